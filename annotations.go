@@ -0,0 +1,124 @@
+package influxdb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// AnnotationMeasurement is the reserved measurement annotations are stored in.
+// Each annotation is a point at its start time with "text" and "end" fields.
+const AnnotationMeasurement = "_annotations"
+
+// Annotation marks an event, such as a deploy, or a window of time, such as
+// an incident, so it can be shown alongside other data.
+type Annotation struct {
+	Start time.Time         `json:"start"`
+	End   time.Time         `json:"end"` // same as Start for events
+	Text  string            `json:"text"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+// annotations represents a list of annotations, sortable by start time.
+type annotations []*Annotation
+
+func (p annotations) Len() int           { return len(p) }
+func (p annotations) Less(i, j int) bool { return p[i].Start.Before(p[j].Start) }
+func (p annotations) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// WriteAnnotation writes an annotation to a database on behalf of a user.
+// The annotation starts now if it has no start time and is an event if it
+// has no end time. Returns the messaging index the annotation was written to.
+func (s *Server) WriteAnnotation(user *User, database, retentionPolicy string, a *Annotation) (uint64, error) {
+	if a.Text == "" {
+		return 0, ErrAnnotationTextRequired
+	}
+	start, end := a.Start, a.End
+	if start.IsZero() {
+		start = time.Now()
+	}
+	if end.IsZero() {
+		end = start
+	} else if end.Before(start) {
+		return 0, ErrAnnotationEndBeforeStart
+	}
+
+	return s.WriteSeriesAs(user, database, retentionPolicy, []Point{{
+		Name:      AnnotationMeasurement,
+		Tags:      a.Tags,
+		Timestamp: start,
+		Fields: map[string]interface{}{
+			"text": a.Text,
+			"end":  end.UTC().Format(time.RFC3339Nano),
+		},
+	}})
+}
+
+// Annotations returns the annotations in a database overlapping the time
+// from start to end and having all of the given tags, ordered by start time.
+// A zero start or end leaves that side unbounded. The annotations are read
+// with the privileges of the user.
+func (s *Server) Annotations(user *User, database, retentionPolicy string, start, end time.Time, tags map[string]string) ([]*Annotation, error) {
+	// The measurement is only created once an annotation is written.
+	s.mu.RLock()
+	db := s.databases[database]
+	exists := db != nil && db.measurements[AnnotationMeasurement] != nil
+	s.mu.RUnlock()
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	} else if !exists {
+		return nil, nil
+	}
+
+	name := []string{AnnotationMeasurement}
+	if retentionPolicy != "" {
+		name = []string{database, retentionPolicy, AnnotationMeasurement}
+	}
+
+	// Annotations starting before the window may still overlap it so only
+	// the end of the window bounds the query.
+	var cond influxql.Expr
+	if !end.IsZero() {
+		cond = &influxql.BinaryExpr{Op: influxql.LTE, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.TimeLiteral{Val: end}}
+	}
+	for k, v := range tags {
+		expr := &influxql.BinaryExpr{Op: influxql.EQ, LHS: &influxql.VarRef{Val: k}, RHS: &influxql.StringLiteral{Val: v}}
+		if cond == nil {
+			cond = expr
+		} else {
+			cond = &influxql.BinaryExpr{Op: influxql.AND, LHS: cond, RHS: expr}
+		}
+	}
+	stmt := &influxql.SelectStatement{
+		Fields:     influxql.Fields{{Expr: &influxql.VarRef{Val: "text"}}, {Expr: &influxql.VarRef{Val: "end"}}},
+		Source:     &influxql.Measurement{Name: influxql.QuoteIdent(name)},
+		Condition:  cond,
+		Dimensions: influxql.Dimensions{{Expr: &influxql.Wildcard{}}},
+	}
+
+	results := s.ExecuteQuery(&influxql.Query{Statements: influxql.Statements{stmt}}, database, user)
+	if err := results.Error(); err != nil {
+		return nil, err
+	}
+
+	var a annotations
+	for _, row := range results.Results[0].Series {
+		for _, v := range row.Values {
+			t, _ := v[0].(time.Time)
+			text, _ := v[1].(string)
+			ann := &Annotation{Start: t, End: t, Text: text, Tags: row.Tags}
+			if s, ok := v[2].(string); ok {
+				if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					ann.End = t
+				}
+			}
+			if !start.IsZero() && ann.End.Before(start) {
+				continue
+			}
+			a = append(a, ann)
+		}
+	}
+	sort.Stable(a)
+	return a, nil
+}
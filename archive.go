@@ -0,0 +1,272 @@
+package influxdb
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// ShardArchiveVersion is the version of the archive format written by ExportShard.
+const ShardArchiveVersion = 1
+
+// archiveBatchSize is the most points of a series held in one archive record.
+const archiveBatchSize = 5000
+
+// ShardArchiveHeader is the first record of a shard archive. It describes the
+// shard the archive was exported from and the type of every field in it.
+//
+// A shard archive is a gzipped stream of JSON objects, one per line. The
+// header is followed by records holding the points of a series:
+//
+//	{"name":"cpu","tags":{"host":"serverA"},"points":[{"timestamp":"2000-01-01T00:00:00Z","fields":{"value":100}}]}
+//
+// Series are identified by their measurement and tags rather than the ids
+// used by the cluster they were exported from, so archives can be imported
+// into any cluster.
+type ShardArchiveHeader struct {
+	Version         int                                     `json:"version"`
+	Database        string                                  `json:"database"`
+	RetentionPolicy string                                  `json:"retentionPolicy"`
+	ShardID         uint64                                  `json:"shardID"`
+	StartTime       time.Time                               `json:"startTime"`
+	EndTime         time.Time                               `json:"endTime"`
+	Fields          map[string]map[string]influxql.DataType `json:"fields"` // field types by measurement
+}
+
+// archiveSeries is a record holding points of a series in a shard archive.
+type archiveSeries struct {
+	Name   string            `json:"name"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Points []archivePoint    `json:"points"`
+}
+
+// archivePoint is a point in a shard archive.
+type archivePoint struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Fields    map[string]interface{} `json:"fields"`
+}
+
+// ExportShard writes an archive of a shard stored on this server to w. Points
+// are read within the backup IO budget.
+func (s *Server) ExportShard(id uint64, w io.Writer) error {
+	s.mu.RLock()
+	sh := s.shards[id]
+	if sh == nil {
+		s.mu.RUnlock()
+		return ErrShardNotFound
+	} else if !sh.HasDataNodeID(s.id) {
+		s.mu.RUnlock()
+		return ErrShardNotLocal
+	}
+	db, rp := s.retentionPolicyByShardID(id)
+	g := s.shardGroupByShardID(id)
+	if db == nil || g == nil {
+		s.mu.RUnlock()
+		return ErrShardNotFound
+	}
+	h := &ShardArchiveHeader{
+		Version:         ShardArchiveVersion,
+		Database:        db.name,
+		RetentionPolicy: rp.Name,
+		ShardID:         id,
+		StartTime:       g.StartTime,
+		EndTime:         g.EndTime,
+		Fields:          make(map[string]map[string]influxql.DataType),
+	}
+	s.mu.RUnlock()
+
+	ids, err := sh.seriesIDs()
+	if err != nil {
+		return err
+	}
+
+	// Look up the series stored in the shard and the types of their fields.
+	type entry struct {
+		id     uint32
+		series *Series
+		codec  *FieldCodec
+	}
+	var entries []entry
+	codecs := make(map[*Measurement]*FieldCodec)
+	s.mu.RLock()
+	for _, id := range ids {
+		series := db.series[id]
+		if series == nil {
+			continue
+		}
+		m := series.measurement
+		if codecs[m] == nil {
+			codecs[m] = NewFieldCodec(m)
+			h.Fields[m.Name] = make(map[string]influxql.DataType, len(m.Fields))
+			for _, f := range m.Fields {
+				if f.Hidden {
+					continue
+				}
+				h.Fields[m.Name][f.Name] = f.Type
+			}
+		}
+		entries = append(entries, entry{id: id, series: series, codec: codecs[m]})
+	}
+	s.mu.RUnlock()
+
+	gw := gzip.NewWriter(w)
+	enc := json.NewEncoder(gw)
+	if err := enc.Encode(h); err != nil {
+		return err
+	}
+
+	// Write the points of each series in batches.
+	for _, e := range entries {
+		var seek []byte
+		for {
+			a, err := sh.readSeriesBatch(e.id, seek, archiveBatchSize)
+			if err != nil {
+				return err
+			} else if len(a) == 0 {
+				break
+			}
+
+			var n int
+			rec := &archiveSeries{Name: e.series.measurement.Name, Tags: e.series.Tags, Points: make([]archivePoint, len(a))}
+			for i, p := range a {
+				rec.Points[i] = archivePoint{Timestamp: time.Unix(0, p.timestamp).UTC(), Fields: e.codec.decodeFieldsByName(p.data)}
+				n += 8 + len(p.data)
+			}
+			s.IO.Wait(IOClassBackup, n)
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+			seek = u64tob(uint64(a[len(a)-1].timestamp) + 1)
+		}
+	}
+	return gw.Close()
+}
+
+// ImportShard writes the points in a shard archive read from r to a database
+// and retention policy. The database and retention policy the shard was
+// exported from are used if they are blank. Returns the number of points
+// written once they have been applied.
+func (s *Server) ImportShard(r io.Reader, database, retentionPolicy string) (int, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("invalid shard archive: %s", err)
+	}
+	dec := json.NewDecoder(gr)
+	dec.UseNumber()
+
+	var h ShardArchiveHeader
+	if err := dec.Decode(&h); err != nil {
+		return 0, fmt.Errorf("invalid shard archive: %s", err)
+	} else if h.Version != ShardArchiveVersion {
+		return 0, fmt.Errorf("unsupported shard archive version: %d", h.Version)
+	}
+	if database == "" {
+		database = h.Database
+	}
+	if retentionPolicy == "" {
+		retentionPolicy = h.RetentionPolicy
+	}
+
+	var n int
+	var index uint64
+	for {
+		var rec archiveSeries
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return n, fmt.Errorf("invalid shard archive: %s", err)
+		}
+
+		points := make([]Point, len(rec.Points))
+		for i, p := range rec.Points {
+			fields, err := decodeArchiveFields(p.Fields, h.Fields[rec.Name])
+			if err != nil {
+				return n, fmt.Errorf("invalid shard archive: %s: %s", rec.Name, err)
+			}
+			points[i] = Point{Name: rec.Name, Tags: rec.Tags, Timestamp: p.Timestamp, Fields: fields}
+		}
+		if len(points) == 0 {
+			continue
+		}
+
+		if index, err = s.WriteSeries(database, retentionPolicy, points); err != nil {
+			return n, err
+		}
+		n += len(points)
+	}
+	return n, s.Sync(index)
+}
+
+// decodeArchiveFields converts field values decoded from JSON to the types
+// recorded in the archive's header.
+func decodeArchiveFields(values map[string]interface{}, types map[string]influxql.DataType) (map[string]interface{}, error) {
+	fields := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		var ok bool
+		switch types[k] {
+		case influxql.Number:
+			var n json.Number
+			if n, ok = v.(json.Number); ok {
+				f, err := n.Float64()
+				fields[k], ok = f, err == nil
+			}
+		case influxql.Unsigned:
+			var n json.Number
+			if n, ok = v.(json.Number); ok {
+				u, err := strconv.ParseUint(string(n), 10, 64)
+				fields[k], ok = u, err == nil
+			}
+		case influxql.Boolean:
+			fields[k], ok = v.(bool)
+		case influxql.String:
+			fields[k], ok = v.(string)
+		case influxql.Histogram:
+			var m map[string]interface{}
+			if m, ok = v.(map[string]interface{}); ok {
+				b, err := influxql.ParseBuckets(archiveBucketCounts(m))
+				fields[k], ok = b, err == nil
+			}
+		default:
+			return nil, fmt.Errorf("unknown field: %s", k)
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid value for %s field %s: %v", types[k], k, v)
+		}
+	}
+	return fields, nil
+}
+
+// archiveBucketCounts converts the counts of a histogram decoded from JSON to
+// the unsigned integers accepted by influxql.ParseBuckets.
+func archiveBucketCounts(m map[string]interface{}) map[string]interface{} {
+	other := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		other[k] = v
+		if n, ok := v.(json.Number); ok {
+			if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+				other[k] = u
+			}
+		}
+	}
+	return other
+}
+
+// seriesIDs returns the ids of the series with points in the shard.
+func (s *Shard) seriesIDs() (ids []uint32, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			// Series buckets are keyed by their 4-byte series id.
+			if len(name) == 4 {
+				ids = append(ids, btou32(name))
+			}
+			return nil
+		})
+	})
+	return
+}
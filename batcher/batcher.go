@@ -0,0 +1,158 @@
+// Package batcher groups points written by the input services into batches
+// so that each service does not need to implement its own buffering.
+package batcher
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+const (
+	// DefaultBatchSize is the number of points buffered before a batch is written.
+	DefaultBatchSize = 1000
+
+	// DefaultBatchPending is the number of full batches queued for writing
+	// before writes to the batcher block.
+	DefaultBatchPending = 5
+
+	// DefaultBatchTimeout is the longest a point is buffered before its batch is written.
+	DefaultBatchTimeout = time.Second
+)
+
+// ErrBatcherClosed is returned when writing points to a closed batcher.
+var ErrBatcherClosed = errors.New("batcher closed")
+
+// SeriesWriter defines the interface for the destination of the data.
+type SeriesWriter interface {
+	WriteSeries(database, retentionPolicy string, points []influxdb.Point) (uint64, error)
+}
+
+// Batcher buffers points per database and retention policy and writes them
+// to the underlying writer when a batch is full or its timeout expires.
+// Batcher implements SeriesWriter so it can be given to any input service.
+type Batcher struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	writer  SeriesWriter
+	batches map[key]*batch
+	queue   chan *batch
+	opened  bool
+
+	Size    int           // points per batch
+	Pending int           // full batches queued before writes block
+	Timeout time.Duration // maximum time a point is buffered
+}
+
+// key identifies the destination of a batch.
+type key struct {
+	database        string
+	retentionPolicy string
+}
+
+// batch is a set of points destined for the same database and retention policy.
+type batch struct {
+	key    key
+	points []influxdb.Point
+	timer  *time.Timer
+}
+
+// NewBatcher returns a new instance of Batcher that writes to w.
+func NewBatcher(w SeriesWriter) *Batcher {
+	return &Batcher{
+		writer:  w,
+		batches: make(map[key]*batch),
+		Size:    DefaultBatchSize,
+		Pending: DefaultBatchPending,
+		Timeout: DefaultBatchTimeout,
+	}
+}
+
+// Open starts writing batches to the underlying writer.
+func (b *Batcher) Open() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.opened {
+		return nil
+	}
+	b.opened = true
+
+	b.queue = make(chan *batch, b.Pending)
+	b.wg.Add(1)
+	go b.process(b.queue)
+	return nil
+}
+
+// Close writes any buffered points and stops the batcher.
+func (b *Batcher) Close() error {
+	b.mu.Lock()
+	if !b.opened {
+		b.mu.Unlock()
+		return nil
+	}
+	b.opened = false
+	for k, bt := range b.batches {
+		bt.timer.Stop()
+		delete(b.batches, k)
+		b.queue <- bt
+	}
+	close(b.queue)
+	b.mu.Unlock()
+
+	// Wait for queued batches to be written.
+	b.wg.Wait()
+	return nil
+}
+
+// WriteSeries buffers points for writing. The returned index is always zero
+// since the points are written asynchronously. Writes block while the
+// number of queued batches is at the pending limit.
+func (b *Batcher) WriteSeries(database, retentionPolicy string, points []influxdb.Point) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.opened {
+		return 0, ErrBatcherClosed
+	}
+
+	k := key{database, retentionPolicy}
+	for _, p := range points {
+		bt := b.batches[k]
+		if bt == nil {
+			bt = &batch{key: k, points: make([]influxdb.Point, 0, b.Size)}
+			bt.timer = time.AfterFunc(b.Timeout, func() { b.timeout(bt) })
+			b.batches[k] = bt
+		}
+
+		bt.points = append(bt.points, p)
+		if len(bt.points) >= b.Size {
+			bt.timer.Stop()
+			delete(b.batches, k)
+			b.queue <- bt
+		}
+	}
+	return 0, nil
+}
+
+// timeout queues a batch once its timeout has expired if it is still buffered.
+func (b *Batcher) timeout(bt *batch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.opened || b.batches[bt.key] != bt {
+		return
+	}
+	delete(b.batches, bt.key)
+	b.queue <- bt
+}
+
+// process writes queued batches to the underlying writer until the queue is closed.
+func (b *Batcher) process(queue chan *batch) {
+	defer b.wg.Done()
+	for bt := range queue {
+		if _, err := b.writer.WriteSeries(bt.key.database, bt.key.retentionPolicy, bt.points); err != nil {
+			log.Printf("batcher: failed to write %d points to %q: %s", len(bt.points), bt.key.database, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package batcher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/batcher"
+)
+
+// Ensure the batcher writes a batch once it reaches the batch size.
+func TestBatcher_WriteSeries_Size(t *testing.T) {
+	w := NewSeriesWriter()
+	b := batcher.NewBatcher(w)
+	b.Size, b.Timeout = 2, time.Hour
+	b.Open()
+	defer b.Close()
+
+	b.WriteSeries("db0", "rp0", []influxdb.Point{{Name: "cpu"}})
+	b.WriteSeries("db1", "rp0", []influxdb.Point{{Name: "cpu"}})
+	b.WriteSeries("db0", "rp0", []influxdb.Point{{Name: "mem"}})
+
+	select {
+	case r := <-w.C:
+		if r.database != "db0" || r.retentionPolicy != "rp0" {
+			t.Fatalf("unexpected destination: %s.%s", r.database, r.retentionPolicy)
+		} else if len(r.points) != 2 || r.points[0].Name != "cpu" || r.points[1].Name != "mem" {
+			t.Fatalf("unexpected points: %#v", r.points)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for batch")
+	}
+}
+
+// Ensure the batcher writes a partial batch once its timeout expires.
+func TestBatcher_WriteSeries_Timeout(t *testing.T) {
+	w := NewSeriesWriter()
+	b := batcher.NewBatcher(w)
+	b.Timeout = 10 * time.Millisecond
+	b.Open()
+	defer b.Close()
+
+	b.WriteSeries("db0", "", []influxdb.Point{{Name: "cpu"}})
+
+	select {
+	case r := <-w.C:
+		if len(r.points) != 1 {
+			t.Fatalf("unexpected point count: %d", len(r.points))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for batch")
+	}
+}
+
+// Ensure closing the batcher writes buffered points and rejects new writes.
+func TestBatcher_Close(t *testing.T) {
+	w := NewSeriesWriter()
+	b := batcher.NewBatcher(w)
+	b.Timeout = time.Hour
+	b.Open()
+
+	b.WriteSeries("db0", "", []influxdb.Point{{Name: "cpu"}, {Name: "mem"}})
+	b.Close()
+
+	if r := <-w.C; len(r.points) != 2 {
+		t.Fatalf("unexpected point count: %d", len(r.points))
+	} else if _, err := b.WriteSeries("db0", "", []influxdb.Point{{Name: "cpu"}}); err != batcher.ErrBatcherClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// SeriesWriter is a test writer that sends each write to a channel.
+type SeriesWriter struct {
+	C chan write
+}
+
+type write struct {
+	database        string
+	retentionPolicy string
+	points          []influxdb.Point
+}
+
+// NewSeriesWriter returns a new instance of SeriesWriter.
+func NewSeriesWriter() *SeriesWriter {
+	return &SeriesWriter{C: make(chan write, 16)}
+}
+
+func (w *SeriesWriter) WriteSeries(database, retentionPolicy string, points []influxdb.Point) (uint64, error) {
+	w.C <- write{database, retentionPolicy, points}
+	return 0, nil
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/influxdb/influxdb/messaging"
+	"github.com/influxdb/influxdb/transport"
 )
 
 // Broker represents an InfluxDB specific messaging broker.
@@ -55,12 +56,17 @@ func (b *Broker) RunContinuousQueryLoop() {
 	go b.continuousQueryLoop(b.done)
 }
 
-// Close closes the broker.
-func (b *Broker) Close() error {
+// StopContinuousQueryLoop stops running continuous queries.
+func (b *Broker) StopContinuousQueryLoop() {
 	if b.done != nil {
 		close(b.done)
 		b.done = nil
 	}
+}
+
+// Close closes the broker.
+func (b *Broker) Close() error {
+	b.StopContinuousQueryLoop()
 	return b.Broker.Close()
 }
 
@@ -112,14 +118,12 @@ func (b *Broker) requestContinuousQueryProcessing() error {
 	cqURL := copyURL(b.currentCQProcessingNode.URL)
 	cqURL.Path = "/process_continuous_queries"
 	cqURL.Scheme = "http"
-	client := &http.Client{
-		Timeout: DefaultDataNodeTimeout,
-	}
+	client := transport.Default.Client(DefaultDataNodeTimeout)
 	resp, err := client.Post(cqURL.String(), "application/octet-stream", nil)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	defer func() { _ = transport.CloseBody(resp) }()
 
 	// Check if created.
 	if resp.StatusCode != http.StatusAccepted {
@@ -0,0 +1,117 @@
+package influxdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// Points in shard stores are prefixed with a CRC-32 of their timestamp and
+// encoded fields so corruption on disk is detected when they are read. Stores
+// created before checksums were added have no checksums until they are
+// compacted. Stores with checksums hold a top-level "checksums" bucket.
+
+const (
+	// ChecksumPolicyFail fails reads of points that don't match their checksum.
+	ChecksumPolicyFail = "fail"
+
+	// ChecksumPolicySkip logs and leaves out points that don't match their checksum.
+	ChecksumPolicySkip = "skip"
+
+	// ChecksumPolicyLog logs and returns points that don't match their checksum.
+	ChecksumPolicyLog = "log"
+)
+
+// checksumSize is the size of a point checksum, in bytes.
+const checksumSize = 4
+
+// checksumsBucket is the name of the bucket marking a store with checksums.
+var checksumsBucket = []byte("checksums")
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// normalizeChecksumPolicy returns the lowercase name of a checksum policy.
+// Returns an error if the policy is unknown.
+func normalizeChecksumPolicy(policy string) (string, error) {
+	switch policy = strings.ToLower(policy); policy {
+	case "", ChecksumPolicyFail, ChecksumPolicySkip, ChecksumPolicyLog:
+		return policy, nil
+	}
+	return "", ErrInvalidChecksumPolicy
+}
+
+// pointChecksum returns the checksum of a point's key and encoded fields.
+func pointChecksum(key, data []byte) uint32 {
+	return crc32.Update(crc32.Checksum(key, checksumTable), checksumTable, data)
+}
+
+// splitChecksum returns the encoded fields of a point stored with a checksum.
+// Returns ErrChecksumMismatch if the point doesn't match its checksum.
+func splitChecksum(key, v []byte) ([]byte, error) {
+	if len(v) < checksumSize {
+		return nil, ErrChecksumMismatch
+	}
+	data := v[checksumSize:]
+	if binary.BigEndian.Uint32(v) != pointChecksum(key, data) {
+		return data, ErrChecksumMismatch
+	}
+	return data, nil
+}
+
+// pointCodec converts points between their encoded fields and the values
+// stored in a shard store.
+type pointCodec struct {
+	shardID   uint64
+	checksums bool   // set if the store holds checksums
+	policy    string // checksum policy, ChecksumPolicyFail if blank
+}
+
+// pointCodec returns the codec for the points in tx's store.
+func (s *Shard) pointCodec(tx *bolt.Tx) *pointCodec {
+	return &pointCodec{
+		shardID:   s.ID,
+		checksums: tx.Bucket(checksumsBucket) != nil,
+		policy:    s.checksumPolicy,
+	}
+}
+
+// encode returns the value stored for a point.
+func (c *pointCodec) encode(key, data []byte) []byte {
+	if !c.checksums {
+		return data
+	}
+	v := make([]byte, checksumSize+len(data))
+	binary.BigEndian.PutUint32(v, pointChecksum(key, data))
+	copy(v[checksumSize:], data)
+	return v
+}
+
+// decode returns the encoded fields of a stored point. Points that don't
+// match their checksum are handled according to the checksum policy: ok is
+// false if the point should be left out and an error is returned if the read
+// should fail.
+func (c *pointCodec) decode(seriesID uint32, key, v []byte) (data []byte, ok bool, err error) {
+	if !c.checksums {
+		return v, true, nil
+	}
+	data, err = splitChecksum(key, v)
+	if err == nil {
+		return data, true, nil
+	}
+
+	err = fmt.Errorf("shard %d: series %d: point %d: %s", c.shardID, seriesID, int64(btou64(key)), err)
+	switch c.policy {
+	case ChecksumPolicySkip:
+		log.Printf("%s, skipped", err)
+		return nil, false, nil
+	case ChecksumPolicyLog:
+		log.Print(err)
+		return data, data != nil, nil
+	default:
+		return nil, false, err
+	}
+}
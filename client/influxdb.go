@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/influxdb/influxdb/influxql"
@@ -258,90 +260,219 @@ func (t Timestamp) MarshalJSON() ([]byte, error) {
 	return []byte(`"` + s + `"`), nil
 }
 
-// Point defines the fields that will be written to the database
+// Point defines the fields that will be written to the database.
+//
+// JSON numbers are written as floats unless the field is declared unsigned or
+// duration in Types. Unsigned values are sent as JSON integers or strings of
+// digits so that counters above 2^53 keep their precision. Durations are sent
+// as integer nanosecond counts or as strings such as "1.5s".
 type Point struct {
-	Name      string                 `json:"name"`
-	Tags      map[string]string      `json:"tags"`
-	Timestamp Timestamp              `json:"timestamp"`
-	Fields    map[string]interface{} `json:"fields"`
-	Precision string                 `json:"precision"`
+	Name      string                       `json:"name"`
+	Tags      map[string]string            `json:"tags"`
+	Timestamp Timestamp                    `json:"timestamp"`
+	Fields    map[string]interface{}       `json:"fields"`
+	Types     map[string]influxql.DataType `json:"types,omitempty"`
+	Precision string                       `json:"precision"`
 }
 
-// UnmarshalJSON decodes the data into the Point struct
-func (p *Point) UnmarshalJSON(b []byte) error {
-	var normal struct {
-		Name      string                 `json:"name"`
-		Tags      map[string]string      `json:"tags"`
-		Timestamp time.Time              `json:"timestamp"`
-		Precision string                 `json:"precision"`
-		Fields    map[string]interface{} `json:"fields"`
-	}
-	var epoch struct {
-		Name      string                 `json:"name"`
-		Tags      map[string]string      `json:"tags"`
-		Timestamp *int64                 `json:"timestamp"`
-		Precision string                 `json:"precision"`
-		Fields    map[string]interface{} `json:"fields"`
-	}
-
-	if err := func() error {
-		var err error
-		dec := json.NewDecoder(bytes.NewBuffer(b))
-		dec.UseNumber()
-		if err = dec.Decode(&epoch); err != nil {
-			return err
+// MarshalJSON encodes the point, declaring uint64 fields as unsigned and
+// time.Duration fields as durations.
+func (p Point) MarshalJSON() ([]byte, error) {
+	type point Point
+	other := point(p)
+	for k, v := range p.Fields {
+		var typ influxql.DataType
+		switch v.(type) {
+		case uint64:
+			typ = influxql.Unsigned
+		case time.Duration:
+			typ = influxql.Duration
 		}
-		// Convert from epoch to time.Time, but only if Timestamp
-		// was actually set.
-		var ts time.Time
-		if epoch.Timestamp != nil {
-			ts, err = EpochToTime(*epoch.Timestamp, epoch.Precision)
-			if err != nil {
-				return err
+		if typ == "" || other.Types[k] != "" {
+			continue
+		}
+		if len(other.Types) == len(p.Types) {
+			other.Types = make(map[string]influxql.DataType, len(p.Types)+1)
+			for k, typ := range p.Types {
+				other.Types[k] = typ
 			}
 		}
-		p.Name = epoch.Name
-		p.Tags = epoch.Tags
-		p.Timestamp = Timestamp(ts)
-		p.Precision = epoch.Precision
-		p.Fields = normalizeFields(epoch.Fields)
-		return nil
-	}(); err == nil {
-		return nil
+		other.Types[k] = typ
+	}
+	return json.Marshal(other)
+}
+
+// UnmarshalJSON decodes the data into the Point struct
+func (p *Point) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Name      string                       `json:"name"`
+		Tags      map[string]string            `json:"tags"`
+		Timestamp json.RawMessage              `json:"timestamp"`
+		Precision string                       `json:"precision"`
+		Fields    map[string]interface{}       `json:"fields"`
+		Types     map[string]influxql.DataType `json:"types"`
 	}
 
 	dec := json.NewDecoder(bytes.NewBuffer(b))
 	dec.UseNumber()
-	if err := dec.Decode(&normal); err != nil {
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	ts, err := ParseTimestamp(v.Timestamp, v.Precision)
+	if err != nil {
+		return err
+	}
+	p.Name = v.Name
+	p.Tags = v.Tags
+	p.Timestamp = Timestamp(ts)
+	p.Precision = v.Precision
+	p.Types = v.Types
+	if p.Fields, err = normalizeFields(v.Fields, v.Types); err != nil {
 		return err
 	}
-	normal.Timestamp = SetPrecision(normal.Timestamp, normal.Precision)
-	p.Name = normal.Name
-	p.Tags = normal.Tags
-	p.Timestamp = Timestamp(normal.Timestamp)
-	p.Precision = normal.Precision
-	p.Fields = normalizeFields(normal.Fields)
 
 	return nil
 }
 
-// Remove any notion of json.Number
-func normalizeFields(fields map[string]interface{}) map[string]interface{} {
+// ErrLossyTimestamp is returned when an epoch timestamp is sent as a JSON
+// number that can't be represented exactly, such as 1.4340555620000001e+18.
+var ErrLossyTimestamp = errors.New("timestamp loses precision as a JSON number, send it as an integer or a string")
+
+// AllowLossyTimestamps causes epoch timestamps that can't be represented
+// exactly to be rounded to the nearest integer instead of rejected.
+var AllowLossyTimestamps = false
+
+// ParseTimestamp decodes a JSON timestamp. Timestamps can be RFC3339 strings,
+// which are rounded to the precision, or epochs in the precision sent either
+// as JSON integers or as strings of digits. Returns the zero time if raw is
+// empty or null.
+//
+// JSON numbers are only exact up to 2^53 when decoded as floats so nanosecond
+// epochs written in float notation may have been rounded by the sender. Such
+// timestamps return ErrLossyTimestamp unless AllowLossyTimestamps is set.
+func ParseTimestamp(raw json.RawMessage, precision string) (time.Time, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}, nil
+	}
+
+	// Strings are either RFC3339 times or string-encoded epochs.
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return time.Time{}, err
+		}
+		if epoch, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return EpochToTime(epoch, precision)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return SetPrecision(t, precision), nil
+	}
+
+	// Integers are decoded exactly.
+	n := json.Number(raw)
+	if epoch, err := n.Int64(); err == nil {
+		return EpochToTime(epoch, precision)
+	}
+
+	// Numbers in float notation are only accepted if they are exact.
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp: %s", raw)
+	} else if f == math.Trunc(f) && math.Abs(f) <= maxExactFloat64 {
+		return EpochToTime(int64(f), precision)
+	} else if !AllowLossyTimestamps {
+		return time.Time{}, fmt.Errorf("%s: %s", ErrLossyTimestamp, raw)
+	} else if math.Abs(f) >= math.MaxInt64 {
+		return time.Time{}, fmt.Errorf("timestamp out of range: %s", raw)
+	}
+	return EpochToTime(int64(math.Floor(f+0.5)), precision)
+}
+
+// maxExactFloat64 is the largest integer that a float64 can represent exactly.
+const maxExactFloat64 = 1 << 53
+
+// Remove any notion of json.Number. Numbers are decoded as floats unless types
+// declares the field unsigned, in which case the value must be a non-negative
+// integer sent as a JSON number or a string of digits, or duration, in which
+// case the value is decoded by parseDuration. Only number, unsigned and
+// duration fields can be declared.
+func normalizeFields(fields map[string]interface{}, types map[string]influxql.DataType) (map[string]interface{}, error) {
 	newFields := map[string]interface{}{}
 
 	for k, v := range fields {
+		switch types[k] {
+		case "":
+		case influxql.Number:
+			if _, ok := v.(json.Number); !ok {
+				return nil, fmt.Errorf("field \"%s\": invalid number: %v", k, v)
+			}
+		case influxql.Unsigned:
+			var s string
+			switch v := v.(type) {
+			case json.Number:
+				s = string(v)
+			case string:
+				s = v
+			}
+			u, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("field \"%s\": invalid unsigned integer: %v", k, v)
+			}
+			newFields[k] = u
+			continue
+		case influxql.Duration:
+			d, err := parseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("field \"%s\": invalid duration: %v", k, v)
+			}
+			newFields[k] = d
+			continue
+		default:
+			return nil, fmt.Errorf("field \"%s\": unsupported type: %s", k, types[k])
+		}
+
 		switch v := v.(type) {
 		case json.Number:
+			// Numbers too large for a float64 are kept as ±Inf.
 			jv, e := v.Float64()
-			if e != nil {
+			if e != nil && !math.IsInf(jv, 0) {
 				panic(fmt.Sprintf("unable to convert json.Number to float64: %s", e))
 			}
 			newFields[k] = jv
+		case map[string]interface{}:
+			// Objects hold histogram buckets.
+			nv, err := normalizeFields(v, nil)
+			if err != nil {
+				return nil, err
+			}
+			newFields[k] = nv
 		default:
 			newFields[k] = v
 		}
 	}
-	return newFields
+	return newFields, nil
+}
+
+// parseDuration returns a duration field value sent as an integer nanosecond
+// count, either as a JSON number or a string of digits, or as a string such as
+// "1.5s".
+func parseDuration(v interface{}) (time.Duration, error) {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = string(v)
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d, nil
+		}
+		s = v
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return time.Duration(n), err
 }
 
 // utility functions
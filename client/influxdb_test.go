@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -303,6 +304,91 @@ func TestPoint_UnmarshalRFC(t *testing.T) {
 	}
 }
 
+func TestPoint_UnmarshalUnsigned(t *testing.T) {
+	data := []byte(`{"fields": {"small": 9007199254740992, "large": 18446744073709551000, "str": "18446744073709551615", "float": 1.5}, "types": {"small": "unsigned", "large": "unsigned", "str": "unsigned"}}`)
+	var p client.Point
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v, ok := p.Fields["small"].(uint64); !ok || v != 9007199254740992 {
+		t.Fatalf("unexpected small value: %#v", p.Fields["small"])
+	}
+	if v, ok := p.Fields["large"].(uint64); !ok || v != 18446744073709551000 {
+		t.Fatalf("unexpected large value: %#v", p.Fields["large"])
+	}
+	if v, ok := p.Fields["str"].(uint64); !ok || v != 18446744073709551615 {
+		t.Fatalf("unexpected string value: %#v", p.Fields["str"])
+	}
+	if v, ok := p.Fields["float"].(float64); !ok || v != 1.5 {
+		t.Fatalf("unexpected float value: %#v", p.Fields["float"])
+	}
+
+	// Undeclared integers are numbers regardless of their size.
+	if err := json.Unmarshal([]byte(`{"fields": {"large": 18446744073709551000}}`), &p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if _, ok := p.Fields["large"].(float64); !ok {
+		t.Fatalf("unexpected large value: %#v", p.Fields["large"])
+	}
+
+	for _, s := range []string{
+		`{"fields": {"value": -1}, "types": {"value": "unsigned"}}`,
+		`{"fields": {"value": 1.5}, "types": {"value": "unsigned"}}`,
+		`{"fields": {"value": "a"}, "types": {"value": "number"}}`,
+		`{"fields": {"value": 1}, "types": {"value": "histogram"}}`,
+	} {
+		if err := json.Unmarshal([]byte(s), &p); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestPoint_UnmarshalDuration(t *testing.T) {
+	data := []byte(`{"fields": {"num": 1500000000, "neg": -5, "digits": "42", "str": "1.5s", "float": 1.5}, "types": {"num": "duration", "neg": "duration", "digits": "duration", "str": "duration"}}`)
+	var p client.Point
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for k, d := range map[string]time.Duration{"num": 1500 * time.Millisecond, "neg": -5, "digits": 42, "str": 1500 * time.Millisecond} {
+		if v, ok := p.Fields[k].(time.Duration); !ok || v != d {
+			t.Fatalf("unexpected %s value: %#v", k, p.Fields[k])
+		}
+	}
+	if v, ok := p.Fields["float"].(float64); !ok || v != 1.5 {
+		t.Fatalf("unexpected float value: %#v", p.Fields["float"])
+	}
+
+	for _, s := range []string{
+		`{"fields": {"value": 1.5}, "types": {"value": "duration"}}`,
+		`{"fields": {"value": "soon"}, "types": {"value": "duration"}}`,
+		`{"fields": {"value": true}, "types": {"value": "duration"}}`,
+	} {
+		if err := json.Unmarshal([]byte(s), &p); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestPoint_MarshalUnsigned(t *testing.T) {
+	p := client.Point{Name: "net", Fields: map[string]interface{}{"bytes": uint64(18446744073709551000), "load": float64(1), "rtt": 3 * time.Millisecond}}
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if p.Types != nil {
+		t.Fatalf("point modified: %#v", p.Types)
+	}
+
+	var other client.Point
+	if err := json.Unmarshal(b, &other); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if v, ok := other.Fields["bytes"].(uint64); !ok || v != 18446744073709551000 {
+		t.Fatalf("unexpected bytes value: %#v", other.Fields["bytes"])
+	} else if v, ok := other.Fields["load"].(float64); !ok || v != 1 {
+		t.Fatalf("unexpected load value: %#v", other.Fields["load"])
+	} else if v, ok := other.Fields["rtt"].(time.Duration); !ok || v != 3*time.Millisecond {
+		t.Fatalf("unexpected rtt value: %#v", other.Fields["rtt"])
+	}
+}
+
 func TestEpochToTime(t *testing.T) {
 	now := time.Now()
 
@@ -340,3 +426,37 @@ func emptyTestServer() *httptest.Server {
 		return
 	}))
 }
+
+// Ensure that epoch timestamps can be sent as strings to avoid float rounding.
+func TestPoint_UnmarshalStringEpoch(t *testing.T) {
+	var p client.Point
+	if err := json.Unmarshal([]byte(`{"timestamp": "1434055562000000001", "precision": "n"}`), &p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if ns := p.Timestamp.Time().UnixNano(); ns != 1434055562000000001 {
+		t.Fatalf("unexpected timestamp: %d", ns)
+	}
+}
+
+// Ensure that epoch timestamps in float notation are rejected if they are inexact.
+func TestPoint_UnmarshalLossyTimestamp(t *testing.T) {
+	var p client.Point
+	if err := json.Unmarshal([]byte(`{"timestamp": 1.4340555620000001e18, "precision": "n"}`), &p); err == nil || !strings.Contains(err.Error(), client.ErrLossyTimestamp.Error()) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Exact floats are accepted.
+	if err := json.Unmarshal([]byte(`{"timestamp": 1.434055562e9, "precision": "s"}`), &p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s := p.Timestamp.Time().Unix(); s != 1434055562 {
+		t.Fatalf("unexpected timestamp: %d", s)
+	}
+
+	// Lossy floats are rounded if allowed.
+	client.AllowLossyTimestamps = true
+	defer func() { client.AllowLossyTimestamps = false }()
+	if err := json.Unmarshal([]byte(`{"timestamp": 1.4340555620000001e18, "precision": "n"}`), &p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if ns := p.Timestamp.Time().UnixNano(); ns != 1434055562000000000 {
+		t.Fatalf("unexpected timestamp: %d", ns)
+	}
+}
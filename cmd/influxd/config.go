@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding"
 	"fmt"
 	"log"
 	"net"
@@ -8,13 +9,20 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/batcher"
 	"github.com/influxdb/influxdb/collectd"
 	"github.com/influxdb/influxdb/graphite"
+	"github.com/influxdb/influxdb/httpd"
+	"github.com/influxdb/influxdb/messaging"
+	"github.com/influxdb/influxdb/transport"
 )
 
 const (
@@ -31,6 +39,18 @@ const (
 	// DefaultAPIReadTimeout represents the duration before an API request times out.
 	DefaultAPIReadTimeout = 5 * time.Second
 
+	// DefaultAPIReadHeaderTimeout represents the duration allowed to read the
+	// headers of an API request.
+	DefaultAPIReadHeaderTimeout = 10 * time.Second
+
+	// DefaultAPIIdleTimeout represents the duration an idle keep-alive
+	// connection is kept open.
+	DefaultAPIIdleTimeout = 2 * time.Minute
+
+	// DefaultAPIMaxHeaderSize represents the maximum size of the headers of
+	// an API request.
+	DefaultAPIMaxHeaderSize = 1 << 20 // 1MB
+
 	// DefaultBrokerPort represents the default port the broker runs on.
 	DefaultBrokerPort = 8086
 
@@ -55,6 +75,10 @@ type Config struct {
 
 	Authentication struct {
 		Enabled bool `toml:"enabled"`
+
+		// Route patterns served without authentication. The handler's
+		// defaults are used if not set.
+		ExemptRoutes []string `toml:"exempt-routes"`
 	} `toml:"authentication"`
 
 	Admin struct {
@@ -67,21 +91,58 @@ type Config struct {
 		SSLPort     int      `toml:"ssl-port"`
 		SSLCertPath string   `toml:"ssl-cert"`
 		ReadTimeout Duration `toml:"read-timeout"`
+
+		// Timeouts and limits that stop slow or stalled clients from holding
+		// connections open. Zero disables a timeout or limit.
+		ReadHeaderTimeout Duration `toml:"read-header-timeout"`
+		WriteTimeout      Duration `toml:"write-timeout"`
+		IdleTimeout       Duration `toml:"idle-timeout"`
+		MaxHeaderSize     Size     `toml:"max-header-size"`
+		MaxConnections    int      `toml:"max-connections"`
+
+		// Serve runtime profiling data under /debug/pprof to admin users.
+		PprofEnabled bool `toml:"pprof-enabled"`
+
+		// Round epoch timestamps written as inexact JSON numbers instead of
+		// rejecting the write.
+		AllowLossyTimestamps bool `toml:"allow-lossy-timestamps"`
+
+		// Lifetime of admin UI sessions started through /login.
+		SessionTimeout Duration `toml:"session-timeout"`
+
+		// Tag set to the authenticated user's name on every point written
+		// through the API. Disabled if blank.
+		UserTag string `toml:"user-tag"`
+
+		// Accept tags for every point of a write in the X-Influxdb-Tags header.
+		TagsHeaderEnabled bool `toml:"tags-header-enabled"`
+
+		// Longest a query waits for the index set by its min_index parameter.
+		MaxIndexWait Duration `toml:"max-index-wait"`
 	} `toml:"api"`
 
 	Graphites []Graphite `toml:"graphite"`
+	Deadmans  []Deadman  `toml:"deadman"`
+	Quotas    []Quota    `toml:"quota"`
+	UDFs      []UDF      `toml:"udf"`
+	Rollups   []Rollup   `toml:"rollup"`
 	Collectd  Collectd   `toml:"collectd"`
 
 	UDP struct {
 		Enabled     bool   `toml:"enabled"`
 		BindAddress string `toml:"bind-address"`
 		Port        int    `toml:"port"`
+
+		Batch Batch `toml:"batch"`
 	} `toml:"udp"`
 
 	Broker struct {
-		Port    int      `toml:"port"`
-		Dir     string   `toml:"dir"`
-		Timeout Duration `toml:"election-timeout"`
+		Port               int      `toml:"port"`
+		Dir                string   `toml:"dir"`
+		Timeout            Duration `toml:"election-timeout"`
+		MaxSegmentSize     Size     `toml:"max-segment-size"`
+		TruncationInterval Duration `toml:"truncation-interval"`
+		TruncationWindow   uint64   `toml:"truncation-window"`
 	} `toml:"broker"`
 
 	Data struct {
@@ -89,15 +150,101 @@ type Config struct {
 		Port                  int      `toml:"port"`
 		RetentionCheckEnabled bool     `toml:"retention-check-enabled"`
 		RetentionCheckPeriod  Duration `toml:"retention-check-period"`
+		ReadOnly              bool     `toml:"read-only"`
+		BackfillThreshold     Duration `toml:"backfill-threshold"`
+		ChecksumPolicy        string   `toml:"checksum-policy"`
+		MaxFutureTime         Duration `toml:"max-future-time"`
+		MigrationBackup       bool     `toml:"migration-backup"`
+		HeartbeatInterval     Duration `toml:"heartbeat-interval"` // disabled if zero
+
+		// Background compaction of shards whose shard groups have ended.
+		CompactionEnabled     bool     `toml:"compaction-enabled"`
+		CompactionCheckPeriod Duration `toml:"compaction-check-period"`
+		CompactionConcurrency int      `toml:"compaction-concurrency"`
+		CompactionThroughput  Size     `toml:"compaction-throughput"` // bytes per second, unlimited if zero
+
+		// Budgets of other background IO and of all background IO together,
+		// in bytes per second. Unlimited if zero.
+		RetentionThroughput Size `toml:"retention-throughput"`
+		BackupThroughput    Size `toml:"backup-throughput"`
+		IOThroughput        Size `toml:"io-throughput"`
 	} `toml:"data"`
 
+	ColdStorage struct {
+		// Move shards to an object store once their shard group ended more
+		// than offload-age ago. They are fetched back when a query needs
+		// them and removed again once unused for cache-ttl.
+		Enabled       bool     `toml:"enabled"`
+		OffloadAge    Duration `toml:"offload-age"`
+		CheckInterval Duration `toml:"check-interval"`
+		CacheTTL      Duration `toml:"cache-ttl"`
+
+		// Directory to store shards in, such as a network mount. An
+		// S3-compatible bucket is used if not set.
+		Dir string `toml:"dir"`
+
+		Endpoint        string `toml:"endpoint"`
+		Region          string `toml:"region"`
+		Bucket          string `toml:"bucket"`
+		Prefix          string `toml:"prefix"`
+		AccessKeyID     string `toml:"access-key-id"`
+		SecretAccessKey string `toml:"secret-access-key"`
+	} `toml:"cold-storage"`
+
 	Cluster struct {
 		Dir string `toml:"dir"`
+
+		// Pooled connections used between brokers and data nodes.
+		MaxConnsPerPeer     int      `toml:"max-conns-per-peer"` // unlimited if zero
+		MaxIdleConnsPerPeer int      `toml:"max-idle-conns-per-peer"`
+		IdleConnTimeout     Duration `toml:"idle-conn-timeout"`
 	} `toml:"cluster"`
 
+	Query struct {
+		// Maximum amount of memory a single query can buffer. Unlimited if zero.
+		MaxMemory Size `toml:"max-memory"`
+
+		// Maximum amount of memory all running queries can buffer together. Unlimited if zero.
+		MaxTotalMemory Size `toml:"max-total-memory"`
+
+		// Write buffered rows to disk when a query exceeds a memory limit so
+		// it completes slowly instead of failing. Uses the system temporary
+		// directory if no directory is set.
+		SpillToDisk bool   `toml:"spill-to-disk"`
+		SpillDir    string `toml:"spill-dir"`
+
+		// Maximum number of groups a GROUP BY query can return. Queries over
+		// the limit fail, or return the first groups if truncation is enabled.
+		// Unlimited if zero.
+		MaxGroups      int  `toml:"max-groups"`
+		TruncateGroups bool `toml:"truncate-groups"`
+
+		// Maximum time a query can run for. Requests may ask for a shorter
+		// timeout but not a longer one. Unlimited if zero.
+		MaxTimeout Duration `toml:"max-timeout"`
+	} `toml:"query"`
+
+	Monitoring struct {
+		// Periodically write the server's own statistics to the _internal database.
+		Enabled       bool     `toml:"enabled"`
+		WriteInterval Duration `toml:"write-interval"`
+
+		// Break the write statistics of each database down by measurement.
+		WriteStatsByMeasurement bool `toml:"write-stats-by-measurement"`
+	} `toml:"monitoring"`
+
 	Logging struct {
 		File              string `toml:"file"`
 		WriteTraceEnabled bool   `toml:"write-tracing"`
+
+		// Trace only every Nth write when write tracing is enabled.
+		WriteTraceSampleN int `toml:"write-tracing-sample-n"`
+
+		// Always trace writes to these databases.
+		WriteTraceDatabases []string `toml:"write-tracing-databases"`
+
+		// Most bytes of a traced write's body that are logged. Unlimited if zero.
+		WriteTraceMaxBytes Size `toml:"write-tracing-max-bytes"`
 	} `toml:"logging"`
 
 	ContinuousQuery struct {
@@ -141,10 +288,37 @@ func NewConfig() *Config {
 	c.Broker.Dir = filepath.Join(u.HomeDir, ".influxdb/broker")
 	c.Broker.Port = DefaultBrokerPort
 	c.Broker.Timeout = Duration(1 * time.Second)
+	c.Broker.MaxSegmentSize = Size(messaging.DefaultMaxSegmentSize)
+	c.Broker.TruncationInterval = Duration(messaging.DefaultTruncationInterval)
+	c.Broker.TruncationWindow = messaging.DefaultTruncationWindow
 	c.Data.Dir = filepath.Join(u.HomeDir, ".influxdb/data")
 	c.Data.Port = DefaultDataPort
 	c.Data.RetentionCheckEnabled = true
 	c.Data.RetentionCheckPeriod = Duration(10 * time.Minute)
+	c.Data.BackfillThreshold = Duration(influxdb.DefaultBackfillThreshold)
+	c.Data.ChecksumPolicy = influxdb.ChecksumPolicyFail
+	c.Data.MigrationBackup = true
+	c.Data.HeartbeatInterval = Duration(influxdb.DefaultHeartbeatInterval)
+	c.Data.CompactionEnabled = true
+	c.Data.CompactionCheckPeriod = Duration(influxdb.DefaultCompactionCheckInterval)
+	c.Data.CompactionConcurrency = influxdb.DefaultCompactionConcurrency
+	c.Data.CompactionThroughput = Size(influxdb.DefaultCompactionThroughput)
+	c.Data.RetentionThroughput = Size(influxdb.DefaultRetentionThroughput)
+	c.Data.BackupThroughput = Size(influxdb.DefaultBackupThroughput)
+	c.ColdStorage.OffloadAge = Duration(influxdb.DefaultOffloadAge)
+	c.ColdStorage.CheckInterval = Duration(influxdb.DefaultOffloadCheckInterval)
+	c.ColdStorage.CacheTTL = Duration(influxdb.DefaultColdCacheTTL)
+	c.ColdStorage.Endpoint = "https://s3.amazonaws.com"
+	c.ColdStorage.Region = "us-east-1"
+	c.Query.SpillToDisk = true
+	c.Cluster.MaxConnsPerPeer = transport.DefaultMaxConnsPerPeer
+	c.Cluster.MaxIdleConnsPerPeer = transport.DefaultMaxIdleConnsPerPeer
+	c.Cluster.IdleConnTimeout = Duration(transport.DefaultIdleConnTimeout)
+	c.Monitoring.WriteInterval = Duration(1 * time.Minute)
+	c.HTTPAPI.ReadHeaderTimeout = Duration(DefaultAPIReadHeaderTimeout)
+	c.HTTPAPI.IdleTimeout = Duration(DefaultAPIIdleTimeout)
+	c.HTTPAPI.MaxHeaderSize = Size(DefaultAPIMaxHeaderSize)
+	c.Logging.WriteTraceMaxBytes = Size(httpd.DefaultWriteTraceMaxBytes)
 	c.Admin.Enabled = true
 	c.Admin.Port = 8083
 	c.ContinuousQuery.RecomputePreviousN = 2
@@ -218,6 +392,15 @@ func (c *Config) DataDir() string {
 	return p
 }
 
+// QuerySpillDir returns the directory queries spill buffered rows to.
+// Defaults to the system temporary directory.
+func (c *Config) QuerySpillDir() string {
+	if c.Query.SpillDir == "" {
+		return os.TempDir()
+	}
+	return c.Query.SpillDir
+}
+
 func (c *Config) JoinURLs() string {
 	if c.Initialization.JoinURLs == "" {
 		return DefaultJoinURLs
@@ -227,7 +410,7 @@ func (c *Config) JoinURLs() string {
 }
 
 // Size represents a TOML parseable file size.
-// Users can specify size using "m" for megabytes and "g" for gigabytes.
+// Users can specify size using "k" for kilobytes, "m" for megabytes and "g" for gigabytes.
 type Size int
 
 // UnmarshalText parses a byte size from text.
@@ -241,6 +424,8 @@ func (s *Size) UnmarshalText(text []byte) error {
 
 	// Parse unit of measure ("m", "g", etc).
 	switch suffix := text[len(text)-1]; suffix {
+	case 'k':
+		size *= 1 << 10 // KB
 	case 'm':
 		size *= 1 << 20 // MB
 	case 'g':
@@ -258,9 +443,37 @@ func (s *Size) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// String returns the size in the largest unit that represents it exactly,
+// rounding up to kilobytes.
+func (s Size) String() string {
+	switch {
+	case s == 0:
+		return "0m"
+	case s%(1<<30) == 0:
+		return fmt.Sprintf("%dg", s>>30)
+	case s%(1<<20) == 0:
+		return fmt.Sprintf("%dm", s>>20)
+	default:
+		return fmt.Sprintf("%dk", (s+1<<10-1)>>10)
+	}
+}
+
 // Duration is a TOML wrapper type for time.Duration.
 type Duration time.Duration
 
+// String returns the duration formatted without trailing zero units,
+// such as "10m" or "1h30m".
+func (d Duration) String() string {
+	s := time.Duration(d).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
 // UnmarshalText parses a TOML value into a duration value.
 func (d *Duration) UnmarshalText(text []byte) error {
 	// Ignore if there is no value set.
@@ -297,6 +510,114 @@ func ParseConfig(s string) (*Config, error) {
 	return c, nil
 }
 
+// EnvPrefix is the prefix of environment variables overriding config settings.
+const EnvPrefix = "INFLUXDB"
+
+// ApplyEnvOverrides sets settings from environment variables named
+// INFLUXDB_<SECTION>_<KEY>, such as INFLUXDB_DATA_PORT, where the section and
+// key are upper-cased and dashes are replaced by underscores. Subsections
+// and repeated sections add their name or index, such as
+// INFLUXDB_UDP_BATCH_SIZE or INFLUXDB_GRAPHITE_0_PORT. Lists are comma
+// separated. Returns an error naming the variable if a value is invalid.
+func (c *Config) ApplyEnvOverrides() error {
+	return applyEnvOverrides(EnvPrefix, reflect.ValueOf(c).Elem())
+}
+
+// applyEnvOverrides sets the fields of a struct from the environment
+// variables named prefix followed by their TOML keys.
+func applyEnvOverrides(prefix string, rv reflect.Value) error {
+	for i := 0; i < rv.NumField(); i++ {
+		key := strings.Split(rv.Type().Field(i).Tag.Get("toml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+		fv := rv.Field(i)
+
+		// Descend into sections and repeated sections.
+		if _, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); !ok && fv.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(name, fv); err != nil {
+				return err
+			}
+			continue
+		} else if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Struct {
+			for _, e := range os.Environ() {
+				if !strings.HasPrefix(e, name+"_") {
+					continue
+				}
+				s := strings.SplitN(e[len(name)+1:], "_", 2)[0]
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 {
+					continue
+				}
+				if n >= fv.Len() {
+					fv.Set(reflect.AppendSlice(fv, reflect.MakeSlice(fv.Type(), n+1-fv.Len(), n+1-fv.Len())))
+				}
+			}
+			for j := 0; j < fv.Len(); j++ {
+				if err := applyEnvOverrides(name+"_"+strconv.Itoa(j), fv.Index(j)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Variables that are set but empty still override the setting.
+		value, ok := syscall.Getenv(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(fv, value); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// setEnvValue sets a setting from the value of an environment variable.
+func setEnvValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetUint(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		var a []string
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				a = append(a, e)
+			}
+		}
+		v.Set(reflect.ValueOf(a))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
 type Collectd struct {
 	Addr string `toml:"address"`
 	Port uint16 `toml:"port"`
@@ -304,6 +625,8 @@ type Collectd struct {
 	Database string `toml:"database"`
 	Enabled  bool   `toml:"enabled"`
 	TypesDB  string `toml:"typesdb"`
+
+	Batch Batch `toml:"batch"`
 }
 
 // ConnnectionString returns the connection string for this collectd config in the form host:port.
@@ -323,6 +646,66 @@ func (c *Collectd) ConnectionString(defaultBindAddr string) string {
 	return fmt.Sprintf("%s:%d", addr, port)
 }
 
+// Batch represents the batching configuration shared by the input services.
+type Batch struct {
+	Size    int      `toml:"size"`
+	Pending int      `toml:"pending"`
+	Timeout Duration `toml:"timeout"`
+}
+
+// NewBatcher returns a batcher writing to w, using the defaults for any unset values.
+func (b *Batch) NewBatcher(w batcher.SeriesWriter) *batcher.Batcher {
+	bt := batcher.NewBatcher(w)
+	if b.Size > 0 {
+		bt.Size = b.Size
+	}
+	if b.Pending > 0 {
+		bt.Pending = b.Pending
+	}
+	if b.Timeout > 0 {
+		bt.Timeout = time.Duration(b.Timeout)
+	}
+	return bt
+}
+
+// Quota represents the resource limits for a single database on this node.
+type Quota struct {
+	Database             string `toml:"database"`
+	MaxSeries            int    `toml:"max-series"`
+	MaxWriteRate         int    `toml:"max-write-rate"`
+	MaxConcurrentQueries int    `toml:"max-concurrent-queries"`
+	MaxDiskSize          Size   `toml:"max-disk-size"`
+}
+
+// UDF represents an external process pipeline queries can pass points to.
+type UDF struct {
+	Name    string   `toml:"name"`
+	Command string   `toml:"command"`
+	Args    []string `toml:"args"`
+	Timeout Duration `toml:"timeout"`
+}
+
+// Rollup represents a downsampled copy of a measurement that queries grouping
+// by a multiple of its interval read from instead.
+type Rollup struct {
+	Database              string   `toml:"database"`
+	RetentionPolicy       string   `toml:"retention-policy"`
+	Measurement           string   `toml:"measurement"`
+	Interval              Duration `toml:"interval"`
+	TargetRetentionPolicy string   `toml:"target-retention-policy"`
+	TargetMeasurement     string   `toml:"target-measurement"`
+	Functions             []string `toml:"functions"`
+}
+
+// Deadman represents a check for series that have stopped receiving points.
+type Deadman struct {
+	Database      string   `toml:"database"`
+	Measurement   string   `toml:"measurement"`
+	Threshold     Duration `toml:"threshold"`
+	CheckInterval Duration `toml:"check-interval"`
+	Webhook       string   `toml:"webhook"`
+}
+
 type Graphite struct {
 	Addr string `toml:"address"`
 	Port uint16 `toml:"port"`
@@ -332,6 +715,14 @@ type Graphite struct {
 	Protocol      string `toml:"protocol"`
 	NamePosition  string `toml:"name-position"`
 	NameSeparator string `toml:"name-separator"`
+
+	// Templates of the form "[filter] template [tags]" used to map metric names.
+	Templates []string `toml:"templates"`
+
+	// Default tags of the form "key=value" added to every point.
+	Tags []string `toml:"tags"`
+
+	Batch Batch `toml:"batch"`
 }
 
 // ConnnectionString returns the connection string for this Graphite config in the form host:port.
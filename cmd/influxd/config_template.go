@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/template"
+)
+
+// execConfig runs the "config" command.
+func execConfig(args []string) {
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	fs.Usage = printConfigUsage
+	fs.Parse(args)
+
+	if err := NewConfig().WriteTOML(os.Stdout); err != nil {
+		log.Fatalf("config: %s", err)
+	}
+}
+
+func printConfigUsage() {
+	log.Printf(`usage: config
+
+config prints a configuration file holding the default value of every
+setting, with a comment describing each one. Sections that may be repeated,
+such as [[graphite]], are included as commented-out examples.
+`)
+}
+
+// WriteTOML writes the configuration as a commented TOML file that parses
+// back to the same configuration. Hostname and the repeated sections are
+// written as comments.
+func (c *Config) WriteTOML(w io.Writer) error {
+	return configTemplate.Execute(w, c)
+}
+
+var configTemplate = template.Must(template.New("config").Funcs(template.FuncMap{
+	"q": quoteTOML,
+}).Parse(`# InfluxDB configuration file.
+#
+# Every setting is listed with its default value. Any setting can be
+# overridden by an environment variable named INFLUXDB_<SECTION>_<KEY>,
+# upper-cased with dashes replaced by underscores, such as INFLUXDB_DATA_PORT.
+# Repeated sections are indexed from zero, such as INFLUXDB_GRAPHITE_0_PORT.
+
+# Name other nodes in the cluster reach this node by. Defaults to the
+# hostname of the OS. Set it to an IP or another name that resolves if the
+# hostname doesn't resolve on the other nodes.
+# hostname = {{q .Hostname}}
+
+# Address every listener binds to unless it sets its own. Blank binds to
+# all interfaces.
+bind-address = {{q .BindAddress}}
+
+# Once every 24 hours InfluxDB reports anonymous data, the raft id, OS, arch
+# and version, to m.influxdb.com. Set to true to disable reporting.
+reporting-disabled = {{.ReportingDisabled}}
+
+# Settings for initial start-up. Once a node has started they are ignored.
+[initialization]
+# Comma-delimited URLs, in the form http://host:port, of a cluster to join.
+join-urls = {{q .Initialization.JoinURLs}}
+
+[authentication]
+# Require users to authenticate. Authentication is disabled if not set.
+enabled = {{.Authentication.Enabled}}
+# Routes served without authentication when it is enabled. Setting this
+# replaces the defaults below. Nodes use /data_nodes, /metastore and
+# /process_continuous_queries to talk to each other so keep those exempt.
+# exempt-routes = ["/", "/data_nodes", "/data_nodes/:id", "/login", "/logout", "/metastore", "/ping", "/process_continuous_queries", "/ready", "/status", "/wait/:index"]
+
+# The admin web interface.
+[admin]
+enabled = {{.Admin.Enabled}}
+port = {{.Admin.Port}}
+
+# The HTTP API used to write and query data.
+[api]
+# Port of the API if it isn't served on the data node's port.
+port = {{.HTTPAPI.Port}}
+# SSL is enabled if a port and certificate are set.
+ssl-port = {{.HTTPAPI.SSLPort}}
+ssl-cert = {{q .HTTPAPI.SSLCertPath}}
+# Timeouts and limits that stop slow or stalled clients from holding
+# connections open. Zero disables a timeout or limit. The write timeout also
+# applies to broker streams when the broker shares the API port.
+read-timeout = "{{.HTTPAPI.ReadTimeout}}"
+read-header-timeout = "{{.HTTPAPI.ReadHeaderTimeout}}"
+write-timeout = "{{.HTTPAPI.WriteTimeout}}"
+idle-timeout = "{{.HTTPAPI.IdleTimeout}}"
+max-header-size = "{{.HTTPAPI.MaxHeaderSize}}"
+# Connections accepted at once. Further connections wait.
+max-connections = {{.HTTPAPI.MaxConnections}}
+# Serve profiling data under /debug/pprof to admin users.
+pprof-enabled = {{.HTTPAPI.PprofEnabled}}
+# Round epoch timestamps above 2^53 sent as JSON numbers instead of rejecting
+# the write.
+allow-lossy-timestamps = {{.HTTPAPI.AllowLossyTimestamps}}
+# Lifetime of admin UI sessions started by logging in.
+session-timeout = "{{.HTTPAPI.SessionTimeout}}"
+# Tag set to the name of the authenticated user on every point written.
+# Disabled if blank.
+user-tag = {{q .HTTPAPI.UserTag}}
+# Accept tags in the X-Influxdb-Tags header, e.g. "dc=east,rack=2".
+tags-header-enabled = {{.HTTPAPI.TagsHeaderEnabled}}
+# Longest a query waits for the index passed as its min_index parameter.
+max-index-wait = "{{.HTTPAPI.MaxIndexWait}}"
+
+# The collectd input.
+[collectd]
+enabled = {{.Collectd.Enabled}}
+# Address and port to listen on. The bind address and the collectd default
+# port are used if not set.
+address = {{q .Collectd.Addr}}
+port = {{.Collectd.Port}}
+database = {{q .Collectd.Database}}
+typesdb = {{q .Collectd.TypesDB}}
+# Points are buffered and written in batches. Defaults are used if zero.
+[collectd.batch]
+size = {{.Collectd.Batch.Size}} # Points per batch.
+pending = {{.Collectd.Batch.Pending}} # Full batches queued before the input blocks.
+timeout = "{{.Collectd.Batch.Timeout}}" # Longest a point is buffered.
+
+# A UDP listener accepting JSON batches of points.
+[udp]
+enabled = {{.UDP.Enabled}}
+bind-address = {{q .UDP.BindAddress}}
+port = {{.UDP.Port}}
+# Batching options, as for collectd.
+[udp.batch]
+size = {{.UDP.Batch.Size}}
+pending = {{.UDP.Batch.Pending}}
+timeout = "{{.UDP.Batch.Timeout}}"
+
+# Brokers take part in distributed consensus and store the replicated log.
+[broker]
+dir = {{q .Broker.Dir}}
+port = {{.Broker.Port}}
+election-timeout = "{{.Broker.Timeout}}"
+# Topics are stored in segments that are removed once every data node has
+# read them. The most recent truncation-window indexes are always kept.
+max-segment-size = "{{.Broker.MaxSegmentSize}}"
+truncation-interval = "{{.Broker.TruncationInterval}}"
+truncation-window = {{.Broker.TruncationWindow}}
+
+# Data nodes store the time series data in shards.
+[data]
+dir = {{q .Data.Dir}}
+port = {{.Data.Port}}
+# Whether retention policies are enforced and how often.
+retention-check-enabled = {{.Data.RetentionCheckEnabled}}
+retention-check-period = "{{.Data.RetentionCheckPeriod}}"
+# Reject writes and statements that modify data. Can be toggled at runtime
+# with PUT /read_only?enabled=<true|false>.
+read-only = {{.Data.ReadOnly}}
+# Writes to shard groups that ended longer than this ago are bulk loaded.
+# Disabled if zero.
+backfill-threshold = "{{.Data.BackfillThreshold}}"
+# Reads of points that don't match their checksum either "fail", "skip" the
+# point or "log" it and return it as stored.
+checksum-policy = {{q .Data.ChecksumPolicy}}
+# Writes with a point timestamped further than this ahead of the server's
+# clock are rejected. Unlimited if zero.
+max-future-time = "{{.Data.MaxFutureTime}}"
+# Directories written by older versions are upgraded on start-up, after
+# being copied to <dir>.v<version>-<time>.bak if migration-backup is set.
+migration-backup = {{.Data.MigrationBackup}}
+# How often the node broadcasts its status for SHOW DATA NODES. It's reported
+# down after three missed heartbeats. Disabled if zero.
+heartbeat-interval = "{{.Data.HeartbeatInterval}}"
+# Shards whose shard groups have ended are compacted in the background.
+# Throughput is in bytes written per second, unlimited if zero.
+compaction-enabled = {{.Data.CompactionEnabled}}
+compaction-check-period = "{{.Data.CompactionCheckPeriod}}"
+compaction-concurrency = {{.Data.CompactionConcurrency}}
+compaction-throughput = "{{.Data.CompactionThroughput}}"
+# Bytes per second of TTL deletes, of copying shards out of the server and
+# of all background IO together. Unlimited if zero.
+retention-throughput = "{{.Data.RetentionThroughput}}"
+backup-throughput = "{{.Data.BackupThroughput}}"
+io-throughput = "{{.Data.IOThroughput}}"
+
+# Move shards to an S3-compatible bucket, or a directory, once their shard
+# group ended more than offload-age ago. They are fetched back when a query
+# needs them and removed again once unused for cache-ttl.
+[cold-storage]
+enabled = {{.ColdStorage.Enabled}}
+offload-age = "{{.ColdStorage.OffloadAge}}"
+check-interval = "{{.ColdStorage.CheckInterval}}"
+cache-ttl = "{{.ColdStorage.CacheTTL}}"
+# Directory to store shards in, such as a network mount. The bucket is used
+# if not set.
+dir = {{q .ColdStorage.Dir}}
+endpoint = {{q .ColdStorage.Endpoint}}
+region = {{q .ColdStorage.Region}}
+bucket = {{q .ColdStorage.Bucket}}
+prefix = {{q .ColdStorage.Prefix}}
+access-key-id = {{q .ColdStorage.AccessKeyID}}
+secret-access-key = {{q .ColdStorage.SecretAccessKey}}
+
+[cluster]
+# Location of cluster state.
+dir = {{q .Cluster.Dir}}
+# Keep-alive connections between brokers and data nodes. Requests wait once
+# max-conns-per-peer is reached. Unlimited if zero.
+max-conns-per-peer = {{.Cluster.MaxConnsPerPeer}}
+max-idle-conns-per-peer = {{.Cluster.MaxIdleConnsPerPeer}}
+idle-conn-timeout = "{{.Cluster.IdleConnTimeout}}"
+
+# Query execution limits. Unlimited if zero.
+[query]
+max-memory = "{{.Query.MaxMemory}}" # Memory buffered by a single query.
+max-total-memory = "{{.Query.MaxTotalMemory}}" # Memory buffered by all running queries.
+# Queries over a memory limit write their buffered rows to disk, in the
+# system temporary directory if spill-dir is blank, instead of failing.
+spill-to-disk = {{.Query.SpillToDisk}}
+spill-dir = {{q .Query.SpillDir}}
+# GROUP BY queries with more groups fail, or return the first groups if
+# truncate-groups is set.
+max-groups = {{.Query.MaxGroups}}
+truncate-groups = {{.Query.TruncateGroups}}
+# Queries running longer are stopped.
+max-timeout = "{{.Query.MaxTimeout}}"
+
+# Periodically write the server's own statistics to the "_internal" database.
+[monitoring]
+enabled = {{.Monitoring.Enabled}}
+write-interval = "{{.Monitoring.WriteInterval}}"
+# Also keep write statistics per measurement.
+write-stats-by-measurement = {{.Monitoring.WriteStatsByMeasurement}}
+
+[logging]
+file = {{q .Logging.File}} # Logs go to stderr if blank.
+write-tracing = {{.Logging.WriteTraceEnabled}} # Log every write in detail.
+write-tracing-sample-n = {{.Logging.WriteTraceSampleN}} # Only trace every Nth write.
+write-tracing-max-bytes = "{{.Logging.WriteTraceMaxBytes}}" # Longer bodies are truncated. Unlimited if zero.
+# Always trace writes to these databases.
+# write-tracing-databases = ["mydb"]
+
+[continuous_queries]
+# Previous intervals recomputed in case lagged data arrived, but never
+# further back than recompute-no-older-than.
+recompute-previous-n = {{.ContinuousQuery.RecomputePreviousN}}
+recompute-no-older-than = "{{.ContinuousQuery.RecomputeNoOlderThan}}"
+# Times the current interval is computed, for group by intervals of at least
+# compute-runs-per-interval times compute-no-more-than.
+compute-runs-per-interval = {{.ContinuousQuery.ComputeRunsPerInterval}}
+compute-no-more-than = "{{.ContinuousQuery.ComputeNoMoreThan}}"
+# Don't run continuous queries on this node.
+disable = {{.ContinuousQuery.Disable}}
+
+# Graphite inputs. 0 or more of these sections may be present.
+# [[graphite]]
+# enabled = true
+# protocol = "tcp" # "tcp" or "udp".
+# address = "" # The bind address if not set.
+# port = 2003
+# database = "graphite"
+# name-position = "last"
+# name-separator = "."
+# templates = [ # Map metric names to measurements, tags and fields.
+#   "servers.* .host.measurement.field",
+#   "measurement* region=us-west", # Default for metrics matching no filter.
+# ]
+# tags = ["dc=east"] # Added to every point.
+# [graphite.batch] # Batching options, as for collectd.
+# size = 1000
+
+# Per-database resource limits on this node. Unset limits are unlimited.
+# 0 or more of these sections may be present.
+# [[quota]]
+# database = "mydb"
+# max-series = 100000
+# max-write-rate = 50000 # Points per second.
+# max-concurrent-queries = 10
+# max-disk-size = "10g"
+
+# Detect series that stop receiving points. 0 or more of these sections may
+# be present.
+# [[deadman]]
+# database = "mydb"
+# measurement = "cpu" # All measurements if not set.
+# threshold = "5m"
+# check-interval = "1m" # The threshold if not set.
+# webhook = "http://localhost:9000/alerts" # Points are written to "deadman" if not set.
+
+# External processes pipeline queries can pass points to. 0 or more of these
+# sections may be present.
+# [[udf]]
+# name = "anomaly"
+# command = "/usr/local/bin/anomaly-score"
+# args = ["--threshold", "3"]
+# timeout = "30s"
+
+# Rollups written by continuous queries that long-range queries read
+# instead. 0 or more of these sections may be present.
+# [[rollup]]
+# database = "mydb"
+# retention-policy = "raw" # The default retention policy if not set.
+# measurement = "cpu"
+# interval = "1h"
+# target-retention-policy = "hourly"
+# target-measurement = "cpu" # The measurement's own name if not set.
+# functions = ["max", "min", "sum"]
+`))
+
+// quoteTOML returns s as a TOML basic string.
+func quoteTOML(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&buf, "\\u%04x", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
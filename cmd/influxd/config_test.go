@@ -1,14 +1,28 @@
 package main_test
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	main "github.com/influxdb/influxdb/cmd/influxd"
+	"github.com/influxdb/influxdb/transport"
 )
 
+// Ensure that kilobyte sizes can be parsed.
+func TestSize_UnmarshalText_KB(t *testing.T) {
+	var s main.Size
+	if err := s.UnmarshalText([]byte("64k")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s != 64*(1<<10) {
+		t.Fatalf("unexpected size: %d", s)
+	}
+}
+
 // Ensure that megabyte sizes can be parsed.
 func TestSize_UnmarshalText_MB(t *testing.T) {
 	var s main.Size
@@ -48,10 +62,14 @@ func TestParseConfig(t *testing.T) {
 
 	if c.Logging.File != "influxdb.log" {
 		t.Fatalf("logging file mismatch: %v", c.Logging.File)
+	} else if c.Logging.WriteTraceMaxBytes != main.Size(1<<10) {
+		t.Fatalf("logging write trace max bytes mismatch: %v", c.Logging.WriteTraceMaxBytes)
 	}
 
 	if !c.Authentication.Enabled {
 		t.Fatalf("authentication enabled mismatch: %v", c.Authentication.Enabled)
+	} else if !reflect.DeepEqual(c.Authentication.ExemptRoutes, []string{"/ping", "/metrics"}) {
+		t.Fatalf("authentication exempt routes mismatch: %v", c.Authentication.ExemptRoutes)
 	}
 
 	if c.UDP.Enabled {
@@ -94,6 +112,12 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("graphite tcp name-position mismatch: expected %v, got %v", "last", tcpGraphite.NamePosition)
 	case tcpGraphite.NameSeparatorString() != "-":
 		t.Fatalf("graphite tcp name-separator mismatch: expected %v, got %v", "-", tcpGraphite.NameSeparatorString())
+	case tcpGraphite.Batch.Size != 500:
+		t.Fatalf("graphite tcp batch size mismatch: expected %v, got %v", 500, tcpGraphite.Batch.Size)
+	case tcpGraphite.Batch.Pending != 3:
+		t.Fatalf("graphite tcp batch pending mismatch: expected %v, got %v", 3, tcpGraphite.Batch.Pending)
+	case time.Duration(tcpGraphite.Batch.Timeout) != 2*time.Second:
+		t.Fatalf("graphite tcp batch timeout mismatch: expected %v, got %v", 2*time.Second, tcpGraphite.Batch.Timeout)
 	}
 
 	udpGraphite := c.Graphites[1]
@@ -129,6 +153,12 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("broker dir mismatch: %v", c.Broker.Dir)
 	} else if time.Duration(c.Broker.Timeout) != time.Second {
 		t.Fatalf("broker duration mismatch: %v", c.Broker.Timeout)
+	} else if c.Broker.MaxSegmentSize != 5*1024*1024 {
+		t.Fatalf("broker max segment size mismatch: %v", c.Broker.MaxSegmentSize)
+	} else if time.Duration(c.Broker.TruncationInterval) != time.Hour {
+		t.Fatalf("broker truncation interval mismatch: %v", c.Broker.TruncationInterval)
+	} else if c.Broker.TruncationWindow != 500 {
+		t.Fatalf("broker truncation window mismatch: %v", c.Broker.TruncationWindow)
 	}
 
 	if c.Data.Dir != "/tmp/influxdb/development/db" {
@@ -140,9 +170,110 @@ func TestParseConfig(t *testing.T) {
 	if c.Data.RetentionCheckPeriod != main.Duration(5*time.Minute) {
 		t.Fatalf("Retention check period mismatch: %v", c.Data.RetentionCheckPeriod)
 	}
+	if c.Data.BackfillThreshold != main.Duration(48*time.Hour) {
+		t.Fatalf("backfill threshold mismatch: %v", c.Data.BackfillThreshold)
+	}
+	if c.Data.ChecksumPolicy != "skip" {
+		t.Fatalf("checksum policy mismatch: %v", c.Data.ChecksumPolicy)
+	}
+	if c.Data.MaxFutureTime != main.Duration(10*time.Minute) {
+		t.Fatalf("max future time mismatch: %v", c.Data.MaxFutureTime)
+	}
+	if c.Data.HeartbeatInterval != main.Duration(30*time.Second) {
+		t.Fatalf("heartbeat interval mismatch: %v", c.Data.HeartbeatInterval)
+	}
+	if c.Data.CompactionEnabled != false {
+		t.Fatalf("compaction enabled mismatch: %v", c.Data.CompactionEnabled)
+	} else if c.Data.CompactionCheckPeriod != main.Duration(1*time.Hour) {
+		t.Fatalf("compaction check period mismatch: %v", c.Data.CompactionCheckPeriod)
+	} else if c.Data.CompactionConcurrency != 2 {
+		t.Fatalf("compaction concurrency mismatch: %v", c.Data.CompactionConcurrency)
+	} else if c.Data.CompactionThroughput != main.Size(5*1024*1024) {
+		t.Fatalf("compaction throughput mismatch: %v", c.Data.CompactionThroughput)
+	} else if c.Data.RetentionThroughput != main.Size(1*1024*1024) {
+		t.Fatalf("retention throughput mismatch: %v", c.Data.RetentionThroughput)
+	} else if c.Data.BackupThroughput != main.Size(2*1024*1024) {
+		t.Fatalf("backup throughput mismatch: %v", c.Data.BackupThroughput)
+	} else if c.Data.IOThroughput != main.Size(8*1024*1024) {
+		t.Fatalf("io throughput mismatch: %v", c.Data.IOThroughput)
+	}
+
+	if c.Monitoring.Enabled != true {
+		t.Fatalf("monitoring enabled mismatch: %v", c.Monitoring.Enabled)
+	} else if c.Monitoring.WriteInterval != main.Duration(30*time.Second) {
+		t.Fatalf("monitoring write interval mismatch: %v", c.Monitoring.WriteInterval)
+	} else if !c.Monitoring.WriteStatsByMeasurement {
+		t.Fatalf("monitoring write stats by measurement mismatch: %v", c.Monitoring.WriteStatsByMeasurement)
+	}
 
 	if c.Cluster.Dir != "/tmp/influxdb/development/cluster" {
 		t.Fatalf("cluster dir mismatch: %v", c.Cluster.Dir)
+	} else if c.Cluster.MaxConnsPerPeer != 8 {
+		t.Fatalf("cluster max conns per peer mismatch: %v", c.Cluster.MaxConnsPerPeer)
+	} else if c.Cluster.MaxIdleConnsPerPeer != transport.DefaultMaxIdleConnsPerPeer {
+		t.Fatalf("cluster max idle conns per peer mismatch: %v", c.Cluster.MaxIdleConnsPerPeer)
+	} else if c.Cluster.IdleConnTimeout != main.Duration(30*time.Second) {
+		t.Fatalf("cluster idle conn timeout mismatch: %v", c.Cluster.IdleConnTimeout)
+	}
+
+	if c.HTTPAPI.ReadTimeout != main.Duration(5*time.Second) {
+		t.Fatalf("api read timeout mismatch: %v", c.HTTPAPI.ReadTimeout)
+	} else if c.HTTPAPI.ReadHeaderTimeout != main.Duration(2*time.Second) {
+		t.Fatalf("api read header timeout mismatch: %v", c.HTTPAPI.ReadHeaderTimeout)
+	} else if c.HTTPAPI.WriteTimeout != main.Duration(30*time.Second) {
+		t.Fatalf("api write timeout mismatch: %v", c.HTTPAPI.WriteTimeout)
+	} else if c.HTTPAPI.IdleTimeout != main.Duration(time.Minute) {
+		t.Fatalf("api idle timeout mismatch: %v", c.HTTPAPI.IdleTimeout)
+	} else if c.HTTPAPI.MaxHeaderSize != main.Size(64*(1<<10)) {
+		t.Fatalf("api max header size mismatch: %v", c.HTTPAPI.MaxHeaderSize)
+	} else if c.HTTPAPI.MaxConnections != 500 {
+		t.Fatalf("api max connections mismatch: %v", c.HTTPAPI.MaxConnections)
+	} else if c.HTTPAPI.SessionTimeout != main.Duration(15*time.Minute) {
+		t.Fatalf("api session timeout mismatch: %v", c.HTTPAPI.SessionTimeout)
+	} else if c.HTTPAPI.UserTag != "tenant" {
+		t.Fatalf("api user tag mismatch: %v", c.HTTPAPI.UserTag)
+	} else if !c.HTTPAPI.TagsHeaderEnabled {
+		t.Fatalf("api tags header enabled mismatch: %v", c.HTTPAPI.TagsHeaderEnabled)
+	} else if c.HTTPAPI.MaxIndexWait != main.Duration(5*time.Second) {
+		t.Fatalf("api max index wait mismatch: %v", c.HTTPAPI.MaxIndexWait)
+	}
+
+	if c.Query.MaxMemory != main.Size(100*(1<<20)) {
+		t.Fatalf("query max memory mismatch: %v", c.Query.MaxMemory)
+	} else if c.Query.MaxTotalMemory != main.Size(1<<30) {
+		t.Fatalf("query max total memory mismatch: %v", c.Query.MaxTotalMemory)
+	} else if c.Query.MaxGroups != 500 || !c.Query.TruncateGroups {
+		t.Fatalf("query max groups mismatch: %v, %v", c.Query.MaxGroups, c.Query.TruncateGroups)
+	} else if time.Duration(c.Query.MaxTimeout) != time.Minute {
+		t.Fatalf("query max timeout mismatch: %v", c.Query.MaxTimeout)
+	}
+
+	if !c.ColdStorage.Enabled {
+		t.Fatalf("cold storage enabled mismatch: %v", c.ColdStorage.Enabled)
+	} else if time.Duration(c.ColdStorage.OffloadAge) != 720*time.Hour {
+		t.Fatalf("cold storage offload age mismatch: %v", c.ColdStorage.OffloadAge)
+	} else if time.Duration(c.ColdStorage.CacheTTL) != 2*time.Hour {
+		t.Fatalf("cold storage cache ttl mismatch: %v", c.ColdStorage.CacheTTL)
+	} else if c.ColdStorage.Bucket != "shards" || c.ColdStorage.Region != "eu-west-1" || c.ColdStorage.AccessKeyID != "AKID" {
+		t.Fatalf("cold storage bucket mismatch: %#v", c.ColdStorage)
+	}
+
+	if len(c.Quotas) != 1 {
+		t.Fatalf("quotas mismatch: %v", len(c.Quotas))
+	} else if q := c.Quotas[0]; q != (main.Quota{Database: "tenant1", MaxSeries: 1000, MaxWriteRate: 500, MaxConcurrentQueries: 4, MaxDiskSize: main.Size(2 << 30)}) {
+		t.Fatalf("quota mismatch: %#v", q)
+	}
+
+	if len(c.UDFs) != 1 {
+		t.Fatalf("udfs mismatch: %v", len(c.UDFs))
+	} else if u := c.UDFs[0]; u.Name != "anomaly" || u.Command != "/usr/local/bin/anomaly-score" || !reflect.DeepEqual(u.Args, []string{"--threshold", "3"}) || u.Timeout != main.Duration(10*time.Second) {
+		t.Fatalf("udf mismatch: %#v", u)
+	}
+
+	if len(c.Rollups) != 1 {
+		t.Fatalf("rollups mismatch: %v", len(c.Rollups))
+	} else if r := c.Rollups[0]; r.Database != "mydb" || r.Measurement != "cpu" || r.Interval != main.Duration(time.Hour) || r.TargetRetentionPolicy != "hourly" || !reflect.DeepEqual(r.Functions, []string{"max", "sum"}) {
+		t.Fatalf("rollup mismatch: %#v", r)
 	}
 
 	// TODO: UDP Servers testing.
@@ -171,10 +302,17 @@ join-urls = "http://127.0.0.1:8086"
 # Control authentication
 [authentication]
 enabled = true
+exempt-routes = ["/ping", "/metrics"]
 
 [logging]
 file   = "influxdb.log"
 write-tracing = true
+write-tracing-max-bytes = "1k"
+
+[monitoring]
+enabled = true
+write-interval = "30s"
+write-stats-by-measurement = true
 
 # Configure the admin server
 [admin]
@@ -190,6 +328,15 @@ ssl-cert = "../cert.pem"
 # and keep alive connections they don't use won't end up connection a million times.
 # However, if a request is taking longer than this to complete, could be a problem.
 read-timeout = "5s"
+read-header-timeout = "2s"
+write-timeout = "30s"
+idle-timeout = "1m"
+max-header-size = "64k"
+max-connections = 500
+session-timeout = "15m"
+user-tag = "tenant"
+tags-header-enabled = true
+max-index-wait = "5s"
 
 [input_plugins]
 
@@ -208,6 +355,11 @@ database = "graphite_tcp"  # store graphite data in this database
 name-position = "last"
 name-separator = "-"
 
+[graphite.batch]
+size = 500
+pending = 3
+timeout = "2s"
+
 [[graphite]]
 protocol = "udP"
 enabled = true
@@ -234,18 +386,192 @@ dir  = "/tmp/influxdb/development/broker"
 
 # election-timeout = "2s"
 
+max-segment-size    = "5m"
+truncation-interval = "1h"
+truncation-window   = 500
+
 [data]
 dir = "/tmp/influxdb/development/db"
 retention-check-enabled = true
 retention-check-period = "5m"
+backfill-threshold = "48h"
+checksum-policy = "skip"
+max-future-time = "10m"
+heartbeat-interval = "30s"
+compaction-enabled = false
+compaction-check-period = "1h"
+compaction-concurrency = 2
+compaction-throughput = "5m"
+retention-throughput = "1m"
+backup-throughput = "2m"
+io-throughput = "8m"
 
 [continuous_queries]
 disable = false
 
+[cold-storage]
+enabled = true
+offload-age = "720h"
+cache-ttl = "2h"
+region = "eu-west-1"
+bucket = "shards"
+access-key-id = "AKID"
+
 [cluster]
 dir = "/tmp/influxdb/development/cluster"
+max-conns-per-peer = 8
+idle-conn-timeout = "30s"
+
+[query]
+max-memory = "100m"
+max-total-memory = "1g"
+max-groups = 500
+truncate-groups = true
+max-timeout = "1m"
+
+[[quota]]
+database = "tenant1"
+max-series = 1000
+max-write-rate = 500
+max-concurrent-queries = 4
+max-disk-size = "2g"
+
+[[udf]]
+name = "anomaly"
+command = "/usr/local/bin/anomaly-score"
+args = ["--threshold", "3"]
+timeout = "10s"
+
+[[rollup]]
+database = "mydb"
+measurement = "cpu"
+interval = "1h"
+target-retention-policy = "hourly"
+functions = ["max", "sum"]
 `
 
+// Ensure settings can be overridden by environment variables.
+func TestConfig_ApplyEnvOverrides(t *testing.T) {
+	c, err := main.ParseConfig(`
+[data]
+port = 8086
+
+[[graphite]]
+port = 2003
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{
+		"INFLUXDB_BIND_ADDRESS":                    "10.0.0.1",
+		"INFLUXDB_DATA_PORT":                       "9086",
+		"INFLUXDB_DATA_READ_ONLY":                  "true",
+		"INFLUXDB_DATA_RETENTION_CHECK_PERIOD":     "5m",
+		"INFLUXDB_QUERY_MAX_MEMORY":                "1g",
+		"INFLUXDB_UDP_BATCH_SIZE":                  "250",
+		"INFLUXDB_LOGGING_WRITE_TRACING_DATABASES": "db0, db1",
+		"INFLUXDB_CONTINUOUS_QUERIES_DISABLE":      "true",
+		"INFLUXDB_GRAPHITE_0_DATABASE":             "graphite",
+		"INFLUXDB_GRAPHITE_1_PORT":                 "2004",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	if err := c.ApplyEnvOverrides(); err != nil {
+		t.Fatal(err)
+	} else if c.BindAddress != "10.0.0.1" {
+		t.Fatalf("unexpected bind address: %s", c.BindAddress)
+	} else if c.Data.Port != 9086 || !c.Data.ReadOnly {
+		t.Fatalf("unexpected data settings: %d, %v", c.Data.Port, c.Data.ReadOnly)
+	} else if time.Duration(c.Data.RetentionCheckPeriod) != 5*time.Minute {
+		t.Fatalf("unexpected retention check period: %s", c.Data.RetentionCheckPeriod)
+	} else if c.Query.MaxMemory != 1<<30 {
+		t.Fatalf("unexpected max memory: %d", c.Query.MaxMemory)
+	} else if c.UDP.Batch.Size != 250 {
+		t.Fatalf("unexpected udp batch size: %d", c.UDP.Batch.Size)
+	} else if !reflect.DeepEqual(c.Logging.WriteTraceDatabases, []string{"db0", "db1"}) {
+		t.Fatalf("unexpected write tracing databases: %v", c.Logging.WriteTraceDatabases)
+	} else if !c.ContinuousQuery.Disable {
+		t.Fatal("expected continuous queries to be disabled")
+	} else if len(c.Graphites) != 2 || c.Graphites[0].Port != 2003 || c.Graphites[0].Database != "graphite" || c.Graphites[1].Port != 2004 {
+		t.Fatalf("unexpected graphite settings: %#v", c.Graphites)
+	}
+
+	// Invalid values name the variable.
+	os.Setenv("INFLUXDB_ADMIN_PORT", "eighty")
+	defer os.Unsetenv("INFLUXDB_ADMIN_PORT")
+	if err := c.ApplyEnvOverrides(); err == nil || err.Error() != `INFLUXDB_ADMIN_PORT: invalid integer "eighty"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the default configuration file parses back to the default configuration.
+func TestConfig_WriteTOML(t *testing.T) {
+	c := main.NewConfig()
+	var buf bytes.Buffer
+	if err := c.WriteTOML(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := main.ValidateConfig(buf.String()); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	} else if other, err := main.ParseConfig(buf.String()); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(c, other) {
+		t.Fatalf("mismatch:\n\nexp=%#v\n\ngot=%#v", c, other)
+	}
+}
+
+// Ensure the sample configuration file is valid.
+func TestValidateConfig_Sample(t *testing.T) {
+	b, err := ioutil.ReadFile("../../etc/config.sample.toml")
+	if err != nil {
+		t.Fatal(err)
+	} else if errs := main.ValidateConfig(string(b)); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+// Ensure invalid settings are reported with their line and path.
+func TestValidateConfig(t *testing.T) {
+	errs := main.ValidateConfig(`
+bogus = 1
+
+[data]
+port = "8086"
+checksum-policy = "ignore"
+
+[[graphite]]
+enabled = false
+
+[[graphite]]
+enabled = true
+protocol = "sctp"
+[graphite.batch]
+sizes = 100
+`)
+
+	var a []string
+	for _, e := range errs {
+		a = append(a, e.Error())
+	}
+	if exp := []string{
+		`line 2: bogus: unknown setting`,
+		`line 5: data.port: cannot load TOML value of type string into a Go integer`,
+		`line 6: data.checksum-policy: must be "fail", "skip" or "log"`,
+		`line 13: graphite[1].protocol: must be "tcp" or "udp"`,
+		`line 15: graphite[1].batch.sizes: unknown setting`,
+	}; !reflect.DeepEqual(a, exp) {
+		t.Fatalf("unexpected errors:\n%s", strings.Join(a, "\n"))
+	}
+
+	if errs := main.ValidateConfig("[data\nport = 1\n"); len(errs) != 1 || errs[0].Line != 1 {
+		t.Fatalf("unexpected syntax errors: %v", errs)
+	}
+}
+
 func TestCollectd_ConnectionString(t *testing.T) {
 	var tests = []struct {
 		name             string
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/graphite"
+)
+
+// ConfigError is a problem with a setting in a configuration file.
+type ConfigError struct {
+	Line  int    // line of the setting, zero if unknown
+	Field string // path of the setting, such as "data.port" or "graphite[0].protocol"
+	Msg   string
+}
+
+// Error returns the line, field and message of the error.
+func (e *ConfigError) Error() string {
+	var prefix string
+	if e.Line > 0 {
+		prefix = fmt.Sprintf("line %d: ", e.Line)
+	}
+	if e.Field != "" {
+		prefix += e.Field + ": "
+	}
+	return prefix + e.Msg
+}
+
+// ValidateConfig checks the text of a configuration file. Each setting is
+// decoded on its own so every unknown setting and invalid value is reported,
+// along with the errors returned by Config.Validate. Returns nil if the
+// configuration is valid.
+func ValidateConfig(s string) []*ConfigError {
+	lines := configKeyLines(s)
+
+	var top map[string]toml.Primitive
+	md, err := toml.Decode(s, &top)
+	if err != nil {
+		return []*ConfigError{parseConfigError(err)}
+	}
+
+	v := &configValidator{md: md, lines: lines}
+	c := NewConfig()
+	v.decodeTable("", top, reflect.ValueOf(c).Elem())
+	for _, e := range c.Validate() {
+		e.Line = lines[e.Field]
+		v.errors = append(v.errors, e)
+	}
+
+	sort.Stable(configErrors(v.errors))
+	return v.errors
+}
+
+// configValidator decodes a configuration one setting at a time.
+type configValidator struct {
+	md     toml.MetaData
+	lines  map[string]int // line of each setting by path
+	errors []*ConfigError
+}
+
+func (v *configValidator) errorf(path, format string, a ...interface{}) {
+	v.errors = append(v.errors, &ConfigError{Line: v.lines[path], Field: path, Msg: fmt.Sprintf(format, a...)})
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// decodeTable decodes the settings of a table into the fields of a struct.
+func (v *configValidator) decodeTable(prefix string, table map[string]toml.Primitive, rv reflect.Value) {
+	fields := make(map[string]reflect.Value)
+	for i := 0; i < rv.NumField(); i++ {
+		if name := strings.Split(rv.Type().Field(i).Tag.Get("toml"), ",")[0]; name != "" && name != "-" {
+			fields[name] = rv.Field(i)
+		}
+	}
+
+	for key, prim := range table {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		fv, ok := fields[key]
+		if !ok {
+			v.errorf(path, "unknown setting")
+			continue
+		}
+		v.decode(path, prim, fv)
+	}
+}
+
+// decode decodes a setting into a value, descending into tables and arrays
+// of tables.
+func (v *configValidator) decode(path string, prim toml.Primitive, rv reflect.Value) {
+	switch {
+	case rv.Kind() == reflect.Struct && !reflect.PtrTo(rv.Type()).Implements(textUnmarshalerType):
+		var table map[string]toml.Primitive
+		if err := v.md.PrimitiveDecode(prim, &table); err != nil {
+			v.errorf(path, "expected a table")
+			return
+		}
+		v.decodeTable(path, table, rv)
+
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Struct:
+		var tables []map[string]toml.Primitive
+		if err := v.md.PrimitiveDecode(prim, &tables); err != nil {
+			v.errorf(path, "expected an array of tables")
+			return
+		}
+		rv.Set(reflect.MakeSlice(rv.Type(), len(tables), len(tables)))
+		for i, table := range tables {
+			v.decodeTable(fmt.Sprintf("%s[%d]", path, i), table, rv.Index(i))
+		}
+
+	default:
+		if err := v.md.PrimitiveDecode(prim, rv.Addr().Interface()); err != nil {
+			v.errorf(path, "%s", strings.TrimPrefix(err.Error(), "toml: "))
+		}
+	}
+}
+
+// Validate returns an error for each setting whose value is invalid or
+// conflicts with another setting.
+func (c *Config) Validate() []*ConfigError {
+	var a []*ConfigError
+	errorf := func(field, format string, v ...interface{}) {
+		a = append(a, &ConfigError{Field: field, Msg: fmt.Sprintf(format, v...)})
+	}
+
+	for field, port := range map[string]int{
+		"admin.port":   c.Admin.Port,
+		"api.port":     c.HTTPAPI.Port,
+		"api.ssl-port": c.HTTPAPI.SSLPort,
+		"udp.port":     c.UDP.Port,
+		"broker.port":  c.Broker.Port,
+		"data.port":    c.Data.Port,
+	} {
+		if port < 0 || port > 65535 {
+			errorf(field, "invalid port %d", port)
+		}
+	}
+	if c.HTTPAPI.SSLPort != 0 && c.HTTPAPI.SSLCertPath == "" {
+		errorf("api.ssl-cert", "required when ssl-port is set")
+	}
+
+	switch strings.ToLower(c.Data.ChecksumPolicy) {
+	case "", influxdb.ChecksumPolicyFail, influxdb.ChecksumPolicySkip, influxdb.ChecksumPolicyLog:
+	default:
+		errorf("data.checksum-policy", "must be %q, %q or %q", influxdb.ChecksumPolicyFail, influxdb.ChecksumPolicySkip, influxdb.ChecksumPolicyLog)
+	}
+	if c.Data.RetentionCheckEnabled && c.Data.RetentionCheckPeriod <= 0 {
+		errorf("data.retention-check-period", "must be positive when retention checks are enabled")
+	}
+	if c.Data.HeartbeatInterval < 0 {
+		errorf("data.heartbeat-interval", "must not be negative")
+	}
+	if c.Data.CompactionEnabled && c.Data.CompactionCheckPeriod <= 0 {
+		errorf("data.compaction-check-period", "must be positive when compaction is enabled")
+	}
+	if c.ColdStorage.Enabled && c.ColdStorage.Dir == "" && c.ColdStorage.Bucket == "" {
+		errorf("cold-storage.bucket", "a bucket or directory is required when cold storage is enabled")
+	}
+	if c.Monitoring.Enabled && c.Monitoring.WriteInterval <= 0 {
+		errorf("monitoring.write-interval", "must be positive when monitoring is enabled")
+	}
+	if c.Collectd.Enabled && c.Collectd.Database == "" {
+		errorf("collectd.database", "required when collectd is enabled")
+	}
+
+	for i, g := range c.Graphites {
+		field := fmt.Sprintf("graphite[%d]", i)
+		if !g.Enabled {
+			continue
+		}
+		if p := strings.ToLower(g.Protocol); p != "tcp" && p != "udp" {
+			errorf(field+".protocol", `must be "tcp" or "udp"`)
+		}
+		parser := graphite.NewParser()
+		for _, t := range g.Templates {
+			if err := parser.AddTemplate(t); err != nil {
+				errorf(field+".templates", "%s", err)
+			}
+		}
+		if _, err := graphite.ParseTags(strings.Join(g.Tags, ",")); err != nil {
+			errorf(field+".tags", "%s", err)
+		}
+	}
+	for i, q := range c.Quotas {
+		if q.Database == "" {
+			errorf(fmt.Sprintf("quota[%d].database", i), "required")
+		}
+	}
+	for i, d := range c.Deadmans {
+		field := fmt.Sprintf("deadman[%d]", i)
+		if d.Database == "" {
+			errorf(field+".database", "required")
+		}
+		if d.Threshold <= 0 {
+			errorf(field+".threshold", "must be positive")
+		}
+	}
+	for i, u := range c.UDFs {
+		field := fmt.Sprintf("udf[%d]", i)
+		if u.Name == "" {
+			errorf(field+".name", "required")
+		}
+		if u.Command == "" {
+			errorf(field+".command", "required")
+		}
+	}
+	for i, r := range c.Rollups {
+		field := fmt.Sprintf("rollup[%d]", i)
+		if r.Database == "" {
+			errorf(field+".database", "required")
+		}
+		if r.Measurement == "" {
+			errorf(field+".measurement", "required")
+		}
+		if r.Interval <= 0 {
+			errorf(field+".interval", "must be positive")
+		}
+		if r.TargetRetentionPolicy == "" {
+			errorf(field+".target-retention-policy", "required")
+		}
+	}
+
+	sort.Stable(configErrors(a))
+	return a
+}
+
+// configErrors sorts errors by line, then by field.
+type configErrors []*ConfigError
+
+func (a configErrors) Len() int      { return len(a) }
+func (a configErrors) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a configErrors) Less(i, j int) bool {
+	if a[i].Line != a[j].Line {
+		return a[i].Line < a[j].Line
+	}
+	return a[i].Field < a[j].Field
+}
+
+// tomlErrorLine matches the line number in a TOML syntax error.
+var tomlErrorLine = regexp.MustCompile(`^Near line (\d+) \(last key parsed '([^']*)'\): `)
+
+// parseConfigError converts a TOML syntax error into a ConfigError.
+func parseConfigError(err error) *ConfigError {
+	msg := err.Error()
+	m := tomlErrorLine.FindStringSubmatch(msg)
+	if m == nil {
+		return &ConfigError{Msg: msg}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return &ConfigError{Line: line, Field: m[2], Msg: msg[len(m[0]):]}
+}
+
+var (
+	tomlTableLine      = regexp.MustCompile(`^\[\s*([^\[\]]+?)\s*\]`)
+	tomlArrayTableLine = regexp.MustCompile(`^\[\[\s*([^\[\]]+?)\s*\]\]`)
+	tomlKeyLine        = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*=`)
+)
+
+// configKeyLines returns the line of each setting in the text of a
+// configuration file, by the path used in ConfigErrors.
+func configKeyLines(s string) map[string]int {
+	lines := make(map[string]int)
+	arrays := make(map[string]int) // elements seen of each array of tables
+	var table string
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if m := tomlArrayTableLine.FindStringSubmatch(line); m != nil {
+			table = fmt.Sprintf("%s[%d]", m[1], arrays[m[1]])
+			arrays[m[1]]++
+			lines[table] = i + 1
+		} else if m := tomlTableLine.FindStringSubmatch(line); m != nil {
+			// Subtables of an array of tables belong to its last element.
+			table = m[1]
+			for name, n := range arrays {
+				if strings.HasPrefix(table, name+".") {
+					table = fmt.Sprintf("%s[%d]%s", name, n-1, table[len(name):])
+				}
+			}
+			lines[table] = i + 1
+		} else if m := tomlKeyLine.FindStringSubmatch(line); m != nil {
+			path := m[1]
+			if table != "" {
+				path = table + "." + path
+			}
+			lines[path] = i + 1
+		}
+	}
+	return lines
+}
+
+// execValidateConfig runs the "validate-config" command.
+func execValidateConfig(args []string) {
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	fs.Usage = printValidateConfigUsage
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		printValidateConfigUsage()
+		os.Exit(2)
+	}
+
+	path := fs.Arg(0)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("validate-config: %s", err)
+	}
+
+	errs := ValidateConfig(string(b))
+	for _, e := range errs {
+		if e.Line > 0 {
+			fmt.Printf("%s:%d: ", path, e.Line)
+		} else {
+			fmt.Printf("%s: ", path)
+		}
+		if e.Field != "" {
+			fmt.Printf("%s: ", e.Field)
+		}
+		fmt.Println(e.Msg)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printValidateConfigUsage() {
+	log.Printf(`usage: validate-config <path>
+
+validate-config checks a configuration file without starting a node. Each
+unknown setting, invalid value and conflicting setting is printed with its
+line and the exit status is 1 if there were any.
+`)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serveHTTP serves HTTP requests on a listener using the timeouts and limits
+// from the API configuration.
+func serveHTTP(config *Config, l net.Listener, h http.Handler) error {
+	c := config.HTTPAPI
+	if c.MaxConnections > 0 {
+		l = newLimitListener(l, c.MaxConnections)
+	}
+
+	// The read timeouts are applied as connection deadlines since the server
+	// only has a single timeout covering the headers, the body and idle time.
+	srv := &http.Server{
+		Handler:        h,
+		WriteTimeout:   time.Duration(c.WriteTimeout),
+		MaxHeaderBytes: int(c.MaxHeaderSize),
+		ConnState:      readDeadlines(time.Duration(c.ReadHeaderTimeout), time.Duration(c.ReadTimeout), time.Duration(c.IdleTimeout)),
+	}
+	return srv.Serve(l)
+}
+
+// readDeadlines returns a connection state hook that limits the time to read
+// the headers of a new connection's first request, the time to read a request
+// body once its headers are read, and the time an idle connection has to send
+// its next request. A zero duration removes the limit.
+func readDeadlines(header, body, idle time.Duration) func(net.Conn, http.ConnState) {
+	return func(conn net.Conn, state http.ConnState) {
+		var d time.Duration
+		switch state {
+		case http.StateNew:
+			d = header
+		case http.StateActive:
+			d = body
+		case http.StateIdle:
+			d = idle
+		default:
+			return
+		}
+
+		var t time.Time
+		if d > 0 {
+			t = time.Now().Add(d)
+		}
+		conn.SetReadDeadline(t)
+	}
+}
+
+// limitListener is a listener that accepts at most n connections at once.
+// Accept blocks until an open connection is closed.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+// newLimitListener returns a listener that accepts at most n connections from l at once.
+func newLimitListener(l net.Listener, n int) *limitListener {
+	return &limitListener{Listener: l, sem: make(chan struct{}, n)}
+}
+
+// Accept waits for a free connection slot and then for the next connection.
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.sem <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+// limitConn is a connection that frees its listener slot when closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close closes the connection and frees its slot.
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"syscall"
 )
 
 const logo = `
@@ -68,6 +69,16 @@ func main() {
 		execRun(args[1:])
 	case "":
 		execRun(args)
+	case "shard":
+		execShard(args[1:])
+	case "verify":
+		execVerify(args[1:])
+	case "migrate":
+		execMigrate(args[1:])
+	case "config":
+		execConfig(args[1:])
+	case "validate-config":
+		execValidateConfig(args[1:])
 	case "version":
 		execVersion(args[1:])
 	case "help":
@@ -115,10 +126,14 @@ func execRun(args []string) {
 	}
 	log.SetOutput(logWriter)
 
-	Run(config, *join, version, logWriter)
+	runNode(config, *join, logWriter)
+}
 
-	// Wait indefinitely.
-	<-(chan struct{})(nil)
+// waitForSignal blocks until the process is asked to stop.
+func waitForSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	log.Printf("received %s, shutting down", <-c)
 }
 
 // execVersion runs the "version" command.
@@ -151,8 +166,13 @@ Usage:
 
 The commands are:
 
+    config               print the default configuration
     join-cluster         create a new node that will join an existing cluster
+    migrate              upgrade a stopped node's data to the current format
     run                  run node with existing configuration
+    shard                export or import a shard archive
+    validate-config      check a configuration file for errors
+    verify               check, and optionally repair, a stopped node's data
     version              displays the InfluxDB version
 
 "run" is the default command.
@@ -192,14 +212,6 @@ func startProfiling(cpuprofile, memprofile string) {
 		prof.mem = f
 		runtime.MemProfileRate = 4096
 	}
-
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		<-c
-		stopProfiling()
-		os.Exit(0)
-	}()
 }
 
 func stopProfiling() {
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/influxdb/influxdb"
+)
+
+// execMigrate runs the "migrate" command.
+func execMigrate(args []string) {
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		configPath = fs.String("config", "", "")
+		dryRun     = fs.Bool("dry-run", false, "")
+		noBackup   = fs.Bool("no-backup", false, "")
+	)
+	fs.Usage = printMigrateUsage
+	fs.Parse(args)
+
+	config := parseConfig(*configPath, "")
+	r, err := influxdb.MigrateDataDir(config.DataDir(), influxdb.MigrateOptions{
+		DryRun: *dryRun,
+		Backup: config.Data.MigrationBackup && !*noBackup,
+		Logger: log.New(os.Stderr, "", 0),
+	})
+	if err != nil {
+		log.Fatalf("migrate: %s", err)
+	}
+
+	if !*dryRun {
+		fmt.Printf("data format version %d, migrated to %d\n", r.From, r.To)
+		return
+	}
+	for _, m := range r.Migrations {
+		fmt.Printf("version %d: %s\n", m.Version, m.Description)
+	}
+	fmt.Printf("data format version %d, would migrate to %d\n", r.From, r.To)
+}
+
+func printMigrateUsage() {
+	log.Printf(`usage: migrate [flags]
+
+migrate upgrades the data directory of a stopped data node to the format
+version of this build. Each migration is logged as it runs. Nodes also
+migrate their data directory when they start.
+
+        -config <path>
+                          Set the path to the configuration file.
+
+        -dry-run
+                          Print the migrations that would run without
+                          changing the data directory.
+
+        -no-backup
+                          Don't copy the data directory before migrating it,
+                          even if migration-backup is set.
+`)
+}
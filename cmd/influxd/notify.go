@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// readyPollInterval is how often readiness is checked while waiting for a
+// node to catch up.
+const readyPollInterval = 100 * time.Millisecond
+
+// node holds the broker, data node and services started by Run.
+type node struct {
+	broker   *messaging.Broker
+	server   *influxdb.Server
+	services *ServiceManager
+}
+
+// close closes the services before the data node and broker, logging errors.
+func (n *node) close() {
+	if err := n.services.Close(); err != nil {
+		log.Printf("service close error: %s", err)
+	}
+	if err := n.server.Close(); err != nil {
+		log.Printf("server close error: %s", err)
+	}
+	if err := n.broker.Close(); err != nil {
+		log.Printf("broker close error: %s", err)
+	}
+}
+
+// waitReady blocks until the server can serve traffic. Returns false if done
+// is closed first.
+func waitReady(s *influxdb.Server, done <-chan struct{}) bool {
+	for !s.Ready() {
+		select {
+		case <-done:
+			return false
+		case <-time.After(readyPollInterval):
+		}
+	}
+	return true
+}
+
+// readyService tells the service manager that started the process, if any,
+// once the data node has caught up with the broker and can serve traffic.
+type readyService struct {
+	baseService
+	server *influxdb.Server
+	done   chan struct{}
+}
+
+// Open starts waiting for the server to become ready.
+func (s *readyService) Open() error {
+	s.done = make(chan struct{})
+	go func(done chan struct{}) {
+		if !waitReady(s.server, done) {
+			return
+		}
+		s.logger.Printf("data node #%d ready to serve traffic", s.server.ID())
+		if err := sdNotify("READY=1"); err != nil {
+			s.logger.Printf("notify error: %s", err)
+		}
+	}(s.done)
+	return nil
+}
+
+// Close tells the service manager the node is stopping.
+func (s *readyService) Close() error {
+	close(s.done)
+	return sdNotify("STOPPING=1")
+}
+
+// sdNotify sends a state, such as "READY=1", to systemd over the socket named
+// by $NOTIFY_SOCKET. Does nothing if the variable is not set.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+
+	// Names starting with "@" are in the abstract namespace.
+	if strings.HasPrefix(name, "@") {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -5,8 +5,6 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"net"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -15,15 +13,20 @@ import (
 	"time"
 
 	"github.com/influxdb/influxdb"
-	"github.com/influxdb/influxdb/admin"
+	"github.com/influxdb/influxdb/client"
 	"github.com/influxdb/influxdb/collectd"
 	"github.com/influxdb/influxdb/graphite"
 	"github.com/influxdb/influxdb/httpd"
 	"github.com/influxdb/influxdb/messaging"
+	"github.com/influxdb/influxdb/objectstore"
+	"github.com/influxdb/influxdb/pipeline"
+	"github.com/influxdb/influxdb/transport"
 	"github.com/influxdb/influxdb/udp"
 )
 
-func Run(config *Config, join, version string, logWriter *os.File) (*messaging.Broker, *influxdb.Server) {
+// Run opens the broker and data node described by config along with their
+// services. The returned ServiceManager closes the services.
+func Run(config *Config, join, version string, logWriter *os.File) (*messaging.Broker, *influxdb.Server, *ServiceManager) {
 	log.Printf("influxdb started, version %s, commit %s", version, commit)
 
 	// Parse the configuration and determine if a broker and/or server exist.
@@ -42,6 +45,11 @@ func Run(config *Config, join, version string, logWriter *os.File) (*messaging.B
 	}
 	initServer = initServer || initBroker
 
+	// Limit the pooled connections used to reach other nodes.
+	transport.Default.MaxConnsPerPeer = config.Cluster.MaxConnsPerPeer
+	transport.Default.MaxIdleConnsPerPeer = config.Cluster.MaxIdleConnsPerPeer
+	transport.Default.IdleConnTimeout = time.Duration(config.Cluster.IdleConnTimeout)
+
 	// Parse join urls from the --join flag.
 	var joinURLs []*url.URL
 	if join == "" {
@@ -51,118 +59,183 @@ func Run(config *Config, join, version string, logWriter *os.File) (*messaging.B
 	}
 
 	// Open broker, initialize or join as necessary.
-	b := openBroker(config.BrokerDir(), config.BrokerURL(), initBroker, joinURLs, logWriter)
+	b := openBroker(config, initBroker, joinURLs, logWriter)
 
-	// Start the broker handler.
+	// Start the broker handler before opening the server as the server may
+	// need to reach the broker to initialize.
+	m := NewServiceManager(logWriter)
 	var h *Handler
 	if b != nil {
 		h = &Handler{brokerHandler: messaging.NewHandler(b.Broker)}
-		// We want to make sure we are spun up before we exit this function, so we manually listen and serve
-		listener, err := net.Listen("tcp", config.BrokerAddr())
-		if err != nil {
-			log.Fatal(err)
-		}
-		go func() { log.Fatal(http.Serve(listener, h)) }()
-		log.Printf("broker listening on %s", config.BrokerAddr())
+		m.Add("broker", &httpService{config: config, addr: config.BrokerAddr(), handler: h})
 
 		// have it occasionally tell a data node in the cluster to run continuous queries
 		if config.ContinuousQuery.Disable {
 			log.Printf("Not running continuous queries. [continuous_queries].disable is set to true.")
 		} else {
-			b.RunContinuousQueryLoop()
+			m.Add("continuous_queries", &continuousQueryService{broker: b}, "broker")
 		}
 	}
+	if err := m.Open(); err != nil {
+		log.Fatal(err)
+	}
 
 	// Open server, initialize or join as necessary.
 	s := openServer(config, b, initServer, initBroker, configExists, joinURLs, logWriter)
 	s.SetAuthenticationEnabled(config.Authentication.Enabled)
+	s.SetReadOnly(config.Data.ReadOnly)
 
+	addServices(m, config, b, h, s, version, logWriter)
+	if err := m.Open(); err != nil {
+		log.Fatal(err)
+	}
+
+	return b.Broker, s, m
+}
+
+// addServices adds the services of a data node to m.
+func addServices(m *ServiceManager, config *Config, b *influxdb.Broker, h *Handler, s *influxdb.Server, version string, logWriter io.Writer) {
 	// Enable retention policy enforcement if requested.
 	if config.Data.RetentionCheckEnabled {
 		interval := time.Duration(config.Data.RetentionCheckPeriod)
-		if err := s.StartRetentionPolicyEnforcement(interval); err != nil {
-			log.Fatalf("retention policy enforcement failed: %s", err.Error())
-		}
-		log.Printf("broker enforcing retention policies with check interval of %s", interval)
+		m.Add("retention", &loopService{
+			start: func() error { return s.StartRetentionPolicyEnforcement(interval) },
+			msg:   fmt.Sprintf("enforcing retention policies with check interval of %s", interval),
+		})
 	}
 
-	// Start the server handler. Attach to broker if listening on the same port.
-	if s != nil {
-		sh := httpd.NewHandler(s, config.Authentication.Enabled, version)
-		sh.SetLogOutput(logWriter)
-		sh.WriteTrace = config.Logging.WriteTraceEnabled
+	// Broadcast the node's status for SHOW DATA NODES.
+	if config.Data.HeartbeatInterval > 0 {
+		interval := time.Duration(config.Data.HeartbeatInterval)
+		m.Add("heartbeat", &loopService{
+			start: func() error { return s.StartHeartbeats(interval) },
+			msg:   fmt.Sprintf("sending heartbeats every %s", interval),
+		})
+	}
 
-		if h != nil && config.BrokerAddr() == config.DataAddr() {
-			h.serverHandler = sh
-		} else {
-			// We want to make sure we are spun up before we exit this function, so we manually listen and serve
-			listener, err := net.Listen("tcp", config.DataAddr())
-			if err != nil {
-				log.Fatal(err)
+	// Compact shards in the background if requested.
+	if config.Data.CompactionEnabled {
+		interval := time.Duration(config.Data.CompactionCheckPeriod)
+		m.Add("compaction", &loopService{
+			start: func() error { return s.StartCompaction(interval) },
+			msg:   fmt.Sprintf("compacting shards with check interval of %s", interval),
+		})
+	}
+
+	// Offload old shards to the object store if requested.
+	if config.ColdStorage.Enabled {
+		interval := time.Duration(config.ColdStorage.CheckInterval)
+		m.Add("offload", &loopService{
+			start: func() error { return s.StartOffloading(interval) },
+			msg:   fmt.Sprintf("offloading shards older than %s with check interval of %s", time.Duration(config.ColdStorage.OffloadAge), interval),
+		})
+	}
+
+	// Start checking for series that stop receiving points.
+	if len(config.Deadmans) > 0 {
+		m.Add("deadman", &loopService{start: func() error {
+			for _, c := range config.Deadmans {
+				interval := time.Duration(c.CheckInterval)
+				if interval == 0 {
+					interval = time.Duration(c.Threshold)
+				}
+				if err := s.StartDeadmanCheck(&influxdb.DeadmanCheck{
+					Database:    c.Database,
+					Measurement: c.Measurement,
+					Threshold:   time.Duration(c.Threshold),
+					WebhookURL:  c.Webhook,
+				}, interval); err != nil {
+					return err
+				}
 			}
-			go func() { log.Fatal(http.Serve(listener, sh)) }()
-		}
-		log.Printf("data node #%d listening on %s", s.ID(), config.DataAddr())
-
-		// Start the admin interface on the default port
-		if config.Admin.Enabled {
-			port := fmt.Sprintf(":%d", config.Admin.Port)
-			log.Printf("starting admin server on %s", port)
-			a := admin.NewServer(port)
-			go a.ListenAndServe()
-		}
+			return nil
+		}})
+	}
+
+	// Write the server's own statistics if requested.
+	if config.Monitoring.Enabled {
+		interval := time.Duration(config.Monitoring.WriteInterval)
+		m.Add("monitor", &loopService{
+			start: func() error {
+				return s.StartSelfMonitoring(influxdb.DefaultMonitorDatabase, influxdb.DefaultMonitorRetentionPolicyName, interval)
+			},
+			msg: fmt.Sprintf("writing server statistics to %s every %s", influxdb.DefaultMonitorDatabase, interval),
+		})
+	}
 
-		// Spin up the collectd server
-		if config.Collectd.Enabled {
-			c := config.Collectd
-			cs := collectd.NewServer(s, c.TypesDB)
+	// Start the server handler. Attach to broker if listening on the same port.
+	sh := newServerHandler(config, s, version, logWriter)
+	if b != nil {
+		sh.TruncatedSegments = b.TruncatedSegments
+	}
+	if h != nil && config.BrokerAddr() == config.DataAddr() {
+		m.Add("httpd", &httpService{config: config, addr: config.DataAddr(), handler: sh, attach: h}, "broker")
+	} else {
+		m.Add("httpd", &httpService{config: config, addr: config.DataAddr(), handler: sh})
+	}
+
+	// Start the admin interface on the default port
+	if config.Admin.Enabled {
+		m.Add("admin", &adminService{addr: fmt.Sprintf(":%d", config.Admin.Port)}, "httpd")
+	}
+
+	// Spin up the collectd server
+	if config.Collectd.Enabled {
+		c := config.Collectd
+		m.Add("collectd", &inputService{server: s, batch: c.Batch, listen: func(w *countingWriter) (func() error, error) {
+			cs := collectd.NewServer(w, c.TypesDB)
 			cs.Database = c.Database
-			err := collectd.ListenAndServe(cs, c.ConnectionString(config.BindAddress))
-			if err != nil {
-				log.Printf("failed to start collectd Server: %v\n", err.Error())
+			if err := collectd.ListenAndServe(cs, c.ConnectionString(config.BindAddress)); err != nil {
+				return nil, err
 			}
-		}
+			return cs.Close, nil
+		}})
+	}
 
-		// Start the server bound to a UDP listener
-		if config.UDP.Enabled {
-			log.Printf("Starting UDP listener on %s", config.DataAddrUDP())
-			u := udp.NewUDPServer(s)
-			if err := u.ListenAndServe(config.DataAddrUDP()); err != nil {
-				log.Printf("Failed to start UDP listener on %s: %s", config.DataAddrUDP(), err)
-			}
+	// Start the server bound to a UDP listener
+	if config.UDP.Enabled {
+		m.Add("udp", &inputService{server: s, batch: config.UDP.Batch, listen: func(w *countingWriter) (func() error, error) {
+			return nil, udp.NewUDPServer(w).ListenAndServe(config.DataAddrUDP())
+		}})
+	}
 
+	// Spin up any Graphite servers
+	for i, c := range config.Graphites {
+		if !c.Enabled {
+			continue
 		}
 
-		// Spin up any Graphite servers
-		for _, c := range config.Graphites {
-			if !c.Enabled {
-				continue
+		// Configure Graphite parsing.
+		parser := graphite.NewParser()
+		parser.Separator = c.NameSeparatorString()
+		parser.LastEnabled = c.LastEnabled()
+		for _, t := range c.Templates {
+			if err := parser.AddTemplate(t); err != nil {
+				log.Fatalf("invalid Graphite template: %s", err)
 			}
-
-			// Configure Graphite parsing.
-			parser := graphite.NewParser()
-			parser.Separator = c.NameSeparatorString()
-			parser.LastEnabled = c.LastEnabled()
-
-			// Start the relevant server.
-			if strings.ToLower(c.Protocol) == "tcp" {
-				g := graphite.NewTCPServer(parser, s)
+		}
+		tags, err := graphite.ParseTags(strings.Join(c.Tags, ","))
+		if err != nil {
+			log.Fatalf("invalid Graphite tags: %s", err)
+		}
+		parser.Tags = tags
+
+		// Start the relevant server.
+		c := c
+		m.Add(fmt.Sprintf("graphite%d", i), &inputService{server: s, batch: c.Batch, listen: func(w *countingWriter) (func() error, error) {
+			switch strings.ToLower(c.Protocol) {
+			case "tcp":
+				g := graphite.NewTCPServer(parser, w)
 				g.Database = c.Database
-				err := g.ListenAndServe(c.ConnectionString(config.BindAddress))
-				if err != nil {
-					log.Printf("failed to start TCP Graphite Server: %v\n", err.Error())
-				}
-			} else if strings.ToLower(c.Protocol) == "udp" {
-				g := graphite.NewUDPServer(parser, s)
+				return nil, g.ListenAndServe(c.ConnectionString(config.BindAddress))
+			case "udp":
+				g := graphite.NewUDPServer(parser, w)
 				g.Database = c.Database
-				err := g.ListenAndServe(c.ConnectionString(config.BindAddress))
-				if err != nil {
-					log.Printf("failed to start UDP Graphite Server: %v\n", err.Error())
-				}
-			} else {
-				log.Fatalf("unrecognized Graphite Server prototcol %s", c.Protocol)
+				return nil, g.ListenAndServe(c.ConnectionString(config.BindAddress))
+			default:
+				return nil, fmt.Errorf("unrecognized Graphite Server prototcol %s", c.Protocol)
 			}
-		}
+		}})
 	}
 
 	// unless disabled, start the loop to report anonymous usage stats every 24h
@@ -170,11 +243,45 @@ func Run(config *Config, join, version string, logWriter *os.File) (*messaging.B
 		// Make sure we have a config object b4 we try to use it.
 		if configObj := b.Broker.Log().Config(); configObj != nil {
 			clusterID := configObj.ClusterID
-			go s.StartReportingLoop(version, clusterID)
+			m.Add("reporting", &loopService{start: func() error {
+				go s.StartReportingLoop(version, clusterID)
+				return nil
+			}})
 		}
 	}
 
-	return b.Broker, s
+	// Tell the process manager once the node can serve traffic.
+	m.Add("ready", &readyService{server: s}, "httpd")
+}
+
+// newServerHandler returns the HTTP handler of a data node.
+func newServerHandler(config *Config, s *influxdb.Server, version string, logWriter io.Writer) *httpd.Handler {
+	sh := httpd.NewHandler(s, config.Authentication.Enabled, version)
+	sh.SetLogOutput(logWriter)
+	sh.WriteTrace = config.Logging.WriteTraceEnabled
+	sh.WriteTraceSampleN = config.Logging.WriteTraceSampleN
+	sh.WriteTraceMaxBytes = int(config.Logging.WriteTraceMaxBytes)
+	sh.WriteTraceDatabases = make(map[string]bool)
+	for _, name := range config.Logging.WriteTraceDatabases {
+		sh.WriteTraceDatabases[name] = true
+	}
+	sh.PprofEnabled = config.HTTPAPI.PprofEnabled
+	client.AllowLossyTimestamps = config.HTTPAPI.AllowLossyTimestamps
+	sh.SessionTimeout = time.Duration(config.HTTPAPI.SessionTimeout)
+	sh.UserTag = config.HTTPAPI.UserTag
+	sh.TagsHeaderEnabled = config.HTTPAPI.TagsHeaderEnabled
+	sh.MaxIndexWait = time.Duration(config.HTTPAPI.MaxIndexWait)
+	sh.UDFs = make(map[string]*pipeline.UDF)
+	for _, u := range config.UDFs {
+		sh.UDFs[u.Name] = &pipeline.UDF{Name: u.Name, Command: u.Command, Args: u.Args, Timeout: time.Duration(u.Timeout)}
+	}
+	if config.Authentication.ExemptRoutes != nil {
+		sh.AuthExemptions = make(map[string]bool)
+		for _, pattern := range config.Authentication.ExemptRoutes {
+			sh.AuthExemptions[pattern] = true
+		}
+	}
+	return sh
 }
 
 // write the current process id to a file specified by path.
@@ -198,18 +305,22 @@ func writePIDFile(path string) {
 
 // parses the configuration from a given path. Sets overrides as needed.
 func parseConfig(path, hostname string) *Config {
+	var config *Config
 	if path == "" {
 		log.Println("No config provided, using default settings")
-		return NewConfig()
+		config = NewConfig()
+	} else {
+		// Parse configuration.
+		var err error
+		if config, err = ParseConfigFile(path); err != nil {
+			log.Fatalf("config: %s", err)
+		}
 	}
 
-	// Parse configuration.
-	config, err := ParseConfigFile(path)
-	if err != nil {
+	// Override config properties.
+	if err := config.ApplyEnvOverrides(); err != nil {
 		log.Fatalf("config: %s", err)
 	}
-
-	// Override config properties.
 	if hostname != "" {
 		config.Hostname = hostname
 	}
@@ -218,12 +329,15 @@ func parseConfig(path, hostname string) *Config {
 }
 
 // creates and initializes a broker.
-func openBroker(path string, u *url.URL, initializing bool, joinURLs []*url.URL, w io.Writer) *influxdb.Broker {
+func openBroker(config *Config, initializing bool, joinURLs []*url.URL, w io.Writer) *influxdb.Broker {
 	// Create broker.
 	b := influxdb.NewBroker()
 	b.SetLogOutput(w)
+	b.MaxSegmentSize = int64(config.Broker.MaxSegmentSize)
+	b.TruncationInterval = time.Duration(config.Broker.TruncationInterval)
+	b.TruncationWindow = config.Broker.TruncationWindow
 
-	if err := b.Open(path, u); err != nil {
+	if err := b.Open(config.BrokerDir(), config.BrokerURL()); err != nil {
 		log.Fatalf("failed to open broker: %s", err)
 	}
 
@@ -272,6 +386,61 @@ func openServer(config *Config, b *influxdb.Broker, initServer, initBroker, conf
 	s.RecomputeNoOlderThan = time.Duration(config.ContinuousQuery.RecomputeNoOlderThan)
 	s.ComputeRunsPerInterval = config.ContinuousQuery.ComputeRunsPerInterval
 	s.ComputeNoMoreThan = time.Duration(config.ContinuousQuery.ComputeNoMoreThan)
+	s.BackfillThreshold = time.Duration(config.Data.BackfillThreshold)
+	s.ChecksumPolicy = config.Data.ChecksumPolicy
+	s.MaxFutureTime = time.Duration(config.Data.MaxFutureTime)
+	s.MigrationBackup = config.Data.MigrationBackup
+	s.Compactor.SetConcurrency(config.Data.CompactionConcurrency)
+	s.IO.SetThroughput(influxdb.IOClassCompaction, int64(config.Data.CompactionThroughput))
+	s.IO.SetThroughput(influxdb.IOClassRetention, int64(config.Data.RetentionThroughput))
+	s.IO.SetThroughput(influxdb.IOClassBackup, int64(config.Data.BackupThroughput))
+	s.IO.SetThroughput("", int64(config.Data.IOThroughput))
+	s.MaxQueryMemory = int64(config.Query.MaxMemory)
+	s.QueryMemory.SetLimit(int64(config.Query.MaxTotalMemory))
+	if config.Query.SpillToDisk {
+		s.QuerySpillDir = config.QuerySpillDir()
+	}
+	s.MaxGroups = config.Query.MaxGroups
+	s.TruncateGroups = config.Query.TruncateGroups
+	s.MaxQueryTimeout = time.Duration(config.Query.MaxTimeout)
+	s.WriteStats.ByMeasurement = config.Monitoring.WriteStatsByMeasurement
+	for _, q := range config.Quotas {
+		s.Quotas.SetQuota(influxdb.Quota{
+			Database:             q.Database,
+			MaxSeries:            q.MaxSeries,
+			MaxWriteRate:         q.MaxWriteRate,
+			MaxConcurrentQueries: q.MaxConcurrentQueries,
+			MaxDiskBytes:         int64(q.MaxDiskSize),
+		})
+	}
+
+	if c := config.ColdStorage; c.Enabled {
+		if c.Dir != "" {
+			s.ColdStore = &objectstore.Dir{Path: c.Dir}
+		} else {
+			s.ColdStore = &objectstore.S3{
+				Endpoint:        c.Endpoint,
+				Region:          c.Region,
+				Bucket:          c.Bucket,
+				Prefix:          c.Prefix,
+				AccessKeyID:     c.AccessKeyID,
+				SecretAccessKey: c.SecretAccessKey,
+			}
+		}
+		s.OffloadAge = time.Duration(c.OffloadAge)
+		s.ColdCacheTTL = time.Duration(c.CacheTTL)
+	}
+	for _, r := range config.Rollups {
+		s.Rollups = append(s.Rollups, &influxdb.Rollup{
+			Database:              r.Database,
+			RetentionPolicy:       r.RetentionPolicy,
+			Measurement:           r.Measurement,
+			Interval:              time.Duration(r.Interval),
+			TargetRetentionPolicy: r.TargetRetentionPolicy,
+			TargetMeasurement:     r.TargetMeasurement,
+			Functions:             r.Functions,
+		})
+	}
 
 	if err := s.Open(config.Data.Dir); err != nil {
 		log.Fatalf("failed to open data server: %v", err.Error())
@@ -391,6 +560,10 @@ run starts the broker and data node server. If this is the first time running
 the command then a new cluster will be initialized unless the -join argument
 is used.
 
+When started by systemd with Type=notify, or as a Windows service, the node
+is reported as started once it has caught up with the broker and can serve
+traffic. It shuts down cleanly on SIGINT, SIGTERM or a service stop request.
+
         -config <path>
                           Set the path to the configuration file.
 
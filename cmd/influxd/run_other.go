@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "os"
+
+// runNode starts a node and blocks until it receives a signal to stop.
+func runNode(config *Config, join string, logWriter *os.File) {
+	b, s, m := Run(config, join, version, logWriter)
+	n := &node{broker: b, server: s, services: m}
+	waitForSignal()
+	n.close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// windowsServiceName is the name of the service registered with the Windows
+// service control manager.
+const windowsServiceName = "influxdb"
+
+// runNode starts a node and blocks until it is stopped. When started by the
+// Windows service control manager the node is reported as running once it
+// can serve traffic and is stopped by the manager. Otherwise it stops on a
+// signal.
+func runNode(config *Config, join string, logWriter *os.File) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Fatalf("service detection error: %s", err)
+	} else if !isService {
+		b, s, m := Run(config, join, version, logWriter)
+		n := &node{broker: b, server: s, services: m}
+		waitForSignal()
+		n.close()
+		return
+	}
+
+	if err := svc.Run(windowsServiceName, &windowsService{config: config, join: join, logWriter: logWriter}); err != nil {
+		log.Fatalf("service error: %s", err)
+	}
+}
+
+// windowsService runs a node under the Windows service control manager.
+type windowsService struct {
+	config    *Config
+	join      string
+	logWriter *os.File
+}
+
+// Execute starts the node, reports it as running once it can serve traffic
+// and closes it when the service control manager asks it to stop.
+func (ws *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	b, s, m := Run(ws.config, ws.join, version, ws.logWriter)
+	n := &node{broker: b, server: s, services: m}
+
+	// Report the service as running once the node has caught up.
+	ready := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		if waitReady(s, done) {
+			close(ready)
+		}
+	}()
+
+	for {
+		select {
+		case <-ready:
+			status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+			ready = nil
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				n.close()
+				return false, 0
+			}
+		}
+	}
+}
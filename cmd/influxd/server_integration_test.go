@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -91,7 +92,7 @@ func createCombinedNodeCluster(t *testing.T, testName, tmpDir string, nNodes, ba
 	c.Admin.Enabled = false
 	c.ReportingDisabled = true
 
-	b, s := main.Run(c, "", "x.x", os.Stderr)
+	b, s, _ := main.Run(c, "", "x.x", os.Stderr)
 	if b == nil {
 		t.Fatalf("Test %s: failed to create broker on port %d", testName, basePort)
 	}
@@ -113,7 +114,7 @@ func createCombinedNodeCluster(t *testing.T, testName, tmpDir string, nNodes, ba
 		c.Broker.Port = nextPort
 		c.Data.Port = nextPort
 
-		b, s := main.Run(c, "http://localhost:"+strconv.Itoa(basePort), "x.x", os.Stderr)
+		b, s, _ := main.Run(c, "http://localhost:"+strconv.Itoa(basePort), "x.x", os.Stderr)
 		if b == nil {
 			t.Fatalf("Test %s: failed to create following broker on port %d", testName, basePort)
 		}
@@ -423,6 +424,26 @@ func runTestsData(t *testing.T, testName string, nodes Cluster, database, retent
 			queryDb:  "%DB%",
 			expected: `{"results":[{"series":[{"name":"measurements","columns":["name"],"values":[["cpu"]]}]}]}`,
 		},
+		{
+			query:    "SHOW MEASUREMENTS WHERE region = 'caeast' OR host = 'server01'",
+			queryDb:  "%DB%",
+			expected: `{"results":[{"series":[{"name":"measurements","columns":["name"],"values":[["cpu"],["gpu"],["other"]]}]}]}`,
+		},
+		{
+			query:    "SHOW MEASUREMENTS WITH MEASUREMENT =~ /pu$/",
+			queryDb:  "%DB%",
+			expected: `{"results":[{"series":[{"name":"measurements","columns":["name"],"values":[["cpu"],["gpu"]]}]}]}`,
+		},
+		{
+			query:    "SHOW MEASUREMENTS WITH MEASUREMENT != cpu",
+			queryDb:  "%DB%",
+			expected: `{"results":[{"series":[{"name":"measurements","columns":["name"],"values":[["gpu"],["other"]]}]}]}`,
+		},
+		{
+			query:    "SHOW MEASUREMENTS WITH MEASUREMENT =~ /u/ WHERE region = 'useast' LIMIT 1 OFFSET 1",
+			queryDb:  "%DB%",
+			expected: `{"results":[{"series":[{"name":"measurements","columns":["name"],"values":[["gpu"]]}]}]}`,
+		},
 
 		{
 			reset: true,
@@ -501,12 +522,12 @@ func runTestsData(t *testing.T, testName string, nodes Cluster, database, retent
 		]}`,
 			query:    `SHOW FIELD KEYS`,
 			queryDb:  "%DB%",
-			expected: `{"results":[{"series":[{"name":"cpu","columns":["fieldKey"],"values":[["field1"],["field2"],["field3"]]},{"name":"gpu","columns":["fieldKey"],"values":[["field4"],["field5"],["field6"],["field7"]]}]}]}`,
+			expected: `{"results":[{"series":[{"name":"cpu","columns":["fieldKey","fieldType","unit","description","displayName"],"values":[["field1","number","","",""],["field2","number","","",""],["field3","number","","",""]]},{"name":"gpu","columns":["fieldKey","fieldType","unit","description","displayName"],"values":[["field4","number","","",""],["field5","number","","",""],["field6","number","","",""],["field7","number","","",""]]}]}]}`,
 		},
 		{
 			query:    `SHOW FIELD KEYS FROM cpu`,
 			queryDb:  "%DB%",
-			expected: `{"results":[{"series":[{"name":"cpu","columns":["fieldKey"],"values":[["field1"],["field2"],["field3"]]}]}]}`,
+			expected: `{"results":[{"series":[{"name":"cpu","columns":["fieldKey","fieldType","unit","description","displayName"],"values":[["field1","number","","",""],["field2","number","","",""],["field3","number","","",""]]}]}]}`,
 		},
 
 		// User control tests
@@ -654,3 +675,40 @@ func Test3NodeServer(t *testing.T) {
 
 	runTestsData(t, testName, nodes, "mydb", "myrp")
 }
+
+// Ensure a node tells systemd it is ready once it can serve traffic.
+func TestSingleServer_NotifyReady(t *testing.T) {
+	testName := "single server notify"
+	if testing.Short() {
+		t.Skip(fmt.Sprintf("skipping '%s'", testName))
+	}
+	dir := tempfile()
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	// Listen on the notify socket as systemd would.
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "notify"), Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", addr.Name)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	nodes := createCombinedNodeCluster(t, testName, dir, 1, 8290)
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if n, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf[:n]) != "READY=1" {
+		t.Fatalf("unexpected state: %q", buf[:n])
+	} else if !nodes[0].server.Ready() {
+		t.Fatal("node notified ready before it could serve traffic")
+	}
+}
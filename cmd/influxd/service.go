@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+// Service is a subsystem of a node, such as the HTTP API, an input or a
+// background loop, that is opened and closed by a ServiceManager.
+type Service interface {
+	// Open starts the service and returns once it is ready.
+	Open() error
+
+	// Close stops the service.
+	Close() error
+
+	// WithLogger sets the logger the service writes to.
+	WithLogger(l *log.Logger)
+
+	// Statistics returns counters of the service's activity.
+	Statistics() map[string]interface{}
+}
+
+// ServiceManager opens services after the services they depend on and closes
+// them in the reverse order.
+type ServiceManager struct {
+	services map[string]*managedService
+	names    []string // service names in the order added
+	opened   []string // service names in the order opened
+
+	logOutput io.Writer
+}
+
+// managedService is a service and the names of the services it depends on.
+type managedService struct {
+	service Service
+	deps    []string
+	opened  bool
+}
+
+// NewServiceManager returns a new instance of ServiceManager. Each service
+// logs to w with its name as a prefix.
+func NewServiceManager(w io.Writer) *ServiceManager {
+	return &ServiceManager{
+		services:  make(map[string]*managedService),
+		logOutput: w,
+	}
+}
+
+// Add adds a service with the names of the services that must be opened
+// before it. The service is opened by the next call to Open.
+func (m *ServiceManager) Add(name string, s Service, deps ...string) {
+	if _, ok := m.services[name]; !ok {
+		m.names = append(m.names, name)
+	}
+	m.services[name] = &managedService{service: s, deps: deps}
+}
+
+// Open opens each service that isn't open yet after its dependencies.
+// Services without dependencies between them are opened in the order they
+// were added. If a service fails to open then every open service is closed.
+func (m *ServiceManager) Open() error {
+	names, err := m.order()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		ms := m.services[name]
+		if ms.opened {
+			continue
+		}
+		ms.service.WithLogger(log.New(m.logOutput, "["+name+"] ", log.LstdFlags))
+		if err := ms.service.Open(); err != nil {
+			_ = m.Close()
+			return fmt.Errorf("open %s: %s", name, err)
+		}
+		ms.opened = true
+		m.opened = append(m.opened, name)
+	}
+	return nil
+}
+
+// order returns the service names sorted so each service follows its
+// dependencies. Returns an error if a dependency is missing or circular.
+func (m *ServiceManager) order() ([]string, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	a := make([]string, 0, len(m.names))
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("service %s depends on itself", name)
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range m.services[name].deps {
+			if m.services[dep] == nil {
+				return fmt.Errorf("service %s depends on unknown service %s", name, dep)
+			} else if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		a = append(a, name)
+		return nil
+	}
+
+	for _, name := range m.names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Close closes the open services in the reverse order they were opened.
+// Returns the first error.
+func (m *ServiceManager) Close() error {
+	var err error
+	for i := len(m.opened) - 1; i >= 0; i-- {
+		name := m.opened[i]
+		ms := m.services[name]
+		if e := ms.service.Close(); e != nil && err == nil {
+			err = fmt.Errorf("close %s: %s", name, e)
+		}
+		ms.opened = false
+	}
+	m.opened = nil
+	return err
+}
+
+// Opened returns the names of the open services in the order they were opened.
+func (m *ServiceManager) Opened() []string {
+	return append([]string(nil), m.opened...)
+}
+
+// Statistics returns the statistics of each open service by name.
+func (m *ServiceManager) Statistics() map[string]map[string]interface{} {
+	stats := make(map[string]map[string]interface{})
+	for _, name := range m.opened {
+		if s := m.services[name].service.Statistics(); s != nil {
+			stats[name] = s
+		}
+	}
+	return stats
+}
@@ -0,0 +1,112 @@
+package main_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+
+	main "github.com/influxdb/influxdb/cmd/influxd"
+)
+
+// Ensure services are opened after their dependencies and closed in reverse.
+func TestServiceManager_Open(t *testing.T) {
+	var events []string
+	m := main.NewServiceManager(ioutil.Discard)
+	m.Add("admin", NewTestService("admin", &events), "httpd")
+	m.Add("httpd", NewTestService("httpd", &events), "broker")
+	m.Add("broker", NewTestService("broker", &events))
+	m.Add("udp", NewTestService("udp", &events))
+
+	if err := m.Open(); err != nil {
+		t.Fatal(err)
+	} else if a := m.Opened(); !reflect.DeepEqual(a, []string{"broker", "httpd", "admin", "udp"}) {
+		t.Fatalf("unexpected open order: %v", a)
+	} else if stats := m.Statistics(); stats["udp"]["opened"] != 1 {
+		t.Fatalf("unexpected statistics: %v", stats)
+	}
+
+	// Services added later are opened without reopening the others.
+	m.Add("graphite0", NewTestService("graphite0", &events), "broker")
+	if err := m.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	} else if exp := []string{
+		"open broker", "open httpd", "open admin", "open udp", "open graphite0",
+		"close graphite0", "close udp", "close admin", "close httpd", "close broker",
+	}; !reflect.DeepEqual(events, exp) {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
+
+// Ensure services already opened are closed if a service fails to open.
+func TestServiceManager_Open_Error(t *testing.T) {
+	var events []string
+	m := main.NewServiceManager(ioutil.Discard)
+	m.Add("broker", NewTestService("broker", &events))
+	bad := NewTestService("httpd", &events)
+	bad.err = errors.New("address in use")
+	m.Add("httpd", bad, "broker")
+
+	if err := m.Open(); err == nil || err.Error() != "open httpd: address in use" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(events, []string{"open broker", "open httpd", "close broker"}) {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
+
+// Ensure missing and circular dependencies are rejected.
+func TestServiceManager_Open_InvalidDependencies(t *testing.T) {
+	var events []string
+	m := main.NewServiceManager(ioutil.Discard)
+	m.Add("httpd", NewTestService("httpd", &events), "broker")
+	if err := m.Open(); err == nil || err.Error() != "service httpd depends on unknown service broker" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m = main.NewServiceManager(ioutil.Discard)
+	m.Add("a", NewTestService("a", &events), "b")
+	m.Add("b", NewTestService("b", &events), "a")
+	if err := m.Open(); err == nil || err.Error() != "service a depends on itself" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if len(events) != 0 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
+
+// TestService records when it is opened and closed.
+type TestService struct {
+	name   string
+	events *[]string
+	err    error // returned by Open
+	logger *log.Logger
+	opened int
+}
+
+// NewTestService returns a new instance of TestService appending to events.
+func NewTestService(name string, events *[]string) *TestService {
+	return &TestService{name: name, events: events}
+}
+
+func (s *TestService) Open() error {
+	*s.events = append(*s.events, "open "+s.name)
+	if s.err == nil {
+		s.opened++
+	}
+	return s.err
+}
+
+func (s *TestService) Close() error {
+	*s.events = append(*s.events, "close "+s.name)
+	return nil
+}
+
+func (s *TestService) WithLogger(l *log.Logger) { s.logger = l }
+
+func (s *TestService) Statistics() map[string]interface{} {
+	return map[string]interface{}{"opened": s.opened}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/admin"
+	"github.com/influxdb/influxdb/batcher"
+)
+
+// baseService holds the logger of a service and reports no statistics.
+// It is embedded by the services started by Run.
+type baseService struct {
+	logger *log.Logger
+}
+
+// WithLogger sets the logger the service writes to.
+func (s *baseService) WithLogger(l *log.Logger) { s.logger = l }
+
+// Statistics returns nil as the service keeps no counters.
+func (s *baseService) Statistics() map[string]interface{} { return nil }
+
+// httpService serves a handler on its own listener, or through the broker's
+// handler if attach is set.
+type httpService struct {
+	baseService
+	config  *Config
+	addr    string
+	handler http.Handler
+	attach  *Handler // broker handler sharing its port, if any
+
+	listener net.Listener
+	closing  int32  // set once Close is called
+	requests uint64 // requests served
+}
+
+// Open starts serving requests.
+func (s *httpService) Open() error {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&s.requests, 1)
+		s.handler.ServeHTTP(w, r)
+	})
+
+	if s.attach != nil {
+		s.attach.serverHandler = h
+		s.logger.Printf("listening on %s", s.addr)
+		return nil
+	}
+
+	// Listen before returning so the service is ready once it is open.
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	go func() {
+		if err := serveHTTP(s.config, l, h); atomic.LoadInt32(&s.closing) == 0 {
+			log.Fatal(err)
+		}
+	}()
+	s.logger.Printf("listening on %s", s.addr)
+	return nil
+}
+
+// Close stops accepting connections.
+func (s *httpService) Close() error {
+	atomic.StoreInt32(&s.closing, 1)
+	if s.attach != nil {
+		s.attach.serverHandler = nil
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// Statistics returns the number of requests served.
+func (s *httpService) Statistics() map[string]interface{} {
+	return map[string]interface{}{"requests": atomic.LoadUint64(&s.requests)}
+}
+
+// loopService starts a background loop of the server. The loops are stopped
+// when the server is closed.
+type loopService struct {
+	baseService
+	start func() error
+	msg   string // logged once started
+}
+
+// Open starts the loop.
+func (s *loopService) Open() error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if s.msg != "" {
+		s.logger.Print(s.msg)
+	}
+	return nil
+}
+
+// Close does nothing as the loop stops with the server.
+func (s *loopService) Close() error { return nil }
+
+// continuousQueryService has the broker ask data nodes to run continuous queries.
+type continuousQueryService struct {
+	baseService
+	broker *influxdb.Broker
+}
+
+// Open starts the continuous query loop.
+func (s *continuousQueryService) Open() error {
+	s.broker.RunContinuousQueryLoop()
+	return nil
+}
+
+// Close stops the continuous query loop.
+func (s *continuousQueryService) Close() error {
+	s.broker.StopContinuousQueryLoop()
+	return nil
+}
+
+// adminService serves the admin interface.
+type adminService struct {
+	baseService
+	addr   string
+	server *admin.Server
+}
+
+// Open starts the admin server.
+func (s *adminService) Open() error {
+	s.server = admin.NewServer(s.addr)
+	go s.server.ListenAndServe()
+	s.logger.Printf("listening on %s", s.addr)
+	return nil
+}
+
+// Close stops the admin server.
+func (s *adminService) Close() error {
+	s.server.Close()
+	return nil
+}
+
+// inputService receives points from an input, such as collectd or Graphite,
+// and writes them to the server in batches.
+type inputService struct {
+	baseService
+	server *influxdb.Server
+	batch  Batch
+
+	// Starts the input writing to w. The returned function, if any, stops
+	// the input. Inputs without one listen until the process exits.
+	listen func(w *countingWriter) (close func() error, err error)
+
+	batcher *batcher.Batcher
+	close   func() error
+	writer  *countingWriter
+}
+
+// Open opens the batcher and starts the input.
+func (s *inputService) Open() error {
+	s.batcher = s.batch.NewBatcher(s.server)
+	if err := s.batcher.Open(); err != nil {
+		return err
+	}
+	s.writer = &countingWriter{w: s.batcher}
+
+	close, err := s.listen(s.writer)
+	if err != nil {
+		_ = s.batcher.Close()
+		return err
+	}
+	s.close = close
+	return nil
+}
+
+// Close stops the input, if it can be stopped, and flushes the batcher.
+func (s *inputService) Close() error {
+	if s.close != nil {
+		if err := s.close(); err != nil {
+			return err
+		}
+	}
+	return s.batcher.Close()
+}
+
+// Statistics returns the number of points received and failed writes.
+func (s *inputService) Statistics() map[string]interface{} {
+	return map[string]interface{}{
+		"pointsReceived": atomic.LoadUint64(&s.writer.points),
+		"writeErrors":    atomic.LoadUint64(&s.writer.errors),
+	}
+}
+
+// countingWriter counts the points written through it.
+type countingWriter struct {
+	w      batcher.SeriesWriter
+	points uint64
+	errors uint64
+}
+
+// WriteSeries writes points to the underlying writer.
+func (w *countingWriter) WriteSeries(database, retentionPolicy string, points []influxdb.Point) (uint64, error) {
+	atomic.AddUint64(&w.points, uint64(len(points)))
+	index, err := w.w.WriteSeries(database, retentionPolicy, points)
+	if err != nil {
+		atomic.AddUint64(&w.errors, 1)
+	}
+	return index, err
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// execShard runs the "shard" command.
+func execShard(args []string) {
+	var cmd string
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "export":
+		execShardExport(args)
+	case "import":
+		execShardImport(args)
+	default:
+		printShardUsage()
+		os.Exit(2)
+	}
+}
+
+// execShardExport runs the "shard export" command.
+func execShardExport(args []string) {
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host     = fs.String("host", "http://localhost:8086", "")
+		id       = fs.Uint64("id", 0, "")
+		out      = fs.String("out", "", "")
+		username = fs.String("username", "", "")
+		password = fs.String("password", "", "")
+	)
+	fs.Usage = printShardUsage
+	fs.Parse(args)
+	if *id == 0 {
+		log.Fatal("shard export: shard id required")
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("shard export: %s", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := exportShard(*host, *id, *username, *password, w); err != nil {
+		log.Fatalf("shard export: %s", err)
+	}
+}
+
+// execShardImport runs the "shard import" command.
+func execShardImport(args []string) {
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		host     = fs.String("host", "http://localhost:8086", "")
+		in       = fs.String("in", "", "")
+		database = fs.String("database", "", "")
+		policy   = fs.String("retention-policy", "", "")
+		username = fs.String("username", "", "")
+		password = fs.String("password", "", "")
+	)
+	fs.Usage = printShardUsage
+	fs.Parse(args)
+
+	r := os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Fatalf("shard import: %s", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	n, err := importShard(*host, *database, *policy, *username, *password, r)
+	if err != nil {
+		log.Fatalf("shard import: %s", err)
+	}
+	log.Printf("imported %d points", n)
+}
+
+// exportShard writes the archive of a shard served by host to w.
+func exportShard(host string, id uint64, username, password string, w io.Writer) error {
+	u, err := shardURL(host, fmt.Sprintf("/shards/%d/export", id), username, password)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := shardResponseError(resp); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// importShard sends a shard archive read from r to host. The database and
+// retention policy the shard was exported from are used if they are blank.
+func importShard(host, database, policy, username, password string, r io.Reader) (int, error) {
+	u, err := shardURL(host, "/shards/import", username, password)
+	if err != nil {
+		return 0, err
+	}
+	q := u.Query()
+	if database != "" {
+		q.Set("db", database)
+	}
+	if policy != "" {
+		q.Set("rp", policy)
+	}
+	u.RawQuery = q.Encode()
+
+	resp, err := http.Post(u.String(), "application/octet-stream", r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := shardResponseError(resp); err != nil {
+		return 0, err
+	}
+
+	var body struct {
+		Points int `json:"points"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return body.Points, nil
+}
+
+// shardURL returns the URL of an endpoint on host with the credentials set.
+func shardURL(host, path, username, password string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(host, "/") + path)
+	if err != nil {
+		return nil, err
+	}
+	if username != "" {
+		q := u.Query()
+		q.Set("u", username)
+		q.Set("p", password)
+		u.RawQuery = q.Encode()
+	}
+	return u, nil
+}
+
+// shardResponseError returns the error in an unsuccessful response.
+func shardResponseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var body struct {
+		Err string `json:"error"`
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(b, &body); err == nil && body.Err != "" {
+		return fmt.Errorf("%s: %s", resp.Status, body.Err)
+	}
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
+
+func printShardUsage() {
+	log.Printf(`usage: shard export [flags] | shard import [flags]
+
+shard export writes a portable archive of a shard stored on a data node.
+shard import writes the points of an archive into a database on any cluster.
+
+export flags:
+
+        -host <url>
+                          The data node's API. Defaults to http://localhost:8086.
+
+        -id <id>
+                          The id of the shard, as listed by SHOW SHARDS.
+
+        -out <path>
+                          Write the archive to a file instead of stdout.
+
+import flags:
+
+        -host <url>
+                          The data node's API. Defaults to http://localhost:8086.
+
+        -in <path>
+                          Read the archive from a file instead of stdin.
+
+        -database <name>
+                          The database to import into. Defaults to the one exported from.
+
+        -retention-policy <name>
+                          The retention policy to import into. Defaults to the one exported from.
+
+common flags:
+
+        -username <name>
+        -password <password>
+                          Credentials of an admin user if authentication is enabled.
+`)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/influxdb/influxdb"
+)
+
+// execVerify runs the "verify" command.
+func execVerify(args []string) {
+	fs := flag.NewFlagSet("", flag.ExitOnError)
+	var (
+		configPath = fs.String("config", "", "")
+		repair     = fs.Bool("repair", false, "")
+	)
+	fs.Usage = printVerifyUsage
+	fs.Parse(args)
+
+	config := parseConfig(*configPath, "")
+	r, err := influxdb.Verify(config.DataDir(), *repair)
+	if err != nil {
+		log.Fatalf("verify: %s", err)
+	}
+
+	for _, p := range r.Problems {
+		fmt.Println(p)
+	}
+	for _, p := range r.Repairs {
+		fmt.Println("repaired:", p)
+	}
+	fmt.Printf("checked %d shards, %d series, %d points: %d problems, %d repairs\n", r.Shards, r.Series, r.Points, len(r.Problems), len(r.Repairs))
+
+	if !r.OK() {
+		os.Exit(1)
+	}
+}
+
+func printVerifyUsage() {
+	log.Printf(`usage: verify [flags]
+
+verify checks the data directory of a stopped data node. The pages of the
+metastore and of each shard are checked, the series index of each database
+is decoded and every point is checked against its checksum and decoded
+against the index. Each problem found is printed and the exit status is 1
+if there were any.
+
+        -config <path>
+                          Set the path to the configuration file.
+
+        -repair
+                          Rebuild the series index: entries that can't be
+                          decoded are removed and the series id sequence is
+                          advanced past every series id found in the shards.
+                          Shards are never modified.
+`)
+}
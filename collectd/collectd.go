@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"sync"
 	"time"
@@ -154,6 +155,11 @@ func Unmarshal(data *gollectd.Packet) []influxdb.Point {
 
 	var points []influxdb.Point
 	for i := range data.Values {
+		// Collectd sends NaN for unknown values. These are skipped.
+		if v := data.Values[i].Value; math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+
 		name := fmt.Sprintf("%s_%s", data.Plugin, data.Values[i].Name)
 		tags := make(map[string]string)
 		fields := make(map[string]interface{})
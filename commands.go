@@ -9,12 +9,18 @@ import (
 
 const (
 	// Data node messages
-	createDataNodeMessageType = messaging.MessageType(0x00)
-	deleteDataNodeMessageType = messaging.MessageType(0x01)
+	createDataNodeMessageType    = messaging.MessageType(0x00)
+	deleteDataNodeMessageType    = messaging.MessageType(0x01)
+	dataNodeHeartbeatMessageType = messaging.MessageType(0x02)
+	setDataNodeLabelsMessageType = messaging.MessageType(0x03)
 
 	// Database messages
 	createDatabaseMessageType = messaging.MessageType(0x10)
 	dropDatabaseMessageType   = messaging.MessageType(0x11)
+	renameDatabaseMessageType = messaging.MessageType(0x12)
+	cloneDatabaseMessageType  = messaging.MessageType(0x13)
+	setWriteLimitMessageType  = messaging.MessageType(0x14)
+	setNameRulesMessageType   = messaging.MessageType(0x15)
 
 	// Retention policy messages
 	createRetentionPolicyMessageType     = messaging.MessageType(0x20)
@@ -27,20 +33,35 @@ const (
 	updateUserMessageType = messaging.MessageType(0x31)
 	deleteUserMessageType = messaging.MessageType(0x32)
 
+	// API token messages
+	createTokenMessageType = messaging.MessageType(0x33)
+	deleteTokenMessageType = messaging.MessageType(0x34)
+
 	// Shard messages
 	createShardGroupIfNotExistsMessageType = messaging.MessageType(0x40)
 	deleteShardGroupMessageType            = messaging.MessageType(0x41)
+	dropShardMessageType                   = messaging.MessageType(0x42)
+	truncateShardGroupsMessageType         = messaging.MessageType(0x43)
 
 	// Series messages
-	dropSeriesMessageType = messaging.MessageType(0x50)
+	dropSeriesMessageType       = messaging.MessageType(0x50)
+	updateSeriesTagsMessageType = messaging.MessageType(0x51)
 
 	// Measurement messages
 	createMeasurementsIfNotExistsMessageType = messaging.MessageType(0x60)
 	dropMeasurementMessageType               = messaging.MessageType(0x61)
+	updateFieldMessageType                   = messaging.MessageType(0x62)
+	setMeasurementTTLMessageType             = messaging.MessageType(0x63)
+	updateMetadataMessageType                = messaging.MessageType(0x64)
+	fieldConvertedMessageType                = messaging.MessageType(0x65)
 
 	// Continuous Query messages
 	createContinuousQueryMessageType = messaging.MessageType(0x70)
 
+	// Stored query messages
+	createStoredQueryMessageType = messaging.MessageType(0x71)
+	dropStoredQueryMessageType   = messaging.MessageType(0x72)
+
 	// Write series data messages (per-topic)
 	writeRawSeriesMessageType = messaging.MessageType(0x80)
 
@@ -56,14 +77,51 @@ type deleteDataNodeCommand struct {
 	ID uint64 `json:"id"`
 }
 
+type dataNodeHeartbeatCommand struct {
+	ID       uint64        `json:"id"`
+	Time     time.Time     `json:"time"`
+	Interval time.Duration `json:"interval"`
+	DiskFree uint64        `json:"diskFree,omitempty"`
+}
+
+type setDataNodeLabelsCommand struct {
+	ID     uint64            `json:"id"`
+	Labels map[string]string `json:"labels"`
+}
+
 type createDatabaseCommand struct {
 	Name string `json:"name"`
+
+	// Retention policy created with the database and set as its default.
+	RetentionPolicy *RetentionPolicy `json:"retentionPolicy,omitempty"`
 }
 
 type dropDatabaseCommand struct {
 	Name string `json:"name"`
 }
 
+type renameDatabaseCommand struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName"`
+}
+
+type setWriteLimitCommand struct {
+	Database string `json:"database"`
+	Limit    int    `json:"limit"`
+	Burst    int    `json:"burst,omitempty"`
+}
+
+type setNameRulesCommand struct {
+	Database string     `json:"database"`
+	Rules    *NameRules `json:"rules,omitempty"`
+}
+
+type cloneDatabaseCommand struct {
+	Name    string `json:"name"`
+	NewName string `json:"newName"`
+	Data    bool   `json:"data,omitempty"`
+}
+
 type createShardGroupIfNotExistsCommand struct {
 	Database  string    `json:"database"`
 	Policy    string    `json:"policy"`
@@ -74,6 +132,12 @@ type deleteShardGroupCommand struct {
 	Policy   string `json:"policy"`
 	ID       uint64 `json:"id"`
 }
+type dropShardCommand struct {
+	ID uint64 `json:"id"`
+}
+type truncateShardGroupsCommand struct {
+	Timestamp time.Time `json:"timestamp"`
+}
 type createUserCommand struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -88,17 +152,33 @@ type updateUserCommand struct {
 type deleteUserCommand struct {
 	Username string `json:"username"`
 }
+
+// createTokenCommand carries the hash of a token's secret, never the secret.
+type createTokenCommand struct {
+	Name       string                        `json:"name"`
+	Hash       string                        `json:"hash"`
+	Admin      bool                          `json:"admin,omitempty"`
+	Privileges map[string]influxql.Privilege `json:"privileges,omitempty"`
+	CreatedAt  time.Time                     `json:"createdAt"`
+}
+
+type deleteTokenCommand struct {
+	Name string `json:"name"`
+}
 type setPrivilegeCommand struct {
-	Privilege influxql.Privilege `json:"privilege"`
-	Username  string             `json:"username"`
-	Database  string             `json:"database"`
+	Privilege    influxql.Privilege `json:"privilege"`
+	Username     string             `json:"username"`
+	Database     string             `json:"database"`
+	Measurements *MeasurementFilter `json:"measurements,omitempty"`
 }
 type createRetentionPolicyCommand struct {
-	Database string        `json:"database"`
-	Name     string        `json:"name"`
-	Duration time.Duration `json:"duration"`
-	ReplicaN uint32        `json:"replicaN"`
-	SplitN   uint32        `json:"splitN"`
+	Database   string        `json:"database"`
+	Name       string        `json:"name"`
+	Duration   time.Duration `json:"duration"`
+	ReplicaN   uint32        `json:"replicaN"`
+	SplitN     uint32        `json:"splitN"`
+	Duplicates string        `json:"duplicates,omitempty"`
+	Force      bool          `json:"force,omitempty"`
 }
 type updateRetentionPolicyCommand struct {
 	Database string                 `json:"database"`
@@ -120,6 +200,36 @@ type dropMeasurementCommand struct {
 	Name     string `json:"name"`
 }
 
+type updateFieldCommand struct {
+	Database    string            `json:"database"`
+	Measurement string            `json:"measurement"`
+	Name        string            `json:"name"`
+	Type        influxql.DataType `json:"type"`
+}
+
+type fieldConvertedCommand struct {
+	Database    string `json:"database"`
+	Measurement string `json:"measurement"`
+	Name        string `json:"name"`
+	NodeID      uint64 `json:"nodeID"`
+	Failed      bool   `json:"failed,omitempty"`
+}
+
+type setMeasurementTTLCommand struct {
+	Database    string        `json:"database"`
+	Measurement string        `json:"measurement"`
+	TTL         time.Duration `json:"ttl"`
+}
+
+type updateMetadataCommand struct {
+	Database    string  `json:"database"`
+	Measurement string  `json:"measurement"`
+	Field       string  `json:"field,omitempty"`
+	Unit        *string `json:"unit,omitempty"`
+	Description *string `json:"description,omitempty"`
+	DisplayName *string `json:"displayName,omitempty"`
+}
+
 type createMeasurementSubcommand struct {
 	Name   string              `json:"name"`
 	Tags   []map[string]string `json:"tags"`
@@ -180,7 +290,7 @@ func (c *createMeasurementsIfNotExistsCommand) addFieldIfNotExists(measurement,
 
 	for _, f := range m.Fields {
 		if f.Name == name {
-			if f.Type != typ {
+			if !fieldTypesCompatible(f.Type, typ) {
 				return ErrFieldTypeConflict
 			}
 			// Field already present in subcommand with same type, nothing to do.
@@ -199,7 +309,25 @@ type dropSeriesCommand struct {
 	SeriesByMeasurement map[string][]uint32 `json:"seriesIds"`
 }
 
+type updateSeriesTagsCommand struct {
+	Database    string            `json:"database"`
+	Measurement string            `json:"measurement"`
+	SeriesID    uint32            `json:"seriesID"`
+	Tags        map[string]string `json:"tags"`
+}
+
 // createContinuousQueryCommand is the raft command for creating a continuous query on a database
 type createContinuousQueryCommand struct {
 	Query string `json:"query"`
 }
+
+type createStoredQueryCommand struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+	Query    string `json:"query"`
+}
+
+type dropStoredQueryCommand struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+}
@@ -0,0 +1,228 @@
+package influxdb
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCompactionCheckInterval is the default time between compaction passes.
+	DefaultCompactionCheckInterval = 10 * time.Minute
+
+	// DefaultCompactionConcurrency is the default number of shards compacted at once.
+	DefaultCompactionConcurrency = 1
+
+	// DefaultCompactionThroughput is the default limit on bytes written per
+	// second by all compactions together.
+	DefaultCompactionThroughput = 20 * 1024 * 1024
+
+	// compactionMinWasteRatio is the fraction of a shard's file that must be
+	// unused before the shard is compacted.
+	compactionMinWasteRatio = 0.25
+)
+
+// Compactor schedules shard compactions. Shards are compacted once their shard
+// group has ended and enough of their file is unused. The number of concurrent
+// compactions and their write throughput can be changed while running.
+type Compactor struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	io          *IOScheduler      // throttles the writes of compactions
+	concurrency int               // maximum compactions running at once
+	running     int               // compactions currently running
+	compacted   map[uint64]uint64 // shard write count as of its last compaction
+}
+
+// NewCompactor returns a new instance of Compactor. Compactions are throttled
+// by the compaction budget of io.
+func NewCompactor(io *IOScheduler) *Compactor {
+	c := &Compactor{
+		io:          io,
+		concurrency: DefaultCompactionConcurrency,
+		compacted:   make(map[uint64]uint64),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Concurrency returns the maximum number of shards compacted at once.
+func (c *Compactor) Concurrency() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.concurrency
+}
+
+// SetConcurrency sets the maximum number of shards compacted at once. Values
+// less than one are treated as one. Running compactions are not interrupted.
+func (c *Compactor) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.concurrency = n
+	c.cond.Broadcast()
+}
+
+// Throughput returns the limit on bytes written per second by compactions.
+func (c *Compactor) Throughput() int64 { return c.io.Throughput(IOClassCompaction) }
+
+// SetThroughput sets the limit on bytes written per second by all compactions
+// together. Compactions are not throttled if n is zero.
+func (c *Compactor) SetThroughput(n int64) { c.io.SetThroughput(IOClassCompaction, n) }
+
+// acquire blocks until another compaction can run.
+func (c *Compactor) acquire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.running >= c.concurrency {
+		c.cond.Wait()
+	}
+	c.running++
+}
+
+// release marks a compaction as finished.
+func (c *Compactor) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running--
+	c.cond.Broadcast()
+}
+
+// needsCompaction returns true if the shard has been written to since it was
+// last compacted and enough of its file is unused.
+func (c *Compactor) needsCompaction(sh *Shard) (bool, error) {
+	_, size, inuse, err := sh.diskStats()
+	if err != nil {
+		return false, err
+	}
+	return c.pending(sh, size, inuse), nil
+}
+
+// pending returns true if a shard with a file of size bytes, of which inuse
+// bytes hold data, is due for compaction.
+func (c *Compactor) pending(sh *Shard, size, inuse int64) bool {
+	c.mu.Lock()
+	writeN, ok := c.compacted[sh.ID]
+	c.mu.Unlock()
+	if ok && writeN == sh.writeCount() {
+		return false
+	}
+	return size > 0 && float64(size-inuse)/float64(size) >= compactionMinWasteRatio
+}
+
+// markCompacted records the shard's write count after it was compacted.
+func (c *Compactor) markCompacted(sh *Shard) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compacted[sh.ID] = sh.writeCount()
+}
+
+// StartCompaction launches background shard compaction.
+func (s *Server) StartCompaction(checkInterval time.Duration) error {
+	if checkInterval == 0 {
+		return fmt.Errorf("compaction check interval must be non-zero")
+	}
+
+	s.mu.Lock()
+	done := make(chan struct{}, 0)
+	s.compactDone = done
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(checkInterval):
+				if err := s.CompactShards(); err != nil {
+					log.Printf("compaction: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// CompactShards compacts the local shards of ended shard groups that have
+// become fragmented. It returns once the compactions have finished.
+func (s *Server) CompactShards() error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	for _, sh := range s.compactionCandidates() {
+		ok, err := s.Compactor.needsCompaction(sh)
+		if err != nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("shard %d: %s", sh.ID, err))
+			mu.Unlock()
+			continue
+		} else if !ok {
+			continue
+		}
+
+		s.Compactor.acquire()
+		wg.Add(1)
+		go func(sh *Shard) {
+			defer wg.Done()
+			defer s.Compactor.release()
+
+			if err := s.compactShard(sh); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("shard %d: %s", sh.ID, err))
+				mu.Unlock()
+			}
+		}(sh)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// compactShard compacts a single shard. Shards that are modified while being
+// compacted are skipped until the next pass.
+func (s *Server) compactShard(sh *Shard) error {
+	_, size, _, _ := sh.diskStats()
+	start := time.Now()
+
+	if err := sh.compact(s.IO.waitFunc(IOClassCompaction)); err == errShardModified {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	s.Compactor.markCompacted(sh)
+
+	_, newSize, _, _ := sh.diskStats()
+	s.Logger.Printf("compacted shard %d from %d to %d bytes in %s", sh.ID, size, newSize, time.Since(start))
+	return nil
+}
+
+// compactionCandidates returns the local shards of shard groups that have ended.
+func (s *Server) compactionCandidates() (a []*Shard) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now().UTC()
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				if !g.EndTime.Before(now) {
+					continue
+				}
+				for _, sh := range g.Shards {
+					// Offloaded shards are left as they were uploaded.
+					if cold, _ := sh.offloaded(); sh.HasDataNodeID(s.id) && !cold {
+						a = append(a, sh)
+					}
+				}
+			}
+		}
+	}
+	return
+}
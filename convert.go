@@ -0,0 +1,202 @@
+package influxdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// FieldConversion represents a background job that converts the values of a
+// field stored on this server to the field's new type.
+//
+// Converted values are stored under the ID of a hidden field with the same
+// name so that points encoded as either type can be decoded while the job
+// runs. The field is replaced by the hidden field once every data node has
+// converted its values.
+type FieldConversion struct {
+	ID          uint64            `json:"id"`
+	Database    string            `json:"database"`
+	Measurement string            `json:"measurement"`
+	Field       string            `json:"field"`
+	Type        influxql.DataType `json:"type"`
+
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"` // zero until the job is done
+
+	SeriesN    int    `json:"seriesN"`         // series to convert, once per shard group
+	SeriesDone int    `json:"seriesDone"`      // series converted so far
+	Err        string `json:"error,omitempty"` // set if the job failed
+}
+
+// Done returns true if the job has finished or failed.
+func (j *FieldConversion) Done() bool { return !j.EndTime.IsZero() }
+
+// fieldConversions tracks the field conversion jobs started on the server.
+type fieldConversions struct {
+	mu   sync.Mutex
+	jobs []*FieldConversion
+}
+
+// update calls fn with the job while holding the lock.
+func (r *fieldConversions) update(j *FieldConversion, fn func(j *FieldConversion)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(j)
+}
+
+// FieldConversions returns the field conversion jobs started on the server,
+// oldest first.
+func (s *Server) FieldConversions() []*FieldConversion {
+	s.fieldConversions.mu.Lock()
+	defer s.fieldConversions.mu.Unlock()
+
+	a := make([]*FieldConversion, len(s.fieldConversions.jobs))
+	for i, j := range s.fieldConversions.jobs {
+		other := *j
+		a[i] = &other
+	}
+	return a
+}
+
+// FieldConversion returns a field conversion job by id or nil if it doesn't exist.
+func (s *Server) FieldConversion(id uint64) *FieldConversion {
+	for _, j := range s.FieldConversions() {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// startFieldConversion starts a job converting the local values of a field
+// unless one is already running. Must be called with the lock held.
+func (s *Server) startFieldConversion(database, measurement, name string) {
+	m := s.databases[database].measurements[measurement]
+	f := m.FieldByName(name)
+	if f == nil || f.ReplacedBy == 0 {
+		return
+	}
+	typ := m.Field(f.ReplacedBy).Type
+
+	s.fieldConversions.mu.Lock()
+	defer s.fieldConversions.mu.Unlock()
+	for _, j := range s.fieldConversions.jobs {
+		if !j.Done() && j.Database == database && j.Measurement == measurement && j.Field == name {
+			return
+		}
+	}
+
+	j := &FieldConversion{
+		ID:          uint64(len(s.fieldConversions.jobs) + 1),
+		Database:    database,
+		Measurement: measurement,
+		Field:       name,
+		Type:        typ,
+		StartTime:   time.Now().UTC(),
+	}
+	s.fieldConversions.jobs = append(s.fieldConversions.jobs, j)
+
+	go s.runFieldConversion(j)
+}
+
+// resumeFieldConversions starts a job for each field this server has yet to
+// convert the values of. Must be called with the lock held.
+func (s *Server) resumeFieldConversions() {
+	for _, db := range s.databases {
+		for _, m := range db.measurements {
+			for _, f := range m.Fields {
+				if f.ReplacedBy == 0 {
+					continue
+				}
+				for _, id := range f.NodeIDs {
+					if id == s.id {
+						s.startFieldConversion(db.name, m.Name, f.Name)
+					}
+				}
+			}
+		}
+	}
+}
+
+// runFieldConversion converts the values of each series stored on this server
+// in turn, then reports to the cluster that the server is done.
+func (s *Server) runFieldConversion(j *FieldConversion) {
+	type target struct {
+		shard    *Shard
+		seriesID uint32
+	}
+
+	// Find the series stored on this server.
+	s.mu.RLock()
+	nodeID := s.id
+	var codec *FieldCodec
+	var targets []target
+	if db := s.databases[j.Database]; db != nil {
+		if m := db.measurements[j.Measurement]; m != nil {
+			codec = NewFieldCodec(m)
+			for _, rp := range db.policies {
+				for _, g := range rp.shardGroups {
+					for _, id := range m.seriesIDs {
+						if sh := g.ShardBySeriesID(id); sh.HasDataNodeID(nodeID) {
+							targets = append(targets, target{shard: sh, seriesID: id})
+						}
+					}
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+	s.fieldConversions.update(j, func(j *FieldConversion) { j.SeriesN = len(targets) })
+
+	var err error
+	convert := func(data []byte) []byte { return codec.convertField(j.Field, data) }
+	for _, t := range targets {
+		// Stop early if the conversion was abandoned by another data node.
+		if !s.fieldConverting(j) {
+			break
+		}
+		if err = t.shard.rewriteSeries(t.seriesID, convert); err != nil {
+			break
+		}
+		s.fieldConversions.update(j, func(j *FieldConversion) { j.SeriesDone++ })
+	}
+	if err != nil {
+		s.Logger.Printf("field conversion %d failed: %s", j.ID, err)
+	} else {
+		s.Logger.Printf("field conversion %d converted %d series", j.ID, j.SeriesDone)
+	}
+
+	// Report the result so the field is replaced once every data node is done.
+	// The job is resumed on restart if the report can't be sent.
+	c := &fieldConvertedCommand{Database: j.Database, Measurement: j.Measurement, Name: j.Field, NodeID: nodeID, Failed: err != nil}
+	if s.Client() == nil {
+		if err == nil {
+			err = ErrServerClosed
+		}
+	} else if _, e := s.broadcast(fieldConvertedMessageType, c); e != nil && err == nil {
+		err = e
+	}
+
+	s.fieldConversions.update(j, func(j *FieldConversion) {
+		if err != nil {
+			j.Err = err.Error()
+		}
+		j.EndTime = time.Now().UTC()
+	})
+}
+
+// fieldConverting returns true if the field of a job is still being converted.
+func (s *Server) fieldConverting(j *FieldConversion) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if db := s.databases[j.Database]; db != nil {
+		if m := db.measurements[j.Measurement]; m != nil {
+			if f := m.FieldByName(j.Field); f != nil && f.ReplacedBy != 0 {
+				return m.Field(f.ReplacedBy).Type == j.Type
+			}
+		}
+	}
+	return false
+}
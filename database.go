@@ -7,6 +7,7 @@ import (
 	"math"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,9 +27,15 @@ type database struct {
 
 	policies          map[string]*RetentionPolicy // retention policies by name
 	continuousQueries []*ContinuousQuery          // continuous queries
+	storedQueries     []*StoredQuery              // stored queries
 
 	defaultRetentionPolicy string
 
+	writeLimit int // points written per second, unlimited if zero
+	writeBurst int // points written at once, the write limit if zero
+
+	nameRules *NameRules // normalizes written measurement names, if set
+
 	// in memory indexing structures
 	measurements map[string]*Measurement // measurement name to object and index
 	series       map[uint32]*Series      // map series id to the Series object
@@ -40,6 +47,7 @@ func newDatabase() *database {
 	return &database{
 		policies:          make(map[string]*RetentionPolicy),
 		continuousQueries: make([]*ContinuousQuery, 0),
+		storedQueries:     make([]*StoredQuery, 0),
 		measurements:      make(map[string]*Measurement),
 		series:            make(map[uint32]*Series),
 		names:             make([]string, 0),
@@ -55,6 +63,31 @@ func (db *database) shardGroupByTimestamp(policy string, timestamp time.Time) (*
 	return p.shardGroupByTimestamp(timestamp), nil
 }
 
+// lastSeen returns the time of the most recent point in a series across all
+// retention policies. Returns the zero time if the series has no points.
+func (db *database) lastSeen(seriesID uint32) (time.Time, error) {
+	var max int64
+	var found bool
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			if len(g.Shards) == 0 {
+				continue
+			}
+			ts, ok, err := g.ShardBySeriesID(seriesID).lastTimestamp(seriesID)
+			if err != nil {
+				return time.Time{}, err
+			} else if ok && (!found || ts > max) {
+				max, found = ts, true
+			}
+		}
+	}
+
+	if !found {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, max).UTC(), nil
+}
+
 // Series takes a series ID and returns a series.
 func (db *database) Series(id uint32) *Series {
 	return db.series[id]
@@ -70,6 +103,10 @@ func (db *database) MarshalJSON() ([]byte, error) {
 		o.Policies = append(o.Policies, rp)
 	}
 	o.ContinuousQueries = db.continuousQueries
+	o.StoredQueries = db.storedQueries
+	o.WriteLimit = db.writeLimit
+	o.WriteBurst = db.writeBurst
+	o.NameRules = db.nameRules
 	return json.Marshal(&o)
 }
 
@@ -84,6 +121,14 @@ func (db *database) UnmarshalJSON(data []byte) error {
 	// Copy over properties from intermediate type.
 	db.name = o.Name
 	db.defaultRetentionPolicy = o.DefaultRetentionPolicy
+	db.writeLimit = o.WriteLimit
+	db.writeBurst = o.WriteBurst
+	if r := o.NameRules; r != nil {
+		var err error
+		if db.nameRules, err = NewNameRules(r.Lowercase, r.Characters, r.MaxLength); err != nil {
+			return err
+		}
+	}
 
 	// Copy shard policies.
 	db.policies = make(map[string]*RetentionPolicy)
@@ -98,6 +143,11 @@ func (db *database) UnmarshalJSON(data []byte) error {
 		db.continuousQueries = append(db.continuousQueries, c)
 	}
 
+	db.storedQueries = o.StoredQueries
+	if db.storedQueries == nil {
+		db.storedQueries = make([]*StoredQuery, 0)
+	}
+
 	return nil
 }
 
@@ -107,6 +157,10 @@ type databaseJSON struct {
 	DefaultRetentionPolicy string             `json:"defaultRetentionPolicy,omitempty"`
 	Policies               []*RetentionPolicy `json:"policies,omitempty"`
 	ContinuousQueries      []*ContinuousQuery `json:"continuousQueries,omitempty"`
+	StoredQueries          []*StoredQuery     `json:"storedQueries,omitempty"`
+	WriteLimit             int                `json:"writeLimit,omitempty"`
+	WriteBurst             int                `json:"writeBurst,omitempty"`
+	NameRules              *NameRules         `json:"nameRules,omitempty"`
 }
 
 // Measurement represents a collection of time series in a database. It also contains in memory
@@ -117,6 +171,15 @@ type Measurement struct {
 	Name   string   `json:"name,omitempty"`
 	Fields []*Field `json:"fields,omitempty"`
 
+	// Length of time to keep points. Points are kept for the duration of
+	// their retention policy if zero or if the policy's duration is shorter.
+	TTL time.Duration `json:"ttl,omitempty"`
+
+	// Descriptive metadata for displaying the measurement.
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+
 	// in-memory index fields
 	series              map[string]*Series // sorted tagset string to the series object
 	seriesByID          map[uint32]*Series // lookup table for series by their id
@@ -143,8 +206,8 @@ func NewMeasurement(name string) *Measurement {
 // the fields already exists with a different type.
 func (m *Measurement) createFieldIfNotExists(name string, typ influxql.DataType) error {
 	// Ignore if the field already exists.
-	if f := m.FieldByName(name); f != nil {
-		if f.Type != typ {
+	if f := m.fieldForWrite(name); f != nil {
+		if !fieldTypesCompatible(f.Type, typ) {
 			return ErrFieldTypeConflict
 		}
 		return nil
@@ -174,16 +237,26 @@ func (m *Measurement) Field(id uint8) *Field {
 	return m.Fields[id-1]
 }
 
-// FieldByName returns a field by name.
+// FieldByName returns a visible field by name.
 func (m *Measurement) FieldByName(name string) *Field {
 	for _, f := range m.Fields {
-		if f.Name == name {
+		if f.Name == name && !f.Hidden {
 			return f
 		}
 	}
 	return nil
 }
 
+// fieldForWrite returns the field that values written to a field name are
+// stored as. It differs from the named field while its type is being changed.
+func (m *Measurement) fieldForWrite(name string) *Field {
+	f := m.FieldByName(name)
+	if f != nil && f.ReplacedBy != 0 {
+		return m.Field(f.ReplacedBy)
+	}
+	return f
+}
+
 // addSeries will add a series to the measurementIndex. Returns false if already present
 func (m *Measurement) addSeries(s *Series) bool {
 	if _, ok := m.seriesByID[s.ID]; ok {
@@ -289,36 +362,50 @@ func (m *Measurement) seriesIDsAndFilters(stmt *influxql.SelectStatement) (serie
 	return ids, seriesIdsToExpr
 }
 
+// tagSet represents the series grouped under one set of tag values and the
+// filter applied to each series.
+type tagSet struct {
+	ids     []uint32
+	filters []influxql.Expr
+}
+
 // tagSets returns the unique tag sets that exist for the given tag keys. This is used to determine
 // what composite series will be created by a group by. i.e. "group by region" should return:
 // {"region":"uswest"}, {"region":"useast"}
 // or region, service returns
 // {"region": "uswest", "service": "redis"}, {"region": "uswest", "service": "mysql"}, etc...
-func (m *Measurement) tagSets(stmt *influxql.SelectStatement, dimensions []string) map[string]map[uint32]influxql.Expr {
+//
+// Returns ErrTooManyGroups as soon as there are more than maxGroups tag sets,
+// unless maxGroups is zero.
+func (m *Measurement) tagSets(stmt *influxql.SelectStatement, dimensions []string, maxGroups int) (map[string]*tagSet, error) {
 	// get the unique set of series ids and the filters that should be applied to each
 	seriesIDs, filters := m.seriesIDsAndFilters(stmt)
 
-	// build the tag sets
-	tagSets := make(map[string]map[uint32]influxql.Expr)
+	// build the tag sets, reusing one slice for the tag values of each series
+	tagSets := make(map[string]*tagSet)
+	tags := make([]string, len(dimensions))
 	for _, id := range seriesIDs {
 		// get the series and set the tag values for the dimensions we care about
 		s := m.seriesByID[id]
-		tags := make([]string, len(dimensions))
 		for i, dim := range dimensions {
 			tags[i] = s.Tags[dim]
 		}
 
-		// marshal it into a string and put this series and its expr into the tagSets map
+		// marshal it into a string and add this series and its expr to its tag set
 		t := string(influxql.MarshalStrings(tags))
 		set, ok := tagSets[t]
 		if !ok {
-			set = make(map[uint32]influxql.Expr)
+			if maxGroups > 0 && len(tagSets) >= maxGroups {
+				return nil, ErrTooManyGroups
+			}
+			set = &tagSet{}
+			tagSets[t] = set
 		}
-		set[id] = filters[id]
-		tagSets[t] = set
+		set.ids = append(set.ids, id)
+		set.filters = append(set.filters, filters[id])
 	}
 
-	return tagSets
+	return tagSets, nil
 }
 
 // idsForExpr will return a collection of series ids, a bool indicating if the result should be
@@ -680,11 +767,45 @@ func (a Measurements) union(other Measurements) Measurements {
 	return result
 }
 
+// filterByName returns the measurements whose names match a "WITH MEASUREMENT"
+// operator and name or regular expression.
+func (a Measurements) filterByName(op influxql.Token, expr influxql.Expr) Measurements {
+	var result Measurements
+	for _, m := range a {
+		var match bool
+		switch expr := expr.(type) {
+		case *influxql.VarRef:
+			match = m.Name == expr.Val
+		case *influxql.RegexLiteral:
+			match = expr.Val.MatchString(m.Name)
+		}
+		if match == (op == influxql.EQ || op == influxql.EQREGEX) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
 // Field represents a series field.
 type Field struct {
 	ID   uint8             `json:"id,omitempty"`
 	Name string            `json:"name,omitempty"`
 	Type influxql.DataType `json:"type,omitempty"`
+
+	// Descriptive metadata for displaying the field.
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+
+	// When a field's type is changed its values are converted and stored under
+	// the ID of another field with the same name, so that a point encoded as
+	// either type can always be decoded. The field being converted keeps its
+	// ID until every data node has converted its values and new values are
+	// written to ReplacedBy in the meantime. Hidden fields can't be referenced
+	// by name and their values are returned as the visible field's type.
+	ReplacedBy uint8    `json:"replacedBy,omitempty"`
+	Hidden     bool     `json:"hidden,omitempty"`
+	NodeIDs    []uint64 `json:"nodeIDs,omitempty"` // data nodes still converting values
 }
 
 // Fields represents a list of fields.
@@ -707,7 +828,9 @@ func NewFieldCodec(m *Measurement) *FieldCodec {
 	fieldsByName := make(map[string]*Field, len(m.Fields))
 	for _, f := range m.Fields {
 		fieldsByID[f.ID] = f
-		fieldsByName[f.Name] = f
+		if !f.Hidden {
+			fieldsByName[f.Name] = f
+		}
 	}
 	return &FieldCodec{fieldsByID: fieldsByID, fieldsByName: fieldsByName}
 }
@@ -725,8 +848,15 @@ func (f *FieldCodec) EncodeFields(values map[string]interface{}) ([]byte, error)
 		field := f.fieldsByName[k]
 		if field == nil {
 			panic(fmt.Sprintf("field does not exist for %s", k))
-		} else if influxql.InspectDataType(v) != field.Type {
+		} else if field.ReplacedBy != 0 {
+			// Write values of a field being converted as the new type.
+			field = f.fieldsByID[field.ReplacedBy]
+		}
+
+		if cv, ok := coerceValue(v, field.Type); !ok {
 			return nil, fmt.Errorf("field \"%s\" is type %T, mapped as type %s", k, k, field.Type)
+		} else {
+			v = cv
 		}
 
 		var buf []byte
@@ -743,6 +873,13 @@ func (f *FieldCodec) EncodeFields(values map[string]interface{}) ([]byte, error)
 
 			buf = make([]byte, 9)
 			binary.BigEndian.PutUint64(buf[1:9], math.Float64bits(value))
+		case influxql.Unsigned:
+			buf = make([]byte, 9)
+			binary.BigEndian.PutUint64(buf[1:9], v.(uint64))
+		case influxql.Duration:
+			// Durations are stored as signed nanosecond counts.
+			buf = make([]byte, 9)
+			binary.BigEndian.PutUint64(buf[1:9], uint64(v.(time.Duration)))
 		case influxql.Boolean:
 			value := v.(bool)
 
@@ -764,6 +901,8 @@ func (f *FieldCodec) EncodeFields(values map[string]interface{}) ([]byte, error)
 			for i, c := range []byte(value) {
 				buf[i+3] = byte(c)
 			}
+		case influxql.Histogram:
+			buf = encodeBuckets(v.(influxql.Buckets))
 		default:
 			panic(fmt.Sprintf("unsupported value type: %T", v))
 		}
@@ -778,12 +917,109 @@ func (f *FieldCodec) EncodeFields(values map[string]interface{}) ([]byte, error)
 	return b, nil
 }
 
+// encodeBuckets encodes a histogram field, leaving the first byte for the
+// field ID. The bucket count (2 bytes) is followed by the bound and count of
+// each bucket (8 bytes each).
+func encodeBuckets(buckets influxql.Buckets) []byte {
+	bounds := buckets.Bounds()
+	buf := make([]byte, 3+16*len(bounds))
+	binary.BigEndian.PutUint16(buf[1:3], uint16(len(bounds)))
+	for i, bound := range bounds {
+		binary.BigEndian.PutUint64(buf[3+16*i:], math.Float64bits(bound))
+		binary.BigEndian.PutUint64(buf[11+16*i:], buckets[bound])
+	}
+	return buf
+}
+
+// decodeBuckets decodes a histogram field. Returns the buckets and the number
+// of bytes read.
+func decodeBuckets(b []byte) (influxql.Buckets, int) {
+	n := int(binary.BigEndian.Uint16(b[1:3]))
+	buckets := make(influxql.Buckets, n)
+	for i := 0; i < n; i++ {
+		bound := math.Float64frombits(binary.BigEndian.Uint64(b[3+16*i:]))
+		buckets[bound] = binary.BigEndian.Uint64(b[11+16*i:])
+	}
+	return buckets, 3 + 16*n
+}
+
+// maxExactFloat64 is the largest integer that a float64 can represent exactly.
+const maxExactFloat64 = 1 << 53
+
+// coerceValue converts a value to the data type of the field it is written to.
+// Unsigned integers up to 2^53 are stored in number fields as floats and whole,
+// non-negative numbers are stored in unsigned fields as integers. Returns false
+// if the value cannot be stored as typ without losing precision.
+func coerceValue(v interface{}, typ influxql.DataType) (interface{}, bool) {
+	switch typ {
+	case influxql.Number:
+		if u, ok := v.(uint64); ok {
+			return float64(u), u <= maxExactFloat64
+		}
+	case influxql.Unsigned:
+		switch n := v.(type) {
+		case int:
+			if n >= 0 {
+				return uint64(n), true
+			}
+		case float64:
+			if n >= 0 && n < math.MaxUint64 && n == math.Trunc(n) {
+				return uint64(n), true
+			}
+		}
+	}
+	return v, influxql.InspectDataType(v) == typ
+}
+
+// nonFiniteField returns the name of a field with a NaN or ±Inf value, or a
+// blank string if every value is finite.
+func nonFiniteField(fields map[string]interface{}) string {
+	for k, v := range fields {
+		if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return k
+		}
+	}
+	return ""
+}
+
+// reservedKey returns a tag or field key of a point that can't be queried.
+// Returns a blank string and false if every key can be queried.
+func reservedKey(p *Point) (string, bool) {
+	for k := range p.Tags {
+		if isReservedKey(k) {
+			return k, true
+		}
+	}
+	for k := range p.Fields {
+		if isReservedKey(k) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// isReservedKey returns true if a tag or field key is blank, and so can't be
+// referenced, or is "time" in any case, which always refers to the timestamp.
+func isReservedKey(k string) bool { return k == "" || strings.EqualFold(k, "time") }
+
+// fieldTypesCompatible returns true if values of type typ can be written to a
+// field of type fieldType. Numbers and unsigned integers are interchangeable.
+func fieldTypesCompatible(fieldType, typ influxql.DataType) bool {
+	if fieldType == typ {
+		return true
+	}
+	isNumeric := func(t influxql.DataType) bool { return t == influxql.Number || t == influxql.Unsigned }
+	return isNumeric(fieldType) && isNumeric(typ)
+}
+
 // DecodeByID scans a byte slice for a field with the given ID, converts it to its
-// expected type, and return that value.
+// expected type, and return that value. Values stored under another field with
+// the same name are converted to the type of the field.
 func (f *FieldCodec) DecodeByID(targetID uint8, b []byte) (interface{}, error) {
 	if len(b) == 0 {
 		return 0, ErrFieldNotFound
 	}
+	target := f.fieldsByID[targetID]
 
 	for {
 		if len(b) < 1 {
@@ -801,6 +1037,14 @@ func (f *FieldCodec) DecodeByID(targetID uint8, b []byte) (interface{}, error) {
 			// Move bytes forward.
 			value = math.Float64frombits(binary.BigEndian.Uint64(b[1:9]))
 			b = b[9:]
+		case influxql.Unsigned:
+			value = binary.BigEndian.Uint64(b[1:9])
+			// Move bytes forward.
+			b = b[9:]
+		case influxql.Duration:
+			value = time.Duration(binary.BigEndian.Uint64(b[1:9]))
+			// Move bytes forward.
+			b = b[9:]
 		case influxql.Boolean:
 			if b[1] == 1 {
 				value = true
@@ -814,12 +1058,20 @@ func (f *FieldCodec) DecodeByID(targetID uint8, b []byte) (interface{}, error) {
 			value = string(b[3 : 3+size])
 			// Move bytes forward.
 			b = b[size+3:]
+		case influxql.Histogram:
+			var n int
+			value, n = decodeBuckets(b)
+			b = b[n:]
 		default:
 			panic(fmt.Sprintf("unsupported value type: %T", field.Type))
 		}
 
 		if field.ID == targetID {
 			return value, nil
+		} else if target != nil && field.Name == target.Name {
+			if value = castValue(value, target.Type); value != nil {
+				return value, nil
+			}
 		}
 	}
 
@@ -854,6 +1106,14 @@ func (f *FieldCodec) DecodeFields(b []byte) map[uint8]interface{} {
 			value = math.Float64frombits(binary.BigEndian.Uint64(b[1:9]))
 			// Move bytes forward.
 			b = b[9:]
+		case influxql.Unsigned:
+			value = binary.BigEndian.Uint64(b[1:9])
+			// Move bytes forward.
+			b = b[9:]
+		case influxql.Duration:
+			value = time.Duration(binary.BigEndian.Uint64(b[1:9]))
+			// Move bytes forward.
+			b = b[9:]
 		case influxql.Boolean:
 			if b[1] == 1 {
 				value = true
@@ -864,13 +1124,28 @@ func (f *FieldCodec) DecodeFields(b []byte) map[uint8]interface{} {
 			b = b[2:]
 		case influxql.String:
 			size := binary.BigEndian.Uint16(b[1:3])
-			value = string(b[3:size])
+			value = string(b[3 : 3+size])
 			// Move bytes forward.
 			b = b[size+3:]
+		case influxql.Histogram:
+			var n int
+			value, n = decodeBuckets(b)
+			b = b[n:]
 		default:
 			panic(fmt.Sprintf("unsupported value type: %T", f.fieldsByID[fieldID]))
 		}
 
+		// Return values of hidden fields as the visible field with the same name.
+		if field.Hidden {
+			visible := f.fieldsByName[field.Name]
+			if visible == nil {
+				continue
+			} else if value = castValue(value, visible.Type); value == nil {
+				continue
+			}
+			fieldID = visible.ID
+		}
+
 		values[fieldID] = value
 
 	}
@@ -878,6 +1153,74 @@ func (f *FieldCodec) DecodeFields(b []byte) map[uint8]interface{} {
 	return values
 }
 
+// MergeFields combines two encoded field sets into a single encoded field set.
+// Fields in b take precedence over fields with the same name in a, including
+// fields with the same name stored as another type.
+// Returns an error if either field set holds a field the codec doesn't know.
+func (f *FieldCodec) MergeFields(a, b []byte) ([]byte, error) {
+	// Determine which fields are overridden by b.
+	bfields, err := f.splitFields(b)
+	if err != nil {
+		return nil, err
+	}
+	overridden := make(map[string]bool)
+	for _, fb := range bfields {
+		overridden[f.fieldsByID[fb[0]].Name] = true
+	}
+
+	// Append the fields of a that were not overridden, then all of b.
+	afields, err := f.splitFields(a)
+	if err != nil {
+		return nil, err
+	}
+	var merged []byte
+	for _, fb := range afields {
+		if !overridden[f.fieldsByID[fb[0]].Name] {
+			merged = append(merged, fb...)
+		}
+	}
+	return append(merged, b...), nil
+}
+
+// splitFields splits an encoded byte slice into the encoded bytes of each field.
+// Returns an error if a field ID has no mapping or the slice is truncated.
+func (f *FieldCodec) splitFields(b []byte) ([][]byte, error) {
+	var a [][]byte
+	for len(b) > 0 {
+		field := f.fieldsByID[b[0]]
+		if field == nil {
+			return nil, fmt.Errorf("field ID %d has no mapping", b[0])
+		}
+
+		var n int
+		switch field.Type {
+		case influxql.Number, influxql.Unsigned, influxql.Duration:
+			n = 9
+		case influxql.Boolean:
+			n = 2
+		case influxql.String:
+			if len(b) < 3 {
+				return nil, ErrInvalidPointBuffer
+			}
+			n = 3 + int(binary.BigEndian.Uint16(b[1:3]))
+		case influxql.Histogram:
+			if len(b) < 3 {
+				return nil, ErrInvalidPointBuffer
+			}
+			n = 3 + 16*int(binary.BigEndian.Uint16(b[1:3]))
+		default:
+			return nil, fmt.Errorf("unsupported value type: %s", field.Type)
+		}
+		if n > len(b) {
+			return nil, ErrInvalidPointBuffer
+		}
+
+		a = append(a, b[:n])
+		b = b[n:]
+	}
+	return a, nil
+}
+
 // Series belong to a Measurement and represent unique time series in a database
 type Series struct {
 	ID   uint32
@@ -1019,9 +1362,39 @@ type RetentionPolicy struct {
 	// The number of copies to make of each shard.
 	ReplicaN uint32 `json:"replicaN"`
 
+	// How points written to an existing series and timestamp are handled.
+	// Defaults to DuplicatePolicyOverwrite if blank.
+	Duplicates string `json:"duplicates,omitempty"`
+
 	shardGroups []*ShardGroup
 }
 
+const (
+	// DuplicatePolicyOverwrite replaces an existing point with the new point.
+	DuplicatePolicyOverwrite = "overwrite"
+
+	// DuplicatePolicyMerge combines the fields of both points. Fields in the
+	// new point take precedence over fields in the existing point.
+	DuplicatePolicyMerge = "merge"
+
+	// DuplicatePolicyReject keeps the existing point and drops the new point.
+	DuplicatePolicyReject = "reject"
+)
+
+// There is no versioned policy. A shard stores a single point per series and
+// timestamp so earlier versions of a point could not be kept or queried
+// without changing the storage format.
+
+// normalizeDuplicatePolicy returns the lowercase name of a duplicate policy.
+// Returns an error if the policy is unknown.
+func normalizeDuplicatePolicy(policy string) (string, error) {
+	switch policy = strings.ToLower(policy); policy {
+	case "", DuplicatePolicyOverwrite, DuplicatePolicyMerge, DuplicatePolicyReject:
+		return policy, nil
+	}
+	return "", ErrInvalidDuplicatePolicy
+}
+
 // NewRetentionPolicy returns a new instance of RetentionPolicy with defaults set.
 func NewRetentionPolicy(name string) *RetentionPolicy {
 	return &RetentionPolicy{
@@ -1031,6 +1404,12 @@ func NewRetentionPolicy(name string) *RetentionPolicy {
 	}
 }
 
+// ShardGroupDuration returns the length of time covered by each shard group
+// created for the policy.
+func (rp *RetentionPolicy) ShardGroupDuration() time.Duration {
+	return rp.Duration
+}
+
 // shardGroupByTimestamp returns the group in the policy that owns a timestamp.
 // Returns nil group does not exist.
 func (rp *RetentionPolicy) shardGroupByTimestamp(timestamp time.Time) *ShardGroup {
@@ -1087,6 +1466,214 @@ func (db *database) dropMeasurement(name string) error {
 	return nil
 }
 
+// startFieldConversion begins changing the type of a field. New values are
+// written as typ to a hidden field with the same name, which replaces the
+// field once each data node in nodeIDs has converted its existing values.
+// Fields are replaced rather than modified so that codecs in use by queries
+// are unaffected.
+func (m *Measurement) startFieldConversion(name string, typ influxql.DataType, nodeIDs []uint64) error {
+	switch typ {
+	case influxql.Number, influxql.Unsigned, influxql.Boolean, influxql.String:
+	default:
+		return ErrInvalidFieldType
+	}
+
+	f := m.FieldByName(name)
+	if f == nil {
+		return ErrFieldNotFound
+	} else if f.ReplacedBy != 0 {
+		return ErrFieldConversionInProgress
+	} else if f.Type == typ {
+		return nil
+	}
+
+	// Reuse the hidden field of an earlier conversion to the same type.
+	var r *Field
+	for _, other := range m.Fields {
+		if other.Hidden && other.Name == name && other.Type == typ {
+			r = other
+			break
+		}
+	}
+	if r == nil {
+		if len(m.Fields)+1 > math.MaxUint8 {
+			return ErrFieldOverflow
+		}
+		r = &Field{ID: uint8(len(m.Fields) + 1), Name: name, Type: typ, Hidden: true}
+		m.Fields = append(m.Fields, r)
+	}
+
+	other := *f
+	other.ReplacedBy = r.ID
+	other.NodeIDs = nodeIDs
+	m.Fields[f.ID-1] = &other
+
+	// Nothing is stored yet so the field can be replaced straight away.
+	if len(nodeIDs) == 0 {
+		m.replaceField(&other)
+	}
+	return nil
+}
+
+// fieldConverted records that a data node has converted its values of a field.
+// The field is replaced once every data node has. If the node failed then the
+// conversion is abandoned and the field keeps its type. Values converted so far
+// are still read as the field's type.
+func (m *Measurement) fieldConverted(name string, nodeID uint64, failed bool) error {
+	f := m.FieldByName(name)
+	if f == nil {
+		return ErrFieldNotFound
+	} else if f.ReplacedBy == 0 {
+		return nil
+	}
+
+	other := *f
+	if failed {
+		other.ReplacedBy, other.NodeIDs = 0, nil
+		m.Fields[f.ID-1] = &other
+		return nil
+	}
+
+	other.NodeIDs = nil
+	for _, id := range f.NodeIDs {
+		if id != nodeID {
+			other.NodeIDs = append(other.NodeIDs, id)
+		}
+	}
+	m.Fields[f.ID-1] = &other
+
+	if len(other.NodeIDs) == 0 {
+		m.replaceField(&other)
+	}
+	return nil
+}
+
+// replaceField hides a field and makes its replacement visible in its place.
+func (m *Measurement) replaceField(f *Field) {
+	r := *m.Fields[f.ReplacedBy-1]
+	r.Hidden = false
+	r.Unit, r.Description, r.DisplayName = f.Unit, f.Description, f.DisplayName
+	m.Fields[r.ID-1] = &r
+
+	f.ReplacedBy, f.NodeIDs, f.Hidden = 0, nil, true
+}
+
+// convertField re-encodes the values of a field that are stored as another
+// type as the type new values of the field are written as. Values that cannot
+// be converted are removed.
+func (f *FieldCodec) convertField(name string, data []byte) []byte {
+	target := f.fieldsByName[name]
+	if target == nil {
+		return data
+	} else if target.ReplacedBy != 0 {
+		target = f.fieldsByID[target.ReplacedBy]
+	}
+
+	// Data that can't be split is left for the query engine to report.
+	fields, err := f.splitFields(data)
+	if err != nil {
+		return data
+	}
+
+	var b []byte
+	for _, buf := range fields {
+		if field := f.fieldsByID[buf[0]]; field.Name != name || field.ID == target.ID {
+			b = append(b, buf...)
+			continue
+		}
+
+		v, err := f.DecodeByID(buf[0], buf)
+		if err != nil {
+			continue
+		} else if v = castValue(v, target.Type); v == nil {
+			continue
+		}
+
+		enc, err := f.EncodeFields(map[string]interface{}{name: v})
+		if err != nil {
+			continue
+		}
+		b = append(b, enc...)
+	}
+	return b
+}
+
+// castValue converts a field value to a given data type.
+// Returns nil if the value cannot be converted.
+func castValue(v interface{}, typ influxql.DataType) interface{} {
+	switch typ {
+	case influxql.Number:
+		switch v := v.(type) {
+		case float64:
+			return v
+		case uint64:
+			return float64(v)
+		case time.Duration:
+			return float64(v)
+		case bool:
+			if v {
+				return float64(1)
+			}
+			return float64(0)
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+	case influxql.Unsigned:
+		switch v := v.(type) {
+		case float64:
+			if u, ok := coerceValue(v, influxql.Unsigned); ok {
+				return u
+			}
+		case uint64:
+			return v
+		case time.Duration:
+			if v >= 0 {
+				return uint64(v)
+			}
+		case bool:
+			if v {
+				return uint64(1)
+			}
+			return uint64(0)
+		case string:
+			if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+				return u
+			}
+		}
+	case influxql.Boolean:
+		switch v := v.(type) {
+		case float64:
+			return v != 0
+		case uint64:
+			return v != 0
+		case time.Duration:
+			return v != 0
+		case bool:
+			return v
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		}
+	case influxql.String:
+		switch v := v.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case uint64:
+			return strconv.FormatUint(v, 10)
+		case time.Duration:
+			return v.String()
+		case bool:
+			return strconv.FormatBool(v)
+		case string:
+			return v
+		}
+	}
+	return nil
+}
+
 // dropSeries will delete all data with the seriesID
 func (rp *RetentionPolicy) dropSeries(seriesID uint32) error {
 	for _, g := range rp.shardGroups {
@@ -1113,6 +1700,7 @@ func (rp *RetentionPolicy) MarshalJSON() ([]byte, error) {
 	o.Name = rp.Name
 	o.Duration = rp.Duration
 	o.ReplicaN = rp.ReplicaN
+	o.Duplicates = rp.Duplicates
 	for _, g := range rp.shardGroups {
 		o.ShardGroups = append(o.ShardGroups, g)
 	}
@@ -1131,6 +1719,7 @@ func (rp *RetentionPolicy) UnmarshalJSON(data []byte) error {
 	rp.Name = o.Name
 	rp.ReplicaN = o.ReplicaN
 	rp.Duration = o.Duration
+	rp.Duplicates = o.Duplicates
 	rp.shardGroups = o.ShardGroups
 
 	return nil
@@ -1142,6 +1731,7 @@ type retentionPolicyJSON struct {
 	ReplicaN    uint32        `json:"replicaN,omitempty"`
 	SplitN      uint32        `json:"splitN,omitempty"`
 	Duration    time.Duration `json:"duration,omitempty"`
+	Duplicates  string        `json:"duplicates,omitempty"`
 	ShardGroups []*ShardGroup `json:"shardGroups,omitempty"`
 }
 
@@ -1247,6 +1837,15 @@ func (db *database) continuousQueryByName(name string) *ContinuousQuery {
 	return nil
 }
 
+func (db *database) storedQueryByName(name string) *StoredQuery {
+	for _, q := range db.storedQueries {
+		if q.Name == name {
+			return q
+		}
+	}
+	return nil
+}
+
 // used to convert the tag set to bytes for use as a lookup key
 func marshalTags(tags map[string]string) []byte {
 	s := make([]string, 0, len(tags))
@@ -1382,6 +1981,8 @@ func (db *database) measurementsByTagFilters(filters []*TagFilter) Measurements
 		}
 	}
 
+	// Sort so results can be combined with union and intersect.
+	sort.Sort(measurements)
 	return measurements
 }
 
@@ -1425,7 +2026,6 @@ func (m *Measurement) tagValuesByKeyAndSeriesID(tagKeys []string, ids seriesIDs)
 		// Iterate the tag keys we're interested in and collect values
 		// from this series, if they exist.
 		for _, tagKey := range tagKeys {
-			tagKey = strings.Trim(tagKey, `"`)
 			if tagVal, ok := s.Tags[tagKey]; ok {
 				if _, ok = tagValues[tagKey]; !ok {
 					tagValues[tagKey] = newStringSet()
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package influxdb
+
+// diskFree returns zero as free disk space isn't reported on this platform.
+func diskFree(path string) (uint64, error) { return 0, nil }
@@ -3,6 +3,7 @@ package graphite
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -42,6 +43,13 @@ type SeriesWriter interface {
 type Parser struct {
 	Separator   string
 	LastEnabled bool
+
+	// Templates map metric names to measurements, tags and fields. If no
+	// template matches a metric then the name is decoded as key.value pairs.
+	Templates []*Template
+
+	// Tags are added to every point unless set by the metric or template.
+	Tags map[string]string
 }
 
 // NewParser returns a GraphiteParser instance.
@@ -49,6 +57,28 @@ func NewParser() *Parser {
 	return &Parser{Separator: DefaultGraphiteNameSeparator}
 }
 
+// AddTemplate parses a template and adds it to the parser.
+func (p *Parser) AddTemplate(s string) error {
+	t, err := ParseTemplate(s, p.Separator)
+	if err != nil {
+		return err
+	}
+	p.Templates = append(p.Templates, t)
+	return nil
+}
+
+// template returns the template with the most specific filter that matches
+// the metric segments. Returns nil if no template matches.
+func (p *Parser) template(segments []string) *Template {
+	var match *Template
+	for _, t := range p.Templates {
+		if t.Match(segments) && (match == nil || len(t.Filter) > len(match.Filter)) {
+			match = t
+		}
+	}
+	return match
+}
+
 // Parse performs Graphite parsing of a single line.
 func (p *Parser) Parse(line string) (influxdb.Point, error) {
 	// Break into 3 fields (name, value, timestamp).
@@ -57,24 +87,40 @@ func (p *Parser) Parse(line string) (influxdb.Point, error) {
 		return influxdb.Point{}, fmt.Errorf("received %q which doesn't have three fields", line)
 	}
 
-	// decode the name and tags
-	name, tags, err := p.DecodeNameAndTags(fields[0])
+	// decode the name and tags, using a template if one matches.
+	var name, field string
+	var tags map[string]string
+	var err error
+	segments := strings.Split(fields[0], p.Separator)
+	if t := p.template(segments); t != nil {
+		name, tags, field, err = t.Apply(segments, p.Separator)
+	} else {
+		name, tags, err = p.DecodeNameAndTags(fields[0])
+		field = name
+	}
 	if err != nil {
 		return influxdb.Point{}, err
 	}
+	for k, v := range p.Tags {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
 
 	// Parse value.
 	v, err := strconv.ParseFloat(fields[1], 64)
 	if err != nil {
 		return influxdb.Point{}, err
+	} else if math.IsNaN(v) || math.IsInf(v, 0) {
+		return influxdb.Point{}, fmt.Errorf("invalid value: %s", fields[1])
 	}
 
 	fieldValues := make(map[string]interface{})
 	// Determine if value is a float or an int.
 	if i := int64(v); float64(i) == v {
-		fieldValues[name] = int64(v)
+		fieldValues[field] = int64(v)
 	} else {
-		fieldValues[name] = v
+		fieldValues[field] = v
 	}
 
 	// Parse timestamp.
@@ -1,7 +1,9 @@
 package graphite_test
 
 import (
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -194,6 +196,16 @@ func Test_DecodeMetric(t *testing.T) {
 			line: `cpu 50z 1419972457825`,
 			err:  `strconv.ParseFloat: parsing "50z": invalid syntax`,
 		},
+		{
+			test: "should fail parsing NaN",
+			line: `cpu NaN ` + strTime,
+			err:  `invalid value: NaN`,
+		},
+		{
+			test: "should fail parsing Inf",
+			line: `cpu +Inf ` + strTime,
+			err:  `invalid value: +Inf`,
+		},
 		{
 			test: "should fail parsing invalid time",
 			line: `cpu 50.554 14199724z57825`,
@@ -248,3 +260,104 @@ func errstr(err error) string {
 	}
 	return ""
 }
+
+func Test_DecodeMetric_Templates(t *testing.T) {
+	var tests = []struct {
+		test        string
+		templates   []string
+		defaultTags []string
+		line        string
+		name        string
+		field       string
+		tags        map[string]string
+		err         string
+	}{
+		{
+			test:      "template with filter",
+			templates: []string{"servers.* .host.measurement.field"},
+			line:      `servers.server01.cpu.idle 50 1000`,
+			name:      "cpu",
+			field:     "idle",
+			tags:      map[string]string{"host": "server01"},
+		},
+		{
+			test:      "most specific filter wins",
+			templates: []string{"servers.* .host.measurement.field", "servers.db.* ..host.measurement", "measurement*"},
+			line:      `servers.db.server01.disk 50 1000`,
+			name:      "disk",
+			field:     "disk",
+			tags:      map[string]string{"host": "server01"},
+		},
+		{
+			test:      "default template with tags",
+			templates: []string{"servers.* .host.measurement.field", "measurement* region=us-west"},
+			line:      `stats.requests.count 50 1000`,
+			name:      "stats.requests.count",
+			field:     "stats.requests.count",
+			tags:      map[string]string{"region": "us-west"},
+		},
+		{
+			test:        "default tags do not override metric tags",
+			templates:   []string{".host.measurement"},
+			defaultTags: []string{"host=default", "dc=east"},
+			line:        `servers.server01.cpu 50 1000`,
+			name:        "cpu",
+			field:       "cpu",
+			tags:        map[string]string{"host": "server01", "dc": "east"},
+		},
+		{
+			test:      "no template matches",
+			templates: []string{"servers.* .host.measurement.field"},
+			line:      `cpu.host.server01 50 1000`,
+			name:      "cpu",
+			field:     "cpu",
+			tags:      map[string]string{"host": "server01"},
+		},
+		{
+			test:      "no measurement in metric",
+			templates: []string{"servers.* ..measurement"},
+			line:      `servers.server01 50 1000`,
+			err:       `no measurement found for metric: "servers.server01"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Logf("testing %q...", test.test)
+
+		p := graphite.NewParser()
+		for _, s := range test.templates {
+			if err := p.AddTemplate(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tags, err := graphite.ParseTags(strings.Join(test.defaultTags, ","))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Tags = tags
+
+		point, err := p.Parse(test.line)
+		if errstr(err) != test.err {
+			t.Fatalf("err does not match.  expected %v, got %v", test.err, err)
+		} else if err != nil {
+			continue
+		}
+		if point.Name != test.name {
+			t.Fatalf("name parse failer.  expected %v, got %v", test.name, point.Name)
+		}
+		if _, ok := point.Fields[test.field]; !ok {
+			t.Fatalf("field not found.  expected %v, got %v", test.field, point.Fields)
+		}
+		if !reflect.DeepEqual(point.Tags, test.tags) {
+			t.Fatalf("unexpected tags.  expected %v, got %v", test.tags, point.Tags)
+		}
+	}
+}
+
+func Test_ParseTemplate_Err(t *testing.T) {
+	for _, s := range []string{"", ".host.field", "a b c d", "measurement region"} {
+		if _, err := graphite.ParseTemplate(s, "."); err == nil {
+			t.Fatalf("expected error for template %q", s)
+		}
+	}
+}
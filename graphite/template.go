@@ -0,0 +1,139 @@
+package graphite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Template maps the segments of a Graphite metric name to a measurement, tags
+// and a field. Each part of a template names what the matching segment of the
+// metric becomes:
+//
+//	measurement  - part of the measurement name; multiple parts are joined
+//	measurement* - the measurement name, consuming all remaining segments
+//	field        - the field name; defaults to the measurement name
+//	(empty)      - the segment is skipped
+//	(other)      - a tag with that key
+//
+// For example, the template ".host.measurement.field" maps the metric
+// "servers.server01.cpu.idle" to the measurement "cpu" with the field "idle"
+// and the tag "host=server01".
+type Template struct {
+	Filter []string          // leading segments of matching metrics; "*" matches any
+	Parts  []string          // what each segment of the metric maps to
+	Tags   map[string]string // default tags added to matching metrics
+}
+
+// ParseTemplate parses a template of the form "[filter] template [tags]" where
+// tags are comma-separated key=value pairs, e.g. "servers.* .host.measurement.field region=us-west".
+func ParseTemplate(s, separator string) (*Template, error) {
+	var filter, template, tags string
+	switch a := strings.Fields(s); len(a) {
+	case 1:
+		template = a[0]
+	case 2:
+		if strings.Contains(a[1], "=") {
+			template, tags = a[0], a[1]
+		} else {
+			filter, template = a[0], a[1]
+		}
+	case 3:
+		filter, template, tags = a[0], a[1], a[2]
+	default:
+		return nil, fmt.Errorf("invalid template: %q", s)
+	}
+
+	t := &Template{Parts: strings.Split(template, separator)}
+	if filter != "" {
+		t.Filter = strings.Split(filter, separator)
+	}
+
+	// A template must produce a measurement name.
+	var hasMeasurement bool
+	for _, part := range t.Parts {
+		if part == "measurement" || part == "measurement*" {
+			hasMeasurement = true
+		}
+	}
+	if !hasMeasurement {
+		return nil, fmt.Errorf("no measurement specified for template: %q", s)
+	}
+
+	m, err := ParseTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	t.Tags = m
+
+	return t, nil
+}
+
+// ParseTags parses comma-separated key=value pairs.
+func ParseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	if s == "" {
+		return tags, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		a := strings.SplitN(kv, "=", 2)
+		if len(a) != 2 || a[0] == "" || a[1] == "" {
+			return nil, fmt.Errorf("invalid tag: %q", kv)
+		}
+		tags[a[0]] = a[1]
+	}
+	return tags, nil
+}
+
+// Match returns true if the template applies to the metric segments.
+func (t *Template) Match(segments []string) bool {
+	if len(segments) < len(t.Filter) {
+		return false
+	}
+	for i, f := range t.Filter {
+		if f != "*" && f != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the measurement name, tags and field name for the metric segments.
+func (t *Template) Apply(segments []string, separator string) (string, map[string]string, string, error) {
+	var measurement []string
+	var field string
+	tags := make(map[string]string)
+	for k, v := range t.Tags {
+		tags[k] = v
+	}
+
+	for i, part := range t.Parts {
+		if i >= len(segments) {
+			break
+		}
+
+		switch part {
+		case "":
+		case "measurement":
+			measurement = append(measurement, segments[i])
+		case "measurement*":
+			measurement = append(measurement, segments[i:]...)
+		case "field":
+			field = segments[i]
+		default:
+			tags[part] = segments[i]
+		}
+
+		if part == "measurement*" {
+			break
+		}
+	}
+
+	name := strings.Join(measurement, separator)
+	if name == "" {
+		return "", nil, "", fmt.Errorf("no measurement found for metric: %q", strings.Join(segments, separator))
+	}
+	if field == "" {
+		field = name
+	}
+	return name, tags, field, nil
+}
@@ -0,0 +1,161 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// metadataHistoryMessageTypes are the commands that change databases, retention
+// policies or users. A snapshot of the metadata is kept after each one is applied.
+var metadataHistoryMessageTypes = map[messaging.MessageType]string{
+	createDatabaseMessageType:            "createDatabase",
+	dropDatabaseMessageType:              "dropDatabase",
+	renameDatabaseMessageType:            "renameDatabase",
+	cloneDatabaseMessageType:             "cloneDatabase",
+	setWriteLimitMessageType:             "setWriteLimit",
+	setNameRulesMessageType:              "setNameRules",
+	createRetentionPolicyMessageType:     "createRetentionPolicy",
+	updateRetentionPolicyMessageType:     "updateRetentionPolicy",
+	deleteRetentionPolicyMessageType:     "deleteRetentionPolicy",
+	setDefaultRetentionPolicyMessageType: "setDefaultRetentionPolicy",
+	createUserMessageType:                "createUser",
+	updateUserMessageType:                "updateUser",
+	deleteUserMessageType:                "deleteUser",
+	setPrivilegeMessageType:              "setPrivilege",
+	dropShardMessageType:                 "dropShard",
+	truncateShardGroupsMessageType:       "truncateShardGroups",
+}
+
+// MetadataChange describes a metadata command applied by the server.
+type MetadataChange struct {
+	Index uint64    `json:"index"`
+	Time  time.Time `json:"time"` // when the command was applied by this server
+	Type  string    `json:"type"`
+
+	// The command as it was broadcast. Omitted for user commands as they carry passwords.
+	Command json.RawMessage `json:"command,omitempty"`
+}
+
+// MetadataSnapshot represents the databases, retention policies and users
+// immediately after a metadata change.
+type MetadataSnapshot struct {
+	MetadataChange
+	Databases []*DatabaseMetadata `json:"databases"`
+	Users     []*UserMetadata     `json:"users"`
+}
+
+// DatabaseMetadata represents a database within a metadata snapshot.
+type DatabaseMetadata struct {
+	Name                   string             `json:"name"`
+	DefaultRetentionPolicy string             `json:"defaultRetentionPolicy,omitempty"`
+	RetentionPolicies      []*RetentionPolicy `json:"retentionPolicies"`
+}
+
+// UserMetadata represents a user within a metadata snapshot.
+type UserMetadata struct {
+	Name       string                        `json:"name"`
+	Admin      bool                          `json:"admin,omitempty"`
+	Privileges map[string]influxql.Privilege `json:"privileges,omitempty"`
+}
+
+// MetadataHistory returns the metadata changes applied by the server, oldest first.
+func (s *Server) MetadataHistory() (a []*MetadataChange, err error) {
+	err = s.meta.mustView(func(tx *metatx) error {
+		for _, ss := range tx.metadataSnapshots() {
+			a = append(a, &ss.MetadataChange)
+		}
+		return nil
+	})
+	return
+}
+
+// MetadataAt returns the metadata as of a broker index.
+// Returns ErrMetadataSnapshotNotFound if no changes were applied at or before the index.
+func (s *Server) MetadataAt(index uint64) (ss *MetadataSnapshot, err error) {
+	err = s.meta.mustView(func(tx *metatx) error {
+		if ss = tx.metadataSnapshot(index); ss == nil {
+			return ErrMetadataSnapshotNotFound
+		}
+		return nil
+	})
+	return
+}
+
+// MetadataAtTime returns the metadata as of a point in time.
+// Returns ErrMetadataSnapshotNotFound if no changes were applied at or before the time.
+func (s *Server) MetadataAtTime(t time.Time) (ss *MetadataSnapshot, err error) {
+	err = s.meta.mustView(func(tx *metatx) error {
+		for _, other := range tx.metadataSnapshots() {
+			if other.Time.After(t) {
+				break
+			}
+			ss = other
+		}
+		if ss == nil {
+			return ErrMetadataSnapshotNotFound
+		}
+		return nil
+	})
+	return
+}
+
+// recordMetadataChange saves a snapshot of the metadata after a metadata command
+// has been applied. The caller must hold the server lock.
+func (s *Server) recordMetadataChange(m *messaging.Message) {
+	typ, ok := metadataHistoryMessageTypes[m.Type]
+	if !ok {
+		return
+	}
+
+	ss := &MetadataSnapshot{
+		MetadataChange: MetadataChange{Index: m.Index, Time: time.Now().UTC(), Type: typ},
+		Databases:      []*DatabaseMetadata{},
+		Users:          []*UserMetadata{},
+	}
+	switch m.Type {
+	case createUserMessageType, updateUserMessageType:
+	default:
+		ss.Command = json.RawMessage(m.Data)
+	}
+
+	for _, db := range s.databases {
+		d := &DatabaseMetadata{Name: db.name, DefaultRetentionPolicy: db.defaultRetentionPolicy, RetentionPolicies: []*RetentionPolicy{}}
+		for _, rp := range db.policies {
+			d.RetentionPolicies = append(d.RetentionPolicies, rp)
+		}
+		sort.Sort(retentionPolicies(d.RetentionPolicies))
+		ss.Databases = append(ss.Databases, d)
+	}
+	sort.Sort(databaseMetadatas(ss.Databases))
+
+	for _, u := range s.users {
+		ss.Users = append(ss.Users, &UserMetadata{Name: u.Name, Admin: u.Admin, Privileges: u.Privileges})
+	}
+	sort.Sort(userMetadatas(ss.Users))
+
+	_ = s.meta.mustUpdate(0, func(tx *metatx) error {
+		return tx.saveMetadataSnapshot(ss)
+	})
+}
+
+type databaseMetadatas []*DatabaseMetadata
+
+func (a databaseMetadatas) Len() int           { return len(a) }
+func (a databaseMetadatas) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a databaseMetadatas) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type userMetadatas []*UserMetadata
+
+func (a userMetadatas) Len() int           { return len(a) }
+func (a userMetadatas) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a userMetadatas) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type retentionPolicies []*RetentionPolicy
+
+func (a retentionPolicies) Len() int           { return len(a) }
+func (a retentionPolicies) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a retentionPolicies) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
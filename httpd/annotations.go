@@ -0,0 +1,124 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// annotationRequest is the body of a request to write an annotation.
+type annotationRequest struct {
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retentionPolicy"`
+	influxdb.Annotation
+}
+
+// serveCreateAnnotation writes an annotation passed as a JSON object with a
+// database, optional retention policy, start and end times, text and tags.
+// Annotations are written like points so the same privileges are required.
+func (h *Handler) serveCreateAnnotation(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	var req annotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "error reading annotation: "+err.Error(), false, http.StatusBadRequest)
+		return
+	} else if req.Database == "" {
+		httpError(w, "database is required", false, http.StatusBadRequest)
+		return
+	} else if !h.server.DatabaseExists(req.Database) {
+		httpError(w, fmt.Sprintf("database not found: %q", req.Database), false, http.StatusNotFound)
+		return
+	}
+
+	if h.requireAuthentication {
+		if user == nil {
+			httpError(w, fmt.Sprintf("user is required to write to database %q", req.Database), false, http.StatusUnauthorized)
+			return
+		} else if !user.Authorize(influxql.WritePrivilege, req.Database) || !user.AuthorizeMeasurement(req.Database, influxdb.AnnotationMeasurement) {
+			httpError(w, fmt.Sprintf("%q user is not authorized to write annotations to database %q", user.Name, req.Database), false, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Tags injected into writes are applied to annotations too.
+	tags, err := h.writeTags(r, user)
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusBadRequest)
+		return
+	} else if len(tags) > 0 {
+		if req.Tags == nil {
+			req.Tags = make(map[string]string, len(tags))
+		}
+		for k, v := range tags {
+			req.Tags[k] = v
+		}
+	}
+
+	index, err := h.server.WriteAnnotation(user, req.Database, req.RetentionPolicy, &req.Annotation)
+	if err != nil {
+		httpError(w, err.Error(), false, errorStatusCode(err))
+		return
+	}
+	w.Header().Add("X-InfluxDB-Index", fmt.Sprintf("%d", index))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveAnnotations returns the annotations in the database given by "db"
+// as a JSON array ordered by start time. The optional "start" and "end"
+// parameters limit them to those overlapping a time range and "tags", as
+// comma-separated key=value pairs, to those with matching tags.
+func (h *Handler) serveAnnotations(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	q := r.URL.Query()
+	pretty := q.Get("pretty") == "true"
+	db := q.Get("db")
+	if db == "" {
+		httpError(w, "database is required", pretty, http.StatusBadRequest)
+		return
+	} else if h.requireAuthentication && user == nil {
+		httpError(w, fmt.Sprintf("user is required to read from database %q", db), pretty, http.StatusUnauthorized)
+		return
+	}
+
+	var start, end time.Time
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"start", &start}, {"end", &end}} {
+		if s := q.Get(p.name); s != "" {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				httpError(w, fmt.Sprintf("invalid %s: %q", p.name, s), pretty, http.StatusBadRequest)
+				return
+			}
+			*p.t = t
+		}
+	}
+
+	var tags map[string]string
+	if s := q.Get("tags"); s != "" {
+		var ok bool
+		if tags, ok = parseTags(s); !ok {
+			httpError(w, fmt.Sprintf("invalid tags: %q", s), pretty, http.StatusBadRequest)
+			return
+		}
+	}
+
+	a, err := h.server.Annotations(user, db, q.Get("rp"), start, end, tags)
+	if err != nil {
+		httpError(w, err.Error(), pretty, errorStatusCode(err))
+		return
+	} else if a == nil {
+		a = []*influxdb.Annotation{}
+	}
+
+	w.Header().Add("content-type", "application/json")
+	if pretty {
+		b, _ := json.MarshalIndent(a, "", "    ")
+		w.Write(b)
+	} else {
+		_ = json.NewEncoder(w).Encode(a)
+	}
+}
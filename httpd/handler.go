@@ -1,6 +1,7 @@
 package httpd
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,10 +10,17 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"net/http/pprof"
 	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"compress/gzip"
@@ -22,6 +30,7 @@ import (
 	"github.com/bmizerany/pat"
 	"github.com/influxdb/influxdb"
 	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/pipeline"
 )
 
 // TODO: Standard response headers (see: HeaderHandler)
@@ -41,12 +50,85 @@ type route struct {
 // Handler represents an HTTP handler for the InfluxDB server.
 type Handler struct {
 	server                *influxdb.Server
-	routes                []route
 	mux                   *pat.PatternServeMux
 	requireAuthentication bool
+	version               string
+
+	mu         sync.Mutex
+	routes     []route
+	middleware []Middleware
+	frozen     bool
+	freezeOnce sync.Once
+
+	Logger       *log.Logger
+	WriteTrace   bool // Detailed logging of write path
+	PprofEnabled bool // Serve profiling and debug endpoints to admin users
+
+	// Write tracing is sampled to keep logging volume down. When WriteTrace
+	// is set, every Nth write is traced, or every write if N is zero or one.
+	// Writes to the listed databases are always traced.
+	WriteTraceSampleN   int
+	WriteTraceDatabases map[string]bool
+
+	// Most bytes of a traced write's body that are logged. Longer bodies
+	// are truncated. Unlimited if zero.
+	WriteTraceMaxBytes int
+
+	writeN uint64 // write requests seen, used for sampling
+
+	// Route patterns, such as "/ping", served without authentication when
+	// authentication is enabled. Set to DefaultAuthExemptions by NewHandler.
+	// CORS preflight requests never require authentication.
+	AuthExemptions map[string]bool
+
+	// Lifetime of sessions started through /login. DefaultSessionTimeout
+	// is used if zero.
+	SessionTimeout time.Duration
+	sessions       sessions
+
+	// User-defined functions pipeline queries can run, by name.
+	UDFs map[string]*pipeline.UDF
+
+	// Longest a query waits for the index set by its min_index parameter to
+	// be applied. DefaultMaxIndexWait is used if zero.
+	MaxIndexWait time.Duration
+
+	// Tag set to the name of the authenticated user on every point written,
+	// replacing any value sent by the client. Disabled if blank.
+	UserTag string
+
+	// Accept tags for every point of a write in TagsHeader. The header is
+	// rejected if this isn't set. The tags replace any sent with the points.
+	TagsHeaderEnabled bool
+
+	// Returns the topic segments truncated when the local broker opened, if
+	// the node runs a broker. Included in the /recovery report.
+	TruncatedSegments func() []string
+}
 
-	Logger     *log.Logger
-	WriteTrace bool // Detailed logging of write path
+// DefaultWriteTraceMaxBytes is the default number of bytes of a traced write's
+// body that are logged.
+const DefaultWriteTraceMaxBytes = 4096
+
+// DefaultMaxIndexWait is the default longest time a query waits for the
+// index set by its min_index parameter.
+const DefaultMaxIndexWait = 10 * time.Second
+
+// DefaultAuthExemptions are the route patterns served without authentication
+// by default. They include the routes that nodes use to talk to each other,
+// which must stay exempt unless nodes are given credentials.
+var DefaultAuthExemptions = []string{
+	"/",
+	"/data_nodes",
+	"/data_nodes/:id",
+	"/login",
+	"/logout",
+	"/metastore",
+	"/ping",
+	"/process_continuous_queries",
+	"/ready",
+	"/status",
+	"/wait/:index",
 }
 
 // NewHandler returns a new instance of Handler.
@@ -55,14 +137,28 @@ func NewHandler(s *influxdb.Server, requireAuthentication bool, version string)
 		server: s,
 		mux:    pat.New(),
 		requireAuthentication: requireAuthentication,
+		version:               version,
 		Logger:                log.New(os.Stderr, "[http] ", log.LstdFlags),
+		AuthExemptions:        make(map[string]bool),
+		WriteTraceMaxBytes:    DefaultWriteTraceMaxBytes,
+	}
+	for _, pattern := range DefaultAuthExemptions {
+		h.AuthExemptions[pattern] = true
 	}
 
 	h.routes = append(h.routes,
+		route{
+			"query", // Query serving route.
+			"OPTIONS", "/query", true, true, h.serveOptions,
+		},
 		route{
 			"query", // Query serving route.
 			"GET", "/query", true, true, h.serveQuery,
 		},
+		route{
+			"query", // Query serving route.
+			"POST", "/query", true, true, h.serveQuery,
+		},
 		route{
 			"write", // Data-ingest route.
 			"OPTIONS", "/write", true, true, h.serveOptions,
@@ -71,26 +167,78 @@ func NewHandler(s *influxdb.Server, requireAuthentication bool, version string)
 			"write", // Data-ingest route.
 			"POST", "/write", true, true, h.serveWrite,
 		},
-		route{ // List data nodes
-			"data_nodes_index",
-			"GET", "/data_nodes", true, false, h.serveDataNodes,
+		route{ // Pipeline query preflight
+			"pipeline_options",
+			"OPTIONS", "/pipeline", true, true, h.serveOptions,
+		},
+		route{ // Pipeline query
+			"pipeline",
+			"GET", "/pipeline", true, true, h.servePipeline,
+		},
+		route{ // Pipeline query
+			"pipeline",
+			"POST", "/pipeline", true, true, h.servePipeline,
+		},
+		route{ // Annotations preflight
+			"annotations_options",
+			"OPTIONS", "/annotations", true, true, h.serveOptions,
+		},
+		route{ // List annotations
+			"annotations",
+			"GET", "/annotations", true, true, h.serveAnnotations,
+		},
+		route{ // Write an annotation
+			"annotations_create",
+			"POST", "/annotations", true, true, h.serveCreateAnnotation,
+		},
+		route{ // Data node preflight
+			"data_nodes_options",
+			"OPTIONS", "/data_nodes", true, false, h.serveOptions,
 		},
 		route{ // Create data node
 			"data_nodes_create",
 			"POST", "/data_nodes", true, false, h.serveCreateDataNode,
 		},
+		route{ // Data node preflight
+			"data_nodes_options",
+			"OPTIONS", "/data_nodes/:id", true, false, h.serveOptions,
+		},
 		route{ // Delete data node
 			"data_nodes_delete",
 			"DELETE", "/data_nodes/:id", true, false, h.serveDeleteDataNode,
 		},
+		route{ // Login preflight
+			"login_options",
+			"OPTIONS", "/login", true, true, h.serveOptions,
+		},
+		route{ // Start a session
+			"login",
+			"POST", "/login", true, true, h.serveLogin,
+		},
+		route{ // End a session
+			"logout",
+			"POST", "/logout", true, true, h.serveLogout,
+		},
 		route{ // Metastore
 			"metastore",
 			"GET", "/metastore", false, false, h.serveMetastore,
 		},
+		route{ // Status
+			"status",
+			"OPTIONS", "/status", true, true, h.serveOptions,
+		},
 		route{ // Status
 			"status",
 			"GET", "/status", true, true, h.serveStatus,
 		},
+		route{ // Startup recovery report
+			"recovery",
+			"GET", "/recovery", true, true, h.serveRecovery,
+		},
+		route{ // Ping
+			"ping",
+			"OPTIONS", "/ping", true, true, h.serveOptions,
+		},
 		route{ // Ping
 			"ping",
 			"GET", "/ping", true, true, h.servePing,
@@ -99,37 +247,230 @@ func NewHandler(s *influxdb.Server, requireAuthentication bool, version string)
 			"ping-head",
 			"HEAD", "/ping", true, true, h.servePing,
 		},
+		route{ // Readiness
+			"ready",
+			"OPTIONS", "/ready", true, true, h.serveOptions,
+		},
+		route{ // Readiness
+			"ready",
+			"GET", "/ready", true, true, h.serveReady,
+		},
+		route{ // Readiness
+			"ready-head",
+			"HEAD", "/ready", true, true, h.serveReady,
+		},
+		route{ // Read-only mode preflight
+			"read_only_options",
+			"OPTIONS", "/read_only", true, true, h.serveOptions,
+		},
+		route{ // Read-only mode
+			"read_only",
+			"GET", "/read_only", true, true, h.serveReadOnly,
+		},
+		route{ // Toggle read-only mode
+			"read_only_update",
+			"PUT", "/read_only", true, true, h.serveUpdateReadOnly,
+		},
+		route{ // Compaction settings preflight
+			"compaction_options",
+			"OPTIONS", "/compaction", true, true, h.serveOptions,
+		},
+		route{ // Compaction settings
+			"compaction",
+			"GET", "/compaction", true, true, h.serveCompaction,
+		},
+		route{ // Update compaction settings
+			"compaction_update",
+			"PUT", "/compaction", true, true, h.serveUpdateCompaction,
+		},
+		route{ // Background IO budgets preflight
+			"io_options",
+			"OPTIONS", "/io", true, true, h.serveOptions,
+		},
+		route{ // Background IO budgets
+			"io",
+			"GET", "/io", true, true, h.serveIO,
+		},
+		route{ // Update background IO budgets
+			"io_update",
+			"PUT", "/io", true, true, h.serveUpdateIO,
+		},
+		route{ // Shard statistics preflight
+			"shards_options",
+			"OPTIONS", "/shards", true, true, h.serveOptions,
+		},
+		route{ // Shard statistics
+			"shards",
+			"GET", "/shards", true, true, h.serveShards,
+		},
+		route{ // Shard archive, already gzipped
+			"shard_export",
+			"GET", "/shards/:id/export", false, true, h.serveExportShard,
+		},
+		route{ // Import a shard archive
+			"shard_import",
+			"POST", "/shards/import", true, true, h.serveImportShard,
+		},
+		route{ // Tag rewrite preflight
+			"tag_rewrites_options",
+			"OPTIONS", "/tag_rewrites", true, true, h.serveOptions,
+		},
+		route{ // List tag rewrite jobs
+			"tag_rewrites_index",
+			"GET", "/tag_rewrites", true, true, h.serveTagRewrites,
+		},
+		route{ // Start a tag rewrite job
+			"tag_rewrites_create",
+			"POST", "/tag_rewrites", true, true, h.serveCreateTagRewrite,
+		},
+		route{ // Tag rewrite job progress
+			"tag_rewrites_show",
+			"GET", "/tag_rewrites/:id", true, true, h.serveTagRewrite,
+		},
+		route{ // Field conversion preflight
+			"field_conversions_options",
+			"OPTIONS", "/field_conversions", true, true, h.serveOptions,
+		},
+		route{ // List field conversion jobs
+			"field_conversions_index",
+			"GET", "/field_conversions", true, true, h.serveFieldConversions,
+		},
+		route{ // Field conversion job progress
+			"field_conversions_show",
+			"GET", "/field_conversions/:id", true, true, h.serveFieldConversion,
+		},
+		route{ // Schema preflight
+			"schema_options",
+			"OPTIONS", "/schema", true, true, h.serveOptions,
+		},
+		route{ // Schema introspection
+			"schema",
+			"GET", "/schema", true, true, h.serveSchema,
+		},
+		route{ // Metadata as of an index or time
+			"metadata",
+			"GET", "/metadata", true, true, h.serveMetadata,
+		},
+		route{ // Metadata change history
+			"metadata_history",
+			"GET", "/metadata/history", true, true, h.serveMetadataHistory,
+		},
+		route{ // Subscription preflight
+			"subscribe_options",
+			"OPTIONS", "/subscribe", true, true, h.serveOptions,
+		},
+		route{ // Stream written points over a websocket
+			"subscribe",
+			"GET", "/subscribe", false, true, h.serveSubscribe,
+		},
+		route{ // Events preflight
+			"events_options",
+			"OPTIONS", "/events", true, true, h.serveOptions,
+		},
+		route{ // Stream written points as server-sent events
+			"events",
+			"GET", "/events", false, true, h.serveEvents,
+		},
 		route{ // Tell data node to run CQs that should be run
 			"process_continuous_queries",
 			"POST", "/process_continuous_queries", false, false, h.serveProcessContinuousQueries,
 		},
+		route{
+			"wait", // Wait.
+			"OPTIONS", "/wait/:index", true, true, h.serveOptions,
+		},
 		route{
 			"wait", // Wait.
 			"GET", "/wait/:index", true, true, h.serveWait,
 		},
+		route{ // Profiling
+			"pprof",
+			"GET", "/debug/pprof/", false, false, h.servePprof,
+		},
+		route{ // Profiling symbol lookups
+			"pprof",
+			"POST", "/debug/pprof/symbol", false, false, h.servePprof,
+		},
+		route{ // Write goroutine and heap dumps to disk
+			"dump",
+			"POST", "/debug/dump", false, true, h.serveDump,
+		},
+		route{
+			"index", // Index.
+			"OPTIONS", "/", true, true, h.serveOptions,
+		},
 		route{
 			"index", // Index.
 			"GET", "/", true, true, h.serveIndex,
 		},
 	)
 
-	for _, r := range h.routes {
-		var handler http.Handler
+	return h
+}
 
-		// If it's a handler func that requires authorization, wrap it in authorization
-		if hf, ok := r.handlerFunc.(func(http.ResponseWriter, *http.Request, *influxdb.User)); ok {
-			handler = authenticate(hf, h, requireAuthentication)
-		}
-		// This is a normal handler signature and does not require authorization
-		if hf, ok := r.handlerFunc.(func(http.ResponseWriter, *http.Request)); ok {
-			handler = http.HandlerFunc(hf)
+// Middleware wraps the handler of a route.
+type Middleware func(http.Handler) http.Handler
+
+// Use adds middleware that wraps every route, including custom routes.
+// Middleware runs after request IDs are assigned and before authentication,
+// in the order it was added. Panics if the handler has started serving.
+func (h *Handler) Use(m Middleware) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.frozen {
+		panic("httpd: middleware added after handler started serving")
+	}
+	h.middleware = append(h.middleware, m)
+}
+
+// HandleFunc registers a custom route. fn must be a
+// func(http.ResponseWriter, *http.Request) or a
+// func(http.ResponseWriter, *http.Request, *influxdb.User); routes are
+// authenticated like the built-in routes either way and the latter is passed
+// the authenticated user. Built-in routes take precedence over custom routes
+// with the same pattern. Panics if the handler has started serving.
+func (h *Handler) HandleFunc(name, method, pattern string, fn interface{}) {
+	switch fn.(type) {
+	case func(http.ResponseWriter, *http.Request), func(http.ResponseWriter, *http.Request, *influxdb.User):
+	default:
+		panic(fmt.Sprintf("httpd: invalid handler func for route %s: %T", name, fn))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.frozen {
+		panic("httpd: route added after handler started serving")
+	}
+	h.routes = append(h.routes, route{name, method, pattern, true, true, fn})
+}
+
+// freeze adds the routes to the mux. Routes and middleware can't be added once
+// the handler is frozen.
+func (h *Handler) freeze() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.frozen = true
+
+	for _, r := range h.routes {
+		// Every route is authenticated unless it is exempt. Handlers that
+		// don't use the user are passed through once it is authenticated.
+		var inner func(http.ResponseWriter, *http.Request, *influxdb.User)
+		switch hf := r.handlerFunc.(type) {
+		case func(http.ResponseWriter, *http.Request, *influxdb.User):
+			inner = hf
+		case func(http.ResponseWriter, *http.Request):
+			inner = func(w http.ResponseWriter, r *http.Request, _ *influxdb.User) { hf(w, r) }
 		}
+		handler := authenticate(inner, h, h.requireAuthentication, r.pattern)
 
 		if r.gzipped {
 			handler = gzipFilter(handler)
 		}
-		handler = versionHeader(handler, version)
+		handler = versionHeader(handler, h.version)
 		handler = cors(handler)
+		for i := len(h.middleware) - 1; i >= 0; i-- {
+			handler = h.middleware[i](handler)
+		}
 		handler = requestID(handler)
 		if r.log {
 			handler = logging(handler, r.name, h.Logger)
@@ -138,8 +479,6 @@ func NewHandler(s *influxdb.Server, requireAuthentication bool, version string)
 
 		h.mux.Add(r.method, r.pattern, handler)
 	}
-
-	return h
 }
 
 // SetLogOutput sets writer for all handler log output.
@@ -147,283 +486,1391 @@ func (h *Handler) SetLogOutput(w io.Writer) {
 	h.Logger = log.New(w, "[http] ", log.LstdFlags)
 }
 
-// ServeHTTP responds to HTTP request to the handler.
+// ServeHTTP responds to HTTP request to the handler. The handler's routes are
+// frozen on the first request.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.freezeOnce.Do(h.freeze)
 	h.mux.ServeHTTP(w, r)
 }
 
-// serveQuery parses an incoming query and, if valid, executes the query.
-func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+// queryRequest represents the parameters of a query request.
+type queryRequest struct {
+	Query    string `json:"q"`
+	Database string `json:"db"`
+	Pretty   bool   `json:"pretty"`
+	Epoch    string `json:"epoch"`
+	Stats    bool   `json:"stats"`     // include execution statistics
+	Timeout  string `json:"timeout"`   // maximum execution time, e.g. "30s"
+	MinIndex uint64 `json:"min_index"` // index the node must apply before running the query
+
+	// Name of a stored query to run instead of q and the values of its
+	// bound parameters.
+	Name   string                 `json:"name"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// parseQueryRequest reads the query parameters from the URL or, for POST
+// requests, from a form-encoded or JSON body.
+func parseQueryRequest(r *http.Request) (*queryRequest, error) {
 	q := r.URL.Query()
-	p := influxql.NewParser(strings.NewReader(q.Get("q")))
-	db := q.Get("db")
-	pretty := q.Get("pretty") == "true"
+	if r.Method == "POST" {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			qr := &queryRequest{Pretty: q.Get("pretty") == "true", Epoch: q.Get("epoch"), Stats: q.Get("stats") == "true", Timeout: q.Get("timeout")}
+			if err := qr.parseMinIndex(q.Get("min_index")); err != nil {
+				return nil, err
+			}
+			if err := json.NewDecoder(r.Body).Decode(qr); err != nil {
+				return nil, err
+			}
+			return qr, nil
+		}
+
+		// Body values take precedence over the URL.
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		q = r.Form
+	}
+
+	qr := &queryRequest{
+		Query:    q.Get("q"),
+		Database: q.Get("db"),
+		Pretty:   q.Get("pretty") == "true",
+		Epoch:    q.Get("epoch"),
+		Stats:    q.Get("stats") == "true",
+		Timeout:  q.Get("timeout"),
+		Name:     q.Get("name"),
+	}
+	if err := qr.parseMinIndex(q.Get("min_index")); err != nil {
+		return nil, err
+	}
+
+	// Parameters are passed as a JSON object.
+	if params := q.Get("params"); params != "" {
+		if err := json.Unmarshal([]byte(params), &qr.Params); err != nil {
+			return nil, fmt.Errorf("invalid params: %s", err)
+		}
+	}
+	return qr, nil
+}
+
+// parseMinIndex sets the request's MinIndex from a parameter value, if set.
+func (qr *queryRequest) parseMinIndex(s string) error {
+	if s == "" {
+		return nil
+	}
+	index, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid min_index: %q", s)
+	}
+	qr.MinIndex = index
+	return nil
+}
 
-	// Parse query from query string.
-	query, err := p.ParseQuery()
+// serveQuery parses an incoming query and, if valid, executes the query.
+func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	qr, err := parseQueryRequest(r)
 	if err != nil {
+		httpError(w, "error reading query: "+err.Error(), r.URL.Query().Get("pretty") == "true", http.StatusBadRequest)
+		return
+	}
+	p := influxql.NewParser(strings.NewReader(qr.Query))
+	db := qr.Database
+	pretty := qr.Pretty
+
+	// Timestamps are returned as RFC3339 strings unless an epoch precision is set.
+	var precision time.Duration
+	if qr.Epoch != "" {
+		if precision = epochPrecisions[qr.Epoch]; precision == 0 {
+			httpError(w, fmt.Sprintf("invalid epoch: %q", qr.Epoch), pretty, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Queries run until they complete unless a timeout is set. The server's
+	// maximum timeout applies either way.
+	var timeout time.Duration
+	if qr.Timeout != "" {
+		if timeout, err = time.ParseDuration(qr.Timeout); err != nil || timeout <= 0 {
+			httpError(w, fmt.Sprintf("invalid timeout: %q", qr.Timeout), pretty, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Parse query from query string or look up the stored query.
+	var query *influxql.Query
+	if qr.Name != "" {
+		if qr.Query != "" {
+			httpError(w, "q and name cannot both be set", pretty, http.StatusBadRequest)
+			return
+		} else if h.requireAuthentication && (user == nil || !user.Authorize(influxql.ReadPrivilege, db)) {
+			httpError(w, fmt.Sprintf("user is not authorized to read from database %q", db), pretty, http.StatusUnauthorized)
+			return
+		}
+
+		sq, err := h.server.StoredQuery(db, qr.Name)
+		if err == influxdb.ErrStoredQueryNotFound || err == influxdb.ErrDatabaseNotFound {
+			httpError(w, err.Error(), pretty, http.StatusNotFound)
+			return
+		} else if err != nil {
+			httpError(w, err.Error(), pretty, http.StatusInternalServerError)
+			return
+		}
+
+		if query, err = sq.Bind(qr.Params); err != nil {
+			httpError(w, "error binding query: "+err.Error(), pretty, http.StatusBadRequest)
+			return
+		}
+	} else if query, err = p.ParseQuery(); err != nil {
 		httpError(w, "error parsing query: "+err.Error(), pretty, http.StatusBadRequest)
 		return
 	}
 
+	// Wait for a write the client made, possibly through another node, to be
+	// applied so the query sees it. The wait is bounded by the timeout.
+	if qr.MinIndex > 0 {
+		wait := h.MaxIndexWait
+		if wait <= 0 {
+			wait = DefaultMaxIndexWait
+		}
+		if timeout > 0 && timeout < wait {
+			wait = timeout
+		}
+		if err := h.server.WaitForIndex(qr.MinIndex, wait); err != nil {
+			httpError(w, fmt.Sprintf("%s %d", err, qr.MinIndex), pretty, http.StatusServiceUnavailable)
+			return
+		}
+	}
+
 	// Execute query. One result will return for each statement.
-	results := h.server.ExecuteQuery(query, db, user)
+	// The query is stopped if the client disconnects before it completes.
+	closing, done := closeNotify(w)
+	defer done()
+	results := h.server.ExecuteQueryWithOptions(query, db, user, influxdb.QueryOptions{
+		Stats:   qr.Stats,
+		Timeout: timeout,
+		Closing: closing,
+	})
+	if precision != 0 {
+		convertToEpoch(results, precision)
+	}
 
 	// Send results to client.
 	httpResults(w, results, pretty)
 }
 
+// epochPrecisions maps the values of the epoch query parameter to the unit
+// timestamps are returned in.
+var epochPrecisions = map[string]time.Duration{
+	"h":  time.Hour,
+	"m":  time.Minute,
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"u":  time.Microsecond,
+	"n":  time.Nanosecond,
+}
+
+// convertToEpoch replaces the timestamps in results with integer counts of
+// precision since the Unix epoch.
+func convertToEpoch(results influxdb.Results, precision time.Duration) {
+	for _, result := range results.Results {
+		if result == nil {
+			continue
+		}
+		for _, row := range result.Series {
+			for _, values := range row.Values {
+				for i, v := range values {
+					if t, ok := v.(time.Time); ok {
+						values[i] = t.UnixNano() / int64(precision)
+					}
+				}
+			}
+		}
+	}
+}
+
 // serveWrite receives incoming series data and writes it to the database.
 func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
 	var bp influxdb.BatchPoints
 	var dec *json.Decoder
+	var body []byte
+
+	var writeError = func(result influxdb.Result, statusCode int) {
+		w.WriteHeader(statusCode)
+		w.Header().Add("content-type", "application/json")
+		_ = json.NewEncoder(w).Encode(&result)
+		return
+	}
+
+	mode, err := influxdb.ParseParseMode(r.URL.Query().Get("parse"))
+	if err != nil {
+		writeError(influxdb.Result{Err: err}, http.StatusBadRequest)
+		return
+	}
+	wait, err := influxdb.ParseWaitMode(r.URL.Query().Get("wait"))
+	if err != nil {
+		writeError(influxdb.Result{Err: err}, http.StatusBadRequest)
+		return
+	}
+
+	// Tags injected by the server are applied after the points are
+	// normalized so they can't be overridden by the body.
+	tags, err := h.writeTags(r, user)
+	if err != nil {
+		writeError(influxdb.Result{Err: err}, http.StatusBadRequest)
+		return
+	}
 
-	if h.WriteTrace {
-		b, err := ioutil.ReadAll(r.Body)
+	// Copy the start of the body as it is read so it can be logged if the
+	// request is traced. The whole body is buffered only if it is parsed
+	// strictly, as it is checked after it is decoded.
+	trace := h.sampleWrite()
+	start := time.Now()
+	received := &countingReader{r: r.Body}
+	var src io.Reader = received
+	var logged *truncatedBuffer
+	if h.WriteTrace || len(h.WriteTraceDatabases) > 0 {
+		logged = &truncatedBuffer{max: h.WriteTraceMaxBytes}
+		src = io.TeeReader(src, logged)
+	}
+	if mode == influxdb.StrictParse {
+		b, err := ioutil.ReadAll(src)
 		if err != nil {
 			h.Logger.Print("write handler failed to read bytes from request body")
-		} else {
-			h.Logger.Printf("write body received by handler: %s", string(b))
 		}
-		dec = json.NewDecoder(strings.NewReader(string(b)))
+		body = b
+		dec = json.NewDecoder(bytes.NewReader(b))
 	} else {
-		dec = json.NewDecoder(r.Body)
+		dec = json.NewDecoder(src)
+	}
+
+	if err := dec.Decode(&bp); err != nil {
+		if err.Error() == "EOF" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		writeError(influxdb.Result{Err: err}, http.StatusInternalServerError)
+		return
+	}
+
+	if mode == influxdb.StrictParse {
+		if err := influxdb.CheckBatchPointsJSON(body); err != nil {
+			writeError(influxdb.Result{Err: err}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The retention policy may be set in the body or with the "rp" parameter.
+	// The database's default retention policy is used if neither is set.
+	if rp := r.URL.Query().Get("rp"); rp != "" {
+		if bp.RetentionPolicy != "" && bp.RetentionPolicy != rp {
+			writeError(influxdb.Result{Err: fmt.Errorf("retention policy %q does not match rp parameter %q", bp.RetentionPolicy, rp)}, http.StatusBadRequest)
+			return
+		}
+		bp.RetentionPolicy = rp
+	}
+
+	t := &writeTrace{database: bp.Database, retentionPolicy: bp.RetentionPolicy, parse: time.Since(start)}
+	if trace = trace || h.WriteTraceDatabases[bp.Database]; trace {
+		h.Logger.Printf("write body received by handler: %s %s", redactedRequestURI(r.URL), logged)
+		defer func() { h.Logger.Printf("write trace: %s", t) }()
+	}
+
+	if bp.Database == "" {
+		writeError(influxdb.Result{Err: fmt.Errorf("database is required")}, http.StatusInternalServerError)
+		return
+	}
+
+	if !h.server.DatabaseExists(bp.Database) {
+		writeError(influxdb.Result{Err: fmt.Errorf("database not found: %q", bp.Database)}, http.StatusNotFound)
+		return
+	}
+	h.server.WriteStats.RecordBytesReceived(bp.Database, received.n)
+
+	if h.requireAuthentication && user == nil {
+		writeError(influxdb.Result{Err: fmt.Errorf("user is required to write to database %q", bp.Database)}, http.StatusUnauthorized)
+		return
+	}
+
+	if h.requireAuthentication && !user.Authorize(influxql.WritePrivilege, bp.Database) {
+		writeError(influxdb.Result{Err: fmt.Errorf("%q user is not authorized to write to database %q", user.Name, bp.Database)}, http.StatusUnauthorized)
+		return
+	}
+
+	// Measurement names are normalized by the database's name rules, if any.
+	rules, _ := h.server.NameRules(bp.Database)
+
+	// Users may be restricted to writing some measurements of the database.
+	if h.requireAuthentication {
+		for _, p := range bp.Points {
+			name := p.Name
+			if rules != nil {
+				name = rules.Normalize(name)
+			}
+			if !user.AuthorizeMeasurement(bp.Database, name) {
+				writeError(influxdb.Result{Err: fmt.Errorf("%q user is not authorized to write to measurement %q in database %q", user.Name, name, bp.Database)}, http.StatusUnauthorized)
+				return
+			}
+		}
+	}
+
+	start = time.Now()
+	points, err := influxdb.NormalizeBatchPoints(bp, mode, rules)
+	t.normalize, t.points, t.err = time.Since(start), len(points), err
+	if err != nil {
+		h.server.WriteStats.RecordDropped(bp.Database, "", influxdb.DropReasonInvalid, len(bp.Points))
+		writeError(influxdb.Result{Err: err}, http.StatusBadRequest)
+		return
+	}
+	injectTags(points, tags)
+
+	// Validate the points without writing them if this is a dry run.
+	if r.URL.Query().Get("dry_run") == "true" {
+		v, err := h.server.ValidateSeries(bp.Database, bp.RetentionPolicy, points)
+		if err != nil {
+			writeError(influxdb.Result{Err: err}, errorStatusCode(err))
+			return
+		}
+		w.Header().Add("content-type", "application/json")
+		if len(v.Errors) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		_ = json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	start = time.Now()
+	index, err := h.server.WriteSeriesWait(user, bp.Database, bp.RetentionPolicy, points, wait)
+	t.write, t.err = time.Since(start), err
+	if err == nil && wait == influxdb.WaitNone {
+		// The points haven't been written yet so there is no index.
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if _, ok := err.(*influxdb.PointError); ok {
+		writeError(influxdb.Result{Err: err}, errorStatusCode(err))
+		return
 	}
+	switch err {
+	case nil:
+		w.Header().Add("X-InfluxDB-Index", fmt.Sprintf("%d", index))
+	case influxdb.ErrReadOnly, influxdb.ErrSeriesQuotaExceeded, influxdb.ErrDiskQuotaExceeded, influxdb.ErrWriteRateQuotaExceeded, influxdb.ErrWriteThrottled,
+		influxdb.ErrRetentionPolicyNotFound, influxdb.ErrDefaultRetentionPolicyNotFound, influxdb.ErrNonFiniteFieldValue, influxdb.ErrDuplicatePointRejected:
+		writeError(influxdb.Result{Err: err}, errorStatusCode(err))
+	default:
+		writeError(influxdb.Result{Err: err}, http.StatusInternalServerError)
+	}
+}
+
+// TagsHeader is the request header holding tags, as comma-separated key=value
+// pairs, applied to every point of a write.
+const TagsHeader = "X-Influxdb-Tags"
+
+// writeTags returns the tags applied to every point written by a request,
+// from TagsHeader and the handler's UserTag.
+func (h *Handler) writeTags(r *http.Request, user *influxdb.User) (map[string]string, error) {
+	tags := make(map[string]string)
+	if s := r.Header.Get(TagsHeader); s != "" {
+		if !h.TagsHeaderEnabled {
+			return nil, fmt.Errorf("%s header is not enabled", TagsHeader)
+		}
+		var ok bool
+		if tags, ok = parseTags(s); !ok {
+			return nil, fmt.Errorf("invalid %s header: %q", TagsHeader, s)
+		}
+	}
+
+	// The user tag takes precedence so it can't be spoofed with the header.
+	if h.UserTag != "" && user != nil {
+		tags[h.UserTag] = user.Name
+	}
+	return tags, nil
+}
+
+// parseTags parses comma-separated key=value pairs. Returns false if a pair
+// is malformed or has a blank key or value.
+func parseTags(s string) (map[string]string, bool) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, false
+		}
+		tags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return tags, true
+}
+
+// injectTags sets tags on each point, replacing existing values.
+func injectTags(points []influxdb.Point, tags map[string]string) {
+	if len(tags) == 0 {
+		return
+	}
+	for i := range points {
+		if points[i].Tags == nil {
+			points[i].Tags = make(map[string]string, len(tags))
+		}
+		for k, v := range tags {
+			points[i].Tags[k] = v
+		}
+	}
+}
+
+// sampleWrite returns true if the current write request is selected for tracing.
+func (h *Handler) sampleWrite() bool {
+	if !h.WriteTrace {
+		return false
+	} else if h.WriteTraceSampleN <= 1 {
+		return true
+	}
+	return atomic.AddUint64(&h.writeN, 1)%uint64(h.WriteTraceSampleN) == 0
+}
+
+// countingReader counts the bytes read from a reader.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+// truncatedBuffer keeps the first max bytes written to it and counts the rest.
+// Writes never fail so it can be used with an io.TeeReader.
+type truncatedBuffer struct {
+	buf bytes.Buffer
+	max int // unlimited if zero
+	n   int // total bytes written
+}
+
+// Write keeps as much of p as fits under the limit.
+func (b *truncatedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.n += n
+	if b.max > 0 {
+		if room := b.max - b.buf.Len(); room < len(p) {
+			p = p[:room]
+		}
+	}
+	_, _ = b.buf.Write(p)
+	return n, nil
+}
+
+// String returns the kept bytes, noting how many bytes were dropped.
+func (b *truncatedBuffer) String() string {
+	if b.n > b.buf.Len() {
+		return fmt.Sprintf("%s... (%d more bytes)", b.buf.String(), b.n-b.buf.Len())
+	}
+	return b.buf.String()
+}
+
+// writeTrace records how long each stage of a write request took.
+type writeTrace struct {
+	database        string
+	retentionPolicy string
+	points          int
+	parse           time.Duration // decoding the request body
+	normalize       time.Duration // validating points and applying defaults
+	write           time.Duration // writing points to the server
+	err             error
+}
+
+// String returns the trace as space-separated key=value pairs.
+func (t *writeTrace) String() string {
+	s := fmt.Sprintf("db=%q rp=%q points=%d parse=%s normalize=%s write=%s",
+		t.database, t.retentionPolicy, t.points, t.parse, t.normalize, t.write)
+	if t.err != nil {
+		s += fmt.Sprintf(" err=%q", t.err.Error())
+	}
+	return s
+}
+
+// serveMetastore returns a copy of the metastore.
+func (h *Handler) serveMetastore(w http.ResponseWriter, r *http.Request) {
+	// Set headers.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="meta"`)
+
+	if err := h.server.CopyMetastore(w); err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+	}
+}
+
+// serveStatus returns a set of states that the server is currently in.
+func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json")
+
+	pretty := r.URL.Query().Get("pretty") == "true"
+
+	data := struct {
+		Id    uint64 `json:"id"`
+		Index uint64 `json:"index"`
+	}{
+		Id:    h.server.ID(),
+		Index: h.server.Index(),
+	}
+	var b []byte
+	if pretty {
+		b, _ = json.MarshalIndent(data, "", "    ")
+	} else {
+		b, _ = json.Marshal(data)
+	}
+	w.Write(b)
+}
+
+// serveRecovery returns what the server recovered when it started. Requires
+// an admin user when authentication is enabled.
+func (h *Handler) serveRecovery(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	report := h.server.Recovery()
+	if h.TruncatedSegments != nil {
+		report.TruncatedSegments = h.TruncatedSegments()
+	}
+
+	w.Header().Add("content-type", "application/json")
+	var b []byte
+	if r.URL.Query().Get("pretty") == "true" {
+		b, _ = json.MarshalIndent(report, "", "    ")
+	} else {
+		b, _ = json.Marshal(report)
+	}
+	w.Write(b)
+}
+
+// serveOptions returns an empty response to comply with OPTIONS pre-flight requests
+func (h *Handler) serveOptions(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// servePing returns a simple response to let the client know the server is running.
+func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveReady lets the client know whether the server can serve traffic.
+// Unlike /ping, it fails while the server is still catching up with the broker.
+func (h *Handler) serveReady(w http.ResponseWriter, r *http.Request) {
+	if !h.server.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveIndex returns the current index of the node as the body of the response
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(fmt.Sprintf("%d", h.server.Index())))
+}
+
+// serveWait returns the current index of the node as the body of the response
+// Takes optional parameters:
+//     index - If specified, will block until the index is applied before returning
+//     timeout (optional) - time in milliseconds to wait until index is met before erring out
+//               default timeout if not specified really big (max int64)
+func (h *Handler) serveWait(w http.ResponseWriter, r *http.Request) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get(":index"), 10, 64)
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+
+	if index == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var d time.Duration
+	if timeout == 0 {
+		d = math.MaxInt64
+	} else {
+		d = time.Duration(timeout) * time.Millisecond
+	}
+	if err := h.server.WaitForIndex(index, d); err != nil {
+		w.WriteHeader(http.StatusRequestTimeout)
+		return
+	}
+	w.Write([]byte(fmt.Sprintf("%d", h.server.Index())))
+}
+
+// serveCreateDataNode creates a new data node in the cluster.
+func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request) {
+	// Read in data node from request body.
+	var n dataNodeJSON
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		httpError(w, err.Error(), false, http.StatusBadRequest)
+		return
+	}
+
+	// Parse the URL.
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		httpError(w, "invalid data node url", false, http.StatusBadRequest)
+		return
+	}
+
+	// Create the data node.
+	if err := h.server.CreateDataNode(u); err == influxdb.ErrDataNodeExists {
+		httpError(w, err.Error(), false, http.StatusConflict)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	// Retrieve data node reference.
+	node := h.server.DataNodeByURL(u)
+
+	// Create a new replica on the broker that streams the broadcast messages
+	// after this server's index. The new node copies this server's metastore
+	// when it joins so it already has the earlier changes.
+	if err := h.server.Client().CreateReplicaFrom(node.ID, node.URL, h.server.Index()); err != nil {
+		httpError(w, err.Error(), false, http.StatusBadGateway)
+		return
+	}
+
+	// Write new node back to client.
+	w.WriteHeader(http.StatusCreated)
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(&dataNodeJSON{ID: node.ID, URL: node.URL.String()})
+}
+
+// serveDeleteDataNode removes an existing node.
+func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request) {
+	// Parse node id.
+	nodeID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid node id", false, http.StatusBadRequest)
+		return
+	}
+
+	// Delete the node.
+	if err := h.server.DeleteDataNode(nodeID); err == influxdb.ErrDataNodeNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveProcessContinuousQueries will execute any continuous queries that should be run
+func (h *Handler) serveProcessContinuousQueries(w http.ResponseWriter, r *http.Request) {
+	if err := h.server.RunContinuousQueries(); err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveReadOnly returns whether the node is in read-only mode.
+func (h *Handler) serveReadOnly(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(&readOnlyJSON{ReadOnly: h.server.ReadOnly()})
+}
+
+// serveUpdateReadOnly turns read-only mode on or off. Requires an admin user
+// when authentication is enabled.
+func (h *Handler) serveUpdateReadOnly(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if err != nil {
+		httpError(w, "invalid enabled value", false, http.StatusBadRequest)
+		return
+	}
+	h.server.SetReadOnly(enabled)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type readOnlyJSON struct {
+	ReadOnly bool `json:"readOnly"`
+}
+
+// serveCompaction returns the current shard compaction settings.
+func (h *Handler) serveCompaction(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(&compactionJSON{
+		Concurrency: h.server.Compactor.Concurrency(),
+		Throughput:  h.server.Compactor.Throughput(),
+	})
+}
+
+// serveUpdateCompaction changes the number of concurrent compactions or their
+// throughput in bytes per second. Requires an admin user when authentication
+// is enabled.
+func (h *Handler) serveUpdateCompaction(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	if s := q.Get("concurrency"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			httpError(w, "invalid concurrency value", false, http.StatusBadRequest)
+			return
+		}
+		h.server.Compactor.SetConcurrency(n)
+	}
+	if s := q.Get("throughput"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			httpError(w, "invalid throughput value", false, http.StatusBadRequest)
+			return
+		}
+		h.server.Compactor.SetThroughput(n)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type compactionJSON struct {
+	Concurrency int   `json:"concurrency"`
+	Throughput  int64 `json:"throughput"`
+}
+
+// ioClasses maps the parameters of the IO budget endpoints to IO classes.
+var ioClasses = map[string]string{
+	"total":      "",
+	"compaction": influxdb.IOClassCompaction,
+	"retention":  influxdb.IOClassRetention,
+	"backup":     influxdb.IOClassBackup,
+}
+
+// serveIO returns the budgets of background IO in bytes per second.
+func (h *Handler) serveIO(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	m := make(map[string]int64, len(ioClasses))
+	for name, class := range ioClasses {
+		m[name] = h.server.IO.Throughput(class)
+	}
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(m)
+}
+
+// serveUpdateIO changes the budgets of background IO in bytes per second.
+// Each parameter names a class of IO, or "total" for all IO together.
+// Requires an admin user when authentication is enabled.
+func (h *Handler) serveUpdateIO(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	// Validate every budget before changing any.
+	budgets := make(map[string]int64)
+	for name, values := range r.URL.Query() {
+		class, ok := ioClasses[name]
+		if !ok {
+			httpError(w, fmt.Sprintf("unknown io class: %s", name), false, http.StatusBadRequest)
+			return
+		}
+		n, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil || n < 0 {
+			httpError(w, fmt.Sprintf("invalid %s throughput value", name), false, http.StatusBadRequest)
+			return
+		}
+		budgets[class] = n
+	}
+	for class, n := range budgets {
+		h.server.IO.SetThroughput(class, n)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveShards returns statistics for the shards stored on this node. Results
+// are limited to a single database if the "db" parameter is set. Requires an
+// admin user when authentication is enabled.
+func (h *Handler) serveShards(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	a, err := h.server.ShardStats(r.URL.Query().Get("db"))
+	if err != nil {
+		httpError(w, err.Error(), false, errorStatusCode(err))
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// serveExportShard writes an archive of a shard stored on this node. Requires
+// an admin user when authentication is enabled.
+func (h *Handler) serveExportShard(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid shard id", false, http.StatusBadRequest)
+		return
+	}
+
+	// Errors can only be reported before the archive starts being written.
+	var started bool
+	ww := writerFunc(func(p []byte) (int, error) {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=shard-%d.archive.gz", id))
+		}
+		return w.Write(p)
+	})
+	if err := h.server.ExportShard(id, ww); err != nil {
+		if started {
+			h.Logger.Printf("export shard %d: %s", id, err)
+			return
+		}
+		httpError(w, err.Error(), false, errorStatusCode(err))
+	}
+}
+
+// serveImportShard writes the points of a shard archive in the request body
+// to the database and retention policy in the "db" and "rp" parameters, or
+// those the shard was exported from if not set. Requires an admin user when
+// authentication is enabled.
+func (h *Handler) serveImportShard(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	n, err := h.server.ImportShard(r.Body, q.Get("db"), q.Get("rp"))
+	if err != nil && isShardArchiveError(err) {
+		httpError(w, err.Error(), false, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, errorStatusCode(err))
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Points int `json:"points"`
+	}{n})
+}
+
+// writerFunc adapts a function to an io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (fn writerFunc) Write(p []byte) (int, error) { return fn(p) }
+
+// serveTagRewrites returns the tag rewrite jobs started on this node. Requires
+// an admin user when authentication is enabled.
+func (h *Handler) serveTagRewrites(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.server.TagRewrites())
+}
+
+// serveTagRewrite returns the progress of a single tag rewrite job. Requires
+// an admin user when authentication is enabled.
+func (h *Handler) serveTagRewrite(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid tag rewrite id", false, http.StatusBadRequest)
+		return
+	}
+	j := h.server.TagRewrite(id)
+	if j == nil {
+		httpError(w, "tag rewrite not found", false, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+// serveCreateTagRewrite starts a job that changes a tag value on the series of
+// a measurement. The job's progress is available from /tag_rewrites/:id.
+// Requires an admin user when authentication is enabled.
+func (h *Handler) serveCreateTagRewrite(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	j, err := h.server.RewriteTagValue(q.Get("db"), q.Get("measurement"), q.Get("key"), q.Get("old"), q.Get("new"))
+	if err == influxdb.ErrMeasurementNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, errorStatusCode(err))
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	w.Header().Add("Location", fmt.Sprintf("/tag_rewrites/%d", j.ID))
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+// serveFieldConversions returns the field conversion jobs started on this node
+// by ALTER FIELD. Requires an admin user when authentication is enabled.
+func (h *Handler) serveFieldConversions(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.server.FieldConversions())
+}
+
+// serveFieldConversion returns the progress of a single field conversion job.
+// Requires an admin user when authentication is enabled.
+func (h *Handler) serveFieldConversion(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid field conversion id", false, http.StatusBadRequest)
+		return
+	}
+	j := h.server.FieldConversion(id)
+	if j == nil {
+		httpError(w, "field conversion not found", false, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(j)
+}
+
+// serveSchema returns the measurements of a database with their tag keys,
+// field types and series counts.
+func (h *Handler) serveSchema(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	db := r.URL.Query().Get("db")
+	if db == "" {
+		httpError(w, "database is required", false, http.StatusBadRequest)
+		return
+	} else if h.requireAuthentication && user == nil {
+		httpError(w, fmt.Sprintf("user is required to read from database %q", db), false, http.StatusUnauthorized)
+		return
+	} else if h.requireAuthentication && !user.Authorize(influxql.ReadPrivilege, db) {
+		httpError(w, fmt.Sprintf("%q user is not authorized to read from database %q", user.Name, db), false, http.StatusUnauthorized)
+		return
+	}
+
+	a, err := h.server.Schema(db)
+	if err != nil {
+		httpError(w, err.Error(), false, errorStatusCode(err))
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(&schemaJSON{Database: db, Measurements: a})
+}
+
+type schemaJSON struct {
+	Database     string                        `json:"database"`
+	Measurements []*influxdb.MeasurementSchema `json:"measurements"`
+}
 
-	var writeError = func(result influxdb.Result, statusCode int) {
-		w.WriteHeader(statusCode)
-		w.Header().Add("content-type", "application/json")
-		_ = json.NewEncoder(w).Encode(&result)
+// serveMetadata returns the databases, retention policies and users as they
+// were after the last metadata change at or before an index or time.
+func (h *Handler) serveMetadata(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
 		return
 	}
 
-	if err := dec.Decode(&bp); err != nil {
-		if err.Error() == "EOF" {
-			w.WriteHeader(http.StatusOK)
+	q := r.URL.Query()
+	var ss *influxdb.MetadataSnapshot
+	var err error
+	switch {
+	case q.Get("index") != "" && q.Get("time") != "":
+		httpError(w, "index and time cannot both be specified", false, http.StatusBadRequest)
+		return
+	case q.Get("time") != "":
+		t, e := time.Parse(time.RFC3339Nano, q.Get("time"))
+		if e != nil {
+			httpError(w, "invalid time: "+e.Error(), false, http.StatusBadRequest)
 			return
 		}
-		writeError(influxdb.Result{Err: err}, http.StatusInternalServerError)
-		return
+		ss, err = h.server.MetadataAtTime(t)
+	default:
+		index := uint64(math.MaxUint64)
+		if s := q.Get("index"); s != "" {
+			n, e := strconv.ParseUint(s, 10, 64)
+			if e != nil {
+				httpError(w, "invalid index: "+e.Error(), false, http.StatusBadRequest)
+				return
+			}
+			index = n
+		}
+		ss, err = h.server.MetadataAt(index)
 	}
-
-	if bp.Database == "" {
-		writeError(influxdb.Result{Err: fmt.Errorf("database is required")}, http.StatusInternalServerError)
+	if err != nil {
+		httpError(w, err.Error(), false, errorStatusCode(err))
 		return
 	}
 
-	if !h.server.DatabaseExists(bp.Database) {
-		writeError(influxdb.Result{Err: fmt.Errorf("database not found: %q", bp.Database)}, http.StatusNotFound)
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(ss)
+}
+
+// serveMetadataHistory returns the list of metadata changes applied by the server.
+func (h *Handler) serveMetadataHistory(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
 		return
 	}
 
-	if h.requireAuthentication && user == nil {
-		writeError(influxdb.Result{Err: fmt.Errorf("user is required to write to database %q", bp.Database)}, http.StatusUnauthorized)
+	a, err := h.server.MetadataHistory()
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
 		return
 	}
+	if a == nil {
+		a = []*influxdb.MetadataChange{}
+	}
 
-	if h.requireAuthentication && !user.Authorize(influxql.WritePrivilege, bp.Database) {
-		writeError(influxdb.Result{Err: fmt.Errorf("%q user is not authorized to write to database %q", user.Name, bp.Database)}, http.StatusUnauthorized)
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
+// serveSubscribe streams points matching a query to a websocket client as
+// they are written. The query's source and WHERE clause select the points.
+func (h *Handler) serveSubscribe(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	q := r.URL.Query()
+	f, err := parsePointFilter(q.Get("q"), q.Get("db"))
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusBadRequest)
+		return
+	} else if h.requireAuthentication && user == nil {
+		httpError(w, fmt.Sprintf("user is required to read from database %q", f.Database), false, http.StatusUnauthorized)
+		return
+	} else if h.requireAuthentication && !user.Authorize(influxql.ReadPrivilege, f.Database) {
+		httpError(w, fmt.Sprintf("%q user is not authorized to read from database %q", user.Name, f.Database), false, http.StatusUnauthorized)
 		return
 	}
 
-	points, err := influxdb.NormalizeBatchPoints(bp)
+	sub, err := h.server.Subscribe(*f, 0)
 	if err != nil {
-		writeError(influxdb.Result{Err: err}, http.StatusInternalServerError)
+		httpError(w, err.Error(), false, errorStatusCode(err))
 		return
 	}
+	defer sub.Close()
 
-	if index, err := h.server.WriteSeries(bp.Database, bp.RetentionPolicy, points); err != nil {
-		writeError(influxdb.Result{Err: err}, http.StatusInternalServerError)
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusBadRequest)
 		return
-	} else {
-		w.Header().Add("X-InfluxDB-Index", fmt.Sprintf("%d", index))
 	}
-}
-
-// serveMetastore returns a copy of the metastore.
-func (h *Handler) serveMetastore(w http.ResponseWriter, r *http.Request) {
-	// Set headers.
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", `attachment; filename="meta"`)
+	defer ws.Close()
 
-	if err := h.server.CopyMetastore(w); err != nil {
-		httpError(w, err.Error(), false, http.StatusInternalServerError)
+	for {
+		select {
+		case p, ok := <-sub.C():
+			if !ok {
+				// The server dropped the subscription; tell the client why.
+				if err := sub.Err(); err != nil {
+					b, _ := json.Marshal(&influxdb.Result{Err: err})
+					_ = ws.WriteText(b)
+				}
+				return
+			}
+			b, err := json.Marshal(newPointJSON(&p))
+			if err != nil {
+				return
+			}
+			if err := ws.WriteText(b); err != nil {
+				return
+			}
+		case <-ws.Closing():
+			return
+		}
 	}
 }
 
-// serveStatus returns a set of states that the server is currently in.
-func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("content-type", "application/json")
-
-	pretty := r.URL.Query().Get("pretty") == "true"
+// parsePointFilter converts a SELECT statement into a filter on written points.
+// The source may be qualified with a database and retention policy, otherwise
+// db is used. Conditions on time are not supported.
+func parsePointFilter(query, db string) (*influxdb.PointFilter, error) {
+	if query == "" {
+		return nil, errors.New("query is required")
+	}
+	stmt, err := influxql.NewParser(strings.NewReader(query)).ParseStatement()
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(*influxql.SelectStatement)
+	if !ok {
+		return nil, errors.New("subscription query must be a SELECT statement")
+	}
+	m, ok := sel.Source.(*influxql.Measurement)
+	if !ok {
+		return nil, errors.New("subscription query must select from a single measurement")
+	}
 
-	data := struct {
-		Id    uint64 `json:"id"`
-		Index uint64 `json:"index"`
-	}{
-		Id:    h.server.ID(),
-		Index: h.server.Index(),
+	f := &influxdb.PointFilter{Database: db, Condition: sel.Condition}
+	segments, err := influxql.SplitIdent(m.Name)
+	if err != nil {
+		return nil, err
 	}
-	var b []byte
-	if pretty {
-		b, _ = json.MarshalIndent(data, "", "    ")
-	} else {
-		b, _ = json.Marshal(data)
+	switch len(segments) {
+	case 1:
+		f.Measurement = segments[0]
+	case 2:
+		f.RetentionPolicy, f.Measurement = segments[0], segments[1]
+	case 3:
+		f.Database, f.RetentionPolicy, f.Measurement = segments[0], segments[1], segments[2]
+	default:
+		return nil, fmt.Errorf("invalid measurement: %s", m.Name)
+	}
+	if f.Database == "" {
+		return nil, errors.New("database is required")
 	}
-	w.Write(b)
-}
 
-// serveOptions returns an empty response to comply with OPTIONS pre-flight requests
-func (h *Handler) serveOptions(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNoContent)
+	// Points are matched as they arrive so time ranges don't apply.
+	if f.Condition != nil {
+		var hasTime bool
+		influxql.WalkFunc(f.Condition, func(n influxql.Node) {
+			if ref, ok := n.(*influxql.VarRef); ok && strings.ToLower(ref.Val) == "time" {
+				hasTime = true
+			}
+		})
+		if hasTime {
+			return nil, errors.New("subscription conditions cannot reference time")
+		}
+	}
+	return f, nil
 }
 
-// servePing returns a simple response to let the client know the server is running.
-func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNoContent)
-}
+// maxEventBufferN is the largest number of points a client of the events
+// feed can ask to have buffered for it.
+const maxEventBufferN = 10000
 
-// serveIndex returns the current index of the node as the body of the response
-func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte(fmt.Sprintf("%d", h.server.Index())))
-}
+// serveEvents streams points written to a database as server-sent events.
+// Points can be filtered by retention policy, measurement and tag values.
+// Clients that fall more than their buffer behind are disconnected.
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	q := r.URL.Query()
+	f := influxdb.PointFilter{Database: q.Get("db"), RetentionPolicy: q.Get("rp"), Measurement: q.Get("measurement")}
+	if f.Database == "" {
+		httpError(w, "database is required", false, http.StatusBadRequest)
+		return
+	} else if h.requireAuthentication && user == nil {
+		httpError(w, fmt.Sprintf("user is required to read from database %q", f.Database), false, http.StatusUnauthorized)
+		return
+	} else if h.requireAuthentication && !user.Authorize(influxql.ReadPrivilege, f.Database) {
+		httpError(w, fmt.Sprintf("%q user is not authorized to read from database %q", user.Name, f.Database), false, http.StatusUnauthorized)
+		return
+	}
 
-// serveWait returns the current index of the node as the body of the response
-// Takes optional parameters:
-//     index - If specified, will poll for index before returning
-//     timeout (optional) - time in milliseconds to wait until index is met before erring out
-//               default timeout if not specified really big (max int64)
-func (h *Handler) serveWait(w http.ResponseWriter, r *http.Request) {
-	index, _ := strconv.ParseUint(r.URL.Query().Get(":index"), 10, 64)
-	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+	// Tag filters are passed as "key:value".
+	for _, tag := range q["tag"] {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			httpError(w, fmt.Sprintf("invalid tag filter: %q", tag), false, http.StatusBadRequest)
+			return
+		}
+		if f.Tags == nil {
+			f.Tags = make(map[string]string)
+		}
+		f.Tags[kv[0]] = kv[1]
+	}
 
-	if index == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	bufferN := influxdb.DefaultSubscriptionBufferN
+	if s := q.Get("buffer"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxEventBufferN {
+			httpError(w, fmt.Sprintf("buffer must be between 1 and %d", maxEventBufferN), false, http.StatusBadRequest)
+			return
+		}
+		bufferN = n
 	}
 
-	var d time.Duration
-	if timeout == 0 {
-		d = math.MaxInt64
-	} else {
-		d = time.Duration(timeout) * time.Millisecond
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, "streaming not supported", false, http.StatusInternalServerError)
+		return
 	}
-	err := h.pollForIndex(index, d)
+
+	sub, err := h.server.Subscribe(f, bufferN)
 	if err != nil {
-		w.WriteHeader(http.StatusRequestTimeout)
+		httpError(w, err.Error(), false, errorStatusCode(err))
 		return
 	}
-	w.Write([]byte(fmt.Sprintf("%d", h.server.Index())))
-}
+	defer sub.Close()
 
-// pollForIndex will poll until either the index is met or it times out
-// timeout is in milliseconds
-func (h *Handler) pollForIndex(index uint64, timeout time.Duration) error {
-	done := make(chan struct{})
+	var closing <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closing = cn.CloseNotify()
+	}
 
-	go func() {
-		for {
-			if h.server.Index() >= index {
-				done <- struct{}{}
-			}
-			time.Sleep(10 * time.Millisecond)
-		}
-	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
 
 	for {
 		select {
-		case <-done:
-			return nil
-		case <-time.After(timeout):
-			return fmt.Errorf("timed out")
+		case p, ok := <-sub.C():
+			if !ok {
+				// The server dropped the subscription; tell the client why.
+				if err := sub.Err(); err != nil {
+					b, _ := json.Marshal(&influxdb.Result{Err: err})
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", b)
+					flusher.Flush()
+				}
+				return
+			}
+			b, err := json.Marshal(newPointJSON(&p))
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-closing:
+			return
 		}
 	}
 }
 
-// serveDataNodes returns a list of all data nodes in the cluster.
-func (h *Handler) serveDataNodes(w http.ResponseWriter, r *http.Request) {
-	// Generate a list of objects for encoding to the API.
-	a := make([]*dataNodeJSON, 0)
-	for _, n := range h.server.DataNodes() {
-		a = append(a, &dataNodeJSON{
-			ID:  n.ID,
-			URL: n.URL.String(),
-		})
-	}
+// pointJSON is the representation of a point sent to subscribers.
+type pointJSON struct {
+	Name      string                 `json:"name"`
+	Tags      map[string]string      `json:"tags,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Fields    map[string]interface{} `json:"fields"`
+}
 
-	w.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(a)
+func newPointJSON(p *influxdb.Point) *pointJSON {
+	return &pointJSON{Name: p.Name, Tags: p.Tags, Timestamp: p.Timestamp.UTC(), Fields: p.Fields}
 }
 
-// serveCreateDataNode creates a new data node in the cluster.
-func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request) {
-	// Read in data node from request body.
-	var n dataNodeJSON
-	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
-		httpError(w, err.Error(), false, http.StatusBadRequest)
+// servePprof serves runtime profiling data in the format expected by the pprof tool.
+func (h *Handler) servePprof(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.PprofEnabled {
+		http.NotFound(w, r)
+		return
+	} else if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
 		return
 	}
 
-	// Parse the URL.
-	u, err := url.Parse(n.URL)
-	if err != nil {
-		httpError(w, "invalid data node url", false, http.StatusBadRequest)
-		return
+	switch strings.TrimPrefix(r.URL.Path, "/debug/pprof/") {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	default:
+		pprof.Index(w, r)
 	}
+}
 
-	// Create the data node.
-	if err := h.server.CreateDataNode(u); err == influxdb.ErrDataNodeExists {
-		httpError(w, err.Error(), false, http.StatusConflict)
+// serveDump writes a goroutine dump and a heap dump to the temporary
+// directory and returns the paths of the files.
+func (h *Handler) serveDump(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if !h.PprofEnabled {
+		http.NotFound(w, r)
 		return
-	} else if err != nil {
-		httpError(w, err.Error(), false, http.StatusInternalServerError)
+	} else if !h.isAdmin(user) {
+		httpError(w, "admin privileges required", false, http.StatusForbidden)
 		return
 	}
 
-	// Retrieve data node reference.
-	node := h.server.DataNodeByURL(u)
+	prefix := filepath.Join(os.TempDir(), fmt.Sprintf("influxd-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	d := dumpJSON{Goroutine: prefix + ".goroutine", Heap: prefix + ".heap"}
 
-	// Create a new replica on the broker.
-	if err := h.server.Client().CreateReplica(node.ID, node.URL); err != nil {
-		httpError(w, err.Error(), false, http.StatusBadGateway)
+	if err := writeDump(d.Goroutine, func(f *os.File) error {
+		return rpprof.Lookup("goroutine").WriteTo(f, 2)
+	}); err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
 		return
 	}
 
-	// Write new node back to client.
-	w.WriteHeader(http.StatusCreated)
+	if err := writeDump(d.Heap, func(f *os.File) error {
+		runtime.GC()
+		debug.WriteHeapDump(f.Fd())
+		return nil
+	}); err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	h.Logger.Printf("wrote goroutine dump to %s and heap dump to %s", d.Goroutine, d.Heap)
 	w.Header().Add("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(&dataNodeJSON{ID: node.ID, URL: node.URL.String()})
+	_ = json.NewEncoder(w).Encode(&d)
 }
 
-// serveDeleteDataNode removes an existing node.
-func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request) {
-	// Parse node id.
-	nodeID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+// writeDump creates the file at path and passes it to fn.
+func writeDump(path string, fn func(*os.File) error) error {
+	f, err := os.Create(path)
 	if err != nil {
-		httpError(w, "invalid node id", false, http.StatusBadRequest)
-		return
-	}
-
-	// Delete the node.
-	if err := h.server.DeleteDataNode(nodeID); err == influxdb.ErrDataNodeNotFound {
-		httpError(w, err.Error(), false, http.StatusNotFound)
-		return
-	} else if err != nil {
-		httpError(w, err.Error(), false, http.StatusInternalServerError)
-		return
+		return err
 	}
+	defer f.Close()
+	return fn(f)
+}
 
-	w.WriteHeader(http.StatusNoContent)
+type dumpJSON struct {
+	Goroutine string `json:"goroutine"`
+	Heap      string `json:"heap"`
 }
 
-// serveProcessContinuousQueries will execute any continuous queries that should be run
-func (h *Handler) serveProcessContinuousQueries(w http.ResponseWriter, r *http.Request) {
-	if err := h.server.RunContinuousQueries(); err != nil {
-		httpError(w, err.Error(), false, http.StatusInternalServerError)
-		return
+// closeNotify returns a channel that is closed if the client disconnects and
+// a function that must be called once the request is done. The channel is nil
+// if the response writer can't report disconnects.
+func closeNotify(w http.ResponseWriter) (<-chan struct{}, func()) {
+	cn, ok := w.(http.CloseNotifier)
+	if !ok {
+		return nil, func() {}
 	}
+	notify := cn.CloseNotify()
 
-	w.WriteHeader(http.StatusAccepted)
+	closing, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		select {
+		case <-notify:
+			close(closing)
+		case <-done:
+		}
+	}()
+	return closing, func() { close(done) }
+}
+
+// isAdmin returns true if user may access administrative endpoints.
+// Everyone is an admin when authentication is disabled.
+func (h *Handler) isAdmin(user *influxdb.User) bool {
+	return !h.requireAuthentication || (user != nil && user.Admin)
 }
 
 type dataNodeJSON struct {
@@ -444,21 +1891,98 @@ func isFieldNotFoundError(err error) bool {
 	return (strings.HasPrefix(err.Error(), "field not found"))
 }
 
+func isShardArchiveError(err error) bool {
+	return strings.HasPrefix(err.Error(), "invalid shard archive") || strings.HasPrefix(err.Error(), "unsupported shard archive")
+}
+
+// statusTooManyRequests is returned when a rate quota is exceeded. The net/http
+// package doesn't define it on the supported Go version.
+const statusTooManyRequests = 429
+
+// statusCodes maps well-known statement errors to the HTTP status code
+// returned to the client. Errors caused by the request are reported as 4xx
+// so that clients do not retry them; anything unlisted is a 5xx.
+var statusCodes = map[error]int{
+	influxdb.ErrDatabaseNameRequired:           http.StatusBadRequest,
+	influxdb.ErrDatabaseRequired:               http.StatusBadRequest,
+	influxdb.ErrUsernameRequired:               http.StatusBadRequest,
+	influxdb.ErrInvalidUsername:                http.StatusBadRequest,
+	influxdb.ErrTokenNameRequired:              http.StatusBadRequest,
+	influxdb.ErrTokenPrivilegesRequired:        http.StatusBadRequest,
+	influxdb.ErrRetentionPolicyNameRequired:    http.StatusBadRequest,
+	influxdb.ErrInvalidDuplicatePolicy:         http.StatusBadRequest,
+	influxdb.ErrDuplicatePointRejected:         http.StatusConflict,
+	influxdb.ErrReplicationFactorTooHigh:       http.StatusBadRequest,
+	influxdb.ErrInvalidQuery:                   http.StatusBadRequest,
+	influxdb.ErrMeasurementNameRequired:        http.StatusBadRequest,
+	influxdb.ErrFieldsRequired:                 http.StatusBadRequest,
+	influxdb.ErrNonFiniteFieldValue:            http.StatusBadRequest,
+	influxdb.ErrFieldOverflow:                  http.StatusBadRequest,
+	influxdb.ErrInvalidFieldType:               http.StatusBadRequest,
+	influxdb.ErrFieldConversionInProgress:      http.StatusConflict,
+	influxdb.ErrInvalidMeasurementTTL:          http.StatusBadRequest,
+	influxdb.ErrInvalidTagRewrite:              http.StatusBadRequest,
+	influxdb.ErrInvalidGrantRevoke:             http.StatusBadRequest,
+	influxdb.ErrReadWritePermissionsRequired:   http.StatusBadRequest,
+	influxql.ErrInvalidDuration:                http.StatusBadRequest,
+	influxql.ErrQueryMemoryLimitExceeded:       http.StatusBadRequest,
+	influxdb.ErrTooManyGroups:                  http.StatusBadRequest,
+	influxdb.ErrShardGroupNotDroppable:         http.StatusBadRequest,
+	influxdb.ErrAnnotationTextRequired:         http.StatusBadRequest,
+	influxdb.ErrAnnotationEndBeforeStart:       http.StatusBadRequest,
+	influxdb.ErrReadAccessDenied:               http.StatusForbidden,
+	influxdb.ErrReadOnly:                       http.StatusForbidden,
+	influxdb.ErrSeriesQuotaExceeded:            http.StatusForbidden,
+	influxdb.ErrDiskQuotaExceeded:              http.StatusForbidden,
+	influxdb.ErrDatabaseNotFound:               http.StatusNotFound,
+	influxdb.ErrRetentionPolicyNotFound:        http.StatusNotFound,
+	influxdb.ErrMetadataSnapshotNotFound:       http.StatusNotFound,
+	influxdb.ErrDefaultRetentionPolicyNotFound: http.StatusNotFound,
+	influxdb.ErrUserNotFound:                   http.StatusNotFound,
+	influxdb.ErrTokenNotFound:                  http.StatusNotFound,
+	influxdb.ErrClusterAdminNotFound:           http.StatusNotFound,
+	influxdb.ErrDataNodeNotFound:               http.StatusNotFound,
+	influxdb.ErrShardNotFound:                  http.StatusNotFound,
+	influxdb.ErrShardNotLocal:                  http.StatusNotFound,
+	influxdb.ErrSeriesNotFound:                 http.StatusNotFound,
+	influxdb.ErrStoredQueryNotFound:            http.StatusNotFound,
+	influxdb.ErrDatabaseExists:                 http.StatusConflict,
+	influxdb.ErrRetentionPolicyExists:          http.StatusConflict,
+	influxdb.ErrUserExists:                     http.StatusConflict,
+	influxdb.ErrTokenExists:                    http.StatusConflict,
+	influxdb.ErrClusterAdminExists:             http.StatusConflict,
+	influxdb.ErrDataNodeExists:                 http.StatusConflict,
+	influxdb.ErrSeriesExists:                   http.StatusConflict,
+	influxdb.ErrContinuousQueryExists:          http.StatusConflict,
+	influxdb.ErrStoredQueryExists:              http.StatusConflict,
+	influxdb.ErrFieldTypeConflict:              http.StatusConflict,
+	influxdb.ErrWriteRateQuotaExceeded:         statusTooManyRequests,
+	influxdb.ErrQueryQuotaExceeded:             statusTooManyRequests,
+	influxql.ErrQueryMemoryPoolExhausted:       http.StatusServiceUnavailable,
+	influxdb.ErrQueryTimeout:                   http.StatusServiceUnavailable,
+}
+
+// errorStatusCode returns the HTTP status code for a statement error.
+func errorStatusCode(err error) int {
+	if isAuthorizationError(err) {
+		return http.StatusUnauthorized
+	} else if isMeasurementNotFoundError(err) || isFieldNotFoundError(err) {
+		// Querying for data that doesn't exist is not an error.
+		return http.StatusOK
+	} else if code, ok := statusCodes[err]; ok {
+		return code
+	} else if _, ok := err.(*influxdb.PointError); ok {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 // httpResult writes a Results array to the client.
 func httpResults(w http.ResponseWriter, results influxdb.Results, pretty bool) {
-	if results.Error() != nil {
-		if isAuthorizationError(results.Error()) {
-			w.WriteHeader(http.StatusUnauthorized)
-		} else if isMeasurementNotFoundError(results.Error()) {
-			w.WriteHeader(http.StatusOK)
-		} else if isFieldNotFoundError(results.Error()) {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			fmt.Println(results.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-	}
 	w.Header().Add("content-type", "application/json")
+	if err := results.Error(); err != nil {
+		w.WriteHeader(errorStatusCode(err))
+	}
 	var b []byte
 	if pretty {
 		b, _ = json.MarshalIndent(results, "", "    ")
@@ -503,22 +2027,71 @@ func parseCredentials(r *http.Request) (string, string, error) {
 	}
 }
 
+// parseToken returns the secret of an API token passed in the Authorization
+// header as "Token <secret>".
+func parseToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Token ") || !strings.EqualFold(auth[:len("Token ")], "Token ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len("Token "):]), true
+}
+
+// ImpersonateHeader is the request header an admin user sets to the name of
+// another user to have the request authorized as that user.
+const ImpersonateHeader = "X-Influxdb-Impersonate"
+
 // authenticate wraps a handler and ensures that if user credentials are passed in
 // an attempt is made to authenticate that user. If authentication fails, an error is returned.
 //
 // There is one exception: if there are no users in the system, authentication is not required. This
 // is to facilitate bootstrapping of a system with authentication enabled.
-func authenticate(inner func(http.ResponseWriter, *http.Request, *influxdb.User), h *Handler, requireAuthentication bool) http.Handler {
+//
+// API tokens may be passed in the Authorization header as "Token <secret>"
+// instead of user credentials, and the session cookie set by /login is
+// accepted in their place.
+//
+// Admin users may set ImpersonateHeader to have the request handled exactly as
+// it would be for another user. Each impersonated request is logged.
+//
+// Routes whose pattern is in the handler's AuthExemptions, and CORS preflight
+// requests, are served without a user.
+func authenticate(inner func(http.ResponseWriter, *http.Request, *influxdb.User), h *Handler, requireAuthentication bool, pattern string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requireAuthentication && (r.Method == "OPTIONS" || h.AuthExemptions[pattern]) {
+			inner(w, r, nil)
+			return
+		}
+
 		// Return early if we are not authenticating
 		if !requireAuthentication {
+			if r.Header.Get(ImpersonateHeader) != "" {
+				httpError(w, "impersonation requires authentication", false, http.StatusBadRequest)
+				return
+			}
 			inner(w, r, nil)
 			return
 		}
 		var user *influxdb.User
 
-		// TODO corylanou: never allow this in the future without users
-		if requireAuthentication && h.server.UserCount() > 0 {
+		// API tokens are accepted whether or not any users exist.
+		if secret, ok := parseToken(r); ok {
+			var err error
+			user, err = h.server.AuthenticateToken(secret)
+			if err != nil {
+				httpError(w, err.Error(), false, http.StatusUnauthorized)
+				return
+			}
+		} else if c, err := r.Cookie(SessionCookie); err == nil && !hasCredentials(r) {
+			// Sessions started through /login are used unless the request
+			// passes credentials of its own.
+			user, err = h.sessionUser(c.Value)
+			if err != nil {
+				httpError(w, err.Error(), false, http.StatusUnauthorized)
+				return
+			}
+		} else if requireAuthentication && h.server.UserCount() > 0 {
+			// TODO corylanou: never allow this in the future without users
 			username, password, err := parseCredentials(r)
 			if err != nil {
 				httpError(w, err.Error(), false, http.StatusUnauthorized)
@@ -535,6 +2108,22 @@ func authenticate(inner func(http.ResponseWriter, *http.Request, *influxdb.User)
 				return
 			}
 		}
+
+		// Switch to the impersonated user if an admin requested it.
+		if name := r.Header.Get(ImpersonateHeader); name != "" {
+			if user == nil || !user.Admin {
+				httpError(w, "only admin users can impersonate", false, http.StatusUnauthorized)
+				return
+			}
+			other := h.server.User(name)
+			if other == nil {
+				httpError(w, fmt.Sprintf("impersonated user not found: %q", name), false, http.StatusBadRequest)
+				return
+			}
+			h.Logger.Printf("impersonation: user %q as %q from %s: %s %s", user.Name, other.Name, r.RemoteAddr, r.Method, r.URL.Path)
+			user = other
+		}
+
 		inner(w, r, user)
 	})
 }
@@ -594,10 +2183,20 @@ func cors(inner http.Handler) http.Handler {
 				`Content-Type`,
 				`X-CSRF-Token`,
 				`X-HTTP-Method-Override`,
+				ImpersonateHeader,
+				TagsHeader,
+			}, ", "))
+
+			w.Header().Set(`Access-Control-Expose-Headers`, strings.Join([]string{
+				`Date`,
+				`Request-Id`,
+				`X-InfluxDB-Version`,
 			}, ", "))
 		}
 
+		// Preflight requests never reach the inner handler.
 		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
@@ -1,15 +1,19 @@
 package httpd_test
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -199,6 +203,42 @@ func TestHandler_CreateDatabase(t *testing.T) {
 	}
 }
 
+func TestHandler_CreateDatabase_PostJSON(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/query`, nil, nil, `{"q": "CREATE DATABASE foo"}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"results":[{}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	} else if !srvr.DatabaseExists("foo") {
+		t.Fatal("database not created")
+	}
+}
+
+func TestHandler_CreateDatabase_PostForm(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	resp, err := http.PostForm(s.URL+`/query`, url.Values{"q": {"CREATE DATABASE foo"}})
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if body := strings.TrimRight(string(b), "\n"); body != `{"results":[{}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	} else if !srvr.DatabaseExists("foo") {
+		t.Fatal("database not created")
+	}
+}
+
 func TestHandler_CreateDatabase_BadRequest_NoName(t *testing.T) {
 	srvr := OpenAuthlessServer(NewMessagingClient())
 	s := NewHTTPServer(srvr)
@@ -217,7 +257,7 @@ func TestHandler_CreateDatabase_Conflict(t *testing.T) {
 	defer s.Close()
 
 	status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "CREATE DATABASE foo"}, nil, "")
-	if status != http.StatusInternalServerError {
+	if status != http.StatusConflict {
 		t.Fatalf("unexpected status: %d", status)
 	} else if body != `{"results":[{"error":"database exists"}]}` {
 		t.Fatalf("unexpected body: %s", body)
@@ -244,7 +284,7 @@ func TestHandler_DropDatabase_NotFound(t *testing.T) {
 	defer s.Close()
 
 	status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "DROP DATABASE bar"}, nil, "")
-	if status != http.StatusInternalServerError {
+	if status != http.StatusNotFound {
 		t.Fatalf("unexpected status: %d", status)
 	} else if body != `{"results":[{"error":"database not found"}]}` {
 		t.Fatalf("unexpected body: %s", body)
@@ -255,6 +295,8 @@ func TestHandler_RetentionPolicies(t *testing.T) {
 	srvr := OpenAuthlessServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
 	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "baz", Duration: time.Hour, ReplicaN: 1})
+	srvr.SetDefaultRetentionPolicy("foo", "baz")
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
@@ -262,7 +304,7 @@ func TestHandler_RetentionPolicies(t *testing.T) {
 
 	if status != http.StatusOK {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != `{"results":[{"series":[{"columns":["name","duration","replicaN"],"values":[["bar","168h0m0s",1]]}]}]}` {
+	} else if body != `{"results":[{"series":[{"columns":["name","duration","replicaN","default","shardGroupDuration"],"values":[["bar","168h0m0s",1,false,"168h0m0s"],["baz","1h0m0s",1,true,"1h0m0s"]]}]}]}` {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
@@ -274,7 +316,7 @@ func TestHandler_RetentionPolicies_DatabaseNotFound(t *testing.T) {
 
 	status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "SHOW RETENTION POLICIES foo"}, nil, "")
 
-	if status != http.StatusInternalServerError {
+	if status != http.StatusNotFound {
 		t.Fatalf("unexpected status: %d", status)
 	} else if body != `{"results":[{"error":"database not found"}]}` {
 		t.Fatalf("unexpected body: %s", body)
@@ -328,7 +370,7 @@ func TestHandler_CreateRetentionPolicy_DatabaseNotFound(t *testing.T) {
 	query := map[string]string{"q": "CREATE RETENTION POLICY bar ON foo DURATION 1h REPLICATION 1"}
 	status, _ := MustHTTP("GET", s.URL+`/query`, query, nil, "")
 
-	if status != http.StatusInternalServerError {
+	if status != http.StatusNotFound {
 		t.Fatalf("unexpected status: %d", status)
 	}
 }
@@ -344,7 +386,7 @@ func TestHandler_CreateRetentionPolicy_Conflict(t *testing.T) {
 
 	status, _ := MustHTTP("GET", s.URL+`/query`, query, nil, "")
 
-	if status != http.StatusInternalServerError {
+	if status != http.StatusConflict {
 		t.Fatalf("unexpected status: %d", status)
 	}
 }
@@ -370,7 +412,7 @@ func TestHandler_UpdateRetentionPolicy(t *testing.T) {
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	query := map[string]string{"q": "ALTER RETENTION POLICY bar ON foo REPLICATION 42 DURATION 1m DEFAULT"}
+	query := map[string]string{"q": "ALTER RETENTION POLICY bar ON foo REPLICATION 42 FORCE DURATION 1m DEFAULT"}
 	status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
 
 	// Verify updated policy.
@@ -393,6 +435,25 @@ func TestHandler_UpdateRetentionPolicy(t *testing.T) {
 	}
 }
 
+func TestHandler_UpdateRetentionPolicy_ErrReplicationFactorTooHigh(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	query := map[string]string{"q": "ALTER RETENTION POLICY bar ON foo REPLICATION 42"}
+	status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
+
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"results":[{"error":"replication factor exceeds data node count"}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	} else if p, _ := srvr.RetentionPolicy("foo", "bar"); p.ReplicaN != 1 {
+		t.Fatalf("unexpected replication factor: %d", p.ReplicaN)
+	}
+}
+
 func TestHandler_UpdateRetentionPolicy_BadRequest(t *testing.T) {
 	srvr := OpenAuthlessServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
@@ -418,7 +479,7 @@ func TestHandler_UpdateRetentionPolicy_DatabaseNotFound(t *testing.T) {
 	status, _ := MustHTTP("GET", s.URL+`/query`, query, nil, "")
 
 	// Verify response.
-	if status != http.StatusInternalServerError {
+	if status != http.StatusNotFound {
 		t.Fatalf("unexpected status: %d", status)
 	}
 }
@@ -434,7 +495,7 @@ func TestHandler_UpdateRetentionPolicy_NotFound(t *testing.T) {
 	status, _ := MustHTTP("GET", s.URL+`/query`, query, nil, "")
 
 	// Verify response.
-	if status != http.StatusInternalServerError {
+	if status != http.StatusNotFound {
 		t.Fatalf("unexpected status: %d", status)
 	}
 }
@@ -464,7 +525,7 @@ func TestHandler_DeleteRetentionPolicy_DatabaseNotFound(t *testing.T) {
 	query := map[string]string{"q": "DROP RETENTION POLICY bar ON qux"}
 	status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
 
-	if status != http.StatusInternalServerError {
+	if status != http.StatusNotFound {
 		t.Fatalf("unexpected status: %d", status)
 	} else if body != `{"results":[{"error":"database not found"}]}` {
 		t.Fatalf("unexpected body: %s", body)
@@ -480,13 +541,53 @@ func TestHandler_DeleteRetentionPolicy_NotFound(t *testing.T) {
 	query := map[string]string{"q": "DROP RETENTION POLICY bar ON foo"}
 	status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
 
-	if status != http.StatusInternalServerError {
+	if status != http.StatusNotFound {
 		t.Fatalf("unexpected status: %d", status)
 	} else if body != `{"results":[{"error":"retention policy not found"}]}` {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
 
+func TestHandler_Query_Preflight(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	req, err := http.NewRequest("OPTIONS", s.URL+`/query`, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		panic(err)
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "http://example.com" {
+		t.Fatalf("unexpected Access-Control-Allow-Origin: %q", origin)
+	} else if headers := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(headers, "Authorization") {
+		t.Fatalf("unexpected Access-Control-Allow-Headers: %q", headers)
+	}
+}
+
+func TestHandler_Ready(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("GET", s.URL+`/ready`, nil, nil, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
 func TestHandler_GzipEnabled(t *testing.T) {
 	srvr := OpenAuthlessServer(NewMessagingClient())
 	s := NewHTTPServer(srvr)
@@ -645,6 +746,29 @@ func TestHandler_PingHead(t *testing.T) {
 	}
 }
 
+func TestHandler_Recovery(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+	s.Handler.TruncatedSegments = func() []string { return []string{"/broker/1/0"} }
+
+	status, body := MustHTTP("GET", s.URL+`/recovery`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	var r influxdb.RecoveryReport
+	if err := json.Unmarshal([]byte(body), &r); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if r.CompletedAt == nil {
+		t.Fatalf("expected recovery to be complete: %s", body)
+	} else if r.FormatVersion == 0 {
+		t.Fatalf("unexpected format version: %s", body)
+	} else if len(r.TruncatedSegments) != 1 || r.Clean() {
+		t.Fatalf("unexpected truncated segments: %s", body)
+	}
+}
+
 func TestHandler_Users_MultipleUsers(t *testing.T) {
 	srvr := OpenAuthlessServer(NewMessagingClient())
 	srvr.CreateUser("jdoe", "1337", false)
@@ -698,19 +822,18 @@ func TestHandler_UpdateUser_PasswordBadRequest(t *testing.T) {
 	}
 }
 
-func TestHandler_DataNodes(t *testing.T) {
-	t.Skip()
-	srvr := OpenUninitializedServer(NewMessagingClient())
+func TestHandler_ShowDataNodes(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
 	srvr.CreateDataNode(MustParseURL("http://localhost:1000"))
-	srvr.CreateDataNode(MustParseURL("http://localhost:2000"))
-	srvr.CreateDataNode(MustParseURL("http://localhost:3000"))
+	srvr.SetDataNodeLabels(2, map[string]string{"rack": "r1"})
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, body := MustHTTP("GET", s.URL+`/data_nodes`, nil, nil, "")
+	query := map[string]string{"q": "SHOW DATA NODES"}
+	status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
 	if status != http.StatusOK {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != `[{"id":1,"url":"http://localhost:1000"},{"id":2,"url":"http://localhost:2000"},{"id":3,"url":"http://localhost:3000"}]` {
+	} else if body != `{"results":[{"series":[{"name":"data_nodes","columns":["id","url","status","last_heartbeat","shard_count","disk_free","rack"],"values":[[1,"//127.0.0.1:8080","unknown",null,0,0,null],[2,"http://localhost:1000","unknown",null,0,0,"r1"]]}]}]}` {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
@@ -729,6 +852,28 @@ func TestHandler_CreateDataNode(t *testing.T) {
 	}
 }
 
+// Ensure a new data node's replica starts after the server's index.
+func TestHandler_CreateDataNode_ReplicaIndex(t *testing.T) {
+	c := NewMessagingClient()
+	srvr := OpenAuthlessServer(c)
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	var replicaIndex uint64
+	c.CreateReplicaFunc = func(replicaID uint64, connectURL *url.URL, index uint64) error {
+		replicaIndex = index
+		return nil
+	}
+
+	status, body := MustHTTP("POST", s.URL+`/data_nodes`, nil, nil, `{"url":"http://localhost:1000"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if replicaIndex == 0 || replicaIndex != srvr.Index() {
+		t.Fatalf("unexpected replica index: %d (server index %d)", replicaIndex, srvr.Index())
+	}
+}
+
 func TestHandler_CreateDataNode_BadRequest(t *testing.T) {
 	t.Skip()
 	srvr := OpenAuthlessServer(NewMessagingClient())
@@ -861,6 +1006,231 @@ func TestHandler_AuthenticatedDatabases_AuthorizedBasicAuth(t *testing.T) {
 	}
 }
 
+// Ensure admin users can run requests as another user and that it is logged.
+func TestHandler_Impersonate(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("bob", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+	var buf bytes.Buffer
+	s.Handler.SetLogOutput(&buf)
+
+	query := map[string]string{"q": "SHOW MEASUREMENTS", "db": "foo", "u": "lisa", "p": "password"}
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// The request fails as it would for bob.
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, map[string]string{"X-Influxdb-Impersonate": "bob"}, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if !strings.Contains(buf.String(), `impersonation: user "lisa" as "bob"`) {
+		t.Fatalf("impersonation not logged: %s", buf.String())
+	}
+
+	// Unknown users can't be impersonated.
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, map[string]string{"X-Influxdb-Impersonate": "nobody"}, ""); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Non-admin users can't impersonate.
+	query["u"] = "bob"
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, map[string]string{"X-Influxdb-Impersonate": "lisa"}, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+}
+
+func TestHandler_Token(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateDatabase("bar")
+	srvr.CreateUser("lisa", "password", true)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Create a token that can read foo.
+	query := map[string]string{"q": "CREATE TOKEN reader WITH READ ON foo", "u": "lisa", "p": "password"}
+	status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	var results influxdb.Results
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatal(err)
+	}
+	secret := results.Results[0].Series[0].Values[0][1].(string)
+	auth := map[string]string{"Authorization": "Token " + secret}
+
+	// The token can read foo but not bar.
+	if status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "SHOW MEASUREMENTS", "db": "foo"}, auth, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	if status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "SHOW MEASUREMENTS", "db": "bar"}, auth, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Unknown tokens are rejected.
+	if status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "SHOW MEASUREMENTS", "db": "foo"}, map[string]string{"Authorization": "Token bad"}, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Tokens are listed without their secrets.
+	query["q"] = "SHOW TOKENS"
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if !strings.Contains(body, `"reader",false,"READ ON foo"`) || strings.Contains(body, secret) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Revoked tokens are rejected.
+	query["q"] = "DROP TOKEN reader"
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	if status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "SHOW MEASUREMENTS", "db": "foo"}, auth, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+}
+
+func TestHandler_Login(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateUser("lisa", "password", true)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Bad credentials don't start a session.
+	resp, err := http.PostForm(s.URL+"/login?u=lisa&p=wrong", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if len(resp.Cookies()) != 0 {
+		t.Fatalf("unexpected cookies: %v", resp.Cookies())
+	}
+
+	// Log in and query with the session cookie.
+	resp, err = http.PostForm(s.URL+"/login?u=lisa&p=password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if len(resp.Cookies()) != 1 || resp.Cookies()[0].Name != httpd.SessionCookie || !resp.Cookies()[0].HttpOnly {
+		t.Fatalf("unexpected cookies: %v", resp.Cookies())
+	} else if h := resp.Header.Get("Set-Cookie"); !strings.HasSuffix(h, "; SameSite=Strict") {
+		t.Fatalf("unexpected Set-Cookie header: %s", h)
+	}
+	cookie := map[string]string{"Cookie": resp.Cookies()[0].String()}
+	query := map[string]string{"q": "SHOW DATABASES"}
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, cookie, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Unknown sessions are rejected.
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, map[string]string{"Cookie": httpd.SessionCookie + "=bad"}, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// The session ends on logout.
+	if status, body := MustHTTP("POST", s.URL+`/logout`, nil, cookie, ""); status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, cookie, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Sessions expire.
+	s.Handler.SessionTimeout = time.Millisecond
+	resp, err = http.PostForm(s.URL+"/login?u=lisa&p=password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	time.Sleep(10 * time.Millisecond)
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, map[string]string{"Cookie": resp.Cookies()[0].String()}, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+}
+
+func TestHandler_AuthExemptions(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// /ping and /status are exempt by default; /query is not.
+	if status, body := MustHTTP("GET", s.URL+`/status`, nil, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	if status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "SHOW DATABASES"}, nil, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Exempt only /ping.
+	s.Handler.AuthExemptions = map[string]bool{"/ping": true}
+	if status, body := MustHTTP("GET", s.URL+`/ping`, nil, nil, ""); status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	if status, body := MustHTTP("GET", s.URL+`/status`, nil, nil, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	if status, body := MustHTTP("GET", s.URL+`/status`, map[string]string{"u": "lisa", "p": "password"}, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Preflight requests are never authenticated.
+	if status, body := MustHTTP("OPTIONS", s.URL+`/status`, nil, nil, ""); status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+}
+
+func TestHandler_HandleFunc(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	h := httpd.NewHandler(srvr.Server, true, "X.X")
+
+	// Tag each request and serve a custom route that echoes the user.
+	h.Use(func(inner http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Team", "ops")
+			inner.ServeHTTP(w, r)
+		})
+	})
+	h.HandleFunc("whoami", "GET", "/whoami", func(w http.ResponseWriter, r *http.Request, u *influxdb.User) {
+		w.Write([]byte(u.Name))
+	})
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// Custom routes are authenticated.
+	if status, body := MustHTTP("GET", s.URL+`/whoami`, nil, nil, ""); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	resp, err := http.Get(s.URL + `/whoami?u=lisa&p=password`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := ioutil.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(b) != "lisa" {
+		t.Fatalf("unexpected response: %d: %s", resp.StatusCode, b)
+	} else if resp.Header.Get("X-Team") != "ops" {
+		t.Fatalf("middleware not applied: %v", resp.Header)
+	}
+
+	// Routes can't be added once the handler is serving.
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	h.HandleFunc("late", "GET", "/late", func(w http.ResponseWriter, r *http.Request) {})
+}
+
 func TestHandler_AuthenticatedDatabases_UnauthorizedBasicAuth(t *testing.T) {
 	srvr := OpenAuthenticatedServer(NewMessagingClient())
 	srvr.CreateUser("lisa", "password", true)
@@ -1000,48 +1370,931 @@ func TestHandler_DropSeries(t *testing.T) {
 	}
 }
 
-func TestHandler_serveWriteSeries(t *testing.T) {
-	srvr := OpenAuthenticatedServer(NewMessagingClient())
+func TestHandler_Query_Stats(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
 	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]}`)
-
+	status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [
+		{"name": "cpu", "tags": {"host": "server01"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}},
+		{"name": "cpu", "tags": {"host": "server02"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 50}}
+	]}`)
 	if status != http.StatusOK {
 		t.Fatalf("unexpected status: %d", status)
 	}
+
+	status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"db": "foo", "q": "SELECT value FROM cpu", "stats": "true"}, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	var results influxdb.Results
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatal(err)
+	} else if st := results.Results[0].Stats; st == nil {
+		t.Fatalf("expected stats: %s", body)
+	} else if st.SeriesN != 1 || st.RowsN != 2 || st.PointsScanned != 2 || st.ExecutionTime <= 0 {
+		t.Fatalf("unexpected stats: %#v", st)
+	}
+
+	// Statistics are omitted unless requested.
+	status, body = MustHTTP("GET", s.URL+`/query`, map[string]string{"db": "foo", "q": "SELECT value FROM cpu"}, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if strings.Contains(body, `"stats"`) {
+		t.Fatalf("unexpected body: %s", body)
+	}
 }
 
-func TestHandler_serveWriteSeriesWithNoFields(t *testing.T) {
-	srvr := OpenAuthenticatedServer(NewMessagingClient())
+func TestHandler_StoredQuery(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
 	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z"}]}`)
+	status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [
+		{"name": "cpu", "tags": {"host": "server01"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}},
+		{"name": "cpu", "tags": {"host": "server02"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 50}}
+	]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
 
-	expected := fmt.Sprintf(`{"error":"%s"}`, influxdb.ErrFieldsRequired.Error())
+	status, body := MustHTTP("GET", s.URL+`/query`, map[string]string{"db": "foo", "q": "CREATE QUERY host_cpu AS SELECT value FROM cpu WHERE host = $host"}, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
 
-	if status != http.StatusInternalServerError {
+	// Run the stored query with parameters from the URL.
+	status, body = MustHTTP("GET", s.URL+`/query`, map[string]string{"db": "foo", "name": "host_cpu", "params": `{"host": "server02"}`}, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"results":[{"series":[{"name":"cpu","columns":["time","value"],"values":[["2009-11-10T23:00:00Z",50]]}]}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Run the stored query with parameters from a JSON body.
+	status, body = MustHTTP("POST", s.URL+`/query`, nil, nil, `{"db": "foo", "name": "host_cpu", "params": {"host": "server01"}}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"results":[{"series":[{"name":"cpu","columns":["time","value"],"values":[["2009-11-10T23:00:00Z",100]]}]}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Missing parameters and unknown queries are rejected.
+	status, body = MustHTTP("GET", s.URL+`/query`, map[string]string{"db": "foo", "name": "host_cpu"}, nil, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"error binding query: missing value for parameter $host"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	status, _ = MustHTTP("GET", s.URL+`/query`, map[string]string{"db": "foo", "name": "no_such_query"}, nil, "")
+	if status != http.StatusNotFound {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != expected {
-		t.Fatalf("result mismatch:\n\texp=%s\n\tgot=%s\n", expected, body)
 	}
 }
 
-func TestHandler_serveWriteSeriesWithAuthNilUser(t *testing.T) {
-	srvr := OpenAuthenticatedServer(NewMessagingClient())
+func TestHandler_Schema(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
 	srvr.CreateDatabase("foo")
 	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
-	s := NewAuthenticatedHTTPServer(srvr)
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]}`)
-
-	if status != http.StatusUnauthorized {
+	status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [
+		{"name": "cpu", "tags": {"host": "server01", "region": "uswest"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100, "idle": true}},
+		{"name": "cpu", "tags": {"host": "server02"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 50}},
+		{"name": "mem", "timestamp": "2009-11-10T23:00:00Z", "fields": {"used": "lots"}}
+	]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, body := MustHTTP("GET", s.URL+`/schema`, map[string]string{"db": "foo"}, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"database":"foo","measurements":[{"name":"cpu","tagKeys":["host","region"],"fields":[{"name":"idle","type":"boolean"},{"name":"value","type":"number"}],"seriesCount":2},{"name":"mem","tagKeys":[],"fields":[{"name":"used","type":"string"}],"seriesCount":1}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, _ = MustHTTP("GET", s.URL+`/schema`, map[string]string{"db": "bar"}, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_Shards(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, body := MustHTTP("GET", s.URL+`/shards`, map[string]string{"db": "foo"}, nil, "")
+	var a []*influxdb.ShardStats
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if err := json.Unmarshal([]byte(body), &a); err != nil {
+		t.Fatalf("unexpected body: %s", body)
+	} else if len(a) != 1 || a[0].Database != "foo" || a[0].RetentionPolicy != "bar" || a[0].SeriesN != 1 || a[0].DiskBytes == 0 {
+		t.Fatalf("unexpected shards: %s", body)
+	}
+
+	status, _ = MustHTTP("GET", s.URL+`/shards`, map[string]string{"db": "baz"}, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_ExportImportShard(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.CreateDatabase("baz")
+	srvr.CreateRetentionPolicy("baz", influxdb.NewRetentionPolicy("bat"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}, {"name": "cpu", "timestamp": "2009-11-10T23:00:10Z", "fields": {"value": 200}}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	a, err := srvr.ShardStats("foo")
+	if err != nil || len(a) != 1 {
+		t.Fatalf("unexpected shards: %v", err)
+	}
+	id := a[0].ID
+
+	status, archive := MustHTTP("GET", s.URL+fmt.Sprintf(`/shards/%d/export`, id), nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if archive == "" {
+		t.Fatal("expected archive")
+	}
+
+	status, body := MustHTTP("POST", s.URL+`/shards/import`, map[string]string{"db": "baz", "rp": "bat"}, nil, archive)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"points":2}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, _ = MustHTTP("POST", s.URL+`/shards/import`, map[string]string{"db": "baz"}, nil, "not an archive")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, _ = MustHTTP("GET", s.URL+`/shards/1000/export`, nil, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_TagRewrites(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "serverA"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, body := MustHTTP("POST", s.URL+`/tag_rewrites`, map[string]string{"db": "foo", "measurement": "cpu", "key": "host", "old": "serverA", "new": "serverB"}, nil, "")
+	var j influxdb.TagRewrite
+	if status != http.StatusAccepted {
+		t.Fatalf("unexpected status: %d", status)
+	} else if err := json.Unmarshal([]byte(body), &j); err != nil {
+		t.Fatalf("unexpected body: %s", body)
+	} else if j.ID != 1 || j.SeriesN != 1 {
+		t.Fatalf("unexpected job: %s", body)
+	}
+
+	// Wait for the job to finish.
+	for i := 0; ; i++ {
+		status, body = MustHTTP("GET", s.URL+`/tag_rewrites/1`, nil, nil, "")
+		if status != http.StatusOK {
+			t.Fatalf("unexpected status: %d", status)
+		} else if err := json.Unmarshal([]byte(body), &j); err != nil {
+			t.Fatalf("unexpected body: %s", body)
+		} else if j.Done() {
+			break
+		} else if i == 100 {
+			t.Fatalf("job not done: %s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if j.SeriesDone != 1 || j.Err != "" {
+		t.Fatalf("unexpected job: %s", body)
+	}
+
+	status, _ = MustHTTP("GET", s.URL+`/tag_rewrites/2`, nil, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTP("POST", s.URL+`/tag_rewrites`, map[string]string{"db": "foo", "measurement": "mem", "key": "host", "old": "serverA", "new": "serverB"}, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_Subscribe(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Requests that aren't websocket handshakes are rejected.
+	status, _ := MustHTTP("GET", s.URL+`/subscribe`, map[string]string{"db": "foo", "q": "SELECT * FROM cpu"}, nil, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Open a websocket subscribed to one host.
+	u, _ := url.Parse(s.URL)
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	q := url.Values{"db": {"foo"}, "q": {`SELECT * FROM cpu WHERE host = 'server01' AND value > 10`}}
+	fmt.Fprintf(conn, "GET /subscribe?%s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", q.Encode(), u.Host)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if v := resp.Header.Get("Sec-WebSocket-Accept"); v != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key: %s", v)
+	}
+
+	status, _ = MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [
+		{"name": "cpu", "tags": {"host": "server02"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}},
+		{"name": "cpu", "tags": {"host": "server01"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 5}},
+		{"name": "cpu", "tags": {"host": "server01"}, "timestamp": "2009-11-10T23:00:01Z", "fields": {"value": 50}}
+	]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Only the matching point is received.
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Fatal(err)
+	} else if header[0] != 0x81 {
+		t.Fatalf("unexpected frame: %x", header[0])
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	} else if string(payload) != `{"name":"cpu","tags":{"host":"server01"},"timestamp":"2009-11-10T23:00:01Z","fields":{"value":50}}` {
+		t.Fatalf("unexpected payload: %s", payload)
+	}
+}
+
+func TestHandler_Events(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("GET", s.URL+`/events`, map[string]string{"db": "foo", "buffer": "0"}, nil, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTP("GET", s.URL+`/events`, map[string]string{"db": "foo", "tag": "host"}, nil, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	resp, err := http.Get(s.URL + `/events?db=foo&measurement=cpu&tag=host:server01&buffer=10`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if v := resp.Header.Get("Content-Type"); v != "text/event-stream" {
+		t.Fatalf("unexpected content type: %s", v)
+	}
+
+	status, _ = MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [
+		{"name": "mem", "tags": {"host": "server01"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}},
+		{"name": "cpu", "tags": {"host": "server02"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}},
+		{"name": "cpu", "tags": {"host": "server01"}, "timestamp": "2009-11-10T23:00:01Z", "fields": {"value": 50}}
+	]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Only the matching point is received.
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	} else if line != `data: {"name":"cpu","tags":{"host":"server01"},"timestamp":"2009-11-10T23:00:01Z","fields":{"value":50}}`+"\n" {
+		t.Fatalf("unexpected event: %s", line)
+	}
+}
+
+func TestHandler_Metadata(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("GET", s.URL+`/metadata`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if !strings.Contains(body, `"type":"createDatabase","command":{"name":"foo"},"databases":[{"name":"foo","retentionPolicies":[]}],"users":[]`) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/metadata/history`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if !strings.Contains(body, `"type":"createDatabase"`) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, _ = MustHTTP("GET", s.URL+`/metadata`, map[string]string{"index": "1"}, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+	status, _ = MustHTTP("GET", s.URL+`/metadata`, map[string]string{"time": "yesterday"}, nil, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_ReadOnly(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("PUT", s.URL+`/read_only`, map[string]string{"enabled": "true"}, nil, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, body := MustHTTP("GET", s.URL+`/read_only`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"readOnly":true}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, _ = MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]}`)
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, body = MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "CREATE DATABASE bar"}, nil, "")
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"results":[{"error":"server is read-only"}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, _ = MustHTTP("GET", s.URL+`/query`, map[string]string{"q": "SHOW DATABASES"}, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_Compaction(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("PUT", s.URL+`/compaction`, map[string]string{"concurrency": "4", "throughput": "1048576"}, nil, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, body := MustHTTP("GET", s.URL+`/compaction`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"concurrency":4,"throughput":1048576}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("PUT", s.URL+`/compaction`, map[string]string{"concurrency": "0"}, nil, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"invalid concurrency value"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_IO(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("PUT", s.URL+`/io`, map[string]string{"retention": "1024", "total": "1048576"}, nil, "")
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, body := MustHTTP("GET", s.URL+`/io`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"backup":52428800,"compaction":20971520,"retention":1024,"total":1048576}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("PUT", s.URL+`/io`, map[string]string{"scrub": "1"}, nil, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"unknown io class: scrub"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	status, body = MustHTTP("PUT", s.URL+`/io`, map[string]string{"backup": "-1"}, nil, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"invalid backup throughput value"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Pprof(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("GET", s.URL+`/debug/pprof/cmdline`, nil, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	s.Handler.PprofEnabled = true
+	status, _ = MustHTTP("GET", s.URL+`/debug/pprof/cmdline`, nil, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_Pprof_AdminRequired(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateUser("jdoe", "1337", false)
+	srvr.CreateUser("mclark", "1337", true)
+	s := NewAuthenticatedHTTPServer(srvr)
+	s.Handler.PprofEnabled = true
+	defer s.Close()
+
+	status, _ := MustHTTP("GET", s.URL+`/debug/pprof/cmdline`, map[string]string{"u": "jdoe", "p": "1337"}, nil, "")
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	status, _ = MustHTTP("GET", s.URL+`/debug/pprof/cmdline`, map[string]string{"u": "mclark", "p": "1337"}, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_serveWriteSeries_TraceSampled(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	var buf bytes.Buffer
+	s.Handler.SetLogOutput(&buf)
+	s.Handler.WriteTrace = true
+	s.Handler.WriteTraceSampleN = 2
+
+	for i := 0; i < 4; i++ {
+		status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]}`)
+		if status != http.StatusOK {
+			t.Fatalf("unexpected status: %d", status)
+		}
+	}
+
+	if n := strings.Count(buf.String(), `write trace: db="foo" rp="bar" points=1 `); n != 2 {
+		t.Fatalf("unexpected trace count: %d\n%s", n, buf.String())
+	}
+}
+
+func TestHandler_serveWriteSeries_TraceDatabase(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	var buf bytes.Buffer
+	s.Handler.SetLogOutput(&buf)
+	s.Handler.WriteTraceDatabases = map[string]bool{"foo": true}
+
+	status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if !strings.Contains(buf.String(), `write trace: db="foo" rp="bar" points=1 `) {
+		t.Fatalf("write not traced: %s", buf.String())
+	}
+}
+
+func TestHandler_serveWriteSeries_TraceTruncated(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.CreateUser("lisa", "secret", true)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	var buf bytes.Buffer
+	s.Handler.SetLogOutput(&buf)
+	s.Handler.WriteTrace = true
+	s.Handler.WriteTraceMaxBytes = 20
+
+	body := `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]}`
+	status, _ := MustHTTP("POST", s.URL+`/write`, map[string]string{"u": "lisa", "p": "secret"}, nil, body)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// The body is cut off and the password is not logged.
+	if !strings.Contains(buf.String(), fmt.Sprintf(`%s... (%d more bytes)`, body[:20], len(body)-20)) {
+		t.Fatalf("body not truncated: %s", buf.String())
+	} else if strings.Contains(buf.String(), "secret") {
+		t.Fatalf("password logged: %s", buf.String())
+	} else if !strings.Contains(buf.String(), "p=REDACTED") {
+		t.Fatalf("redacted url not logged: %s", buf.String())
+	}
+}
+
+func TestHandler_serveWriteSeries(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, _ := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]}`)
+
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_serveWriteSeries_DryRun(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"dry_run": "true"}, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"database":"foo","retentionPolicy":"bar","points":1,"newSeries":[{"name":"cpu","tags":{"host":"server01"}}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	} else if a := srvr.MeasurementNames("foo"); len(a) != 0 {
+		t.Fatalf("unexpected measurements: %v", a)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"dry_run": "true"}, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "fields": {"value": 100}}, {"name": "cpu", "fields": {"value": "x"}}]}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if !strings.Contains(body, `"errors":[{"index":1,"error":"field \"value\" is type string, previously written in batch as type number"}]`) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure that questionable writes are rejected in strict mode and normalized otherwise.
+func TestHandler_serveWriteSeries_ParseMode(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	for i, tt := range []struct {
+		body string
+		err  string
+	}{
+		{body: `{"database": "foo", "retentionPolicy": "bar", "extra": 1, "points": [{"name": "cpu", "fields": {"value": 1}}]}`, err: `unknown field \"extra\"`},
+		{body: `{"database": "foo", "retentionPolicy": "bar", "points": [{"name": "cpu", "fields": {"value": 1, "other": 1e400}}]}`, err: `point 0: invalid value for field \"other\": +Inf`},
+		{body: `{"database": "foo", "retentionPolicy": "bar", "points": [{"name": "cpu", "tags": {"host": ""}, "fields": {"value": 1}}]}`, err: `point 0: empty value for tag \"host\"`},
+		{body: `{"database": "foo", "retentionPolicy": "bar", "points": [{"name": "cpu", "fields": {"value": 1, "value": 2}}]}`, err: `point 0: duplicate field \"value\"`},
+	} {
+		status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"parse": "strict"}, nil, tt.body)
+		if status != http.StatusBadRequest {
+			t.Fatalf("%d. unexpected status: %d", i, status)
+		} else if !strings.Contains(body, tt.err) {
+			t.Fatalf("%d. unexpected body: %s", i, body)
+		}
+
+		status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"parse": "lenient"}, nil, tt.body)
+		if status != http.StatusOK {
+			t.Fatalf("%d. unexpected status: %d: %s", i, status, body)
+		}
+	}
+
+	// Only the normalized series and fields are written.
+	if a, err := srvr.Schema("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || len(a[0].TagKeys) != 0 || len(a[0].Fields) != 1 || a[0].Fields[0].Name != "value" {
+		t.Fatalf("unexpected schema: %s", mustMarshalJSON(a))
+	}
+
+	status, _ := MustHTTP("POST", s.URL+`/write`, map[string]string{"parse": "loose"}, nil, `{}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_serveWriteSeries_RetentionPolicyParam(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("baz"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	point := `"points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]`
+
+	// Without a default retention policy a policy must be given.
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", `+point+`}`)
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// The rp parameter is used when the body omits the policy.
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"rp": "bar"}, nil, `{"database" : "foo", `+point+`}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// The body and rp parameter must agree.
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"rp": "bar"}, nil, `{"database" : "foo", "retentionPolicy" : "baz", `+point+`}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Unknown policies are not found.
+	status, body = MustHTTP("POST", s.URL+`/write`, map[string]string{"rp": "qux"}, nil, `{"database" : "foo", `+point+`}`)
+	if status != http.StatusNotFound || body != `{"error":"retention policy not found"}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	// The default retention policy is used when no policy is given.
+	srvr.SetDefaultRetentionPolicy("foo", "baz")
+	status, body = MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", `+point+`}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+}
+
+func TestHandler_serveWriteSeries_Wait(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	write := `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}]}`
+	if status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"wait": "always"}, nil, write); status != http.StatusBadRequest || body != `{"error":"invalid wait mode: \"always\""}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	// Applied points can be read as soon as the write returns.
+	if status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"wait": "applied"}, nil, strings.Replace(write, "cpu", "disk", 1)); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	query := map[string]string{"db": "foo", "q": "SELECT value FROM disk"}
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK || body != `{"results":[{"series":[{"name":"disk","columns":["time","value"],"values":[["2009-11-10T23:00:00Z",100]]}]}]}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	// Flushed points can be read as soon as the write returns.
+	if status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"wait": "flushed"}, nil, write); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	query["q"] = "SELECT value FROM cpu"
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK || body != `{"results":[{"series":[{"name":"cpu","columns":["time","value"],"values":[["2009-11-10T23:00:00Z",100]]}]}]}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+
+	// Unacknowledged writes are accepted before they are written.
+	write = `{"database" : "foo", "points": [{"name": "mem", "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}]}`
+	if status, body := MustHTTP("POST", s.URL+`/write`, map[string]string{"wait": "none"}, nil, write); status != http.StatusAccepted {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	time.Sleep(100 * time.Millisecond) // Ensure data node picks up write.
+	query["q"] = "SELECT value FROM mem"
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK || body != `{"results":[{"series":[{"name":"mem","columns":["time","value"],"values":[["2009-11-10T23:00:00Z",100]]}]}]}` {
+		t.Fatalf("unexpected response: %d: %s", status, body)
+	}
+}
+
+func TestHandler_serveQuery_Epoch(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	index := MustWrite(s.URL, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00.123456789Z", "fields": {"value": 100}}]}`)
+	if err := srvr.Sync(index); err != nil {
+		t.Fatal(err)
+	}
+
+	for epoch, expected := range map[string]string{
+		"s":  `1257894000`,
+		"ms": `1257894000123`,
+		"u":  `1257894000123456`,
+		"n":  `1257894000123456789`,
+		"":   `"2009-11-10T23:00:00.123456789Z"`,
+	} {
+		query := map[string]string{"db": "foo", "q": "SELECT value FROM cpu", "epoch": epoch}
+		status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
+		if status != http.StatusOK {
+			t.Fatalf("unexpected status: %d", status)
+		} else if body != `{"results":[{"series":[{"name":"cpu","columns":["time","value"],"values":[[`+expected+`,100]]}]}]}` {
+			t.Fatalf("unexpected body for epoch %q: %s", epoch, body)
+		}
+	}
+
+	query := map[string]string{"db": "foo", "q": "SELECT value FROM cpu", "epoch": "fortnight"}
+	if status, _ := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+// Ensure the handler accepts a query timeout and rejects invalid ones.
+func TestHandler_serveQuery_Timeout(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.MaxQueryTimeout = time.Minute
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	index := MustWrite(s.URL, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}]}`)
+	if err := srvr.Sync(index); err != nil {
+		t.Fatal(err)
+	}
+
+	query := map[string]string{"db": "foo", "q": "SELECT value FROM cpu", "timeout": "1h"}
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"results":[{"series":[{"name":"cpu","columns":["time","value"],"values":[["2009-11-10T23:00:00Z",100]]}]}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	for _, timeout := range []string{"soon", "-1s", "0s"} {
+		query["timeout"] = timeout
+		if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusBadRequest {
+			t.Fatalf("unexpected status for %q: %d", timeout, status)
+		} else if body != fmt.Sprintf(`{"error":"invalid timeout: \"%s\""}`, timeout) {
+			t.Fatalf("unexpected body for %q: %s", timeout, body)
+		}
+	}
+}
+
+// Ensure a query waits for the index given by min_index.
+func TestHandler_serveQuery_MinIndex(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	s.Handler.MaxIndexWait = 10 * time.Millisecond
+	defer s.Close()
+
+	index := MustWrite(s.URL, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}]}`)
+
+	query := map[string]string{"db": "foo", "q": "SELECT value FROM cpu", "min_index": strconv.FormatUint(index, 10)}
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"results":[{"series":[{"name":"cpu","columns":["time","value"],"values":[["2009-11-10T23:00:00Z",100]]}]}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// The query isn't run if the index isn't applied in time.
+	query["min_index"] = strconv.FormatUint(index+100, 10)
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != fmt.Sprintf(`{"error":"timed out waiting for index %d"}`, index+100) {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	query["min_index"] = "latest"
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"error reading query: invalid min_index: \"latest\""}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+// Ensure the handler runs pipeline queries.
+func TestHandler_servePipeline(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [
+		{"name": "cpu", "tags": {"host": "serverA"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 10}},
+		{"name": "cpu", "tags": {"host": "serverA"}, "timestamp": "2009-11-10T23:00:30Z", "fields": {"value": 30}},
+		{"name": "cpu", "tags": {"host": "serverA"}, "timestamp": "2009-11-10T23:01:00Z", "fields": {"value": 100}},
+		{"name": "cpu", "tags": {"host": "serverB"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 5}}
+	]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// Keep the series whose mean per minute ever exceeds 50.
+	query := map[string]string{"q": `from(database: "foo", measurement: "cpu", field: "value") |> window(every: 1m) |> aggregate(fn: "mean") |> filter(expr: "value > 50")`}
+	status, body = MustHTTP("GET", s.URL+`/pipeline`, query, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"results":[{"series":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","value"],"values":[["2009-11-10T23:01:00Z",100]]}]}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	query = map[string]string{"q": `from(database: "foo", measurement: "cpu", field: "value") |> median()`}
+	if status, body = MustHTTP("GET", s.URL+`/pipeline`, query, nil, ""); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"error parsing pipeline: unknown stage: median()"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_serveWriteSeriesWithNoFields(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z"}]}`)
+
+	expected := fmt.Sprintf(`{"error":"%s"}`, influxdb.ErrFieldsRequired.Error())
+
+	if status != http.StatusInternalServerError {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != expected {
+		t.Fatalf("result mismatch:\n\texp=%s\n\tgot=%s\n", expected, body)
+	}
+}
+
+// Ensure writes with a point too far in the future are rejected as bad requests.
+func TestHandler_serveWriteSeries_FutureTimestamp(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.MaxFutureTime = 10 * time.Minute
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "timestamp": "2100-01-01T00:00:00Z", "fields": {"value": 100}}]}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", status)
+	} else if exp := `{"error":"point 0: invalid timestamp 2100-01-01T00:00:00Z: timestamp is too far in the future"}`; body != exp {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_serveWriteSeriesWithAuthNilUser(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","fields": {"value": 100}}]}`)
+
+	if status != http.StatusUnauthorized {
 		t.Fatalf("unexpected status: %d", status)
 	}
 
@@ -1051,6 +2304,117 @@ func TestHandler_serveWriteSeriesWithAuthNilUser(t *testing.T) {
 	}
 }
 
+func TestHandler_serveWriteSeries_InjectedTags(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.CreateUser("lisa", "password", true)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// The header is rejected unless it is enabled.
+	creds := map[string]string{"u": "lisa", "p": "password"}
+	write := `{"database" : "foo", "points": [{"name": "cpu", "tags": {"host": "server01", "tenant": "bob"}, "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}]}`
+	if status, body := MustHTTP("POST", s.URL+`/write`, creds, map[string]string{"X-Influxdb-Tags": "dc=east"}, write); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	s.Handler.UserTag = "tenant"
+	s.Handler.TagsHeaderEnabled = true
+	if status, body := MustHTTP("POST", s.URL+`/write`, creds, map[string]string{"X-Influxdb-Tags": "dc="}, write); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"invalid X-Influxdb-Tags header: \"dc=\""}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// The user tag can't be overridden by the body or the header.
+	if status, body := MustHTTP("POST", s.URL+`/write`, creds, map[string]string{"X-Influxdb-Tags": "dc=east, tenant=bob"}, write); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+	time.Sleep(100 * time.Millisecond) // Ensure data node picks up write.
+
+	query := map[string]string{"db": "foo", "q": "SELECT value FROM cpu GROUP BY *", "u": "lisa", "p": "password"}
+	if status, body := MustHTTP("GET", s.URL+`/query`, query, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"results":[{"series":[{"name":"cpu","tags":{"dc":"east","host":"server01","tenant":"lisa"},"columns":["time","value"],"values":[["2009-11-10T23:00:00Z",100]]}]}]}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_serveWriteSeries_MeasurementNotAuthorized(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	srvr.CreateUser("bob", "password", false)
+	srvr.SetPrivilegeWithFilter(influxql.WritePrivilege, "bob", "foo", &influxdb.MeasurementFilter{Names: []string{"cpu"}})
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	creds := map[string]string{"u": "bob", "p": "password"}
+	if status, body := MustHTTP("POST", s.URL+`/write`, creds, nil, `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}]}`); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	// The whole batch is rejected if any point is to another measurement.
+	status, body := MustHTTP("POST", s.URL+`/write`, creds, nil, `{"database" : "foo", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}, {"name": "mem", "timestamp": "2009-11-10T23:00:00Z", "fields": {"value": 100}}]}`)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"\"bob\" user is not authorized to write to measurement \"mem\" in database \"foo\""}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestHandler_Annotations(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.SetDefaultRetentionPolicy("foo", "bar")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	if status, body := MustHTTP("POST", s.URL+`/annotations`, nil, nil, `{"database": "foo", "start": "2000-01-01T00:00:00Z"}`); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `{"error":"annotation text required"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	if status, body := MustHTTP("POST", s.URL+`/annotations`, nil, nil, `{"database": "bar", "text": "deploy"}`); status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	for _, a := range []string{
+		`{"database": "foo", "start": "2000-01-01T00:00:00Z", "text": "deploy v1", "tags": {"app": "api"}}`,
+		`{"database": "foo", "start": "2000-01-01T01:00:00Z", "end": "2000-01-01T02:00:00Z", "text": "outage", "tags": {"app": "web"}}`,
+	} {
+		if status, body := MustHTTP("POST", s.URL+`/annotations`, nil, nil, a); status != http.StatusNoContent {
+			t.Fatalf("unexpected status: %d: %s", status, body)
+		}
+	}
+	time.Sleep(100 * time.Millisecond) // Ensure data node picks up write.
+
+	if status, body := MustHTTP("GET", s.URL+`/annotations`, map[string]string{"db": "foo"}, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `[{"start":"2000-01-01T00:00:00Z","end":"2000-01-01T00:00:00Z","text":"deploy v1","tags":{"app":"api"}},{"start":"2000-01-01T01:00:00Z","end":"2000-01-01T02:00:00Z","text":"outage","tags":{"app":"web"}}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Annotations are filtered by window and tags.
+	if status, body := MustHTTP("GET", s.URL+`/annotations`, map[string]string{"db": "foo", "start": "2000-01-01T01:30:00Z", "end": "2000-01-01T03:00:00Z"}, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `[{"start":"2000-01-01T01:00:00Z","end":"2000-01-01T02:00:00Z","text":"outage","tags":{"app":"web"}}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	if status, body := MustHTTP("GET", s.URL+`/annotations`, map[string]string{"db": "foo", "tags": "app=api"}, nil, ""); status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	} else if body != `[{"start":"2000-01-01T00:00:00Z","end":"2000-01-01T00:00:00Z","text":"deploy v1","tags":{"app":"api"}}]` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	if status, body := MustHTTP("GET", s.URL+`/annotations`, map[string]string{"db": "foo", "tags": "app"}, nil, ""); status != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+}
+
 func TestHandler_serveWriteSeries_noDatabaseExists(t *testing.T) {
 	srvr := OpenAuthenticatedServer(NewMessagingClient())
 	s := NewHTTPServer(srvr)
@@ -1366,6 +2730,26 @@ func MustHTTP(verb, path string, params, headers map[string]string, body string)
 	return resp.StatusCode, strings.TrimRight(string(b), "\n")
 }
 
+// MustWrite posts a write to the server at the given URL and returns the
+// index from the X-InfluxDB-Index header. Panic on error.
+func MustWrite(u, body string) uint64 {
+	resp, err := http.Post(u+"/write", "application/json", strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		panic(fmt.Sprintf("unexpected status: %d: %s", resp.StatusCode, b))
+	}
+	index, err := strconv.ParseUint(resp.Header.Get("X-InfluxDB-Index"), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	return index
+}
+
 // MustParseURL parses a string into a URL. Panic on error.
 func MustParseURL(s string) *url.URL {
 	u, err := url.Parse(s)
@@ -1466,7 +2850,7 @@ type MessagingClient struct {
 	mu    sync.Mutex // Ensure all publishing is serialized.
 
 	PublishFunc       func(*messaging.Message) (uint64, error)
-	CreateReplicaFunc func(replicaID uint64, connectURL *url.URL) error
+	CreateReplicaFunc func(replicaID uint64, connectURL *url.URL, index uint64) error
 	DeleteReplicaFunc func(replicaID uint64) error
 	SubscribeFunc     func(replicaID, topicID uint64) error
 	UnsubscribeFunc   func(replicaID, topicID uint64) error
@@ -1476,7 +2860,7 @@ type MessagingClient struct {
 func NewMessagingClient() *MessagingClient {
 	c := &MessagingClient{c: make(chan *messaging.Message, 1)}
 	c.PublishFunc = c.send
-	c.CreateReplicaFunc = func(replicaID uint64, connectURL *url.URL) error { return nil }
+	c.CreateReplicaFunc = func(replicaID uint64, connectURL *url.URL, index uint64) error { return nil }
 	c.DeleteReplicaFunc = func(replicaID uint64) error { return nil }
 	c.SubscribeFunc = func(replicaID, topicID uint64) error { return nil }
 	c.UnsubscribeFunc = func(replicaID, topicID uint64) error { return nil }
@@ -1501,8 +2885,8 @@ func (c *MessagingClient) send(m *messaging.Message) (uint64, error) {
 }
 
 // Creates a new replica with a given ID on the broker.
-func (c *MessagingClient) CreateReplica(replicaID uint64, connectURL *url.URL) error {
-	return c.CreateReplicaFunc(replicaID, connectURL)
+func (c *MessagingClient) CreateReplicaFrom(replicaID uint64, connectURL *url.URL, index uint64) error {
+	return c.CreateReplicaFunc(replicaID, connectURL, index)
 }
 
 // Deletes an existing replica with a given ID from the broker.
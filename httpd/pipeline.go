@@ -0,0 +1,63 @@
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/pipeline"
+)
+
+// servePipeline runs a pipeline query passed in the "q" parameter. The points
+// are read with the privileges of the user and the result of the last stage
+// is returned in the same format as a query.
+func (h *Handler) servePipeline(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "error reading pipeline: "+err.Error(), false, http.StatusBadRequest)
+		return
+	}
+	pretty := r.FormValue("pretty") == "true"
+
+	var precision time.Duration
+	if epoch := r.FormValue("epoch"); epoch != "" {
+		if precision = epochPrecisions[epoch]; precision == 0 {
+			httpError(w, fmt.Sprintf("invalid epoch: %q", epoch), pretty, http.StatusBadRequest)
+			return
+		}
+	}
+
+	p, err := pipeline.Parse(r.FormValue("q"), time.Now().UTC())
+	if err != nil {
+		httpError(w, "error parsing pipeline: "+err.Error(), pretty, http.StatusBadRequest)
+		return
+	} else if err := p.Bind(h.UDFs); err != nil {
+		httpError(w, "error parsing pipeline: "+err.Error(), pretty, http.StatusBadRequest)
+		return
+	}
+	q, err := p.Query()
+	if err != nil {
+		httpError(w, "error parsing pipeline: "+err.Error(), pretty, http.StatusBadRequest)
+		return
+	}
+
+	// Read the points and pass them through the stages.
+	closing, done := closeNotify(w)
+	defer done()
+	results := h.server.ExecuteQueryWithOptions(q, p.Database, user, influxdb.QueryOptions{Closing: closing})
+	if err := results.Error(); err != nil {
+		httpResults(w, results, pretty)
+		return
+	}
+	rows, err := p.Apply(results.Results[0].Series)
+	if err != nil {
+		httpError(w, "error running pipeline: "+err.Error(), pretty, http.StatusBadRequest)
+		return
+	}
+
+	results = influxdb.Results{Results: []*influxdb.Result{{Series: rows}}}
+	if precision != 0 {
+		convertToEpoch(results, precision)
+	}
+	httpResults(w, results, pretty)
+}
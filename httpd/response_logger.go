@@ -1,9 +1,12 @@
 package httpd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -42,6 +45,31 @@ func (l *responseLogger) WriteHeader(s int) {
 	l.status = s
 }
 
+// Hijack lets the handler take over the connection, e.g. for websockets.
+func (l *responseLogger) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := l.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer cannot be hijacked")
+	}
+	l.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+// Flush sends any buffered data to the client.
+func (l *responseLogger) Flush() {
+	if f, ok := l.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify returns a channel that receives a value when the client goes away.
+func (l *responseLogger) CloseNotify() <-chan bool {
+	if cn, ok := l.w.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
 func (l *responseLogger) Status() int {
 	return l.status
 }
@@ -63,7 +91,7 @@ func buildLogLine(l *responseLogger, r *http.Request, start time.Time) string {
 		host = r.RemoteAddr
 	}
 
-	uri := r.URL.RequestURI()
+	uri := redactedRequestURI(r.URL)
 
 	referer := r.Referer()
 
@@ -87,6 +115,20 @@ func buildLogLine(l *responseLogger, r *http.Request, start time.Time) string {
 	return strings.Join(fields, " ")
 }
 
+// redactedRequestURI returns the request URI with the password parameter
+// replaced so that credentials aren't written to logs.
+func redactedRequestURI(u *url.URL) string {
+	q := u.Query()
+	if q.Get("p") == "" {
+		return u.RequestURI()
+	}
+	q.Set("p", "REDACTED")
+
+	other := *u
+	other.RawQuery = q.Encode()
+	return other.RequestURI()
+}
+
 // detect detects the first presense of a non blank string and returns it
 func detect(values ...string) string {
 	for _, v := range values {
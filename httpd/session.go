@@ -0,0 +1,176 @@
+package httpd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb"
+)
+
+const (
+	// DefaultSessionTimeout is the default lifetime of a login session.
+	DefaultSessionTimeout = time.Hour
+
+	// SessionCookie is the name of the cookie holding a session's token.
+	SessionCookie = "influxdb_session"
+
+	// sessionTokenSize is the number of random bytes in a session token.
+	sessionTokenSize = 32
+)
+
+// errSessionExpired is returned when a session cookie is unknown or expired.
+var errSessionExpired = errors.New("session expired")
+
+// session represents a user logged in through the login endpoint.
+type session struct {
+	username string
+	expires  time.Time
+}
+
+// sessions holds the login sessions issued by a handler. Sessions are kept in
+// memory so they are only valid on the node that issued them and end when the
+// node restarts.
+type sessions struct {
+	mu sync.Mutex
+	m  map[string]*session
+}
+
+// create starts a session for a user and returns its token.
+func (s *sessions) create(username string, expires time.Time) (string, error) {
+	b := make([]byte, sessionTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]*session)
+	}
+
+	// Remove expired sessions so abandoned logins don't accumulate.
+	now := time.Now()
+	for k, other := range s.m {
+		if now.After(other.expires) {
+			delete(s.m, k)
+		}
+	}
+
+	s.m[token] = &session{username: username, expires: expires}
+	return token, nil
+}
+
+// username returns the user of an unexpired session.
+func (s *sessions) username(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := s.m[token]
+	if ss == nil {
+		return "", false
+	} else if time.Now().After(ss.expires) {
+		delete(s.m, token)
+		return "", false
+	}
+	return ss.username, true
+}
+
+// remove ends a session.
+func (s *sessions) remove(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, token)
+}
+
+// serveLogin authenticates a user with their credentials and starts a session.
+// The session's token is set in a cookie that authenticates later requests
+// until the session expires or the user logs out.
+func (h *Handler) serveLogin(w http.ResponseWriter, r *http.Request) {
+	username, password, err := parseCredentials(r)
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+	user, err := h.server.Authenticate(username, password)
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	} else if user == nil {
+		httpError(w, "invalid username or password", false, http.StatusUnauthorized)
+		return
+	}
+
+	timeout := h.SessionTimeout
+	if timeout <= 0 {
+		timeout = DefaultSessionTimeout
+	}
+	expires := time.Now().Add(timeout)
+	token, err := h.sessions.create(user.Name, expires)
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"user": user.Name, "expires": expires.UTC()})
+}
+
+// serveLogout ends the request's session and clears its cookie.
+func (h *Handler) serveLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		h.sessions.remove(c.Value)
+	}
+	setSessionCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setSessionCookie adds a Set-Cookie header for a session cookie. The cookie
+// is only sent on same-site requests. The attribute is written by hand since
+// http.Cookie has no field for it.
+func setSessionCookie(w http.ResponseWriter, c *http.Cookie) {
+	w.Header().Add("Set-Cookie", c.String()+"; SameSite=Strict")
+}
+
+// sessionUser returns the user of the session with the given token.
+// Returns an error if the session has expired or its user was dropped.
+func (h *Handler) sessionUser(token string) (*influxdb.User, error) {
+	name, ok := h.sessions.username(token)
+	if !ok {
+		return nil, errSessionExpired
+	}
+	u := h.server.User(name)
+	if u == nil {
+		h.sessions.remove(token)
+		return nil, errSessionExpired
+	}
+	return u, nil
+}
+
+// hasCredentials returns true if the request passes a username and password.
+func hasCredentials(r *http.Request) bool {
+	if q := r.URL.Query(); q.Get("u") != "" && q.Get("p") != "" {
+		return true
+	}
+	_, _, ok := r.BasicAuth()
+	return ok
+}
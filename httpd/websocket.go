@@ -0,0 +1,172 @@
+package httpd
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// webSocketGUID is combined with the client's key to accept a websocket handshake.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocket frame opcodes.
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// errNotWebSocket is returned when a request is not a websocket handshake.
+var errNotWebSocket = errors.New("websocket upgrade required")
+
+// webSocket is a minimal server-side websocket connection (RFC 6455). It sends
+// text messages to the client and only reads control frames from the client.
+type webSocket struct {
+	mu   sync.Mutex
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	closing chan struct{} // closed when the client closes the connection
+}
+
+// upgradeWebSocket completes the websocket handshake and takes over the connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*webSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		return nil, errNotWebSocket
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("websockets not supported")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// Accept the handshake.
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ws := &webSocket{conn: conn, rw: rw, closing: make(chan struct{})}
+	go ws.read()
+	return ws, nil
+}
+
+// Closing returns a channel that is closed when the client goes away.
+func (ws *webSocket) Closing() <-chan struct{} { return ws.closing }
+
+// WriteText sends a text message to the client.
+func (ws *webSocket) WriteText(b []byte) error { return ws.writeFrame(opText, b) }
+
+// Close sends a close frame and closes the connection.
+func (ws *webSocket) Close() error {
+	_ = ws.writeFrame(opClose, nil)
+	return ws.conn.Close()
+}
+
+// writeFrame writes a single unmasked, unfragmented frame.
+func (ws *webSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	if _, err := ws.rw.Write(header); err != nil {
+		return err
+	} else if _, err := ws.rw.Write(payload); err != nil {
+		return err
+	}
+	return ws.rw.Flush()
+}
+
+// read consumes frames from the client, answering pings, until the client
+// closes the connection or an error occurs.
+func (ws *webSocket) read() {
+	defer close(ws.closing)
+
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(ws.rw, header[:]); err != nil {
+			return
+		}
+		opcode, masked := header[0]&0x0F, header[1]&0x80 != 0
+
+		// Read the payload length.
+		n := uint64(header[1] & 0x7F)
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(ws.rw, b[:]); err != nil {
+				return
+			}
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(ws.rw, b[:]); err != nil {
+				return
+			}
+			n = binary.BigEndian.Uint64(b[:])
+		}
+
+		// Client frames are masked; only control frames are kept.
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(ws.rw, mask[:]); err != nil {
+				return
+			}
+		}
+		if opcode < opClose {
+			if _, err := io.CopyN(ioutil.Discard, ws.rw, int64(n)); err != nil {
+				return
+			}
+			continue
+		} else if n > 125 {
+			return // control frames must be short
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(ws.rw, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case opClose:
+			return
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -4,10 +4,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/influxdb/influxdb/client"
+	"github.com/influxdb/influxdb/influxql"
 )
 
 var (
@@ -65,6 +70,18 @@ var (
 	// ErrInvalidUsername is returned when using a username with invalid characters.
 	ErrInvalidUsername = errors.New("invalid username")
 
+	// ErrTokenExists is returned when creating a duplicate API token.
+	ErrTokenExists = errors.New("token exists")
+
+	// ErrTokenNotFound is returned when dropping a non-existent API token.
+	ErrTokenNotFound = errors.New("token not found")
+
+	// ErrTokenNameRequired is returned when creating an API token without a name.
+	ErrTokenNameRequired = errors.New("token name required")
+
+	// ErrTokenPrivilegesRequired is returned when creating an API token without privileges.
+	ErrTokenPrivilegesRequired = errors.New("token privileges required")
+
 	// ErrRetentionPolicyExists is returned when creating a duplicate shard space.
 	ErrRetentionPolicyExists = errors.New("retention policy exists")
 
@@ -74,6 +91,19 @@ var (
 	// ErrRetentionPolicyNameRequired is returned using a blank shard space name.
 	ErrRetentionPolicyNameRequired = errors.New("retention policy name required")
 
+	// ErrInvalidDuplicatePolicy is returned when a retention policy specifies
+	// an unknown duplicate point policy.
+	ErrInvalidDuplicatePolicy = errors.New("invalid duplicate policy")
+
+	// ErrDuplicatePointRejected is returned for a write when the retention
+	// policy rejects duplicates and a point already existed. The write's other
+	// points are still written.
+	ErrDuplicatePointRejected = errors.New("duplicate point rejected")
+
+	// ErrReplicationFactorTooHigh is returned when a retention policy's
+	// replication factor exceeds the number of data nodes and isn't forced.
+	ErrReplicationFactorTooHigh = errors.New("replication factor exceeds data node count")
+
 	// ErrDefaultRetentionPolicyNotFound is returned when using the default
 	// policy on a database but the default has not been set.
 	ErrDefaultRetentionPolicyNotFound = errors.New("default retention policy not found")
@@ -81,6 +111,14 @@ var (
 	// ErrShardNotFound is returned writing to a non-existent shard.
 	ErrShardNotFound = errors.New("shard not found")
 
+	// ErrShardGroupNotDroppable is returned when dropping a shard whose group
+	// has other shards. Only whole shard groups can be dropped.
+	ErrShardGroupNotDroppable = errors.New("only whole shard groups can be dropped")
+
+	// ErrTruncateTimeInPast is returned when truncating shard groups at a time
+	// that has already passed.
+	ErrTruncateTimeInPast = errors.New("truncate time is in the past")
+
 	// ErrInvalidPointBuffer is returned when a buffer containing data for writing is invalid
 	ErrInvalidPointBuffer = errors.New("invalid point buffer")
 
@@ -94,6 +132,13 @@ var (
 	// ErrInvalidQuery is returned when executing an unknown query type.
 	ErrInvalidQuery = errors.New("invalid query")
 
+	// ErrTooManyGroups is returned when a query groups by more tag values
+	// than the server allows.
+	ErrTooManyGroups = errors.New("too many groups")
+
+	// ErrQueryTimeout is returned when a query runs longer than its timeout.
+	ErrQueryTimeout = errors.New("query timeout")
+
 	// ErrMeasurementNameRequired is returned when a point does not contain a name.
 	ErrMeasurementNameRequired = errors.New("measurement name required")
 
@@ -106,15 +151,48 @@ var (
 	// ErrFieldOverflow is returned when too many fields are created on a measurement.
 	ErrFieldOverflow = errors.New("field overflow")
 
+	// ErrNonFiniteFieldValue is returned when a point has a NaN or ±Inf field
+	// value. Such values can't be encoded in JSON query results.
+	ErrNonFiniteFieldValue = errors.New("field values must be finite numbers, NaN and Inf are not allowed")
+
+	// ErrTimestampInFuture is returned when a point's timestamp is further
+	// ahead of the server's clock than the server allows.
+	ErrTimestampInFuture = errors.New("timestamp is too far in the future")
+
+	// ErrInvalidSystemQuery is returned when a select statement on a system
+	// measurement does more than select columns.
+	ErrInvalidSystemQuery = errors.New("system measurements only support selecting columns with WHERE, LIMIT and OFFSET")
+
+	// ErrSystemMeasurement is returned when writing to a system measurement.
+	ErrSystemMeasurement = errors.New("system measurements can't be written to")
+
+	// ErrReservedKey is returned when a point has a tag or field key that
+	// can't be queried. Queries use "time" for the point's timestamp.
+	ErrReservedKey = errors.New(`tag and field keys must not be blank or "time"`)
+
 	// ErrFieldTypeConflict is returned when a new field already exists with a different type.
 	ErrFieldTypeConflict = errors.New("field type conflict")
 
+	// ErrInvalidMeasurementTTL is returned when a measurement TTL is negative.
+	ErrInvalidMeasurementTTL = errors.New("invalid measurement ttl")
+
 	// ErrFieldNotFound is returned when a field cannot be found.
 	ErrFieldNotFound = errors.New("field not found")
 
+	// ErrInvalidFieldType is returned when a field is converted to an unsupported type.
+	ErrInvalidFieldType = errors.New("invalid field type")
+
+	// ErrFieldConversionInProgress is returned when changing the type of a
+	// field whose values are still being converted to another type.
+	ErrFieldConversionInProgress = errors.New("field conversion in progress")
+
 	// ErrSeriesNotFound is returned when looking up a non-existent series by database, name and tags
 	ErrSeriesNotFound = errors.New("series not found")
 
+	// ErrInvalidTagRewrite is returned when a tag rewrite has no key or new value
+	// or the new value is the same as the old value.
+	ErrInvalidTagRewrite = errors.New("invalid tag rewrite")
+
 	// ErrSeriesExists is returned when attempting to set the id of a series by database, name and tags that already exists
 	ErrSeriesExists = errors.New("series already exists")
 
@@ -126,8 +204,76 @@ var (
 	// privilege for a user on the cluster or a database.
 	ErrInvalidGrantRevoke = errors.New("invalid privilege requested")
 
+	// ErrReadOnly is returned when a write or a mutating statement is sent to
+	// a server in read-only mode.
+	ErrReadOnly = errors.New("server is read-only")
+
+	// ErrWaitTimeout is returned when an index is not applied before a wait times out.
+	ErrWaitTimeout = errors.New("timed out waiting for index")
+
+	// ErrMetadataSnapshotNotFound is returned when no metadata history exists
+	// at or before a requested index or time.
+	ErrMetadataSnapshotNotFound = errors.New("metadata snapshot not found")
+
+	// ErrSlowSubscriber is returned when a subscription is closed because it
+	// did not keep up with the points being written.
+	ErrSlowSubscriber = errors.New("subscriber too slow")
+
+	// ErrSeriesQuotaExceeded is returned when a write would create more series
+	// than the database's quota allows.
+	ErrSeriesQuotaExceeded = errors.New("series quota exceeded")
+
+	// ErrWriteRateQuotaExceeded is returned when a write would exceed the
+	// number of points per second the database's quota allows.
+	ErrWriteRateQuotaExceeded = errors.New("write rate quota exceeded")
+
+	// ErrWriteThrottled is returned when a write would exceed the database's
+	// write limit.
+	ErrWriteThrottled = errors.New("database write limit exceeded")
+
+	// ErrInvalidWriteLimit is returned when a database's write limit or burst
+	// is negative.
+	ErrInvalidWriteLimit = errors.New("invalid write limit")
+
+	// ErrInvalidNameRules is returned when a database's measurement name rules
+	// have an invalid character class or a negative maximum length.
+	ErrInvalidNameRules = errors.New("invalid measurement name rules")
+
+	// ErrQueryQuotaExceeded is returned when a query would exceed the number
+	// of concurrent queries the database's quota allows.
+	ErrQueryQuotaExceeded = errors.New("concurrent query quota exceeded")
+
+	// ErrDiskQuotaExceeded is returned when writing to a database whose shards
+	// use more disk than its quota allows.
+	ErrDiskQuotaExceeded = errors.New("disk quota exceeded")
+
 	// ErrContinuousQueryExists is returned when creating a duplicate continuous query.
 	ErrContinuousQueryExists = errors.New("continuous query already exists")
+
+	// ErrStoredQueryExists is returned when creating a duplicate stored query.
+	ErrStoredQueryExists = errors.New("stored query already exists")
+
+	// ErrStoredQueryNotFound is returned when a stored query doesn't exist.
+	ErrStoredQueryNotFound = errors.New("stored query not found")
+
+	// ErrAnnotationTextRequired is returned when writing an annotation without text.
+	ErrAnnotationTextRequired = errors.New("annotation text required")
+
+	// ErrAnnotationEndBeforeStart is returned when writing an annotation that
+	// ends before it starts.
+	ErrAnnotationEndBeforeStart = errors.New("annotation ends before it starts")
+
+	// ErrShardNotLocal is returned when reading the data of a shard that
+	// isn't stored on this server.
+	ErrShardNotLocal = errors.New("shard is not stored on this server")
+
+	// ErrInvalidChecksumPolicy is returned when the server is configured with
+	// an unknown checksum policy.
+	ErrInvalidChecksumPolicy = errors.New("invalid checksum policy")
+
+	// ErrChecksumMismatch is returned when a point read from a shard doesn't
+	// match its checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
 )
 
 // BatchPoints is used to send batched data in a single write.
@@ -138,72 +284,302 @@ type BatchPoints struct {
 	Tags            map[string]string `json:"tags"`
 	Timestamp       time.Time         `json:"timestamp"`
 	Precision       string            `json:"precision"`
+
+	// Add a nanosecond per point to the timestamps given to points without
+	// one so points with the same series in a batch don't overwrite each other.
+	IncrementTimestamps bool `json:"incrementTimestamps,omitempty"`
 }
 
 // UnmarshalJSON decodes the data into the BatchPoints struct
 func (bp *BatchPoints) UnmarshalJSON(b []byte) error {
-	var normal struct {
-		Points          []client.Point    `json:"points"`
-		Database        string            `json:"database"`
-		RetentionPolicy string            `json:"retentionPolicy"`
-		Tags            map[string]string `json:"tags"`
-		Timestamp       time.Time         `json:"timestamp"`
-		Precision       string            `json:"precision"`
+	var v struct {
+		Points              []client.Point    `json:"points"`
+		Database            string            `json:"database"`
+		RetentionPolicy     string            `json:"retentionPolicy"`
+		Tags                map[string]string `json:"tags"`
+		Timestamp           json.RawMessage   `json:"timestamp"`
+		Precision           string            `json:"precision"`
+		IncrementTimestamps bool              `json:"incrementTimestamps"`
 	}
-	var epoch struct {
-		Points          []client.Point    `json:"points"`
-		Database        string            `json:"database"`
-		RetentionPolicy string            `json:"retentionPolicy"`
-		Tags            map[string]string `json:"tags"`
-		Timestamp       *int64            `json:"timestamp"`
-		Precision       string            `json:"precision"`
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
 	}
+	ts, err := client.ParseTimestamp(v.Timestamp, v.Precision)
+	if err != nil {
+		return err
+	}
+	bp.Points = v.Points
+	bp.Database = v.Database
+	bp.RetentionPolicy = v.RetentionPolicy
+	bp.Tags = v.Tags
+	bp.Timestamp = ts
+	bp.Precision = v.Precision
+	bp.IncrementTimestamps = v.IncrementTimestamps
+
+	return nil
+}
 
-	if err := func() error {
-		var err error
-		if err = json.Unmarshal(b, &epoch); err != nil {
-			return err
+// ParseMode controls how questionable input in a write is handled. Lenient
+// parsing normalizes the input while strict parsing rejects the write:
+//
+//	input                     lenient             strict
+//	unknown top-level field   ignored             rejected
+//	NaN or ±Inf field value   field dropped       rejected
+//	empty tag value           tag dropped         rejected
+//	duplicate field key       last value kept     rejected
+//	duplicate tag key         last value kept     rejected
+//	field key used by a tag   field dropped       rejected
+//	blank or "time" key       tag/field dropped   rejected
+//
+// Unknown fields and duplicate keys can only be detected in the encoded
+// write so they are checked by CheckBatchPointsJSON. Keys are checked in
+// sorted order so the same write always reports the same error.
+type ParseMode int
+
+const (
+	// LenientParse normalizes questionable input.
+	LenientParse ParseMode = iota
+
+	// StrictParse rejects writes with questionable input.
+	StrictParse
+)
+
+// ParseParseMode returns the parse mode with the given name.
+// An empty name returns LenientParse.
+func ParseParseMode(s string) (ParseMode, error) {
+	switch s {
+	case "", "lenient":
+		return LenientParse, nil
+	case "strict":
+		return StrictParse, nil
+	}
+	return LenientParse, fmt.Errorf("invalid parse mode: %q", s)
+}
+
+// batchPointsJSONFields are the top-level fields of an encoded BatchPoints.
+var batchPointsJSONFields = map[string]bool{
+	"points":              true,
+	"database":            true,
+	"retentionPolicy":     true,
+	"tags":                true,
+	"timestamp":           true,
+	"precision":           true,
+	"incrementTimestamps": true,
+}
+
+// PointError is returned when a point in a write is invalid.
+type PointError struct {
+	Index  int    // position of the point in the write
+	Key    string // tag or field key that is invalid, if any
+	Reason string // description of the problem, such as "duplicate tag"
+	Err    error  // underlying error, if any
+}
+
+// Error returns the position of the point and the reason it is invalid.
+func (e *PointError) Error() string {
+	msg := fmt.Sprintf("point %d: %s", e.Index, e.Reason)
+	if e.Key != "" {
+		msg += fmt.Sprintf(" %q", e.Key)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// CheckBatchPointsJSON returns an error if an encoded BatchPoints has unknown
+// top-level fields, duplicate batch tag keys, or a point with duplicate tag
+// or field keys.
+func CheckBatchPointsJSON(b []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !batchPointsJSONFields[k] {
+			return fmt.Errorf("unknown field %q", k)
 		}
-		// Convert from epoch to time.Time
-		var ts time.Time
-		if epoch.Timestamp != nil {
-			ts, err = client.EpochToTime(*epoch.Timestamp, epoch.Precision)
-			if err != nil {
-				return err
+	}
+	if k, ok := duplicateJSONKey(m["tags"]); ok {
+		return fmt.Errorf("duplicate tag %q", k)
+	}
+
+	var points []struct {
+		Tags   json.RawMessage `json:"tags"`
+		Fields json.RawMessage `json:"fields"`
+	}
+	if err := json.Unmarshal(m["points"], &points); m["points"] != nil && err != nil {
+		return err
+	}
+	for i, p := range points {
+		if k, ok := duplicateJSONKey(p.Tags); ok {
+			return &PointError{Index: i, Key: k, Reason: "duplicate tag"}
+		} else if k, ok := duplicateJSONKey(p.Fields); ok {
+			return &PointError{Index: i, Key: k, Reason: "duplicate field"}
+		}
+	}
+	return nil
+}
+
+// duplicateJSONKey returns the first key repeated in an encoded JSON object.
+// Values are skipped without being checked.
+func duplicateJSONKey(b json.RawMessage) (string, bool) {
+	i := skipJSONSpace(b, 0)
+	if i == len(b) || b[i] != '{' {
+		return "", false
+	}
+
+	keys := make(map[string]bool)
+	for i = skipJSONSpace(b, i+1); i < len(b) && b[i] != '}'; {
+		end := jsonStringEnd(b, i)
+		if end < 0 {
+			return "", false
+		}
+		var k string
+		if err := json.Unmarshal(b[i:end], &k); err != nil {
+			return "", false
+		}
+		if keys[k] {
+			return k, true
+		}
+		keys[k] = true
+
+		// Skip the colon, the value and the comma before the next key.
+		if i = skipJSONSpace(b, end); i == len(b) || b[i] != ':' {
+			return "", false
+		}
+		i = skipJSONSpace(b, skipJSONValue(b, skipJSONSpace(b, i+1)))
+		if i < len(b) && b[i] == ',' {
+			i = skipJSONSpace(b, i+1)
+		}
+	}
+	return "", false
+}
+
+// skipJSONSpace returns the index of the first non-whitespace byte from i.
+func skipJSONSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n' || b[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// jsonStringEnd returns the index after the JSON string starting at i.
+// Returns -1 if there is no string at i.
+func jsonStringEnd(b []byte, i int) int {
+	if i == len(b) || b[i] != '"' {
+		return -1
+	}
+	for i++; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// skipJSONValue returns the index after the JSON value starting at i.
+func skipJSONValue(b []byte, i int) int {
+	for depth := 0; i < len(b); i++ {
+		switch b[i] {
+		case '"':
+			end := jsonStringEnd(b, i)
+			if end < 0 {
+				return len(b)
+			}
+			i = end - 1
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				return i
+			}
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		case ',':
+			if depth == 0 {
+				return i
 			}
 		}
-		bp.Points = epoch.Points
-		bp.Database = epoch.Database
-		bp.RetentionPolicy = epoch.RetentionPolicy
-		bp.Tags = epoch.Tags
-		bp.Timestamp = ts
-		bp.Precision = epoch.Precision
-		return nil
-	}(); err == nil {
-		return nil
 	}
+	return i
+}
 
-	if err := json.Unmarshal(b, &normal); err != nil {
-		return err
+// NameRules normalize the measurement names of points written to a database
+// so that agents sending the same name in different forms write to a single
+// measurement.
+type NameRules struct {
+	// Convert names to lower case.
+	Lowercase bool `json:"lowercase,omitempty"`
+
+	// Characters allowed in names, as the body of a regular expression
+	// character class such as "a-z0-9_". Other characters are replaced with
+	// an underscore. All characters are allowed if blank.
+	Characters string `json:"characters,omitempty"`
+
+	// Names are truncated to this many characters. Unlimited if zero.
+	MaxLength int `json:"maxLength,omitempty"`
+
+	disallowed *regexp.Regexp // matches characters not in Characters
+}
+
+// NewNameRules returns name rules with the given settings.
+// Returns an error if the allowed characters are not a valid character class.
+func NewNameRules(lowercase bool, characters string, maxLength int) (*NameRules, error) {
+	r := &NameRules{Lowercase: lowercase, Characters: characters, MaxLength: maxLength}
+	if maxLength < 0 {
+		return nil, ErrInvalidNameRules
+	} else if characters != "" {
+		re, err := regexp.Compile("[^" + characters + "]")
+		if err != nil {
+			return nil, ErrInvalidNameRules
+		}
+		r.disallowed = re
 	}
-	normal.Timestamp = client.SetPrecision(normal.Timestamp, normal.Precision)
-	bp.Points = normal.Points
-	bp.Database = normal.Database
-	bp.RetentionPolicy = normal.RetentionPolicy
-	bp.Tags = normal.Tags
-	bp.Timestamp = normal.Timestamp
-	bp.Precision = normal.Precision
+	return r, nil
+}
 
-	return nil
+// Normalize returns the name with the rules applied.
+func (r *NameRules) Normalize(name string) string {
+	if r.Lowercase {
+		name = strings.ToLower(name)
+	}
+	if r.disallowed != nil {
+		name = r.disallowed.ReplaceAllLiteralString(name, "_")
+	}
+	if r.MaxLength > 0 {
+		if a := []rune(name); len(a) > r.MaxLength {
+			name = string(a[:r.MaxLength])
+		}
+	}
+	return name
 }
 
 // NormalizeBatchPoints returns a slice of Points, created by populating individual
 // points within the batch, which do not have timestamps or tags, with the top-level
-// values.
-func NormalizeBatchPoints(bp BatchPoints) ([]Point, error) {
+// values. Empty tag values and non-finite field values are dropped or rejected
+// depending on the parse mode. Measurement names are normalized with rules,
+// if not nil.
+//
+// If the batch increments timestamps, the nth point without a timestamp is
+// given the default timestamp plus n-1 nanoseconds. The increment is added
+// after rounding to the precision so it isn't lost.
+func NormalizeBatchPoints(bp BatchPoints, mode ParseMode, rules *NameRules) ([]Point, error) {
 	points := []Point{}
-	for _, p := range bp.Points {
-		if p.Timestamp.Time().IsZero() {
+	var defaulted int64 // number of points given a default timestamp
+	for i, p := range bp.Points {
+		isDefault := p.Timestamp.Time().IsZero()
+		if isDefault {
 			if bp.Timestamp.IsZero() {
 				p.Timestamp = client.Timestamp(time.Now())
 			} else {
@@ -213,7 +589,12 @@ func NormalizeBatchPoints(bp BatchPoints) ([]Point, error) {
 		if p.Precision == "" && bp.Precision != "" {
 			p.Precision = bp.Precision
 		}
-		p.Timestamp = client.Timestamp(client.SetPrecision(p.Timestamp.Time(), p.Precision))
+		ts := client.SetPrecision(p.Timestamp.Time(), p.Precision)
+		if isDefault && bp.IncrementTimestamps {
+			ts = ts.Add(time.Duration(defaulted))
+			defaulted++
+		}
+		p.Timestamp = client.Timestamp(ts)
 		if len(bp.Tags) > 0 {
 			if p.Tags == nil {
 				p.Tags = make(map[string]string)
@@ -224,6 +605,62 @@ func NormalizeBatchPoints(bp BatchPoints) ([]Point, error) {
 				}
 			}
 		}
+		for _, k := range sortedKeys(p.Tags) {
+			if isReservedKey(k) {
+				if mode == StrictParse {
+					return nil, &PointError{Index: i, Key: k, Reason: "invalid tag key", Err: ErrReservedKey}
+				}
+			} else if p.Tags[k] != "" {
+				continue
+			} else if mode == StrictParse {
+				return nil, &PointError{Index: i, Key: k, Reason: "empty value for tag"}
+			}
+			delete(p.Tags, k)
+		}
+		for _, k := range sortedFieldKeys(p.Fields) {
+			v := p.Fields[k]
+
+			// Blank keys and "time" can't be queried.
+			if isReservedKey(k) {
+				if mode == StrictParse {
+					return nil, &PointError{Index: i, Key: k, Reason: "invalid field key", Err: ErrReservedKey}
+				}
+				delete(p.Fields, k)
+				continue
+			}
+
+			// A field can't share a key with a tag of the same point.
+			if _, ok := p.Tags[k]; ok {
+				if mode == StrictParse {
+					return nil, &PointError{Index: i, Key: k, Reason: "field has the same key as tag"}
+				}
+				delete(p.Fields, k)
+				continue
+			}
+
+			// Objects are histograms mapping bucket bounds to counts.
+			if m, ok := v.(map[string]interface{}); ok {
+				b, err := influxql.ParseBuckets(m)
+				if err == nil {
+					p.Fields[k] = b
+					continue
+				} else if mode == StrictParse {
+					return nil, &PointError{Index: i, Key: k, Reason: "invalid value for field", Err: err}
+				}
+				delete(p.Fields, k)
+				continue
+			}
+
+			if f, ok := v.(float64); !ok || !(math.IsNaN(f) || math.IsInf(f, 0)) {
+				continue
+			} else if mode == StrictParse {
+				return nil, &PointError{Index: i, Key: k, Reason: "invalid value for field", Err: fmt.Errorf("%v", f)}
+			}
+			delete(p.Fields, k)
+		}
+		if rules != nil {
+			p.Name = rules.Normalize(p.Name)
+		}
 		// Need to convert from a client.Point to a influxdb.Point
 		points = append(points, Point{
 			Name:      p.Name,
@@ -236,6 +673,26 @@ func NormalizeBatchPoints(bp BatchPoints) ([]Point, error) {
 	return points, nil
 }
 
+// sortedKeys returns the keys of a tag set in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedFieldKeys returns the keys of a field set in sorted order.
+func sortedFieldKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // ErrAuthorize represents an authorization error.
 type ErrAuthorize struct {
 	text string
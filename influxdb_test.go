@@ -2,9 +2,15 @@ package influxdb_test
 
 import (
 	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/influxdb/influxdb"
+	"github.com/influxdb/influxdb/client"
+	"github.com/influxdb/influxdb/influxql"
 )
 
 // Ensure that data with epoch timestamps can be decoded.
@@ -45,3 +51,146 @@ func TestBatchPoints_Normal(t *testing.T) {
 		t.Errorf("failed to unmarshal nanosecond data: %s", err.Error())
 	}
 }
+
+// Ensure that batch timestamps in float notation are rejected if they are inexact.
+func TestBatchPoints_LossyTimestamp(t *testing.T) {
+	var p influxdb.BatchPoints
+	if err := json.Unmarshal([]byte(`{"database": "foo", "timestamp": 1.4244733039069373e16, "precision": "n"}`), &p); err == nil || !strings.Contains(err.Error(), client.ErrLossyTimestamp.Error()) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// String-encoded epochs keep their precision.
+	if err := json.Unmarshal([]byte(`{"database": "foo", "timestamp": "14244733039069373", "precision": "n"}`), &p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if ns := p.Timestamp.UnixNano(); ns != 14244733039069373 {
+		t.Fatalf("unexpected timestamp: %d", ns)
+	}
+}
+
+// Ensure that tag and field keys repeated within a point are rejected in strict
+// mode and resolved the same way every time otherwise.
+func TestNormalizeBatchPoints_DuplicateKeys(t *testing.T) {
+	for i, tt := range []struct {
+		body string
+		err  string
+	}{
+		{body: `{"database": "foo", "points": [{"name": "cpu", "tags": {"host": "a", "host": "b"}, "fields": {"value": 1}}]}`, err: `point 0: duplicate tag "host"`},
+		{body: `{"database": "foo", "tags": {"host": "a", "host": "b"}, "points": [{"name": "cpu", "fields": {"value": 1}}]}`, err: `duplicate tag "host"`},
+		{body: `{"database": "foo", "points": [{"name": "cpu", "fields": {"value": 1}}, {"name": "cpu", "fields": {"value": 1, "value": 2}}]}`, err: `point 1: duplicate field "value"`},
+		{body: `{"database": "foo", "points": [{"name": "cpu", "tags": {"host": "a", "h\u006fst": "b"}, "fields": {"value": 1}}]}`, err: `point 0: duplicate tag "host"`},
+		{body: `{"database": "foo", "points": [{"name": "cpu", "tags": {"host": "a,\"host\": {"}, "fields": {"value": {"value": [1, {"value": 2}]}, "other": 1}}]}`},
+	} {
+		if err := influxdb.CheckBatchPointsJSON([]byte(tt.body)); errstr(err) != tt.err {
+			t.Errorf("%d. unexpected error: %v", i, err)
+		}
+	}
+
+	// A field with the key of a tag, including a batch tag, is dropped.
+	var bp influxdb.BatchPoints
+	if err := json.Unmarshal([]byte(`{"database": "foo", "tags": {"region": "us"}, "points": [{"name": "cpu", "tags": {"host": "a"}, "fields": {"host": 1, "region": 2, "value": 3}}]}`), &bp); err != nil {
+		t.Fatal(err)
+	}
+	_, err := influxdb.NormalizeBatchPoints(bp, influxdb.StrictParse, nil)
+	if e, ok := err.(*influxdb.PointError); !ok || e.Index != 0 || e.Key != "host" || err.Error() != `point 0: field has the same key as tag "host"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if points, err := influxdb.NormalizeBatchPoints(bp, influxdb.LenientParse, nil); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(points[0].Fields, map[string]interface{}{"value": float64(3)}) {
+		t.Fatalf("unexpected fields: %#v", points[0].Fields)
+	}
+}
+
+// Ensure that points without timestamps are given incrementing timestamps if requested.
+func TestNormalizeBatchPoints_IncrementTimestamps(t *testing.T) {
+	body := `{"database": "foo", "timestamp": 946684800, "precision": "s", "points": [{"name": "cpu", "fields": {"value": 1}}, {"name": "cpu", "timestamp": 946684810, "fields": {"value": 2}}, {"name": "cpu", "fields": {"value": 3}}]}`
+	for i, tt := range []struct {
+		increment string
+		exp       []string
+	}{
+		{increment: ``, exp: []string{"2000-01-01T00:00:00Z", "2000-01-01T00:00:10Z", "2000-01-01T00:00:00Z"}},
+		{increment: `"incrementTimestamps": true, `, exp: []string{"2000-01-01T00:00:00Z", "2000-01-01T00:00:10Z", "2000-01-01T00:00:00.000000001Z"}},
+	} {
+		var bp influxdb.BatchPoints
+		if err := json.Unmarshal([]byte(strings.Replace(body, `{"database"`, `{`+tt.increment+`"database"`, 1)), &bp); err != nil {
+			t.Fatal(err)
+		}
+		points, err := influxdb.NormalizeBatchPoints(bp, influxdb.StrictParse, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, p := range points {
+			if ts := p.Timestamp.UTC().Format(time.RFC3339Nano); ts != tt.exp[j] {
+				t.Errorf("%d. point %d: unexpected timestamp: %s", i, j, ts)
+			}
+		}
+	}
+}
+
+// Ensure that blank and "time" tag and field keys are dropped or rejected.
+func TestNormalizeBatchPoints_ReservedKeys(t *testing.T) {
+	var bp influxdb.BatchPoints
+	if err := json.Unmarshal([]byte(`{"database": "foo", "points": [{"name": "cpu", "tags": {"host": "a", "Time": "b"}, "fields": {"": 1, "value": 2}}]}`), &bp); err != nil {
+		t.Fatal(err)
+	}
+	_, err := influxdb.NormalizeBatchPoints(bp, influxdb.StrictParse, nil)
+	if e, ok := err.(*influxdb.PointError); !ok || e.Key != "Time" || err.Error() != `point 0: invalid tag key "Time": tag and field keys must not be blank or "time"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if points, err := influxdb.NormalizeBatchPoints(bp, influxdb.LenientParse, nil); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(points[0].Tags, map[string]string{"host": "a"}) {
+		t.Fatalf("unexpected tags: %#v", points[0].Tags)
+	} else if !reflect.DeepEqual(points[0].Fields, map[string]interface{}{"value": float64(2)}) {
+		t.Fatalf("unexpected fields: %#v", points[0].Fields)
+	}
+}
+
+// Ensure that measurement names are normalized by name rules.
+func TestNormalizeBatchPoints_NameRules(t *testing.T) {
+	var bp influxdb.BatchPoints
+	if err := json.Unmarshal([]byte(`{"database": "foo", "points": [{"name": "CPU Load", "fields": {"value": 1}}, {"name": "cpu_load.total", "fields": {"value": 1}}]}`), &bp); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := influxdb.NewNameRules(true, "a-z_", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, err := influxdb.NormalizeBatchPoints(bp, influxdb.StrictParse, rules)
+	if err != nil {
+		t.Fatal(err)
+	} else if points[0].Name != "cpu_load" || points[1].Name != "cpu_load" {
+		t.Fatalf("unexpected names: %q, %q", points[0].Name, points[1].Name)
+	}
+
+	if _, err := influxdb.NewNameRules(false, "z-a", 0); err != influxdb.ErrInvalidNameRules {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that object fields are normalized into histograms.
+func TestNormalizeBatchPoints_Histogram(t *testing.T) {
+	var bp influxdb.BatchPoints
+	if err := json.Unmarshal([]byte(`{"database": "foo", "points": [{"name": "req", "fields": {"latency": {"10": 4, "100": 2, "+Inf": 1}}}]}`), &bp); err != nil {
+		t.Fatal(err)
+	}
+	points, err := influxdb.NormalizeBatchPoints(bp, influxdb.StrictParse, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := (influxql.Buckets{10: 4, 100: 2, math.Inf(1): 1}); !reflect.DeepEqual(points[0].Fields["latency"], exp) {
+		t.Fatalf("unexpected histogram: %#v", points[0].Fields["latency"])
+	}
+
+	// Invalid histograms are rejected in strict mode and dropped otherwise.
+	if err := json.Unmarshal([]byte(`{"database": "foo", "points": [{"name": "req", "fields": {"latency": {"10": 1.5}, "value": 1}}]}`), &bp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := influxdb.NormalizeBatchPoints(bp, influxdb.StrictParse, nil); err == nil || err.Error() != `point 0: invalid value for field "latency": invalid histogram count for bound 10: 1.5` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if points, err := influxdb.NormalizeBatchPoints(bp, influxdb.LenientParse, nil); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(points[0].Fields, map[string]interface{}{"value": float64(1)}) {
+		t.Fatalf("unexpected fields: %#v", points[0].Fields)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,10 +20,14 @@ const (
 	Unknown = DataType("")
 	// Number means the data type is an int or float.
 	Number = DataType("number")
+	// Unsigned means the data type is an unsigned 64-bit integer.
+	Unsigned = DataType("unsigned")
 	// Boolean means the data type is a boolean.
 	Boolean = DataType("boolean")
 	// String means the data type is a string of text.
 	String = DataType("string")
+	// Histogram means the data type is a set of histogram buckets.
+	Histogram = DataType("histogram")
 	// Time means the data type is a time.
 	Time = DataType("time")
 	// Duration means the data type is a duration of time.
@@ -35,10 +41,14 @@ func InspectDataType(v interface{}) DataType {
 		return Number
 	case int:
 		return Number
+	case uint64:
+		return Unsigned
 	case bool:
 		return Boolean
 	case string:
 		return String
+	case Buckets:
+		return Histogram
 	case time.Time:
 		return Time
 	case time.Duration:
@@ -57,33 +67,53 @@ type Node interface {
 func (*Query) node()     {}
 func (Statements) node() {}
 
+func (*AlterDataNodeStatement) node()         {}
+func (*AlterDatabaseStatement) node()         {}
+func (*AlterFieldStatement) node()            {}
+func (*AlterMeasurementStatement) node()      {}
 func (*AlterRetentionPolicyStatement) node()  {}
 func (*CreateContinuousQueryStatement) node() {}
 func (*CreateDatabaseStatement) node()        {}
+func (*CreateQueryStatement) node()           {}
+func (*CloneDatabaseStatement) node()         {}
 func (*CreateRetentionPolicyStatement) node() {}
+func (*CreateTokenStatement) node()           {}
 func (*CreateUserStatement) node()            {}
 func (*DeleteStatement) node()                {}
 func (*DropContinuousQueryStatement) node()   {}
 func (*DropDatabaseStatement) node()          {}
 func (*DropMeasurementStatement) node()       {}
+func (*DropQueryStatement) node()             {}
 func (*DropRetentionPolicyStatement) node()   {}
 func (*DropSeriesStatement) node()            {}
+func (*DropShardStatement) node()             {}
+func (*DropTokenStatement) node()             {}
 func (*DropUserStatement) node()              {}
 func (*GrantStatement) node()                 {}
+func (*ResetUsageStatement) node()            {}
 func (*ShowContinuousQueriesStatement) node() {}
+func (*ShowDataNodesStatement) node()         {}
 func (*ShowDatabasesStatement) node()         {}
 func (*ShowFieldKeysStatement) node()         {}
 func (*ShowRetentionPoliciesStatement) node() {}
 func (*ShowMeasurementsStatement) node()      {}
+func (*ShowQueriesStatement) node()           {}
+func (*ShowQuotasStatement) node()            {}
 func (*ShowSeriesStatement) node()            {}
+func (*ShowShardGroupsStatement) node()       {}
+func (*ShowShardsStatement) node()            {}
 func (*ShowTagKeysStatement) node()           {}
 func (*ShowTagValuesStatement) node()         {}
+func (*ShowTokensStatement) node()            {}
+func (*ShowUsageStatement) node()             {}
 func (*ShowUsersStatement) node()             {}
 func (*RevokeStatement) node()                {}
+func (*TruncateShardsStatement) node()        {}
 func (*SelectStatement) node()                {}
 
 func (*BinaryExpr) node()      {}
 func (*BooleanLiteral) node()  {}
+func (*BoundParameter) node()  {}
 func (*Call) node()            {}
 func (*Dimension) node()       {}
 func (Dimensions) node()       {}
@@ -147,29 +177,48 @@ type ExecutionPrivilege struct {
 // ExecutionPrivileges is a list of privileges required to execute a statement.
 type ExecutionPrivileges []ExecutionPrivilege
 
+func (*AlterDataNodeStatement) stmt()         {}
+func (*AlterDatabaseStatement) stmt()         {}
+func (*AlterFieldStatement) stmt()            {}
+func (*AlterMeasurementStatement) stmt()      {}
 func (*AlterRetentionPolicyStatement) stmt()  {}
 func (*CreateContinuousQueryStatement) stmt() {}
 func (*CreateDatabaseStatement) stmt()        {}
+func (*CreateQueryStatement) stmt()           {}
+func (*CloneDatabaseStatement) stmt()         {}
 func (*CreateRetentionPolicyStatement) stmt() {}
+func (*CreateTokenStatement) stmt()           {}
 func (*CreateUserStatement) stmt()            {}
 func (*DeleteStatement) stmt()                {}
 func (*DropContinuousQueryStatement) stmt()   {}
 func (*DropDatabaseStatement) stmt()          {}
 func (*DropMeasurementStatement) stmt()       {}
+func (*DropQueryStatement) stmt()             {}
 func (*DropRetentionPolicyStatement) stmt()   {}
 func (*DropSeriesStatement) stmt()            {}
+func (*DropShardStatement) stmt()             {}
+func (*DropTokenStatement) stmt()             {}
 func (*DropUserStatement) stmt()              {}
 func (*GrantStatement) stmt()                 {}
+func (*ResetUsageStatement) stmt()            {}
 func (*ShowContinuousQueriesStatement) stmt() {}
+func (*ShowDataNodesStatement) stmt()         {}
 func (*ShowDatabasesStatement) stmt()         {}
 func (*ShowFieldKeysStatement) stmt()         {}
 func (*ShowMeasurementsStatement) stmt()      {}
+func (*ShowQueriesStatement) stmt()           {}
+func (*ShowQuotasStatement) stmt()            {}
 func (*ShowRetentionPoliciesStatement) stmt() {}
 func (*ShowSeriesStatement) stmt()            {}
+func (*ShowShardGroupsStatement) stmt()       {}
+func (*ShowShardsStatement) stmt()            {}
 func (*ShowTagKeysStatement) stmt()           {}
 func (*ShowTagValuesStatement) stmt()         {}
+func (*ShowTokensStatement) stmt()            {}
+func (*ShowUsageStatement) stmt()             {}
 func (*ShowUsersStatement) stmt()             {}
 func (*RevokeStatement) stmt()                {}
+func (*TruncateShardsStatement) stmt()        {}
 func (*SelectStatement) stmt()                {}
 
 // Expr represents an expression that can be evaluated to a value.
@@ -180,6 +229,7 @@ type Expr interface {
 
 func (*BinaryExpr) expr()      {}
 func (*BooleanLiteral) expr()  {}
+func (*BoundParameter) expr()  {}
 func (*Call) expr()            {}
 func (*DurationLiteral) expr() {}
 func (*nilLiteral) expr()      {}
@@ -235,13 +285,50 @@ func (a SortFields) String() string {
 type CreateDatabaseStatement struct {
 	// Name of the database to be created.
 	Name string
+
+	// Succeed without changes if the database already exists.
+	IfNotExists bool
+
+	// Should a default retention policy be created with the database?
+	RetentionPolicyCreate bool
+
+	// Duration data written to the retention policy will be retained.
+	// The server default is used if nil.
+	RetentionPolicyDuration *time.Duration
+
+	// Replication factor for data written to the retention policy.
+	// The server default is used if nil.
+	RetentionPolicyReplication *int
+
+	// Name of the retention policy. The server default is used if blank.
+	RetentionPolicyName string
 }
 
 // String returns a string representation of the create database statement.
 func (s *CreateDatabaseStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("CREATE DATABASE ")
+	if s.IfNotExists {
+		_, _ = buf.WriteString("IF NOT EXISTS ")
+	}
 	_, _ = buf.WriteString(s.Name)
+
+	if s.RetentionPolicyCreate {
+		_, _ = buf.WriteString(" WITH")
+		if s.RetentionPolicyDuration != nil {
+			_, _ = buf.WriteString(" DURATION ")
+			_, _ = buf.WriteString(FormatDuration(*s.RetentionPolicyDuration))
+		}
+		if s.RetentionPolicyReplication != nil {
+			_, _ = buf.WriteString(" REPLICATION ")
+			_, _ = buf.WriteString(strconv.Itoa(*s.RetentionPolicyReplication))
+		}
+		if s.RetentionPolicyName != "" {
+			_, _ = buf.WriteString(" NAME ")
+			_, _ = buf.WriteString(s.RetentionPolicyName)
+		}
+	}
+
 	return buf.String()
 }
 
@@ -250,16 +337,59 @@ func (s *CreateDatabaseStatement) RequiredPrivileges() ExecutionPrivileges {
 	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
 }
 
+// CloneDatabaseStatement represents a command to create a database from an
+// existing database.
+type CloneDatabaseStatement struct {
+	// Name of the database to be created.
+	Name string
+
+	// Name of the database to be cloned.
+	Source string
+
+	// Succeed without changes if the database already exists.
+	IfNotExists bool
+
+	// Should the shards of the database be copied?
+	Data bool
+}
+
+// String returns a string representation of the clone database statement.
+func (s *CloneDatabaseStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("CREATE DATABASE ")
+	if s.IfNotExists {
+		_, _ = buf.WriteString("IF NOT EXISTS ")
+	}
+	_, _ = buf.WriteString(s.Name)
+	_, _ = buf.WriteString(" FROM ")
+	_, _ = buf.WriteString(s.Source)
+	if s.Data {
+		_, _ = buf.WriteString(" WITH DATA")
+	}
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege required to execute a CloneDatabaseStatement.
+func (s *CloneDatabaseStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
 // DropDatabaseStatement represents a command to drop a database.
 type DropDatabaseStatement struct {
 	// Name of the database to be dropped.
 	Name string
+
+	// Succeed without changes if the database does not exist.
+	IfExists bool
 }
 
 // String returns a string representation of the drop database statement.
 func (s *DropDatabaseStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("DROP DATABASE ")
+	if s.IfExists {
+		_, _ = buf.WriteString("IF EXISTS ")
+	}
 	_, _ = buf.WriteString(s.Name)
 	return buf.String()
 }
@@ -276,12 +406,18 @@ type DropRetentionPolicyStatement struct {
 
 	// Name of the database to drop the policy from.
 	Database string
+
+	// Succeed without changes if the policy does not exist.
+	IfExists bool
 }
 
 // String returns a string representation of the drop retention policy statement.
 func (s *DropRetentionPolicyStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("DROP RETENTION POLICY ")
+	if s.IfExists {
+		_, _ = buf.WriteString("IF EXISTS ")
+	}
 	_, _ = buf.WriteString(s.Name)
 	_, _ = buf.WriteString(" ON ")
 	_, _ = buf.WriteString(s.Database)
@@ -298,6 +434,9 @@ type CreateUserStatement struct {
 	// Name of the user to be created.
 	Name string
 
+	// Succeed without changes if the user already exists.
+	IfNotExists bool
+
 	// User's password
 	Password string
 
@@ -309,6 +448,9 @@ type CreateUserStatement struct {
 func (s *CreateUserStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("CREATE USER ")
+	if s.IfNotExists {
+		_, _ = buf.WriteString("IF NOT EXISTS ")
+	}
 	_, _ = buf.WriteString(s.Name)
 	_, _ = buf.WriteString(" WITH PASSWORD ")
 	_, _ = buf.WriteString(s.Password)
@@ -330,12 +472,18 @@ func (s *CreateUserStatement) RequiredPrivileges() ExecutionPrivileges {
 type DropUserStatement struct {
 	// Name of the user to drop.
 	Name string
+
+	// Succeed without changes if the user does not exist.
+	IfExists bool
 }
 
 // String returns a string representation of the drop user statement.
 func (s *DropUserStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("DROP USER ")
+	if s.IfExists {
+		_, _ = buf.WriteString("IF EXISTS ")
+	}
 	_, _ = buf.WriteString(s.Name)
 	return buf.String()
 }
@@ -345,6 +493,77 @@ func (s *DropUserStatement) RequiredPrivileges() ExecutionPrivileges {
 	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
 }
 
+// CreateTokenStatement represents a command for creating an API token.
+type CreateTokenStatement struct {
+	// Name of the token to be created.
+	Name string
+
+	// Token has all privileges on the cluster.
+	Admin bool
+
+	// Privileges granted to the token by database name.
+	Privileges map[string]Privilege
+}
+
+// String returns a string representation of the create token statement.
+func (s *CreateTokenStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("CREATE TOKEN ")
+	_, _ = buf.WriteString(s.Name)
+	_, _ = buf.WriteString(" WITH ")
+	if s.Admin {
+		_, _ = buf.WriteString(AllPrivileges.String())
+		return buf.String()
+	}
+
+	names := make([]string, 0, len(s.Privileges))
+	for name := range s.Privileges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i > 0 {
+			_, _ = buf.WriteString(", ")
+		}
+		_, _ = buf.WriteString(s.Privileges[name].String())
+		_, _ = buf.WriteString(" ON ")
+		_, _ = buf.WriteString(name)
+	}
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege(s) required to execute a CreateTokenStatement.
+func (s *CreateTokenStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// DropTokenStatement represents a command for revoking an API token.
+type DropTokenStatement struct {
+	// Name of the token to drop.
+	Name string
+}
+
+// String returns a string representation of the drop token statement.
+func (s *DropTokenStatement) String() string {
+	return "DROP TOKEN " + s.Name
+}
+
+// RequiredPrivileges returns the privilege(s) required to execute a DropTokenStatement.
+func (s *DropTokenStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// ShowTokensStatement represents a command for listing API tokens.
+type ShowTokensStatement struct{}
+
+// String returns a string representation of the ShowTokensStatement.
+func (s *ShowTokensStatement) String() string { return "SHOW TOKENS" }
+
+// RequiredPrivileges returns the privilege(s) required to execute a ShowTokensStatement.
+func (s *ShowTokensStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
 // Privilege is a type of action a user can be granted the right to use.
 type Privilege int
 
@@ -385,6 +604,12 @@ type GrantStatement struct {
 	// Thing to grant privilege on (e.g., a DB).
 	On string
 
+	// Measurements the user may write to in the database. Writes to other
+	// measurements are rejected, or only writes to these if Except is set.
+	// Unrestricted if empty.
+	Measurements []string
+	Except       bool
+
 	// Who to grant the privilege to.
 	User string
 }
@@ -398,6 +623,18 @@ func (s *GrantStatement) String() string {
 		_, _ = buf.WriteString(" ON ")
 		_, _ = buf.WriteString(s.On)
 	}
+	if len(s.Measurements) > 0 {
+		if s.Except {
+			_, _ = buf.WriteString(" NOT")
+		}
+		_, _ = buf.WriteString(" MEASUREMENTS ")
+		for i, name := range s.Measurements {
+			if i > 0 {
+				_, _ = buf.WriteString(", ")
+			}
+			_, _ = buf.WriteString(name)
+		}
+	}
 	_, _ = buf.WriteString(" TO ")
 	_, _ = buf.WriteString(s.User)
 	return buf.String()
@@ -444,6 +681,9 @@ type CreateRetentionPolicyStatement struct {
 	// Name of policy to create.
 	Name string
 
+	// Succeed without changes if the policy already exists.
+	IfNotExists bool
+
 	// Name of database this policy belongs to.
 	Database string
 
@@ -453,6 +693,12 @@ type CreateRetentionPolicyStatement struct {
 	// Replication factor for data written to this policy.
 	Replication int
 
+	// Allow a replication factor above the number of data nodes.
+	Force bool
+
+	// Handling of points written to an existing series and timestamp.
+	Duplicates string
+
 	// Should this policy be set as default for the database?
 	Default bool
 }
@@ -461,6 +707,9 @@ type CreateRetentionPolicyStatement struct {
 func (s *CreateRetentionPolicyStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("CREATE RETENTION POLICY ")
+	if s.IfNotExists {
+		_, _ = buf.WriteString("IF NOT EXISTS ")
+	}
 	_, _ = buf.WriteString(s.Name)
 	_, _ = buf.WriteString(" ON ")
 	_, _ = buf.WriteString(s.Database)
@@ -468,6 +717,13 @@ func (s *CreateRetentionPolicyStatement) String() string {
 	_, _ = buf.WriteString(FormatDuration(s.Duration))
 	_, _ = buf.WriteString(" REPLICATION ")
 	_, _ = buf.WriteString(strconv.Itoa(s.Replication))
+	if s.Force {
+		_, _ = buf.WriteString(" FORCE")
+	}
+	if s.Duplicates != "" {
+		_, _ = buf.WriteString(" DUPLICATES ")
+		_, _ = buf.WriteString(s.Duplicates)
+	}
 	if s.Default {
 		_, _ = buf.WriteString(" DEFAULT")
 	}
@@ -493,8 +749,17 @@ type AlterRetentionPolicyStatement struct {
 	// Replication factor for data written to this policy.
 	Replication *int
 
+	// Allow a replication factor above the number of data nodes.
+	Force bool
+
+	// Handling of points written to an existing series and timestamp.
+	Duplicates *string
+
 	// Should this policy be set as defalut for the database?
 	Default bool
+
+	// New name of the policy.
+	NewName *string
 }
 
 // String returns a string representation of the alter retention policy statement.
@@ -515,10 +780,24 @@ func (s *AlterRetentionPolicyStatement) String() string {
 		_, _ = buf.WriteString(strconv.Itoa(*s.Replication))
 	}
 
+	if s.Force {
+		_, _ = buf.WriteString(" FORCE")
+	}
+
+	if s.Duplicates != nil {
+		_, _ = buf.WriteString(" DUPLICATES ")
+		_, _ = buf.WriteString(*s.Duplicates)
+	}
+
 	if s.Default {
 		_, _ = buf.WriteString(" DEFAULT")
 	}
 
+	if s.NewName != nil {
+		_, _ = buf.WriteString(" RENAME TO ")
+		_, _ = buf.WriteString(*s.NewName)
+	}
+
 	return buf.String()
 }
 
@@ -527,6 +806,180 @@ func (s *AlterRetentionPolicyStatement) RequiredPrivileges() ExecutionPrivileges
 	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
 }
 
+// AlterDatabaseStatement represents a command to rename a database or to
+// change its write limit or measurement name normalization.
+type AlterDatabaseStatement struct {
+	// Name of the database to alter.
+	Name string
+
+	// New name of the database.
+	NewName string
+
+	// Points written per second, or zero to remove the limit. The write limit
+	// is unchanged if nil.
+	WriteLimit *int
+
+	// Points written at once, or zero for the write limit.
+	WriteBurst int
+
+	// Set if the statement changes how measurement names are normalized with
+	// NORMALIZE NAMES. Without any options normalization is removed.
+	NormalizeNames bool
+
+	// Lowercase measurement names.
+	LowercaseNames bool
+
+	// Characters allowed in measurement names as the body of a regular
+	// expression character class. All are allowed if blank.
+	NameCharacters string
+
+	// Maximum length of measurement names. Unlimited if zero.
+	MaxNameLength int
+}
+
+// String returns a string representation of the alter database statement.
+func (s *AlterDatabaseStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("ALTER DATABASE ")
+	_, _ = buf.WriteString(s.Name)
+	if s.WriteLimit != nil {
+		_, _ = buf.WriteString(" WRITE LIMIT ")
+		_, _ = buf.WriteString(strconv.Itoa(*s.WriteLimit))
+		if s.WriteBurst > 0 {
+			_, _ = buf.WriteString(" BURST ")
+			_, _ = buf.WriteString(strconv.Itoa(s.WriteBurst))
+		}
+		return buf.String()
+	}
+	if s.NormalizeNames {
+		_, _ = buf.WriteString(" NORMALIZE NAMES")
+		if s.LowercaseNames {
+			_, _ = buf.WriteString(" LOWERCASE")
+		}
+		if s.NameCharacters != "" {
+			_, _ = buf.WriteString(" CHARACTERS ")
+			_, _ = buf.WriteString(QuoteString(s.NameCharacters))
+		}
+		if s.MaxNameLength > 0 {
+			_, _ = buf.WriteString(" LENGTH ")
+			_, _ = buf.WriteString(strconv.Itoa(s.MaxNameLength))
+		}
+		return buf.String()
+	}
+	_, _ = buf.WriteString(" RENAME TO ")
+	_, _ = buf.WriteString(s.NewName)
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege required to execute an AlterDatabaseStatement.
+func (s *AlterDatabaseStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// AlterFieldStatement represents a command to change the data type of a field.
+type AlterFieldStatement struct {
+	// Name of the field to alter.
+	Name string
+
+	// Name of the measurement the field belongs to.
+	Measurement string
+
+	// Data type the field's values will be converted to, unchanged if blank.
+	Type DataType
+
+	// Metadata values to set by key, unchanged if nil.
+	Metadata map[string]string
+}
+
+// Metadata keys that can be set on measurements and fields.
+const (
+	MetadataUnit        = "unit"
+	MetadataDescription = "description"
+	MetadataDisplayName = "display_name"
+)
+
+// formatMetadataAssignments returns a SET clause for metadata values or labels,
+// sorted by key. Returns an empty string if there are no values.
+func formatMetadataAssignments(m map[string]string) string {
+	if len(m) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	_, _ = buf.WriteString(" SET ")
+	for i, k := range keys {
+		if i > 0 {
+			_, _ = buf.WriteString(", ")
+		}
+		_, _ = buf.WriteString(k)
+		_, _ = buf.WriteString(" = ")
+		_, _ = buf.WriteString(QuoteString(m[k]))
+	}
+	return buf.String()
+}
+
+// String returns a string representation of the alter field statement.
+func (s *AlterFieldStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("ALTER FIELD ")
+	_, _ = buf.WriteString(s.Name)
+	_, _ = buf.WriteString(" ON ")
+	_, _ = buf.WriteString(s.Measurement)
+	if s.Type != "" {
+		_, _ = buf.WriteString(" TO ")
+		_, _ = buf.WriteString(string(s.Type))
+	}
+	_, _ = buf.WriteString(formatMetadataAssignments(s.Metadata))
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege required to execute an AlterFieldStatement.
+func (s *AlterFieldStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// AlterMeasurementStatement represents a command to change how long the points
+// of a measurement are kept.
+type AlterMeasurementStatement struct {
+	// Name of the measurement to alter.
+	Name string
+
+	// Length of time points are kept, unchanged if nil. Zero keeps points for
+	// the duration of their retention policy.
+	TTL *time.Duration
+
+	// Metadata values to set by key, unchanged if nil.
+	Metadata map[string]string
+}
+
+// String returns a string representation of the alter measurement statement.
+func (s *AlterMeasurementStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("ALTER MEASUREMENT ")
+	_, _ = buf.WriteString(s.Name)
+	if s.TTL != nil {
+		_, _ = buf.WriteString(" TTL ")
+		if *s.TTL == 0 {
+			_, _ = buf.WriteString("INF")
+		} else {
+			_, _ = buf.WriteString(FormatDuration(*s.TTL))
+		}
+	}
+	_, _ = buf.WriteString(formatMetadataAssignments(s.Metadata))
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege required to execute an AlterMeasurementStatement.
+func (s *AlterMeasurementStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
 // SelectStatement represents a command for extracting data from the database.
 type SelectStatement struct {
 	// Expressions returned from the selection.
@@ -616,15 +1069,38 @@ func cloneSource(s Source) Source {
 // RewriteWildcards returns the re-written form of the select statement. Any wildcard query
 // fields are replaced with the supplied fields, and any wildcard GROUP BY fields are replaced
 // with the supplied dimensions.
-func (s *SelectStatement) RewriteWildcards(fields Fields, dimensions Dimensions) *SelectStatement {
+//
+// A "*::tag" query field is removed and the supplied dimensions are added to the GROUP BY
+// instead so the tag values are returned with each series. Function calls with a wildcard
+// argument, such as mean(*), are repeated for each of the supplied numeric fields and named
+// after the function and field.
+func (s *SelectStatement) RewriteWildcards(fields Fields, dimensions Dimensions, numeric Fields) *SelectStatement {
 	other := s.Clone()
 
 	// Rewrite all wildcard query fields
+	var groupByTags bool
 	rwFields := make(Fields, 0, len(s.Fields))
 	for _, f := range s.Fields {
-		switch f.Expr.(type) {
+		switch expr := f.Expr.(type) {
 		case *Wildcard:
+			if expr.Type == TAG {
+				groupByTags = true
+				continue
+			}
 			rwFields = append(rwFields, fields...)
+		case *Call:
+			if len(expr.Args) == 0 {
+				rwFields = append(rwFields, f)
+				break
+			} else if _, ok := expr.Args[0].(*Wildcard); !ok {
+				rwFields = append(rwFields, f)
+				break
+			}
+			for _, n := range numeric {
+				call := CloneExpr(expr).(*Call)
+				call.Args[0] = CloneExpr(n.Expr)
+				rwFields = append(rwFields, &Field{Expr: call, Alias: expr.Name + "_" + n.Name()})
+			}
 		default:
 			rwFields = append(rwFields, f)
 		}
@@ -641,6 +1117,15 @@ func (s *SelectStatement) RewriteWildcards(fields Fields, dimensions Dimensions)
 			rwDimensions = append(rwDimensions, d)
 		}
 	}
+
+	// Group by the selected tags that aren't already grouped by.
+	if groupByTags {
+		for _, d := range dimensions {
+			if !rwDimensions.contains(d) {
+				rwDimensions = append(rwDimensions, d)
+			}
+		}
+	}
 	other.Dimensions = rwDimensions
 
 	return other
@@ -734,6 +1219,13 @@ func (s *SelectStatement) HasWildcard() bool {
 		if ok {
 			return true
 		}
+
+		// Check for function calls over all fields.
+		if call, ok := f.Expr.(*Call); ok && len(call.Args) > 0 {
+			if _, ok := call.Args[0].(*Wildcard); ok {
+				return true
+			}
+		}
 	}
 
 	for _, d := range s.Dimensions {
@@ -1155,8 +1647,83 @@ func (s *DropContinuousQueryStatement) RequiredPrivileges() ExecutionPrivileges
 	return ExecutionPrivileges{{Name: "", Privilege: WritePrivilege}}
 }
 
+// CreateQueryStatement represents a command for storing a named query.
+type CreateQueryStatement struct {
+	// Name of the stored query.
+	Name string
+
+	// Database the query is stored in. The query's default database is used
+	// if blank.
+	Database string
+
+	// Query to store. It may reference bound parameters.
+	Source *SelectStatement
+}
+
+// String returns a string representation of the statement.
+func (s *CreateQueryStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("CREATE QUERY ")
+	_, _ = buf.WriteString(s.Name)
+	if s.Database != "" {
+		_, _ = buf.WriteString(" ON ")
+		_, _ = buf.WriteString(s.Database)
+	}
+	_, _ = buf.WriteString(" AS ")
+	_, _ = buf.WriteString(s.Source.String())
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege required to execute a CreateQueryStatement.
+func (s *CreateQueryStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: s.Database, Privilege: WritePrivilege}}
+}
+
+// DropQueryStatement represents a command for removing a stored query.
+type DropQueryStatement struct {
+	// Name of the stored query.
+	Name string
+
+	// Database the query is stored in. The query's default database is used
+	// if blank.
+	Database string
+}
+
+// String returns a string representation of the statement.
+func (s *DropQueryStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("DROP QUERY ")
+	_, _ = buf.WriteString(s.Name)
+	if s.Database != "" {
+		_, _ = buf.WriteString(" ON ")
+		_, _ = buf.WriteString(s.Database)
+	}
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege required to execute a DropQueryStatement.
+func (s *DropQueryStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: s.Database, Privilege: WritePrivilege}}
+}
+
+// ShowQueriesStatement represents a command for listing stored queries.
+type ShowQueriesStatement struct{}
+
+// String returns a string representation of the statement.
+func (s *ShowQueriesStatement) String() string { return "SHOW QUERIES" }
+
+// RequiredPrivileges returns the privilege required to execute a ShowQueriesStatement.
+func (s *ShowQueriesStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
+}
+
 // ShowMeasurementsStatement represents a command for listing measurements.
 type ShowMeasurementsStatement struct {
+	// Operator and name or regular expression of "WITH MEASUREMENT", which
+	// restricts the measurements listed. Measurement is nil if not set.
+	MeasurementOp Token
+	Measurement   Expr // *VarRef for a name or *RegexLiteral
+
 	// An expression evaluated on data point.
 	Condition Expr
 
@@ -1176,6 +1743,16 @@ func (s *ShowMeasurementsStatement) String() string {
 	var buf bytes.Buffer
 	_, _ = buf.WriteString("SHOW MEASUREMENTS")
 
+	if s.Measurement != nil {
+		_, _ = buf.WriteString(" WITH MEASUREMENT ")
+		_, _ = buf.WriteString(s.MeasurementOp.String())
+		_, _ = buf.WriteString(" ")
+		if re, ok := s.Measurement.(*RegexLiteral); ok {
+			_, _ = buf.WriteString("/" + re.Val.String() + "/")
+		} else {
+			_, _ = buf.WriteString(s.Measurement.String())
+		}
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
@@ -1321,6 +1898,19 @@ func (s *ShowTagValuesStatement) String() string {
 		_, _ = buf.WriteString(" FROM ")
 		_, _ = buf.WriteString(s.Source.String())
 	}
+	if len(s.TagKeys) == 1 {
+		_, _ = buf.WriteString(" WITH KEY = ")
+		_, _ = buf.WriteString((&VarRef{Val: s.TagKeys[0]}).String())
+	} else if len(s.TagKeys) > 1 {
+		_, _ = buf.WriteString(" WITH KEY IN (")
+		for i, key := range s.TagKeys {
+			if i > 0 {
+				_, _ = buf.WriteString(", ")
+			}
+			_, _ = buf.WriteString((&VarRef{Val: key}).String())
+		}
+		_, _ = buf.WriteString(")")
+	}
 	if s.Condition != nil {
 		_, _ = buf.WriteString(" WHERE ")
 		_, _ = buf.WriteString(s.Condition.String())
@@ -1345,6 +1935,123 @@ func (s *ShowTagValuesStatement) RequiredPrivileges() ExecutionPrivileges {
 	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
 }
 
+// ShowQuotasStatement represents a command for listing database quotas and their usage.
+type ShowQuotasStatement struct{}
+
+// String returns a string representation of the ShowQuotasStatement.
+func (s *ShowQuotasStatement) String() string { return "SHOW QUOTAS" }
+
+// RequiredPrivileges returns the privilege(s) required to execute a ShowQuotasStatement.
+func (s *ShowQuotasStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// ShowShardsStatement represents a command for listing the shards stored on a data node.
+type ShowShardsStatement struct{}
+
+// String returns a string representation of the ShowShardsStatement.
+func (s *ShowShardsStatement) String() string { return "SHOW SHARDS" }
+
+// RequiredPrivileges returns the privilege(s) required to execute a ShowShardsStatement.
+func (s *ShowShardsStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// ShowDataNodesStatement represents a command for listing the data nodes of
+// the cluster with their status and labels.
+type ShowDataNodesStatement struct{}
+
+// String returns a string representation of the ShowDataNodesStatement.
+func (s *ShowDataNodesStatement) String() string { return "SHOW DATA NODES" }
+
+// RequiredPrivileges returns the privilege(s) required to execute a ShowDataNodesStatement.
+func (s *ShowDataNodesStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// AlterDataNodeStatement represents a command to set the labels of a data
+// node, such as its rack or zone.
+type AlterDataNodeStatement struct {
+	// ID of the data node to alter.
+	ID uint64
+
+	// Label values to set by key. A blank value removes the label.
+	Labels map[string]string
+}
+
+// String returns a string representation of the AlterDataNodeStatement.
+func (s *AlterDataNodeStatement) String() string {
+	return fmt.Sprintf("ALTER DATA NODE %d%s", s.ID, formatMetadataAssignments(s.Labels))
+}
+
+// RequiredPrivileges returns the privilege(s) required to execute an AlterDataNodeStatement.
+func (s *AlterDataNodeStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// ShowShardGroupsStatement represents a command for listing shard groups.
+type ShowShardGroupsStatement struct{}
+
+// String returns a string representation of the ShowShardGroupsStatement.
+func (s *ShowShardGroupsStatement) String() string { return "SHOW SHARD GROUPS" }
+
+// RequiredPrivileges returns the privilege(s) required to execute a ShowShardGroupsStatement.
+func (s *ShowShardGroupsStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// DropShardStatement represents a command for deleting a shard's data.
+type DropShardStatement struct {
+	// ID of the shard to be dropped.
+	ID uint64
+}
+
+// String returns a string representation of the DropShardStatement.
+func (s *DropShardStatement) String() string { return fmt.Sprintf("DROP SHARD %d", s.ID) }
+
+// RequiredPrivileges returns the privilege(s) required to execute a DropShardStatement.
+func (s *DropShardStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// TruncateShardsStatement represents a command for ending the shard groups
+// that span a point in time so that new shard groups start at that time.
+type TruncateShardsStatement struct {
+	Time time.Time
+}
+
+// String returns a string representation of the TruncateShardsStatement.
+func (s *TruncateShardsStatement) String() string {
+	return fmt.Sprintf("TRUNCATE SHARDS '%s'", s.Time.UTC().Format(time.RFC3339Nano))
+}
+
+// RequiredPrivileges returns the privilege(s) required to execute a TruncateShardsStatement.
+func (s *TruncateShardsStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// ShowUsageStatement represents a command for listing metered usage by database and user.
+type ShowUsageStatement struct{}
+
+// String returns a string representation of the ShowUsageStatement.
+func (s *ShowUsageStatement) String() string { return "SHOW USAGE" }
+
+// RequiredPrivileges returns the privilege(s) required to execute a ShowUsageStatement.
+func (s *ShowUsageStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// ResetUsageStatement represents a command for clearing metered usage.
+type ResetUsageStatement struct{}
+
+// String returns a string representation of the ResetUsageStatement.
+func (s *ResetUsageStatement) String() string { return "RESET USAGE" }
+
+// RequiredPrivileges returns the privilege(s) required to execute a ResetUsageStatement.
+func (s *ResetUsageStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
 // ShowUsersStatement represents a command for listing users.
 type ShowUsersStatement struct{}
 
@@ -1446,7 +2153,7 @@ func (f *Field) String() string {
 	if f.Alias == "" {
 		return f.Expr.String()
 	}
-	return fmt.Sprintf("%s AS %s", f.Expr.String(), f.Alias)
+	return fmt.Sprintf("%s AS %s", f.Expr.String(), (&VarRef{Val: f.Alias}).String())
 }
 
 // Dimensions represents a list of dimensions.
@@ -1496,6 +2203,16 @@ func (a Dimensions) Normalize() (time.Duration, []string, error) {
 	return dur, tags, nil
 }
 
+// contains returns true if a dimension with the same expression as d exists.
+func (a Dimensions) contains(d *Dimension) bool {
+	for _, other := range a {
+		if other.String() == d.String() {
+			return true
+		}
+	}
+	return false
+}
+
 // Dimension represents an expression that a select statement is grouped by.
 type Dimension struct {
 	Expr Expr
@@ -1550,7 +2267,16 @@ type VarRef struct {
 }
 
 // String returns a string representation of the variable reference.
-func (r *VarRef) String() string { return r.Val }
+// Qualified names are written as is. Other names are quoted if they can't be
+// parsed as a bare identifier.
+func (r *VarRef) String() string {
+	if isQualifiedIdent(r.Val) {
+		return r.Val
+	} else if IdentNeedsQuotes(r.Val) {
+		return QuoteIdent([]string{r.Val})
+	}
+	return r.Val
+}
 
 // Call represents a function call.
 type Call struct {
@@ -1633,6 +2359,71 @@ type DurationLiteral struct {
 // String returns a string representation of the literal.
 func (l *DurationLiteral) String() string { return FormatDuration(l.Val) }
 
+// BoundParameter represents a placeholder for a value that is supplied when
+// a stored query is executed.
+type BoundParameter struct {
+	Name string
+}
+
+// String returns a string representation of the bound parameter.
+func (p *BoundParameter) String() string { return "$" + p.Name }
+
+// BoundParameters returns the names of the bound parameters in a node, sorted
+// and without duplicates.
+func BoundParameters(node Node) []string {
+	m := make(map[string]struct{})
+	WalkFunc(node, func(n Node) {
+		if p, ok := n.(*BoundParameter); ok {
+			m[p.Name] = struct{}{}
+		}
+	})
+
+	a := make([]string, 0, len(m))
+	for name := range m {
+		a = append(a, name)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// BindParameters replaces the bound parameters in a node with literals of
+// their values. Values are numbers, strings or booleans. Strings are bound the
+// same as quoted strings in a query so dates and times become time literals.
+// Returns an error if a parameter has no value.
+func BindParameters(node Node, params map[string]interface{}) (err error) {
+	RewriteFunc(node, func(n Node) Node {
+		p, ok := n.(*BoundParameter)
+		if !ok || err != nil {
+			return n
+		}
+
+		var expr Expr
+		switch v := params[p.Name].(type) {
+		case float64:
+			expr = &NumberLiteral{Val: v}
+		case int:
+			expr = &NumberLiteral{Val: float64(v)}
+		case int64:
+			expr = &NumberLiteral{Val: float64(v)}
+		case bool:
+			expr = &BooleanLiteral{Val: v}
+		case string:
+			if expr, err = ParseExpr(QuoteString(v)); err != nil {
+				err = fmt.Errorf("invalid value for parameter %s: %s", p, err)
+				return n
+			}
+		case nil:
+			err = fmt.Errorf("missing value for parameter %s", p)
+			return n
+		default:
+			err = fmt.Errorf("invalid value for parameter %s: %v", p, v)
+			return n
+		}
+		return expr
+	})
+	return
+}
+
 // nilLiteral represents a nil literal.
 // This is not available to the query language itself. It's only used internally.
 type nilLiteral struct{}
@@ -1668,11 +2459,22 @@ type RegexLiteral struct {
 // String returns a string representation of the literal.
 func (r *RegexLiteral) String() string { return r.Val.String() }
 
-// Wildcard represents a wild card expression.
-type Wildcard struct{}
+// Wildcard represents a wild card expression. A wildcard in the select list
+// may be limited to fields or tags with "*::field" or "*::tag".
+type Wildcard struct {
+	Type Token // FIELD, TAG or ILLEGAL for all fields
+}
 
 // String returns a string representation of the wildcard.
-func (e *Wildcard) String() string { return "*" }
+func (e *Wildcard) String() string {
+	switch e.Type {
+	case FIELD:
+		return "*::field"
+	case TAG:
+		return "*::tag"
+	}
+	return "*"
+}
 
 // CloneExpr returns a deep copy of the expression.
 func CloneExpr(expr Expr) Expr {
@@ -1684,6 +2486,8 @@ func CloneExpr(expr Expr) Expr {
 		return &BinaryExpr{Op: expr.Op, LHS: CloneExpr(expr.LHS), RHS: CloneExpr(expr.RHS)}
 	case *BooleanLiteral:
 		return &BooleanLiteral{Val: expr.Val}
+	case *BoundParameter:
+		return &BoundParameter{Name: expr.Name}
 	case *Call:
 		args := make([]Expr, len(expr.Args))
 		for i, arg := range expr.Args {
@@ -1705,7 +2509,7 @@ func CloneExpr(expr Expr) Expr {
 	case *VarRef:
 		return &VarRef{Val: expr.Val}
 	case *Wildcard:
-		return &Wildcard{}
+		return &Wildcard{Type: expr.Type}
 	}
 	panic("unreachable")
 }
@@ -1881,7 +2685,9 @@ func Rewrite(r Rewriter, node Node) Node {
 		n.Fields = Rewrite(r, n.Fields).(Fields)
 		n.Dimensions = Rewrite(r, n.Dimensions).(Dimensions)
 		n.Source = Rewrite(r, n.Source).(Source)
-		n.Condition = Rewrite(r, n.Condition).(Expr)
+		if n.Condition != nil {
+			n.Condition = Rewrite(r, n.Condition).(Expr)
+		}
 
 	case Fields:
 		for i, f := range n {
@@ -1935,12 +2741,16 @@ func Eval(expr Expr, m map[string]interface{}) interface{} {
 		return evalBinaryExpr(expr, m)
 	case *BooleanLiteral:
 		return expr.Val
+	case *DurationLiteral:
+		return expr.Val
 	case *NumberLiteral:
 		return expr.Val
 	case *ParenExpr:
 		return Eval(expr.Expr, m)
 	case *StringLiteral:
 		return expr.Val
+	case *RegexLiteral:
+		return expr.Val
 	case *VarRef:
 		return m[expr.Val]
 	default:
@@ -1952,6 +2762,25 @@ func evalBinaryExpr(expr *BinaryExpr, m map[string]interface{}) interface{} {
 	lhs := Eval(expr.LHS, m)
 	rhs := Eval(expr.RHS, m)
 
+	// Durations are compared and combined as nanosecond counts.
+	if d, ok := lhs.(time.Duration); ok {
+		lhs = float64(d)
+	}
+	if d, ok := rhs.(time.Duration); ok {
+		rhs = float64(d)
+	}
+
+	// Compare unsigned integers exactly. All other math is done with floats.
+	if v, ok := evalUnsignedComparison(expr.Op, lhs, rhs); ok {
+		return v
+	}
+	if u, ok := lhs.(uint64); ok {
+		lhs = float64(u)
+	}
+	if u, ok := rhs.(uint64); ok {
+		rhs = float64(u)
+	}
+
 	// Evaluate if both sides are simple types.
 	switch lhs := lhs.(type) {
 	case bool:
@@ -1990,6 +2819,15 @@ func evalBinaryExpr(expr *BinaryExpr, m map[string]interface{}) interface{} {
 			return lhs / rhs
 		}
 	case string:
+		if re, ok := rhs.(*regexp.Regexp); ok {
+			switch expr.Op {
+			case EQREGEX:
+				return matchRegex(re, lhs)
+			case NEQREGEX:
+				return !matchRegex(re, lhs)
+			}
+			return nil
+		}
 		rhs, _ := rhs.(string)
 		switch expr.Op {
 		case EQ:
@@ -2001,6 +2839,122 @@ func evalBinaryExpr(expr *BinaryExpr, m map[string]interface{}) interface{} {
 	return nil
 }
 
+// matchRegex returns true if s matches re.
+//
+// Case-insensitive searches for plain text, such as /(?i)error/, or for
+// text at the start of the value, such as /(?i)^warn/, are common when
+// searching log-like string fields. They are matched by comparing the text
+// directly instead of running the regular expression.
+func matchRegex(re *regexp.Regexp, s string) bool {
+	text, prefix, ok := foldedText(re.String())
+	if !ok || !isASCII(s) {
+		return re.MatchString(s)
+	} else if prefix {
+		return len(s) >= len(text) && strings.EqualFold(s[:len(text)], text)
+	}
+	for i := 0; i+len(text) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(text)], text) {
+			return true
+		}
+	}
+	return false
+}
+
+// foldedText returns the text searched for by a case-insensitive pattern
+// made of ASCII text with no special characters other than a leading "^".
+// Returns false if the pattern is anything else.
+func foldedText(pattern string) (text string, prefix bool, ok bool) {
+	if !strings.HasPrefix(pattern, "(?i)") {
+		return "", false, false
+	}
+	text = strings.TrimPrefix(pattern, "(?i)")
+	if strings.HasPrefix(text, "^") {
+		text, prefix = text[1:], true
+	}
+	if text == "" || !isASCII(text) || regexp.QuoteMeta(text) != text {
+		return "", false, false
+	}
+	return text, prefix, true
+}
+
+// isASCII returns true if s only contains ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// evalUnsignedComparison compares two values when either one is an unsigned integer.
+// Returns false if op is not a comparison or the values cannot be compared.
+func evalUnsignedComparison(op Token, lhs, rhs interface{}) (v bool, ok bool) {
+	var cmp int
+	if u, isUnsigned := lhs.(uint64); isUnsigned {
+		cmp, ok = compareUnsigned(u, rhs)
+	} else if u, isUnsigned := rhs.(uint64); isUnsigned {
+		cmp, ok = compareUnsigned(u, lhs)
+		cmp = -cmp
+	}
+	if !ok {
+		return false, false
+	}
+
+	switch op {
+	case EQ:
+		return cmp == 0, true
+	case NEQ:
+		return cmp != 0, true
+	case LT:
+		return cmp < 0, true
+	case LTE:
+		return cmp <= 0, true
+	case GT:
+		return cmp > 0, true
+	case GTE:
+		return cmp >= 0, true
+	}
+	return false, false
+}
+
+// compareUnsigned compares an unsigned integer to a number without losing precision.
+// Returns -1, 0, or 1 if u is less than, equal to, or greater than v.
+// Returns false if v is not a number.
+func compareUnsigned(u uint64, v interface{}) (int, bool) {
+	var other uint64
+	switch v := v.(type) {
+	case uint64:
+		other = v
+	case float64:
+		if math.IsNaN(v) {
+			return 0, false
+		} else if v < 0 {
+			return 1, true
+		} else if v >= math.MaxUint64 {
+			return -1, true
+		}
+
+		// If v has a fractional part then u can never equal it.
+		other = uint64(v)
+		if float64(other) != v {
+			if u <= other {
+				return -1, true
+			}
+			return 1, true
+		}
+	default:
+		return 0, false
+	}
+
+	if u < other {
+		return -1, true
+	} else if u > other {
+		return 1, true
+	}
+	return 0, true
+}
+
 // Reduce evaluates expr using the available values in valuer.
 // References that don't exist in valuer are ignored.
 func Reduce(expr Expr, valuer Valuer) Expr {
@@ -17,6 +17,8 @@ func TestInspectDataType(t *testing.T) {
 		typ influxql.DataType
 	}{
 		{float64(100), influxql.Number},
+		{uint64(100), influxql.Unsigned},
+		{10 * time.Millisecond, influxql.Duration},
 	} {
 		if typ := influxql.InspectDataType(tt.v); tt.typ != typ {
 			t.Errorf("%d. %v (%s): unexpected type: %s", i, tt.v, tt.typ, typ)
@@ -232,6 +234,16 @@ func TestSelectStatement_HasWildcard(t *testing.T) {
 			wildcard: true,
 		},
 
+		// Typed and function call wildcards
+		{
+			stmt:     `SELECT *::tag FROM cpu`,
+			wildcard: true,
+		},
+		{
+			stmt:     `SELECT count(*) FROM cpu`,
+			wildcard: true,
+		},
+
 		// No GROUP BY wildcards
 		{
 			stmt:     `SELECT value FROM cpu GROUP BY host`,
@@ -372,6 +384,24 @@ func TestSelectStatement_RewriteWildcards(t *testing.T) {
 			stmt:    `SELECT * FROM cpu GROUP BY *`,
 			rewrite: `SELECT value1, value2 FROM cpu GROUP BY host, region`,
 		},
+
+		// Field wildcard
+		{
+			stmt:    `SELECT *::field FROM cpu`,
+			rewrite: `SELECT value1, value2 FROM cpu`,
+		},
+
+		// Tag wildcard
+		{
+			stmt:    `SELECT *::tag, *::field FROM cpu GROUP BY time(1m), host`,
+			rewrite: `SELECT value1, value2 FROM cpu GROUP BY time(1m), host, region`,
+		},
+
+		// Function call wildcard
+		{
+			stmt:    `SELECT mean(*), percentile(*, 90) FROM cpu GROUP BY time(1m)`,
+			rewrite: `SELECT mean(value1) AS mean_value1, mean(value2) AS mean_value2, percentile(value1, 90.000) AS percentile_value1, percentile(value2, 90.000) AS percentile_value2 FROM cpu GROUP BY time(1m)`,
+		},
 	}
 
 	for i, tt := range tests {
@@ -382,7 +412,7 @@ func TestSelectStatement_RewriteWildcards(t *testing.T) {
 		}
 
 		// Rewrite statement.
-		rw := stmt.(*influxql.SelectStatement).RewriteWildcards(fields, dimensions)
+		rw := stmt.(*influxql.SelectStatement).RewriteWildcards(fields, dimensions, fields)
 		if rw == nil {
 			t.Errorf("%d. %q: unexpected nil statement", i, tt.stmt)
 			continue
@@ -506,6 +536,56 @@ func TestRewrite(t *testing.T) {
 	}
 }
 
+// Ensure bound parameters can be replaced with literals.
+func TestBindParameters(t *testing.T) {
+	for i, tt := range []struct {
+		s      string
+		params map[string]interface{}
+		out    string
+		err    string
+	}{
+		{
+			s:      `SELECT mean(value) FROM cpu WHERE host = $host AND time > $start GROUP BY time(10m)`,
+			params: map[string]interface{}{"host": "serverA", "start": "2000-01-01T00:00:00Z"},
+			out:    `SELECT mean(value) FROM cpu WHERE host = 'serverA' AND time > "2000-01-01 00:00:00" GROUP BY time(10m)`,
+		},
+		{
+			s:      `SELECT value * $scale FROM cpu WHERE enabled = $enabled`,
+			params: map[string]interface{}{"scale": float64(2), "enabled": true},
+			out:    `SELECT value * 2.000 FROM cpu WHERE enabled = true`,
+		},
+		{
+			s:      `SELECT value * $scale FROM cpu`,
+			params: map[string]interface{}{"scale": float64(2)},
+			out:    `SELECT value * 2.000 FROM cpu`,
+		},
+		{
+			s:      `SELECT value FROM cpu WHERE host = $host OR region = $region`,
+			params: map[string]interface{}{"host": "serverA"},
+			err:    `missing value for parameter $region`,
+		},
+		{
+			s:      `SELECT value FROM cpu WHERE host = $host`,
+			params: map[string]interface{}{"host": []interface{}{"serverA"}},
+			err:    `invalid value for parameter $host: [serverA]`,
+		},
+	} {
+		stmt := MustParseSelectStatement(tt.s)
+		if names := influxql.BoundParameters(stmt); len(names) == 0 {
+			t.Errorf("%d. %s: expected bound parameters", i, tt.s)
+		}
+
+		err := influxql.BindParameters(stmt, tt.params)
+		if errstring(err) != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n\nexp=%s\n\ngot=%v\n\n", i, tt.s, tt.err, err)
+		} else if tt.err == "" && stmt.String() != tt.out {
+			t.Errorf("%d. %s: statement mismatch:\n\nexp=%s\n\ngot=%s\n\n", i, tt.s, tt.out, stmt.String())
+		} else if tt.err == "" && len(influxql.BoundParameters(stmt)) != 0 {
+			t.Errorf("%d. %s: unexpected bound parameters: %v", i, tt.s, influxql.BoundParameters(stmt))
+		}
+	}
+}
+
 // Ensure an expression can be reduced.
 func TestEval(t *testing.T) {
 	for i, tt := range []struct {
@@ -538,6 +618,28 @@ func TestEval(t *testing.T) {
 		{in: `foo = 'bar'`, out: true, data: map[string]interface{}{"foo": "bar"}},
 		{in: `foo = 'bar'`, out: nil, data: map[string]interface{}{"foo": nil}},
 		{in: `foo <> 'bar'`, out: true, data: map[string]interface{}{"foo": "xxx"}},
+
+		// Regular expressions.
+		{in: `foo =~ /b.r/`, out: true, data: map[string]interface{}{"foo": "bar"}},
+		{in: `foo !~ /b.r/`, out: false, data: map[string]interface{}{"foo": "bar"}},
+		{in: `foo =~ /(?i)error/`, out: true, data: map[string]interface{}{"foo": "disk ERROR on sda"}},
+		{in: `foo =~ /(?i)error/`, out: false, data: map[string]interface{}{"foo": "disk err"}},
+		{in: `foo =~ /(?i)^warn/`, out: true, data: map[string]interface{}{"foo": "Warning: low memory"}},
+		{in: `foo =~ /(?i)^warn/`, out: false, data: map[string]interface{}{"foo": "no warnings"}},
+		{in: `foo =~ /(?i)k/`, out: true, data: map[string]interface{}{"foo": "300\u212a"}},
+		{in: `foo =~ /(?i)error/`, out: nil, data: map[string]interface{}{"foo": float64(1)}},
+
+		// Unsigned integers.
+		{in: `foo > 9007199254740992`, out: true, data: map[string]interface{}{"foo": uint64(9007199254740993)}},
+		{in: `foo = bar`, out: false, data: map[string]interface{}{"foo": uint64(9007199254740993), "bar": uint64(9007199254740992)}},
+		{in: `foo < 2.5`, out: true, data: map[string]interface{}{"foo": uint64(2)}},
+		{in: `foo > -1`, out: true, data: map[string]interface{}{"foo": uint64(0)}},
+		{in: `10 < foo`, out: true, data: map[string]interface{}{"foo": uint64(11)}},
+		{in: `foo * 2`, out: float64(6), data: map[string]interface{}{"foo": uint64(3)}},
+
+		// Durations.
+		{in: `foo > 10ms`, out: true, data: map[string]interface{}{"foo": 20 * time.Millisecond}},
+		{in: `foo = 1500000`, out: true, data: map[string]interface{}{"foo": 1500 * time.Microsecond}},
 	} {
 		// Evaluate expression.
 		out := influxql.Eval(MustParseExpr(tt.in), tt.data)
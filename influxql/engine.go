@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"hash/fnv"
 	"math"
+	"math/rand"
+	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -57,6 +60,20 @@ type Planner struct {
 
 	// Returns the current time. Defaults to time.Now().
 	Now func() time.Time
+
+	// Maximum number of bytes a single query can buffer. Unlimited if zero.
+	MaxQueryMemory int64
+
+	// Memory pool shared by all running queries. Optional.
+	MemoryPool *MemoryPool
+
+	// Directory where queries over their memory limit write buffered rows
+	// instead of failing. Queries fail with the limit error if blank.
+	SpillDir string
+
+	// Closed to stop queries before they complete. The rows buffered so far
+	// are returned followed by an ErrQueryInterrupted row. Optional.
+	Interrupt <-chan struct{}
 }
 
 // NewPlanner returns a new instance of Planner.
@@ -84,6 +101,9 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 
 	// Create the executor.
 	e := newExecutor(tx, stmt)
+	e.mem = &memoryAccount{max: p.MaxQueryMemory, pool: p.MemoryPool}
+	e.spillDir = p.SpillDir
+	e.interrupt = p.Interrupt
 
 	// Determine group by tag keys.
 	interval, tags, err := stmt.Dimensions.Normalize()
@@ -161,7 +181,7 @@ func (p *Planner) planRawQuery(e *Executor, v *VarRef) (Processor, error) {
 	// Create mapper and reducer.
 	mappers := make([]*Mapper, len(itrs))
 	for i, itr := range itrs {
-		mappers[i] = NewMapper(MapRawQuery, itr, e.interval)
+		mappers[i] = NewMapper(MapRawQuery, e.interruptible(itr), e.interval)
 	}
 	r := NewReducer(ReduceRawQuery, mappers)
 	r.name = lastIdent(stmt.Source.(*Measurement).Name)
@@ -174,12 +194,19 @@ func (p *Planner) planRawQuery(e *Executor, v *VarRef) (Processor, error) {
 // planCall generates a processor for a function call.
 func (p *Planner) planCall(e *Executor, c *Call) (Processor, error) {
 	// Ensure there is a single argument.
-	if c.Name == "percentile" {
+	switch c.Name {
+	case "percentile", "sample", "histogram_percentile":
 		if len(c.Args) != 2 {
-			return nil, fmt.Errorf("expected two arguments for percentile()")
+			return nil, fmt.Errorf("expected two arguments for %s()", c.Name)
+		}
+	case "elapsed":
+		if len(c.Args) != 1 && len(c.Args) != 2 {
+			return nil, fmt.Errorf("expected one or two arguments for elapsed()")
+		}
+	default:
+		if len(c.Args) != 1 {
+			return nil, fmt.Errorf("expected one argument for %s()", c.Name)
 		}
-	} else if len(c.Args) != 1 {
-		return nil, fmt.Errorf("expected one argument for %s()", c.Name)
 	}
 
 	// Ensure the argument is a variable reference.
@@ -228,6 +255,32 @@ func (p *Planner) planCall(e *Executor, c *Call) (Processor, error) {
 			return nil, fmt.Errorf("expected float argument in percentile()")
 		}
 		mapFn, reduceFn = MapEcho, ReducePercentile(lit.Val)
+	case "sample":
+		lit, ok := c.Args[1].(*NumberLiteral)
+		if !ok || lit.Val < 1 || lit.Val != math.Trunc(lit.Val) {
+			return nil, fmt.Errorf("expected positive integer argument in sample()")
+		}
+		mapFn, reduceFn = MapSample(int(lit.Val)), ReduceSample(int(lit.Val))
+	case "elapsed":
+		unit := time.Nanosecond
+		if len(c.Args) == 2 {
+			lit, ok := c.Args[1].(*DurationLiteral)
+			if !ok || lit.Val <= 0 {
+				return nil, fmt.Errorf("expected duration argument in elapsed()")
+			}
+			unit = lit.Val
+		}
+		mapFn, reduceFn = MapPoints, ReduceElapsed(unit)
+	case "difference":
+		mapFn, reduceFn = MapPoints, ReduceDifference
+	case "histogram":
+		mapFn, reduceFn = MapHistogram, ReduceHistogram
+	case "histogram_percentile":
+		lit, ok := c.Args[1].(*NumberLiteral)
+		if !ok || lit.Val < 0 || lit.Val > 100 {
+			return nil, fmt.Errorf("expected percentile between 0 and 100 in histogram_percentile()")
+		}
+		mapFn, reduceFn = MapHistogram, ReduceHistogramPercentile(lit.Val)
 	default:
 		return nil, fmt.Errorf("function not found: %q", c.Name)
 	}
@@ -235,7 +288,7 @@ func (p *Planner) planCall(e *Executor, c *Call) (Processor, error) {
 	// Create mapper and reducer.
 	mappers := make([]*Mapper, len(itrs))
 	for i, itr := range itrs {
-		mappers[i] = NewMapper(mapFn, itr, e.interval)
+		mappers[i] = NewMapper(mapFn, e.interruptible(itr), e.interval)
 	}
 	r := NewReducer(reduceFn, mappers)
 	r.name = lastIdent(stmt.Source.(*Measurement).Name)
@@ -268,8 +321,12 @@ type Executor struct {
 	tx         Tx               // transaction
 	stmt       *SelectStatement // original statement
 	processors []Processor      // per-field processors
+	interrupt  <-chan struct{}  // closed to stop the query early
 	interval   time.Duration    // group by interval
 	tags       []string         // dimensional tag keys
+	mem        *memoryAccount   // buffered memory accounting
+	spillDir   string           // directory for spilled rows, spilling disabled if blank
+	runs       []*os.File       // sorted runs of spilled rows
 }
 
 // newExecutor returns an executor associated with a transaction and statement.
@@ -301,9 +358,6 @@ func (e *Executor) Execute() (<-chan *Row, error) {
 
 // execute runs in a separate separate goroutine and streams data from processors.
 func (e *Executor) execute(out chan *Row) {
-	// Ensure the transaction closes after execution.
-	defer e.tx.Close()
-
 	// TODO: Support multi-value rows.
 
 	// Initialize map of rows by encoded tagset.
@@ -315,28 +369,74 @@ func (e *Executor) execute(out chan *Row) {
 		fieldIDs, _ = e.tx.FieldIDs(e.stmt.Fields)
 	}
 
-	// Combine values from each processor.
-loop:
-	for {
+	// Literal processors never close so only field processors are read.
+	open := make([]bool, len(e.processors))
+	for i, p := range e.processors {
+		_, literal := p.(*literalProcessor)
+		open[i] = !literal
+	}
+
+	// Combine values from each processor until all of them are exhausted.
+	// Fields may have values for different intervals so processors are read
+	// independently and their values merged by time once they are done.
+	for remaining := countTrue(open); remaining > 0; {
 		// Retrieve values from processors and write them to the approprite
 		// row based on their tagset.
 		for i, p := range e.processors {
+			if !open[i] {
+				continue
+			}
+
 			// Retrieve data from the processor.
-			m, ok := <-p.C()
+			var m map[Key]interface{}
+			var ok bool
+			select {
+			case m, ok = <-p.C():
+			case <-e.interrupt:
+			}
+
+			// Values read after an interrupt may come from a partial scan
+			// so only the rows buffered before it are returned.
+			if e.interrupted() {
+				e.drain()
+				e.flush(rows, isRaw, out, ErrQueryInterrupted)
+				return
+			}
+
 			if !ok {
-				break loop
+				open[i] = false
+				remaining--
+				continue
 			}
 
 			// Set values on returned row.
 			for k, v := range m {
-				// Lookup row values and populate data.
-				row, values := e.createRowValuesIfNotExists(rows, e.processors[0].Name(), k.Timestamp, k.Values)
+				// Decode and account for the values before they are added
+				// as buffered rows may be spilled to make room for them.
+				var vals [][]interface{}
+				var n int64
 				if isRaw {
 					vv := v.([]*rawQueryMapOutput)
-					vals := make([][]interface{}, len(vv))
+					vals = make([][]interface{}, len(vv))
 					for i, val := range vv {
 						vals[i] = e.tx.DecodeValues(fieldIDs, val.timestamp, val.data)
+						n += sizeOfValues(vals[i])
 					}
+				} else {
+					n = sizeOfValue(v)
+				}
+				if _, err := e.reserve(rows, n); err != nil {
+					e.abort(out, err)
+					return
+				}
+
+				// Lookup row values and populate data.
+				row, values, err := e.createRowValuesIfNotExists(rows, e.processors[0].Name(), k.Timestamp, k.Values)
+				if err != nil {
+					e.abort(out, err)
+					return
+				}
+				if isRaw {
 					row.Values = vals
 				} else {
 					values[i+1] = v
@@ -345,65 +445,185 @@ loop:
 		}
 	}
 
-	// Normalize rows and values.
-	// Convert all times to timestamps
-	a := make(Rows, 0, len(rows))
-	for _, row := range rows {
-		for _, values := range row.Values {
-			t := time.Unix(0, values[0].(int64))
-			values[0] = t.UTC()
+	// All iterators have been read so the transaction can be closed. The
+	// rows are incomplete if any points could not be read.
+	if err := e.tx.Close(); err != nil {
+		e.abort(out, err)
+		return
+	}
+
+	e.flush(rows, isRaw, out, nil)
+}
+
+// flush sends the buffered rows, merged with any spilled rows, in sorted
+// order. If err is set then it is sent after the rows. The output channel
+// is closed when done.
+func (e *Executor) flush(rows map[string]*Row, isRaw bool, out chan *Row, err error) {
+	defer e.close(out)
+
+	// Merge the buffered rows with the spilled rows if the query spilled.
+	if len(e.runs) > 0 {
+		if err := e.mergeRuns(rows, isRaw, out); err != nil {
+			out <- &Row{Err: err}
+			return
+		}
+	} else {
+		// Normalize rows and values.
+		a := make(Rows, 0, len(rows))
+		for _, row := range rows {
+			a = append(a, normalizeRow(row, isRaw))
+		}
+		sort.Sort(a)
+
+		// Send rows to the channel.
+		for _, row := range a {
+			out <- row
+		}
+	}
+
+	if err != nil {
+		out <- &Row{Err: err}
+	}
+}
+
+// countTrue returns the number of true values in a.
+func countTrue(a []bool) int {
+	var n int
+	for _, v := range a {
+		if v {
+			n++
 		}
-		a = append(a, row)
 	}
-	sort.Sort(a)
+	return n
+}
 
-	// Send rows to the channel.
-	for _, row := range a {
-		out <- row
+// interrupted returns true if the query has been interrupted.
+func (e *Executor) interrupted() bool {
+	select {
+	case <-e.interrupt:
+		return true
+	default:
+		return false
 	}
+}
 
-	// Mark the end of the output channel.
+// interruptible returns an iterator that ends early once the query is
+// interrupted so its mapper stops scanning.
+func (e *Executor) interruptible(itr Iterator) Iterator {
+	if e.interrupt == nil {
+		return itr
+	}
+	return &interruptIterator{Iterator: itr, interrupt: e.interrupt}
+}
+
+// abort sends an error row and closes the output channel.
+func (e *Executor) abort(out chan *Row, err error) {
+	e.drain()
+	out <- &Row{Err: err}
+	e.close(out)
+}
+
+// close returns the buffered memory to the pool and removes the spilled runs
+// before closing the output channel so they are freed once it is drained.
+func (e *Executor) close(out chan *Row) {
+	e.mem.release()
+	e.removeRuns()
 	close(out)
 }
 
+// drain discards the remaining processor output in the background so the
+// mappers can exit. The transaction is closed once they stop reading from it.
+func (e *Executor) drain() {
+	go func() {
+		for _, p := range e.processors {
+			if _, ok := p.(*literalProcessor); ok {
+				continue
+			}
+			for _ = range p.C() {
+			}
+		}
+		_ = e.tx.Close()
+	}()
+}
+
+// interruptIterator ends an iterator once a query is interrupted.
+type interruptIterator struct {
+	Iterator
+	interrupt <-chan struct{}
+}
+
+// Next returns the next point from the underlying iterator or the end of
+// the iterator if the query has been interrupted.
+func (i *interruptIterator) Next() (key int64, data []byte, value interface{}) {
+	select {
+	case <-i.interrupt:
+		return 0, nil, nil
+	default:
+		return i.Iterator.Next()
+	}
+}
+
 // creates a new value set if one does not already exist for a given tagset + timestamp.
-func (e *Executor) createRowValuesIfNotExists(rows map[string]*Row, name string, timestamp int64, tagset string) (*Row, []interface{}) {
+func (e *Executor) createRowValuesIfNotExists(rows map[string]*Row, name string, timestamp int64, tagset string) (*Row, []interface{}, error) {
 	// TODO: Add "name" to lookup key.
 
-	// Find row by tagset.
-	var row *Row
-	if row = rows[tagset]; row == nil {
-		row = &Row{Name: name}
+	// Return the last value set if it matches the timestamp.
+	row := rows[tagset]
+	if row != nil && len(row.Values) > 0 && row.Values[len(row.Values)-1][0] == timestamp {
+		return row, row.Values[len(row.Values)-1], nil
+	}
 
-		// Create tag map.
-		row.Tags = make(map[string]string)
-		for i, v := range UnmarshalStrings([]byte(tagset)) {
-			row.Tags[e.tags[i]] = v
-		}
+	// Otherwise create a new value set, and a new row if the tagset is new.
+	values := make([]interface{}, len(e.processors)+1)
+	values[0] = timestamp
+	n := sizeOfValues(values)
+	existing := row != nil
+	if !existing {
+		row = e.newRow(name, tagset)
+		n += row.size()
+	}
 
-		// Create column names.
-		row.Columns = make([]string, 1, len(e.stmt.Fields)+1)
-		row.Columns[0] = "time"
-		for i, f := range e.stmt.Fields {
-			name := f.Name()
-			if name == "" {
-				name = fmt.Sprintf("col%d", i)
-			}
-			row.Columns = append(row.Columns, name)
+	// Account for the row and values before saving them.
+	spilled, err := e.reserve(rows, n)
+	if err != nil {
+		return nil, nil, err
+	} else if spilled && existing {
+		// The row was written to disk so it is started again.
+		row = e.newRow(name, tagset)
+		if _, err := e.reserve(rows, row.size()); err != nil {
+			return nil, nil, err
 		}
+	}
+
+	// Save to lookup.
+	rows[tagset] = row
+	row.Values = append(row.Values, values)
+
+	return row, values, nil
+}
 
-		// Save to lookup.
-		rows[tagset] = row
+// newRow returns an empty row for an encoded tagset.
+func (e *Executor) newRow(name string, tagset string) *Row {
+	row := &Row{Name: name}
+
+	// Create tag map.
+	row.Tags = make(map[string]string)
+	for i, v := range UnmarshalStrings([]byte(tagset)) {
+		row.Tags[e.tags[i]] = v
 	}
 
-	// If no values exist or last value doesn't match the timestamp then create new.
-	if len(row.Values) == 0 || row.Values[len(row.Values)-1][0] != timestamp {
-		values := make([]interface{}, len(e.processors)+1)
-		values[0] = timestamp
-		row.Values = append(row.Values, values)
+	// Create column names.
+	row.Columns = make([]string, 1, len(e.stmt.Fields)+1)
+	row.Columns[0] = "time"
+	for i, f := range e.stmt.Fields {
+		name := f.Name()
+		if name == "" {
+			name = fmt.Sprintf("col%d", i)
+		}
+		row.Columns = append(row.Columns, name)
 	}
 
-	return row, row.Values[len(row.Values)-1]
+	return row
 }
 
 // Mapper represents an object for processing iterators.
@@ -522,14 +742,66 @@ func MapCount(itr Iterator, e *Emitter, tmin int64) {
 }
 
 // MapSum computes the summation of values in an iterator.
+// See addSum() for the handling of unsigned values.
 func MapSum(itr Iterator, e *Emitter, tmin int64) {
-	n := float64(0)
+	var n interface{}
 	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
-		n += v.(float64)
+		n = addSum(n, v)
+	}
+	if n == nil {
+		n = float64(0)
 	}
 	e.Emit(Key{tmin, itr.Tags()}, n)
 }
 
+// addSum adds a value to a running sum. A nil sum is treated as empty.
+//
+// Unsigned values are summed as unsigned integers so that large counters do
+// not lose precision, and durations are summed as durations. If the sum
+// overflows, or if values of different types are mixed, then the sum is
+// promoted to a float64 instead of wrapping.
+func addSum(sum, v interface{}) interface{} {
+	if sum == nil {
+		switch v := v.(type) {
+		case uint64, time.Duration:
+			return v
+		}
+		return float64Value(v)
+	}
+
+	switch a := sum.(type) {
+	case uint64:
+		if b, ok := v.(uint64); ok && a <= math.MaxUint64-b {
+			return a + b
+		}
+	case time.Duration:
+		if b, ok := v.(time.Duration); ok {
+			if c := a + b; (c > a) == (b > 0) {
+				return c
+			}
+		}
+	}
+	return float64Value(sum) + float64Value(v)
+}
+
+// float64Value returns a numeric value as a float64. Durations are returned
+// as nanosecond counts. Booleans are returned as 1 for true and 0 for false
+// so sum() counts true values and mean() returns the fraction that are true.
+func float64Value(v interface{}) float64 {
+	switch v := v.(type) {
+	case uint64:
+		return float64(v)
+	case time.Duration:
+		return float64(v)
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	return v.(float64)
+}
+
 // Processor represents an object for joining reducer output.
 type Processor interface {
 	Process()
@@ -683,9 +955,12 @@ type ReduceFunc func(Key, []interface{}, *Emitter)
 
 // ReduceSum computes the sum of values for each key.
 func ReduceSum(key Key, values []interface{}, e *Emitter) {
-	var n float64
+	var n interface{}
 	for _, v := range values {
-		n += v.(float64)
+		n = addSum(n, v)
+	}
+	if n == nil {
+		n = float64(0)
 	}
 	e.Emit(key, n)
 }
@@ -696,7 +971,7 @@ func MapMean(itr Iterator, e *Emitter, tmin int64) {
 
 	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
 		out.Count++
-		out.Sum += v.(float64)
+		out.Sum += float64Value(v)
 	}
 	if out.Count > 0 {
 		e.Emit(Key{tmin, itr.Tags()}, out)
@@ -727,7 +1002,7 @@ func MapMin(itr Iterator, e *Emitter, tmin int64) {
 	pointsYielded := false
 
 	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
-		val := v.(float64)
+		val := float64Value(v)
 		// Initialize min
 		if !pointsYielded {
 			min = val
@@ -766,7 +1041,7 @@ func MapMax(itr Iterator, e *Emitter, tmax int64) {
 	pointsYielded := false
 
 	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
-		val := v.(float64)
+		val := float64Value(v)
 		// Initialize max
 		if !pointsYielded {
 			max = val
@@ -808,7 +1083,7 @@ func MapSpread(itr Iterator, e *Emitter, tmax int64) {
 	pointsYielded := false
 
 	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
-		val := v.(float64)
+		val := float64Value(v)
 		// Initialize
 		if !pointsYielded {
 			out.Max = val
@@ -849,7 +1124,7 @@ func MapStddev(itr Iterator, e *Emitter, tmax int64) {
 	var values []float64
 
 	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
-		values = append(values, v.(float64))
+		values = append(values, float64Value(v))
 		// Emit in batches.
 		// unbounded emission of data can lead to excessive memory use
 		// or other potential performance problems.
@@ -1012,7 +1287,7 @@ func ReducePercentile(percentile float64) ReduceFunc {
 		for _, v := range values {
 			vals := v.([]interface{})
 			for _, v := range vals {
-				allValues = append(allValues, v.(float64))
+				allValues = append(allValues, float64Value(v))
 			}
 		}
 
@@ -1028,6 +1303,168 @@ func ReducePercentile(percentile float64) ReduceFunc {
 	}
 }
 
+// timeValue is the time and value of a point.
+type timeValue struct {
+	Time int64
+	Val  interface{}
+}
+
+// timeValues sorts points by time.
+type timeValues []timeValue
+
+func (a timeValues) Len() int           { return len(a) }
+func (a timeValues) Less(i, j int) bool { return a[i].Time < a[j].Time }
+func (a timeValues) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// MapPoints emits the time and value of each point for each group by interval.
+func MapPoints(itr Iterator, e *Emitter, tmin int64) {
+	var a timeValues
+	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
+		a = append(a, timeValue{Time: k, Val: v})
+	}
+	if len(a) > 0 {
+		e.Emit(Key{tmin, itr.Tags()}, a)
+	}
+}
+
+// reducePoints returns the points emitted by MapPoints for a key in time order.
+func reducePoints(values []interface{}) timeValues {
+	var a timeValues
+	for _, v := range values {
+		a = append(a, v.(timeValues)...)
+	}
+	sort.Sort(a)
+	return a
+}
+
+// ReduceElapsed computes the time between consecutive points in units of
+// unit. Each result is emitted with the time of the later point.
+func ReduceElapsed(unit time.Duration) ReduceFunc {
+	return func(key Key, values []interface{}, e *Emitter) {
+		a := reducePoints(values)
+		for i := 1; i < len(a); i++ {
+			e.Emit(Key{a[i].Time, key.Values}, (a[i].Time-a[i-1].Time)/int64(unit))
+		}
+	}
+}
+
+// ReduceDifference computes the change in value between consecutive points.
+// Each result is emitted with the time of the later point.
+func ReduceDifference(key Key, values []interface{}, e *Emitter) {
+	a := reducePoints(values)
+	for i := 1; i < len(a); i++ {
+		e.Emit(Key{a[i].Time, key.Values}, float64Value(a[i].Val)-float64Value(a[i-1].Val))
+	}
+}
+
+// samplePoint is a point chosen by a sample with its random priority.
+type samplePoint struct {
+	Time     int64
+	Val      interface{}
+	priority float64
+}
+
+// samplePoints sorts sampled points by priority.
+type samplePoints []samplePoint
+
+func (a samplePoints) Len() int           { return len(a) }
+func (a samplePoints) Less(i, j int) bool { return a[i].priority < a[j].priority }
+func (a samplePoints) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// lowest returns the n points with the lowest priority.
+func (a samplePoints) lowest(n int) samplePoints {
+	if len(a) <= n {
+		return a
+	}
+	sort.Sort(a)
+	return a[:n]
+}
+
+// MapSample chooses n points at random from an iterator. Each point is given
+// a random priority and the points with the lowest priorities are kept, so
+// the samples of several iterators can be combined by the reducer.
+func MapSample(n int) MapFunc {
+	return func(itr Iterator, e *Emitter, tmin int64) {
+		var a samplePoints
+		for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
+			a = append(a, samplePoint{Time: k, Val: v, priority: rand.Float64()})
+
+			// Discard points that can't be chosen so memory stays bounded.
+			if len(a) == 2*n {
+				a = a.lowest(n)
+			}
+		}
+		if len(a) > 0 {
+			e.Emit(Key{tmin, itr.Tags()}, a.lowest(n))
+		}
+	}
+}
+
+// ReduceSample chooses n points at random from the samples of each key. Each
+// chosen point is emitted with its own time, in time order.
+func ReduceSample(n int) ReduceFunc {
+	return func(key Key, values []interface{}, e *Emitter) {
+		var a samplePoints
+		for _, v := range values {
+			a = append(a, v.(samplePoints)...)
+		}
+		a = a.lowest(n)
+
+		sort.Sort(samplePointsByTime(a))
+		for _, p := range a {
+			e.Emit(Key{p.Time, key.Values}, p.Val)
+		}
+	}
+}
+
+// samplePointsByTime sorts sampled points by time.
+type samplePointsByTime []samplePoint
+
+func (a samplePointsByTime) Len() int           { return len(a) }
+func (a samplePointsByTime) Less(i, j int) bool { return a[i].Time < a[j].Time }
+func (a samplePointsByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// MapHistogram merges the histograms of each group by interval. Values that
+// aren't histograms are ignored.
+func MapHistogram(itr Iterator, e *Emitter, tmin int64) {
+	var b Buckets
+	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
+		if v, ok := v.(Buckets); ok {
+			if b == nil {
+				b = make(Buckets, len(v))
+			}
+			b.Merge(v)
+		}
+	}
+	if b != nil {
+		e.Emit(Key{tmin, itr.Tags()}, b)
+	}
+}
+
+// mergeBuckets merges the histograms output by MapHistogram.
+func mergeBuckets(values []interface{}) Buckets {
+	b := make(Buckets)
+	for _, v := range values {
+		b.Merge(v.(Buckets))
+	}
+	return b
+}
+
+// ReduceHistogram merges the histograms of each key.
+func ReduceHistogram(key Key, values []interface{}, e *Emitter) {
+	e.Emit(key, mergeBuckets(values))
+}
+
+// ReduceHistogramPercentile estimates a percentile from the merged histograms
+// of each key.
+func ReduceHistogramPercentile(percentile float64) ReduceFunc {
+	return func(key Key, values []interface{}, e *Emitter) {
+		if v := mergeBuckets(values).Percentile(percentile); !math.IsNaN(v) {
+			e.Emit(key, v)
+		}
+	}
+}
+
 func MapRawQuery(itr Iterator, e *Emitter, tmin int64) {
 	var values []interface{}
 
@@ -1145,17 +1582,17 @@ func (e *binaryExprEvaluator) run() {
 func (e *binaryExprEvaluator) eval(lhs, rhs interface{}) interface{} {
 	switch e.op {
 	case ADD:
-		return lhs.(float64) + rhs.(float64)
+		return float64Value(lhs) + float64Value(rhs)
 	case SUB:
-		return lhs.(float64) - rhs.(float64)
+		return float64Value(lhs) - float64Value(rhs)
 	case MUL:
-		return lhs.(float64) * rhs.(float64)
+		return float64Value(lhs) * float64Value(rhs)
 	case DIV:
-		rhs := rhs.(float64)
+		rhs := float64Value(rhs)
 		if rhs == 0 {
 			return float64(0)
 		}
-		return lhs.(float64) / rhs
+		return float64Value(lhs) / rhs
 	default:
 		// TODO: Validate operation & data types.
 		panic("invalid operation: " + e.op.String())
@@ -1255,6 +1692,18 @@ type Row struct {
 	Err     error             `json:"err,omitempty"`
 }
 
+// size returns the approximate number of bytes held by the row's name, tags and columns.
+func (r *Row) size() int64 {
+	n := int64(len(r.Name))
+	for k, v := range r.Tags {
+		n += int64(len(k)+len(v)) + 32
+	}
+	for _, c := range r.Columns {
+		n += int64(len(c)) + 16
+	}
+	return n
+}
+
 // tagsHash returns a hash of tag key/value pairs.
 func (r *Row) tagsHash() uint64 {
 	h := fnv.New64a()
@@ -1325,3 +1774,112 @@ func UnmarshalStrings(b []byte) (ret []string) {
 		b = b[n+2:]
 	}
 }
+
+// ErrQueryInterrupted is returned when a query is stopped before it completes.
+var ErrQueryInterrupted = errors.New("query interrupted")
+
+// ErrQueryMemoryLimitExceeded is returned when a query buffers more data than its limit.
+var ErrQueryMemoryLimitExceeded = errors.New("query memory limit exceeded")
+
+// ErrQueryMemoryPoolExhausted is returned when running queries together buffer
+// more data than the server-wide limit.
+var ErrQueryMemoryPoolExhausted = errors.New("query memory pool exhausted")
+
+// MemoryPool tracks the memory buffered by all running queries against a shared cap.
+type MemoryPool struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+}
+
+// NewMemoryPool returns a new instance of MemoryPool. Unlimited if limit is zero.
+func NewMemoryPool(limit int64) *MemoryPool {
+	return &MemoryPool{limit: limit}
+}
+
+// SetLimit sets the maximum number of bytes that can be reserved from the pool.
+func (p *MemoryPool) SetLimit(limit int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = limit
+}
+
+// Used returns the number of bytes currently reserved from the pool.
+func (p *MemoryPool) Used() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.used
+}
+
+// Reserve claims n bytes from the pool.
+// Returns ErrQueryMemoryPoolExhausted if the pool's limit would be exceeded.
+func (p *MemoryPool) Reserve(n int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.limit > 0 && p.used+n > p.limit {
+		return ErrQueryMemoryPoolExhausted
+	}
+	p.used += n
+	return nil
+}
+
+// Release returns n bytes to the pool.
+func (p *MemoryPool) Release(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used -= n
+}
+
+// memoryAccount tracks the memory buffered by a single query.
+type memoryAccount struct {
+	max  int64       // per-query limit, unlimited if zero
+	used int64       // bytes reserved by the query
+	pool *MemoryPool // shared pool, optional
+}
+
+// reserve claims n bytes for the query and from the shared pool, if set.
+func (a *memoryAccount) reserve(n int64) error {
+	if a.max > 0 && a.used+n > a.max {
+		return ErrQueryMemoryLimitExceeded
+	}
+	if a.pool != nil {
+		if err := a.pool.Reserve(n); err != nil {
+			return err
+		}
+	}
+	a.used += n
+	return nil
+}
+
+// release returns all memory reserved by the query to the shared pool.
+func (a *memoryAccount) release() {
+	if a.pool != nil {
+		a.pool.Release(a.used)
+	}
+	a.used = 0
+}
+
+// sizeOfValues returns the approximate number of bytes held by a slice of values.
+func sizeOfValues(a []interface{}) int64 {
+	n := int64(24)
+	for _, v := range a {
+		n += sizeOfValue(v)
+	}
+	return n
+}
+
+// sizeOfValue returns the approximate number of bytes held by a single value.
+func sizeOfValue(v interface{}) int64 {
+	switch v := v.(type) {
+	case string:
+		return int64(len(v)) + 32
+	case []byte:
+		return int64(len(v)) + 40
+	case time.Time:
+		return 40
+	case []interface{}:
+		return sizeOfValues(v)
+	default:
+		return 16
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
 	"reflect"
 	"strings"
@@ -77,6 +79,30 @@ func TestReducer_Reduce(t *testing.T) {
 	}
 }
 
+// Ensure a reducer sums unsigned values exactly and promotes the sum to a float on overflow.
+func TestReducer_Reduce_SumUnsigned(t *testing.T) {
+	m := []*influxql.Mapper{
+		influxql.NewMapper(influxql.MapSum,
+			NewIterator([]string{"foo"}, []Point{
+				{"2000-01-01T00:00:00Z", uint64(math.MaxUint64 - 10)},
+				{"2000-01-01T00:01:00Z", uint64(math.MaxUint64)},
+			}), 1*time.Minute),
+		influxql.NewMapper(influxql.MapSum,
+			NewIterator([]string{"foo"}, []Point{
+				{"2000-01-01T00:00:00Z", uint64(10)},
+				{"2000-01-01T00:01:00Z", uint64(1)},
+			}), 1*time.Minute)}
+
+	r := influxql.NewReducer(influxql.ReduceSum, m)
+	ch := r.Reduce().C()
+	if data := <-ch; !reflect.DeepEqual(data, map[influxql.Key]interface{}{influxql.Key{Timestamp: 946684800000000000, Values: "\x00\x03foo"}: uint64(math.MaxUint64)}) {
+		t.Fatalf("unexpected data(0): %#v", data)
+	}
+	if data := <-ch; !reflect.DeepEqual(data, map[influxql.Key]interface{}{influxql.Key{Timestamp: 946684860000000000, Values: "\x00\x03foo"}: float64(math.MaxUint64) + 1}) {
+		t.Fatalf("unexpected data(1): %#v", data)
+	}
+}
+
 // Ensure the planner can plan and execute a simple count query.
 func TestPlanner_Plan_Count(t *testing.T) {
 	tx := NewTx()
@@ -539,6 +565,148 @@ func TestPlanner_Plan_Percentile(t *testing.T) {
 	}
 }
 
+// Ensure the planner can plan and execute a sample query.
+func TestPlanner_Plan_Sample(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:00Z", float64(1)},
+				{"2000-01-01T00:00:10Z", float64(2)},
+				{"2000-01-01T00:00:20Z", float64(3)},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:30Z", float64(4)},
+				{"2000-01-01T00:00:40Z", float64(5)},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:01:30Z", float64(6)},
+			})}, nil
+	}
+
+	// Sample two points from each minute. Each value is its point's second
+	// within the minute divided by ten, plus one.
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT sample(value, 2) FROM cpu WHERE time >= '2000-01-01' GROUP BY time(1m)`)
+	if len(rs) != 1 {
+		t.Fatalf("unexpected rows: %s", jsonify(rs))
+	} else if values := rs[0].Values; len(values) != 3 {
+		t.Fatalf("unexpected values: %s", jsonify(rs))
+	} else {
+		for i, v := range values {
+			ts, val := v[0].(time.Time), v[1].(float64)
+			if i > 0 && !ts.After(values[i-1][0].(time.Time)) {
+				t.Fatalf("values out of order: %s", jsonify(rs))
+			} else if exp := float64(ts.Second()/10 + 1); ts.Minute() == 0 && val != exp {
+				t.Fatalf("unexpected value at %s: %v", ts, val)
+			} else if ts.Minute() == 1 && val != 6 {
+				t.Fatalf("unexpected value at %s: %v", ts, val)
+			}
+		}
+	}
+
+	// Sampling more points than exist returns every point.
+	rs = MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT sample(value, 10) FROM cpu WHERE time >= '2000-01-01'`)
+	if exp := minify(`[{"name":"cpu","columns":["time","sample"],"values":[["2000-01-01T00:00:00Z",1],["2000-01-01T00:00:10Z",2],["2000-01-01T00:00:20Z",3],["2000-01-01T00:00:30Z",4],["2000-01-01T00:00:40Z",5],["2000-01-01T00:01:30Z",6]]}]`); minify(jsonify(rs)) != exp {
+		t.Fatalf("unexpected resultset: %s", jsonify(rs))
+	}
+
+	if _, err := PlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`, `SELECT sample(value, 1.5) FROM cpu`); err == nil || err.Error() != `expected positive integer argument in sample()` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the planner can plan and execute an elapsed query.
+func TestPlanner_Plan_Elapsed(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:00Z", float64(100)},
+				{"2000-01-01T00:00:10Z", float64(90)},
+				{"2000-01-01T00:00:40Z", float64(80)},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:15Z", float64(70)},
+				{"2000-01-01T00:01:30Z", float64(60)},
+			})}, nil
+	}
+
+	exp := minify(`[{"name":"cpu","columns":["time","elapsed"],"values":[["2000-01-01T00:00:10Z",10],["2000-01-01T00:00:15Z",5],["2000-01-01T00:00:40Z",25],["2000-01-01T00:01:30Z",50]]}]`)
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT elapsed(value, 1s) FROM cpu WHERE time >= '2000-01-01'`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	// Points are only compared with points in the same interval.
+	exp = minify(`[{"name":"cpu","columns":["time","elapsed"],"values":[["2000-01-01T00:00:10Z",10000000000],["2000-01-01T00:00:15Z",5000000000],["2000-01-01T00:00:40Z",25000000000]]}]`)
+	rs = MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT elapsed(value) FROM cpu WHERE time >= '2000-01-01' GROUP BY time(1m)`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	if _, err := PlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`, `SELECT elapsed(value, 10) FROM cpu`); err == nil || err.Error() != `expected duration argument in elapsed()` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the planner can plan and execute a difference query.
+func TestPlanner_Plan_Difference(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:00Z", float64(100)},
+				{"2000-01-01T00:00:10Z", float64(90)},
+				{"2000-01-01T00:00:40Z", float64(120)},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:20Z", float64(95)},
+				{"2000-01-01T00:01:30Z", uint64(60)},
+			})}, nil
+	}
+
+	exp := minify(`[{"name":"cpu","columns":["time","difference"],"values":[["2000-01-01T00:00:10Z",-10],["2000-01-01T00:00:20Z",5],["2000-01-01T00:00:40Z",25],["2000-01-01T00:01:30Z",-60]]}]`)
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT difference(value) FROM cpu WHERE time >= '2000-01-01'`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+}
+
+// Ensure the planner can merge histograms and estimate percentiles from them.
+func TestPlanner_Plan_Histogram(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:00Z", influxql.Buckets{10: 4, 20: 2}},
+				{"2000-01-01T00:00:10Z", influxql.Buckets{10: 2, 20: 2, math.Inf(1): 1}},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:20Z", influxql.Buckets{10: 0, 20: 6}},
+				{"2000-01-01T00:00:30Z", float64(100)},
+			})}, nil
+	}
+
+	exp := minify(`[{"name":"cpu","columns":["time","histogram"],"values":[["1970-01-01T00:00:00Z",{"10":6,"20":10,"+Inf":1}]]}]`)
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT histogram(value) FROM cpu WHERE time >= '2000-01-01'`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	exp = minify(`[{"name":"cpu","columns":["time","histogram_percentile"],"values":[["1970-01-01T00:00:00Z",12.5]]}]`)
+	rs = MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT histogram_percentile(value, 50) FROM cpu WHERE time >= '2000-01-01'`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+}
+
 // Ensure the planner can plan and execute a count query grouped by hour.
 func TestPlanner_Plan_GroupByInterval(t *testing.T) {
 	tx := NewTx()
@@ -634,6 +802,172 @@ func TestPlanner_Plan_GroupByIntervalAndTag(t *testing.T) {
 	}
 }
 
+// Ensure the executor aborts a query that buffers more memory than its limit.
+func TestPlanner_Plan_MaxQueryMemory(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T09:00:00Z", float64(10)},
+				{"2000-01-01T10:00:00Z", float64(20)},
+				{"2000-01-01T11:00:00Z", float64(30)},
+			})}, nil
+	}
+
+	pool := influxql.NewMemoryPool(0)
+	p := influxql.NewPlanner(NewDB(tx))
+	p.Now = func() time.Time { return mustParseTime("2000-01-01T12:00:00Z") }
+	p.MaxQueryMemory = 100
+	p.MemoryPool = pool
+
+	e, err := p.Plan(MustParseSelectStatement(`SELECT sum(value) FROM cpu WHERE time >= now() - 3h GROUP BY time(1h), host`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Only an error row should be returned.
+	var rows []*influxql.Row
+	for row := range ch {
+		rows = append(rows, row)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("unexpected row count: %d", len(rows))
+	} else if rows[0].Err != influxql.ErrQueryMemoryLimitExceeded {
+		t.Fatalf("unexpected error: %v", rows[0].Err)
+	} else if n := pool.Used(); n != 0 {
+		t.Fatalf("memory not released to pool: %d", n)
+	}
+}
+
+// Ensure the executor stops an interrupted query and closes its transaction
+// once the mappers have stopped.
+func TestPlanner_Plan_Interrupt(t *testing.T) {
+	closed := make(chan struct{})
+	tx := NewTx()
+	tx.CloseFunc = func() error { close(closed); return nil }
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T09:00:00Z", float64(10)},
+				{"2000-01-01T10:00:00Z", float64(20)},
+			})}, nil
+	}
+
+	interrupt := make(chan struct{})
+	close(interrupt)
+	p := influxql.NewPlanner(NewDB(tx))
+	p.Now = func() time.Time { return mustParseTime("2000-01-01T12:00:00Z") }
+	p.Interrupt = interrupt
+
+	e, err := p.Plan(MustParseSelectStatement(`SELECT sum(value) FROM cpu WHERE time >= now() - 3h GROUP BY time(1h), host`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Only an error row should be returned.
+	var rows []*influxql.Row
+	for row := range ch {
+		rows = append(rows, row)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("unexpected row count: %d", len(rows))
+	} else if rows[0].Err != influxql.ErrQueryInterrupted {
+		t.Fatalf("unexpected error: %v", rows[0].Err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("transaction not closed")
+	}
+}
+
+// Ensure the executor spills buffered rows to disk instead of failing when
+// a spill directory is set.
+func TestPlanner_Plan_SpillDir(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		var itrs []influxql.Iterator
+		for _, host := range []string{"servera", "serverb", "serverc"} {
+			itrs = append(itrs, NewIterator([]string{host}, []Point{
+				{"2000-01-01T09:00:00Z", float64(10)},
+				{"2000-01-01T10:00:00Z", float64(20)},
+				{"2000-01-01T10:30:00Z", float64(5)},
+				{"2000-01-01T11:00:00Z", float64(30)},
+			}))
+		}
+		return itrs, nil
+	}
+
+	dir, err := ioutil.TempDir("", "influxql-spill-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	execute := func(max int64, spillDir string, pool *influxql.MemoryPool) string {
+		p := influxql.NewPlanner(NewDB(tx))
+		p.Now = func() time.Time { return mustParseTime("2000-01-01T12:00:00Z") }
+		p.MaxQueryMemory = max
+		p.MemoryPool = pool
+		p.SpillDir = spillDir
+
+		e, err := p.Plan(MustParseSelectStatement(`SELECT sum(value), count(value) FROM cpu WHERE time >= now() - 3h GROUP BY time(1h), host`))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ch, err := e.Execute()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var rows []*influxql.Row
+		for row := range ch {
+			if row.Err != nil {
+				t.Fatalf("unexpected error: %s", row.Err)
+			}
+			rows = append(rows, row)
+		}
+		return jsonify(rows)
+	}
+
+	// Results match the unlimited query and the spilled runs are removed.
+	pool := influxql.NewMemoryPool(0)
+	if exp, act := execute(0, "", nil), execute(300, dir, pool); exp != act {
+		t.Fatalf("unexpected resultset:\n\nexp=%s\n\ngot=%s\n\n", exp, act)
+	} else if n := pool.Used(); n != 0 {
+		t.Fatalf("memory not released to pool: %d", n)
+	} else if fis, _ := ioutil.ReadDir(dir); len(fis) != 0 {
+		t.Fatalf("spilled runs not removed: %d", len(fis))
+	}
+}
+
+// Ensure the memory pool rejects reservations beyond its limit.
+func TestMemoryPool_Reserve(t *testing.T) {
+	p := influxql.NewMemoryPool(100)
+	if err := p.Reserve(60); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := p.Reserve(60); err != influxql.ErrQueryMemoryPoolExhausted {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Releasing memory allows the reservation to succeed.
+	p.Release(60)
+	if err := p.Reserve(60); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if n := p.Used(); n != 60 {
+		t.Fatalf("unexpected used memory: %d", n)
+	}
+}
+
 // Ensure the planner sends the correct simplified statements to the iterator creator.
 func TestPlanner_CreateIterators(t *testing.T) {
 	var flag0, flag1 bool
@@ -0,0 +1,130 @@
+package influxql
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+func init() {
+	// Histograms may be held in rows spilled to disk.
+	gob.Register(Buckets(nil))
+}
+
+// Buckets represents a histogram. Each bucket holds the number of values
+// greater than the bound of the bucket below it and at most its own upper
+// bound. Values above the highest bound are counted in a bucket bound by +Inf.
+//
+// Histograms with the same bounds are merged by adding their counts so
+// distributions written by many clients can be combined exactly.
+type Buckets map[float64]uint64
+
+// ParseBuckets converts a histogram written as a JSON object, mapping upper
+// bounds to counts, into buckets. Bounds are numbers or "+Inf" and counts
+// are non-negative integers.
+func ParseBuckets(m map[string]interface{}) (Buckets, error) {
+	if len(m) == 0 {
+		return nil, fmt.Errorf("histogram has no buckets")
+	} else if len(m) > math.MaxUint16 {
+		return nil, fmt.Errorf("histogram has too many buckets: %d", len(m))
+	}
+
+	b := make(Buckets, len(m))
+	for k, v := range m {
+		bound, err := strconv.ParseFloat(k, 64)
+		if err != nil || math.IsNaN(bound) {
+			return nil, fmt.Errorf("invalid histogram bound: %q", k)
+		}
+
+		switch v := v.(type) {
+		case float64:
+			if v < 0 || v != math.Trunc(v) || v >= math.MaxUint64 {
+				return nil, fmt.Errorf("invalid histogram count for bound %s: %v", k, v)
+			}
+			b[bound] = uint64(v)
+		case uint64:
+			b[bound] = v
+		default:
+			return nil, fmt.Errorf("invalid histogram count for bound %s: %v", k, v)
+		}
+	}
+	return b, nil
+}
+
+// Bounds returns the upper bounds of the buckets in ascending order.
+func (b Buckets) Bounds() []float64 {
+	a := make([]float64, 0, len(b))
+	for bound := range b {
+		a = append(a, bound)
+	}
+	sort.Float64s(a)
+	return a
+}
+
+// Count returns the number of values in all buckets.
+func (b Buckets) Count() uint64 {
+	var n uint64
+	for _, c := range b {
+		n += c
+	}
+	return n
+}
+
+// Merge adds the counts of other to the buckets.
+func (b Buckets) Merge(other Buckets) {
+	for bound, c := range other {
+		b[bound] += c
+	}
+}
+
+// Percentile estimates the value below which p percent of the values fall.
+// Values are assumed to be spread evenly through their bucket, with the
+// lowest bucket starting at zero, or at its bound if that is negative.
+// Values in the +Inf bucket are estimated as the highest finite bound.
+// Returns NaN if the histogram is empty.
+func (b Buckets) Percentile(p float64) float64 {
+	total := b.Count()
+	if total == 0 {
+		return math.NaN()
+	}
+	rank := p / 100 * float64(total)
+
+	var n uint64
+	lower := 0.0
+	for i, bound := range b.Bounds() {
+		if i == 0 && bound < 0 {
+			lower = bound
+		}
+		c := b[bound]
+		if c > 0 && float64(n+c) >= rank {
+			if math.IsInf(bound, 1) {
+				return lower
+			}
+			return lower + (bound-lower)*(rank-float64(n))/float64(c)
+		}
+		n += c
+		if !math.IsInf(bound, 1) {
+			lower = bound
+		}
+	}
+	return lower
+}
+
+// MarshalJSON encodes the buckets as an object mapping bounds to counts.
+func (b Buckets) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	_ = buf.WriteByte('{')
+	for i, bound := range b.Bounds() {
+		if i > 0 {
+			_ = buf.WriteByte(',')
+		}
+		_, _ = buf.WriteString(strconv.Quote(strconv.FormatFloat(bound, 'g', -1, 64)))
+		_ = buf.WriteByte(':')
+		_, _ = buf.WriteString(strconv.FormatUint(b[bound], 10))
+	}
+	_ = buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -87,6 +87,15 @@ func (p *Parser) ParseStatement() (Statement, error) {
 		return p.parseRevokeStatement()
 	case ALTER:
 		return p.parseAlterStatement()
+	case IDENT:
+		// RESET and TRUNCATE are not keywords so that they remain usable as identifiers.
+		switch strings.ToUpper(lit) {
+		case "RESET":
+			return p.parseResetUsageStatement()
+		case "TRUNCATE":
+			return p.parseTruncateShardsStatement()
+		}
+		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
 	default:
 		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
 	}
@@ -109,6 +118,8 @@ func (p *Parser) parseShowStatement() (Statement, error) {
 		return nil, newParseError(tokstr(tok, lit), []string{"KEYS", "VALUES"}, pos)
 	case MEASUREMENTS:
 		return p.parseShowMeasurementsStatement()
+	case QUERIES:
+		return &ShowQueriesStatement{}, nil
 	case RETENTION:
 		tok, pos, lit := p.scanIgnoreWhitespace()
 		if tok == POLICIES {
@@ -127,9 +138,31 @@ func (p *Parser) parseShowStatement() (Statement, error) {
 		return nil, newParseError(tokstr(tok, lit), []string{"KEYS", "VALUES"}, pos)
 	case USERS:
 		return p.parseShowUsersStatement()
+	case IDENT:
+		// DATA, QUOTAS, SHARD, SHARDS and USAGE are not keywords so that they remain usable as identifiers.
+		switch strings.ToUpper(lit) {
+		case "DATA":
+			if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "NODES" {
+				return nil, newParseError(tokstr(tok, lit), []string{"NODES"}, pos)
+			}
+			return &ShowDataNodesStatement{}, nil
+		case "QUOTAS":
+			return p.parseShowQuotasStatement()
+		case "SHARD":
+			if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "GROUPS" {
+				return nil, newParseError(tokstr(tok, lit), []string{"GROUPS"}, pos)
+			}
+			return p.parseShowShardGroupsStatement()
+		case "SHARDS":
+			return p.parseShowShardsStatement()
+		case "TOKENS":
+			return &ShowTokensStatement{}, nil
+		case "USAGE":
+			return p.parseShowUsageStatement()
+		}
 	}
 
-	return nil, newParseError(tokstr(tok, lit), []string{"CONTINUOUS", "DATABASES", "FIELD", "MEASUREMENTS", "RETENTION", "SERIES", "TAG", "USERS"}, pos)
+	return nil, newParseError(tokstr(tok, lit), []string{"CONTINUOUS", "DATA", "DATABASES", "FIELD", "MEASUREMENTS", "QUERIES", "QUOTAS", "RETENTION", "SERIES", "SHARD", "SHARDS", "TAG", "TOKENS", "USAGE", "USERS"}, pos)
 }
 
 // parseCreateStatement parses a string and returns a create statement.
@@ -138,6 +171,8 @@ func (p *Parser) parseCreateStatement() (Statement, error) {
 	tok, pos, lit := p.scanIgnoreWhitespace()
 	if tok == CONTINUOUS {
 		return p.parseCreateContinuousQueryStatement()
+	} else if tok == QUERY {
+		return p.parseCreateQueryStatement()
 	} else if tok == DATABASE {
 		return p.parseCreateDatabaseStatement()
 	} else if tok == USER {
@@ -148,9 +183,11 @@ func (p *Parser) parseCreateStatement() (Statement, error) {
 			return nil, newParseError(tokstr(tok, lit), []string{"POLICY"}, pos)
 		}
 		return p.parseCreateRetentionPolicyStatement()
+	} else if tok == IDENT && strings.ToUpper(lit) == "TOKEN" {
+		return p.parseCreateTokenStatement()
 	}
 
-	return nil, newParseError(tokstr(tok, lit), []string{"CONTINUOUS", "DATABASE", "USER", "RETENTION"}, pos)
+	return nil, newParseError(tokstr(tok, lit), []string{"CONTINUOUS", "DATABASE", "QUERY", "USER", "RETENTION", "TOKEN"}, pos)
 }
 
 // parseDropStatement parses a string and returns a drop statement.
@@ -163,6 +200,8 @@ func (p *Parser) parseDropStatement() (Statement, error) {
 		return p.parseDropMeasurementStatement()
 	} else if tok == CONTINUOUS {
 		return p.parseDropContinuousQueryStatement()
+	} else if tok == QUERY {
+		return p.parseDropQueryStatement()
 	} else if tok == DATABASE {
 		return p.parseDropDatabaseStatement()
 	} else if tok == RETENTION {
@@ -172,6 +211,10 @@ func (p *Parser) parseDropStatement() (Statement, error) {
 		return p.parseDropRetentionPolicyStatement()
 	} else if tok == USER {
 		return p.parseDropUserStatement()
+	} else if tok == IDENT && strings.ToUpper(lit) == "SHARD" {
+		return p.parseDropShardStatement()
+	} else if tok == IDENT && strings.ToUpper(lit) == "TOKEN" {
+		return p.parseDropTokenStatement()
 	}
 
 	return nil, newParseError(tokstr(tok, lit), []string{"SERIES", "CONTINUOUS", "MEASUREMENT"}, pos)
@@ -186,9 +229,306 @@ func (p *Parser) parseAlterStatement() (Statement, error) {
 			return nil, newParseError(tokstr(tok, lit), []string{"POLICY"}, pos)
 		}
 		return p.parseAlterRetentionPolicyStatement()
+	} else if tok == FIELD {
+		return p.parseAlterFieldStatement()
+	} else if tok == DATABASE {
+		return p.parseAlterDatabaseStatement()
+	} else if tok == MEASUREMENT {
+		return p.parseAlterMeasurementStatement()
+	} else if tok == IDENT && strings.ToUpper(lit) == "DATA" {
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "NODE" {
+			return nil, newParseError(tokstr(tok, lit), []string{"NODE"}, pos)
+		}
+		return p.parseAlterDataNodeStatement()
+	}
+
+	return nil, newParseError(tokstr(tok, lit), []string{"DATA", "DATABASE", "FIELD", "MEASUREMENT", "RETENTION"}, pos)
+}
+
+// parseAlterDataNodeStatement parses a string and returns an alter data node statement.
+// This function assumes the ALTER DATA NODE tokens have already been consumed.
+func (p *Parser) parseAlterDataNodeStatement() (*AlterDataNodeStatement, error) {
+	stmt := &AlterDataNodeStatement{}
+
+	// Parse the data node id.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return nil, newParseError(tokstr(tok, lit), []string{"number"}, pos)
+	}
+	id, err := strconv.ParseUint(lit, 10, 64)
+	if err != nil {
+		return nil, &ParseError{Message: err.Error(), Pos: pos}
+	}
+	stmt.ID = id
+
+	// Consume the required SET token.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); !isSetToken(tok, lit) {
+		return nil, newParseError(tokstr(tok, lit), []string{"SET"}, pos)
+	}
+
+	// Parse the comma delimited list of labels assigned to strings.
+	stmt.Labels = make(map[string]string)
+	for {
+		key, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EQ {
+			return nil, newParseError(tokstr(tok, lit), []string{"="}, pos)
+		}
+		if stmt.Labels[key], err = p.parseString(); err != nil {
+			return nil, err
+		}
+
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			return stmt, nil
+		}
+	}
+}
+
+// parseAlterMeasurementStatement parses a string and returns an alter measurement statement.
+// This function assumes the ALTER MEASUREMENT tokens have already been consumed.
+func (p *Parser) parseAlterMeasurementStatement() (*AlterMeasurementStatement, error) {
+	stmt := &AlterMeasurementStatement{}
+
+	// Parse the measurement name.
+	ident, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = ident
+
+	// Loop through the TTL and SET options. TTL is matched as an identifier so
+	// that "ttl" can still be used as a bare measurement, tag or field key.
+	for i := 0; i < 2; i++ {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		switch {
+		case tok == IDENT && strings.ToUpper(lit) == "TTL" && stmt.TTL == nil:
+			d, err := p.parseDuration()
+			if err != nil {
+				return nil, err
+			}
+			stmt.TTL = &d
+		case isSetToken(tok, lit) && stmt.Metadata == nil:
+			if stmt.Metadata, err = p.parseMetadataAssignments(); err != nil {
+				return nil, err
+			}
+		default:
+			if i < 1 {
+				return nil, newParseError(tokstr(tok, lit), []string{"TTL", "SET"}, pos)
+			}
+			p.unscan()
+			return stmt, nil
+		}
+	}
+	return stmt, nil
+}
+
+// parseAlterDatabaseStatement parses a string and returns an alter database statement.
+// This function assumes the ALTER DATABASE tokens have already been consumed.
+func (p *Parser) parseAlterDatabaseStatement() (*AlterDatabaseStatement, error) {
+	stmt := &AlterDatabaseStatement{}
+
+	// Parse the database name.
+	ident, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = ident
+
+	// Parse the write limit and optional burst.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == WRITE {
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != LIMIT {
+			return nil, newParseError(tokstr(tok, lit), []string{"LIMIT"}, pos)
+		}
+		n, err := p.parseInt(0, math.MaxInt32)
+		if err != nil {
+			return nil, err
+		}
+		stmt.WriteLimit = &n
+
+		// BURST is not a keyword so that it can still be used as an identifier.
+		if tok, _, lit := p.scanIgnoreWhitespace(); tok == IDENT && strings.ToUpper(lit) == "BURST" {
+			if stmt.WriteBurst, err = p.parseInt(0, math.MaxInt32); err != nil {
+				return nil, err
+			}
+		} else {
+			p.unscan()
+		}
+		return stmt, nil
+	} else if tok == IDENT && strings.ToUpper(lit) == "NORMALIZE" {
+		return p.parseNormalizeNames(stmt)
+	} else if tok != IDENT || strings.ToUpper(lit) != "RENAME" {
+		return nil, newParseError(tokstr(tok, lit), []string{"NORMALIZE", "RENAME", "WRITE"}, pos)
+	}
+	p.unscan()
+
+	// Consume the required RENAME TO tokens.
+	if err := p.parseRenameTo(); err != nil {
+		return nil, err
+	}
+
+	// Parse the new database name.
+	if stmt.NewName, err = p.parseIdent(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseNormalizeNames parses the measurement name normalization options of
+// an alter database statement. This function assumes the NORMALIZE token has
+// already been consumed. None of the option names are keywords.
+func (p *Parser) parseNormalizeNames(stmt *AlterDatabaseStatement) (*AlterDatabaseStatement, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "NAMES" {
+		return nil, newParseError(tokstr(tok, lit), []string{"NAMES"}, pos)
+	}
+	stmt.NormalizeNames = true
+
+	for {
+		tok, _, lit := p.scanIgnoreWhitespace()
+		if tok != IDENT {
+			p.unscan()
+			return stmt, nil
+		}
+
+		switch strings.ToUpper(lit) {
+		case "LOWERCASE":
+			stmt.LowercaseNames = true
+		case "CHARACTERS":
+			tok, pos, lit := p.scanIgnoreWhitespace()
+			if tok != STRING {
+				return nil, newParseError(tokstr(tok, lit), []string{"string"}, pos)
+			} else if _, err := regexp.Compile("[" + lit + "]"); err != nil {
+				return nil, &ParseError{Message: fmt.Sprintf("invalid characters %q", lit), Pos: pos}
+			}
+			stmt.NameCharacters = lit
+		case "LENGTH":
+			n, err := p.parseInt(1, math.MaxInt32)
+			if err != nil {
+				return nil, err
+			}
+			stmt.MaxNameLength = n
+		default:
+			p.unscan()
+			return stmt, nil
+		}
+	}
+}
+
+// parseRenameTo parses the "RENAME TO" tokens. RENAME is not a keyword so
+// that it can still be used as an identifier.
+func (p *Parser) parseRenameTo() error {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "RENAME" {
+		return newParseError(tokstr(tok, lit), []string{"RENAME"}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != TO {
+		return newParseError(tokstr(tok, lit), []string{"TO"}, pos)
+	}
+	return nil
+}
+
+// parseAlterFieldStatement parses a string and returns an alter field statement.
+// This function assumes the ALTER FIELD tokens have already been consumed.
+func (p *Parser) parseAlterFieldStatement() (*AlterFieldStatement, error) {
+	stmt := &AlterFieldStatement{}
+
+	// Parse the field name.
+	ident, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = ident
+
+	// Consume the required ON token.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != ON {
+		return nil, newParseError(tokstr(tok, lit), []string{"ON"}, pos)
+	}
+
+	// Parse the measurement name.
+	ident, err = p.parseIdent()
+	if err != nil {
+		return nil, err
 	}
+	stmt.Measurement = ident
 
-	return nil, newParseError(tokstr(tok, lit), []string{"RETENTION"}, pos)
+	// Consume the required TO token or SET clause.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if isSetToken(tok, lit) {
+		if stmt.Metadata, err = p.parseMetadataAssignments(); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	} else if tok != TO {
+		return nil, newParseError(tokstr(tok, lit), []string{"TO", "SET"}, pos)
+	}
+
+	// Parse the data type.
+	tok, pos, lit = p.scanIgnoreWhitespace()
+	switch typ := DataType(strings.ToLower(lit)); typ {
+	case Number, Unsigned, Boolean, String:
+		if tok != IDENT {
+			break
+		}
+		stmt.Type = typ
+
+		// Parse the optional SET clause.
+		if tok, _, lit := p.scanIgnoreWhitespace(); !isSetToken(tok, lit) {
+			p.unscan()
+			return stmt, nil
+		}
+		if stmt.Metadata, err = p.parseMetadataAssignments(); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"number", "unsigned", "boolean", "string"}, pos)
+}
+
+// isSetToken returns true if the token is the SET identifier. SET is not a
+// keyword so that it can still be used as an identifier.
+func isSetToken(tok Token, lit string) bool {
+	return tok == IDENT && strings.ToUpper(lit) == "SET"
+}
+
+// parseMetadataAssignments parses a comma delimited list of metadata keys
+// assigned to strings. This function assumes the SET token has already been
+// consumed.
+func (p *Parser) parseMetadataAssignments() (map[string]string, error) {
+	m := make(map[string]string)
+	for {
+		// Parse the metadata key.
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		key := strings.ToLower(lit)
+		switch key {
+		case MetadataUnit, MetadataDescription, MetadataDisplayName:
+			if tok == IDENT {
+				break
+			}
+			fallthrough
+		default:
+			return nil, newParseError(tokstr(tok, lit), []string{MetadataUnit, MetadataDescription, MetadataDisplayName}, pos)
+		}
+
+		// Consume the required "=" token.
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EQ {
+			return nil, newParseError(tokstr(tok, lit), []string{"="}, pos)
+		}
+
+		// Parse the value.
+		value, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+
+		// Continue while there are more assignments.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			return m, nil
+		}
+	}
 }
 
 // parseCreateRetentionPolicyStatement parses a string and returns a create retention policy statement.
@@ -196,6 +536,12 @@ func (p *Parser) parseAlterStatement() (Statement, error) {
 func (p *Parser) parseCreateRetentionPolicyStatement() (*CreateRetentionPolicyStatement, error) {
 	stmt := &CreateRetentionPolicyStatement{}
 
+	// Parse optional IF NOT EXISTS clause.
+	var err error
+	if stmt.IfNotExists, err = p.parseIfNotExists(); err != nil {
+		return nil, err
+	}
+
 	// Parse the retention policy name.
 	ident, err := p.parseIdent()
 	if err != nil {
@@ -240,6 +586,23 @@ func (p *Parser) parseCreateRetentionPolicyStatement() (*CreateRetentionPolicySt
 	}
 	stmt.Replication = n
 
+	// Parse optional FORCE token. It's matched as an identifier so that
+	// "force" can still be used as a bare measurement, tag or field key.
+	if tok, _, lit := p.scanIgnoreWhitespace(); tok == IDENT && strings.ToUpper(lit) == "FORCE" {
+		stmt.Force = true
+	} else {
+		p.unscan()
+	}
+
+	// Parse optional DUPLICATES clause. It's matched as an identifier as well.
+	if tok, pos, lit = p.scanIgnoreWhitespace(); tok == IDENT && strings.ToUpper(lit) == "DUPLICATES" {
+		if stmt.Duplicates, err = p.parseIdent(); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
+
 	// Parse optional DEFAULT token.
 	if tok, pos, lit = p.scanIgnoreWhitespace(); tok == DEFAULT {
 		stmt.Default = true
@@ -274,11 +637,40 @@ func (p *Parser) parseAlterRetentionPolicyStatement() (*AlterRetentionPolicyStat
 	}
 	stmt.Database = ident
 
-	// Loop through option tokens (DURATION, REPLICATION, DEFAULT, etc.).
-	maxNumOptions := 3
-Loop:
-	for i := 0; i < maxNumOptions; i++ {
+	// Loop through option tokens (DURATION, REPLICATION, FORCE, DUPLICATES, DEFAULT, etc.).
+	// Any subset may be given in any order but each only once.
+	seen := make(map[string]bool)
+	for i := 0; ; i++ {
 		tok, pos, lit := p.scanIgnoreWhitespace()
+		option := strings.ToUpper(tokstr(tok, lit))
+		if seen[option] {
+			return nil, &ParseError{Message: fmt.Sprintf("%s specified more than once", option), Pos: pos}
+		}
+		seen[option] = true
+
+		if tok == IDENT && option == "FORCE" {
+			stmt.Force = true
+			continue
+		} else if tok == IDENT && option == "DUPLICATES" {
+			ident, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Duplicates = &ident
+			continue
+		} else if option == "RENAME" {
+			p.unscan()
+			if err := p.parseRenameTo(); err != nil {
+				return nil, err
+			}
+			ident, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			stmt.NewName = &ident
+			continue
+		}
+
 		switch tok {
 		case DURATION:
 			d, err := p.parseDuration()
@@ -296,14 +688,42 @@ Loop:
 			stmt.Default = true
 		default:
 			if i < 1 {
-				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "RETENTION", "DEFAULT"}, pos)
+				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "REPLICATION", "DUPLICATES", "DEFAULT", "RENAME"}, pos)
 			}
 			p.unscan()
-			break Loop
+			return stmt, nil
 		}
 	}
+}
 
-	return stmt, nil
+// parseIfNotExists parses an optional "IF NOT EXISTS" clause.
+// Returns true if the clause was present.
+func (p *Parser) parseIfNotExists() (bool, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != IF {
+		p.unscan()
+		return false, nil
+	}
+	// NOT is matched as an identifier so that it remains usable as one.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "NOT" {
+		return false, newParseError(tokstr(tok, lit), []string{"NOT"}, pos)
+	}
+	if err := p.parseTokens([]Token{EXISTS}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// parseIfExists parses an optional "IF EXISTS" clause.
+// Returns true if the clause was present.
+func (p *Parser) parseIfExists() (bool, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != IF {
+		p.unscan()
+		return false, nil
+	}
+	if err := p.parseTokens([]Token{EXISTS}); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // parseInt parses a string and returns an integer literal.
@@ -348,6 +768,27 @@ func (p *Parser) parseUInt32() (uint32, error) {
 	return uint32(n), nil
 }
 
+// parseTime parses a date or date time string and returns a time.
+func (p *Parser) parseTime() (time.Time, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != STRING {
+		return time.Time{}, newParseError(tokstr(tok, lit), []string{"time"}, pos)
+	}
+
+	if isDateString(lit) {
+		if t, err := time.Parse(DateFormat, lit); err == nil {
+			return t, nil
+		}
+	} else if isDateTimeString(lit) {
+		if t, err := time.Parse(DateTimeFormat, lit); err == nil {
+			return t, nil
+		} else if t, err := time.Parse(time.RFC3339Nano, lit); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, &ParseError{Message: "unable to parse datetime", Pos: pos}
+}
+
 // parseDuration parses a string and returns a duration literal.
 // This function assumes the DURATION token has already been consumed.
 func (p *Parser) parseDuration() (time.Duration, error) {
@@ -377,6 +818,36 @@ func (p *Parser) parseIdent() (string, error) {
 	return lit, nil
 }
 
+// parseIdentName parses an identifier naming a field, tag or alias and
+// returns the name without quotes.
+func (p *Parser) parseIdentName() (string, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != IDENT {
+		return "", newParseError(tokstr(tok, lit), []string{"identifier"}, pos)
+	}
+	return identName(lit, pos)
+}
+
+// identName returns the name referenced by an identifier literal. Quotes are
+// removed from a single name. Names qualified by a measurement, such as
+// "cpu"."value", are kept as written so they can be normalized with the
+// measurement. Returns an error if any part of the name is blank.
+func identName(lit string, pos Pos) (string, error) {
+	segments, err := SplitIdent(lit)
+	if err != nil {
+		return "", &ParseError{Message: "invalid identifier: " + lit, Pos: pos}
+	}
+	for _, segment := range segments {
+		if segment == "" {
+			return "", &ParseError{Message: "empty identifier: " + lit, Pos: pos}
+		}
+	}
+	if len(segments) > 1 {
+		return lit, nil
+	}
+	return segments[0], nil
+}
+
 // parseIdentList parses a comma delimited list of identifiers.
 func (p *Parser) parseIdentList() ([]string, error) {
 	// Parse first (required) identifier.
@@ -470,11 +941,11 @@ func (p *Parser) parseGrantStatement() (*GrantStatement, error) {
 	tok, pos, lit := p.scanIgnoreWhitespace()
 	if tok == ON {
 		// Parse the name of the thing we're granting a privilege to use.
-		lit, err := p.parseIdent()
+		ident, err := p.parseIdent()
 		if err != nil {
 			return nil, err
 		}
-		stmt.On = lit
+		stmt.On = ident
 
 		tok, pos, lit = p.scanIgnoreWhitespace()
 	} else if priv != AllPrivileges {
@@ -483,6 +954,24 @@ func (p *Parser) parseGrantStatement() (*GrantStatement, error) {
 		return nil, newParseError(tokstr(tok, lit), []string{"ON"}, pos)
 	}
 
+	// Parse optional measurements the writes are restricted to.
+	isNot := tok == IDENT && strings.ToUpper(lit) == "NOT"
+	if stmt.On != "" && (tok == MEASUREMENTS || isNot) {
+		if priv == ReadPrivilege {
+			return nil, &ParseError{Message: "measurements can only be restricted for WRITE or ALL", Pos: pos}
+		}
+		if isNot {
+			stmt.Except = true
+			if tok, pos, lit = p.scanIgnoreWhitespace(); tok != MEASUREMENTS {
+				return nil, newParseError(tokstr(tok, lit), []string{"MEASUREMENTS"}, pos)
+			}
+		}
+		if stmt.Measurements, err = p.parseIdentList(); err != nil {
+			return nil, err
+		}
+		tok, pos, lit = p.scanIgnoreWhitespace()
+	}
+
 	// Check for required TO token.
 	if tok != TO {
 		return nil, newParseError(tokstr(tok, lit), []string{"TO"}, pos)
@@ -680,6 +1169,18 @@ func (p *Parser) parseShowMeasurementsStatement() (*ShowMeasurementsStatement, e
 	stmt := &ShowMeasurementsStatement{}
 	var err error
 
+	// Parse optional "WITH MEASUREMENT <op> <name|/regex/>".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == WITH {
+		if err := p.parseTokens([]Token{MEASUREMENT}); err != nil {
+			return nil, err
+		}
+		if stmt.MeasurementOp, stmt.Measurement, err = p.parseMeasurementFilter(); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
+
 	// Parse condition: "WHERE EXPR".
 	if stmt.Condition, err = p.parseCondition(); err != nil {
 		return nil, err
@@ -703,6 +1204,29 @@ func (p *Parser) parseShowMeasurementsStatement() (*ShowMeasurementsStatement, e
 	return stmt, nil
 }
 
+// parseMeasurementFilter parses the operator and the measurement name or
+// regular expression it's compared with.
+func (p *Parser) parseMeasurementFilter() (Token, Expr, error) {
+	op, pos, lit := p.scanIgnoreWhitespace()
+	switch op {
+	case EQ, NEQ:
+		ident, err := p.parseIdentName()
+		if err != nil {
+			return ILLEGAL, nil, err
+		}
+		return op, &VarRef{Val: ident}, nil
+	case EQREGEX, NEQREGEX:
+		p.consumeWhitespace()
+		re, err := p.parseRegex()
+		if err != nil {
+			return ILLEGAL, nil, err
+		}
+		return op, re, nil
+	default:
+		return ILLEGAL, nil, newParseError(tokstr(op, lit), []string{"=", "!=", "=~", "!~"}, pos)
+	}
+}
+
 // parseShowRetentionPoliciesStatement parses a string and returns a ShowRetentionPoliciesStatement.
 // This function assumes the "SHOW RETENTION POLICIES" tokens have been consumed.
 func (p *Parser) parseShowRetentionPoliciesStatement() (*ShowRetentionPoliciesStatement, error) {
@@ -800,8 +1324,6 @@ func (p *Parser) parseShowTagValuesStatement() (*ShowTagValuesStatement, error)
 
 // parseTagKeys parses a string and returns a list of tag keys.
 func (p *Parser) parseTagKeys() ([]string, error) {
-	var err error
-
 	// Parse required WITH KEY tokens.
 	if err := p.parseTokens([]Token{WITH, KEY}); err != nil {
 		return nil, err
@@ -817,8 +1339,17 @@ func (p *Parser) parseTagKeys() ([]string, error) {
 		}
 
 		// Parse tag key list.
-		if tagKeys, err = p.parseIdentList(); err != nil {
-			return nil, err
+		for {
+			key, err := p.parseIdentName()
+			if err != nil {
+				return nil, err
+			}
+			tagKeys = append(tagKeys, key)
+
+			if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+				p.unscan()
+				break
+			}
 		}
 
 		// Parse required ) token.
@@ -827,11 +1358,11 @@ func (p *Parser) parseTagKeys() ([]string, error) {
 		}
 	} else if tok == EQ {
 		// Parse required tag key.
-		ident, err := p.parseIdent()
+		key, err := p.parseIdentName()
 		if err != nil {
 			return nil, err
 		}
-		tagKeys = append(tagKeys, ident)
+		tagKeys = append(tagKeys, key)
 	} else {
 		return nil, newParseError(tokstr(tok, lit), []string{"IN", "="}, pos)
 	}
@@ -839,6 +1370,68 @@ func (p *Parser) parseTagKeys() ([]string, error) {
 	return tagKeys, nil
 }
 
+// parseShowQuotasStatement parses a string and returns a ShowQuotasStatement.
+// This function assumes the "SHOW QUOTAS" tokens have already been consumed.
+func (p *Parser) parseShowQuotasStatement() (*ShowQuotasStatement, error) {
+	return &ShowQuotasStatement{}, nil
+}
+
+// parseShowShardGroupsStatement parses a string and returns a ShowShardGroupsStatement.
+// This function assumes the "SHOW SHARD GROUPS" tokens have already been consumed.
+func (p *Parser) parseShowShardGroupsStatement() (*ShowShardGroupsStatement, error) {
+	return &ShowShardGroupsStatement{}, nil
+}
+
+// parseDropShardStatement parses a string and returns a DropShardStatement.
+// This function assumes the "DROP SHARD" tokens have already been consumed.
+func (p *Parser) parseDropShardStatement() (*DropShardStatement, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return nil, newParseError(tokstr(tok, lit), []string{"number"}, pos)
+	}
+
+	id, err := strconv.ParseUint(lit, 10, 64)
+	if err != nil {
+		return nil, &ParseError{Message: err.Error(), Pos: pos}
+	}
+	return &DropShardStatement{ID: id}, nil
+}
+
+// parseTruncateShardsStatement parses a string and returns a TruncateShardsStatement.
+// This function assumes the "TRUNCATE" token has already been consumed.
+func (p *Parser) parseTruncateShardsStatement() (*TruncateShardsStatement, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "SHARDS" {
+		return nil, newParseError(tokstr(tok, lit), []string{"SHARDS"}, pos)
+	}
+
+	t, err := p.parseTime()
+	if err != nil {
+		return nil, err
+	}
+	return &TruncateShardsStatement{Time: t}, nil
+}
+
+// parseShowShardsStatement parses a string and returns a ShowShardsStatement.
+// This function assumes the "SHOW SHARDS" tokens have already been consumed.
+func (p *Parser) parseShowShardsStatement() (*ShowShardsStatement, error) {
+	return &ShowShardsStatement{}, nil
+}
+
+// parseShowUsageStatement parses a string and returns a ShowUsageStatement.
+// This function assumes the "SHOW USAGE" tokens have already been consumed.
+func (p *Parser) parseShowUsageStatement() (*ShowUsageStatement, error) {
+	return &ShowUsageStatement{}, nil
+}
+
+// parseResetUsageStatement parses a string and returns a ResetUsageStatement.
+// This function assumes the "RESET" token has already been consumed.
+func (p *Parser) parseResetUsageStatement() (*ResetUsageStatement, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "USAGE" {
+		return nil, newParseError(tokstr(tok, lit), []string{"USAGE"}, pos)
+	}
+	return &ResetUsageStatement{}, nil
+}
+
 // parseShowUsersStatement parses a string and returns a ShowUsersStatement.
 // This function assumes the "SHOW USERS" tokens have been consumed.
 func (p *Parser) parseShowUsersStatement() (*ShowUsersStatement, error) {
@@ -1010,9 +1603,15 @@ func (p *Parser) parseCreateContinuousQueryStatement() (*CreateContinuousQuerySt
 
 // parseCreateDatabaseStatement parses a string and returns a CreateDatabaseStatement.
 // This function assumes the "CREATE DATABASE" tokens have already been consumed.
-func (p *Parser) parseCreateDatabaseStatement() (*CreateDatabaseStatement, error) {
+func (p *Parser) parseCreateDatabaseStatement() (Statement, error) {
 	stmt := &CreateDatabaseStatement{}
 
+	// Parse optional IF NOT EXISTS clause.
+	var err error
+	if stmt.IfNotExists, err = p.parseIfNotExists(); err != nil {
+		return nil, err
+	}
+
 	// Parse the name of the database to be created.
 	lit, err := p.parseIdent()
 	if err != nil {
@@ -1020,6 +1619,74 @@ func (p *Parser) parseCreateDatabaseStatement() (*CreateDatabaseStatement, error
 	}
 	stmt.Name = lit
 
+	// Parse optional retention policy options: "WITH DURATION x REPLICATION n NAME rp"
+	// or the database to clone: "FROM db [WITH DATA]".
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FROM {
+		return p.parseCloneDatabaseStatement(stmt.Name, stmt.IfNotExists)
+	} else if tok != WITH {
+		p.unscan()
+		return stmt, nil
+	}
+	stmt.RetentionPolicyCreate = true
+
+	// Loop through option tokens. NAME is matched as an identifier so that
+	// "name" can still be used as a bare measurement, tag or field key.
+	for i := 0; i < 3; i++ {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		switch {
+		case tok == DURATION && stmt.RetentionPolicyDuration == nil:
+			d, err := p.parseDuration()
+			if err != nil {
+				return nil, err
+			}
+			stmt.RetentionPolicyDuration = &d
+		case tok == REPLICATION && stmt.RetentionPolicyReplication == nil:
+			n, err := p.parseInt(1, math.MaxInt32)
+			if err != nil {
+				return nil, err
+			}
+			stmt.RetentionPolicyReplication = &n
+		case tok == IDENT && strings.ToUpper(lit) == "NAME" && stmt.RetentionPolicyName == "":
+			ident, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			stmt.RetentionPolicyName = ident
+		default:
+			if i < 1 {
+				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "REPLICATION", "NAME"}, pos)
+			}
+			p.unscan()
+			return stmt, nil
+		}
+	}
+
+	return stmt, nil
+}
+
+// parseCloneDatabaseStatement parses a string and returns a CloneDatabaseStatement.
+// This function assumes the CREATE DATABASE name FROM tokens have already been consumed.
+func (p *Parser) parseCloneDatabaseStatement(name string, ifNotExists bool) (*CloneDatabaseStatement, error) {
+	stmt := &CloneDatabaseStatement{Name: name, IfNotExists: ifNotExists}
+
+	// Parse the name of the database to be cloned.
+	ident, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Source = ident
+
+	// Parse the optional WITH DATA clause. DATA is matched as an identifier so
+	// that it can still be used as a bare measurement, tag or field key.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != WITH {
+		p.unscan()
+		return stmt, nil
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToUpper(lit) != "DATA" {
+		return nil, newParseError(tokstr(tok, lit), []string{"DATA"}, pos)
+	}
+	stmt.Data = true
+
 	return stmt, nil
 }
 
@@ -1028,6 +1695,12 @@ func (p *Parser) parseCreateDatabaseStatement() (*CreateDatabaseStatement, error
 func (p *Parser) parseDropDatabaseStatement() (*DropDatabaseStatement, error) {
 	stmt := &DropDatabaseStatement{}
 
+	// Parse optional IF EXISTS clause.
+	var err error
+	if stmt.IfExists, err = p.parseIfExists(); err != nil {
+		return nil, err
+	}
+
 	// Parse the name of the database to be dropped.
 	lit, err := p.parseIdent()
 	if err != nil {
@@ -1043,6 +1716,12 @@ func (p *Parser) parseDropDatabaseStatement() (*DropDatabaseStatement, error) {
 func (p *Parser) parseDropRetentionPolicyStatement() (*DropRetentionPolicyStatement, error) {
 	stmt := &DropRetentionPolicyStatement{}
 
+	// Parse optional IF EXISTS clause.
+	var err error
+	if stmt.IfExists, err = p.parseIfExists(); err != nil {
+		return nil, err
+	}
+
 	// Parse the policy name.
 	ident, err := p.parseIdent()
 	if err != nil {
@@ -1068,6 +1747,12 @@ func (p *Parser) parseDropRetentionPolicyStatement() (*DropRetentionPolicyStatem
 func (p *Parser) parseCreateUserStatement() (*CreateUserStatement, error) {
 	stmt := &CreateUserStatement{}
 
+	// Parse optional IF NOT EXISTS clause.
+	var err error
+	if stmt.IfNotExists, err = p.parseIfNotExists(); err != nil {
+		return nil, err
+	}
+
 	// Parse name of the user to be created.
 	ident, err := p.parseIdent()
 	if err != nil {
@@ -1107,6 +1792,12 @@ func (p *Parser) parseCreateUserStatement() (*CreateUserStatement, error) {
 func (p *Parser) parseDropUserStatement() (*DropUserStatement, error) {
 	stmt := &DropUserStatement{}
 
+	// Parse optional IF EXISTS clause.
+	var err error
+	if stmt.IfExists, err = p.parseIfExists(); err != nil {
+		return nil, err
+	}
+
 	// Parse the name of the user to be dropped.
 	lit, err := p.parseIdent()
 	if err != nil {
@@ -1117,6 +1808,64 @@ func (p *Parser) parseDropUserStatement() (*DropUserStatement, error) {
 	return stmt, nil
 }
 
+// parseCreateTokenStatement parses a string and returns a CreateTokenStatement.
+// This function assumes the "CREATE TOKEN" tokens have already been consumed.
+func (p *Parser) parseCreateTokenStatement() (*CreateTokenStatement, error) {
+	stmt := &CreateTokenStatement{Privileges: make(map[string]Privilege)}
+
+	// Parse name of the token to be created.
+	ident, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = ident
+
+	// Consume "WITH" token.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != WITH {
+		return nil, newParseError(tokstr(tok, lit), []string{"WITH"}, pos)
+	}
+
+	// Parse a comma-separated list of "<privilege> ON <database>".
+	// "ALL PRIVILEGES" on its own grants all privileges on the cluster.
+	for {
+		priv, err := p.parsePrivilege()
+		if err != nil {
+			return nil, err
+		}
+
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok != ON {
+			if priv == AllPrivileges && len(stmt.Privileges) == 0 {
+				p.unscan()
+				stmt.Admin = true
+				return stmt, nil
+			}
+			return nil, newParseError(tokstr(tok, lit), []string{"ON"}, pos)
+		}
+
+		name, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Privileges[name] = priv
+
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			return stmt, nil
+		}
+	}
+}
+
+// parseDropTokenStatement parses a string and returns a DropTokenStatement.
+// This function assumes the "DROP TOKEN" tokens have already been consumed.
+func (p *Parser) parseDropTokenStatement() (*DropTokenStatement, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return &DropTokenStatement{Name: name}, nil
+}
+
 // parseRetentionPolicy parses a string and returns a retention policy name.
 // This function assumes the "WITH" token has already been consumed.
 func (p *Parser) parseRetentionPolicy() (name string, dfault bool, err error) {
@@ -1148,6 +1897,68 @@ func (p *Parser) parseRetentionPolicy() (name string, dfault bool, err error) {
 	return
 }
 
+// parseCreateQueryStatement parses a string and returns a CreateQueryStatement.
+// This function assumes the "CREATE QUERY" tokens have already been consumed.
+func (p *Parser) parseCreateQueryStatement() (*CreateQueryStatement, error) {
+	stmt := &CreateQueryStatement{}
+
+	// Read the name of the query to store.
+	ident, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = ident
+
+	// Read the optional database name.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == ON {
+		if stmt.Database, err = p.parseIdent(); err != nil {
+			return nil, err
+		}
+		tok, pos, lit = p.scanIgnoreWhitespace()
+	}
+
+	// Expect an "AS SELECT" clause.
+	if tok != AS {
+		return nil, newParseError(tokstr(tok, lit), []string{"ON", "AS"}, pos)
+	} else if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SELECT {
+		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+	}
+
+	// Read the select statement to be stored.
+	source, err := p.parseSelectStatement(targetNotRequired)
+	if err != nil {
+		return nil, err
+	}
+	stmt.Source = source
+
+	return stmt, nil
+}
+
+// parseDropQueryStatement parses a string and returns a DropQueryStatement.
+// This function assumes the "DROP QUERY" tokens have already been consumed.
+func (p *Parser) parseDropQueryStatement() (*DropQueryStatement, error) {
+	stmt := &DropQueryStatement{}
+
+	// Read the name of the query to drop.
+	ident, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = ident
+
+	// Read the optional database name.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok != ON {
+		p.unscan()
+		return stmt, nil
+	}
+	if stmt.Database, err = p.parseIdent(); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
 // parseDropContinuousQueriesStatement parses a string and returns a DropContinuousQueryStatement.
 // This function assumes the "DROP CONTINUOUS" tokens have already been consumed.
 func (p *Parser) parseDropContinuousQueryStatement() (*DropContinuousQueryStatement, error) {
@@ -1172,13 +1983,6 @@ func (p *Parser) parseDropContinuousQueryStatement() (*DropContinuousQueryStatem
 func (p *Parser) parseFields() (Fields, error) {
 	var fields Fields
 
-	// Check for "*" (i.e., "all fields")
-	if tok, _, _ := p.scanIgnoreWhitespace(); tok == MUL {
-		fields = append(fields, &Field{&Wildcard{}, ""})
-		return fields, nil
-	}
-	p.unscan()
-
 	for {
 		// Parse the field.
 		f, err := p.parseField()
@@ -1231,7 +2035,7 @@ func (p *Parser) parseAlias() (string, error) {
 	}
 
 	// Then we should have the alias identifier.
-	lit, err := p.parseIdent()
+	lit, err := p.parseIdentName()
 	if err != nil {
 		return "", err
 	}
@@ -1534,7 +2338,11 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 			return p.parseCall(lit)
 		}
 		p.unscan()
-		return &VarRef{Val: lit}, nil
+		name, err := identName(lit, pos)
+		if err != nil {
+			return nil, err
+		}
+		return &VarRef{Val: name}, nil
 	case STRING:
 		// If literal looks like a date time then parse it as a time literal.
 		if isDateTimeString(lit) {
@@ -1564,11 +2372,13 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 		return &NumberLiteral{Val: v}, nil
 	case TRUE, FALSE:
 		return &BooleanLiteral{Val: (tok == TRUE)}, nil
+	case BOUNDPARAM:
+		return &BoundParameter{Name: lit}, nil
 	case DURATION_VAL:
 		v, _ := ParseDuration(lit)
 		return &DurationLiteral{Val: v}, nil
 	case MUL:
-		return &Wildcard{}, nil
+		return p.parseWildcard()
 	case REGEX:
 		re, err := regexp.Compile(lit)
 		if err != nil {
@@ -1602,6 +2412,21 @@ func (p *Parser) parseRegex() (Expr, error) {
 	return &RegexLiteral{Val: re}, nil
 }
 
+// parseWildcard parses the optional "::field" or "::tag" type of a wildcard
+// whose "*" has already been read.
+func (p *Parser) parseWildcard() (*Wildcard, error) {
+	if tok, _, _ := p.scan(); tok != DOUBLECOLON {
+		p.unscan()
+		return &Wildcard{}, nil
+	}
+
+	tok, pos, lit := p.scan()
+	if tok != FIELD && tok != TAG {
+		return nil, newParseError(tokstr(tok, lit), []string{"FIELD", "TAG"}, pos)
+	}
+	return &Wildcard{Type: tok}, nil
+}
+
 // parseCall parses a function call.
 // This function assumes the function name and LPAREN have been consumed.
 func (p *Parser) parseCall(name string) (*Call, error) {
@@ -57,6 +57,18 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT typed wildcards
+		{
+			s: `SELECT *::tag, *::field FROM myseries`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					{Expr: &influxql.Wildcard{Type: influxql.TAG}},
+					{Expr: &influxql.Wildcard{Type: influxql.FIELD}},
+				},
+				Source: &influxql.Measurement{Name: "myseries"},
+			},
+		},
+
 		// SELECT statement
 		{
 			s: `SELECT field1, field2 ,field3 AS field_x FROM myseries WHERE host = 'hosta.influxdb.org' GROUP BY 10h ORDER BY ASC LIMIT 20 OFFSET 10;`,
@@ -83,6 +95,26 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT statement with quoted identifiers
+		{
+			s: `SELECT "cpu load", "select" AS "my, value" FROM cpu WHERE "host name" = 'a\'b' GROUP BY "data center"`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					{Expr: &influxql.VarRef{Val: "cpu load"}},
+					{Expr: &influxql.VarRef{Val: "select"}, Alias: "my, value"},
+				},
+				Source: &influxql.Measurement{Name: "cpu"},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "host name"},
+					RHS: &influxql.StringLiteral{Val: "a'b"},
+				},
+				Dimensions: []*influxql.Dimension{
+					{Expr: &influxql.VarRef{Val: "data center"}},
+				},
+			},
+		},
+
 		// SELECT statement with JOIN
 		{
 			s: `SELECT field1 FROM join(aa,"bb", cc) JOIN cc`,
@@ -219,6 +251,29 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SHOW MEASUREMENTS WITH MEASUREMENT
+		{
+			s: `SHOW MEASUREMENTS WITH MEASUREMENT =~ /^cpu/ WHERE region = 'uswest' LIMIT 10 OFFSET 20`,
+			stmt: &influxql.ShowMeasurementsStatement{
+				MeasurementOp: influxql.EQREGEX,
+				Measurement:   &influxql.RegexLiteral{Val: regexp.MustCompile(`^cpu`)},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "region"},
+					RHS: &influxql.StringLiteral{Val: "uswest"},
+				},
+				Limit:  10,
+				Offset: 20,
+			},
+		},
+		{
+			s: `SHOW MEASUREMENTS WITH MEASUREMENT = cpu`,
+			stmt: &influxql.ShowMeasurementsStatement{
+				MeasurementOp: influxql.EQ,
+				Measurement:   &influxql.VarRef{Val: "cpu"},
+			},
+		},
+
 		// SHOW RETENTION POLICIES
 		{
 			s: `SHOW RETENTION POLICIES mydb`,
@@ -319,7 +374,7 @@ func TestParser_ParseStatement(t *testing.T) {
 		{
 			s: `SHOW TAG VALUES WITH KEY = "host" WHERE region = 'uswest'`,
 			stmt: &influxql.ShowTagValuesStatement{
-				TagKeys: []string{`"host"`},
+				TagKeys: []string{`host`},
 				Condition: &influxql.BinaryExpr{
 					Op:  influxql.EQ,
 					LHS: &influxql.VarRef{Val: "region"},
@@ -328,6 +383,90 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SHOW QUOTAS
+		{
+			s:    `SHOW QUOTAS`,
+			stmt: &influxql.ShowQuotasStatement{},
+		},
+
+		// SHOW SHARD GROUPS
+		{
+			s:    `SHOW SHARD GROUPS`,
+			stmt: &influxql.ShowShardGroupsStatement{},
+		},
+
+		// DROP SHARD
+		{
+			s:    `DROP SHARD 12`,
+			stmt: &influxql.DropShardStatement{ID: 12},
+		},
+
+		// TRUNCATE SHARDS
+		{
+			s:    `TRUNCATE SHARDS '2015-01-02T03:04:05Z'`,
+			stmt: &influxql.TruncateShardsStatement{Time: mustParseTime("2015-01-02T03:04:05Z")},
+		},
+
+		// SHOW SHARDS
+		{
+			s:    `SHOW SHARDS`,
+			stmt: &influxql.ShowShardsStatement{},
+		},
+
+		// SHOW DATA NODES
+		{
+			s:    `SHOW DATA NODES`,
+			stmt: &influxql.ShowDataNodesStatement{},
+		},
+
+		// ALTER DATA NODE
+		{
+			s: `ALTER DATA NODE 2 SET rack = 'r1', zone = ''`,
+			stmt: &influxql.AlterDataNodeStatement{
+				ID:     2,
+				Labels: map[string]string{"rack": "r1", "zone": ""},
+			},
+		},
+
+		// CREATE TOKEN
+		{
+			s: `CREATE TOKEN collector WITH WRITE ON db0, READ ON db1`,
+			stmt: &influxql.CreateTokenStatement{
+				Name:       "collector",
+				Privileges: map[string]influxql.Privilege{"db0": influxql.WritePrivilege, "db1": influxql.ReadPrivilege},
+			},
+		},
+
+		// CREATE TOKEN with all privileges on the cluster
+		{
+			s:    `CREATE TOKEN ops WITH ALL PRIVILEGES`,
+			stmt: &influxql.CreateTokenStatement{Name: "ops", Admin: true, Privileges: map[string]influxql.Privilege{}},
+		},
+
+		// DROP TOKEN
+		{
+			s:    `DROP TOKEN collector`,
+			stmt: &influxql.DropTokenStatement{Name: "collector"},
+		},
+
+		// SHOW TOKENS
+		{
+			s:    `SHOW TOKENS`,
+			stmt: &influxql.ShowTokensStatement{},
+		},
+
+		// SHOW USAGE
+		{
+			s:    `SHOW USAGE`,
+			stmt: &influxql.ShowUsageStatement{},
+		},
+
+		// RESET USAGE
+		{
+			s:    `RESET USAGE`,
+			stmt: &influxql.ResetUsageStatement{},
+		},
+
 		// SHOW USERS
 		{
 			s:    `SHOW USERS`,
@@ -385,6 +524,55 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: &influxql.ShowContinuousQueriesStatement{},
 		},
 
+		// SHOW QUERIES statement
+		{
+			s:    `SHOW QUERIES`,
+			stmt: &influxql.ShowQueriesStatement{},
+		},
+
+		// CREATE QUERY statement
+		{
+			s: `CREATE QUERY dashboards_cpu AS SELECT mean(value) FROM cpu WHERE host = $host AND time > $start GROUP BY region`,
+			stmt: &influxql.CreateQueryStatement{
+				Name: "dashboards_cpu",
+				Source: &influxql.SelectStatement{
+					Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "mean", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}},
+					Source: &influxql.Measurement{Name: "cpu"},
+					Condition: &influxql.BinaryExpr{
+						Op:  influxql.AND,
+						LHS: &influxql.BinaryExpr{Op: influxql.EQ, LHS: &influxql.VarRef{Val: "host"}, RHS: &influxql.BoundParameter{Name: "host"}},
+						RHS: &influxql.BinaryExpr{Op: influxql.GT, LHS: &influxql.VarRef{Val: "time"}, RHS: &influxql.BoundParameter{Name: "start"}},
+					},
+					Dimensions: []*influxql.Dimension{{Expr: &influxql.VarRef{Val: "region"}}},
+				},
+			},
+		},
+
+		// CREATE QUERY ... ON statement
+		{
+			s: `CREATE QUERY myquery ON testdb AS SELECT value FROM cpu`,
+			stmt: &influxql.CreateQueryStatement{
+				Name:     "myquery",
+				Database: "testdb",
+				Source: &influxql.SelectStatement{
+					Fields: []*influxql.Field{{Expr: &influxql.VarRef{Val: "value"}}},
+					Source: &influxql.Measurement{Name: "cpu"},
+				},
+			},
+		},
+
+		// DROP QUERY statement
+		{
+			s:    `DROP QUERY myquery`,
+			stmt: &influxql.DropQueryStatement{Name: "myquery"},
+		},
+
+		// DROP QUERY ... ON statement
+		{
+			s:    `DROP QUERY myquery ON testdb`,
+			stmt: &influxql.DropQueryStatement{Name: "myquery", Database: "testdb"},
+		},
+
 		// CREATE CONTINUOUS QUERY ... INTO <measurement>
 		{
 			s: `CREATE CONTINUOUS QUERY myquery ON testdb BEGIN SELECT count() INTO measure1 FROM myseries GROUP BY time(5m) END`,
@@ -476,6 +664,29 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// CREATE DATABASE statement with retention policy options
+		{
+			s: `CREATE DATABASE testdb WITH DURATION 30d REPLICATION 2 NAME rp1`,
+			stmt: &influxql.CreateDatabaseStatement{
+				Name:                       "testdb",
+				RetentionPolicyCreate:      true,
+				RetentionPolicyDuration:    func() *time.Duration { d := 30 * 24 * time.Hour; return &d }(),
+				RetentionPolicyReplication: func() *int { n := 2; return &n }(),
+				RetentionPolicyName:        "rp1",
+			},
+		},
+
+		// CREATE DATABASE statement with some retention policy options
+		{
+			s: `CREATE DATABASE testdb WITH name rp1 DURATION INF`,
+			stmt: &influxql.CreateDatabaseStatement{
+				Name:                    "testdb",
+				RetentionPolicyCreate:   true,
+				RetentionPolicyDuration: func() *time.Duration { d := time.Duration(0); return &d }(),
+				RetentionPolicyName:     "rp1",
+			},
+		},
+
 		// CREATE USER statement
 		{
 			s: `CREATE USER testuser WITH PASSWORD 'pwd1337'`,
@@ -507,6 +718,76 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: &influxql.DropDatabaseStatement{Name: "testdb"},
 		},
 
+		// DROP DATABASE IF EXISTS statement
+		{
+			s:    `DROP DATABASE IF EXISTS testdb`,
+			stmt: &influxql.DropDatabaseStatement{Name: "testdb", IfExists: true},
+		},
+
+		// CREATE DATABASE FROM statement
+		{
+			s: `CREATE DATABASE staging FROM production WITH DATA`,
+			stmt: &influxql.CloneDatabaseStatement{
+				Name:   "staging",
+				Source: "production",
+				Data:   true,
+			},
+		},
+
+		// CREATE DATABASE IF NOT EXISTS FROM statement without data
+		{
+			s: `CREATE DATABASE IF NOT EXISTS staging FROM production`,
+			stmt: &influxql.CloneDatabaseStatement{
+				Name:        "staging",
+				Source:      "production",
+				IfNotExists: true,
+			},
+		},
+
+		// CREATE DATABASE IF NOT EXISTS statement
+		{
+			s:    `CREATE DATABASE IF NOT EXISTS testdb`,
+			stmt: &influxql.CreateDatabaseStatement{Name: "testdb", IfNotExists: true},
+		},
+
+		// CREATE USER IF NOT EXISTS statement
+		{
+			s: `CREATE USER IF NOT EXISTS testuser WITH PASSWORD 'pwd1337'`,
+			stmt: &influxql.CreateUserStatement{
+				Name:        "testuser",
+				IfNotExists: true,
+				Password:    "pwd1337",
+			},
+		},
+
+		// DROP USER IF EXISTS statement
+		{
+			s:    `DROP USER IF EXISTS jdoe`,
+			stmt: &influxql.DropUserStatement{Name: "jdoe", IfExists: true},
+		},
+
+		// CREATE RETENTION POLICY IF NOT EXISTS
+		{
+			s: `CREATE RETENTION POLICY IF NOT EXISTS policy1 ON testdb DURATION 1h REPLICATION 2`,
+			stmt: &influxql.CreateRetentionPolicyStatement{
+				Name:        "policy1",
+				IfNotExists: true,
+				Database:    "testdb",
+				Duration:    time.Hour,
+				Replication: 2,
+			},
+		},
+
+		// DROP RETENTION POLICY IF EXISTS
+		{
+			s: `DROP RETENTION POLICY IF EXISTS policy1 ON mydb`,
+			stmt: &influxql.DropRetentionPolicyStatement{
+				Name:     "policy1",
+				Database: "mydb",
+				IfExists: true,
+			},
+		},
+
 		// DROP MEASUREMENT statement
 		{
 			s:    `DROP MEASUREMENT cpu`,
@@ -548,6 +829,29 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// GRANT WRITE with allowed measurements
+		{
+			s: `GRANT WRITE ON testdb MEASUREMENTS cpu, mem TO jdoe`,
+			stmt: &influxql.GrantStatement{
+				Privilege:    influxql.WritePrivilege,
+				On:           "testdb",
+				Measurements: []string{"cpu", "mem"},
+				User:         "jdoe",
+			},
+		},
+
+		// GRANT ALL with denied measurements
+		{
+			s: `GRANT ALL ON testdb NOT MEASUREMENTS billing TO jdoe`,
+			stmt: &influxql.GrantStatement{
+				Privilege:    influxql.AllPrivileges,
+				On:           "testdb",
+				Measurements: []string{"billing"},
+				Except:       true,
+				User:         "jdoe",
+			},
+		},
+
 		// GRANT ALL
 		{
 			s: `GRANT ALL ON testdb TO jdoe`,
@@ -660,6 +964,32 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// CREATE RETENTION POLICY ... DUPLICATES
+		{
+			s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 2m REPLICATION 4 DUPLICATES merge DEFAULT`,
+			stmt: &influxql.CreateRetentionPolicyStatement{
+				Name:        "policy1",
+				Database:    "testdb",
+				Duration:    2 * time.Minute,
+				Replication: 4,
+				Duplicates:  "merge",
+				Default:     true,
+			},
+		},
+
+		// CREATE RETENTION POLICY ... FORCE
+		{
+			s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 2m REPLICATION 4 FORCE DEFAULT`,
+			stmt: &influxql.CreateRetentionPolicyStatement{
+				Name:        "policy1",
+				Database:    "testdb",
+				Duration:    2 * time.Minute,
+				Replication: 4,
+				Force:       true,
+				Default:     true,
+			},
+		},
+
 		// ALTER RETENTION POLICY
 		{
 			s:    `ALTER RETENTION POLICY policy1 ON testdb DURATION 1m REPLICATION 4 DEFAULT`,
@@ -696,11 +1026,161 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: newAlterRetentionPolicyStatement("policy1", "testdb", -1, 4, false),
 		},
 
+		// ALTER RETENTION POLICY with only DURATION
+		{
+			s:    `ALTER RETENTION POLICY policy1 ON testdb DURATION 2h`,
+			stmt: newAlterRetentionPolicyStatement("policy1", "testdb", 2*time.Hour, -1, false),
+		},
+
+		// ALTER RETENTION POLICY with DUPLICATES
+		{
+			s: `ALTER RETENTION POLICY policy1 ON testdb DUPLICATES reject`,
+			stmt: &influxql.AlterRetentionPolicyStatement{
+				Name:       "policy1",
+				Database:   "testdb",
+				Duplicates: func() *string { s := "reject"; return &s }(),
+			},
+		},
+
+		// ALTER RETENTION POLICY with FORCE
+		{
+			s: `ALTER RETENTION POLICY policy1 ON testdb REPLICATION 4 FORCE`,
+			stmt: &influxql.AlterRetentionPolicyStatement{
+				Name:        "policy1",
+				Database:    "testdb",
+				Replication: func() *int { n := 4; return &n }(),
+				Force:       true,
+			},
+		},
+
+		// ALTER RETENTION POLICY with RENAME TO
+		{
+			s: `ALTER RETENTION POLICY policy1 ON testdb DEFAULT RENAME TO policy2`,
+			stmt: &influxql.AlterRetentionPolicyStatement{
+				Name:     "policy1",
+				Database: "testdb",
+				Default:  true,
+				NewName:  func() *string { s := "policy2"; return &s }(),
+			},
+		},
+
+		// ALTER DATABASE
+		{
+			s: `ALTER DATABASE db0 RENAME TO db1`,
+			stmt: &influxql.AlterDatabaseStatement{
+				Name:    "db0",
+				NewName: "db1",
+			},
+		},
+		{
+			s: `ALTER DATABASE dev WRITE LIMIT 1000 BURST 5000`,
+			stmt: &influxql.AlterDatabaseStatement{
+				Name:       "dev",
+				WriteLimit: func() *int { n := 1000; return &n }(),
+				WriteBurst: 5000,
+			},
+		},
+		{
+			s: `ALTER DATABASE dev WRITE LIMIT 0`,
+			stmt: &influxql.AlterDatabaseStatement{
+				Name:       "dev",
+				WriteLimit: func() *int { n := 0; return &n }(),
+			},
+		},
+		{
+			s: `ALTER DATABASE metrics NORMALIZE NAMES LOWERCASE CHARACTERS 'a-z0-9_.' LENGTH 64`,
+			stmt: &influxql.AlterDatabaseStatement{
+				Name:           "metrics",
+				NormalizeNames: true,
+				LowercaseNames: true,
+				NameCharacters: "a-z0-9_.",
+				MaxNameLength:  64,
+			},
+		},
+		{
+			s: `ALTER DATABASE metrics NORMALIZE NAMES`,
+			stmt: &influxql.AlterDatabaseStatement{
+				Name:           "metrics",
+				NormalizeNames: true,
+			},
+		},
+
+		// ALTER MEASUREMENT
+		{
+			s: `ALTER MEASUREMENT debug_events TTL 1d`,
+			stmt: &influxql.AlterMeasurementStatement{
+				Name: "debug_events",
+				TTL:  func() *time.Duration { d := 24 * time.Hour; return &d }(),
+			},
+		},
+
+		// ALTER MEASUREMENT with infinite TTL
+		{
+			s: `ALTER MEASUREMENT debug_events TTL INF`,
+			stmt: &influxql.AlterMeasurementStatement{
+				Name: "debug_events",
+				TTL:  func() *time.Duration { d := time.Duration(0); return &d }(),
+			},
+		},
+
+		// ALTER MEASUREMENT with metadata
+		{
+			s: `ALTER MEASUREMENT cpu SET description = 'CPU usage', display_name = 'CPU'`,
+			stmt: &influxql.AlterMeasurementStatement{
+				Name:     "cpu",
+				Metadata: map[string]string{"description": "CPU usage", "display_name": "CPU"},
+			},
+		},
+
+		// ALTER MEASUREMENT with TTL and metadata
+		{
+			s: `ALTER MEASUREMENT cpu SET unit = 'percent' TTL 7d`,
+			stmt: &influxql.AlterMeasurementStatement{
+				Name:     "cpu",
+				TTL:      func() *time.Duration { d := 7 * 24 * time.Hour; return &d }(),
+				Metadata: map[string]string{"unit": "percent"},
+			},
+		},
+
+		// ALTER FIELD
+		{
+			s: `ALTER FIELD value ON cpu TO Number`,
+			stmt: &influxql.AlterFieldStatement{
+				Name:        "value",
+				Measurement: "cpu",
+				Type:        influxql.Number,
+			},
+		},
+
+		// ALTER FIELD with metadata
+		{
+			s: `ALTER FIELD value ON cpu SET unit = 'percent', display_name = 'Usage'`,
+			stmt: &influxql.AlterFieldStatement{
+				Name:        "value",
+				Measurement: "cpu",
+				Metadata:    map[string]string{"unit": "percent", "display_name": "Usage"},
+			},
+		},
+
+		// ALTER FIELD with type and metadata
+		{
+			s: `ALTER FIELD value ON cpu TO number SET description = 'Percent of CPU in use'`,
+			stmt: &influxql.AlterFieldStatement{
+				Name:        "value",
+				Measurement: "cpu",
+				Type:        influxql.Number,
+				Metadata:    map[string]string{"description": "Percent of CPU in use"},
+			},
+		},
+
 		// Errors
 		{s: ``, err: `found EOF, expected SELECT at line 1, char 1`},
 		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 8`},
 		{s: `blah blah`, err: `found blah, expected SELECT at line 1, char 1`},
 		{s: `SELECT field1 X`, err: `found X, expected FROM at line 1, char 15`},
+		{s: `SELECT *::value FROM cpu`, err: `found value, expected FIELD, TAG at line 1, char 11`},
+		{s: `SELECT "" FROM cpu`, err: `empty identifier: "" at line 1, char 8`},
+		{s: `SELECT value FROM cpu GROUP BY "".host`, err: `empty identifier: "".host at line 1, char 32`},
 		{s: `SELECT field1 FROM "series" WHERE X +;`, err: `found ;, expected identifier, string, number, bool at line 1, char 38`},
 		{s: `SELECT field1 FROM myseries GROUP`, err: `found EOF, expected BY at line 1, char 35`},
 		{s: `SELECT field1 FROM myseries LIMIT`, err: `found EOF, expected number at line 1, char 35`},
@@ -724,15 +1204,30 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `DROP SERIES FROM`, err: `found EOF, expected identifier at line 1, char 18`},
 		{s: `DROP SERIES FROM src WHERE`, err: `found EOF, expected identifier, string, number, bool at line 1, char 28`},
 		{s: `SHOW CONTINUOUS`, err: `found EOF, expected QUERIES at line 1, char 17`},
+		{s: `SHOW MEASUREMENTS WITH MEASUREMENT cpu`, err: `found cpu, expected =, !=, =~, !~ at line 1, char 36`},
 		{s: `SHOW RETENTION`, err: `found EOF, expected POLICIES at line 1, char 16`},
 		{s: `SHOW RETENTION POLICIES`, err: `found EOF, expected identifier at line 1, char 25`},
-		{s: `SHOW FOO`, err: `found FOO, expected CONTINUOUS, DATABASES, FIELD, MEASUREMENTS, RETENTION, SERIES, TAG, USERS at line 1, char 6`},
+		{s: `SHOW FOO`, err: `found FOO, expected CONTINUOUS, DATA, DATABASES, FIELD, MEASUREMENTS, QUERIES, QUOTAS, RETENTION, SERIES, SHARD, SHARDS, TAG, TOKENS, USAGE, USERS at line 1, char 6`},
+		{s: `SHOW SHARD`, err: `found EOF, expected GROUPS at line 1, char 12`},
+		{s: `DROP SHARD foo`, err: `found foo, expected number at line 1, char 12`},
+		{s: `CREATE TOKEN collector`, err: `found EOF, expected WITH at line 1, char 24`},
+		{s: `CREATE TOKEN collector WITH WRITE`, err: `found EOF, expected ON at line 1, char 35`},
+		{s: `CREATE TOKEN collector WITH READ ON db0, ALL PRIVILEGES`, err: `found EOF, expected ON at line 1, char 57`},
+		{s: `TRUNCATE SHARDS`, err: `found EOF, expected time at line 1, char 17`},
+		{s: `TRUNCATE SHARDS 'foo'`, err: `unable to parse datetime at line 1, char 16`},
 		{s: `DROP CONTINUOUS`, err: `found EOF, expected QUERY at line 1, char 17`},
 		{s: `DROP CONTINUOUS QUERY`, err: `found EOF, expected identifier at line 1, char 23`},
 		{s: `CREATE CONTINUOUS`, err: `found EOF, expected QUERY at line 1, char 19`},
 		{s: `CREATE CONTINUOUS QUERY`, err: `found EOF, expected identifier at line 1, char 25`},
 		{s: `DROP FOO`, err: `found FOO, expected SERIES, CONTINUOUS, MEASUREMENT at line 1, char 6`},
+		{s: `CREATE QUERY`, err: `found EOF, expected identifier at line 1, char 14`},
+		{s: `CREATE QUERY myquery`, err: `found EOF, expected ON, AS at line 1, char 22`},
+		{s: `CREATE QUERY myquery ON testdb`, err: `found EOF, expected ON, AS at line 1, char 32`},
+		{s: `CREATE QUERY myquery AS DELETE FROM cpu`, err: `found DELETE, expected SELECT at line 1, char 25`},
+		{s: `DROP QUERY`, err: `found EOF, expected identifier at line 1, char 12`},
 		{s: `DROP DATABASE`, err: `found EOF, expected identifier at line 1, char 15`},
+		{s: `DROP DATABASE IF testdb`, err: `found testdb, expected EXISTS at line 1, char 18`},
+		{s: `CREATE DATABASE IF EXISTS testdb`, err: `found EXISTS, expected NOT at line 1, char 20`},
 		{s: `DROP RETENTION`, err: `found EOF, expected POLICY at line 1, char 16`},
 		{s: `DROP RETENTION POLICY`, err: `found EOF, expected identifier at line 1, char 23`},
 		{s: `DROP RETENTION POLICY "1h.cpu"`, err: `found EOF, expected ON at line 1, char 32`},
@@ -749,13 +1244,21 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `GRANT READ TO jdoe`, err: `found TO, expected ON at line 1, char 12`},
 		{s: `GRANT READ ON`, err: `found EOF, expected identifier at line 1, char 15`},
 		{s: `GRANT READ ON testdb`, err: `found EOF, expected TO at line 1, char 22`},
-		{s: `GRANT READ ON testdb TO`, err: `found EOF, expected identifier at line 1, char 25`}, {s: `GRANT`, err: `found EOF, expected READ, WRITE, ALL [PRIVILEGES] at line 1, char 7`},
+		{s: `GRANT READ ON testdb TO`, err: `found EOF, expected identifier at line 1, char 25`},
+		{s: `GRANT READ ON testdb MEASUREMENTS cpu TO jdoe`, err: `measurements can only be restricted for WRITE or ALL at line 1, char 22`},
+		{s: `GRANT WRITE ON testdb NOT cpu TO jdoe`, err: `found cpu, expected MEASUREMENTS at line 1, char 27`},
+		{s: `GRANT WRITE ON testdb MEASUREMENTS TO jdoe`, err: `found TO, expected identifier at line 1, char 36`}, {s: `GRANT`, err: `found EOF, expected READ, WRITE, ALL [PRIVILEGES] at line 1, char 7`},
 		{s: `REVOKE BOGUS`, err: `found BOGUS, expected READ, WRITE, ALL [PRIVILEGES] at line 1, char 8`},
 		{s: `REVOKE READ`, err: `found EOF, expected ON at line 1, char 13`},
 		{s: `REVOKE READ TO jdoe`, err: `found TO, expected ON at line 1, char 13`},
 		{s: `REVOKE READ ON`, err: `found EOF, expected identifier at line 1, char 16`},
 		{s: `REVOKE READ ON testdb`, err: `found EOF, expected FROM at line 1, char 23`},
 		{s: `REVOKE READ ON testdb FROM`, err: `found EOF, expected identifier at line 1, char 28`},
+		{s: `CREATE DATABASE staging FROM`, err: `found EOF, expected identifier at line 1, char 30`},
+		{s: `CREATE DATABASE staging FROM production WITH`, err: `found EOF, expected DATA at line 1, char 46`},
+		{s: `CREATE DATABASE testdb WITH`, err: `found EOF, expected DURATION, REPLICATION, NAME at line 1, char 29`},
+		{s: `CREATE DATABASE testdb WITH NAME`, err: `found EOF, expected identifier at line 1, char 34`},
+		{s: `CREATE DATABASE testdb WITH REPLICATION 0`, err: `invalid value 0: must be 1 <= n <= 2147483647 at line 1, char 41`},
 		{s: `CREATE RETENTION`, err: `found EOF, expected POLICY at line 1, char 18`},
 		{s: `CREATE RETENTION POLICY`, err: `found EOF, expected identifier at line 1, char 25`},
 		{s: `CREATE RETENTION POLICY policy1`, err: `found EOF, expected ON at line 1, char 33`},
@@ -768,11 +1271,42 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 3.14`, err: `number must be an integer at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 0`, err: `invalid value 0: must be 1 <= n <= 2147483647 at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION bad`, err: `found bad, expected number at line 1, char 67`},
-		{s: `ALTER`, err: `found EOF, expected RETENTION at line 1, char 7`},
+		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 1 DUPLICATES`, err: `found EOF, expected identifier at line 1, char 80`},
+		{s: `ALTER`, err: `found EOF, expected DATA, DATABASE, FIELD, MEASUREMENT, RETENTION at line 1, char 7`},
+		{s: `ALTER DATA NODE`, err: `found EOF, expected number at line 1, char 17`},
+		{s: `ALTER DATA NODE 2`, err: `found EOF, expected SET at line 1, char 18`},
+		{s: `ALTER DATA NODE 2 SET rack`, err: `found EOF, expected = at line 1, char 28`},
+		{s: `ALTER DATA NODE 2 SET rack = r1`, err: `found r1, expected string at line 1, char 30`},
+		{s: `SHOW DATA`, err: `found EOF, expected NODES at line 1, char 11`},
+		{s: `ALTER MEASUREMENT`, err: `found EOF, expected identifier at line 1, char 19`},
+		{s: `ALTER MEASUREMENT cpu`, err: `found EOF, expected TTL, SET at line 1, char 23`},
+		{s: `ALTER MEASUREMENT cpu SET`, err: `found EOF, expected unit, description, display_name at line 1, char 27`},
+		{s: `ALTER MEASUREMENT cpu SET color = 'red'`, err: `found color, expected unit, description, display_name at line 1, char 27`},
+		{s: `ALTER MEASUREMENT cpu SET unit`, err: `found EOF, expected = at line 1, char 32`},
+		{s: `ALTER MEASUREMENT cpu SET unit = percent`, err: `found percent, expected string at line 1, char 34`},
+		{s: `ALTER MEASUREMENT cpu TTL`, err: `found EOF, expected duration at line 1, char 27`},
+		{s: `ALTER DATABASE`, err: `found EOF, expected identifier at line 1, char 16`},
+		{s: `ALTER DATABASE db0`, err: `found EOF, expected NORMALIZE, RENAME, WRITE at line 1, char 20`},
+		{s: `ALTER DATABASE db0 NORMALIZE`, err: `found EOF, expected NAMES at line 1, char 30`},
+		{s: `ALTER DATABASE db0 NORMALIZE NAMES CHARACTERS a`, err: `found a, expected string at line 1, char 47`},
+		{s: `ALTER DATABASE db0 NORMALIZE NAMES LENGTH 0`, err: `invalid value 0: must be 1 <= n <= 2147483647 at line 1, char 43`},
+		{s: `ALTER DATABASE db0 WRITE`, err: `found EOF, expected LIMIT at line 1, char 26`},
+		{s: `ALTER DATABASE db0 WRITE LIMIT`, err: `found EOF, expected number at line 1, char 32`},
+		{s: `ALTER DATABASE db0 WRITE LIMIT -1`, err: `invalid value -1: must be 0 <= n <= 2147483647 at line 1, char 32`},
+		{s: `ALTER DATABASE db0 WRITE LIMIT 100 BURST`, err: `found EOF, expected number at line 1, char 42`},
+		{s: `ALTER DATABASE db0 RENAME`, err: `found EOF, expected TO at line 1, char 27`},
+		{s: `ALTER DATABASE db0 RENAME TO`, err: `found EOF, expected identifier at line 1, char 30`},
+		{s: `ALTER FIELD`, err: `found EOF, expected identifier at line 1, char 13`},
+		{s: `ALTER FIELD value`, err: `found EOF, expected ON at line 1, char 19`},
+		{s: `ALTER FIELD value ON cpu`, err: `found EOF, expected TO, SET at line 1, char 26`},
+		{s: `ALTER FIELD value ON cpu TO`, err: `found EOF, expected number, unsigned, boolean, string at line 1, char 29`},
+		{s: `ALTER FIELD value ON cpu TO integer`, err: `found integer, expected number, unsigned, boolean, string at line 1, char 29`},
 		{s: `ALTER RETENTION`, err: `found EOF, expected POLICY at line 1, char 17`},
 		{s: `ALTER RETENTION POLICY`, err: `found EOF, expected identifier at line 1, char 24`},
 		{s: `ALTER RETENTION POLICY policy1`, err: `found EOF, expected ON at line 1, char 32`}, {s: `ALTER RETENTION POLICY policy1 ON`, err: `found EOF, expected identifier at line 1, char 35`},
-		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, RETENTION, DEFAULT at line 1, char 42`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, REPLICATION, DUPLICATES, DEFAULT, RENAME at line 1, char 42`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 2 DURATION 2h`, err: `DURATION specified more than once at line 1, char 68`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb RENAME policy2`, err: `found policy2, expected TO at line 1, char 49`},
 	}
 
 	for i, tt := range tests {
@@ -804,6 +1338,7 @@ func TestParser_ParseExpr(t *testing.T) {
 		{s: `true`, expr: &influxql.BooleanLiteral{Val: true}},
 		{s: `false`, expr: &influxql.BooleanLiteral{Val: false}},
 		{s: `my_ident`, expr: &influxql.VarRef{Val: "my_ident"}},
+		{s: `$my_param`, expr: &influxql.BoundParameter{Name: "my_param"}},
 		{s: `'2000-01-01 00:00:00'`, expr: &influxql.TimeLiteral{Val: mustParseTime("2000-01-01T00:00:00Z")}},
 		{s: `'2000-01-01 00:00:00.232'`, expr: &influxql.TimeLiteral{Val: mustParseTime("2000-01-01T00:00:00.232Z")}},
 		{s: `'2000-01-32 00:00:00'`, err: `unable to parse datetime at line 1, char 1`},
@@ -1042,6 +1577,60 @@ func TestQuoteIdent(t *testing.T) {
 	}
 }
 
+// Ensure variable references are quoted only when they can't be parsed bare.
+func TestVarRef_String(t *testing.T) {
+	for i, tt := range []struct {
+		val string
+		s   string
+	}{
+		{`value`, `value`},
+		{`cpu.load`, `cpu.load`},
+		{`time`, `time`},
+		{``, `""`},
+		{`cpu load`, `"cpu load"`},
+		{`a,b`, `"a,b"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`select`, `"select"`},
+		{`FROM`, `"FROM"`},
+		{`1st`, `"1st"`},
+		{`_shards`, `_shards`},
+		{`duplicates`, `duplicates`},
+		{`not`, `not`},
+		{`quotas`, `quotas`},
+		{`reset`, `reset`},
+		{`usage`, `usage`},
+		{`cpu.`, `"cpu."`},
+		{`cpu..load`, `"cpu..load"`},
+		{`"db0"."rp0"."cpu"."value"`, `"db0"."rp0"."cpu"."value"`},
+	} {
+		if s := (&influxql.VarRef{Val: tt.val}).String(); tt.s != s {
+			t.Errorf("%d. %s: mismatch: %s != %s", i, tt.val, tt.s, s)
+		}
+	}
+}
+
+// Ensure statements with names that need quoting can be parsed from their
+// string representation.
+func TestStatement_String_QuotedNames(t *testing.T) {
+	for i, s := range []string{
+		`SELECT "cpu load", "select" AS "my, value" FROM cpu WHERE "host name" = 'a\'b' GROUP BY "data center"`,
+		`SHOW TAG VALUES FROM cpu WITH KEY = "data center"`,
+		`SHOW TAG VALUES FROM cpu WITH KEY IN (region, "data center")`,
+		`SHOW MEASUREMENTS WITH MEASUREMENT = "cpu load"`,
+	} {
+		stmt, err := influxql.NewParser(strings.NewReader(s)).ParseStatement()
+		if err != nil {
+			t.Fatalf("%d. %s: unexpected error: %s", i, s, err)
+		}
+		other, err := influxql.NewParser(strings.NewReader(stmt.String())).ParseStatement()
+		if err != nil {
+			t.Errorf("%d. %s: unexpected error: %s", i, stmt.String(), err)
+		} else if !reflect.DeepEqual(stmt, other) {
+			t.Errorf("%d. %s: mismatch:\n\nexp=%#v\n\ngot=%#v", i, stmt.String(), stmt, other)
+		}
+	}
+}
+
 // Ensure DropSeriesStatement can convert to a string
 func TestDropSeriesStatement_String(t *testing.T) {
 	var tests = []struct {
@@ -28,10 +28,10 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	ch0, pos := s.r.read()
 
 	// If we see whitespace then consume all contiguous whitespace.
-	// If we see a letter then consume as an ident or reserved word.
+	// If we see a letter or underscore then consume as an ident or reserved word.
 	if isWhitespace(ch0) {
 		return s.scanWhitespace()
-	} else if isLetter(ch0) {
+	} else if isLetter(ch0) || ch0 == '_' {
 		s.r.unread()
 		return s.scanIdent()
 	} else if isDigit(ch0) {
@@ -93,8 +93,19 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 		return RPAREN, pos, ""
 	case ',':
 		return COMMA, pos, ""
+	case '$':
+		if ch1, _ := s.r.read(); isIdentChar(ch1) {
+			s.r.unread()
+			return BOUNDPARAM, pos, ScanBareIdent(s.r)
+		}
+		s.r.unread()
 	case ';':
 		return SEMICOLON, pos, ""
+	case ':':
+		if ch1, _ := s.r.read(); ch1 == ':' {
+			return DOUBLECOLON, pos, ""
+		}
+		s.r.unread()
 	}
 
 	return ILLEGAL, pos, string(ch0)
@@ -299,6 +310,28 @@ func isDigit(ch rune) bool { return (ch >= '0' && ch <= '9') }
 // isIdentChar returns true if the rune that be used in a bare identifier.
 func isIdentChar(ch rune) bool { return isLetter(ch) || isDigit(ch) || ch == '_' }
 
+// IdentNeedsQuotes returns true if a name must be double quoted to be parsed
+// back as the same identifier. This is the case for keywords and for names
+// that are blank, don't start with a letter or underscore, or contain
+// characters other than letters, digits, underscores and single dots.
+func IdentNeedsQuotes(ident string) bool {
+	if ident == "" || Lookup(strings.ToLower(ident)) != IDENT {
+		return true
+	}
+	var prev rune
+	for i, ch := range ident {
+		if i == 0 && !isLetter(ch) && ch != '_' {
+			return true
+		} else if ch == '.' && prev == '.' {
+			return true
+		} else if ch != '.' && !isIdentChar(ch) {
+			return true
+		}
+		prev = ch
+	}
+	return prev == '.'
+}
+
 // bufScanner represents a wrapper for scanner to add a buffer.
 // It provides a fixed-length circular buffer that can be unread.
 type bufScanner struct {
@@ -506,8 +539,8 @@ func ScanString(r io.RuneScanner) (string, error) {
 				_, _ = buf.WriteRune('\n')
 			} else if ch1 == '\\' {
 				_, _ = buf.WriteRune('\\')
-			} else if ch1 == '"' {
-				_, _ = buf.WriteRune('"')
+			} else if ch1 == '"' || ch1 == '\'' {
+				_, _ = buf.WriteRune(ch1)
 			} else {
 				return string(ch0) + string(ch1), errBadEscape
 			}
@@ -603,6 +636,21 @@ func SplitIdent(s string) (segments []string, err error) {
 	}
 }
 
+// isQualifiedIdent returns true if s is an identifier with multiple segments,
+// none of them blank, such as "db"."rp"."cpu".
+func isQualifiedIdent(s string) bool {
+	segments, err := SplitIdent(s)
+	if err != nil || len(segments) < 2 {
+		return false
+	}
+	for _, segment := range segments {
+		if segment == "" {
+			return false
+		}
+	}
+	return true
+}
+
 // lastIdent returns the last identifier.
 func lastIdent(s string) string {
 	a, _ := SplitIdent(s)
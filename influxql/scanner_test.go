@@ -59,6 +59,7 @@ func TestScanner_Scan(t *testing.T) {
 		// Identifiers
 		{s: `foo`, tok: influxql.IDENT, lit: `foo`},
 		{s: `Zx12_3U_-`, tok: influxql.IDENT, lit: `Zx12_3U_`},
+		{s: `_shards`, tok: influxql.IDENT, lit: `_shards`},
 		{s: `"foo".bar`, tok: influxql.IDENT, lit: `"foo".bar`},
 		{s: `"foo\\bar"`, tok: influxql.IDENT, lit: `"foo\bar"`},
 		{s: `"foo\bar"`, tok: influxql.BADESCAPE, lit: `\b`, pos: influxql.Pos{Line: 0, Char: 5}},
@@ -66,6 +67,11 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `test"`, tok: influxql.BADSTRING, lit: "", pos: influxql.Pos{Line: 0, Char: 3}},
 		{s: `"test`, tok: influxql.BADSTRING, lit: `test`},
 
+		// Bound parameters
+		{s: `$host`, tok: influxql.BOUNDPARAM, lit: `host`},
+		{s: `$2`, tok: influxql.BOUNDPARAM, lit: `2`},
+		{s: `$`, tok: influxql.ILLEGAL, lit: `$`},
+
 		{s: `true`, tok: influxql.TRUE},
 		{s: `false`, tok: influxql.FALSE},
 
@@ -239,6 +245,7 @@ func TestScanString(t *testing.T) {
 		{in: `"foo\nbar"`, out: "foo\nbar"},
 		{in: `"foo\\bar"`, out: `foo\bar`},
 		{in: `"foo\"bar"`, out: `foo"bar`},
+		{in: `'foo\'bar'`, out: `foo'bar`},
 
 		{in: `"foo` + "\n", out: `foo`, err: "bad string"}, // newline in string
 		{in: `"foo`, out: `foo`, err: "bad string"},        // unclosed quotes
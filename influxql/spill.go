@@ -0,0 +1,241 @@
+package influxql
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// spilledRow is a buffered row written to disk when a query exceeds its
+// memory limit. Timestamps are kept as int64 nanoseconds.
+type spilledRow struct {
+	Tagset  string
+	Hash    uint64
+	Name    string
+	Tags    map[string]string
+	Columns []string
+	Values  [][]interface{}
+}
+
+// newSpilledRow returns a spilled row for a buffered row.
+func newSpilledRow(tagset string, row *Row) *spilledRow {
+	return &spilledRow{
+		Tagset:  tagset,
+		Hash:    row.tagsHash(),
+		Name:    row.Name,
+		Tags:    row.Tags,
+		Columns: row.Columns,
+		Values:  row.Values,
+	}
+}
+
+// less returns true if r sorts before other. Rows are ordered as Rows sorts
+// them with ties broken by the encoded tagset.
+func (r *spilledRow) less(other *spilledRow) bool {
+	if r.Name != other.Name {
+		return r.Name < other.Name
+	} else if r.Hash != other.Hash {
+		return r.Hash < other.Hash
+	}
+	return r.Tagset < other.Tagset
+}
+
+// spilledRows sorts rows for writing to a run.
+type spilledRows []*spilledRow
+
+func (a spilledRows) Len() int           { return len(a) }
+func (a spilledRows) Less(i, j int) bool { return a[i].less(a[j]) }
+func (a spilledRows) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// newSpilledRows returns the buffered rows in sorted order.
+func newSpilledRows(rows map[string]*Row) spilledRows {
+	a := make(spilledRows, 0, len(rows))
+	for tagset, row := range rows {
+		a = append(a, newSpilledRow(tagset, row))
+	}
+	sort.Sort(a)
+	return a
+}
+
+// reserve claims n bytes for buffered rows. If the query is over its memory
+// limit and spilling is enabled then the buffered rows are written to disk to
+// make room. Returns true if the rows were spilled.
+func (e *Executor) reserve(rows map[string]*Row, n int64) (bool, error) {
+	err := e.mem.reserve(n)
+	if err == nil {
+		return false, nil
+	} else if e.spillDir == "" || len(rows) == 0 {
+		return false, err
+	}
+
+	if err := e.spill(rows); err != nil {
+		return false, err
+	}
+	return true, e.mem.reserve(n)
+}
+
+// spill writes the buffered rows to a new sorted run on disk, removes them
+// from the buffer and releases their memory.
+func (e *Executor) spill(rows map[string]*Row) error {
+	f, err := ioutil.TempFile(e.spillDir, "influxql-spill-")
+	if err != nil {
+		return err
+	}
+	e.runs = append(e.runs, f)
+
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, r := range newSpilledRows(rows) {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for k := range rows {
+		delete(rows, k)
+	}
+	e.mem.release()
+	return nil
+}
+
+// removeRuns closes and deletes the query's spilled runs.
+func (e *Executor) removeRuns() {
+	for _, f := range e.runs {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	e.runs = nil
+}
+
+// mergeRuns sends the buffered and spilled rows to out in sorted order. Rows
+// for the same tagset are combined and their values sorted by time. Values of
+// aggregate queries with the same time are merged into one value set.
+func (e *Executor) mergeRuns(rows map[string]*Row, isRaw bool, out chan *Row) error {
+	// Read each run from the start alongside the remaining buffered rows.
+	var cursors []rowCursor
+	for _, f := range e.runs {
+		if _, err := f.Seek(0, 0); err != nil {
+			return err
+		}
+		cursors = append(cursors, &runCursor{dec: gob.NewDecoder(bufio.NewReader(f))})
+	}
+	cursors = append(cursors, &sliceCursor{rows: newSpilledRows(rows)})
+
+	// Read the first row of each cursor.
+	heads := make([]*spilledRow, len(cursors))
+	for i, c := range cursors {
+		r, err := c.next()
+		if err != nil {
+			return err
+		}
+		heads[i] = r
+	}
+
+	for {
+		// Find the lowest row across all cursors.
+		var min *spilledRow
+		for _, r := range heads {
+			if r != nil && (min == nil || r.less(min)) {
+				min = r
+			}
+		}
+		if min == nil {
+			return nil
+		}
+
+		// Combine the values of every cursor positioned on the same row.
+		row := &Row{Name: min.Name, Tags: min.Tags, Columns: min.Columns}
+		for i, r := range heads {
+			if r == nil || r.less(min) || min.less(r) {
+				continue
+			}
+			row.Values = append(row.Values, r.Values...)
+
+			next, err := cursors[i].next()
+			if err != nil {
+				return err
+			}
+			heads[i] = next
+		}
+
+		out <- normalizeRow(row, isRaw)
+	}
+}
+
+// normalizeRow sorts a row's values by time and converts the timestamps to
+// times.
+func normalizeRow(row *Row, isRaw bool) *Row {
+	sort.Stable(valuesByTime(row.Values))
+
+	// Merge value sets with the same time that were split across runs or
+	// added out of order by different fields.
+	if !isRaw && len(row.Values) > 0 {
+		values := row.Values[:1]
+		for _, v := range row.Values[1:] {
+			last := values[len(values)-1]
+			if v[0].(int64) != last[0].(int64) {
+				values = append(values, v)
+				continue
+			}
+			for i := 1; i < len(v); i++ {
+				if last[i] == nil {
+					last[i] = v[i]
+				}
+			}
+		}
+		row.Values = values
+	}
+
+	for _, values := range row.Values {
+		values[0] = time.Unix(0, values[0].(int64)).UTC()
+	}
+	return row
+}
+
+// valuesByTime sorts value sets by their int64 timestamp.
+type valuesByTime [][]interface{}
+
+func (a valuesByTime) Len() int           { return len(a) }
+func (a valuesByTime) Less(i, j int) bool { return a[i][0].(int64) < a[j][0].(int64) }
+func (a valuesByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// rowCursor iterates over sorted rows. Returns a nil row once exhausted.
+type rowCursor interface {
+	next() (*spilledRow, error)
+}
+
+// runCursor reads the rows of a run from disk.
+type runCursor struct {
+	dec *gob.Decoder
+}
+
+func (c *runCursor) next() (*spilledRow, error) {
+	var r spilledRow
+	if err := c.dec.Decode(&r); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// sliceCursor iterates over rows held in memory.
+type sliceCursor struct {
+	rows spilledRows
+}
+
+func (c *sliceCursor) next() (*spilledRow, error) {
+	if len(c.rows) == 0 {
+		return nil, nil
+	}
+	r := c.rows[0]
+	c.rows = c.rows[1:]
+	return r, nil
+}
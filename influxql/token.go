@@ -25,6 +25,7 @@ const (
 	FALSE        // false
 	REGEX        // Regular expressions
 	BADREGEX     // `.*
+	BOUNDPARAM   // $param
 	literal_end
 
 	operator_beg
@@ -47,11 +48,12 @@ const (
 	GTE      // >=
 	operator_end
 
-	LPAREN    // (
-	RPAREN    // )
-	COMMA     // ,
-	SEMICOLON // ;
-	DOT       // .
+	LPAREN      // (
+	RPAREN      // )
+	COMMA       // ,
+	SEMICOLON   // ;
+	DOT         // .
+	DOUBLECOLON // ::
 
 	keyword_beg
 	// Keywords
@@ -129,6 +131,7 @@ var tokens = [...]string{
 	TRUE:         "TRUE",
 	FALSE:        "FALSE",
 	REGEX:        "REGEX",
+	BOUNDPARAM:   "BOUNDPARAM",
 
 	ADD: "+",
 	SUB: "-",
@@ -147,11 +150,12 @@ var tokens = [...]string{
 	GT:       ">",
 	GTE:      ">=",
 
-	LPAREN:    "(",
-	RPAREN:    ")",
-	COMMA:     ",",
-	SEMICOLON: ";",
-	DOT:       ".",
+	LPAREN:      "(",
+	RPAREN:      ")",
+	COMMA:       ",",
+	SEMICOLON:   ";",
+	DOT:         ".",
+	DOUBLECOLON: "::",
 
 	ALL:          "ALL",
 	ALTER:        "ALTER",
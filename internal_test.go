@@ -3,6 +3,11 @@ package influxdb
 // This file is run within the "influxdb" package and allows for internal unit tests.
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -184,6 +189,80 @@ func TestCreateMeasurementsCommand_Errors(t *testing.T) {
 	}
 }
 
+// Ensure values stored as either type of a field being converted can be read
+// and that the field is only replaced once every data node is done.
+func TestMeasurement_startFieldConversion(t *testing.T) {
+	m := NewMeasurement("cpu")
+	if err := m.createFieldIfNotExists("value", influxql.Number); err != nil {
+		t.Fatal(err)
+	}
+	old, err := NewFieldCodec(m).EncodeFields(map[string]interface{}{"value": float64(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start converting to a string on two data nodes.
+	if err := m.startFieldConversion("value", influxql.String, []uint64{1, 2}); err != nil {
+		t.Fatal(err)
+	} else if err := m.startFieldConversion("value", influxql.Boolean, nil); err != ErrFieldConversionInProgress {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// New values are written as strings but read as the field's current type.
+	codec := NewFieldCodec(m)
+	if f := m.fieldForWrite("value"); f.ID != 2 || f.Type != influxql.String {
+		t.Fatalf("unexpected write field: %#v", f)
+	} else if _, err := codec.EncodeFields(map[string]interface{}{"value": float64(1)}); err == nil {
+		t.Fatal("expected error writing the old type")
+	}
+	b, err := codec.EncodeFields(map[string]interface{}{"value": "200"})
+	if err != nil {
+		t.Fatal(err)
+	} else if v, err := codec.DecodeByID(1, b); err != nil || v != float64(200) {
+		t.Fatalf("unexpected value: %v (%v)", v, err)
+	} else if merged, err := codec.MergeFields(old, b); err != nil {
+		t.Fatal(err)
+	} else if v := codec.DecodeFields(merged); !reflect.DeepEqual(v, map[uint8]interface{}{1: float64(200)}) {
+		t.Fatalf("unexpected merged values: %#v", v)
+	}
+
+	// Fields the codec doesn't know can't be merged.
+	if _, err := codec.MergeFields([]byte{9, 0, 0, 0, 0, 0, 0, 0, 0}, b); err == nil || err.Error() != "field ID 9 has no mapping" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Converted values are stored under the new field.
+	if b := codec.convertField("value", old); b[0] != 2 {
+		t.Fatalf("unexpected field id: %d", b[0])
+	} else if v, err := codec.DecodeByID(1, b); err != nil || v != float64(100) {
+		t.Fatalf("unexpected value: %v (%v)", v, err)
+	}
+
+	// The field is replaced after the last data node converts its values.
+	if err := m.fieldConverted("value", 1, false); err != nil {
+		t.Fatal(err)
+	} else if f := m.FieldByName("value"); f.Type != influxql.Number {
+		t.Fatalf("unexpected type: %s", f.Type)
+	} else if err := m.fieldConverted("value", 2, false); err != nil {
+		t.Fatal(err)
+	} else if f := m.FieldByName("value"); f.ID != 2 || f.Type != influxql.String || f.Hidden {
+		t.Fatalf("unexpected field: %#v", f)
+	} else if v, err := NewFieldCodec(m).DecodeByID(2, old); err != nil || v != "100" {
+		t.Fatalf("unexpected value: %v (%v)", v, err)
+	}
+
+	// A failed data node abandons the conversion and keeps the field's type.
+	if err := m.startFieldConversion("value", influxql.Number, []uint64{1}); err != nil {
+		t.Fatal(err)
+	} else if f := m.fieldForWrite("value"); f.ID != 1 {
+		t.Fatalf("expected hidden field to be reused: %#v", f)
+	} else if err := m.fieldConverted("value", 1, true); err != nil {
+		t.Fatal(err)
+	} else if f := m.FieldByName("value"); f.ID != 2 || f.ReplacedBy != 0 {
+		t.Fatalf("unexpected field: %#v", f)
+	}
+}
+
 // Test comparing seriesIDs for equality.
 func Test_seriesIDs_equals(t *testing.T) {
 	ids1 := seriesIDs{1, 2, 3}
@@ -322,3 +401,152 @@ func MustParseExpr(s string) influxql.Expr {
 func strref(s string) *string {
 	return &s
 }
+
+// Ensure the quota manager limits the number of concurrent queries per database.
+func TestQuotaManager_acquireQuery(t *testing.T) {
+	m := NewQuotaManager()
+	m.SetQuota(Quota{Database: "foo", MaxConcurrentQueries: 1})
+
+	if err := m.acquireQuery("foo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := m.acquireQuery("foo"); err != ErrQueryQuotaExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := m.acquireQuery("bar"); err != nil {
+		t.Fatalf("unexpected error for database without quota: %s", err)
+	}
+
+	m.releaseQuery("foo")
+	if err := m.acquireQuery("foo"); err != nil {
+		t.Fatalf("unexpected error after release: %s", err)
+	}
+}
+
+// Ensure the write limiter refills buckets at the write limit up to the burst.
+func TestWriteLimiter_reserve(t *testing.T) {
+	l := newWriteLimiter()
+	now := time.Unix(0, 0)
+	l.Now = func() time.Time { return now }
+
+	// The bucket starts full.
+	if err := l.reserve("foo", 10, 20, 20); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := l.reserve("foo", 10, 20, 1); err != ErrWriteThrottled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Tokens are added at the limit but never beyond the burst.
+	now = now.Add(500 * time.Millisecond)
+	if err := l.reserve("foo", 10, 20, 5); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := l.reserve("foo", 10, 20, 1); err != ErrWriteThrottled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err := l.reserve("foo", 10, 20, 21); err != nil {
+		t.Fatalf("unexpected error for batch larger than burst: %s", err)
+	} else if err := l.reserve("foo", 10, 20, 1); err != ErrWriteThrottled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Other databases and databases without a limit are not affected.
+	if err := l.reserve("bar", 10, 0, 10); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := l.reserve("foo", 0, 0, 1000); err != nil {
+		t.Fatalf("unexpected error for database without limit: %s", err)
+	}
+}
+
+// Ensure query timeouts are bounded by the server's maximum.
+func TestServer_queryTimeout(t *testing.T) {
+	s := &Server{}
+	if d := s.queryTimeout(0); d != 0 {
+		t.Fatalf("unexpected unbounded timeout: %s", d)
+	} else if d := s.queryTimeout(time.Second); d != time.Second {
+		t.Fatalf("unexpected requested timeout: %s", d)
+	}
+
+	s.MaxQueryTimeout = time.Minute
+	for _, tt := range []struct {
+		d, exp time.Duration
+	}{
+		{d: 0, exp: time.Minute},
+		{d: time.Second, exp: time.Second},
+		{d: time.Hour, exp: time.Minute},
+	} {
+		if d := s.queryTimeout(tt.d); d != tt.exp {
+			t.Errorf("%s: unexpected timeout: exp=%s, got=%s", tt.d, tt.exp, d)
+		}
+	}
+}
+
+// Ensure a data directory is migrated in order, with a backup, and resumes
+// from the last migration that completed.
+func TestMigrateDataDir(t *testing.T) {
+	path, err := ioutil.TempDir("", "influxdb-migrate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	// A new directory starts at the latest version.
+	var ran []int
+	fail := 3
+	a := []Migration{}
+	for v := 1; v <= 3; v++ {
+		v := v
+		a = append(a, Migration{Version: v, Description: fmt.Sprintf("step %d", v), Migrate: func(string) error {
+			if v == fail {
+				return errors.New("marker")
+			}
+			ran = append(ran, v)
+			return nil
+		}})
+	}
+	if r, err := migrateDataDir(path, a, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	} else if r.From != 0 || r.To != 3 || len(r.Migrations) != 0 {
+		t.Fatalf("unexpected report: %#v", r)
+	}
+
+	// An existing directory without a version file starts at version 0.
+	os.Remove(filepath.Join(path, dataFormatVersionFile))
+	if err := ioutil.WriteFile(filepath.Join(path, "meta"), []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run changes nothing.
+	if r, err := migrateDataDir(path, a, MigrateOptions{DryRun: true, Backup: true}); err != nil {
+		t.Fatal(err)
+	} else if r.From != 0 || r.To != 3 || len(r.Migrations) != 3 || r.BackupPath != "" || len(ran) != 0 {
+		t.Fatalf("unexpected report: %#v", r)
+	} else if v, _ := ReadDataFormatVersion(path); v != 0 {
+		t.Fatalf("unexpected version: %d", v)
+	}
+
+	// A failed migration leaves the version of the last one that completed.
+	r, err := migrateDataDir(path, a, MigrateOptions{Backup: true})
+	if err == nil || err.Error() != "migrate to version 3: marker" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if r.To != 2 || !reflect.DeepEqual(ran, []int{1, 2}) {
+		t.Fatalf("unexpected report: %#v, ran %v", r, ran)
+	} else if v, _ := ReadDataFormatVersion(path); v != 2 {
+		t.Fatalf("unexpected version: %d", v)
+	}
+	defer os.RemoveAll(r.BackupPath)
+	if b, err := ioutil.ReadFile(filepath.Join(r.BackupPath, "meta")); err != nil || string(b) != "data" {
+		t.Fatalf("unexpected backup: %q, %v", b, err)
+	}
+
+	// The next run resumes.
+	fail = 0
+	if r, err := migrateDataDir(path, a, MigrateOptions{}); err != nil {
+		t.Fatal(err)
+	} else if r.From != 2 || r.To != 3 || !reflect.DeepEqual(ran, []int{1, 2, 3}) {
+		t.Fatalf("unexpected report: %#v, ran %v", r, ran)
+	}
+
+	// Directories written by a newer version are rejected.
+	if _, err := migrateDataDir(path, a[:2], MigrateOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,137 @@
+package influxdb
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Classes of background disk IO throttled by the IOScheduler.
+const (
+	// IOClassCompaction is the IO of rewriting fragmented shards.
+	IOClassCompaction = "compaction"
+
+	// IOClassRetention is the IO of removing points past their measurement's TTL.
+	IOClassRetention = "retention"
+
+	// IOClassBackup is the IO of copying shards out of the server, such as
+	// exports and uploads to an object store.
+	IOClassBackup = "backup"
+)
+
+const (
+	// DefaultRetentionThroughput is the default limit on bytes deleted per
+	// second when removing expired points.
+	DefaultRetentionThroughput = 10 * 1024 * 1024
+
+	// DefaultBackupThroughput is the default limit on bytes read per second
+	// when copying shards out of the server.
+	DefaultBackupThroughput = 50 * 1024 * 1024
+
+	// ioBatchSize is the number of bytes processed per transaction by
+	// throttled background work.
+	ioBatchSize = 1 << 20
+)
+
+// IOScheduler throttles background maintenance so it doesn't starve queries
+// and writes of disk bandwidth. Each class of work has its own budget in
+// bytes per second and all classes together share a total budget. Budgets
+// can be changed while running.
+type IOScheduler struct {
+	mu      sync.Mutex
+	total   ioThrottle
+	classes map[string]*ioThrottle
+}
+
+// ioThrottle spaces out IO to a number of bytes per second.
+type ioThrottle struct {
+	throughput int64     // bytes per second, unlimited if zero
+	next       time.Time // time the throttle allows the next IO
+}
+
+// reserve accounts for n bytes and returns how long to wait before using them.
+func (t *ioThrottle) reserve(now time.Time, n int) time.Duration {
+	if t.throughput == 0 {
+		return 0
+	}
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / t.throughput))
+	return t.next.Sub(now)
+}
+
+// NewIOScheduler returns a new instance of IOScheduler with the default budgets.
+func NewIOScheduler() *IOScheduler {
+	return &IOScheduler{
+		classes: map[string]*ioThrottle{
+			IOClassCompaction: {throughput: DefaultCompactionThroughput},
+			IOClassRetention:  {throughput: DefaultRetentionThroughput},
+			IOClassBackup:     {throughput: DefaultBackupThroughput},
+		},
+	}
+}
+
+// throttle returns the throttle of a class, or the total if class is blank.
+// Must be called with the lock held.
+func (s *IOScheduler) throttle(class string) *ioThrottle {
+	if class == "" {
+		return &s.total
+	}
+	t := s.classes[class]
+	if t == nil {
+		t = &ioThrottle{}
+		s.classes[class] = t
+	}
+	return t
+}
+
+// Throughput returns the budget of a class in bytes per second, or the total
+// budget if class is blank. Zero means unlimited.
+func (s *IOScheduler) Throughput(class string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.throttle(class).throughput
+}
+
+// SetThroughput sets the budget of a class in bytes per second, or the total
+// budget if class is blank. The class is not throttled if n is zero.
+func (s *IOScheduler) SetThroughput(class string, n int64) {
+	if n < 0 {
+		n = 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttle(class).throughput = n
+}
+
+// Wait blocks until n more bytes of a class of IO fit in its budget and the
+// total budget.
+func (s *IOScheduler) Wait(class string, n int) {
+	s.mu.Lock()
+	now := time.Now()
+	d := s.throttle(class).reserve(now, n)
+	if td := s.total.reserve(now, n); td > d {
+		d = td
+	}
+	s.mu.Unlock()
+
+	time.Sleep(d)
+}
+
+// waitFunc returns a function waiting on the budget of a class.
+func (s *IOScheduler) waitFunc(class string) func(n int) {
+	return func(n int) { s.Wait(class, n) }
+}
+
+// ioThrottledWriter waits on an IO budget before each write to a writer.
+type ioThrottledWriter struct {
+	w    io.Writer
+	wait func(n int)
+}
+
+// Write waits for the budget of p before writing it.
+func (w *ioThrottledWriter) Write(p []byte) (int, error) {
+	w.wait(len(p))
+	return w.w.Write(p)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/url"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/influxdb/influxdb/raft"
 )
@@ -20,6 +22,20 @@ import (
 // BroadcastTopicID is the topic used to communicate with all replicas.
 const BroadcastTopicID = uint64(0)
 
+const (
+	// DefaultMaxSegmentSize is the size a topic segment can reach before
+	// a new segment is started.
+	DefaultMaxSegmentSize = 10 * 1024 * 1024
+
+	// DefaultTruncationInterval is how often the broker removes topic
+	// segments that have been read by every replica.
+	DefaultTruncationInterval = 10 * time.Minute
+
+	// DefaultTruncationWindow is the number of indexes behind the slowest
+	// replica that are kept when topics are truncated.
+	DefaultTruncationWindow = 10000
+)
+
 // Broker represents distributed messaging system segmented into topics.
 // Each topic represents a linear series of events.
 type Broker struct {
@@ -31,6 +47,18 @@ type Broker struct {
 	replicas map[uint64]*Replica // replica by id
 	topics   map[uint64]*topic   // topics by id
 
+	done chan struct{} // closed when the broker is closed
+
+	truncatedSegments []string // segments whose torn last message was removed on open
+
+	// The size at which topic segments are rolled over.
+	MaxSegmentSize int64
+
+	// How often topics are truncated and how many indexes are kept behind
+	// the slowest replica. Truncation is disabled if the interval is zero.
+	TruncationInterval time.Duration
+	TruncationWindow   uint64
+
 	Logger *log.Logger
 }
 
@@ -40,7 +68,12 @@ func NewBroker() *Broker {
 		log:      raft.NewLog(),
 		replicas: make(map[uint64]*Replica),
 		topics:   make(map[uint64]*topic),
-		Logger:   log.New(os.Stderr, "[broker] ", log.LstdFlags),
+
+		MaxSegmentSize:     DefaultMaxSegmentSize,
+		TruncationInterval: DefaultTruncationInterval,
+		TruncationWindow:   DefaultTruncationWindow,
+
+		Logger: log.New(os.Stderr, "[broker] ", log.LstdFlags),
 	}
 	b.log.FSM = (*brokerFSM)(b)
 	return b
@@ -107,6 +140,12 @@ func (b *Broker) Open(path string, u *url.URL) error {
 	b.log.URL = &url.URL{}
 	*b.log.URL = *u
 
+	// Periodically remove topic segments that have been read.
+	if b.TruncationInterval > 0 {
+		b.done = make(chan struct{})
+		go b.truncateLoop(b.done)
+	}
+
 	return nil
 }
 
@@ -124,6 +163,12 @@ func (b *Broker) close() error {
 	}
 	b.path = ""
 
+	// Stop truncating topics.
+	if b.done != nil {
+		close(b.done)
+		b.done = nil
+	}
+
 	// Close all topics & replicas.
 	b.closeTopics()
 	b.closeReplicas()
@@ -172,6 +217,7 @@ func (b *Broker) load() error {
 	for _, st := range hdr.Topics {
 		t := b.createTopic(st.ID)
 		t.index = st.Index
+		t.truncatedIndex = st.TruncatedIndex
 
 		// Open new empty topic file.
 		if err := t.open(); err != nil {
@@ -201,16 +247,32 @@ func (b *Broker) load() error {
 
 // loadIndex reads through all topics to find the highest known index.
 func (b *Broker) loadIndex() error {
+	b.truncatedSegments = nil
 	for _, t := range b.topics {
-		if err := t.loadIndex(); err != nil {
+		path, err := t.loadIndex()
+		if err != nil {
 			return fmt.Errorf("topic(%d): %s", t.id, err)
-		} else if t.index > b.index {
+		} else if path != "" {
+			b.Logger.Printf("truncated torn message at end of segment %s", path)
+			b.truncatedSegments = append(b.truncatedSegments, path)
+		}
+		if t.index > b.index {
 			b.index = t.index
 		}
 	}
+	sort.Strings(b.truncatedSegments)
 	return nil
 }
 
+// TruncatedSegments returns the paths of topic segments whose last message
+// was only partly written, such as by a crash, and was removed when the
+// broker opened.
+func (b *Broker) TruncatedSegments() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.truncatedSegments
+}
+
 // save persists the broker metadata to disk.
 func (b *Broker) save() error {
 	if b.path == "" {
@@ -250,25 +312,18 @@ func (b *Broker) createSnapshotHeader() (*snapshotHeader, error) {
 	// Create parent header.
 	s := &snapshotHeader{}
 
-	// Append topics.
+	// Append topics along with the current size of their segments.
 	for _, t := range b.topics {
-		// Retrieve current topic file size.
-		var sz int64
-		if t.file != nil {
-			fi, err := t.file.Stat()
-			if err != nil {
-				return nil, err
-			}
-			sz = fi.Size()
+		st := &snapshotTopic{ID: t.id, Index: t.index, TruncatedIndex: t.truncatedIndex}
+		t.mu.RLock()
+		for _, seg := range t.segments {
+			st.segments = append(st.segments, &segment{index: seg.index, path: seg.path, size: seg.size})
+			st.Size += seg.size
 		}
+		t.mu.RUnlock()
 
 		// Append topic to the snapshot.
-		s.Topics = append(s.Topics, &snapshotTopic{
-			ID:    t.id,
-			Index: t.index,
-			Size:  sz,
-			path:  t.path,
-		})
+		s.Topics = append(s.Topics, st)
 	}
 
 	// Append replicas and the current index for each topic.
@@ -293,6 +348,23 @@ func (b *Broker) URL() *url.URL {
 	return b.log.URL
 }
 
+// URLs returns the connection urls for all brokers in the cluster.
+// Returns nil if the broker has not been initialized or joined a cluster.
+func (b *Broker) URLs() []*url.URL {
+	config := b.log.Config()
+	if config == nil {
+		return nil
+	}
+
+	a := make([]*url.URL, 0, len(config.Nodes))
+	for _, n := range config.Nodes {
+		if n.URL != nil {
+			a = append(a, n.URL)
+		}
+	}
+	return a
+}
+
 // LeaderURL returns the connection url for the leader broker.
 func (b *Broker) LeaderURL() *url.URL {
 	_, u := b.log.Leader()
@@ -325,6 +397,17 @@ func (b *Broker) Publish(m *Message) (uint64, error) {
 	return b.log.Apply(buf)
 }
 
+// PublishBatch writes a list of messages in order.
+// Returns the index of the first message. The following messages are
+// assigned consecutive indexes. Otherwise returns an error.
+func (b *Broker) PublishBatch(a []*Message) (uint64, error) {
+	commands := make([][]byte, len(a))
+	for i, m := range a {
+		commands[i], _ = m.MarshalBinary()
+	}
+	return b.log.ApplyBatch(commands)
+}
+
 // PublishSync writes a message and waits until the change is applied.
 func (b *Broker) PublishSync(m *Message) error {
 	// Publish message.
@@ -368,9 +451,13 @@ func (b *Broker) Replicas() []*Replica {
 // initializes a new topic object.
 func (b *Broker) createTopic(id uint64) *topic {
 	t := &topic{
-		id:       id,
-		path:     filepath.Join(b.path, strconv.FormatUint(uint64(id), 10)),
-		replicas: make(map[uint64]*Replica),
+		id:             id,
+		path:           filepath.Join(b.path, strconv.FormatUint(uint64(id), 10)),
+		maxSegmentSize: b.MaxSegmentSize,
+		replicas:       make(map[uint64]*Replica),
+	}
+	if t.maxSegmentSize <= 0 {
+		t.maxSegmentSize = DefaultMaxSegmentSize
 	}
 	b.topics[t.id] = t
 	return t
@@ -387,7 +474,16 @@ func (b *Broker) createTopicIfNotExists(id uint64) *topic {
 }
 
 // CreateReplica creates a new named replica.
+// The replica receives broadcast messages published after it is created.
 func (b *Broker) CreateReplica(id uint64, connectURL *url.URL) error {
+	return b.CreateReplicaFrom(id, connectURL, 0)
+}
+
+// CreateReplicaFrom creates a new named replica that receives the broadcast
+// messages after index. This allows a replica that is bootstrapped from a
+// snapshot of the state at index to only stream the tail of the log.
+// An index of zero starts the replica at the end of the broadcast topic.
+func (b *Broker) CreateReplicaFrom(id uint64, connectURL *url.URL, index uint64) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -400,7 +496,7 @@ func (b *Broker) CreateReplica(id uint64, connectURL *url.URL) error {
 	// Add command to create replica.
 	return b.PublishSync(&Message{
 		Type: CreateReplicaMessageType,
-		Data: mustMarshalJSON(&CreateReplicaCommand{ID: id, URL: connectURL.String()}),
+		Data: mustMarshalJSON(&CreateReplicaCommand{ID: id, URL: connectURL.String(), Index: index}),
 	})
 }
 
@@ -411,9 +507,18 @@ func (b *Broker) mustApplyCreateReplica(m *Message) {
 	// Create replica.
 	r := newReplica(b, c.ID, c.URL)
 
-	// Automatically subscribe to the config topic.
+	// Automatically subscribe to the config topic. Subscriptions cannot
+	// start before messages that have been truncated.
 	t := b.createTopicIfNotExists(BroadcastTopicID)
-	r.topics[BroadcastTopicID] = t.index
+	index := t.index
+	if c.Index > 0 && c.Index < index {
+		index = c.Index
+		if index < t.truncatedIndex {
+			b.Logger.Printf("topic truncated, subscribing from index %d: replica=%d, topic=%d", t.truncatedIndex, r.id, BroadcastTopicID)
+			index = t.truncatedIndex
+		}
+	}
+	r.topics[BroadcastTopicID] = index
 
 	// Add replica to the broker.
 	b.replicas[c.ID] = r
@@ -503,12 +608,19 @@ func (b *Broker) mustApplySubscribe(m *Message) {
 		return
 	}
 
+	// Subscriptions cannot start before messages that have been truncated.
+	index := c.Index
+	if index < t.truncatedIndex {
+		b.Logger.Printf("topic truncated, subscribing from index %d: replica=%d, topic=%d", t.truncatedIndex, r.id, c.TopicID)
+		index = t.truncatedIndex
+	}
+
 	// Add subscription to replica.
-	r.topics[c.TopicID] = c.Index
+	r.topics[c.TopicID] = index
 	t.replicas[c.ReplicaID] = r
 
 	// Catch up replica.
-	_, _ = t.writeTo(r, c.Index)
+	_, _ = t.writeTo(r, index)
 
 	b.mustSave()
 }
@@ -547,6 +659,99 @@ func (b *Broker) mustApplyUnsubscribe(m *Message) {
 	b.mustSave()
 }
 
+// Truncate removes topic segments that have been read by every subscribed
+// replica. The most recent TruncationWindow indexes before the slowest
+// replica are always kept. Replicas only report what they have read to the
+// broker they stream from so other brokers will truncate less.
+func (b *Broker) Truncate() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.opened() {
+		return ErrClosed
+	}
+
+	var truncated bool
+	for _, t := range b.topics {
+		// Find the highest index that can be removed.
+		index, ok := b.readIndex(t.id)
+		if !ok || index <= b.TruncationWindow {
+			continue
+		}
+
+		if n, err := t.truncate(index - b.TruncationWindow); err != nil {
+			return fmt.Errorf("topic(%d): %s", t.id, err)
+		} else if n == 0 {
+			continue
+		}
+		truncated = true
+
+		// Move replicas past the removed messages so they don't ask for them.
+		for _, r := range b.replicas {
+			if i, ok := r.topics[t.id]; ok && i < t.truncatedIndex {
+				r.topics[t.id] = t.truncatedIndex
+			}
+		}
+	}
+
+	if truncated {
+		return b.save()
+	}
+	return nil
+}
+
+// pendingIndexes returns the highest index of each topic a replica is
+// subscribed to that has messages after the replica's last known index.
+func (b *Broker) pendingIndexes(r *Replica) map[uint64]uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	m := make(map[uint64]uint64)
+	for topicID, index := range r.topics {
+		if t := b.topics[topicID]; t != nil {
+			if i := t.highIndex(); i > index {
+				m[topicID] = i
+			}
+		}
+	}
+	return m
+}
+
+// readIndex returns the lowest index read from a topic across all subscribed
+// replicas. Returns false if no replicas are subscribed to the topic.
+func (b *Broker) readIndex(topicID uint64) (index uint64, ok bool) {
+	for _, r := range b.replicas {
+		i, subscribed := r.topics[topicID]
+		if !subscribed {
+			continue
+		}
+		if n := r.readIndex(topicID); n > i {
+			i = n
+		}
+		if !ok || i < index {
+			index, ok = i, true
+		}
+	}
+	return
+}
+
+// truncateLoop periodically truncates topics until done is closed.
+func (b *Broker) truncateLoop(done chan struct{}) {
+	ticker := time.NewTicker(b.TruncationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := b.Truncate(); err != nil && err != ErrClosed {
+				b.Logger.Printf("truncate: %s", err)
+			}
+		}
+	}
+}
+
 // brokerFSM implements the raft.FSM interface for the broker.
 // This is implemented as a separate type because it is not meant to be exported.
 type brokerFSM Broker
@@ -606,12 +811,11 @@ func (fsm *brokerFSM) Index() (uint64, error) {
 func (fsm *brokerFSM) Snapshot(w io.Writer) (uint64, error) {
 	b := (*Broker)(fsm)
 
-	// TODO: Prevent truncation during snapshot.
-
-	// Calculate header under lock.
+	// Calculate header under lock. The lock is held while streaming so
+	// segments cannot be truncated during the snapshot.
 	b.mu.RLock()
+	defer b.mu.RUnlock()
 	hdr, err := b.createSnapshotHeader()
-	b.mu.RUnlock()
 	if err != nil {
 		return 0, fmt.Errorf("create snapshot: %s", err)
 	}
@@ -630,10 +834,12 @@ func (fsm *brokerFSM) Snapshot(w io.Writer) (uint64, error) {
 		return 0, fmt.Errorf("write header: %s", err)
 	}
 
-	// Stream each topic sequentially.
+	// Stream each topic's segments sequentially.
 	for _, t := range hdr.Topics {
-		if _, err := copyFileN(w, t.path, t.Size); err != nil {
-			return 0, err
+		for _, seg := range t.segments {
+			if _, err := copyFileN(w, seg.path, seg.size); err != nil {
+				return 0, err
+			}
 		}
 	}
 
@@ -672,21 +878,29 @@ func (fsm *brokerFSM) Restore(r io.Reader) error {
 	for _, st := range s.Topics {
 		t := b.createTopic(st.ID)
 		t.index = st.Index
+		t.truncatedIndex = st.TruncatedIndex
 
-		// Remove existing file if it exists.
-		if err := os.Remove(t.path); err != nil && !os.IsNotExist(err) {
+		// Remove existing segments if they exist.
+		if err := os.RemoveAll(t.path); err != nil {
 			return err
 		}
 
-		// Open new empty topic file.
+		// Open new empty topic.
 		if err := t.open(); err != nil {
 			return fmt.Errorf("open topic: %s", err)
 		}
+		if st.Size == 0 {
+			continue
+		}
 
-		// Copy data from snapshot into file.
+		// Copy data from snapshot into a single segment.
+		if err := t.roll(st.TruncatedIndex + 1); err != nil {
+			return fmt.Errorf("create segment: %s", err)
+		}
 		if _, err := io.CopyN(t.file, r, st.Size); err != nil {
 			return fmt.Errorf("copy topic: %s", err)
 		}
+		t.segments[0].size = st.Size
 	}
 
 	// Update the replicas.
@@ -741,11 +955,12 @@ type snapshotReplica struct {
 }
 
 type snapshotTopic struct {
-	ID    uint64 `json:"id"`
-	Index uint64 `json:"index"`
-	Size  int64  `json:"size"`
+	ID             uint64 `json:"id"`
+	Index          uint64 `json:"index"`
+	TruncatedIndex uint64 `json:"truncatedIndex,omitempty"`
+	Size           int64  `json:"size"`
 
-	path string
+	segments []*segment
 }
 
 type snapshotReplicaTopic struct {
@@ -754,18 +969,36 @@ type snapshotReplicaTopic struct {
 }
 
 // topic represents a single named queue of messages.
-// Each topic is identified by a unique path.
+// Each topic is identified by a unique path. Messages are stored in a
+// directory of segment files so that old messages can be removed.
 type topic struct {
-	id    uint64 // unique identifier
-	index uint64 // highest index written
-	path  string // on-disk path
+	id             uint64 // unique identifier
+	index          uint64 // highest index written
+	truncatedIndex uint64 // highest index removed by truncation
+	path           string // on-disk directory
+
+	maxSegmentSize int64
 
-	file *os.File // on-disk representation
+	file *os.File // active segment
 
 	mu       sync.RWMutex
+	segments []*segment          // segments ordered by index
 	replicas map[uint64]*Replica // replicas subscribed to topic
 }
 
+// segment represents a file holding a contiguous range of a topic's messages.
+type segment struct {
+	index uint64 // lowest index that can be stored in the segment
+	path  string
+	size  int64
+}
+
+type segments []*segment
+
+func (a segments) Len() int           { return len(a) }
+func (a segments) Less(i, j int) bool { return a[i].index < a[j].index }
+func (a segments) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 // addReplica adds a replica to the topic.
 func (t *topic) addReplica(r *Replica) {
 	t.mu.Lock()
@@ -780,25 +1013,118 @@ func (t *topic) replica(id uint64) *Replica {
 	return t.replicas[id]
 }
 
-// open opens a topic for writing.
+// open opens a topic's last segment for writing.
+// highIndex returns the highest index written to the topic.
+func (t *topic) highIndex() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.index
+}
+
 func (t *topic) open() error {
 	assert(t.file == nil, "topic already open: %d", t.id)
 
-	// Ensure the parent directory exists.
-	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+	// Topics used to be stored as a single file. Move it into a segment.
+	if fi, err := os.Stat(t.path); err == nil && !fi.IsDir() {
+		if err := t.migrate(); err != nil {
+			return fmt.Errorf("migrate: %s", err)
+		}
+	}
+
+	// Ensure the topic directory exists.
+	if err := os.MkdirAll(t.path, 0755); err != nil {
+		return err
+	}
+
+	// Read the list of segments.
+	a, err := readSegments(t.path)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.segments = a
+	t.mu.Unlock()
+
+	// Open the writer to the last segment.
+	if len(a) > 0 {
+		f, err := os.OpenFile(a[len(a)-1].path, os.O_RDWR|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		t.file = f
+	}
+
+	return nil
+}
+
+// migrate moves a topic stored as a single file into the topic's first segment.
+func (t *topic) migrate() error {
+	tmp := t.path + ".tmp"
+	if err := os.Rename(t.path, tmp); err != nil {
 		return err
 	}
+	if err := os.MkdirAll(t.path, 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.path, "1"))
+}
+
+// readSegments returns the segments in a topic directory ordered by index.
+func readSegments(path string) ([]*segment, error) {
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
 
-	// Open the writer to the on-disk file.
-	f, err := os.OpenFile(t.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	var a []*segment
+	for _, fi := range fis {
+		index, err := strconv.ParseUint(fi.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		a = append(a, &segment{index: index, path: filepath.Join(path, fi.Name()), size: fi.Size()})
+	}
+	sort.Sort(segments(a))
+	return a, nil
+}
+
+// roll closes the current segment and starts a new one beginning at index.
+func (t *topic) roll(index uint64) error {
+	if t.file != nil {
+		_ = t.file.Close()
+		t.file = nil
+	}
+
+	path := filepath.Join(t.path, strconv.FormatUint(index, 10))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
 		return err
 	}
 	t.file = f
 
+	t.mu.Lock()
+	t.segments = append(t.segments, &segment{index: index, path: path})
+	t.mu.Unlock()
 	return nil
 }
 
+// truncate removes segments that only hold messages at or before index.
+// The last segment is never removed. Returns the number of segments removed.
+func (t *topic) truncate(index uint64) (n int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for len(t.segments) > 1 && t.segments[1].index-1 <= index {
+		if err := os.Remove(t.segments[0].path); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		t.truncatedIndex = t.segments[1].index - 1
+		t.segments = t.segments[1:]
+		n++
+	}
+	return n, nil
+}
+
 // close closes the underlying file.
 func (t *topic) Close() error {
 	// Close file.
@@ -809,14 +1135,38 @@ func (t *topic) Close() error {
 	return nil
 }
 
-// loadIndex reads the highest available index for a topic from disk.
-func (t *topic) loadIndex() error {
-	// Open topic file for reading.
-	f, err := os.Open(t.path)
+// loadIndex reads the highest available index for a topic from disk. A
+// message only partly written to the end of the last segment is removed.
+// Returns the path of the segment if it was truncated.
+func (t *topic) loadIndex() (truncated string, err error) {
+	t.mu.RLock()
+	a := t.segments
+	t.mu.RUnlock()
+
+	for i, seg := range a {
+		n, err := t.loadSegmentIndex(seg.path)
+		if err == io.ErrUnexpectedEOF && i == len(a)-1 {
+			if err := os.Truncate(seg.path, n); err != nil {
+				return "", err
+			}
+			seg.size = n
+			return seg.path, nil
+		} else if err != nil {
+			return "", fmt.Errorf("decode: %s", err)
+		}
+	}
+	return "", nil
+}
+
+// loadSegmentIndex reads the highest index in a segment. Returns the size of
+// the complete messages read.
+func (t *topic) loadSegmentIndex(path string) (n int64, err error) {
+	// Open segment file for reading.
+	f, err := os.Open(path)
 	if os.IsNotExist(err) {
-		return nil
+		return 0, nil
 	} else if err != nil {
-		return err
+		return 0, err
 	}
 	defer func() { _ = f.Close() }()
 
@@ -826,10 +1176,11 @@ func (t *topic) loadIndex() error {
 		// Decode message.
 		var m Message
 		if err := dec.Decode(&m); err == io.EOF {
-			return nil
+			return n, nil
 		} else if err != nil {
-			return fmt.Errorf("decode: %s", err)
+			return n, err
 		}
+		n += int64(messageHeaderSize + len(m.Data))
 
 		// Update the topic's highest index.
 		t.index = m.Index
@@ -837,13 +1188,32 @@ func (t *topic) loadIndex() error {
 }
 
 // writeTo writes the topic to a replica since a given index.
-// Returns an error if the starting index is unavailable.
+// Returns an error if the starting index has been truncated.
 func (t *topic) writeTo(r *Replica, index uint64) (int64, error) {
-	// TODO: If index is too old then return an error.
+	t.mu.RLock()
+	a, truncatedIndex := t.segments, t.truncatedIndex
+	t.mu.RUnlock()
 
-	// Open topic file for reading.
-	// If it doesn't exist then just exit immediately.
-	f, err := os.Open(t.path)
+	if index < truncatedIndex {
+		return 0, ErrTopicTruncated
+	}
+
+	var total int64
+	for _, seg := range a {
+		n, err := t.writeSegmentTo(r, seg.path, index)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// writeSegmentTo writes the messages in a segment after index to a replica.
+func (t *topic) writeSegmentTo(r *Replica, path string, index uint64) (int64, error) {
+	// Open segment file for reading.
+	// If it doesn't exist then it has been truncated.
+	f, err := os.Open(path)
 	if os.IsNotExist(err) {
 		return 0, nil
 	} else if err != nil {
@@ -873,6 +1243,7 @@ func (t *topic) writeTo(r *Replica, index uint64) (int64, error) {
 		if err != nil {
 			return total, fmt.Errorf("write to: %s", err)
 		}
+		r.markRead(t.id, m.Index)
 		total += n
 	}
 
@@ -891,6 +1262,16 @@ func (t *topic) encode(m *Message) error {
 	// Ensure message is in-order.
 	assert(m.Index > t.index, "topic message out of order: %d -> %d", t.index, m.Index)
 
+	// Start a new segment if there are none or the current one is full.
+	t.mu.RLock()
+	roll := len(t.segments) == 0 || t.segments[len(t.segments)-1].size >= t.maxSegmentSize
+	t.mu.RUnlock()
+	if roll {
+		if err := t.roll(m.Index); err != nil {
+			return fmt.Errorf("roll: %s", err)
+		}
+	}
+
 	// Encode message.
 	b := make([]byte, messageHeaderSize+len(m.Data))
 	copy(b, m.marshalHeader())
@@ -901,15 +1282,16 @@ func (t *topic) encode(m *Message) error {
 		return fmt.Errorf("encode header: %s", err)
 	}
 
-	// Move up high water mark on the topic.
-	t.index = m.Index
-
-	// Write message out to all replicas.
+	// Move up high water mark on the topic and write message out to all replicas.
 	func() {
 		t.mu.Lock()
 		defer t.mu.Unlock()
+		t.index = m.Index
+		t.segments[len(t.segments)-1].size += int64(len(b))
 		for _, r := range t.replicas {
-			_, _ = r.Write(b)
+			if _, err := r.Write(b); err == nil {
+				r.markRead(t.id, m.Index)
+			}
 		}
 	}()
 
@@ -935,6 +1317,9 @@ type Replica struct {
 	done   chan struct{} // notify when current writer is removed
 
 	topics map[uint64]uint64 // current index for each subscribed topic
+
+	mu   sync.Mutex
+	read map[uint64]uint64 // highest index written to the replica for each topic
 }
 
 // newReplica returns a new Replica instance associated with a broker.
@@ -950,9 +1335,27 @@ func newReplica(b *Broker, id uint64, urlstr string) *Replica {
 		broker: b,
 		id:     id,
 		topics: make(map[uint64]uint64),
+		read:   make(map[uint64]uint64),
 	}
 }
 
+// markRead records that a topic has been written to the replica up to index.
+func (r *Replica) markRead(topicID, index uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if index > r.read[topicID] {
+		r.read[topicID] = index
+	}
+}
+
+// readIndex returns the highest index written to the replica for a topic
+// since the broker started.
+func (r *Replica) readIndex(topicID uint64) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read[topicID]
+}
+
 // closeWriter removes the writer on the replica and closes the notify channel.
 func (r *Replica) closeWriter() {
 	if r.writer != nil {
@@ -1039,8 +1442,9 @@ func (r *Replica) WriteTo(w io.Writer) (int64, error) {
 
 // CreateReplica creates a new replica.
 type CreateReplicaCommand struct {
-	ID  uint64 `json:"id"`
-	URL string `json:"url"`
+	ID    uint64 `json:"id"`
+	URL   string `json:"url"`
+	Index uint64 `json:"index,omitempty"` // broadcast index to start after
 }
 
 // DeleteReplicaCommand removes a replica.
@@ -1158,8 +1562,10 @@ func (dec *MessageDecoder) Decode(m *Message) error {
 	}
 	m.unmarshalHeader(b[:])
 
-	// Read data.
-	if _, err := io.ReadFull(dec.r, m.Data); err != nil {
+	// Read data. A header without its data is a partly written message.
+	if _, err := io.ReadFull(dec.r, m.Data); err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
 		return err
 	}
 
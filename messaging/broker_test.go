@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -224,6 +225,90 @@ func TestBroker_Reopen(t *testing.T) {
 	}
 }
 
+// Ensure the broker removes a torn message from the end of a segment on open.
+func TestBroker_Reopen_TruncatedSegment(t *testing.T) {
+	b := NewBroker(nil)
+	defer b.Close()
+	b.MustCreateReplica(2000, &url.URL{Host: "localhost"})
+	b.MustSubscribe(2000, 20)
+	index := b.MustPublishSync(&messaging.Message{TopicID: 20, Data: []byte("0000")})
+
+	// Append a partial message to the topic's only segment.
+	path, u := b.Path(), b.URL()
+	b.Broker.Close()
+	fis, err := ioutil.ReadDir(filepath.Join(path, "20"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(fis) != 1 {
+		t.Fatalf("unexpected segment count: %d", len(fis))
+	}
+	segPath := filepath.Join(path, "20", fis[0].Name())
+	f, err := os.OpenFile(segPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 0, 0})
+	f.Close()
+
+	b.Broker = messaging.NewBroker()
+	if err := b.Broker.Open(path, u); err != nil {
+		t.Fatal(err)
+	}
+	if a := b.TruncatedSegments(); !reflect.DeepEqual(a, []string{segPath}) {
+		t.Fatalf("unexpected truncated segments: %v", a)
+	} else if fi, _ := os.Stat(segPath); fi.Size() != fis[0].Size() {
+		t.Fatalf("unexpected segment size: %d", fi.Size())
+	} else if newIndex := b.Index(); newIndex != index {
+		t.Fatalf("index mismatch: exp=%d, got=%d", index, newIndex)
+	}
+}
+
+// Ensure the broker removes topic segments once every replica has read them.
+func TestBroker_Truncate(t *testing.T) {
+	b := NewBroker(nil)
+	defer b.Close()
+	b.MaxSegmentSize = 1
+	b.TruncationWindow = 1
+
+	b.MustCreateReplica(2000, &url.URL{Host: "localhost"})
+	b.MustSubscribe(2000, 20)
+	var index uint64
+	for i := 0; i < 5; i++ {
+		index = b.MustPublishSync(&messaging.Message{TopicID: 20, Data: []byte("0000")})
+	}
+	segmentN := func() int {
+		fis, err := ioutil.ReadDir(filepath.Join(b.Path(), "20"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(fis)
+	}
+
+	// Nothing is removed until the replica has read the topic.
+	if err := b.Truncate(); err != nil {
+		t.Fatal(err)
+	} else if n := segmentN(); n != 5 {
+		t.Fatalf("unexpected segment count: %d", n)
+	}
+
+	// Segments before the window are removed once read.
+	if a := Messages(b.MustReadAll(2000)).Unicasted(); len(a) != 5 {
+		t.Fatalf("unexpected message count: %d", len(a))
+	}
+	if err := b.Truncate(); err != nil {
+		t.Fatal(err)
+	} else if n := segmentN(); n != 1 {
+		t.Fatalf("unexpected segment count: %d", n)
+	}
+
+	// New subscribers start after the truncated messages.
+	b.MustCreateReplica(2001, &url.URL{Host: "localhost"})
+	b.MustSubscribe(2001, 20)
+	if a := Messages(b.MustReadAll(2001)).Unicasted(); len(a) != 1 || a[0].Index != index {
+		t.Fatalf("unexpected messages: %#v", a)
+	}
+}
+
 // Benchmarks a single broker without HTTP.
 func BenchmarkBroker_Publish(b *testing.B) {
 	br := NewBroker(nil)
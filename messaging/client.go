@@ -13,14 +13,30 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/influxdb/influxdb/raft"
+	"github.com/influxdb/influxdb/transport"
 )
 
 // DefaultReconnectTimeout is the default time to wait between when a broker
 // stream disconnects and another connection is retried.
 const DefaultReconnectTimeout = 100 * time.Millisecond
 
+// DefaultFailoverTimeout is the default time to keep retrying a request
+// against other brokers while a new leader is elected.
+const DefaultFailoverTimeout = 10 * time.Second
+
+// DefaultMaxBatchSize is the default maximum number of messages that are
+// published to the broker in a single request.
+const DefaultMaxBatchSize = 1000
+
+// DefaultMaxPipelineDepth is the default maximum number of batches that can
+// be waiting on the broker at the same time.
+const DefaultMaxPipelineDepth = 4
+
 // ClientConfig represents the Client configuration that must be persisted
 // across restarts.
 type ClientConfig struct {
@@ -41,18 +57,44 @@ type Client struct {
 	mu        sync.Mutex
 	replicaID uint64       // the replica that the client is connecting as.
 	config    ClientConfig // The Client state that must be persisted to disk.
+	path      string       // where the config is persisted.
 
 	opened bool
 	done   chan chan struct{} // disconnection notification
 
+	// Publish requests waiting to be batched and the channel that stops the
+	// publisher. In-flight batches are tracked by the wait group.
+	publishing chan *publishRequest
+	closing    chan struct{}
+	wg         sync.WaitGroup
+
+	// Highest index per topic that must be received before the client has
+	// caught up with the broker. Nil until a stream is connected.
+	pending map[uint64]uint64
+
 	// Channel streams messages from the broker.
 	c chan *Message
 
 	// The amount of time to wait before reconnecting to a broker stream.
 	ReconnectTimeout time.Duration
 
+	// The amount of time to keep retrying requests against other brokers
+	// when the leader is unavailable.
+	FailoverTimeout time.Duration
+
+	// The maximum number of messages published in a single request.
+	// Concurrent calls to Publish are combined into batches up to this size.
+	MaxBatchSize int
+
+	// The maximum number of batches sent to the broker before the first is
+	// acknowledged. Acknowledgements are returned in the order sent.
+	MaxPipelineDepth int
+
 	// The logging interface used by the client for out-of-band errors.
 	Logger *log.Logger
+
+	// The HTTP client used to reach the brokers.
+	HTTPClient *http.Client
 }
 
 // NewClient returns a new instance of Client.
@@ -60,7 +102,11 @@ func NewClient(replicaID uint64) *Client {
 	return &Client{
 		replicaID:        replicaID,
 		ReconnectTimeout: DefaultReconnectTimeout,
+		FailoverTimeout:  DefaultFailoverTimeout,
+		MaxBatchSize:     DefaultMaxBatchSize,
+		MaxPipelineDepth: DefaultMaxPipelineDepth,
 		Logger:           log.New(os.Stderr, "[messaging] ", log.LstdFlags),
+		HTTPClient:       transport.Default.Client(0),
 	}
 }
 
@@ -72,6 +118,47 @@ func (c *Client) ReplicaID() uint64 { return c.replicaID }
 // of the incoming message index to make sure it has not been processed.
 func (c *Client) C() <-chan *Message { return c.c }
 
+// CaughtUp returns true once the client has received every message that
+// existed on the broker when the current stream was connected.
+func (c *Client) CaughtUp() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending != nil && len(c.pending) == 0
+}
+
+// setPending parses the topic indexes the broker reports as unread.
+func (c *Client) setPending(s string) {
+	pending := make(map[uint64]uint64)
+	for _, kv := range strings.Split(s, ",") {
+		a := strings.SplitN(kv, "=", 2)
+		if len(a) != 2 {
+			continue
+		}
+		topicID, err := strconv.ParseUint(a[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		index, err := strconv.ParseUint(a[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		pending[topicID] = index
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = pending
+}
+
+// markRead removes a topic from the pending set once its high water mark is read.
+func (c *Client) markRead(m *Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index, ok := c.pending[m.TopicID]; ok && m.Index >= index {
+		delete(c.pending, m.TopicID)
+	}
+}
+
 // URLs returns a list of broker URLs to connect to.
 func (c *Client) URLs() []*url.URL {
 	c.mu.Lock()
@@ -148,9 +235,10 @@ func (c *Client) Open(path string, urls []*url.URL) error {
 		return ErrBrokerURLRequired
 	}
 
-	// Now that we have the seed URLs, actually use these to
-	// get the actual Broker URLs. Do that here.
-	c.config.Brokers = urls // Let's pretend they are the same
+	// Start with the seed URLs. The full list of brokers is sent by the
+	// broker when the stream connects.
+	c.config.Brokers = urls
+	c.path = path
 
 	// Create a channel for streaming messages.
 	c.c = make(chan *Message, 0)
@@ -161,6 +249,12 @@ func (c *Client) Open(path string, urls []*url.URL) error {
 		go c.streamer(c.done)
 	}
 
+	// Start batching published messages.
+	c.publishing = make(chan *publishRequest)
+	c.closing = make(chan struct{})
+	c.wg.Add(1)
+	go c.publisher(c.publishing, c.closing)
+
 	// Set open flag.
 	c.opened = true
 
@@ -170,13 +264,24 @@ func (c *Client) Open(path string, urls []*url.URL) error {
 // Close disconnects the client from the broker cluster.
 func (c *Client) Close() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 
 	// Return error if the client is already closed.
-	if !c.opened {
+	if !c.opened || c.closing == nil {
+		c.mu.Unlock()
 		return ErrClientClosed
 	}
 
+	// Stop the publisher and wait for in-flight batches to be acknowledged.
+	// The lock is released as publishing requires it to find the leader.
+	closing := c.closing
+	c.closing, c.publishing = nil, nil
+	c.mu.Unlock()
+	close(closing)
+	c.wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	// Shutdown streamer.
 	if c.done != nil {
 		ch := make(chan struct{})
@@ -196,193 +301,299 @@ func (c *Client) Close() error {
 }
 
 // Publish sends a message to the broker and returns an index or error.
+// Messages published concurrently are sent to the broker together.
 func (c *Client) Publish(m *Message) (uint64, error) {
-	var resp *http.Response
-	var err error
+	c.mu.Lock()
+	publishing, closing := c.publishing, c.closing
+	c.mu.Unlock()
+	if closing == nil {
+		return 0, ErrClientClosed
+	}
 
-	for {
-		u := *c.LeaderURL()
-		// Send the message to the messages endpoint.
-		u.Path = "/messaging/messages"
-		u.RawQuery = url.Values{
-			"type":    {strconv.FormatUint(uint64(m.Type), 10)},
-			"topicID": {strconv.FormatUint(m.TopicID, 10)},
-		}.Encode()
-		resp, err = http.Post(u.String(), "application/octet-stream", bytes.NewReader(m.Data))
-		if err != nil {
-			return 0, err
-		}
-		defer resp.Body.Close()
+	// Queue the message on the publisher and wait for its acknowledgement.
+	req := &publishRequest{m: m, done: make(chan struct{})}
+	select {
+	case publishing <- req:
+	case <-closing:
+		return 0, ErrClientClosed
+	}
+	<-req.done
+	return req.index, req.err
+}
 
-		// If a temporary redirect occurs then update the leader and retry.
-		// If a non-200 status is returned then an error occurred.
-		if resp.StatusCode == http.StatusTemporaryRedirect {
-			redirectURL, err := url.Parse(resp.Header.Get("Location"))
-			if err != nil {
-				return 0, fmt.Errorf("bad redirect: %s", resp.Header.Get("Location"))
-			}
-			c.SetLeaderURL(redirectURL)
-			continue
-		} else if resp.StatusCode != http.StatusOK {
-			if errstr := resp.Header.Get("X-Broker-Error"); errstr != "" {
-				return 0, errors.New(errstr)
-			}
-			return 0, fmt.Errorf("cannot publish(%d)", resp.StatusCode)
-		} else {
-			break
+// PublishBatch sends a list of messages to the broker in a single request.
+// Returns the index of each message, in order, or an error.
+func (c *Client) PublishBatch(a []*Message) ([]uint64, error) {
+	var buf bytes.Buffer
+	for _, m := range a {
+		if _, err := m.WriteTo(&buf); err != nil {
+			return nil, err
 		}
 	}
 
-	// Parse broker index.
+	resp, err := c.do("POST", "/messaging/batches", nil, buf.Bytes(), http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = transport.CloseBody(resp) }()
+
+	// Parse the index of the first message. The rest follow consecutively.
 	index, err := strconv.ParseUint(resp.Header.Get("X-Broker-Index"), 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("invalid index: %s", err)
+		return nil, fmt.Errorf("invalid index: %s", err)
 	}
 
-	return index, nil
+	indexes := make([]uint64, len(a))
+	for i := range indexes {
+		indexes[i] = index + uint64(i)
+	}
+	return indexes, nil
 }
 
-// CreateReplica creates a replica on the broker.
-func (c *Client) CreateReplica(id uint64, u *url.URL) error {
-	var resp *http.Response
-	var err error
+// publishRequest represents a message waiting to be published by the publisher.
+type publishRequest struct {
+	m     *Message
+	index uint64
+	err   error
+	done  chan struct{}
+}
+
+// publisher combines waiting publish requests into batches and sends them to
+// the broker. Up to MaxPipelineDepth batches can be in flight at once but the
+// requests are acknowledged in the order that their batches were sent.
+func (c *Client) publisher(publishing <-chan *publishRequest, closing <-chan struct{}) {
+	defer c.wg.Done()
+
+	depth := c.MaxPipelineDepth
+	if depth < 1 {
+		depth = 1
+	}
+	slots := make(chan struct{}, depth)
+
+	// The acknowledgement of the previous batch.
+	prev := make(chan struct{})
+	close(prev)
 
 	for {
-		u := *c.LeaderURL()
-		u.Path = "/messaging/replicas"
-		u.RawQuery = url.Values{
-			"id":  {strconv.FormatUint(id, 10)},
-			"url": {u.String()},
-		}.Encode()
-		resp, err = http.Post(u.String(), "application/octet-stream", nil)
-		if err != nil {
-			return err
+		// Wait for the first request of the batch.
+		var batch []*publishRequest
+		select {
+		case req := <-publishing:
+			batch = append(batch, req)
+		case <-closing:
+			return
 		}
-		defer func() { _ = resp.Body.Close() }()
 
-		// If a temporary redirect occurs then update the leader and retry.
-		// If a non-201 status is returned then an error occurred.
-		if resp.StatusCode == http.StatusTemporaryRedirect {
-			redirectURL, err := url.Parse(resp.Header.Get("Location"))
-			if err != nil {
-				return fmt.Errorf("bad redirect: %s", resp.Header.Get("Location"))
+		// Add requests that are already waiting, up to the batch size.
+	gather:
+		for len(batch) < c.MaxBatchSize {
+			select {
+			case req := <-publishing:
+				batch = append(batch, req)
+			default:
+				break gather
 			}
-			c.SetLeaderURL(redirectURL)
-			continue
-		} else if resp.StatusCode != http.StatusCreated {
-			return errors.New(resp.Header.Get("X-Broker-Error"))
 		}
-		break
-	}
 
-	return nil
+		// Wait for room in the pipeline and send the batch.
+		slots <- struct{}{}
+		done := make(chan struct{})
+		c.wg.Add(1)
+		go func(batch []*publishRequest, prev, done chan struct{}) {
+			defer c.wg.Done()
+			defer close(done)
+
+			a := make([]*Message, len(batch))
+			for i, req := range batch {
+				a[i] = req.m
+			}
+			indexes, err := c.PublishBatch(a)
+			<-slots
+
+			// Acknowledge after the previous batch.
+			<-prev
+			for i, req := range batch {
+				if err != nil {
+					req.err = err
+				} else {
+					req.index = indexes[i]
+				}
+				close(req.done)
+			}
+		}(batch, prev, done)
+		prev = done
+	}
 }
 
-// DeleteReplica removes a replica on the broker.
-func (c *Client) DeleteReplica(id uint64) error {
-	var resp *http.Response
-	var err error
+// CreateReplica creates a replica on the broker.
+// The replica receives broadcast messages published after it is created.
+func (c *Client) CreateReplica(id uint64, u *url.URL) error {
+	return c.CreateReplicaFrom(id, u, 0)
+}
 
-	for {
-		u := *c.LeaderURL()
-		u.Path = "/messaging/replicas"
-		u.RawQuery = url.Values{"id": {strconv.FormatUint(id, 10)}}.Encode()
-		req, _ := http.NewRequest("DELETE", u.String(), nil)
-		resp, err = http.DefaultClient.Do(req)
-		if err != nil {
-			return err
-		}
-		defer func() { _ = resp.Body.Close() }()
+// CreateReplicaFrom creates a replica on the broker that receives the
+// broadcast messages after index. An index of zero starts the replica at
+// the end of the broadcast topic.
+func (c *Client) CreateReplicaFrom(id uint64, u *url.URL, index uint64) error {
+	values := url.Values{
+		"id":  {strconv.FormatUint(id, 10)},
+		"url": {u.String()},
+	}
+	if index > 0 {
+		values.Set("index", strconv.FormatUint(index, 10))
+	}
 
-		// If a temporary redirect occurs then update the leader and retry.
-		// If a non-204 status is returned then an error occurred.
-		if resp.StatusCode == http.StatusTemporaryRedirect {
-			redirectURL, err := url.Parse(resp.Header.Get("Location"))
-			if err != nil {
-				return fmt.Errorf("bad redirect: %s", resp.Header.Get("Location"))
-			}
-			c.SetLeaderURL(redirectURL)
-			continue
-		} else if resp.StatusCode != http.StatusNoContent {
-			return errors.New(resp.Header.Get("X-Broker-Error"))
-		}
-		break
+	resp, err := c.do("POST", "/messaging/replicas", values, nil, http.StatusCreated)
+	if err != nil {
+		return err
 	}
+	return transport.CloseBody(resp)
+}
 
-	return nil
+// DeleteReplica removes a replica on the broker.
+func (c *Client) DeleteReplica(id uint64) error {
+	resp, err := c.do("DELETE", "/messaging/replicas", url.Values{
+		"id": {strconv.FormatUint(id, 10)},
+	}, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return transport.CloseBody(resp)
 }
 
 // Subscribe subscribes a replica to a topic on the broker.
 func (c *Client) Subscribe(replicaID, topicID uint64) error {
-	var resp *http.Response
-	var err error
+	resp, err := c.do("POST", "/messaging/subscriptions", url.Values{
+		"replicaID": {strconv.FormatUint(replicaID, 10)},
+		"topicID":   {strconv.FormatUint(topicID, 10)},
+	}, nil, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return transport.CloseBody(resp)
+}
 
-	for {
+// Unsubscribe unsubscribes a replica from a topic on the broker.
+func (c *Client) Unsubscribe(replicaID, topicID uint64) error {
+	resp, err := c.do("DELETE", "/messaging/subscriptions", url.Values{
+		"replicaID": {strconv.FormatUint(replicaID, 10)},
+		"topicID":   {strconv.FormatUint(topicID, 10)},
+	}, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return transport.CloseBody(resp)
+}
+
+// do sends a request to the broker leader and returns the response if it has
+// the expected status. If the leader cannot be reached or has lost leadership
+// then the request is retried against the other brokers until every broker
+// has been tried and FailoverTimeout has elapsed.
+func (c *Client) do(method, path string, values url.Values, body []byte, status int) (*http.Response, error) {
+	deadline := time.Now().Add(c.FailoverTimeout)
+	for attempt := 1; ; attempt++ {
 		u := *c.LeaderURL()
-		u.Path = "/messaging/subscriptions"
-		u.RawQuery = url.Values{
-			"replicaID": {strconv.FormatUint(replicaID, 10)},
-			"topicID":   {strconv.FormatUint(topicID, 10)},
-		}.Encode()
-		resp, err = http.Post(u.String(), "application/octet-stream", nil)
+		u.Path = path
+		u.RawQuery = values.Encode()
+		req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
 		if err != nil {
-			return err
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		// If the broker is unavailable then move to the next broker and retry.
+		resp, err := c.HTTPClient.Do(req)
+		if err == nil && isNotLeader(resp) {
+			_ = transport.CloseBody(resp)
+			err = raft.ErrNotLeader
+		}
+		if err != nil {
+			if attempt >= len(c.URLs()) && time.Now().After(deadline) {
+				return nil, err
+			}
+			c.Logger.Printf("broker unavailable: %s: %s", u.Host, err)
+			c.failover(&u)
+			time.Sleep(c.ReconnectTimeout)
+			continue
 		}
-		defer func() { _ = resp.Body.Close() }()
 
 		// If a temporary redirect occurs then update the leader and retry.
-		// If a non-201 status is returned then an error occurred.
+		// If any other status is returned then an error occurred.
 		if resp.StatusCode == http.StatusTemporaryRedirect {
+			_ = transport.CloseBody(resp)
 			redirectURL, err := url.Parse(resp.Header.Get("Location"))
 			if err != nil {
-				return fmt.Errorf("bad redirect: %s", resp.Header.Get("Location"))
+				return nil, fmt.Errorf("bad redirect: %s", resp.Header.Get("Location"))
 			}
 			c.SetLeaderURL(redirectURL)
 			continue
-		} else if resp.StatusCode != http.StatusCreated {
-			return errors.New(resp.Header.Get("X-Broker-Error"))
+		} else if resp.StatusCode != status {
+			_ = transport.CloseBody(resp)
+			if errstr := resp.Header.Get("X-Broker-Error"); errstr != "" {
+				return nil, errors.New(errstr)
+			}
+			return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
 		}
-		break
+
+		return resp, nil
 	}
+}
 
-	return nil
+// isNotLeader returns true if the broker rejected a request because it is not
+// the leader and does not know who is.
+func isNotLeader(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusInternalServerError && resp.Header.Get("X-Broker-Error") == raft.ErrNotLeader.Error()
 }
 
-// Unsubscribe unsubscribes a replica from a topic on the broker.
-func (c *Client) Unsubscribe(replicaID, topicID uint64) error {
-	var resp *http.Response
-	var err error
+// failover moves the leader to the broker after u so the next request is sent
+// to a different broker.
+func (c *Client) failover(u *url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	for {
-		u := *c.LeaderURL()
-		u.Path = "/messaging/subscriptions"
-		u.RawQuery = url.Values{
-			"replicaID": {strconv.FormatUint(replicaID, 10)},
-			"topicID":   {strconv.FormatUint(topicID, 10)},
-		}.Encode()
-		req, _ := http.NewRequest("DELETE", u.String(), nil)
-		resp, err = http.DefaultClient.Do(req)
-		if err != nil {
-			return err
+	brokers := c.config.Brokers
+	for i, b := range brokers {
+		if b.Host == u.Host {
+			c.config.Leader = brokers[(i+1)%len(brokers)]
+			return
 		}
-		defer func() { _ = resp.Body.Close() }()
+	}
+	c.config.Leader = nil
+}
 
-		// If a temporary redirect occurs then update the leader and retry.
-		// If a non-204 status is returned then an error occurred.
-		if resp.StatusCode == http.StatusTemporaryRedirect {
-			redirectURL, err := url.Parse(resp.Header.Get("Location"))
-			if err != nil {
-				return fmt.Errorf("bad redirect: %s", resp.Header.Get("Location"))
-			}
-			c.SetLeaderURL(redirectURL)
-			continue
-		} else if resp.StatusCode != http.StatusNoContent {
-			return errors.New(resp.Header.Get("X-Broker-Error"))
-		}
-		break
+// setBrokers updates the list of brokers in the cluster and persists it so that
+// the client can find the cluster after a restart.
+func (c *Client) setBrokers(a []*url.URL) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Ignore if the list hasn't changed.
+	if len(a) == 0 || urlsEqual(a, c.config.Brokers) {
+		return nil
 	}
+	c.config.Brokers = a
 
-	return nil
+	if c.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(&c.config)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, b, 0600)
+}
+
+// urlsEqual returns true if two lists of URLs have the same hosts in the same order.
+func urlsEqual(a, b []*url.URL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+	return true
 }
 
 // streamer connects to a broker server and streams the replica's messages.
@@ -416,7 +627,7 @@ func (c *Client) streamer(done chan chan struct{}) {
 func (c *Client) streamFromURL(u *url.URL, done chan chan struct{}) error {
 	// Set the replica id on the URL and open the stream.
 	u.RawQuery = url.Values{"replicaID": {strconv.FormatUint(c.replicaID, 10)}}.Encode()
-	resp, err := http.Get(u.String())
+	resp, err := c.HTTPClient.Get(u.String())
 	if err != nil {
 		time.Sleep(c.ReconnectTimeout)
 		return nil
@@ -431,6 +642,12 @@ func (c *Client) streamFromURL(u *url.URL, done chan chan struct{}) error {
 	}
 
 	c.Logger.Printf("connected to broker: %s", u)
+	c.setPending(resp.Header.Get("X-Broker-Pending"))
+
+	// Keep track of every broker in the cluster for failover.
+	if err := c.setBrokers(parseURLs(resp.Header.Get("X-Broker-URLs"))); err != nil {
+		c.Logger.Printf("save brokers: %s", err)
+	}
 
 	// Continuously decode messages from request body in a separate goroutine.
 	errNotify := make(chan error, 0)
@@ -448,6 +665,7 @@ func (c *Client) streamFromURL(u *url.URL, done chan chan struct{}) error {
 
 			// Write message to streaming channel.
 			c.c <- m
+			c.markRead(m)
 		}
 	}()
 
@@ -474,3 +692,13 @@ func (c *Client) streamFromURL(u *url.URL, done chan chan struct{}) error {
 
 // marker error for the streamer.
 var errDone = errors.New("done")
+
+// parseURLs parses a comma-delimited list of URLs. Invalid URLs are skipped.
+func parseURLs(s string) (a []*url.URL) {
+	for _, s := range strings.Split(s, ",") {
+		if u, err := url.Parse(s); err == nil && s != "" {
+			a = append(a, u)
+		}
+	}
+	return
+}
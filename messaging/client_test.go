@@ -2,10 +2,12 @@ package messaging_test
 
 import (
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -48,6 +50,37 @@ func TestClient_Open(t *testing.T) {
 	}
 }
 
+// Ensure that a client reports when it has read the messages on the broker.
+func TestClient_CaughtUp(t *testing.T) {
+	c := NewClient(1000)
+	defer c.Close()
+
+	// Create replica on broker.
+	c.Server.Handler.Broker().CreateReplica(1000, &url.URL{Host: "localhost"})
+
+	// Open client to broker.
+	f := NewTempFile()
+	defer os.Remove(f)
+	u, _ := url.Parse(c.Server.URL)
+	if err := c.Open(f, []*url.URL{u}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The client cannot be caught up until it reads the replica creation.
+	if c.CaughtUp() {
+		t.Fatal("expected client to be catching up")
+	}
+	<-c.C()
+
+	// Wait for the client to mark the message as read.
+	for i := 0; !c.CaughtUp(); i++ {
+		if i > 100 {
+			t.Fatal("expected client to be caught up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 // Ensure that opening an already open client returns an error.
 func TestClient_Open_ErrClientOpen(t *testing.T) {
 	c := NewClient(1000)
@@ -150,9 +183,71 @@ func TestClient_Publish(t *testing.T) {
 	}
 }
 
+// Ensure that a client can publish a batch of messages in a single request.
+func TestClient_PublishBatch(t *testing.T) {
+	c := OpenClient(1000)
+	defer c.Close()
+
+	// Publish messages to the broker.
+	indexes, err := c.PublishBatch([]*messaging.Message{
+		{Type: 100, TopicID: messaging.BroadcastTopicID, Data: []byte{0}},
+		{Type: 100, TopicID: messaging.BroadcastTopicID, Data: []byte{1}},
+		{Type: 100, TopicID: messaging.BroadcastTopicID, Data: []byte{2}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !reflect.DeepEqual(indexes, []uint64{3, 4, 5}) {
+		t.Fatalf("unexpected indexes: %v", indexes)
+	}
+}
+
+// Ensure that concurrent publishes are each acknowledged with their own index.
+func TestClient_Publish_Concurrent(t *testing.T) {
+	c := OpenClient(1000)
+	defer c.Close()
+	c.MaxBatchSize = 10
+
+	// Publish messages from several goroutines.
+	const n = 100
+	var wg sync.WaitGroup
+	indexes := make([]uint64, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			indexes[i], errs[i] = c.Publish(&messaging.Message{Type: 100, TopicID: messaging.BroadcastTopicID, Data: []byte{byte(i)}})
+		}(i)
+	}
+	wg.Wait()
+
+	// Every message should have a unique index after the replica's creation.
+	seen := make(map[uint64]bool)
+	for i := range indexes {
+		if errs[i] != nil {
+			t.Fatalf("unexpected error(%d): %v", i, errs[i])
+		} else if indexes[i] < 3 || indexes[i] >= 3+n || seen[indexes[i]] {
+			t.Fatalf("unexpected index(%d): %d", i, indexes[i])
+		}
+		seen[indexes[i]] = true
+	}
+}
+
+// Ensure that publishing on a closed client returns an error.
+func TestClient_Publish_ErrClientClosed(t *testing.T) {
+	c := OpenClient(1000)
+	defer c.Close()
+	c.Client.Close()
+
+	if _, err := c.Publish(&messaging.Message{Type: 100, TopicID: 0, Data: []byte{0}}); err != messaging.ErrClientClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Ensure that a client receives an error when publishing to a stopped server.
 func TestClient_Publish_ErrConnectionRefused(t *testing.T) {
 	c := OpenClient(1000)
+	c.FailoverTimeout = 0
 	c.Server.Close()
 	defer c.Close()
 
@@ -162,6 +257,29 @@ func TestClient_Publish_ErrConnectionRefused(t *testing.T) {
 	}
 }
 
+// Ensure that a client fails over to another broker when the leader is unreachable.
+func TestClient_Publish_Failover(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	// Find an address that nothing is listening on.
+	dead := NewServer()
+	dead.Close()
+
+	c := messaging.NewClient(0)
+	c.ReconnectTimeout = 0
+	if err := c.Open("", []*url.URL{MustParseURL(dead.URL), MustParseURL(s.URL)}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Publish(&messaging.Message{Type: 100, TopicID: messaging.BroadcastTopicID, Data: []byte{0}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if u := c.LeaderURL().String(); u != s.URL {
+		t.Fatalf("unexpected leader: %s", u)
+	}
+}
+
 // Ensure that a client receives an error when publishing to a closed broker.
 func TestClient_Publish_ErrLogClosed(t *testing.T) {
 	c := OpenClient(1000)
@@ -190,6 +308,43 @@ func TestClient_CreateReplica(t *testing.T) {
 	}
 }
 
+// Ensure that a client can create a replica that streams the broadcast topic after an index.
+func TestClient_CreateReplicaFrom(t *testing.T) {
+	c := OpenClient(0)
+	defer c.Close()
+
+	// Publish broadcast messages before the replica exists.
+	var indexes []uint64
+	for i := 0; i < 3; i++ {
+		index, err := c.Publish(&messaging.Message{Type: 100, TopicID: messaging.BroadcastTopicID, Data: []byte{byte(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexes = append(indexes, index)
+	}
+
+	// Create a replica that starts after the first message.
+	if err := c.CreateReplicaFrom(123, &url.URL{Host: "localhost"}, indexes[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Stream the replica and verify it starts after the index.
+	resp, err := http.Get(c.Server.URL + `/messaging/messages?replicaID=123`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	dec := messaging.NewMessageDecoder(resp.Body)
+	for _, index := range indexes[1:] {
+		var m messaging.Message
+		if err := dec.Decode(&m); err != nil {
+			t.Fatal(err)
+		} else if m.Index != index {
+			t.Fatalf("unexpected index: %d, expected %d", m.Index, index)
+		}
+	}
+}
+
 // Ensure that a client can passthrough an error while creating a replica.
 func TestClient_CreateReplica_Err(t *testing.T) {
 	c := OpenClient(0)
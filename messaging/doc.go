@@ -10,5 +10,14 @@ placed in a single "config" topic that is replicated to all data nodes. Each
 shard's data is placed in its own topic so that it can be parallized across the
 cluster.
 
+Subscriptions
+
+A data node subscribes only to the topics of the shards it stores and
+unsubscribes once a shard is dropped, so it never receives writes for other
+shards. Each subscription keeps its own index and a reconnecting data node
+replays every topic from that index, so catching up only reads the writes it
+missed for its own shards. The config topic is not partitioned because every
+data node applies all configuration changes.
+
 */
 package messaging
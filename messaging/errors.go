@@ -31,6 +31,9 @@ var (
 	// there is no writer attached to the replica.
 	errReplicaUnavailable = errors.New("replica unavailable")
 
+	// ErrInvalidIndex is returned when a request has an index that can't be parsed.
+	ErrInvalidIndex = errors.New("invalid index")
+
 	// ErrClientOpen is returned when opening an already open client.
 	ErrClientOpen = errors.New("client already open")
 
@@ -43,6 +46,13 @@ var (
 	// ErrMessageTypeRequired is returned publishing a message without a type.
 	ErrMessageTypeRequired = errors.New("message type required")
 
+	// ErrMessageRequired is returned when publishing an empty batch of messages.
+	ErrMessageRequired = errors.New("message required")
+
 	// ErrTopicRequired is returned publishing a message without a topic ID.
 	ErrTopicRequired = errors.New("topic required")
+
+	// ErrTopicTruncated is returned when reading a topic from an index that
+	// has already been removed by truncation.
+	ErrTopicTruncated = errors.New("topic truncated")
 )
@@ -1,6 +1,8 @@
 package messaging
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -57,6 +59,12 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else {
 			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		}
+	case "/messaging/batches":
+		if r.Method == "POST" {
+			h.publishBatch(w, r)
+		} else {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
 	case "/messaging/replicas":
 		if r.Method == "POST" {
 			h.createReplica(w, r)
@@ -96,6 +104,24 @@ func (h *Handler) stream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Let the client know how far it has to read to catch up.
+	var pending []string
+	for topicID, index := range h.broker.pendingIndexes(replica) {
+		pending = append(pending, fmt.Sprintf("%d=%d", topicID, index))
+	}
+	w.Header().Set("X-Broker-Pending", strings.Join(pending, ","))
+
+	// Let the client know every broker it can fail over to.
+	var urls []string
+	for _, u := range h.broker.URLs() {
+		urls = append(urls, u.String())
+	}
+	w.Header().Set("X-Broker-URLs", strings.Join(urls, ","))
+	w.WriteHeader(http.StatusOK)
+	if w, ok := w.(http.Flusher); ok {
+		w.Flush()
+	}
+
 	// Connect the response writer to the replica.
 	// This will block until the replica is closed or a new writer connects.
 	_, _ = replica.WriteTo(w)
@@ -143,6 +169,40 @@ func (h *Handler) publish(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Broker-Index", strconv.FormatUint(index, 10))
 }
 
+// publishes a batch of encoded messages to the broker in order.
+func (h *Handler) publishBatch(w http.ResponseWriter, r *http.Request) {
+	// Decode messages from the request body.
+	var a []*Message
+	dec := NewMessageDecoder(r.Body)
+	for {
+		m := &Message{}
+		if err := dec.Decode(m); err == io.EOF {
+			break
+		} else if err != nil {
+			h.error(w, err, http.StatusBadRequest)
+			return
+		}
+		a = append(a, m)
+	}
+	if len(a) == 0 {
+		h.error(w, ErrMessageRequired, http.StatusBadRequest)
+		return
+	}
+
+	// Publish messages to the broker.
+	index, err := h.broker.PublishBatch(a)
+	if err == raft.ErrNotLeader {
+		h.redirectToLeader(w, r)
+		return
+	} else if err != nil {
+		h.error(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Return the index of the first message. The rest follow consecutively.
+	w.Header().Set("X-Broker-Index", strconv.FormatUint(index, 10))
+}
+
 // createReplica creates a new replica with a given ID.
 func (h *Handler) createReplica(w http.ResponseWriter, r *http.Request) {
 	// Read the replica ID.
@@ -159,8 +219,17 @@ func (h *Handler) createReplica(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Read the optional broadcast index to start the replica after.
+	var index uint64
+	if s := r.URL.Query().Get("index"); s != "" {
+		if index, err = strconv.ParseUint(s, 10, 64); err != nil {
+			h.error(w, ErrInvalidIndex, http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Create a new replica on the broker.
-	if err := h.broker.CreateReplica(replicaID, u); err == raft.ErrNotLeader {
+	if err := h.broker.CreateReplicaFrom(replicaID, u, index); err == raft.ErrNotLeader {
 		h.redirectToLeader(w, r)
 		return
 	} else if err == ErrReplicaExists {
@@ -1,6 +1,7 @@
 package messaging_test
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -27,6 +28,8 @@ func TestHandler_stream(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	} else if resp.StatusCode != http.StatusOK {
 		t.Fatalf("unexpected status: %d: %s", resp.StatusCode, resp.Header.Get("X-Broker-Error"))
+	} else if v := resp.Header.Get("X-Broker-URLs"); v != s.URL {
+		t.Fatalf("unexpected broker urls: %s", v)
 	}
 	time.Sleep(10 * time.Millisecond)
 
@@ -161,6 +164,41 @@ func TestHandler_publish_ErrClosed(t *testing.T) {
 	}
 }
 
+// Ensure a handler can publish a batch of messages with consecutive indexes.
+func TestHandler_publishBatch(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	// Encode a batch of messages.
+	var buf bytes.Buffer
+	(&messaging.Message{Type: 100, TopicID: 200, Data: []byte("abc")}).WriteTo(&buf)
+	(&messaging.Message{Type: 100, TopicID: 200, Data: []byte("def")}).WriteTo(&buf)
+
+	// Send request to the broker.
+	resp, _ := http.Post(s.URL+`/messaging/batches`, "application/octet-stream", &buf)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", resp.StatusCode, resp.Header.Get("X-Broker-Error"))
+	} else if index := resp.Header.Get("X-Broker-Index"); index != "2" {
+		t.Fatalf("unexpected index: %s", index)
+	}
+}
+
+// Ensure a handler returns an error when publishing an empty batch.
+func TestHandler_publishBatch_ErrMessageRequired(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	// Send request to the broker.
+	resp, _ := http.Post(s.URL+`/messaging/batches`, "application/octet-stream", strings.NewReader(``))
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if resp.Header.Get("X-Broker-Error") != "message required" {
+		t.Fatalf("unexpected error: %s", resp.Header.Get("X-Broker-Error"))
+	}
+}
+
 // Ensure the handler routes raft requests to the raft handler.
 func TestHandler_raft(t *testing.T) {
 	s := NewServer()
@@ -201,6 +239,21 @@ func TestHandler_createReplica(t *testing.T) {
 	}
 }
 
+// Ensure a handler returns an error when creating a replica with an invalid index.
+func TestHandler_createReplica_ErrInvalidIndex(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	// Send request to the broker.
+	resp, _ := http.Post(s.URL+`/messaging/replicas?id=200&index=foo`, "application/octet-stream", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if resp.Header.Get("X-Broker-Error") != "invalid index" {
+		t.Fatalf("unexpected error: %s", resp.Header.Get("X-Broker-Error"))
+	}
+}
+
 // Ensure a handler returns an error when creating a replica without an id.
 func TestHandler_createReplica_ErrReplicaIDRequired(t *testing.T) {
 	s := NewServer()
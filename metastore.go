@@ -45,6 +45,8 @@ func (m *metastore) init() error {
 		_, _ = tx.CreateBucketIfNotExists([]byte("DataNodes"))
 		_, _ = tx.CreateBucketIfNotExists([]byte("Databases"))
 		_, _ = tx.CreateBucketIfNotExists([]byte("Users"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("Tokens"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("MetadataHistory"))
 		return nil
 	})
 }
@@ -209,6 +211,44 @@ func (tx *metatx) dropDatabase(name string) error {
 	return tx.Bucket([]byte("Databases")).DeleteBucket([]byte(name))
 }
 
+// renameDatabase moves a database's metadata and index to a new name.
+func (tx *metatx) renameDatabase(oldName, newName string) error {
+	if err := tx.cloneDatabase(oldName, newName); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte("Databases")).DeleteBucket([]byte(oldName))
+}
+
+// cloneDatabase copies a database's metadata and index to a new name.
+func (tx *metatx) cloneDatabase(name, newName string) error {
+	b := tx.Bucket([]byte("Databases"))
+	dst, err := b.CreateBucket([]byte(newName))
+	if err != nil {
+		return err
+	}
+	return copyBucket(dst, b.Bucket([]byte(name)))
+}
+
+// copyBucket copies all keys, nested buckets and sequences from src into dst.
+func copyBucket(dst, src *bolt.Bucket) error {
+	// Series ids are allocated from the bucket sequence so it must carry over.
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		// Nested buckets have a nil value.
+		if v != nil {
+			return dst.Put(k, v)
+		}
+
+		child, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(child, src.Bucket(k))
+	})
+}
+
 // dropMeasurement removes measurement from the metastore.
 func (tx *metatx) dropMeasurement(database, measurement string) error {
 	return tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).DeleteBucket([]byte(measurement))
@@ -244,6 +284,12 @@ func (tx *metatx) createSeries(database, name string, tags map[string]string) (*
 	return s, nil
 }
 
+// saveSeries persists the tags of an existing series to the metastore.
+func (tx *metatx) saveSeries(database, name string, s *Series) error {
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).Bucket([]byte(name))
+	return b.Put(u32tob(s.ID), mustMarshalJSON(s))
+}
+
 // dropSeries removes all seriesIDS for a given database/measurement
 func (tx *metatx) dropSeries(database string, seriesByMeasurement map[string][]uint32) error {
 	for measurement, ids := range seriesByMeasurement {
@@ -314,6 +360,56 @@ func (tx *metatx) deleteUser(name string) error {
 	return tx.Bucket([]byte("Users")).Delete([]byte(name))
 }
 
+// tokens returns a list of all API tokens from the metastore.
+func (tx *metatx) tokens() (a []*Token) {
+	c := tx.Bucket([]byte("Tokens")).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		t := &Token{}
+		mustUnmarshalJSON(v, &t)
+		a = append(a, t)
+	}
+	return
+}
+
+// saveToken persists an API token to the metastore.
+func (tx *metatx) saveToken(t *Token) error {
+	return tx.Bucket([]byte("Tokens")).Put([]byte(t.Name), mustMarshalJSON(t))
+}
+
+// deleteToken removes an API token from the metastore.
+func (tx *metatx) deleteToken(name string) error {
+	return tx.Bucket([]byte("Tokens")).Delete([]byte(name))
+}
+
+// metadataSnapshot returns the latest metadata snapshot at or before an index.
+func (tx *metatx) metadataSnapshot(index uint64) (ss *MetadataSnapshot) {
+	c := tx.Bucket([]byte("MetadataHistory")).Cursor()
+	k, v := c.Seek(u64tob(index))
+	if k == nil || btou64(k) > index {
+		k, v = c.Prev()
+	}
+	if k != nil {
+		mustUnmarshalJSON(v, &ss)
+	}
+	return
+}
+
+// metadataSnapshots returns all metadata snapshots ordered by index.
+func (tx *metatx) metadataSnapshots() (a []*MetadataSnapshot) {
+	c := tx.Bucket([]byte("MetadataHistory")).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		ss := &MetadataSnapshot{}
+		mustUnmarshalJSON(v, &ss)
+		a = append(a, ss)
+	}
+	return
+}
+
+// saveMetadataSnapshot persists a metadata snapshot keyed by its index.
+func (tx *metatx) saveMetadataSnapshot(ss *MetadataSnapshot) error {
+	return tx.Bucket([]byte("MetadataHistory")).Put(u64tob(ss.Index), mustMarshalJSON(ss))
+}
+
 // u64tob converts a uint64 into an 8-byte slice.
 func u64tob(v uint64) []byte {
 	b := make([]byte, 8)
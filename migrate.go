@@ -0,0 +1,204 @@
+package influxdb
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DataFormatVersion is the version of the data directory layout written by
+// this build. Directories at an older version are migrated when the server
+// opens them.
+const DataFormatVersion = 1
+
+// dataFormatVersionFile is the name of the file holding the format version of
+// a data directory. Directories written before it existed are at version 0.
+const dataFormatVersionFile = "VERSION"
+
+// Migration upgrades a data directory from the previous format version.
+type Migration struct {
+	Version     int                     // version of the directory once migrated
+	Description string                  // what the migration changes
+	Migrate     func(path string) error // rewrites the directory in place
+}
+
+// migrations upgrade the data directory to DataFormatVersion, in order.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "record the data format version",
+		Migrate:     func(path string) error { return nil },
+	},
+}
+
+// MigrateOptions controls how MigrateDataDir upgrades a data directory.
+type MigrateOptions struct {
+	DryRun bool        // report the migrations that would run without running them
+	Backup bool        // copy the directory before migrating it
+	Logger *log.Logger // logs each migration, discarded if nil
+}
+
+// MigrateReport describes the migrations run, or that would be run, by
+// MigrateDataDir.
+type MigrateReport struct {
+	From       int         // version of the directory before migrating
+	To         int         // version of the directory after migrating
+	Migrations []Migration // migrations run, in order
+	BackupPath string      // copy of the directory made before migrating
+}
+
+// ReadDataFormatVersion returns the format version of a data directory.
+// Returns zero if the directory has no version file.
+func ReadDataFormatVersion(path string) (int, error) {
+	b, err := ioutil.ReadFile(filepath.Join(path, dataFormatVersionFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid data format version: %q", strings.TrimSpace(string(b)))
+	}
+	return v, nil
+}
+
+// writeDataFormatVersion atomically sets the format version of a data directory.
+func writeDataFormatVersion(path string, v int) error {
+	tmp := filepath.Join(path, dataFormatVersionFile+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(v)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(path, dataFormatVersionFile))
+}
+
+// MigrateDataDir upgrades a data directory that is not in use to
+// DataFormatVersion. Each migration is run in order and the version file is
+// updated after each one, so a failed upgrade resumes from the last migration
+// that completed. A new directory is marked with the current version.
+//
+// Returns an error if the directory was written by a newer version.
+func MigrateDataDir(path string, opt MigrateOptions) (*MigrateReport, error) {
+	return migrateDataDir(path, migrations, opt)
+}
+
+func migrateDataDir(path string, migrations []Migration, opt MigrateOptions) (*MigrateReport, error) {
+	logger := opt.Logger
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	target := 0
+	if len(migrations) > 0 {
+		target = migrations[len(migrations)-1].Version
+	}
+
+	from, err := ReadDataFormatVersion(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &MigrateReport{From: from, To: from}
+
+	// Directories without a metastore hold no data and start at the current version.
+	if _, err := os.Stat(filepath.Join(path, "meta")); os.IsNotExist(err) && from == 0 {
+		r.To = target
+		if opt.DryRun {
+			return r, nil
+		}
+		return r, writeDataFormatVersion(path, target)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if from > target {
+		return nil, fmt.Errorf("%s: data format version %d, newer than supported version %d", path, from, target)
+	}
+
+	// Find the migrations to run.
+	for _, m := range migrations {
+		if m.Version > from {
+			r.Migrations = append(r.Migrations, m)
+		}
+	}
+	if len(r.Migrations) == 0 || opt.DryRun {
+		r.To = target
+		return r, nil
+	}
+
+	if opt.Backup {
+		r.BackupPath = fmt.Sprintf("%s.v%d-%s.bak", filepath.Clean(path), from, time.Now().UTC().Format("20060102T150405"))
+		logger.Printf("backing up %s to %s", path, r.BackupPath)
+		if err := copyDir(path, r.BackupPath); err != nil {
+			return r, fmt.Errorf("backup: %s", err)
+		}
+	}
+
+	for _, m := range r.Migrations {
+		logger.Printf("migrating %s to data format version %d: %s", path, m.Version, m.Description)
+		if err := m.Migrate(path); err != nil {
+			return r, fmt.Errorf("migrate to version %d: %s", m.Version, err)
+		}
+		if err := writeDataFormatVersion(path, m.Version); err != nil {
+			return r, err
+		}
+		r.To = m.Version
+	}
+	return r, nil
+}
+
+// copyDir recursively copies the directory src to dst, which must not exist.
+func copyDir(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+}
+
+// copyFile copies the file src to dst and syncs it to disk.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
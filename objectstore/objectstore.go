@@ -0,0 +1,234 @@
+// Package objectstore implements stores for shard files offloaded from local
+// disk: an S3-compatible client and a directory, such as a network mount.
+package objectstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Dir stores objects as files under a directory. Keys are slash-separated
+// paths relative to the directory.
+type Dir struct {
+	Path string
+}
+
+// path returns the file path of the object with key.
+func (d *Dir) path(key string) string {
+	return filepath.Join(d.Path, filepath.FromSlash(key))
+}
+
+// Put writes an object of size bytes read from r. The object is written to
+// a temporary file first so a partially written object is never read.
+func (d *Dir) Put(key string, r io.Reader, size int64) error {
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if n, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	} else if n != size {
+		_ = f.Close()
+		return fmt.Errorf("short write: %d of %d bytes", n, size)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Get opens an object for reading. Returns ErrNotFound if it doesn't exist.
+func (d *Dir) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(d.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes an object. Deleting a missing object is not an error.
+func (d *Dir) Delete(key string) error {
+	if err := os.Remove(d.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// S3 stores objects in a bucket of an S3-compatible service. Requests use
+// path-style URLs and are signed with AWS Signature Version 4.
+type S3 struct {
+	Endpoint        string // base URL of the service, such as "https://s3.amazonaws.com"
+	Region          string
+	Bucket          string
+	Prefix          string // prepended to every key
+	AccessKeyID     string
+	SecretAccessKey string
+
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// Put uploads an object of size bytes read from r.
+func (s *S3) Put(key string, r io.Reader, size int64) error {
+	req, err := s.newRequest("PUT", key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// Get downloads an object. Returns ErrNotFound if it doesn't exist.
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	req, err := s.newRequest("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes an object. Deleting a missing object is not an error.
+func (s *S3) Delete(key string) error {
+	req, err := s.newRequest("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// newRequest returns a signed request for the object with key.
+func (s *S3) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the path exactly as it is escaped for signing.
+	path := u.Path + "/" + escapePath(s.Bucket+"/"+s.Prefix+key)
+	req.URL.Opaque = "//" + u.Host + path
+	s.sign(req, path, time.Now().UTC())
+	return req, nil
+}
+
+// do sends a request and returns an error for any unsuccessful response.
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL, resp.Status, strings.TrimSpace(string(b)))
+}
+
+// sign adds AWS Signature Version 4 headers to req for the escaped path. The
+// payload is not hashed so uploads can be streamed.
+func (s *S3) sign(req *http.Request, path string, now time.Time) {
+	date, timestamp := now.Format("20060102"), now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD",
+		"x-amz-date:" + timestamp,
+		"",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + hexSHA256(canonical)
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	for _, v := range []string{s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// escapePath escapes each segment of a slash-separated path as required by
+// request signing. Only unreserved characters are left unescaped.
+func escapePath(path string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			_ = buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	h := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,104 @@
+package objectstore_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/influxdb/influxdb/objectstore"
+)
+
+// Ensure objects can be written, read and deleted in a directory.
+func TestDir(t *testing.T) {
+	path, err := ioutil.TempDir("", "objectstore-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	testStore(t, &objectstore.Dir{Path: path})
+}
+
+// Ensure objects can be written, read and deleted with signed S3 requests.
+func TestS3(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=") {
+			http.Error(w, "bad auth: "+auth, http.StatusForbidden)
+			return
+		} else if !strings.HasPrefix(r.URL.Path, "/bucket0/cold/") {
+			http.Error(w, "bad path: "+r.URL.Path, http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "PUT":
+			b, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = b
+		case "GET":
+			b, ok := objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			w.Write(b)
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	testStore(t, &objectstore.S3{Endpoint: ts.URL, Region: "us-east-1", Bucket: "bucket0", Prefix: "cold/", AccessKeyID: "AKID", SecretAccessKey: "secret"})
+
+	// Errors include the response.
+	s := &objectstore.S3{Endpoint: ts.URL, Region: "us-east-1", Bucket: "bucket0", AccessKeyID: "other", SecretAccessKey: "secret"}
+	if _, err := s.Get("foo"); err == nil || !strings.Contains(err.Error(), "403 Forbidden: bad auth") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// testStore writes, reads and deletes an object in store.
+func testStore(t *testing.T, store interface {
+	Put(key string, r io.Reader, size int64) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}) {
+	if _, err := store.Get("nodes/1/shards/2"); err != objectstore.ErrNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := []byte("shard data")
+	if err := store.Put("nodes/1/shards/2", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.Get("nodes/1/shards/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, data) {
+		t.Fatalf("unexpected data: %q", b)
+	}
+
+	if err := store.Delete("nodes/1/shards/2"); err != nil {
+		t.Fatal(err)
+	} else if _, err := store.Get("nodes/1/shards/2"); err != objectstore.ErrNotFound {
+		t.Fatalf("unexpected error after delete: %v", err)
+	} else if err := store.Delete("nodes/1/shards/2"); err != nil {
+		t.Fatalf("unexpected error deleting missing object: %v", err)
+	}
+}
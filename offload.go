@@ -0,0 +1,346 @@
+package influxdb
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// DefaultOffloadAge is the default time after its shard group ends that
+	// a shard is offloaded.
+	DefaultOffloadAge = 7 * 24 * time.Hour
+
+	// DefaultOffloadCheckInterval is the default time between offload passes.
+	DefaultOffloadCheckInterval = 10 * time.Minute
+
+	// DefaultColdCacheTTL is the default time a fetched shard is kept on
+	// local disk after it was last used.
+	DefaultColdCacheTTL = 1 * time.Hour
+)
+
+// ObjectStore stores the files of shards offloaded from local disk, such as
+// an S3-compatible bucket.
+type ObjectStore interface {
+	// Put writes an object of size bytes read from r.
+	Put(key string, r io.Reader, size int64) error
+
+	// Get opens an object for reading.
+	Get(key string) (io.ReadCloser, error)
+
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(key string) error
+}
+
+// coldShard locates the offloaded copy of a shard's store.
+type coldShard struct {
+	mu     sync.Mutex // held while the store is fetched, offloaded or dropped
+	store  ObjectStore
+	key    string
+	path   string // local path the store is fetched to
+	writeN uint64 // shard write count when the store was last fetched or offloaded
+}
+
+// markerPath returns the path of the file marking the shard as offloaded.
+// It holds the key of the offloaded copy.
+func (c *coldShard) markerPath() string { return c.path + ".cold" }
+
+// upload writes the store file at path to the object store.
+func (c *coldShard) upload(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return c.store.Put(c.key, f, fi.Size())
+}
+
+// lastReadTime returns the time the shard's store was last used.
+func (s *Shard) lastReadTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastRead)).UTC()
+}
+
+// offloaded returns true if the shard's store is kept in an object store and
+// whether a copy is currently on local disk.
+func (s *Shard) offloaded() (cold, cached bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cold != nil, s.cold != nil && s.store != nil
+}
+
+// fetch downloads an offloaded store to its local path and opens it.
+func (s *Shard) fetch(c *coldShard) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The store may have been fetched or dropped while waiting.
+	s.mu.RLock()
+	done := s.store != nil || s.cold != c
+	s.mu.RUnlock()
+	if done {
+		return nil
+	}
+
+	r, err := c.store.Get(c.key)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	// Download alongside the store so a partial download is never opened.
+	tmppath := c.path + ".fetch"
+	f, err := os.OpenFile(tmppath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmppath)
+		return err
+	} else if err := f.Close(); err != nil {
+		_ = os.Remove(tmppath)
+		return err
+	} else if err := os.Rename(tmppath, c.path); err != nil {
+		_ = os.Remove(tmppath)
+		return err
+	}
+
+	store, err := bolt.Open(c.path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cold != c {
+		return store.Close()
+	}
+	s.store, c.writeN = store, atomic.LoadUint64(&s.writeN)
+	return nil
+}
+
+// offload uploads the shard's store to store under key and removes it from
+// local disk. A store fetched back from the object store is only uploaded
+// again if it was written to since. wait is called before each write of n
+// bytes while copying the store and may block to throttle the copy. Returns
+// errShardModified if the shard is written to or closed while uploading.
+func (s *Shard) offload(store ObjectStore, key string, wait func(n int)) error {
+	s.mu.RLock()
+	db, c := s.store, s.cold
+	s.mu.RUnlock()
+	if db == nil {
+		return nil
+	} else if c == nil {
+		c = &coldShard{store: store, key: key, path: db.Path()}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Copy the store unless the object store already holds it.
+	s.mu.RLock()
+	if s.store != db {
+		s.mu.RUnlock()
+		return errShardModified
+	}
+	writeN := atomic.LoadUint64(&s.writeN)
+	upload := s.cold == nil || writeN != c.writeN
+	tmppath := c.path + ".offload"
+	var err error
+	if upload {
+		err = db.View(func(tx *bolt.Tx) error { return copyStore(tx, tmppath, wait) })
+	}
+	s.mu.RUnlock()
+	if upload {
+		if err == nil {
+			err = c.upload(tmppath)
+		}
+		_ = os.Remove(tmppath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Drop the local store unless it was written to while uploading.
+	s.mu.Lock()
+	if s.store != db || atomic.LoadUint64(&s.writeN) != writeN {
+		s.mu.Unlock()
+		return errShardModified
+	}
+	s.store, s.cold, c.writeN = nil, c, writeN
+	s.mu.Unlock()
+
+	// Mark the shard before removing its file so it is fetched after a
+	// restart. The file is kept if the marker can't be written.
+	err = ioutil.WriteFile(c.markerPath(), []byte(c.key), 0600)
+	if e := db.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(c.path)
+}
+
+// dropCold deletes the shard's offloaded copy so it is no longer fetched.
+func (s *Shard) dropCold() error {
+	s.mu.RLock()
+	c := s.cold
+	s.mu.RUnlock()
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.mu.Lock()
+	s.cold = nil
+	s.mu.Unlock()
+
+	if err := os.Remove(c.markerPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.store.Delete(c.key)
+}
+
+// openShard opens the store of a local shard. An offloaded shard is only
+// opened if a copy is on local disk and is otherwise fetched when used.
+func (s *Server) openShard(sh *Shard) error {
+	sh.checksumPolicy = s.ChecksumPolicy
+	path := s.shardPath(sh.ID)
+	key, err := ioutil.ReadFile(path + ".cold")
+	if os.IsNotExist(err) {
+		return sh.open(path)
+	} else if err != nil {
+		return err
+	} else if s.ColdStore == nil {
+		return fmt.Errorf("shard is offloaded but no object store is configured")
+	}
+
+	sh.cold = &coldShard{store: s.ColdStore, key: string(key), path: path}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	return sh.open(path)
+}
+
+// removeShard closes a local shard and deletes its store and any offloaded copy.
+func (s *Server) removeShard(sh *Shard) error {
+	err := sh.dropCold()
+	_ = sh.close()
+	if e := os.Remove(s.shardPath(sh.ID)); e != nil && !os.IsNotExist(e) && err == nil {
+		err = e
+	}
+	return err
+}
+
+// StartOffloading launches background offloading of old shards to ColdStore.
+func (s *Server) StartOffloading(checkInterval time.Duration) error {
+	if checkInterval == 0 {
+		return fmt.Errorf("offload check interval must be non-zero")
+	} else if s.ColdStore == nil {
+		return fmt.Errorf("offloading requires an object store")
+	}
+
+	s.mu.Lock()
+	done := make(chan struct{}, 0)
+	s.offloadDone = done
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(checkInterval):
+				if err := s.OffloadShards(); err != nil {
+					log.Printf("offload: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// OffloadShards moves the local shards of shard groups that ended more than
+// OffloadAge ago to ColdStore and removes fetched copies of offloaded shards
+// that haven't been used for ColdCacheTTL. Shards written to while being
+// offloaded are skipped until the next pass.
+func (s *Server) OffloadShards() error {
+	if s.ColdStore == nil {
+		return nil
+	}
+
+	var err error
+	for _, sh := range s.offloadCandidates() {
+		start := time.Now()
+		if e := sh.offload(s.ColdStore, fmt.Sprintf("nodes/%d/shards/%d", s.ID(), sh.ID), s.IO.waitFunc(IOClassBackup)); e == errShardModified {
+			continue
+		} else if e != nil {
+			if err == nil {
+				err = fmt.Errorf("shard %d: %s", sh.ID, e)
+			}
+			continue
+		}
+		s.Logger.Printf("offloaded shard %d in %s", sh.ID, time.Since(start))
+	}
+	return err
+}
+
+// offloadCandidates returns the local shards due to be offloaded.
+func (s *Server) offloadCandidates() (a []*Shard) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now().UTC()
+	ttl := s.ColdCacheTTL
+	if ttl <= 0 {
+		ttl = DefaultColdCacheTTL
+	}
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				if !g.EndTime.Before(now.Add(-s.OffloadAge)) {
+					continue
+				}
+				for _, sh := range g.Shards {
+					if !sh.HasDataNodeID(s.id) {
+						continue
+					}
+
+					// Fetched copies are kept while they're in use.
+					if cold, cached := sh.offloaded(); cold && (!cached || now.Sub(sh.lastReadTime()) < ttl) {
+						continue
+					}
+					a = append(a, sh)
+				}
+			}
+		}
+	}
+	return
+}
+
+// copyStore writes a consistent copy of tx's store to path. wait is called
+// before each write of n bytes.
+func copyStore(tx *bolt.Tx, path string, wait func(n int)) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.WriteTo(&ioThrottledWriter{w: f, wait: wait}); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
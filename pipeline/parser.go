@@ -0,0 +1,278 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Parse parses a pipeline. Relative times in range stages are relative to now.
+func Parse(s string, now time.Time) (*Pipeline, error) {
+	calls, err := parseCalls(s)
+	if err != nil {
+		return nil, err
+	} else if len(calls) == 0 || calls[0].name != "from" {
+		return nil, fmt.Errorf("pipeline must start with from()")
+	}
+
+	p := &Pipeline{}
+	var window time.Duration
+	for i, c := range calls {
+		switch c.name {
+		case "from":
+			if i > 0 {
+				return nil, fmt.Errorf("from() must be the first stage")
+			} else if err := c.args.check("database", "retentionPolicy", "measurement", "field"); err != nil {
+				return nil, err
+			}
+			p.Database, p.RetentionPolicy = c.args["database"], c.args["retentionPolicy"]
+			p.Measurement, p.Field = c.args["measurement"], c.args["field"]
+			if p.Database == "" || p.Measurement == "" || p.Field == "" {
+				return nil, fmt.Errorf("from() requires database, measurement and field")
+			} else if !isIdent(p.Field) {
+				return nil, fmt.Errorf("from() field: invalid field name: %q", p.Field)
+			}
+
+		case "range":
+			if err := c.args.check("start", "stop"); err != nil {
+				return nil, err
+			} else if len(p.Stages) > 0 {
+				return nil, fmt.Errorf("range() must come before other stages")
+			}
+			if p.Start, err = parseTime(c.args["start"], now); err != nil {
+				return nil, fmt.Errorf("range() start: %s", err)
+			}
+			if p.Stop, err = parseTime(c.args["stop"], now); err != nil {
+				return nil, fmt.Errorf("range() stop: %s", err)
+			}
+
+		case "filter", "map":
+			if err := c.args.check("expr"); err != nil {
+				return nil, err
+			}
+			expr, err := influxql.ParseExpr(c.args["expr"])
+			if err != nil {
+				return nil, fmt.Errorf("%s() expr: %s", c.name, err)
+			}
+			if c.name == "filter" {
+				p.Stages = append(p.Stages, &FilterStage{Expr: expr})
+			} else {
+				p.Stages = append(p.Stages, &MapStage{Expr: expr})
+			}
+
+		case "window":
+			if err := c.args.check("every"); err != nil {
+				return nil, err
+			}
+			if window, err = influxql.ParseDuration(c.args["every"]); err != nil || window <= 0 {
+				return nil, fmt.Errorf("window() every: invalid duration: %q", c.args["every"])
+			}
+
+		case "aggregate":
+			if err := c.args.check("fn"); err != nil {
+				return nil, err
+			} else if aggregates[c.args["fn"]] == nil {
+				return nil, fmt.Errorf("aggregate() fn: unknown aggregate: %q", c.args["fn"])
+			}
+			p.Stages = append(p.Stages, &AggregateStage{Func: c.args["fn"], Every: window})
+			window = 0
+
+		case "udf":
+			if err := c.args.check("name"); err != nil {
+				return nil, err
+			} else if c.args["name"] == "" {
+				return nil, fmt.Errorf("udf() requires name")
+			}
+			p.Stages = append(p.Stages, &UDFStage{Name: c.args["name"]})
+
+		default:
+			return nil, fmt.Errorf("unknown stage: %s()", c.name)
+		}
+
+		// A window applies to the aggregate that follows it.
+		if window > 0 && c.name != "window" {
+			return nil, fmt.Errorf("window() must be followed by aggregate()")
+		}
+	}
+	if window > 0 {
+		return nil, fmt.Errorf("window() must be followed by aggregate()")
+	}
+	return p, nil
+}
+
+// parseTime parses an RFC3339 time or a duration relative to now. Returns
+// the zero time if s is blank.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	} else if s == "now" {
+		return now, nil
+	} else if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+
+	neg := strings.HasPrefix(s, "-")
+	d, err := influxql.ParseDuration(strings.TrimPrefix(s, "-"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %q", s)
+	} else if neg {
+		d = -d
+	}
+	return now.Add(d), nil
+}
+
+// isIdent returns true if s is a bare identifier.
+func isIdent(s string) bool {
+	for i, ch := range s {
+		if !unicode.IsLetter(ch) && ch != '_' && (i == 0 || !unicode.IsDigit(ch)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// call represents a stage as written: a name and its arguments.
+type call struct {
+	name string
+	args args
+}
+
+// args holds the arguments of a call by name.
+type args map[string]string
+
+// check returns an error if there are any arguments not in names.
+func (a args) check(names ...string) error {
+	for k := range a {
+		var ok bool
+		for _, name := range names {
+			ok = ok || k == name
+		}
+		if !ok {
+			return fmt.Errorf("unknown argument: %s", k)
+		}
+	}
+	return nil
+}
+
+// parseCalls splits a pipeline into its calls. Calls are separated by "|>"
+// and have the form name(key: value, ...) where values are quoted strings
+// or bare words such as numbers and durations.
+func parseCalls(s string) ([]*call, error) {
+	sc := &scanner{s: s}
+	var calls []*call
+	for {
+		name := sc.word()
+		if name == "" {
+			return nil, sc.errorf("expected stage name")
+		}
+		c := &call{name: name, args: make(args)}
+		if !sc.consume("(") {
+			return nil, sc.errorf("expected (")
+		}
+		for !sc.consume(")") {
+			if len(c.args) > 0 && !sc.consume(",") {
+				return nil, sc.errorf("expected , or )")
+			}
+			key := sc.word()
+			if key == "" {
+				return nil, sc.errorf("expected argument name")
+			} else if !sc.consume(":") {
+				return nil, sc.errorf("expected :")
+			}
+			value, err := sc.value()
+			if err != nil {
+				return nil, err
+			} else if _, ok := c.args[key]; ok {
+				return nil, sc.errorf("duplicate argument: %s", key)
+			}
+			c.args[key] = value
+		}
+		calls = append(calls, c)
+
+		if sc.eof() {
+			return calls, nil
+		} else if !sc.consume("|>") {
+			return nil, sc.errorf("expected |>")
+		}
+	}
+}
+
+// scanner reads the tokens of a pipeline.
+type scanner struct {
+	s   string
+	pos int
+}
+
+// skip moves past any whitespace.
+func (sc *scanner) skip() {
+	for sc.pos < len(sc.s) && unicode.IsSpace(rune(sc.s[sc.pos])) {
+		sc.pos++
+	}
+}
+
+// eof returns true if only whitespace is left.
+func (sc *scanner) eof() bool {
+	sc.skip()
+	return sc.pos == len(sc.s)
+}
+
+// consume moves past tok if it is next and returns true if it was.
+func (sc *scanner) consume(tok string) bool {
+	sc.skip()
+	if strings.HasPrefix(sc.s[sc.pos:], tok) {
+		sc.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+// word reads a bare word made of letters, digits and the characters used in
+// numbers and durations. Returns a blank string if there isn't one.
+func (sc *scanner) word() string {
+	sc.skip()
+	start := sc.pos
+	for sc.pos < len(sc.s) {
+		ch := rune(sc.s[sc.pos])
+		if !unicode.IsLetter(ch) && !unicode.IsDigit(ch) && ch != '_' && ch != '.' && ch != '-' {
+			break
+		}
+		sc.pos++
+	}
+	return sc.s[start:sc.pos]
+}
+
+// value reads a quoted string or a bare word.
+func (sc *scanner) value() (string, error) {
+	sc.skip()
+	if sc.pos == len(sc.s) || sc.s[sc.pos] != '"' {
+		if w := sc.word(); w != "" {
+			return w, nil
+		}
+		return "", sc.errorf("expected value")
+	}
+
+	// Find the closing quote, skipping escaped characters.
+	for i := sc.pos + 1; i < len(sc.s); i++ {
+		switch sc.s[i] {
+		case '\\':
+			i++
+		case '"':
+			v, err := strconv.Unquote(sc.s[sc.pos : i+1])
+			if err != nil {
+				return "", sc.errorf("invalid string: %s", err)
+			}
+			sc.pos = i + 1
+			return v, nil
+		}
+	}
+	return "", sc.errorf("unterminated string")
+}
+
+// errorf returns an error at the scanner's position.
+func (sc *scanner) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s at char %d", fmt.Sprintf(format, a...), sc.pos+1)
+}
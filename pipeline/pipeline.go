@@ -0,0 +1,280 @@
+// Package pipeline implements pipeline queries.
+//
+// A pipeline reads the points of one field of a measurement and passes them
+// through a series of stages, each of which transforms the series output by
+// the stage before it. Stages are separated by "|>":
+//
+//	from(database: "mydb", measurement: "cpu", field: "value")
+//	    |> range(start: -1h)
+//	    |> filter(expr: "host = 'serverA'")
+//	    |> window(every: 10m)
+//	    |> aggregate(fn: "max")
+//	    |> map(expr: "value / 100")
+//	    |> udf(name: "anomaly")
+//
+// Stages can be repeated and applied in any order so pipelines can express
+// transformations InfluxQL can't, such as filtering or aggregating the
+// results of an aggregate. The udf stage passes the points to a registered
+// external process, see UDF.
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Pipeline represents a parsed pipeline query.
+type Pipeline struct {
+	Database        string
+	RetentionPolicy string // database's default if blank
+	Measurement     string
+	Field           string
+
+	// Time range of the points read. The start is unbounded if zero.
+	Start time.Time
+	Stop  time.Time
+
+	Stages []Stage
+}
+
+// Query returns the InfluxQL query that reads the points the pipeline's
+// stages are applied to. Each series is returned in its own row.
+func (p *Pipeline) Query() (*influxql.Query, error) {
+	source := []string{p.Measurement}
+	if p.RetentionPolicy != "" {
+		source = []string{p.Database, p.RetentionPolicy, p.Measurement}
+	}
+
+	var cond []string
+	if !p.Start.IsZero() {
+		cond = append(cond, "time >= "+influxql.QuoteString(p.Start.UTC().Format(time.RFC3339Nano)))
+	}
+	if !p.Stop.IsZero() {
+		cond = append(cond, "time < "+influxql.QuoteString(p.Stop.UTC().Format(time.RFC3339Nano)))
+	}
+
+	// The field is a bare identifier; quoted field names keep their quotes.
+	q := fmt.Sprintf("SELECT %s FROM %s", p.Field, influxql.QuoteIdent(source))
+	if len(cond) > 0 {
+		q += " WHERE " + strings.Join(cond, " AND ")
+	}
+	q += " GROUP BY *"
+	return influxql.ParseQuery(q)
+}
+
+// Apply passes the rows read by the pipeline's query through each stage.
+func (p *Pipeline) Apply(rows []*influxql.Row) ([]*influxql.Row, error) {
+	var err error
+	for _, s := range p.Stages {
+		if rows, err = s.Apply(p.Field, rows); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// Stage represents a step of a pipeline. Rows are passed with a time column
+// followed by a column for the field.
+type Stage interface {
+	Apply(field string, rows []*influxql.Row) ([]*influxql.Row, error)
+}
+
+// FilterStage keeps the points for which an expression is true. The
+// expression can refer to the field and to the series' tags.
+type FilterStage struct {
+	Expr influxql.Expr
+}
+
+// Apply removes the points that don't match the filter and any rows left
+// without points.
+func (s *FilterStage) Apply(field string, rows []*influxql.Row) ([]*influxql.Row, error) {
+	var a []*influxql.Row
+	for _, row := range rows {
+		m := valuesOf(row)
+		var values [][]interface{}
+		for _, v := range row.Values {
+			m[field] = v[1]
+			if ok, _ := influxql.Eval(s.Expr, m).(bool); ok {
+				values = append(values, v)
+			}
+		}
+		if len(values) > 0 {
+			a = append(a, &influxql.Row{Name: row.Name, Tags: row.Tags, Columns: row.Columns, Values: values})
+		}
+	}
+	return a, nil
+}
+
+// MapStage replaces the value of each point with the result of an
+// expression. The expression can refer to the field and to the series' tags.
+type MapStage struct {
+	Expr influxql.Expr
+}
+
+// Apply evaluates the expression for each point. Points the expression
+// can't be evaluated for are given a nil value.
+func (s *MapStage) Apply(field string, rows []*influxql.Row) ([]*influxql.Row, error) {
+	a := make([]*influxql.Row, len(rows))
+	for i, row := range rows {
+		m := valuesOf(row)
+		values := make([][]interface{}, len(row.Values))
+		for j, v := range row.Values {
+			m[field] = v[1]
+			values[j] = []interface{}{v[0], influxql.Eval(s.Expr, m)}
+		}
+		a[i] = &influxql.Row{Name: row.Name, Tags: row.Tags, Columns: row.Columns, Values: values}
+	}
+	return a, nil
+}
+
+// AggregateStage reduces the points of each series to one point per window
+// using an aggregate function. All points of a series are in a single window
+// if Every is zero. Each point is given the start time of its window.
+type AggregateStage struct {
+	Func  string
+	Every time.Duration
+}
+
+// aggregates holds the functions supported by aggregate stages.
+var aggregates = map[string]func(values []interface{}) (interface{}, error){
+	"count":  aggregateCount,
+	"sum":    aggregateSum,
+	"mean":   aggregateMean,
+	"min":    aggregateMin,
+	"max":    aggregateMax,
+	"spread": aggregateSpread,
+	"first":  func(values []interface{}) (interface{}, error) { return values[0], nil },
+	"last":   func(values []interface{}) (interface{}, error) { return values[len(values)-1], nil },
+}
+
+// Apply aggregates the points of each row by window.
+func (s *AggregateStage) Apply(field string, rows []*influxql.Row) ([]*influxql.Row, error) {
+	fn := aggregates[s.Func]
+	if fn == nil {
+		return nil, fmt.Errorf("unknown aggregate: %s", s.Func)
+	}
+
+	a := make([]*influxql.Row, len(rows))
+	for i, row := range rows {
+		// Group the values of the row by window. Rows are sorted by time so
+		// the windows are found in order.
+		var windows []time.Time
+		groups := make(map[time.Time][]interface{})
+		for _, v := range row.Values {
+			t, ok := v[0].(time.Time)
+			if !ok {
+				return nil, fmt.Errorf("invalid time: %v", v[0])
+			}
+			if s.Every > 0 {
+				t = t.Truncate(s.Every)
+			} else if len(windows) > 0 {
+				t = windows[0]
+			}
+			if _, ok := groups[t]; !ok {
+				windows = append(windows, t)
+				groups[t] = nil
+			}
+			if v[1] != nil {
+				groups[t] = append(groups[t], v[1])
+			}
+		}
+
+		values := make([][]interface{}, 0, len(windows))
+		for _, t := range windows {
+			var v interface{}
+			if len(groups[t]) > 0 {
+				var err error
+				if v, err = fn(groups[t]); err != nil {
+					return nil, err
+				}
+			}
+			values = append(values, []interface{}{t, v})
+		}
+		a[i] = &influxql.Row{Name: row.Name, Tags: row.Tags, Columns: row.Columns, Values: values}
+	}
+	return a, nil
+}
+
+func aggregateCount(values []interface{}) (interface{}, error) {
+	return float64(len(values)), nil
+}
+
+func aggregateSum(values []interface{}) (interface{}, error) {
+	var sum float64
+	for _, v := range values {
+		f, err := float(v)
+		if err != nil {
+			return nil, err
+		}
+		sum += f
+	}
+	return sum, nil
+}
+
+func aggregateMean(values []interface{}) (interface{}, error) {
+	sum, err := aggregateSum(values)
+	if err != nil {
+		return nil, err
+	}
+	return sum.(float64) / float64(len(values)), nil
+}
+
+func aggregateMin(values []interface{}) (interface{}, error) {
+	min := math.Inf(1)
+	for _, v := range values {
+		f, err := float(v)
+		if err != nil {
+			return nil, err
+		}
+		min = math.Min(min, f)
+	}
+	return min, nil
+}
+
+func aggregateMax(values []interface{}) (interface{}, error) {
+	max := math.Inf(-1)
+	for _, v := range values {
+		f, err := float(v)
+		if err != nil {
+			return nil, err
+		}
+		max = math.Max(max, f)
+	}
+	return max, nil
+}
+
+func aggregateSpread(values []interface{}) (interface{}, error) {
+	min, err := aggregateMin(values)
+	if err != nil {
+		return nil, err
+	}
+	max, _ := aggregateMax(values)
+	return max.(float64) - min.(float64), nil
+}
+
+// float returns a numeric value as a float64.
+func float(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	default:
+		return 0, fmt.Errorf("cannot aggregate non-numeric value: %v", v)
+	}
+}
+
+// valuesOf returns a map of a row's tags for evaluating expressions.
+func valuesOf(row *influxql.Row) map[string]interface{} {
+	m := make(map[string]interface{}, len(row.Tags)+1)
+	for k, v := range row.Tags {
+		m[k] = v
+	}
+	return m
+}
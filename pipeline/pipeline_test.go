@@ -0,0 +1,180 @@
+package pipeline_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/pipeline"
+)
+
+// Ensure a pipeline can be parsed and converted to the query reading its points.
+func TestParse(t *testing.T) {
+	now := mustParseTime("2000-01-01T01:00:00Z")
+	p, err := pipeline.Parse(`from(database: "db0", retentionPolicy: "raw", measurement: "cpu", field: "value")
+		|> range(start: -1h, stop: now)
+		|> filter(expr: "host = 'serverA'")
+		|> window(every: 10m)
+		|> aggregate(fn: "max")
+		|> map(expr: "value / 100")`, now)
+	if err != nil {
+		t.Fatal(err)
+	} else if p.Database != "db0" || p.RetentionPolicy != "raw" || p.Measurement != "cpu" || p.Field != "value" {
+		t.Fatalf("unexpected source: %#v", p)
+	} else if !p.Start.Equal(mustParseTime("2000-01-01T00:00:00Z")) || !p.Stop.Equal(now) {
+		t.Fatalf("unexpected range: %s - %s", p.Start, p.Stop)
+	} else if len(p.Stages) != 3 {
+		t.Fatalf("unexpected stages: %#v", p.Stages)
+	} else if s, ok := p.Stages[1].(*pipeline.AggregateStage); !ok || s.Func != "max" || s.Every != 10*time.Minute {
+		t.Fatalf("unexpected aggregate stage: %#v", p.Stages[1])
+	}
+
+	q, err := p.Query()
+	if err != nil {
+		t.Fatal(err)
+	} else if s := q.String(); s != `SELECT value FROM "db0"."raw"."cpu" WHERE time >= "2000-01-01 00:00:00" AND time < "2000-01-01 01:00:00" GROUP BY *` {
+		t.Fatalf("unexpected query: %s", s)
+	}
+}
+
+// Ensure invalid pipelines return an error.
+func TestParse_Err(t *testing.T) {
+	for i, tt := range []struct {
+		s   string
+		err string
+	}{
+		{s: ``, err: `expected stage name at char 1`},
+		{s: `filter(expr: "value > 1")`, err: `pipeline must start with from()`},
+		{s: `from(database: "db0", measurement: "cpu")`, err: `from() requires database, measurement and field`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value FROM cpu; DROP DATABASE db0")`, err: `from() field: invalid field name: "value FROM cpu; DROP DATABASE db0"`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value", foo: 1)`, err: `unknown argument: foo`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value" |> count()`, err: `expected , or ) at char 58`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value") count()`, err: `expected |> at char 59`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value") |> foo()`, err: `unknown stage: foo()`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value") |> aggregate(fn: "median")`, err: `aggregate() fn: unknown aggregate: "median"`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value") |> window(every: 1m)`, err: `window() must be followed by aggregate()`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value") |> window(every: 1m) |> map(expr: "value")`, err: `window() must be followed by aggregate()`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value") |> map(expr: "value") |> range(start: -1h)`, err: `range() must come before other stages`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value") |> range(start: yesterday)`, err: `range() start: invalid time: "yesterday"`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value") |> filter(expr: "value >")`, err: `filter() expr: found EOF, expected identifier, string, number, bool at line 1, char 8`},
+		{s: `from(database: "db0", measurement: "cpu", field: "value) |> count()`, err: `unterminated string at char 50`},
+	} {
+		if _, err := pipeline.Parse(tt.s, time.Now()); err == nil || err.Error() != tt.err {
+			t.Errorf("%d. %s: error mismatch:\n  exp=%s\n  got=%v", i, tt.s, tt.err, err)
+		}
+	}
+}
+
+// Ensure stages are applied to rows in order.
+func TestPipeline_Apply(t *testing.T) {
+	p, err := pipeline.Parse(`from(database: "db0", measurement: "cpu", field: "value")
+		|> filter(expr: "value > 1")
+		|> window(every: 10s)
+		|> aggregate(fn: "mean")
+		|> map(expr: "value * 2")
+		|> aggregate(fn: "max")
+		|> filter(expr: "host = 'serverA'")`, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := p.Apply([]*influxql.Row{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Columns: []string{"time", "value"}, Values: [][]interface{}{
+			{mustParseTime("2000-01-01T00:00:00Z"), float64(1)},
+			{mustParseTime("2000-01-01T00:00:05Z"), float64(3)},
+			{mustParseTime("2000-01-01T00:00:10Z"), float64(10)},
+			{mustParseTime("2000-01-01T00:00:15Z"), float64(20)},
+		}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Columns: []string{"time", "value"}, Values: [][]interface{}{
+			{mustParseTime("2000-01-01T00:00:00Z"), float64(100)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rows) != 1 {
+		t.Fatalf("unexpected rows: %#v", rows)
+	} else if exp := [][]interface{}{{mustParseTime("2000-01-01T00:00:00Z"), float64(30)}}; !reflect.DeepEqual(rows[0].Values, exp) {
+		t.Fatalf("unexpected values: %#v", rows[0].Values)
+	}
+}
+
+// Ensure aggregating non-numeric values returns an error.
+func TestPipeline_Apply_ErrNonNumeric(t *testing.T) {
+	p, err := pipeline.Parse(`from(database: "db0", measurement: "cpu", field: "value") |> aggregate(fn: "sum")`, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Apply([]*influxql.Row{
+		{Name: "cpu", Columns: []string{"time", "value"}, Values: [][]interface{}{{mustParseTime("2000-01-01T00:00:00Z"), "foo"}}},
+	}); err == nil || err.Error() != `cannot aggregate non-numeric value: foo` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a udf stage passes points through an external process.
+func TestPipeline_Apply_UDF(t *testing.T) {
+	p, err := pipeline.Parse(`from(database: "db0", measurement: "cpu", field: "value") |> udf(name: "double")`, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	} else if err := p.Bind(map[string]*pipeline.UDF{}); err == nil || err.Error() != `unknown udf: double` {
+		t.Fatalf("unexpected bind error: %v", err)
+	}
+
+	// Double each value by rewriting the JSON lines with sed.
+	udf := &pipeline.UDF{Name: "double", Command: "sed", Args: []string{"-E", `s/"value":([0-9]+)/"value":\1\1/`}}
+	if err := p.Bind(map[string]*pipeline.UDF{"double": udf}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := p.Apply([]*influxql.Row{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Columns: []string{"time", "value"}, Values: [][]interface{}{
+			{mustParseTime("2000-01-01T00:00:00Z"), float64(1)},
+			{mustParseTime("2000-01-01T00:00:10Z"), float64(2)},
+		}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Columns: []string{"time", "value"}, Values: [][]interface{}{
+			{mustParseTime("2000-01-01T00:00:00Z"), float64(3)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := []*influxql.Row{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Columns: []string{"time", "value"}, Values: [][]interface{}{
+			{mustParseTime("2000-01-01T00:00:00Z"), float64(11)},
+			{mustParseTime("2000-01-01T00:00:10Z"), float64(22)},
+		}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Columns: []string{"time", "value"}, Values: [][]interface{}{
+			{mustParseTime("2000-01-01T00:00:00Z"), float64(33)},
+		}},
+	}; !reflect.DeepEqual(rows, exp) {
+		t.Fatalf("unexpected rows: %#v", rows)
+	}
+}
+
+// Ensure a udf stage returns an error if the process fails or times out.
+func TestPipeline_Apply_UDFErr(t *testing.T) {
+	rows := []*influxql.Row{{Name: "cpu", Columns: []string{"time", "value"}, Values: [][]interface{}{{mustParseTime("2000-01-01T00:00:00Z"), float64(1)}}}}
+
+	udf := &pipeline.UDF{Name: "fail", Command: "sh", Args: []string{"-c", "echo bad input >&2; exit 3"}}
+	if _, err := udf.Run("value", rows); err == nil || err.Error() != `udf fail: exit status 3: bad input` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	udf = &pipeline.UDF{Name: "garbage", Command: "echo", Args: []string{"not json"}}
+	if _, err := udf.Run("value", rows); err == nil || err.Error() != `udf garbage: invalid output: invalid character 'o' in literal null (expecting 'u')` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	udf = &pipeline.UDF{Name: "slow", Command: "sleep", Args: []string{"5"}, Timeout: 10 * time.Millisecond}
+	if _, err := udf.Run("value", rows); err == nil || err.Error() != `udf slow: timed out after 10ms` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// mustParseTime parses an RFC3339 time. Panic on error.
+func mustParseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
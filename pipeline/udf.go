@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DefaultUDFTimeout is the default time a UDF process is allowed to run.
+const DefaultUDFTimeout = 30 * time.Second
+
+// UDF represents a user-defined function run as an external process.
+//
+// The process is started each time a pipeline passes points to it. Points are
+// written to its stdin as JSON objects, one per line, and stdin is closed.
+// The points it writes to stdout in the same format replace the points passed
+// in. Each object has a "name", "tags", "time" and "value":
+//
+//	{"name":"cpu","tags":{"host":"serverA"},"time":"2000-01-01T00:00:00Z","value":100}
+type UDF struct {
+	Name    string
+	Command string
+	Args    []string
+	Timeout time.Duration // DefaultUDFTimeout if zero
+}
+
+// udfPoint is the encoding of a point passed to and from a UDF process.
+type udfPoint struct {
+	Name  string            `json:"name"`
+	Tags  map[string]string `json:"tags,omitempty"`
+	Time  time.Time         `json:"time"`
+	Value interface{}       `json:"value"`
+}
+
+// Run passes the points of rows through the UDF process. The points output
+// are grouped into rows by measurement and tags in the order first seen.
+func (u *UDF) Run(field string, rows []*influxql.Row) ([]*influxql.Row, error) {
+	var stdin bytes.Buffer
+	enc := json.NewEncoder(&stdin)
+	for _, row := range rows {
+		for _, v := range row.Values {
+			t, ok := v[0].(time.Time)
+			if !ok {
+				return nil, fmt.Errorf("invalid time: %v", v[0])
+			}
+			if err := enc.Encode(&udfPoint{Name: row.Name, Tags: row.Tags, Time: t, Value: v[1]}); err != nil {
+				return nil, fmt.Errorf("udf %s: %s", u.Name, err)
+			}
+		}
+	}
+
+	timeout := u.Timeout
+	if timeout <= 0 {
+		timeout = DefaultUDFTimeout
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(u.Command, u.Args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &stdin, &stdout, &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("udf %s: %s", u.Name, err)
+	}
+
+	// Kill the process if it runs past the timeout.
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-t.C:
+		_ = cmd.Process.Kill()
+		<-done
+		return nil, fmt.Errorf("udf %s: timed out after %s", u.Name, timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("udf %s: %s: %s", u.Name, err, msg)
+		}
+		return nil, fmt.Errorf("udf %s: %s", u.Name, err)
+	}
+
+	// Group the output points into rows.
+	var a []*influxql.Row
+	index := make(map[string]*influxql.Row)
+	dec := json.NewDecoder(&stdout)
+	for {
+		var p udfPoint
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("udf %s: invalid output: %s", u.Name, err)
+		}
+
+		key := seriesKey(p.Name, p.Tags)
+		row := index[key]
+		if row == nil {
+			row = &influxql.Row{Name: p.Name, Tags: p.Tags, Columns: []string{"time", field}}
+			index[key] = row
+			a = append(a, row)
+		}
+		row.Values = append(row.Values, []interface{}{p.Time.UTC(), p.Value})
+	}
+	return a, nil
+}
+
+// seriesKey returns a key identifying a measurement and tag set.
+func seriesKey(name string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	_, _ = buf.WriteString(name)
+	for _, k := range keys {
+		_ = buf.WriteByte(0)
+		_, _ = buf.WriteString(k)
+		_ = buf.WriteByte(0)
+		_, _ = buf.WriteString(tags[k])
+	}
+	return buf.String()
+}
+
+// UDFStage passes points through a UDF process. The UDF is looked up by
+// name when the pipeline is bound.
+type UDFStage struct {
+	Name string
+	UDF  *UDF
+}
+
+// Apply runs the UDF with the points of rows.
+func (s *UDFStage) Apply(field string, rows []*influxql.Row) ([]*influxql.Row, error) {
+	if s.UDF == nil {
+		return nil, fmt.Errorf("udf not bound: %s", s.Name)
+	}
+	return s.UDF.Run(field, rows)
+}
+
+// Bind sets the UDFs run by the pipeline's udf stages from the registered
+// UDFs. Returns an error if a stage names an unregistered UDF.
+func (p *Pipeline) Bind(udfs map[string]*UDF) error {
+	for _, s := range p.Stages {
+		if s, ok := s.(*UDFStage); ok {
+			if s.UDF = udfs[s.Name]; s.UDF == nil {
+				return fmt.Errorf("unknown udf: %s", s.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package influxdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Quota limits the resources a single database may consume on a data node.
+// A limit of zero means unlimited.
+type Quota struct {
+	Database             string
+	MaxSeries            int   // series in the database
+	MaxWriteRate         int   // points written per second
+	MaxConcurrentQueries int   // queries executing at the same time
+	MaxDiskBytes         int64 // size of the database's shards on this node
+}
+
+// QuotaManager enforces per-database quotas in the write and query paths.
+type QuotaManager struct {
+	mu     sync.Mutex
+	quotas map[string]*Quota
+	usage  map[string]*quotaUsage
+
+	// Returns the current time. Defaults to time.Now().
+	Now func() time.Time
+}
+
+// quotaUsage tracks the rate-based and concurrent usage of a database.
+type quotaUsage struct {
+	window  time.Time // start of the current one-second write window
+	points  int       // points written in the current window
+	queries int       // queries currently executing
+}
+
+// NewQuotaManager returns a new instance of QuotaManager.
+func NewQuotaManager() *QuotaManager {
+	return &QuotaManager{
+		quotas: make(map[string]*Quota),
+		usage:  make(map[string]*quotaUsage),
+		Now:    time.Now,
+	}
+}
+
+// SetQuota sets the quota for a database, replacing any existing quota.
+func (m *QuotaManager) SetQuota(q Quota) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotas[q.Database] = &q
+}
+
+// Quota returns the quota for a database. Returns nil if the database has no quota.
+func (m *QuotaManager) Quota(database string) *Quota {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if q := m.quotas[database]; q != nil {
+		other := *q
+		return &other
+	}
+	return nil
+}
+
+// Quotas returns all quotas sorted by database name.
+func (m *QuotaManager) Quotas() []Quota {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := make([]Quota, 0, len(m.quotas))
+	for _, q := range m.quotas {
+		a = append(a, *q)
+	}
+	sort.Sort(quotas(a))
+	return a
+}
+
+// usageOf returns the usage for a database, creating it if necessary.
+// The caller must hold the lock.
+func (m *QuotaManager) usageOf(database string) *quotaUsage {
+	u := m.usage[database]
+	if u == nil {
+		u = &quotaUsage{}
+		m.usage[database] = u
+	}
+	return u
+}
+
+// reserveWrite counts n points against the database's write rate.
+// Returns ErrWriteRateQuotaExceeded if the rate would be exceeded.
+func (m *QuotaManager) reserveWrite(database string, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.quotas[database]
+	if q == nil || q.MaxWriteRate <= 0 {
+		return nil
+	}
+
+	u := m.usageOf(database)
+	if now := m.Now().Truncate(time.Second); !now.Equal(u.window) {
+		u.window, u.points = now, 0
+	}
+	if u.points+n > q.MaxWriteRate {
+		return ErrWriteRateQuotaExceeded
+	}
+	u.points += n
+	return nil
+}
+
+// writeRate returns the number of points written in the current second.
+func (m *QuotaManager) writeRate(database string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.usage[database]
+	if u == nil || !m.Now().Truncate(time.Second).Equal(u.window) {
+		return 0
+	}
+	return u.points
+}
+
+// acquireQuery counts a query as running against the database.
+// Returns ErrQueryQuotaExceeded if too many queries are already running.
+func (m *QuotaManager) acquireQuery(database string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.usageOf(database)
+	if q := m.quotas[database]; q != nil && q.MaxConcurrentQueries > 0 && u.queries >= q.MaxConcurrentQueries {
+		return ErrQueryQuotaExceeded
+	}
+	u.queries++
+	return nil
+}
+
+// releaseQuery marks a query against the database as finished.
+func (m *QuotaManager) releaseQuery(database string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usageOf(database).queries--
+}
+
+// runningQueries returns the number of queries running against the database.
+func (m *QuotaManager) runningQueries(database string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u := m.usage[database]; u != nil {
+		return u.queries
+	}
+	return 0
+}
+
+type quotas []Quota
+
+func (a quotas) Len() int           { return len(a) }
+func (a quotas) Less(i, j int) bool { return a[i].Database < a[j].Database }
+func (a quotas) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -1013,6 +1013,40 @@ func (l *Log) Apply(command []byte) (uint64, error) {
 	return l.internalApply(LogEntryCommand, command)
 }
 
+// ApplyBatch executes a list of commands against the log. The commands are
+// appended together so they are assigned consecutive indexes in order.
+// Returns the index of the first command.
+func (l *Log) ApplyBatch(commands [][]byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Do not apply if this node is closed.
+	// Do not apply if this node is not the leader.
+	if l.state == Stopped {
+		return 0, ErrClosed
+	} else if l.state != Leader {
+		return 0, ErrNotLeader
+	}
+
+	// Append each command to the log.
+	index := l.lastLogIndex + 1
+	for _, command := range commands {
+		l.append(&LogEntry{
+			Type:  LogEntryCommand,
+			Index: l.lastLogIndex + 1,
+			Term:  l.term,
+			Data:  command,
+		})
+	}
+
+	// If there is no config or only one node then move commit index forward.
+	if l.config == nil || len(l.config.Nodes) <= 1 {
+		l.commitIndex = l.lastLogIndex
+	}
+
+	return index, nil
+}
+
 func (l *Log) internalApply(typ LogEntryType, command []byte) (index uint64, err error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
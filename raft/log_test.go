@@ -85,6 +85,31 @@ func TestLog_Apply(t *testing.T) {
 	}
 }
 
+// Ensure that a log can apply a batch of commands with consecutive indexes.
+func TestLog_ApplyBatch(t *testing.T) {
+	l := NewInitializedLog(&url.URL{Host: "log0"})
+	defer l.Close()
+
+	// Apply a batch of commands.
+	index, err := l.ApplyBatch([][]byte{[]byte("foo"), []byte("bar"), []byte("baz")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if index != 2 {
+		t.Fatalf("unexpected index: %d", index)
+	}
+
+	// Force apply cycle and then signal wait.
+	go func() { l.Clock.apply() }()
+
+	// Commands should be applied in order.
+	l.Wait(index + 2)
+	if a := l.FSM.(*FSM).Commands; len(a) != 3 {
+		t.Fatalf("unexpected command count: %d", len(a))
+	} else if string(a[0]) != "foo" || string(a[1]) != "bar" || string(a[2]) != "baz" {
+		t.Fatalf("unexpected commands: %q", a)
+	}
+}
+
 // Ensure that a node has no configuration after it's closed.
 func TestLog_Config_Closed(t *testing.T) {
 	l := NewInitializedLog(&url.URL{Host: "log0"})
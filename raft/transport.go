@@ -9,10 +9,24 @@ import (
 	"net/url"
 	"path"
 	"strconv"
+
+	"github.com/influxdb/influxdb/transport"
 )
 
 // HTTPTransport represents a transport for sending RPCs over the HTTP protocol.
-type HTTPTransport struct{}
+type HTTPTransport struct {
+	// Client used to send requests. Uses pooled connections from the
+	// default transport manager if nil.
+	Client *http.Client
+}
+
+// client returns the HTTP client used to send requests.
+func (t *HTTPTransport) client() *http.Client {
+	if t.Client != nil {
+		return t.Client
+	}
+	return transport.Default.Client(0)
+}
 
 // Join requests membership into a node's cluster.
 func (t *HTTPTransport) Join(uri *url.URL, nodeURL *url.URL) (uint64, uint64, *Config, error) {
@@ -22,11 +36,11 @@ func (t *HTTPTransport) Join(uri *url.URL, nodeURL *url.URL) (uint64, uint64, *C
 	u.RawQuery = (&url.Values{"url": {nodeURL.String()}}).Encode()
 
 	// Send HTTP request.
-	resp, err := http.Get(u.String())
+	resp, err := t.client().Get(u.String())
 	if err != nil {
 		return 0, 0, nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
+	defer func() { _ = transport.CloseBody(resp) }()
 
 	// Parse returned error.
 	if s := resp.Header.Get("X-Raft-Error"); s != "" {
@@ -62,11 +76,11 @@ func (t *HTTPTransport) Leave(uri *url.URL, id uint64) error {
 	u.RawQuery = (&url.Values{"id": {strconv.FormatUint(id, 10)}}).Encode()
 
 	// Send HTTP request.
-	resp, err := http.Get(u.String())
+	resp, err := t.client().Get(u.String())
 	if err != nil {
 		return err
 	}
-	defer func() { _ = resp.Body.Close() }()
+	defer func() { _ = transport.CloseBody(resp) }()
 
 	// Parse returned error.
 	if s := resp.Header.Get("X-Raft-Error"); s != "" {
@@ -90,11 +104,11 @@ func (t *HTTPTransport) Heartbeat(uri *url.URL, term, commitIndex, leaderID uint
 	u.RawQuery = v.Encode()
 
 	// Send HTTP request.
-	resp, err := http.Get(u.String())
+	resp, err := t.client().Get(u.String())
 	if err != nil {
 		return 0, err
 	}
-	_ = resp.Body.Close()
+	_ = transport.CloseBody(resp)
 
 	// Parse returned index.
 	newIndexString := resp.Header.Get("X-Raft-Index")
@@ -125,14 +139,14 @@ func (t *HTTPTransport) ReadFrom(uri *url.URL, id, term, index uint64) (io.ReadC
 	u.RawQuery = v.Encode()
 
 	// Send HTTP request.
-	resp, err := http.Get(u.String())
+	resp, err := t.client().Get(u.String())
 	if err != nil {
 		return nil, err
 	}
 
 	// Parse returned error.
 	if s := resp.Header.Get("X-Raft-Error"); s != "" {
-		_ = resp.Body.Close()
+		_ = transport.CloseBody(resp)
 		return nil, errors.New(s)
 	}
 
@@ -154,11 +168,11 @@ func (t *HTTPTransport) RequestVote(uri *url.URL, term, candidateID, lastLogInde
 	u.RawQuery = v.Encode()
 
 	// Send HTTP request.
-	resp, err := http.Get(u.String())
+	resp, err := t.client().Get(u.String())
 	if err != nil {
 		return err
 	}
-	_ = resp.Body.Close()
+	_ = transport.CloseBody(resp)
 
 	// Parse returned error.
 	if s := resp.Header.Get("X-Raft-Error"); s != "" {
@@ -0,0 +1,99 @@
+package influxdb
+
+import (
+	"time"
+)
+
+// RecoveryReport describes what a data node recovered when it started: the
+// data directory migrations run, the shard stores opened or skipped, and the
+// messages replayed from the broker until the node caught up.
+type RecoveryReport struct {
+	StartedAt   time.Time  `json:"startedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"` // set once the node has caught up with the broker
+
+	// Format version of the data directory before and after it was migrated.
+	FormatVersionBefore int `json:"formatVersionBefore"`
+	FormatVersion       int `json:"formatVersion"`
+
+	// Shard stores opened, and those that couldn't be opened and are
+	// unavailable until the node is repaired.
+	ShardsOpened  int            `json:"shardsOpened"`
+	ShardsSkipped []SkippedShard `json:"shardsSkipped,omitempty"`
+
+	// Broker messages applied since the index stored in the metastore, and
+	// the points they wrote.
+	StartIndex       uint64 `json:"startIndex"`
+	Index            uint64 `json:"index"`
+	MessagesReplayed int    `json:"messagesReplayed"`
+	PointsRecovered  int    `json:"pointsRecovered"`
+
+	// Segments of the local broker's topics whose torn last message was
+	// removed. Set by the HTTP handler if the node runs a broker.
+	TruncatedSegments []string `json:"truncatedSegments,omitempty"`
+}
+
+// Clean returns true if nothing was skipped or truncated.
+func (r *RecoveryReport) Clean() bool {
+	return len(r.ShardsSkipped) == 0 && len(r.TruncatedSegments) == 0
+}
+
+// SkippedShard is a shard whose store couldn't be opened.
+type SkippedShard struct {
+	ID    uint64 `json:"id"`
+	Error string `json:"error"`
+}
+
+// Recovery returns what the server recovered when it was opened.
+func (s *Server) Recovery() RecoveryReport {
+	ready := s.Ready()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ready {
+		s.completeRecovery()
+	}
+	r := s.recovery
+	r.ShardsSkipped = append([]SkippedShard(nil), r.ShardsSkipped...)
+	return r
+}
+
+// recordReplay counts a message applied before the server caught up with the
+// broker. Must be called under the server lock after the index is set.
+func (s *Server) recordReplay(client MessagingClient, pointN int) {
+	if s.recovery.CompletedAt != nil {
+		return
+	}
+	s.recovery.MessagesReplayed++
+	s.recovery.PointsRecovered += pointN
+	s.recovery.Index = s.index
+
+	if c, ok := client.(interface {
+		CaughtUp() bool
+	}); !ok || c.CaughtUp() {
+		s.completeRecovery()
+	}
+}
+
+// completeRecovery marks recovery as complete. Must be called under the server lock.
+func (s *Server) completeRecovery() {
+	if s.recovery.CompletedAt == nil {
+		now := time.Now().UTC()
+		s.recovery.CompletedAt = &now
+		s.recovery.Index = s.index
+	}
+}
+
+// countRawPoints returns the number of points in a raw series batch.
+func countRawPoints(batch []byte) int {
+	var n int
+	for len(batch) >= pointHeaderSize {
+		_, payloadLength, _ := unmarshalPointHeader(batch[:pointHeaderSize])
+		batch = batch[pointHeaderSize:]
+		if int(payloadLength) > len(batch) {
+			break
+		}
+		batch = batch[payloadLength:]
+		n++
+	}
+	return n
+}
@@ -0,0 +1,329 @@
+package influxdb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// tagRewriteBatchSize is the number of points copied per write when a series
+// is merged into an existing series.
+const tagRewriteBatchSize = 5000
+
+// TagRewrite represents a background job that changes the value of a tag on
+// every series of a measurement that has it.
+//
+// A series is renamed in place by changing its tags, which doesn't touch its
+// data. If a series with the new tags already exists then the points of the
+// old series are written to the existing series and the old series is dropped.
+type TagRewrite struct {
+	ID          uint64 `json:"id"`
+	Database    string `json:"database"`
+	Measurement string `json:"measurement"`
+	Key         string `json:"key"`
+	OldValue    string `json:"oldValue"`
+	NewValue    string `json:"newValue"`
+
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"` // zero until the job is done
+
+	SeriesN    int    `json:"seriesN"`         // series with the old value
+	SeriesDone int    `json:"seriesDone"`      // series rewritten so far
+	PointsN    int    `json:"pointsN"`         // points copied into existing series
+	Err        string `json:"error,omitempty"` // set if the job failed
+}
+
+// Done returns true if the job has finished or failed.
+func (j *TagRewrite) Done() bool { return !j.EndTime.IsZero() }
+
+// tagRewrites tracks the tag rewrite jobs started on the server.
+type tagRewrites struct {
+	mu   sync.Mutex
+	jobs []*TagRewrite
+}
+
+// update calls fn with the job while holding the lock.
+func (r *tagRewrites) update(j *TagRewrite, fn func(j *TagRewrite)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(j)
+}
+
+// RewriteTagValue starts a job that changes the value of a tag key from
+// oldValue to newValue on the series of a measurement. Returns the job as it
+// was started. Progress is available from TagRewrites.
+func (s *Server) RewriteTagValue(database, measurement, key, oldValue, newValue string) (*TagRewrite, error) {
+	if key == "" || newValue == "" {
+		return nil, ErrInvalidTagRewrite
+	} else if oldValue == newValue {
+		return nil, ErrInvalidTagRewrite
+	}
+
+	// Find the series with the old value.
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return nil, ErrDatabaseNotFound
+	}
+	m := db.measurements[measurement]
+	if m == nil {
+		s.mu.RUnlock()
+		return nil, ErrMeasurementNotFound
+	}
+	ids := append(seriesIDs(nil), m.seriesByTagKeyValue[key][oldValue]...)
+	s.mu.RUnlock()
+
+	// Register the job.
+	j := &TagRewrite{
+		Database:    database,
+		Measurement: measurement,
+		Key:         key,
+		OldValue:    oldValue,
+		NewValue:    newValue,
+		StartTime:   time.Now().UTC(),
+		SeriesN:     len(ids),
+	}
+	s.tagRewrites.mu.Lock()
+	j.ID = uint64(len(s.tagRewrites.jobs) + 1)
+	s.tagRewrites.jobs = append(s.tagRewrites.jobs, j)
+	other := *j
+	s.tagRewrites.mu.Unlock()
+
+	go s.runTagRewrite(j, ids)
+
+	return &other, nil
+}
+
+// TagRewrites returns the tag rewrite jobs started on the server, oldest first.
+func (s *Server) TagRewrites() []*TagRewrite {
+	s.tagRewrites.mu.Lock()
+	defer s.tagRewrites.mu.Unlock()
+
+	a := make([]*TagRewrite, len(s.tagRewrites.jobs))
+	for i, j := range s.tagRewrites.jobs {
+		other := *j
+		a[i] = &other
+	}
+	return a
+}
+
+// TagRewrite returns a tag rewrite job by id or nil if it doesn't exist.
+func (s *Server) TagRewrite(id uint64) *TagRewrite {
+	for _, j := range s.TagRewrites() {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// runTagRewrite rewrites each series in turn and records the job's progress.
+func (s *Server) runTagRewrite(j *TagRewrite, ids seriesIDs) {
+	var err error
+	for _, id := range ids {
+		var n int
+		if n, err = s.rewriteSeriesTag(j, id); err != nil {
+			break
+		}
+		s.tagRewrites.update(j, func(j *TagRewrite) {
+			j.SeriesDone++
+			j.PointsN += n
+		})
+	}
+
+	s.tagRewrites.update(j, func(j *TagRewrite) {
+		if err != nil {
+			j.Err = err.Error()
+		}
+		j.EndTime = time.Now().UTC()
+	})
+	if err != nil {
+		s.Logger.Printf("tag rewrite %d failed: %s", j.ID, err)
+	} else {
+		s.Logger.Printf("tag rewrite %d rewrote %d series", j.ID, j.SeriesN)
+	}
+}
+
+// rewriteSeriesTag changes the tag of a single series. Returns the number of
+// points copied if the series was merged into an existing series.
+func (s *Server) rewriteSeriesTag(j *TagRewrite, id uint32) (int, error) {
+	s.mu.RLock()
+	var tags map[string]string
+	var target *Series
+	if db := s.databases[j.Database]; db != nil {
+		if series := db.series[id]; series != nil && series.Tags[j.Key] == j.OldValue {
+			tags = make(map[string]string, len(series.Tags))
+			for k, v := range series.Tags {
+				tags[k] = v
+			}
+			tags[j.Key] = j.NewValue
+			target = series.measurement.seriesByTags(tags)
+		}
+	}
+	s.mu.RUnlock()
+
+	// Ignore series that were dropped or changed since the job started.
+	if tags == nil {
+		return 0, nil
+	}
+
+	// Change the series' tags if no series has the new tags yet.
+	if target == nil {
+		if err := s.UpdateSeriesTags(j.Database, j.Measurement, id, tags); err != ErrSeriesExists {
+			return 0, err
+		}
+	}
+
+	// Otherwise copy the points to the existing series and drop the old one.
+	n, err := s.copySeriesPoints(j.Database, j.Measurement, id, tags)
+	if err != nil {
+		return n, err
+	}
+	return n, s.DropSeries(j.Database, map[string][]uint32{j.Measurement: {id}})
+}
+
+// copySeriesPoints writes the points of a series to the series with the given
+// tags. The data of the series must be stored on this server.
+func (s *Server) copySeriesPoints(database, measurement string, id uint32, tags map[string]string) (int, error) {
+	type source struct {
+		policy string
+		shard  *Shard
+	}
+
+	// Find the shard holding the series in each shard group.
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return 0, ErrDatabaseNotFound
+	}
+	m := db.measurements[measurement]
+	if m == nil {
+		s.mu.RUnlock()
+		return 0, ErrMeasurementNotFound
+	}
+	codec := NewFieldCodec(m)
+	var sources []source
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			sh := g.ShardBySeriesID(id)
+			if !sh.HasDataNodeID(s.id) {
+				s.mu.RUnlock()
+				return 0, fmt.Errorf("shard %d is not stored on this server", sh.ID)
+			}
+			sources = append(sources, source{policy: rp.Name, shard: sh})
+		}
+	}
+	s.mu.RUnlock()
+
+	// Copy the points in batches.
+	var n int
+	for _, src := range sources {
+		var seek []byte
+		for {
+			a, err := src.shard.readSeriesBatch(id, seek, tagRewriteBatchSize)
+			if err != nil {
+				return n, err
+			} else if len(a) == 0 {
+				break
+			}
+
+			points := make([]Point, len(a))
+			for i, p := range a {
+				points[i] = Point{Name: measurement, Tags: tags, Timestamp: time.Unix(0, p.timestamp).UTC(), Fields: codec.decodeFieldsByName(p.data)}
+			}
+			index, err := s.WriteSeries(database, src.policy, points)
+			if err != nil {
+				return n, err
+			} else if err := s.Sync(index); err != nil {
+				return n, err
+			}
+
+			n += len(a)
+			seek = u64tob(uint64(a[len(a)-1].timestamp) + 1)
+		}
+	}
+	return n, nil
+}
+
+// UpdateSeriesTags changes the tags of a series. Returns ErrSeriesExists if
+// another series in the measurement already has the tags.
+func (s *Server) UpdateSeriesTags(database, measurement string, id uint32, tags map[string]string) error {
+	c := &updateSeriesTagsCommand{Database: database, Measurement: measurement, SeriesID: id, Tags: tags}
+	_, err := s.broadcast(updateSeriesTagsMessageType, c)
+	return err
+}
+
+func (s *Server) applyUpdateSeriesTags(m *messaging.Message) error {
+	var c updateSeriesTagsCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	mm := db.measurements[c.Measurement]
+	if mm == nil {
+		return ErrMeasurementNotFound
+	}
+	series := mm.seriesByID[c.SeriesID]
+	if series == nil {
+		return ErrSeriesNotFound
+	} else if other := mm.seriesByTags(c.Tags); other != nil {
+		return ErrSeriesExists
+	}
+
+	return s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		// Reindex the series under its new tags.
+		mm.dropSeries(series.ID)
+		delete(db.series, series.ID)
+		series.Tags = c.Tags
+		db.addSeriesToIndex(mm.Name, series)
+
+		return tx.saveSeries(db.name, mm.Name, series)
+	})
+}
+
+// readSeriesBatch returns up to n points of a series starting at the encoded
+// timestamp seek. Points are read from the start of the series if seek is nil.
+func (s *Shard) readSeriesBatch(seriesID uint32, seek []byte, n int) (a []rawPoint, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(u32tob(seriesID))
+		if b == nil {
+			return nil
+		}
+
+		codec := s.pointCodec(tx)
+		c := b.Cursor()
+		k, v := c.First()
+		if seek != nil {
+			k, v = c.Seek(seek)
+		}
+		for ; k != nil && len(a) < n; k, v = c.Next() {
+			data, ok, err := codec.decode(seriesID, k, v)
+			if err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			a = append(a, rawPoint{seriesID: seriesID, timestamp: int64(btou64(k)), data: append([]byte(nil), data...)})
+		}
+		return nil
+	})
+	return
+}
+
+// decodeFieldsByName decodes a byte slice into a map of field names and values.
+func (f *FieldCodec) decodeFieldsByName(b []byte) map[string]interface{} {
+	values := make(map[string]interface{})
+	for id, v := range f.DecodeFields(b) {
+		if field := f.fieldsByID[id]; field != nil {
+			values[field.Name] = v
+		}
+	}
+	return values
+}
@@ -0,0 +1,136 @@
+package influxdb
+
+import (
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// Rollup maps a measurement to a downsampled copy of it, such as one written
+// by a continuous query. Queries against the measurement that group by a
+// multiple of the rollup's interval, and only call the functions listed,
+// read from the rollup instead of the raw points.
+//
+// The rollup must store each field under its original name. A function
+// should only be listed if applying it to the rollup gives the answer it
+// gives for the raw points: the max of maxes is exact but the mean of means
+// is only exact when every interval holds the same number of points.
+type Rollup struct {
+	Database        string
+	RetentionPolicy string // the default retention policy if blank
+	Measurement     string
+
+	Interval              time.Duration
+	TargetRetentionPolicy string
+	TargetMeasurement     string // same as Measurement if blank
+	Functions             []string
+}
+
+// target returns the name of the rollup's measurement.
+func (r *Rollup) target() string {
+	if r.TargetMeasurement != "" {
+		return r.TargetMeasurement
+	}
+	return r.Measurement
+}
+
+// answers returns true if every field of stmt calls one of the rollup's
+// functions on a field of m, and stmt only filters and groups by time and
+// tags of m.
+func (r *Rollup) answers(stmt *influxql.SelectStatement, m *Measurement) bool {
+	for _, f := range stmt.Fields {
+		call, ok := f.Expr.(*influxql.Call)
+		if !ok || len(call.Args) != 1 || !r.hasFunction(call.Name) {
+			return false
+		}
+		ref, ok := call.Args[0].(*influxql.VarRef)
+		if !ok || m.FieldByName(ref.Val) == nil {
+			return false
+		}
+	}
+
+	ok := true
+	check := func(n influxql.Node) {
+		if ref, isRef := n.(*influxql.VarRef); isRef && ref.Val != "time" {
+			if _, isTag := m.seriesByTagKeyValue[ref.Val]; !isTag {
+				ok = false
+			}
+		}
+	}
+	if stmt.Condition != nil {
+		influxql.WalkFunc(stmt.Condition, check)
+	}
+	for _, d := range stmt.Dimensions {
+		influxql.WalkFunc(d, check)
+	}
+	return ok
+}
+
+// hasFunction returns true if name is one of the rollup's functions.
+func (r *Rollup) hasFunction(name string) bool {
+	for _, fn := range r.Functions {
+		if fn == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rollupSelectStatement returns a copy of stmt reading from the coarsest
+// rollup that can answer it. Returns nil if no rollup can.
+func (s *Server) rollupSelectStatement(stmt *influxql.SelectStatement) *influxql.SelectStatement {
+	if len(s.Rollups) == 0 {
+		return nil
+	}
+	source, ok := stmt.Source.(*influxql.Measurement)
+	if !ok {
+		return nil
+	}
+	interval, err := stmt.GroupByInterval()
+	if err != nil || interval == 0 {
+		return nil
+	}
+	segments, err := influxql.SplitIdent(source.Name)
+	if err != nil || len(segments) != 3 {
+		return nil
+	}
+	database, policy, name := segments[0], segments[1], segments[2]
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil
+	}
+
+	var best *Rollup
+	for _, r := range s.Rollups {
+		rp := r.RetentionPolicy
+		if rp == "" {
+			rp = db.defaultRetentionPolicy
+		}
+		if r.Database != database || rp != policy || r.Measurement != name {
+			continue
+		} else if r.Interval <= 0 || interval%r.Interval != 0 {
+			continue
+		} else if best != nil && best.Interval >= r.Interval {
+			continue
+		} else if db.policies[r.TargetRetentionPolicy] == nil {
+			continue
+		}
+
+		// The rollup must hold the fields and tags the statement reads.
+		if m := db.measurements[r.target()]; m == nil || !r.answers(stmt, m) {
+			continue
+		}
+		best = r
+	}
+	if best == nil {
+		return nil
+	}
+
+	other := *stmt
+	other.Source = &influxql.Measurement{Name: influxql.QuoteIdent([]string{database, best.TargetRetentionPolicy, best.target()})}
+	return &other
+}
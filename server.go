@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -17,10 +18,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
+	"github.com/influxdb/influxdb/transport"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -43,6 +46,27 @@ const (
 
 	// DefaultShardRetention is the length of time before a shard is dropped.
 	DefaultShardRetention = 7 * (24 * time.Hour)
+
+	// DefaultBackfillThreshold is how long after a shard group ends that
+	// writes to it are treated as backfill.
+	DefaultBackfillThreshold = 24 * time.Hour
+
+	// DefaultHeartbeatInterval is the default time between a data node's
+	// heartbeats.
+	DefaultHeartbeatInterval = 10 * time.Second
+
+	// DefaultMonitorDatabase is the database that self-monitoring writes to.
+	DefaultMonitorDatabase = "_internal"
+
+	// DefaultMonitorRetentionPolicyName is the retention policy that self-monitoring writes to.
+	DefaultMonitorRetentionPolicyName = "monitor"
+
+	// DefaultMonitorRetention is the length of time self-monitoring data is kept.
+	DefaultMonitorRetention = 7 * (24 * time.Hour)
+
+	// DeadmanMeasurement is the measurement that deadman checks write to
+	// when a series stops receiving points.
+	DeadmanMeasurement = "deadman"
 )
 
 // Server represents a collection of metadata and raw metric data.
@@ -53,8 +77,16 @@ type Server struct {
 	done   chan struct{} // goroutine close notification
 	rpDone chan struct{} // retention policies goroutine close notification
 
+	monitorDone   chan struct{} // self-monitoring goroutine close notification
+	deadmanDone   chan struct{} // deadman check goroutines close notification
+	compactDone   chan struct{} // shard compaction goroutine close notification
+	offloadDone   chan struct{} // shard offload goroutine close notification
+	heartbeatDone chan struct{} // heartbeat goroutine close notification
+	stats         *serverStats  // counters reported by self-monitoring
+
 	client MessagingClient  // broker client
 	index  uint64           // highest broadcast index seen
+	notify chan struct{}    // closed and replaced when index changes
 	errors map[uint64]error // message errors
 
 	meta *metastore // metadata store
@@ -62,13 +94,82 @@ type Server struct {
 	dataNodes map[uint64]*DataNode // data nodes by id
 	databases map[string]*database // databases by name
 	users     map[string]*User     // user by name
+	tokens    map[string]*Token    // API token by name
 
 	shards map[uint64]*Shard // shards by shard id
 
 	Logger     *log.Logger
 	WriteTrace bool // Detailed logging of write path
 
+	// query memory settings
+	MaxQueryMemory int64                // per-query buffer limit in bytes, unlimited if zero
+	QueryMemory    *influxql.MemoryPool // memory shared by all running queries
+	QuerySpillDir  string               // where queries over their limit spill rows, they fail if blank
+
+	// GROUP BY limits. Queries with more than MaxGroups groups fail, or only
+	// return the first MaxGroups groups if TruncateGroups is set. Unlimited if zero.
+	MaxGroups      int
+	TruncateGroups bool
+
+	// Maximum time a query can run for, unlimited if zero.
+	MaxQueryTimeout time.Duration
+
+	// Rollups read by queries in place of raw points. Set before opening.
+	Rollups []*Rollup
+
+	// Local shards of groups that ended more than OffloadAge ago are moved
+	// to ColdStore and fetched back when used. Fetched shards unused for
+	// ColdCacheTTL are removed from local disk again. Set before opening.
+	ColdStore    ObjectStore
+	OffloadAge   time.Duration
+	ColdCacheTTL time.Duration
+
+	// How points that don't match their checksum are read, one of the
+	// ChecksumPolicy constants. Defaults to ChecksumPolicyFail if blank.
+	// Set before opening.
+	ChecksumPolicy string
+
+	// Copy the data directory before migrating it to a newer format version.
+	// Set before opening.
+	MigrationBackup bool
+
+	// Writes with a point timestamped more than MaxFutureTime after the
+	// server's clock are rejected. Unlimited if zero.
+	MaxFutureTime time.Duration
+
+	// per-database resource limits
+	Quotas *QuotaManager
+
+	// what opening the server recovered, see Recovery
+	recovery RecoveryReport
+
+	// token buckets of databases with a write limit
+	writeLimits *writeLimiter
+
+	// schedules and throttles shard compactions
+	Compactor *Compactor
+
+	// throttles background disk IO such as compactions, TTL deletes and exports
+	IO *IOScheduler
+
+	// Writes to shard groups that ended longer than this ago are treated as
+	// backfill and written through a bulk path. Disabled if zero.
+	BackfillThreshold time.Duration
+
+	// usage metered per database and user
+	Usage *UsageMeter
+
+	// write counters per database and measurement
+	WriteStats *WriteStats
+
+	tagRewrites      tagRewrites      // tag rewrite jobs started on this server
+	fieldConversions fieldConversions // field conversion jobs started on this server
+
+	subMu         sync.Mutex                 // protects subscriptions
+	subscriptions map[*Subscription]struct{} // live subscriptions to written points
+
 	authenticationEnabled bool
+	readOnly              bool // reject writes and mutating statements
 
 	// continuous query settings
 	RecomputePreviousN     int
@@ -89,13 +190,29 @@ func NewServer() *Server {
 	s := Server{
 		meta:      &metastore{},
 		errors:    make(map[uint64]error),
+		notify:    make(chan struct{}),
 		dataNodes: make(map[uint64]*DataNode),
 		databases: make(map[string]*database),
 		users:     make(map[string]*User),
+		tokens:    make(map[string]*Token),
 
 		shards: make(map[uint64]*Shard),
+		stats:  &serverStats{},
 		Logger: log.New(os.Stderr, "[server] ", log.LstdFlags),
+
+		QueryMemory: influxql.NewMemoryPool(0),
+		Quotas:      NewQuotaManager(),
+		writeLimits: newWriteLimiter(),
+		Usage:       NewUsageMeter(),
+		WriteStats:  NewWriteStats(),
+		IO:          NewIOScheduler(),
+
+		BackfillThreshold: DefaultBackfillThreshold,
+
+		subscriptions: make(map[*Subscription]struct{}),
 	}
+	s.Compactor = NewCompactor(s.IO)
+
 	// Server will always return with authentication enabled.
 	// This ensures that disabling authentication must be an explicit decision.
 	// To set the server to 'authless mode', call server.SetAuthenticationEnabled(false).
@@ -108,6 +225,21 @@ func (s *Server) SetAuthenticationEnabled(enabled bool) {
 	s.authenticationEnabled = enabled
 }
 
+// SetReadOnly turns on or off read-only mode. While read-only, writes and
+// statements that change data or metadata are rejected with ErrReadOnly.
+func (s *Server) SetReadOnly(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readOnly = enabled
+}
+
+// ReadOnly returns true if the server is in read-only mode.
+func (s *Server) ReadOnly() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readOnly
+}
+
 // ID returns the data node id for the server.
 // Returns zero if the server is closed or the server has not joined a cluster.
 func (s *Server) ID() uint64 {
@@ -123,6 +255,56 @@ func (s *Server) Index() uint64 {
 	return s.index
 }
 
+// setIndex sets the index and wakes up any goroutines waiting for it to change.
+// The caller must hold the lock.
+func (s *Server) setIndex(index uint64) {
+	s.index = index
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// WaitForIndex blocks until the given index (or a higher index) has been
+// applied. Returns ErrWaitTimeout if the index is not reached within timeout.
+func (s *Server) WaitForIndex(index uint64, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.RLock()
+		if s.index >= index {
+			s.mu.RUnlock()
+			return nil
+		}
+		notify := s.notify
+		s.mu.RUnlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return ErrWaitTimeout
+		}
+	}
+}
+
+// Ready returns true if the server can serve traffic. A server is not ready
+// until it is open, has joined a cluster, and its messaging client has caught
+// up with the messages that were on the broker when it connected.
+func (s *Server) Ready() bool {
+	s.mu.RLock()
+	ready := s.opened() && s.id != 0 && s.client != nil
+	client := s.client
+	s.mu.RUnlock()
+
+	if !ready {
+		return false
+	} else if c, ok := client.(interface {
+		CaughtUp() bool
+	}); ok {
+		return c.CaughtUp()
+	}
+	return true
+}
+
 // Path returns the path used when opening the server.
 // Returns an empty string when the server is closed.
 func (s *Server) Path() string {
@@ -160,6 +342,11 @@ func (s *Server) Open(path string) error {
 	} else if path == "" {
 		return ErrPathRequired
 	}
+	policy, err := normalizeChecksumPolicy(s.ChecksumPolicy)
+	if err != nil {
+		return err
+	}
+	s.ChecksumPolicy = policy
 
 	// Set the server path.
 	s.path = path
@@ -168,6 +355,15 @@ func (s *Server) Open(path string) error {
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return err
 	}
+
+	// Upgrade the data directory to the current format version.
+	s.recovery = RecoveryReport{StartedAt: time.Now().UTC()}
+	mr, err := MigrateDataDir(path, MigrateOptions{Backup: s.MigrationBackup, Logger: s.Logger})
+	if err != nil {
+		return fmt.Errorf("migrate: %s", err)
+	}
+	s.recovery.FormatVersionBefore, s.recovery.FormatVersion = mr.From, mr.To
+
 	if err := os.MkdirAll(filepath.Join(path, "shards"), 0755); err != nil {
 		return err
 	}
@@ -204,9 +400,34 @@ func (s *Server) Close() error {
 		close(s.rpDone)
 	}
 
+	if s.monitorDone != nil {
+		close(s.monitorDone)
+		s.monitorDone = nil
+	}
+
+	if s.deadmanDone != nil {
+		close(s.deadmanDone)
+		s.deadmanDone = nil
+	}
+
+	if s.compactDone != nil {
+		close(s.compactDone)
+		s.compactDone = nil
+	}
+
+	if s.offloadDone != nil {
+		close(s.offloadDone)
+		s.offloadDone = nil
+	}
+
+	if s.heartbeatDone != nil {
+		close(s.heartbeatDone)
+		s.heartbeatDone = nil
+	}
+
 	// Remove path.
 	s.path = ""
-	s.index = 0
+	s.setIndex(0)
 
 	// Close message processing.
 	s.setClient(nil)
@@ -227,7 +448,8 @@ func (s *Server) load() error {
 	return s.meta.view(func(tx *metatx) error {
 		// Read server id & index.
 		s.id = tx.id()
-		s.index = tx.index()
+		s.setIndex(tx.index())
+		s.recovery.StartIndex, s.recovery.Index = s.index, s.index
 
 		// Load data nodes.
 		s.dataNodes = make(map[uint64]*DataNode)
@@ -251,14 +473,21 @@ func (s *Server) load() error {
 			}
 		}
 
-		// Open all shards.
+		// Open all shards. Stores that can't be opened are skipped so the
+		// rest of the data remains available.
+		s.shards = make(map[uint64]*Shard)
 		for _, db := range s.databases {
 			for _, rp := range db.policies {
 				for _, g := range rp.shardGroups {
 					for _, sh := range g.Shards {
-						if err := sh.open(s.shardPath(sh.ID)); err != nil {
-							return fmt.Errorf("cannot open shard store: id=%d, err=%s", sh.ID, err)
+						s.shards[sh.ID] = sh
+						if err := s.openShard(sh); err != nil {
+							s.Logger.Printf("skipping shard %d, cannot open store: %s", sh.ID, err)
+							sh.openErr = err
+							s.recovery.ShardsSkipped = append(s.recovery.ShardsSkipped, SkippedShard{ID: sh.ID, Error: err.Error()})
+							continue
 						}
+						s.recovery.ShardsOpened++
 					}
 				}
 			}
@@ -270,6 +499,12 @@ func (s *Server) load() error {
 			s.users[u.Name] = u
 		}
 
+		// Load API tokens.
+		s.tokens = make(map[string]*Token)
+		for _, t := range tx.tokens() {
+			s.tokens[t.Name] = t
+		}
+
 		return nil
 	})
 }
@@ -299,20 +534,352 @@ func (s *Server) StartRetentionPolicyEnforcement(checkInterval time.Duration) er
 func (s *Server) EnforceRetentionPolicies() {
 	log.Println("retention policy enforcement check commencing")
 
-	// Check all shard groups.
+	// Check the shard groups of all policies.
+	type policy struct{ database, name string }
+	var policies []policy
+	s.mu.RLock()
 	for _, db := range s.databases {
 		for _, rp := range db.policies {
+			policies = append(policies, policy{db.name, rp.Name})
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, p := range policies {
+		if err := s.enforceRetentionPolicy(p.database, p.name); err != nil {
+			log.Printf("failed to enforce retention policy %s on database %s: %s", p.name, p.database, err)
+		}
+	}
+
+	if err := s.EnforceMeasurementTTLs(); err != nil {
+		log.Printf("failed to enforce measurement ttls: %s", err)
+	}
+}
+
+// enforceRetentionPolicy requests deletion of the policy's shard groups whose
+// data has aged out of the policy's duration.
+func (s *Server) enforceRetentionPolicy(database, name string) error {
+	var ids []uint64
+	s.mu.RLock()
+	if db := s.databases[database]; db != nil {
+		if rp := db.policies[name]; rp != nil && rp.Duration != 0 {
 			for _, g := range rp.shardGroups {
-				if rp.Duration != 0 && g.EndTime.Add(rp.Duration).Before(time.Now().UTC()) {
-					log.Printf("shard group %d, retention policy %s, database %s due for deletion",
-						g.ID, rp.Name, db.name)
-					if err := s.DeleteShardGroup(db.name, rp.Name, g.ID); err != nil {
-						log.Printf("failed to request deletion of shard group %d: %s", g.ID, err.Error())
+				if g.EndTime.Add(rp.Duration).Before(time.Now().UTC()) {
+					ids = append(ids, g.ID)
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, id := range ids {
+		log.Printf("shard group %d, retention policy %s, database %s due for deletion", id, name, database)
+		if err := s.DeleteShardGroup(database, name, id); err != nil {
+			return fmt.Errorf("delete shard group %d: %s", id, err)
+		}
+	}
+	return nil
+}
+
+// EnforceMeasurementTTLs removes points from the local shards that are older
+// than the TTL of their measurement. Each server removes expired points from
+// its own shards so the deletes are not sent through the broker.
+func (s *Server) EnforceMeasurementTTLs() error {
+	type expiry struct {
+		sh       *Shard
+		seriesID uint32
+		before   int64
+	}
+
+	// Find the series with expired points in the local shards.
+	var a []expiry
+	now := time.Now().UTC()
+	s.mu.RLock()
+	for _, db := range s.databases {
+		for _, m := range db.measurements {
+			if m.TTL == 0 {
+				continue
+			}
+			cutoff := now.Add(-m.TTL)
+			for _, rp := range db.policies {
+				if rp.Duration != 0 && rp.Duration <= m.TTL {
+					continue
+				}
+				for _, g := range rp.shardGroups {
+					if !g.StartTime.Before(cutoff) {
+						continue
+					}
+					for _, id := range m.seriesIDs {
+						if sh := g.ShardBySeriesID(id); sh.HasDataNodeID(s.id) {
+							a = append(a, expiry{sh: sh, seriesID: id, before: cutoff.UnixNano()})
+						}
+					}
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, e := range a {
+		if err := e.sh.deleteSeriesBefore(e.seriesID, e.before, s.IO.waitFunc(IOClassRetention)); err != nil {
+			return fmt.Errorf("shard %d: %s", e.sh.ID, err)
+		}
+	}
+	return nil
+}
+
+// serverStats holds counters of server activity. Fields are updated atomically.
+type serverStats struct {
+	writeReq      uint64 // calls to WriteSeries
+	writeErrors   uint64 // failed calls to WriteSeries
+	pointsWritten uint64 // points accepted by WriteSeries
+	backfillReq   uint64 // write messages applied through the backfill path
+	duplicateReq  uint64 // redelivered write messages that were already applied
+	rejectedReq   uint64 // write messages with points rejected as duplicates
+	queryReq      uint64 // calls to ExecuteQuery
+	queryErrors   uint64 // queries that returned an error
+}
+
+// StartSelfMonitoring periodically writes runtime and server statistics to a
+// database and retention policy, creating them if they do not exist.
+func (s *Server) StartSelfMonitoring(database, retentionPolicy string, interval time.Duration) error {
+	if interval == 0 {
+		return fmt.Errorf("self-monitoring interval must be non-zero")
+	}
+
+	// Create the database and retention policy for the statistics.
+	rp := NewRetentionPolicy(retentionPolicy)
+	rp.Duration = DefaultMonitorRetention
+	if err := s.CreateDatabaseWithRetentionPolicy(database, rp); err == ErrDatabaseExists {
+		if err := s.CreateRetentionPolicy(database, rp); err != nil && err != ErrRetentionPolicyExists {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	done := make(chan struct{}, 0)
+	s.monitorDone = done
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(interval):
+				if _, err := s.WriteSeries(database, retentionPolicy, s.monitorPoints()); err != nil {
+					s.Logger.Printf("self-monitoring write failed: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// monitorPoints returns a point for each group of statistics.
+func (s *Server) monitorPoints() []Point {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	s.mu.RLock()
+	numDatabases, numShards := len(s.databases), len(s.shards)
+	s.mu.RUnlock()
+
+	tags := map[string]string{"server_id": strconv.FormatUint(s.ID(), 10)}
+	now := time.Now().UTC()
+	points := []Point{
+		{Name: "runtime", Tags: tags, Timestamp: now, Fields: map[string]interface{}{
+			"heap_alloc":     float64(mem.HeapAlloc),
+			"heap_sys":       float64(mem.HeapSys),
+			"heap_objects":   float64(mem.HeapObjects),
+			"num_gc":         float64(mem.NumGC),
+			"pause_total_ns": float64(mem.PauseTotalNs),
+			"num_goroutine":  float64(runtime.NumGoroutine()),
+		}},
+		{Name: "write", Tags: tags, Timestamp: now, Fields: map[string]interface{}{
+			"write_req":      float64(atomic.LoadUint64(&s.stats.writeReq)),
+			"write_errors":   float64(atomic.LoadUint64(&s.stats.writeErrors)),
+			"points_written": float64(atomic.LoadUint64(&s.stats.pointsWritten)),
+			"backfill_req":   float64(atomic.LoadUint64(&s.stats.backfillReq)),
+			"duplicate_req":  float64(atomic.LoadUint64(&s.stats.duplicateReq)),
+			"rejected_req":   float64(atomic.LoadUint64(&s.stats.rejectedReq)),
+		}},
+		{Name: "query", Tags: tags, Timestamp: now, Fields: map[string]interface{}{
+			"query_req":    float64(atomic.LoadUint64(&s.stats.queryReq)),
+			"query_errors": float64(atomic.LoadUint64(&s.stats.queryErrors)),
+			"memory_used":  float64(s.QueryMemory.Used()),
+		}},
+		{Name: "server", Tags: tags, Timestamp: now, Fields: map[string]interface{}{
+			"databases": float64(numDatabases),
+			"shards":    float64(numShards),
+		}},
+	}
+
+	// Add the write counters of each database and measurement.
+	for _, st := range s.WriteStats.Stats() {
+		p := Point{Name: "write_database", Tags: map[string]string{"server_id": tags["server_id"], "database": st.Database}, Timestamp: now, Fields: map[string]interface{}{
+			"points_written": float64(st.PointsWritten),
+		}}
+		if st.Measurement != "" {
+			p.Name, p.Tags["measurement"] = "write_measurement", st.Measurement
+		} else {
+			p.Fields["bytes_received"] = float64(st.BytesReceived)
+		}
+		var dropped uint64
+		for reason, n := range st.PointsDropped {
+			p.Fields["points_dropped_"+reason] = float64(n)
+			dropped += n
+		}
+		p.Fields["points_dropped"] = float64(dropped)
+		points = append(points, p)
+	}
+	return points
+}
+
+// DeadmanCheck describes series that are expected to receive points regularly.
+type DeadmanCheck struct {
+	Database    string
+	Measurement string        // checks all measurements if blank
+	Threshold   time.Duration // longest time allowed between points
+	WebhookURL  string        // receives silent series; written to DeadmanMeasurement if blank
+}
+
+// SilentSeries represents a series that has not received points recently.
+type SilentSeries struct {
+	Database    string            `json:"database"`
+	Measurement string            `json:"measurement"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	LastSeen    time.Time         `json:"lastSeen"`
+}
+
+// SilentSeries returns the series in a database without any points newer
+// than threshold before now. If measurement is blank, all measurements are checked.
+func (s *Server) SilentSeries(database, measurement string, threshold time.Duration, now time.Time) ([]*SilentSeries, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	var measurements Measurements
+	if measurement == "" {
+		measurements = db.Measurements()
+	} else if m := db.measurements[measurement]; m != nil {
+		measurements = Measurements{m}
+	} else {
+		return nil, ErrMeasurementNotFound
+	}
+
+	var a []*SilentSeries
+	cutoff := now.Add(-threshold)
+	for _, m := range measurements {
+		// Don't report on the markers written by deadman checks.
+		if m.Name == DeadmanMeasurement {
+			continue
+		}
+
+		for _, id := range m.seriesIDs {
+			lastSeen, err := db.lastSeen(id)
+			if err != nil {
+				return nil, err
+			} else if !lastSeen.Before(cutoff) {
+				continue
+			}
+			a = append(a, &SilentSeries{
+				Database:    database,
+				Measurement: m.Name,
+				Tags:        m.seriesByID[id].Tags,
+				LastSeen:    lastSeen,
+			})
+		}
+	}
+	return a, nil
+}
+
+// StartDeadmanCheck periodically looks for series that have stopped receiving
+// points. Each series is reported once when it goes silent and again only
+// after it has received new points.
+func (s *Server) StartDeadmanCheck(c *DeadmanCheck, interval time.Duration) error {
+	if interval == 0 {
+		return fmt.Errorf("deadman check interval must be non-zero")
+	} else if c.Threshold == 0 {
+		return fmt.Errorf("deadman threshold must be non-zero")
+	}
+
+	s.mu.Lock()
+	if s.deadmanDone == nil {
+		s.deadmanDone = make(chan struct{}, 0)
+	}
+	done := s.deadmanDone
+	s.mu.Unlock()
+
+	go func() {
+		reported := make(map[string]bool)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(interval):
+				a, err := s.SilentSeries(c.Database, c.Measurement, c.Threshold, time.Now().UTC())
+				if err != nil {
+					s.Logger.Printf("deadman check failed: %s", err)
+					continue
+				}
+
+				// Report series that have gone silent since the last check.
+				silent := make(map[string]bool)
+				for _, ss := range a {
+					key := ss.Measurement + string(marshalTags(ss.Tags))
+					if !reported[key] {
+						if err := s.reportSilentSeries(c, ss); err != nil {
+							s.Logger.Printf("deadman report failed: %s", err)
+							continue
+						}
 					}
+					silent[key] = true
 				}
+				reported = silent
 			}
 		}
+	}()
+	return nil
+}
+
+// reportSilentSeries sends a silent series to the check's webhook or writes
+// a marker point to the deadman measurement.
+func (s *Server) reportSilentSeries(c *DeadmanCheck, ss *SilentSeries) error {
+	if c.WebhookURL != "" {
+		b, err := json.Marshal(ss)
+		if err != nil {
+			return err
+		}
+		client := http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Post(c.WebhookURL, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		}
+		return nil
 	}
+
+	tags := map[string]string{"measurement": ss.Measurement}
+	for k, v := range ss.Tags {
+		tags[k] = v
+	}
+	_, err := s.WriteSeries(c.Database, "", []Point{{
+		Name:      DeadmanMeasurement,
+		Tags:      tags,
+		Timestamp: time.Now().UTC(),
+		Fields:    map[string]interface{}{"last_seen": float64(ss.LastSeen.UnixNano())},
+	}})
+	return err
 }
 
 // Client retrieves the current messaging client.
@@ -349,6 +916,9 @@ func (s *Server) setClient(client MessagingClient) error {
 		done := make(chan struct{}, 0)
 		s.done = done
 		go s.processor(client, done)
+
+		// Resume field conversions interrupted by a restart.
+		s.resumeFieldConversions()
 	}
 
 	return nil
@@ -395,10 +965,11 @@ func (s *Server) Sync(index uint64) error {
 			s.mu.RUnlock()
 			return err
 		}
+		notify := s.notify
 		s.mu.RUnlock()
 
-		// Otherwise wait momentarily and check again.
-		time.Sleep(1 * time.Millisecond)
+		// Otherwise wait for the index to change and check again.
+		<-notify
 	}
 }
 
@@ -436,7 +1007,8 @@ type dataNodeJSON struct {
 }
 
 // Join creates a new data node in an existing cluster, copies the metastore,
-// and initializes the ID.
+// and initializes the ID. The metastore is a snapshot of the cluster state so
+// the broker only streams the broadcast messages that follow it.
 func (s *Server) Join(u *url.URL, joinURL *url.URL) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -450,7 +1022,8 @@ func (s *Server) Join(u *url.URL, joinURL *url.URL) error {
 	// Send request.
 	joinURL = copyURL(joinURL)
 	joinURL.Path = "/data_nodes"
-	resp, err := http.Post(joinURL.String(), "application/octet-stream", &buf)
+	client := transport.Default.Client(0)
+	resp, err := client.Post(joinURL.String(), "application/octet-stream", &buf)
 	if err != nil {
 		return err
 	}
@@ -470,7 +1043,7 @@ func (s *Server) Join(u *url.URL, joinURL *url.URL) error {
 
 	// Download the metastore from joining server.
 	joinURL.Path = "/metastore"
-	resp, err = http.Get(joinURL.String())
+	resp, err = client.Get(joinURL.String())
 	if err != nil {
 		return err
 	}
@@ -631,33 +1204,145 @@ func (s *Server) applyDeleteDataNode(m *messaging.Message) (err error) {
 	return
 }
 
-// DatabaseExists returns true if a database exists.
-func (s *Server) DatabaseExists(name string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.databases[name] != nil
+// StartHeartbeats launches broadcasting this data node's status every interval.
+func (s *Server) StartHeartbeats(interval time.Duration) error {
+	if interval == 0 {
+		return fmt.Errorf("heartbeat interval must be non-zero")
+	}
+
+	s.mu.Lock()
+	done := make(chan struct{}, 0)
+	s.heartbeatDone = done
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			if err := s.Heartbeat(interval); err != nil {
+				log.Printf("heartbeat: %s", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return nil
 }
 
-// Databases returns a sorted list of all database names.
-func (s *Server) Databases() (a []string) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for _, db := range s.databases {
-		a = append(a, db.name)
+// Heartbeat broadcasts the status of this data node. The node is reported down
+// if no other heartbeat follows within DataNodeHeartbeatMisses intervals.
+func (s *Server) Heartbeat(interval time.Duration) error {
+	id, path := s.ID(), s.Path()
+	if id == 0 {
+		return ErrDataNodeNotFound
 	}
-	sort.Strings(a)
-	return
-}
 
-// CreateDatabase creates a new database.
-func (s *Server) CreateDatabase(name string) error {
-	c := &createDatabaseCommand{Name: name}
-	_, err := s.broadcast(createDatabaseMessageType, c)
+	// The heartbeat is still sent if the free space can't be determined.
+	free, err := diskFree(path)
+	if err != nil {
+		log.Printf("unable to determine free disk space: %s", err)
+	}
+
+	c := &dataNodeHeartbeatCommand{ID: id, Time: time.Now().UTC(), Interval: interval, DiskFree: free}
+	_, err = s.broadcast(dataNodeHeartbeatMessageType, c)
 	return err
 }
 
-func (s *Server) applyCreateDatabase(m *messaging.Message) (err error) {
-	var c createDatabaseCommand
+func (s *Server) applyDataNodeHeartbeat(m *messaging.Message) (err error) {
+	var c dataNodeHeartbeatCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	n := s.dataNodes[c.ID]
+	if n == nil {
+		return ErrDataNodeNotFound
+	}
+
+	// Update the node's status.
+	n.LastHeartbeat = c.Time
+	n.HeartbeatInterval = c.Interval
+	n.DiskFree = c.DiskFree
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(m.Index, func(tx *metatx) error { return tx.saveDataNode(n) })
+
+	return
+}
+
+// SetDataNodeLabels sets labels on a data node, such as its rack or zone.
+// Labels with a blank value are removed.
+func (s *Server) SetDataNodeLabels(id uint64, labels map[string]string) error {
+	c := &setDataNodeLabelsCommand{ID: id, Labels: labels}
+	_, err := s.broadcast(setDataNodeLabelsMessageType, c)
+	return err
+}
+
+func (s *Server) applySetDataNodeLabels(m *messaging.Message) (err error) {
+	var c setDataNodeLabelsCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	n := s.dataNodes[c.ID]
+	if n == nil {
+		return ErrDataNodeNotFound
+	}
+
+	// Copy the labels so readers of the old map aren't affected.
+	labels := make(map[string]string, len(n.Labels)+len(c.Labels))
+	for k, v := range n.Labels {
+		labels[k] = v
+	}
+	for k, v := range c.Labels {
+		if v == "" {
+			delete(labels, k)
+		} else {
+			labels[k] = v
+		}
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+	n.Labels = labels
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(m.Index, func(tx *metatx) error { return tx.saveDataNode(n) })
+
+	return
+}
+
+// DatabaseExists returns true if a database exists.
+func (s *Server) DatabaseExists(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.databases[name] != nil
+}
+
+// Databases returns a sorted list of all database names.
+func (s *Server) Databases() (a []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, db := range s.databases {
+		a = append(a, db.name)
+	}
+	sort.Strings(a)
+	return
+}
+
+// CreateDatabase creates a new database.
+func (s *Server) CreateDatabase(name string) error {
+	return s.CreateDatabaseWithRetentionPolicy(name, nil)
+}
+
+// CreateDatabaseWithRetentionPolicy creates a new database along with a retention
+// policy that is set as the database's default. Both are created in a single
+// step so that no writes can occur before the retention policy exists.
+func (s *Server) CreateDatabaseWithRetentionPolicy(name string, rp *RetentionPolicy) error {
+	c := &createDatabaseCommand{Name: name, RetentionPolicy: rp}
+	_, err := s.broadcast(createDatabaseMessageType, c)
+	return err
+}
+
+func (s *Server) applyCreateDatabase(m *messaging.Message) (err error) {
+	var c createDatabaseCommand
 	mustUnmarshalJSON(m.Data, &c)
 
 	if s.databases[c.Name] != nil {
@@ -668,6 +1353,25 @@ func (s *Server) applyCreateDatabase(m *messaging.Message) (err error) {
 	db := newDatabase()
 	db.name = c.Name
 
+	// Add the default retention policy, if one was provided.
+	if rp := c.RetentionPolicy; rp != nil {
+		if rp.Name == "" {
+			return ErrRetentionPolicyNameRequired
+		}
+		duplicates, err := normalizeDuplicatePolicy(rp.Duplicates)
+		if err != nil {
+			return err
+		}
+
+		db.policies[rp.Name] = &RetentionPolicy{
+			Name:       rp.Name,
+			Duration:   rp.Duration,
+			ReplicaN:   rp.ReplicaN,
+			Duplicates: duplicates,
+		}
+		db.defaultRetentionPolicy = rp.Name
+	}
+
 	// Persist to metastore.
 	err = s.meta.mustUpdate(m.Index, func(tx *metatx) error { return tx.saveDatabase(db) })
 
@@ -695,8 +1399,289 @@ func (s *Server) applyDropDatabase(m *messaging.Message) (err error) {
 	// Remove from metastore.
 	err = s.meta.mustUpdate(m.Index, func(tx *metatx) error { return tx.dropDatabase(c.Name) })
 
+	// Stop streaming writes for the database's shards.
+	for _, rp := range s.databases[c.Name].policies {
+		for _, g := range rp.shardGroups {
+			s.unsubscribeShards(g.Shards)
+		}
+	}
+
 	// Delete the database entry.
 	delete(s.databases, c.Name)
+	s.writeLimits.remove(c.Name)
+	return
+}
+
+// RenameDatabase renames a database. Continuous queries, user privileges and
+// subscriptions that reference the database are updated to use the new name.
+func (s *Server) RenameDatabase(name, newName string) error {
+	c := &renameDatabaseCommand{Name: name, NewName: newName}
+	_, err := s.broadcast(renameDatabaseMessageType, c)
+	return err
+}
+
+func (s *Server) applyRenameDatabase(m *messaging.Message) (err error) {
+	var c renameDatabaseCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	// Validate command.
+	db := s.databases[c.Name]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if c.NewName == "" {
+		return ErrDatabaseNameRequired
+	} else if s.databases[c.NewName] != nil {
+		return ErrDatabaseExists
+	}
+
+	// Rewrite continuous queries before changing anything so that an invalid
+	// query leaves the database untouched.
+	rename := func(database, policy string) (string, string) {
+		if database == c.Name {
+			return c.NewName, policy
+		}
+		return database, policy
+	}
+	cqs, err := s.renameContinuousQueries(rename)
+	if err != nil {
+		return err
+	}
+
+	// Rename the database and move privileges to the new name.
+	delete(s.databases, db.name)
+	db.name = c.NewName
+	s.databases[db.name] = db
+	s.setContinuousQueries(cqs)
+
+	var users []*User
+	for _, u := range s.users {
+		if p, ok := u.Privileges[c.Name]; ok {
+			delete(u.Privileges, c.Name)
+			u.Privileges[c.NewName] = p
+			if f, ok := u.WriteMeasurements[c.Name]; ok {
+				delete(u.WriteMeasurements, c.Name)
+				u.WriteMeasurements[c.NewName] = f
+			}
+			users = append(users, u)
+		}
+	}
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		if err := tx.renameDatabase(c.Name, c.NewName); err != nil {
+			return err
+		}
+		for other := range cqs {
+			if err := tx.saveDatabase(other); err != nil {
+				return err
+			}
+		}
+		for _, u := range users {
+			if err := tx.saveUser(u); err != nil {
+				return err
+			}
+		}
+		return tx.saveDatabase(db)
+	})
+
+	s.renameSubscriptions(rename)
+	return
+}
+
+// SetWriteLimit limits the points written to a database to limit points per
+// second, with bursts of up to burst points. A burst of zero is the same as
+// the limit. A limit of zero removes it. Writes over the limit are rejected
+// with ErrWriteThrottled.
+func (s *Server) SetWriteLimit(database string, limit, burst int) error {
+	c := &setWriteLimitCommand{Database: database, Limit: limit, Burst: burst}
+	_, err := s.broadcast(setWriteLimitMessageType, c)
+	return err
+}
+
+func (s *Server) applySetWriteLimit(m *messaging.Message) error {
+	var c setWriteLimitCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if c.Limit < 0 || c.Burst < 0 {
+		return ErrInvalidWriteLimit
+	}
+
+	return s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		db.writeLimit, db.writeBurst = c.Limit, c.Burst
+		if c.Limit == 0 {
+			db.writeBurst = 0
+		}
+		return tx.saveDatabase(db)
+	})
+}
+
+// WriteLimit returns the write limit of a database in points per second and
+// its burst in points. Both are zero if the database has no write limit.
+func (s *Server) WriteLimit(database string) (limit, burst int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[database]
+	if db == nil {
+		return 0, 0, ErrDatabaseNotFound
+	}
+	return db.writeLimit, db.writeBurst, nil
+}
+
+// SetNameRules sets the rules normalizing the measurement names of points
+// written to a database. Nil rules remove the normalization.
+func (s *Server) SetNameRules(database string, rules *NameRules) error {
+	c := &setNameRulesCommand{Database: database, Rules: rules}
+	_, err := s.broadcast(setNameRulesMessageType, c)
+	return err
+}
+
+func (s *Server) applySetNameRules(m *messaging.Message) (err error) {
+	var c setNameRulesCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	var rules *NameRules
+	if r := c.Rules; r != nil {
+		if rules, err = NewNameRules(r.Lowercase, r.Characters, r.MaxLength); err != nil {
+			return err
+		}
+	}
+
+	return s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		db.nameRules = rules
+		return tx.saveDatabase(db)
+	})
+}
+
+// NameRules returns the rules normalizing the measurement names written to a
+// database. Returns nil if names are written unchanged.
+func (s *Server) NameRules(database string) (*NameRules, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+	return db.nameRules, nil
+}
+
+// CloneDatabase creates a new database with the retention policies, series
+// and continuous queries of an existing database. Continuous queries that
+// reference the database are changed to reference the clone. If data is true
+// then the shards of the database are copied as well so the clone can be
+// queried and written to without affecting the original.
+func (s *Server) CloneDatabase(name, newName string, data bool) error {
+	c := &cloneDatabaseCommand{Name: name, NewName: newName, Data: data}
+	_, err := s.broadcast(cloneDatabaseMessageType, c)
+	return err
+}
+
+func (s *Server) applyCloneDatabase(m *messaging.Message) (err error) {
+	var c cloneDatabaseCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	// Validate command.
+	src := s.databases[c.Name]
+	if src == nil {
+		return ErrDatabaseNotFound
+	} else if c.NewName == "" {
+		return ErrDatabaseNameRequired
+	} else if s.databases[c.NewName] != nil {
+		return ErrDatabaseExists
+	}
+
+	// Copy the database through its encoded form.
+	db := newDatabase()
+	mustUnmarshalJSON(mustMarshalJSON(src), &db)
+	db.name = c.NewName
+
+	// Point the cloned continuous queries at the clone.
+	rename := func(database, policy string) (string, string) {
+		if database == c.Name {
+			return c.NewName, policy
+		}
+		return database, policy
+	}
+	for i, cq := range db.continuousQueries {
+		q, err := renameContinuousQuery(cq.Query, rename)
+		if err != nil {
+			return fmt.Errorf("continuous query %s: %s", cq.cq.Name, err)
+		}
+		if db.continuousQueries[i], err = NewContinuousQuery(q); err != nil {
+			return fmt.Errorf("continuous query %s: %s", cq.cq.Name, err)
+		}
+	}
+
+	// Shard groups are only kept when the data is cloned.
+	shards := make(map[uint64]*Shard)
+	for _, rp := range src.policies {
+		for _, g := range rp.shardGroups {
+			for _, sh := range g.Shards {
+				shards[sh.ID] = sh
+			}
+		}
+	}
+	if !c.Data {
+		for _, rp := range db.policies {
+			rp.shardGroups = nil
+		}
+	}
+
+	// Persist to metastore. Cloned shards are given new ids and the source
+	// shard of each is tracked so its data can be copied.
+	sources := make(map[*Shard]*Shard)
+	if err = s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		if err := tx.cloneDatabase(c.Name, c.NewName); err != nil {
+			return err
+		}
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				g.ID = tx.nextShardGroupID()
+				for _, sh := range g.Shards {
+					sources[sh] = shards[sh.ID]
+					sh.ID = tx.nextShardID()
+				}
+			}
+		}
+		tx.indexDatabase(db)
+		return tx.saveDatabase(db)
+	}); err != nil {
+		return
+	}
+
+	// Add to databases on server.
+	s.databases[db.name] = db
+	for _, cq := range db.continuousQueries {
+		_ = s.normalizeStatement(cq.cq.Source, cq.cq.Database)
+	}
+
+	// Copy and open the shards assigned to this server. A copy that fails
+	// leaves its shard empty and the error is returned once all shards are open.
+	for sh, other := range sources {
+		s.shards[sh.ID] = sh
+		if !sh.HasDataNodeID(s.id) {
+			continue
+		}
+
+		path := s.shardPath(sh.ID)
+		if e := other.copyFile(path); e != nil && err == nil {
+			err = fmt.Errorf("copy shard %d: %s", other.ID, e)
+		}
+		if err := s.openShard(sh); err != nil {
+			panic("unable to open shard: " + err.Error())
+		}
+		if err := s.client.Subscribe(s.id, sh.ID); err != nil {
+			log.Printf("unable to subscribe: replica=%d, topic=%d, err=%s", s.id, sh.ID, err)
+		}
+	}
+
 	return
 }
 
@@ -707,6 +1692,94 @@ func (s *Server) Shard(id uint64) *Shard {
 	return s.shards[id]
 }
 
+// ShardStats represents the statistics of a shard stored on this data node.
+type ShardStats struct {
+	ID              uint64    `json:"id"`
+	Database        string    `json:"database"`
+	RetentionPolicy string    `json:"retentionPolicy"`
+	ShardGroupID    uint64    `json:"shardGroupID"`
+	StartTime       time.Time `json:"startTime"`
+	EndTime         time.Time `json:"endTime"`
+	SeriesN         int       `json:"seriesN"`
+	DiskBytes       int64     `json:"diskBytes"`
+
+	// Bytes in the shard's file that hold no data and can be reclaimed by
+	// compaction, and whether the shard is due to be compacted.
+	UnusedBytes       int64 `json:"unusedBytes"`
+	CompactionPending bool  `json:"compactionPending"`
+
+	// Whether the shard's store has been moved to the object store. Disk
+	// stats are only set while a fetched copy is on local disk.
+	Offloaded bool `json:"offloaded"`
+
+	LastWrite time.Time `json:"lastWrite"`
+}
+
+// ShardStats returns statistics for the shards stored on this data node,
+// sorted by id. If database is not blank then only its shards are returned.
+func (s *Server) ShardStats(database string) ([]*ShardStats, error) {
+	type entry struct {
+		db, rp string
+		g      *ShardGroup
+		sh     *Shard
+	}
+
+	// Collect local shards while holding the lock.
+	s.mu.RLock()
+	if database != "" && s.databases[database] == nil {
+		s.mu.RUnlock()
+		return nil, ErrDatabaseNotFound
+	}
+	var entries []entry
+	for _, db := range s.databases {
+		if database != "" && db.name != database {
+			continue
+		}
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if sh.HasDataNodeID(s.id) {
+						entries = append(entries, entry{db.name, rp.Name, g, sh})
+					}
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	// Read the stats from each shard's store.
+	a := make([]*ShardStats, 0, len(entries))
+	for _, e := range entries {
+		seriesN, size, inuse, err := e.sh.diskStats()
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %s", e.sh.ID, err)
+		}
+		offloaded, _ := e.sh.offloaded()
+		a = append(a, &ShardStats{
+			ID:                e.sh.ID,
+			Database:          e.db,
+			RetentionPolicy:   e.rp,
+			ShardGroupID:      e.g.ID,
+			StartTime:         e.g.StartTime,
+			EndTime:           e.g.EndTime,
+			SeriesN:           seriesN,
+			DiskBytes:         size,
+			UnusedBytes:       size - inuse,
+			CompactionPending: e.g.EndTime.Before(time.Now()) && s.Compactor.pending(e.sh, size, inuse),
+			Offloaded:         offloaded,
+			LastWrite:         e.sh.lastWriteTime(),
+		})
+	}
+	sort.Sort(shardStats(a))
+	return a, nil
+}
+
+type shardStats []*ShardStats
+
+func (a shardStats) Len() int           { return len(a) }
+func (a shardStats) Less(i, j int) bool { return a[i].ID < a[j].ID }
+func (a shardStats) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 // shardGroupByTimestamp returns a group for a database, policy & timestamp.
 func (s *Server) shardGroupByTimestamp(database, policy string, timestamp time.Time) (*ShardGroup, error) {
 	db := s.databases[database]
@@ -768,8 +1841,15 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 
 	// If no shards match then create a new one.
 	g := newShardGroup()
-	g.StartTime = c.Timestamp.Truncate(rp.Duration).UTC()
-	g.EndTime = g.StartTime.Add(rp.Duration).UTC()
+	g.StartTime = c.Timestamp.Truncate(rp.ShardGroupDuration()).UTC()
+	g.EndTime = g.StartTime.Add(rp.ShardGroupDuration()).UTC()
+
+	// Start after any truncated group that ends within the new group's range.
+	for _, other := range rp.shardGroups {
+		if other.StartTime.Before(g.EndTime) && other.EndTime.After(g.StartTime) {
+			g.StartTime = other.EndTime
+		}
+	}
 
 	// Sort nodes so they're consistently assigned to the shards.
 	nodes := make([]*DataNode, 0, len(s.dataNodes))
@@ -835,7 +1915,7 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 		}
 
 		// Open shard store. Panic if an error occurs and we can retry.
-		if err := sh.open(s.shardPath(sh.ID)); err != nil {
+		if err := s.openShard(sh); err != nil {
 			panic("unable to open shard: " + err.Error())
 		}
 	}
@@ -863,6 +1943,20 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 	return
 }
 
+// unsubscribeShards removes this server's subscriptions to the topics of
+// shards that it no longer stores. The broker then stops streaming their
+// writes, including when this server replays the topics to catch up.
+func (s *Server) unsubscribeShards(a []*Shard) {
+	for _, sh := range a {
+		if !sh.HasDataNodeID(s.id) {
+			continue
+		}
+		if err := s.client.Unsubscribe(s.id, sh.ID); err != nil {
+			log.Printf("unable to unsubscribe: replica=%d, topic=%d, err=%s", s.id, sh.ID, err)
+		}
+	}
+}
+
 // DeleteShardGroup deletes the shard group identified by shardID.
 func (s *Server) DeleteShardGroup(database, policy string, shardID uint64) error {
 	c := &deleteShardGroupCommand{Database: database, Policy: policy, ID: shardID}
@@ -900,14 +1994,13 @@ func (s *Server) applyDeleteShardGroup(m *messaging.Message) (err error) {
 			continue
 		}
 
-		path := shard.store.Path()
-		shard.close()
-		if err := os.Remove(path); err != nil {
+		if err := s.removeShard(shard); err != nil {
 			// Log, but keep going. This can happen if shards were deleted, but the server exited
 			// before it acknowledged the delete command.
-			log.Printf("error deleting shard %s, group ID %d, policy %s: %s", path, g.ID, rp.Name, err.Error())
+			log.Printf("error deleting shard %d, group ID %d, policy %s: %s", shard.ID, g.ID, rp.Name, err.Error())
 		}
 	}
+	s.unsubscribeShards(g.Shards)
 
 	// Remove from metastore.
 	rp.removeShardGroupByID(c.ID)
@@ -917,6 +2010,87 @@ func (s *Server) applyDeleteShardGroup(m *messaging.Message) (err error) {
 	return
 }
 
+// DropShard deletes a shard and removes its group. Returns
+// ErrShardGroupNotDroppable if the group has other shards because series are
+// assigned to shards by their position in the group.
+func (s *Server) DropShard(id uint64) error {
+	c := &dropShardCommand{ID: id}
+	_, err := s.broadcast(dropShardMessageType, c)
+	return err
+}
+
+func (s *Server) applyDropShard(m *messaging.Message) (err error) {
+	var c dropShardCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	// Find the shard's group.
+	g := s.shardGroupByShardID(c.ID)
+	if g == nil {
+		return ErrShardNotFound
+	}
+	if len(g.Shards) > 1 {
+		return ErrShardGroupNotDroppable
+	}
+	db, rp := s.retentionPolicyByShardID(c.ID)
+	sh := g.Shards[0]
+
+	// Delete the shard's data if it's stored on this server.
+	if sh.HasDataNodeID(s.id) {
+		if err := s.removeShard(sh); err != nil {
+			log.Printf("error deleting shard %d, group ID %d, policy %s: %s", sh.ID, g.ID, rp.Name, err.Error())
+		}
+	}
+
+	// Remove the group along with the shard.
+	s.unsubscribeShards(g.Shards)
+	rp.removeShardGroupByID(g.ID)
+	delete(s.shards, c.ID)
+	err = s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		return tx.saveDatabase(db)
+	})
+	return
+}
+
+// TruncateShardGroups ends every shard group that spans a time at that time.
+// Shard groups created for later points start at the time. This can be used to
+// start new shard groups at a boundary before changing a retention policy's
+// duration. Returns ErrTruncateTimeInPast if the time has already passed.
+func (s *Server) TruncateShardGroups(t time.Time) error {
+	if t.Before(time.Now()) {
+		return ErrTruncateTimeInPast
+	}
+	c := &truncateShardGroupsCommand{Timestamp: t}
+	_, err := s.broadcast(truncateShardGroupsMessageType, c)
+	return err
+}
+
+func (s *Server) applyTruncateShardGroups(m *messaging.Message) (err error) {
+	var c truncateShardGroupsCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	for _, db := range s.databases {
+		var changed bool
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				if g.StartTime.Before(c.Timestamp) && g.EndTime.After(c.Timestamp) {
+					g.EndTime = c.Timestamp.UTC()
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		if err := s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+			return tx.saveDatabase(db)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // User returns a user by username
 // Returns nil if the user does not exist.
 func (s *Server) User(name string) *User {
@@ -1078,7 +2252,17 @@ func (s *Server) applyDeleteUser(m *messaging.Message) error {
 
 // SetPrivilege grants / revokes a privilege to a user.
 func (s *Server) SetPrivilege(p influxql.Privilege, username string, dbname string) error {
-	c := &setPrivilegeCommand{p, username, dbname}
+	return s.SetPrivilegeWithFilter(p, username, dbname, nil)
+}
+
+// SetPrivilegeWithFilter grants a privilege on a database and restricts the
+// measurements the user can write to with a filter. A nil filter removes any
+// restriction.
+func (s *Server) SetPrivilegeWithFilter(p influxql.Privilege, username string, dbname string, f *MeasurementFilter) error {
+	if f != nil && (dbname == "" || p < influxql.WritePrivilege) {
+		return ErrInvalidGrantRevoke
+	}
+	c := &setPrivilegeCommand{Privilege: p, Username: username, Database: dbname, Measurements: f}
 	_, err := s.broadcast(setPrivilegeMessageType, c)
 	return err
 }
@@ -1101,8 +2285,16 @@ func (s *Server) applySetPrivilege(m *messaging.Message) error {
 	if c.Database == "" && (c.Privilege == influxql.AllPrivileges || c.Privilege == influxql.NoPrivileges) {
 		u.Admin = (c.Privilege == influxql.AllPrivileges)
 	} else if c.Database != "" {
-		// Update user's privilege for the database.
+		// Update user's privilege and measurement filter for the database.
 		u.Privileges[c.Database] = c.Privilege
+		if c.Measurements != nil {
+			if u.WriteMeasurements == nil {
+				u.WriteMeasurements = make(map[string]*MeasurementFilter)
+			}
+			u.WriteMeasurements[c.Database] = c.Measurements
+		} else {
+			delete(u.WriteMeasurements, c.Database)
+		}
 	} else {
 		return ErrInvalidGrantRevoke
 	}
@@ -1163,13 +2355,28 @@ func (s *Server) RetentionPolicies(database string) ([]*RetentionPolicy, error)
 	return a, nil
 }
 
-// CreateRetentionPolicy creates a retention policy for a database.
+// CreateRetentionPolicy creates a retention policy for a database. Returns
+// ErrReplicationFactorTooHigh if the policy has more replicas than data nodes.
 func (s *Server) CreateRetentionPolicy(database string, rp *RetentionPolicy) error {
+	return s.createRetentionPolicy(database, rp, false)
+}
+
+// ForceCreateRetentionPolicy creates a retention policy for a database even if
+// its replication factor exceeds the number of data nodes, such as before the
+// rest of a cluster has joined. Shards are never assigned more replicas than
+// there are data nodes.
+func (s *Server) ForceCreateRetentionPolicy(database string, rp *RetentionPolicy) error {
+	return s.createRetentionPolicy(database, rp, true)
+}
+
+func (s *Server) createRetentionPolicy(database string, rp *RetentionPolicy, force bool) error {
 	c := &createRetentionPolicyCommand{
-		Database: database,
-		Name:     rp.Name,
-		Duration: rp.Duration,
-		ReplicaN: rp.ReplicaN,
+		Database:   database,
+		Name:       rp.Name,
+		Duration:   rp.Duration,
+		ReplicaN:   rp.ReplicaN,
+		Duplicates: rp.Duplicates,
+		Force:      force,
 	}
 	_, err := s.broadcast(createRetentionPolicyMessageType, c)
 	return err
@@ -1189,11 +2396,20 @@ func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 		return ErrRetentionPolicyExists
 	}
 
+	// Validate the duplicate point policy and replication factor.
+	duplicates, err := normalizeDuplicatePolicy(c.Duplicates)
+	if err != nil {
+		return err
+	} else if err := s.validateReplicaN(c.ReplicaN, c.Force); err != nil {
+		return err
+	}
+
 	// Add policy to the database.
 	db.policies[c.Name] = &RetentionPolicy{
-		Name:     c.Name,
-		Duration: c.Duration,
-		ReplicaN: c.ReplicaN,
+		Name:       c.Name,
+		Duration:   c.Duration,
+		ReplicaN:   c.ReplicaN,
+		Duplicates: duplicates,
 	}
 
 	// Persist to metastore.
@@ -1207,9 +2423,13 @@ func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 // RetentionPolicyUpdate represents retention policy fields that
 // need to be updated.
 type RetentionPolicyUpdate struct {
-	Name     *string        `json:"name,omitempty"`
-	Duration *time.Duration `json:"duration,omitempty"`
-	ReplicaN *uint32        `json:"replicaN,omitempty"`
+	Name       *string        `json:"name,omitempty"`
+	Duration   *time.Duration `json:"duration,omitempty"`
+	ReplicaN   *uint32        `json:"replicaN,omitempty"`
+	Duplicates *string        `json:"duplicates,omitempty"`
+
+	// Allow a replication factor above the number of data nodes.
+	Force bool `json:"force,omitempty"`
 }
 
 // UpdateRetentionPolicy updates an existing retention policy on a database.
@@ -1237,11 +2457,49 @@ func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
 		return ErrRetentionPolicyNotFound
 	}
 
+	// Validate the duplicate point policy and replication factor before
+	// making any changes.
+	var duplicates string
+	if c.Policy.Duplicates != nil {
+		if duplicates, err = normalizeDuplicatePolicy(*c.Policy.Duplicates); err != nil {
+			return err
+		}
+	}
+	if c.Policy.ReplicaN != nil {
+		if err := s.validateReplicaN(*c.Policy.ReplicaN, c.Policy.Force); err != nil {
+			return err
+		}
+	}
+
+	// Validate the new name and rewrite continuous queries that reference the policy.
+	var cqs map[*database][]*ContinuousQuery
+	rename := func(database, policy string) (string, string) {
+		if database == c.Database && policy == c.Name {
+			return database, *c.Policy.Name
+		}
+		return database, policy
+	}
+	if c.Policy.Name != nil && *c.Policy.Name != p.Name {
+		if *c.Policy.Name == "" {
+			return ErrRetentionPolicyNameRequired
+		} else if db.policies[*c.Policy.Name] != nil {
+			return ErrRetentionPolicyExists
+		}
+		if cqs, err = s.renameContinuousQueries(rename); err != nil {
+			return err
+		}
+	}
+
 	// Update the policy name.
-	if c.Policy.Name != nil {
+	if cqs != nil {
 		delete(db.policies, p.Name)
 		p.Name = *c.Policy.Name
 		db.policies[p.Name] = p
+		if db.defaultRetentionPolicy == c.Name {
+			db.defaultRetentionPolicy = p.Name
+		}
+		s.setContinuousQueries(cqs)
+		s.renameSubscriptions(rename)
 	}
 
 	// Update duration.
@@ -1249,19 +2507,89 @@ func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
 		p.Duration = *c.Policy.Duration
 	}
 
-	// Update replication factor.
+	// Update replication factor. Raising it assigns more data nodes to the
+	// policy's existing shards.
+	var replicated []*Shard
 	if c.Policy.ReplicaN != nil {
+		if *c.Policy.ReplicaN > p.ReplicaN {
+			replicated = s.replicateShards(p, *c.Policy.ReplicaN, m.Index)
+		}
 		p.ReplicaN = *c.Policy.ReplicaN
 	}
 
+	// Update duplicate point policy.
+	if c.Policy.Duplicates != nil {
+		p.Duplicates = duplicates
+	}
+
 	// Persist to metastore.
 	err = s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		for other := range cqs {
+			if err := tx.saveDatabase(other); err != nil {
+				return err
+			}
+		}
 		return tx.saveDatabase(db)
 	})
 
+	// Open and subscribe to the shards newly assigned to this server. The
+	// broker replays each shard's topic from the start to fill the replica.
+	for _, sh := range replicated {
+		if err := s.openShard(sh); err != nil {
+			panic("unable to open shard: " + err.Error())
+		}
+		if err := s.client.Subscribe(s.id, sh.ID); err != nil {
+			log.Printf("unable to subscribe: replica=%d, topic=%d, err=%s", s.id, sh.ID, err)
+		}
+	}
+
 	return
 }
 
+// validateReplicaN returns ErrReplicationFactorTooHigh if a replication factor
+// exceeds the number of data nodes and isn't forced. A single replica is always
+// allowed so that policies can be created before any data node has joined.
+func (s *Server) validateReplicaN(replicaN uint32, force bool) error {
+	if !force && replicaN > 1 && int(replicaN) > len(s.dataNodes) {
+		return ErrReplicationFactorTooHigh
+	}
+	return nil
+}
+
+// replicateShards assigns data nodes to a policy's existing shards until each
+// has replicaN replicas or is stored on every data node. Nodes are picked
+// round robin from a place determined by the message index so that every
+// server makes the same assignments. Returns the shards that were newly
+// assigned to this server.
+func (s *Server) replicateShards(rp *RetentionPolicy, replicaN uint32, index uint64) (a []*Shard) {
+	nodes := make([]*DataNode, 0, len(s.dataNodes))
+	for _, n := range s.dataNodes {
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	sort.Sort(dataNodes(nodes))
+
+	nodeIndex := int(index % uint64(len(nodes)))
+	for _, g := range rp.shardGroups {
+		for _, sh := range g.Shards {
+			for i := 0; i < len(nodes) && len(sh.DataNodeIDs) < int(replicaN); i++ {
+				node := nodes[nodeIndex%len(nodes)]
+				nodeIndex++
+				if sh.HasDataNodeID(node.ID) {
+					continue
+				}
+				sh.DataNodeIDs = append(sh.DataNodeIDs, node.ID)
+				if node.ID == s.id {
+					a = append(a, sh)
+				}
+			}
+		}
+	}
+	return a
+}
+
 // DeleteRetentionPolicy removes a retention policy from a database.
 func (s *Server) DeleteRetentionPolicy(database, name string) error {
 	c := &deleteRetentionPolicyCommand{Database: database, Name: name}
@@ -1283,6 +2611,11 @@ func (s *Server) applyDeleteRetentionPolicy(m *messaging.Message) (err error) {
 		return ErrRetentionPolicyNotFound
 	}
 
+	// Stop streaming writes for the retention policy's shards.
+	for _, g := range db.policies[c.Name].shardGroups {
+		s.unsubscribeShards(g.Shards)
+	}
+
 	// Remove retention policy.
 	delete(db.policies, c.Name)
 
@@ -1359,26 +2692,135 @@ func (s *Server) DropSeries(database string, seriesByMeasurement map[string][]ui
 	return err
 }
 
-// Point defines the values that will be written to the database
-type Point struct {
-	Name      string
-	Tags      map[string]string
-	Timestamp time.Time
-	Fields    map[string]interface{}
+// Point defines the values that will be written to the database
+type Point struct {
+	Name      string
+	Tags      map[string]string
+	Timestamp time.Time
+	Fields    map[string]interface{}
+}
+
+// WriteSeries writes series data to the database.
+// Returns the messaging index the data was written to.
+func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (uint64, error) {
+	return s.WriteSeriesAs(nil, database, retentionPolicy, points)
+}
+
+// WriteSeriesAs writes series data to the database on behalf of a user so
+// the write is metered against them. A nil user meters the write anonymously.
+func (s *Server) WriteSeriesAs(user *User, database, retentionPolicy string, points []Point) (uint64, error) {
+	return s.WriteSeriesWait(user, database, retentionPolicy, points, WaitCommitted)
+}
+
+// WaitMode controls when a write returns, trading latency for durability.
+type WaitMode int
+
+const (
+	// WaitCommitted returns once the points are committed to the broker.
+	// The points may not be queryable until the shards apply them.
+	WaitCommitted WaitMode = iota
+
+	// WaitNone returns as soon as the points are queued to be written.
+	// Errors writing them are logged but not returned.
+	WaitNone
+
+	// WaitApplied returns once the points are applied by the shards stored
+	// on this data node so they can be queried from it.
+	WaitApplied
+
+	// WaitFlushed returns once the points are written and synced to disk
+	// by the shards stored on this data node. Shards sync each write as it
+	// is applied so this currently waits as long as WaitApplied.
+	WaitFlushed
+)
+
+// ParseWaitMode returns the wait mode with the given name.
+// An empty name returns WaitCommitted.
+func ParseWaitMode(s string) (WaitMode, error) {
+	switch s {
+	case "", "committed":
+		return WaitCommitted, nil
+	case "none":
+		return WaitNone, nil
+	case "applied":
+		return WaitApplied, nil
+	case "flushed":
+		return WaitFlushed, nil
+	}
+	return WaitCommitted, fmt.Errorf("invalid wait mode: %q", s)
+}
+
+// WriteSeriesWait writes series data the same as WriteSeriesAs but returns
+// at the point set by the wait mode. No index is returned with WaitNone.
+func (s *Server) WriteSeriesWait(user *User, database, retentionPolicy string, points []Point, wait WaitMode) (uint64, error) {
+	if wait == WaitNone {
+		go func() {
+			if _, err := s.WriteSeriesWait(user, database, retentionPolicy, points, WaitCommitted); err != nil {
+				log.Printf("unacknowledged write to database '%s' failed: %s", database, err)
+			}
+		}()
+		return 0, nil
+	}
+
+	atomic.AddUint64(&s.stats.writeReq, 1)
+	index, local, err := s.writeSeries(user, database, retentionPolicy, points)
+	if err == nil && (wait == WaitApplied || wait == WaitFlushed) {
+		// Shards commit each write to disk as it is applied.
+		for _, i := range local {
+			if err = s.Sync(i); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&s.stats.writeErrors, 1)
+
+		// Writes to unknown databases aren't counted so they can't add counters.
+		if s.DatabaseExists(database) {
+			s.WriteStats.recordRejected(database, points, err)
+		}
+	} else {
+		atomic.AddUint64(&s.stats.pointsWritten, uint64(len(points)))
+		s.WriteStats.recordWritten(database, points)
+	}
+	return index, err
+}
+
+// maxPointTime returns the latest timestamp a point can be written with.
+func (s *Server) maxPointTime() time.Time {
+	if s.MaxFutureTime <= 0 {
+		return time.Unix(0, math.MaxInt64)
+	}
+	return time.Now().Add(s.MaxFutureTime)
 }
 
-// WriteSeries writes series data to the database.
-// Returns the messaging index the data was written to.
-func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (uint64, error) {
+// writeSeries publishes the points to the broker. Returns the highest index
+// published and the indexes of the messages for shards on this data node.
+func (s *Server) writeSeries(user *User, database, retentionPolicy string, points []Point) (uint64, []uint64, error) {
 	if s.WriteTrace {
 		log.Printf("received write for database '%s', retention policy '%s', with %d points",
 			database, retentionPolicy, len(points))
 	}
 
-	// Make sure every point has at least one field.
-	for _, p := range points {
+	if s.ReadOnly() {
+		return 0, nil, ErrReadOnly
+	}
+
+	// Make sure every point has at least one field, no non-finite values and
+	// only keys that can be queried, isn't too far in the future and isn't
+	// written to a system measurement.
+	maxTime := s.maxPointTime()
+	for i, p := range points {
 		if len(p.Fields) == 0 {
-			return 0, ErrFieldsRequired
+			return 0, nil, ErrFieldsRequired
+		} else if nonFiniteField(p.Fields) != "" {
+			return 0, nil, ErrNonFiniteFieldValue
+		} else if k, ok := reservedKey(&p); ok {
+			return 0, nil, &PointError{Index: i, Key: k, Reason: "invalid key", Err: ErrReservedKey}
+		} else if p.Timestamp.After(maxTime) {
+			return 0, nil, &PointError{Index: i, Reason: "invalid timestamp " + p.Timestamp.UTC().Format(time.RFC3339Nano), Err: ErrTimestampInFuture}
+		} else if IsSystemMeasurement(p.Name) {
+			return 0, nil, &PointError{Index: i, Reason: "invalid measurement " + p.Name, Err: ErrSystemMeasurement}
 		}
 	}
 
@@ -1386,16 +2828,27 @@ func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (
 	if retentionPolicy == "" {
 		rp, err := s.DefaultRetentionPolicy(database)
 		if err != nil {
-			return 0, fmt.Errorf("failed to determine default retention policy: %s", err.Error())
+			return 0, nil, fmt.Errorf("failed to determine default retention policy: %s", err.Error())
 		} else if rp == nil {
-			return 0, ErrDefaultRetentionPolicyNotFound
+			return 0, nil, ErrDefaultRetentionPolicyNotFound
 		}
 		retentionPolicy = rp.Name
+	} else if rp, err := s.RetentionPolicy(database, retentionPolicy); err != nil {
+		return 0, nil, err
+	} else if rp == nil {
+		return 0, nil, ErrRetentionPolicyNotFound
+	}
+
+	// Reject the write if it would exceed the database's quota or write limit.
+	if err := s.enforceWriteQuota(database, points); err != nil {
+		return 0, nil, err
+	} else if err := s.throttleWrite(database, len(points)); err != nil {
+		return 0, nil, err
 	}
 
 	// Ensure all required Series and Measurement Fields are created cluster-wide.
 	if err := s.createMeasurementsIfNotExists(database, retentionPolicy, points); err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 	if s.WriteTrace {
 		log.Printf("measurements and series created on database '%s'", database)
@@ -1403,7 +2856,7 @@ func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (
 
 	// Ensure all the required shard groups exist. TODO: this should be done async.
 	if err := s.createShardGroupsIfNotExists(database, retentionPolicy, points); err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 	if s.WriteTrace {
 		log.Printf("shard groups created for database '%s'", database)
@@ -1411,6 +2864,7 @@ func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (
 
 	// Build writeRawSeriesMessageType publish commands.
 	shardData := make(map[uint64][]byte, 0)
+	localShards := make(map[uint64]bool)
 	codecs := make(map[string]*FieldCodec, 0)
 	if err := func() error {
 		// Local function makes lock management foolproof.
@@ -1441,6 +2895,7 @@ func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (
 			if s.WriteTrace {
 				log.Printf("shard located: %v", sh)
 			}
+			localShards[sh.ID] = sh.HasDataNodeID(s.id)
 
 			// Many points are likely to have the same Measurement name. Re-use codecs if possible.
 			var codec *FieldCodec
@@ -1470,12 +2925,14 @@ func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (
 
 		return nil
 	}(); err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
 	// Write data for each shard to the Broker.
 	var err error
 	var maxIndex uint64
+	var local []uint64
+	var n int
 	for i, d := range shardData {
 		index, err := s.client.Publish(&messaging.Message{
 			Type:    writeRawSeriesMessageType,
@@ -1483,17 +2940,203 @@ func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (
 			Data:    d,
 		})
 		if err != nil {
-			return maxIndex, err
+			return maxIndex, local, err
 		}
 		if index > maxIndex {
 			maxIndex = index
 		}
+		if localShards[i] {
+			local = append(local, index)
+		}
+		n += len(d)
 		if s.WriteTrace {
 			log.Printf("write series message published successfully for topic %d", i)
 		}
 	}
+	s.Usage.recordWrite(database, user, len(points), n)
+	s.publishPoints(database, retentionPolicy, points)
+
+	return maxIndex, local, err
+}
+
+// WriteValidation reports what writing a set of points would do.
+type WriteValidation struct {
+	Database        string                 `json:"database"`
+	RetentionPolicy string                 `json:"retentionPolicy"`
+	Points          int                    `json:"points"`
+	NewSeries       []SeriesKey            `json:"newSeries,omitempty"`
+	Errors          []PointValidationError `json:"errors,omitempty"`
+}
+
+// SeriesKey identifies a series by measurement name and tags.
+type SeriesKey struct {
+	Name string            `json:"name"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// PointValidationError describes why a point would be rejected.
+type PointValidationError struct {
+	Index int    `json:"index"`
+	Err   string `json:"error"`
+}
+
+// ValidateSeries checks points as WriteSeries would, reporting type conflicts,
+// bad timestamps and the series that would be created. Nothing is written.
+func (s *Server) ValidateSeries(database, retentionPolicy string, points []Point) (*WriteValidation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	// If the retention policy is not set, use the default for this database.
+	if retentionPolicy == "" {
+		retentionPolicy = db.defaultRetentionPolicy
+	}
+	rp := db.policies[retentionPolicy]
+	if rp == nil {
+		if retentionPolicy == "" {
+			return nil, ErrDefaultRetentionPolicyNotFound
+		}
+		return nil, ErrRetentionPolicyNotFound
+	}
+
+	v := &WriteValidation{Database: database, RetentionPolicy: rp.Name, Points: len(points)}
+	minTime, maxTime := time.Unix(0, math.MinInt64), time.Unix(0, math.MaxInt64)
+	maxFutureTime := s.maxPointTime()
+	fieldTypes := make(map[string]influxql.DataType)
+	newSeries := make(map[string]bool)
+	for i, p := range points {
+		invalid := func(err error) {
+			v.Errors = append(v.Errors, PointValidationError{Index: i, Err: err.Error()})
+		}
+
+		if len(p.Fields) == 0 {
+			invalid(ErrFieldsRequired)
+			continue
+		} else if k := nonFiniteField(p.Fields); k != "" {
+			invalid(fmt.Errorf("field \"%s\": %s", k, ErrNonFiniteFieldValue))
+			continue
+		} else if k, ok := reservedKey(&p); ok {
+			invalid(fmt.Errorf("key \"%s\": %s", k, ErrReservedKey))
+			continue
+		}
+		if p.Timestamp.Before(minTime) || p.Timestamp.After(maxTime) {
+			invalid(fmt.Errorf("timestamp out of range: %s", p.Timestamp.Format(time.RFC3339Nano)))
+			continue
+		} else if p.Timestamp.After(maxFutureTime) {
+			invalid(fmt.Errorf("timestamp %s: %s", p.Timestamp.UTC().Format(time.RFC3339Nano), ErrTimestampInFuture))
+			continue
+		} else if IsSystemMeasurement(p.Name) {
+			invalid(fmt.Errorf("measurement %s: %s", p.Name, ErrSystemMeasurement))
+			continue
+		}
+
+		// Check fields against the stored types, or the first type seen in this batch.
+		measurement, series := db.MeasurementAndSeries(p.Name, p.Tags)
+		var err error
+		for k, val := range p.Fields {
+			key := p.Name + "\x00" + k
+			if measurement != nil {
+				if f := measurement.fieldForWrite(k); f != nil {
+					if _, ok := coerceValue(val, f.Type); !ok {
+						err = fmt.Errorf("field \"%s\" is type %T, mapped as type %s", k, val, f.Type)
+						break
+					}
+					continue
+				}
+			}
+			typ := influxql.InspectDataType(val)
+			if prev, ok := fieldTypes[key]; ok && prev != typ {
+				err = fmt.Errorf("field \"%s\" is type %s, previously written in batch as type %s", k, typ, prev)
+				break
+			}
+			fieldTypes[key] = typ
+		}
+		if err != nil {
+			invalid(err)
+			continue
+		}
+
+		// Record series that would be created.
+		if series == nil {
+			if id := string(marshalTags(p.Tags)); !newSeries[p.Name+"\x00"+id] {
+				newSeries[p.Name+"\x00"+id] = true
+				v.NewSeries = append(v.NewSeries, SeriesKey{Name: p.Name, Tags: p.Tags})
+			}
+		}
+	}
+	return v, nil
+}
+
+// enforceWriteQuota returns an error if writing points would exceed the
+// database's series, disk or write rate quota.
+func (s *Server) enforceWriteQuota(database string, points []Point) error {
+	q := s.Quotas.Quota(database)
+	if q == nil {
+		return nil
+	}
+
+	if err := func() error {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
+		db := s.databases[database]
+		if db == nil {
+			return nil
+		}
+		if q.MaxDiskBytes > 0 && s.diskUsage(db) >= q.MaxDiskBytes {
+			return ErrDiskQuotaExceeded
+		}
+		if q.MaxSeries > 0 {
+			newSeries := make(map[string]bool)
+			for _, p := range points {
+				if _, series := db.MeasurementAndSeries(p.Name, p.Tags); series == nil {
+					newSeries[p.Name+"\x00"+string(marshalTags(p.Tags))] = true
+				}
+			}
+			if len(newSeries) > 0 && len(db.series)+len(newSeries) > q.MaxSeries {
+				return ErrSeriesQuotaExceeded
+			}
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+
+	return s.Quotas.reserveWrite(database, len(points))
+}
+
+// throttleWrite takes n points from the database's write limit.
+// Returns ErrWriteThrottled if the write would exceed it.
+func (s *Server) throttleWrite(database string, n int) error {
+	limit, burst, err := s.WriteLimit(database)
+	if err != nil {
+		return nil
+	}
+	return s.writeLimits.reserve(database, limit, burst, n)
+}
 
-	return maxIndex, err
+// diskUsage returns the size in bytes of the database's shards stored on this node.
+// The caller must hold the lock.
+func (s *Server) diskUsage(db *database) int64 {
+	var n int64
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			for _, sh := range g.Shards {
+				path := sh.path()
+				if path == "" {
+					continue
+				}
+				if fi, err := os.Stat(path); err == nil {
+					n += fi.Size()
+				}
+			}
+		}
+	}
+	return n
 }
 
 // applyWriteRawSeries writes raw series data to the database.
@@ -1509,7 +3152,28 @@ func (s *Server) applyWriteRawSeries(m *messaging.Message) error {
 		log.Printf("received write message for application, shard %d", sh.ID)
 	}
 
-	if err := sh.writeSeries(m.Data); err != nil {
+	// Historical writes are sorted and bulk loaded into their shard.
+	// Messages that the broker redelivers after a reconnect are skipped.
+	var err error
+	if s.isBackfillShard(sh.ID) {
+		atomic.AddUint64(&s.stats.backfillReq, 1)
+		err = sh.writeBackfill(m.Index, m.Data, s.pointMergeFunc(sh.ID))
+	} else {
+		err = sh.writeSeries(m.Index, m.Data, s.pointMergeFunc(sh.ID))
+	}
+	if err == errWriteApplied {
+		atomic.AddUint64(&s.stats.duplicateReq, 1)
+		if s.WriteTrace {
+			log.Printf("write message %d already applied to shard %d", m.Index, sh.ID)
+		}
+		return nil
+	} else if err == ErrDuplicatePointRejected {
+		atomic.AddUint64(&s.stats.rejectedReq, 1)
+		if s.WriteTrace {
+			log.Printf("write message %d applied to shard %d with duplicate points rejected", m.Index, sh.ID)
+		}
+		return err
+	} else if err != nil {
 		return err
 	}
 	if s.WriteTrace {
@@ -1519,6 +3183,92 @@ func (s *Server) applyWriteRawSeries(m *messaging.Message) error {
 	return nil
 }
 
+// pointMergeFunc returns the function used to resolve duplicate points written
+// to a shard, based on the duplicate policy of the shard's retention policy.
+// Returns nil if existing points should be overwritten.
+func (s *Server) pointMergeFunc(shardID uint64) pointMergeFunc {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db, rp := s.retentionPolicyByShardID(shardID)
+	if rp == nil {
+		return nil
+	}
+
+	switch rp.Duplicates {
+	case DuplicatePolicyReject:
+		return func(seriesID uint32, existing, data []byte) ([]byte, error) { return nil, nil }
+	case DuplicatePolicyMerge:
+		codecs := make(map[uint32]*FieldCodec)
+		return func(seriesID uint32, existing, data []byte) ([]byte, error) {
+			codec := codecs[seriesID]
+			if codec == nil {
+				s.mu.RLock()
+				if series := db.series[seriesID]; series != nil {
+					codec = NewFieldCodec(series.measurement)
+				}
+				s.mu.RUnlock()
+
+				// Overwrite the point if the series is unknown.
+				if codec == nil {
+					return data, nil
+				}
+				codecs[seriesID] = codec
+			}
+			return codec.MergeFields(existing, data)
+		}
+	default:
+		return nil
+	}
+}
+
+// isBackfillShard returns true if a shard belongs to a shard group that ended
+// longer ago than the backfill threshold.
+func (s *Server) isBackfillShard(shardID uint64) bool {
+	if s.BackfillThreshold <= 0 {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g := s.shardGroupByShardID(shardID)
+	return g != nil && g.EndTime.Before(time.Now().Add(-s.BackfillThreshold))
+}
+
+// shardGroupByShardID returns the shard group that contains a shard.
+// Returns nil if the shard is not found. Must be called with a lock.
+func (s *Server) shardGroupByShardID(shardID uint64) *ShardGroup {
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if sh.ID == shardID {
+						return g
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// retentionPolicyByShardID returns the database and retention policy that own a shard.
+// Returns nil if the shard is not found. Must be called with a lock.
+func (s *Server) retentionPolicyByShardID(shardID uint64) (*database, *RetentionPolicy) {
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if sh.ID == shardID {
+						return db, rp
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
 // createMeasurementsIfNotExists walks the "points" and ensures that all new Series are created, and all
 // new Measurement fields have been created, across the cluster.
 func (s *Server) createMeasurementsIfNotExists(database, retentionPolicy string, points []Point) error {
@@ -1544,9 +3294,9 @@ func (s *Server) createMeasurementsIfNotExists(database, retentionPolicy string,
 
 			for k, v := range p.Fields {
 				if measurement != nil {
-					if f := measurement.FieldByName(k); f != nil {
+					if f := measurement.fieldForWrite(k); f != nil {
 						// Field present in Metastore, make sure there is no type conflict.
-						if f.Type != influxql.InspectDataType(v) {
+						if _, ok := coerceValue(v, f.Type); !ok {
 							return fmt.Errorf(fmt.Sprintf("field \"%s\" is type %T, mapped as type %s", k, v, f.Type))
 						}
 						continue // Field is present, and it's of the same type. Nothing more to do.
@@ -1628,51 +3378,243 @@ func (s *Server) applyCreateMeasurementsIfNotExists(m *messaging.Message) error
 			}
 		}
 
-		return nil
-	}); err != nil {
-		return err
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) DropMeasurement(database, name string) error {
+	c := &dropMeasurementCommand{Database: database, Name: name}
+	_, err := s.broadcast(dropMeasurementMessageType, c)
+	return err
+}
+
+func (s *Server) applyDropMeasurement(m *messaging.Message) error {
+	var c dropMeasurementCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	database := s.databases[c.Database]
+	if database == nil {
+		return ErrDatabaseNotFound
+	}
+
+	measurement := database.measurements[c.Name]
+	if measurement == nil {
+		return ErrMeasurementNotFound
+	}
+
+	err := s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		// Drop metastore data
+		if err := tx.dropMeasurement(c.Database, c.Name); err != nil {
+			return err
+		}
+
+		// Drop measurement from the database.
+		if err := database.dropMeasurement(c.Name); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateField changes the data type of a field on a measurement. Each data node
+// storing the measurement converts its existing values in the background and
+// values that cannot be converted are removed. New values are written as the
+// new type straight away but the field keeps its type until every data node
+// has converted its values. Progress is available from FieldConversions.
+func (s *Server) UpdateField(database, measurement, name string, typ influxql.DataType) error {
+	c := &updateFieldCommand{Database: database, Measurement: measurement, Name: name, Type: typ}
+	_, err := s.broadcast(updateFieldMessageType, c)
+	return err
+}
+
+func (s *Server) applyUpdateField(m *messaging.Message) error {
+	var c updateFieldCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	mm := db.measurements[c.Measurement]
+	if mm == nil {
+		return ErrMeasurementNotFound
+	}
+
+	// Values are converted by every data node storing shards of the database.
+	var nodeIDs []uint64
+	seen := make(map[uint64]bool)
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			for _, sh := range g.Shards {
+				for _, id := range sh.DataNodeIDs {
+					if !seen[id] {
+						seen[id] = true
+						nodeIDs = append(nodeIDs, id)
+					}
+				}
+			}
+		}
+	}
+	sort.Sort(uint64Slice(nodeIDs))
+
+	if err := s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		if err := mm.startFieldConversion(c.Name, c.Type, nodeIDs); err != nil {
+			return err
+		}
+		return tx.saveMeasurement(db.name, mm)
+	}); err != nil {
+		return err
+	}
+
+	// Convert the values stored on this server in the background.
+	if seen[s.id] {
+		s.startFieldConversion(db.name, mm.Name, c.Name)
+	}
+	return nil
+}
+
+// applyFieldConverted records that a data node has finished converting its
+// values of a field and replaces the field once every data node has.
+func (s *Server) applyFieldConverted(m *messaging.Message) error {
+	var c fieldConvertedCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	mm := db.measurements[c.Measurement]
+	if mm == nil {
+		return ErrMeasurementNotFound
+	}
+
+	return s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		if err := mm.fieldConverted(c.Name, c.NodeID, c.Failed); err != nil {
+			return err
+		}
+		return tx.saveMeasurement(db.name, mm)
+	})
+}
+
+// SetMeasurementTTL sets the length of time points in a measurement are kept.
+// A TTL only takes effect if it is shorter than the retention policy the
+// points are written to. A zero TTL removes it.
+func (s *Server) SetMeasurementTTL(database, measurement string, ttl time.Duration) error {
+	c := &setMeasurementTTLCommand{Database: database, Measurement: measurement, TTL: ttl}
+	_, err := s.broadcast(setMeasurementTTLMessageType, c)
+	return err
+}
+
+func (s *Server) applySetMeasurementTTL(m *messaging.Message) error {
+	var c setMeasurementTTLCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	mm := db.measurements[c.Measurement]
+	if mm == nil {
+		return ErrMeasurementNotFound
+	} else if c.TTL < 0 {
+		return ErrInvalidMeasurementTTL
 	}
 
-	return nil
+	return s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		mm.TTL = c.TTL
+		return tx.saveMeasurement(db.name, mm)
+	})
 }
 
-func (s *Server) DropMeasurement(database, name string) error {
-	c := &dropMeasurementCommand{Database: database, Name: name}
-	_, err := s.broadcast(dropMeasurementMessageType, c)
+// MetadataUpdate represents descriptive metadata to change on a measurement
+// or field. Nil values are left unchanged.
+type MetadataUpdate struct {
+	Unit        *string `json:"unit,omitempty"`
+	Description *string `json:"description,omitempty"`
+	DisplayName *string `json:"displayName,omitempty"`
+}
+
+// newMetadataUpdate returns a metadata update from InfluxQL metadata keys.
+func newMetadataUpdate(m map[string]string) *MetadataUpdate {
+	u := &MetadataUpdate{}
+	for k, v := range m {
+		v := v
+		switch k {
+		case influxql.MetadataUnit:
+			u.Unit = &v
+		case influxql.MetadataDescription:
+			u.Description = &v
+		case influxql.MetadataDisplayName:
+			u.DisplayName = &v
+		}
+	}
+	return u
+}
+
+// UpdateMetadata changes the unit, description or display name of a
+// measurement. The field's metadata is changed instead if field is not blank.
+func (s *Server) UpdateMetadata(database, measurement, field string, mu *MetadataUpdate) error {
+	c := &updateMetadataCommand{
+		Database:    database,
+		Measurement: measurement,
+		Field:       field,
+		Unit:        mu.Unit,
+		Description: mu.Description,
+		DisplayName: mu.DisplayName,
+	}
+	_, err := s.broadcast(updateMetadataMessageType, c)
 	return err
 }
 
-func (s *Server) applyDropMeasurement(m *messaging.Message) error {
-	var c dropMeasurementCommand
+func (s *Server) applyUpdateMetadata(m *messaging.Message) error {
+	var c updateMetadataCommand
 	mustUnmarshalJSON(m.Data, &c)
 
-	database := s.databases[c.Database]
-	if database == nil {
+	db := s.databases[c.Database]
+	if db == nil {
 		return ErrDatabaseNotFound
 	}
-
-	measurement := database.measurements[c.Name]
-	if measurement == nil {
+	mm := db.measurements[c.Measurement]
+	if mm == nil {
 		return ErrMeasurementNotFound
 	}
 
-	err := s.meta.mustUpdate(m.Index, func(tx *metatx) error {
-		// Drop metastore data
-		if err := tx.dropMeasurement(c.Database, c.Name); err != nil {
-			return err
+	// Find the fields to set.
+	unit, description, displayName := &mm.Unit, &mm.Description, &mm.DisplayName
+	if c.Field != "" {
+		f := mm.FieldByName(c.Field)
+		if f == nil {
+			return ErrFieldNotFound
 		}
 
-		// Drop measurement from the database.
-		if err := database.dropMeasurement(c.Name); err != nil {
-			return err
-		}
-		return nil
-	})
-	if err != nil {
-		return err
+		// The field is replaced rather than modified so that codecs in use by
+		// queries are unaffected.
+		other := *f
+		mm.Fields[f.ID-1] = &other
+		unit, description, displayName = &other.Unit, &other.Description, &other.DisplayName
 	}
 
-	return nil
+	return s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		if c.Unit != nil {
+			*unit = *c.Unit
+		}
+		if c.Description != nil {
+			*description = *c.Description
+		}
+		if c.DisplayName != nil {
+			*displayName = *c.DisplayName
+		}
+		return tx.saveMeasurement(db.name, mm)
+	})
 }
 
 // createShardGroupsIfNotExist walks the "points" and ensures that all required shards exist on the cluster.
@@ -1763,10 +3705,65 @@ func (s *Server) ReadSeries(database, retentionPolicy, name string, tags map[str
 	return values, nil
 }
 
+// QueryOptions controls how a query is executed.
+type QueryOptions struct {
+	// Set the execution statistics of each statement that was executed.
+	Stats bool
+
+	// Maximum time the query can run for. Bounded by the server's
+	// MaxQueryTimeout. Unlimited if both are zero.
+	Timeout time.Duration
+
+	// Closed when the result is no longer wanted, such as when the client
+	// disconnects, to stop the query early. Optional.
+	Closing <-chan struct{}
+}
+
 // ExecuteQuery executes an InfluxQL query against the server.
 // Returns a resultset for each statement in the query.
 // Stops on first execution error that occurs.
 func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User) Results {
+	return s.ExecuteQueryWithOptions(q, database, user, QueryOptions{})
+}
+
+// ExecuteQueryWithStats executes a query the same as ExecuteQuery and sets
+// the execution statistics of each statement that was executed.
+func (s *Server) ExecuteQueryWithStats(q *influxql.Query, database string, user *User) Results {
+	return s.ExecuteQueryWithOptions(q, database, user, QueryOptions{Stats: true})
+}
+
+// ExecuteQueryWithOptions executes a query the same as ExecuteQuery with the
+// given options. A query that times out returns the results of the statements
+// that completed, and the rows read so far by the running statement, with
+// ErrQueryTimeout. A query stopped by closing returns influxql.ErrQueryInterrupted.
+func (s *Server) ExecuteQueryWithOptions(q *influxql.Query, database string, user *User, opt QueryOptions) Results {
+	atomic.AddUint64(&s.stats.queryReq, 1)
+	results := s.executeQuery(q, database, user, opt)
+	if results.Error() != nil {
+		atomic.AddUint64(&s.stats.queryErrors, 1)
+	}
+	return results
+}
+
+// queryTimeout returns the time a query with the requested timeout can run for.
+func (s *Server) queryTimeout(d time.Duration) time.Duration {
+	if max := s.MaxQueryTimeout; max > 0 && (d <= 0 || d > max) {
+		return max
+	}
+	return d
+}
+
+// isClosed returns true if ch is closed. A nil channel is never closed.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) executeQuery(q *influxql.Query, database string, user *User, opt QueryOptions) Results {
 	// Authorize user to execute the query.
 	if s.authenticationEnabled {
 		if err := s.Authorize(user, q, database); err != nil {
@@ -1774,23 +3771,77 @@ func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User) Re
 		}
 	}
 
+	// Limit the number of queries running against the database at once.
+	if err := s.Quotas.acquireQuery(database); err != nil {
+		return Results{Err: err}
+	}
+	defer s.Quotas.releaseQuery(database)
+	s.Usage.recordQuery(database, user)
+
 	// Build empty resultsets.
 	results := Results{Results: make([]*Result, len(q.Statements))}
 
+	// Interrupt running select statements once the query times out or
+	// is no longer wanted.
+	var interrupt chan struct{}
+	if timeout := s.queryTimeout(opt.Timeout); timeout > 0 || opt.Closing != nil {
+		interrupt = make(chan struct{})
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			var expired <-chan time.Time
+			if timeout > 0 {
+				t := time.NewTimer(timeout)
+				defer t.Stop()
+				expired = t.C
+			}
+			select {
+			case <-expired:
+			case <-opt.Closing:
+			case <-done:
+				return
+			}
+			close(interrupt)
+		}()
+	}
+
+	// interruptErr returns the reason the query was interrupted.
+	interruptErr := func() error {
+		if isClosed(opt.Closing) {
+			return influxql.ErrQueryInterrupted
+		}
+		return ErrQueryTimeout
+	}
+
 	// Execute each statement.
 	for i, stmt := range q.Statements {
+		// Statements aren't started once the query has been interrupted.
+		if isClosed(interrupt) || isClosed(opt.Closing) {
+			results.Results[i] = &Result{Err: interruptErr()}
+			break
+		}
+
+		// Only statements that don't modify anything may run while read-only.
+		if s.ReadOnly() && !isReadOnlyStatement(stmt) {
+			results.Results[i] = &Result{Err: ErrReadOnly}
+			break
+		}
+
 		// Set default database and policy on the statement.
 		if err := s.NormalizeStatement(stmt, database); err != nil {
 			results.Results[i] = &Result{Err: err}
 			break
 		}
 
+		start := time.Now()
 		var res *Result
 		switch stmt := stmt.(type) {
 		case *influxql.SelectStatement:
-			res = s.executeSelectStatement(stmt, database, user)
+			res = s.executeSelectStatement(stmt, database, user, interrupt)
 		case *influxql.CreateDatabaseStatement:
 			res = s.executeCreateDatabaseStatement(stmt, user)
+		case *influxql.CloneDatabaseStatement:
+			res = s.executeCloneDatabaseStatement(stmt, user)
 		case *influxql.DropDatabaseStatement:
 			res = s.executeDropDatabaseStatement(stmt, user)
 		case *influxql.ShowDatabasesStatement:
@@ -1801,6 +3852,12 @@ func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User) Re
 			res = s.executeDropUserStatement(stmt, user)
 		case *influxql.ShowUsersStatement:
 			res = s.executeShowUsersStatement(stmt, user)
+		case *influxql.CreateTokenStatement:
+			res = s.executeCreateTokenStatement(stmt, user)
+		case *influxql.DropTokenStatement:
+			res = s.executeDropTokenStatement(stmt, user)
+		case *influxql.ShowTokensStatement:
+			res = s.executeShowTokensStatement(stmt, user)
 		case *influxql.DropSeriesStatement:
 			res = s.executeDropSeriesStatement(stmt, database, user)
 		case *influxql.ShowSeriesStatement:
@@ -1821,8 +3878,14 @@ func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User) Re
 			res = s.executeRevokeStatement(stmt, user)
 		case *influxql.CreateRetentionPolicyStatement:
 			res = s.executeCreateRetentionPolicyStatement(stmt, user)
+		case *influxql.AlterDatabaseStatement:
+			res = s.executeAlterDatabaseStatement(stmt, user)
 		case *influxql.AlterRetentionPolicyStatement:
 			res = s.executeAlterRetentionPolicyStatement(stmt, user)
+		case *influxql.AlterFieldStatement:
+			res = s.executeAlterFieldStatement(stmt, database, user)
+		case *influxql.AlterMeasurementStatement:
+			res = s.executeAlterMeasurementStatement(stmt, database, user)
 		case *influxql.DropRetentionPolicyStatement:
 			res = s.executeDropRetentionPolicyStatement(stmt, user)
 		case *influxql.ShowRetentionPoliciesStatement:
@@ -1833,10 +3896,41 @@ func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User) Re
 			continue
 		case *influxql.ShowContinuousQueriesStatement:
 			res = s.executeShowContinuousQueriesStatement(stmt, database, user)
+		case *influxql.CreateQueryStatement:
+			res = s.executeCreateQueryStatement(stmt, database, user)
+		case *influxql.DropQueryStatement:
+			res = s.executeDropQueryStatement(stmt, database, user)
+		case *influxql.ShowQueriesStatement:
+			res = s.executeShowQueriesStatement(stmt, database, user)
+		case *influxql.ShowQuotasStatement:
+			res = s.executeShowQuotasStatement(stmt, user)
+		case *influxql.ShowShardsStatement:
+			res = s.executeShowShardsStatement(stmt, user)
+		case *influxql.ShowDataNodesStatement:
+			res = s.executeShowDataNodesStatement(stmt, user)
+		case *influxql.AlterDataNodeStatement:
+			res = s.executeAlterDataNodeStatement(stmt, user)
+		case *influxql.ShowShardGroupsStatement:
+			res = s.executeShowShardGroupsStatement(stmt, user)
+		case *influxql.DropShardStatement:
+			res = &Result{Err: s.DropShard(stmt.ID)}
+		case *influxql.TruncateShardsStatement:
+			res = &Result{Err: s.TruncateShardGroups(stmt.Time)}
+		case *influxql.ShowUsageStatement:
+			res = s.executeShowUsageStatement(stmt, user)
+		case *influxql.ResetUsageStatement:
+			res = s.executeResetUsageStatement(stmt, user)
 		default:
 			panic(fmt.Sprintf("unsupported statement type: %T", stmt))
 		}
 
+		if res.Err == influxql.ErrQueryInterrupted {
+			res.Err = interruptErr()
+		}
+		if opt.Stats {
+			res.Stats = newStatementStats(res, time.Since(start))
+		}
+
 		// If an error occurs then stop processing remaining statements.
 		results.Results[i] = res
 		if res.Err != nil {
@@ -1854,20 +3948,79 @@ func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User) Re
 	return results
 }
 
+// isReadOnlyStatement returns true if stmt cannot modify data or metadata.
+func isReadOnlyStatement(stmt influxql.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *influxql.SelectStatement:
+		return stmt.Target == nil
+	case *influxql.ShowDatabasesStatement,
+		*influxql.ShowUsersStatement,
+		*influxql.ShowTokensStatement,
+		*influxql.ShowSeriesStatement,
+		*influxql.ShowMeasurementsStatement,
+		*influxql.ShowTagKeysStatement,
+		*influxql.ShowTagValuesStatement,
+		*influxql.ShowFieldKeysStatement,
+		*influxql.ShowRetentionPoliciesStatement,
+		*influxql.ShowContinuousQueriesStatement,
+		*influxql.ShowQueriesStatement,
+		*influxql.ShowQuotasStatement,
+		*influxql.ShowShardsStatement,
+		*influxql.ShowShardGroupsStatement,
+		*influxql.ShowDataNodesStatement,
+		*influxql.ShowUsageStatement:
+		return true
+	}
+	// RESET USAGE clears the usage counters so it is treated as a write.
+	return false
+}
+
 // executeSelectStatement plans and executes a select statement against a database.
-func (s *Server) executeSelectStatement(stmt *influxql.SelectStatement, database string, user *User) *Result {
+// The statement stops early with influxql.ErrQueryInterrupted if interrupt is closed.
+func (s *Server) executeSelectStatement(stmt *influxql.SelectStatement, database string, user *User, interrupt <-chan struct{}) *Result {
+	// Parameters are only bound when running a stored query.
+	if names := influxql.BoundParameters(stmt); len(names) > 0 {
+		return &Result{Err: fmt.Errorf("missing value for parameter $%s", names[0])}
+	}
+
+	// System measurements are generated from the metadata of the database.
+	if m, ok := stmt.Source.(*influxql.Measurement); ok {
+		if segments, err := influxql.SplitIdent(m.Name); err == nil && len(segments) == 3 && IsSystemMeasurement(segments[2]) {
+			return s.executeSystemSelectStatement(stmt, segments[0], segments[2])
+		}
+	}
+
 	// Perform any necessary query re-writing.
 	stmt, err := s.rewriteSelectStatement(stmt)
 	if err != nil {
 		return &Result{Err: err}
 	}
 
+	// Read from a rollup of the measurement if one can answer the statement.
+	// Rows keep the name of the measurement that was queried.
+	var name string
+	if other := s.rollupSelectStatement(stmt); other != nil {
+		if segments, err := influxql.SplitIdent(stmt.Source.(*influxql.Measurement).Name); err == nil {
+			name = segments[2]
+		}
+		stmt = other
+	}
+
 	// Plan statement execution.
-	e, err := s.planSelectStatement(stmt)
+	var scanned uint64
+	e, err := s.planSelectStatement(stmt, &scanned, interrupt)
 	if err != nil {
 		return &Result{Err: err}
 	}
 
+	// Meter the points read by the statement against its source database.
+	if m, ok := stmt.Source.(*influxql.Measurement); ok {
+		if segments, err := influxql.SplitIdent(m.Name); err == nil {
+			database = segments[0]
+		}
+	}
+	defer func() { s.Usage.recordScan(database, user, atomic.LoadUint64(&scanned)) }()
+
 	// Execute plan.
 	ch, err := e.Execute()
 	if err != nil {
@@ -1877,8 +4030,16 @@ func (s *Server) executeSelectStatement(stmt *influxql.SelectStatement, database
 	// Read all rows from channel.
 	res := &Result{Series: make([]*influxql.Row, 0)}
 	for row := range ch {
+		if row.Err != nil {
+			// Keep any rows sent before the error, such as those read before an interrupt.
+			return &Result{Series: res.Series, Err: row.Err, scanned: atomic.LoadUint64(&scanned)}
+		}
+		if name != "" {
+			row.Name = name
+		}
 		res.Series = append(res.Series, row)
 	}
+	res.scanned = atomic.LoadUint64(&scanned)
 
 	return res
 }
@@ -1892,7 +4053,7 @@ func (s *Server) rewriteSelectStatement(stmt *influxql.SelectStatement) (*influx
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var fields influxql.Fields
+	var fields, numeric influxql.Fields
 	var dimensions influxql.Dimensions
 	if measurement, ok := stmt.Source.(*influxql.Measurement); ok {
 		segments, err := influxql.SplitIdent(measurement.Name)
@@ -1907,33 +4068,81 @@ func (s *Server) rewriteSelectStatement(stmt *influxql.SelectStatement) (*influx
 		}
 
 		for _, f := range mm.Fields {
+			if f.Hidden {
+				continue
+			}
 			fields = append(fields, &influxql.Field{Expr: &influxql.VarRef{Val: f.Name}})
+			if f.Type == influxql.Number || f.Type == influxql.Unsigned || f.Type == influxql.Duration {
+				numeric = append(numeric, fields[len(fields)-1])
+			}
 		}
 		for _, t := range mm.tagKeys() {
 			dimensions = append(dimensions, &influxql.Dimension{Expr: &influxql.VarRef{Val: t}})
 		}
 	}
 
-	return stmt.RewriteWildcards(fields, dimensions), nil
+	// Tags are grouped by rather than selected so at least one field is required.
+	other := stmt.RewriteWildcards(fields, dimensions, numeric)
+	if len(other.Fields) == 0 {
+		return nil, fmt.Errorf("at least one field is required: %s", stmt)
+	}
+	return other, nil
 }
 
-// plans a selection statement under lock.
-func (s *Server) planSelectStatement(stmt *influxql.SelectStatement) (*influxql.Executor, error) {
+// plans a selection statement under lock. If scanned is set then it is
+// incremented for every point read from the shards. The statement is stopped
+// early if interrupt is closed.
+func (s *Server) planSelectStatement(stmt *influxql.SelectStatement, scanned *uint64, interrupt <-chan struct{}) (*influxql.Executor, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	// Plan query.
-	p := influxql.NewPlanner(s)
+	p := influxql.NewPlanner(&scanCountingDB{server: s, scanned: scanned})
+	p.MaxQueryMemory = s.MaxQueryMemory
+	p.MemoryPool = s.QueryMemory
+	p.SpillDir = s.QuerySpillDir
+	p.Interrupt = interrupt
 
 	return p.Plan(stmt)
 }
 
 func (s *Server) executeCreateDatabaseStatement(q *influxql.CreateDatabaseStatement, user *User) *Result {
-	return &Result{Err: s.CreateDatabase(q.Name)}
+	// Build the default retention policy from the statement's options, if any.
+	var rp *RetentionPolicy
+	if q.RetentionPolicyCreate {
+		rp = NewRetentionPolicy(DefaultRetentionPolicyName)
+		if q.RetentionPolicyName != "" {
+			rp.Name = q.RetentionPolicyName
+		}
+		if q.RetentionPolicyDuration != nil {
+			rp.Duration = *q.RetentionPolicyDuration
+		}
+		if q.RetentionPolicyReplication != nil {
+			rp.ReplicaN = uint32(*q.RetentionPolicyReplication)
+		}
+	}
+
+	err := s.CreateDatabaseWithRetentionPolicy(q.Name, rp)
+	if err == ErrDatabaseExists && q.IfNotExists {
+		err = nil
+	}
+	return &Result{Err: err}
+}
+
+func (s *Server) executeCloneDatabaseStatement(q *influxql.CloneDatabaseStatement, user *User) *Result {
+	err := s.CloneDatabase(q.Source, q.Name, q.Data)
+	if err == ErrDatabaseExists && q.IfNotExists {
+		err = nil
+	}
+	return &Result{Err: err}
 }
 
 func (s *Server) executeDropDatabaseStatement(q *influxql.DropDatabaseStatement, user *User) *Result {
-	return &Result{Err: s.DropDatabase(q.Name)}
+	err := s.DropDatabase(q.Name)
+	if err == ErrDatabaseNotFound && q.IfExists {
+		err = nil
+	}
+	return &Result{Err: err}
 }
 
 func (s *Server) executeShowDatabasesStatement(q *influxql.ShowDatabasesStatement, user *User) *Result {
@@ -1949,11 +4158,19 @@ func (s *Server) executeCreateUserStatement(q *influxql.CreateUserStatement, use
 	if q.Privilege != nil {
 		isAdmin = *q.Privilege == influxql.AllPrivileges
 	}
-	return &Result{Err: s.CreateUser(q.Name, q.Password, isAdmin)}
+	err := s.CreateUser(q.Name, q.Password, isAdmin)
+	if err == ErrUserExists && q.IfNotExists {
+		err = nil
+	}
+	return &Result{Err: err}
 }
 
 func (s *Server) executeDropUserStatement(q *influxql.DropUserStatement, user *User) *Result {
-	return &Result{Err: s.DeleteUser(q.Name)}
+	err := s.DeleteUser(q.Name)
+	if err == ErrUserNotFound && q.IfExists {
+		err = nil
+	}
+	return &Result{Err: err}
 }
 
 func (s *Server) executeDropMeasurementStatement(stmt *influxql.DropMeasurementStatement, database string, user *User) *Result {
@@ -2142,6 +4359,11 @@ func (s *Server) executeShowMeasurementsStatement(stmt *influxql.ShowMeasurement
 	}
 	sort.Sort(measurements)
 
+	// Keep only the measurements matching WITH MEASUREMENT.
+	if stmt.Measurement != nil {
+		measurements = measurements.filterByName(stmt.MeasurementOp, stmt.Measurement)
+	}
+
 	offset := stmt.Offset
 	limit := stmt.Limit
 
@@ -2275,41 +4497,218 @@ func (s *Server) executeShowTagValuesStatement(stmt *influxql.ShowTagValuesState
 			if !ok {
 				tagValues[k] = v
 			}
-			tagValues[k] = tagValues[k].union(v)
+			tagValues[k] = tagValues[k].union(v)
+		}
+	}
+
+	for k, v := range tagValues {
+		r := &influxql.Row{
+			Name:    k + "TagValues",
+			Columns: []string{k},
+		}
+
+		vals := v.list()
+		sort.Strings(vals)
+
+		for _, val := range vals {
+			v := interface{}(val)
+			r.Values = append(r.Values, []interface{}{v})
+		}
+
+		result.Series = append(result.Series, r)
+	}
+
+	sort.Sort(result.Series)
+	return result
+}
+
+func (s *Server) executeShowContinuousQueriesStatement(stmt *influxql.ShowContinuousQueriesStatement, database string, user *User) *Result {
+	rows := []*influxql.Row{}
+	for _, name := range s.Databases() {
+		row := &influxql.Row{Columns: []string{"name", "query"}, Name: name}
+		for _, cq := range s.ContinuousQueries(name) {
+			row.Values = append(row.Values, []interface{}{cq.cq.Name, cq.Query})
+		}
+		rows = append(rows, row)
+	}
+	return &Result{Series: rows}
+}
+
+func (s *Server) executeCreateQueryStatement(stmt *influxql.CreateQueryStatement, database string, user *User) *Result {
+	if stmt.Database != "" {
+		database = stmt.Database
+	}
+	return &Result{Err: s.CreateStoredQuery(database, stmt.Name, stmt.Source.String())}
+}
+
+func (s *Server) executeDropQueryStatement(stmt *influxql.DropQueryStatement, database string, user *User) *Result {
+	if stmt.Database != "" {
+		database = stmt.Database
+	}
+	return &Result{Err: s.DropStoredQuery(database, stmt.Name)}
+}
+
+func (s *Server) executeShowQueriesStatement(stmt *influxql.ShowQueriesStatement, database string, user *User) *Result {
+	queries, err := s.StoredQueries(database)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	row := &influxql.Row{Name: database, Columns: []string{"name", "query", "parameters"}}
+	for _, q := range queries {
+		row.Values = append(row.Values, []interface{}{q.Name, q.Query, q.Parameters()})
+	}
+	return &Result{Series: []*influxql.Row{row}}
+}
+
+func (s *Server) executeShowQuotasStatement(stmt *influxql.ShowQuotasStatement, user *User) *Result {
+	row := &influxql.Row{
+		Name: "quotas",
+		Columns: []string{"database",
+			"max_series", "series",
+			"max_write_rate", "write_rate",
+			"max_concurrent_queries", "queries",
+			"max_disk_bytes", "disk_bytes",
+		},
+	}
+	for _, q := range s.Quotas.Quotas() {
+		var seriesN int
+		var diskBytes int64
+		s.mu.RLock()
+		if db := s.databases[q.Database]; db != nil {
+			seriesN, diskBytes = len(db.series), s.diskUsage(db)
+		}
+		s.mu.RUnlock()
+
+		row.Values = append(row.Values, []interface{}{q.Database,
+			q.MaxSeries, seriesN,
+			q.MaxWriteRate, s.Quotas.writeRate(q.Database),
+			q.MaxConcurrentQueries, s.Quotas.runningQueries(q.Database),
+			q.MaxDiskBytes, diskBytes,
+		})
+	}
+	return &Result{Series: []*influxql.Row{row}}
+}
+
+func (s *Server) executeShowShardsStatement(stmt *influxql.ShowShardsStatement, user *User) *Result {
+	a, err := s.ShardStats("")
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	row := &influxql.Row{
+		Name: "shards",
+		Columns: []string{"id", "database", "retention_policy", "shard_group", "start_time", "end_time",
+			"series", "disk_bytes", "unused_bytes", "compaction_pending", "offloaded", "last_write",
+		},
+	}
+	for _, st := range a {
+		row.Values = append(row.Values, []interface{}{st.ID, st.Database, st.RetentionPolicy, st.ShardGroupID, st.StartTime, st.EndTime,
+			st.SeriesN, st.DiskBytes, st.UnusedBytes, st.CompactionPending, st.Offloaded, st.LastWrite,
+		})
+	}
+	return &Result{Series: []*influxql.Row{row}}
+}
+
+func (s *Server) executeShowDataNodesStatement(stmt *influxql.ShowDataNodesStatement, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Count the shards stored on each node.
+	shardN := make(map[uint64]int)
+	for _, sh := range s.shards {
+		for _, id := range sh.DataNodeIDs {
+			shardN[id]++
+		}
+	}
+
+	nodes := make([]*DataNode, 0, len(s.dataNodes))
+	for _, n := range s.dataNodes {
+		nodes = append(nodes, n)
+	}
+	sort.Sort(dataNodes(nodes))
+
+	// Each label key is a column, after the status columns.
+	row := &influxql.Row{
+		Name:    "data_nodes",
+		Columns: []string{"id", "url", "status", "last_heartbeat", "shard_count", "disk_free"},
+	}
+	keys := make(map[string]string)
+	for _, n := range nodes {
+		for k := range n.Labels {
+			keys[k] = ""
+		}
+	}
+	labels := sortedKeys(keys)
+	row.Columns = append(row.Columns, labels...)
+
+	now := time.Now()
+	for _, n := range nodes {
+		var heartbeat interface{}
+		if !n.LastHeartbeat.IsZero() {
+			heartbeat = n.LastHeartbeat
+		}
+		values := []interface{}{n.ID, n.URL.String(), n.Status(now), heartbeat, shardN[n.ID], n.DiskFree}
+		for _, k := range labels {
+			if v, ok := n.Labels[k]; ok {
+				values = append(values, v)
+			} else {
+				values = append(values, nil)
+			}
+		}
+		row.Values = append(row.Values, values)
+	}
+	return &Result{Series: []*influxql.Row{row}}
+}
+
+func (s *Server) executeAlterDataNodeStatement(stmt *influxql.AlterDataNodeStatement, user *User) *Result {
+	return &Result{Err: s.SetDataNodeLabels(stmt.ID, stmt.Labels)}
+}
+
+func (s *Server) executeShowShardGroupsStatement(stmt *influxql.ShowShardGroupsStatement, user *User) *Result {
+	row := &influxql.Row{
+		Name:    "shard groups",
+		Columns: []string{"id", "database", "retention_policy", "start_time", "end_time", "shards"},
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				ids := make([]uint64, 0, len(g.Shards))
+				for _, sh := range g.Shards {
+					ids = append(ids, sh.ID)
+				}
+				row.Values = append(row.Values, []interface{}{g.ID, db.name, rp.Name, g.StartTime, g.EndTime, ids})
+			}
 		}
 	}
+	sort.Sort(rowValuesByID(row.Values))
+	return &Result{Series: []*influxql.Row{row}}
+}
 
-	for k, v := range tagValues {
-		r := &influxql.Row{
-			Name:    k + "TagValues",
-			Columns: []string{k},
-		}
-
-		vals := v.list()
-		sort.Strings(vals)
+// rowValuesByID sorts result values by the id in their first column.
+type rowValuesByID [][]interface{}
 
-		for _, val := range vals {
-			v := interface{}(val)
-			r.Values = append(r.Values, []interface{}{v})
-		}
+func (a rowValuesByID) Len() int           { return len(a) }
+func (a rowValuesByID) Less(i, j int) bool { return a[i][0].(uint64) < a[j][0].(uint64) }
+func (a rowValuesByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
-		result.Series = append(result.Series, r)
+func (s *Server) executeShowUsageStatement(stmt *influxql.ShowUsageStatement, user *User) *Result {
+	row := &influxql.Row{
+		Name:    "usage",
+		Columns: []string{"database", "user", "bytes_written", "points_written", "queries", "points_scanned"},
 	}
-
-	sort.Sort(result.Series)
-	return result
+	for _, u := range s.Usage.Usage() {
+		row.Values = append(row.Values, []interface{}{u.Database, u.User, u.BytesWritten, u.PointsWritten, u.Queries, u.PointsScanned})
+	}
+	return &Result{Series: []*influxql.Row{row}}
 }
 
-func (s *Server) executeShowContinuousQueriesStatement(stmt *influxql.ShowContinuousQueriesStatement, database string, user *User) *Result {
-	rows := []*influxql.Row{}
-	for _, name := range s.Databases() {
-		row := &influxql.Row{Columns: []string{"name", "query"}, Name: name}
-		for _, cq := range s.ContinuousQueries(name) {
-			row.Values = append(row.Values, []interface{}{cq.cq.Name, cq.Query})
-		}
-		rows = append(rows, row)
-	}
-	return &Result{Series: rows}
+func (s *Server) executeResetUsageStatement(stmt *influxql.ResetUsageStatement, user *User) *Result {
+	s.Usage.Reset()
+	return &Result{}
 }
 
 // filterMeasurementsByExpr filters a list of measurements by a tags expression.
@@ -2359,20 +4758,23 @@ func (s *Server) executeShowFieldKeysStatement(stmt *influxql.ShowFieldKeysState
 		// Create a new row.
 		r := &influxql.Row{
 			Name:    m.Name,
-			Columns: []string{"fieldKey"},
+			Columns: []string{"fieldKey", "fieldType", "unit", "description", "displayName"},
 		}
 
 		// Get a list of field names from the measurement then sort them.
 		names := make([]string, 0, len(m.Fields))
 		for _, f := range m.Fields {
+			if f.Hidden {
+				continue
+			}
 			names = append(names, f.Name)
 		}
 		sort.Strings(names)
 
-		// Add the field names to the result row values.
+		// Add the field names, types and metadata to the result row values.
 		for _, n := range names {
-			v := interface{}(n)
-			r.Values = append(r.Values, []interface{}{v})
+			f := m.FieldByName(n)
+			r.Values = append(r.Values, []interface{}{n, string(f.Type), f.Unit, f.Description, f.DisplayName})
 		}
 
 		// Append the row to the result.
@@ -2382,8 +4784,40 @@ func (s *Server) executeShowFieldKeysStatement(stmt *influxql.ShowFieldKeysState
 	return result
 }
 
+func (s *Server) executeAlterFieldStatement(stmt *influxql.AlterFieldStatement, database string, user *User) *Result {
+	if stmt.Type != "" {
+		if err := s.UpdateField(database, stmt.Measurement, stmt.Name, stmt.Type); err != nil {
+			return &Result{Err: err}
+		}
+	}
+	if stmt.Metadata != nil {
+		if err := s.UpdateMetadata(database, stmt.Measurement, stmt.Name, newMetadataUpdate(stmt.Metadata)); err != nil {
+			return &Result{Err: err}
+		}
+	}
+	return &Result{}
+}
+
+func (s *Server) executeAlterMeasurementStatement(stmt *influxql.AlterMeasurementStatement, database string, user *User) *Result {
+	if stmt.TTL != nil {
+		if err := s.SetMeasurementTTL(database, stmt.Name, *stmt.TTL); err != nil {
+			return &Result{Err: err}
+		}
+	}
+	if stmt.Metadata != nil {
+		if err := s.UpdateMetadata(database, stmt.Name, "", newMetadataUpdate(stmt.Metadata)); err != nil {
+			return &Result{Err: err}
+		}
+	}
+	return &Result{}
+}
+
 func (s *Server) executeGrantStatement(stmt *influxql.GrantStatement, user *User) *Result {
-	return &Result{Err: s.SetPrivilege(stmt.Privilege, stmt.User, stmt.On)}
+	var f *MeasurementFilter
+	if len(stmt.Measurements) > 0 {
+		f = &MeasurementFilter{Names: stmt.Measurements, Except: stmt.Except}
+	}
+	return &Result{Err: s.SetPrivilegeWithFilter(stmt.Privilege, stmt.User, stmt.On, f)}
 }
 
 func (s *Server) executeRevokeStatement(stmt *influxql.RevokeStatement, user *User) *Result {
@@ -2441,10 +4875,13 @@ func (s *Server) executeCreateRetentionPolicyStatement(q *influxql.CreateRetenti
 	rp := NewRetentionPolicy(q.Name)
 	rp.Duration = q.Duration
 	rp.ReplicaN = uint32(q.Replication)
+	rp.Duplicates = q.Duplicates
 
 	// Create new retention policy.
-	err := s.CreateRetentionPolicy(q.Database, rp)
-	if err != nil {
+	err := s.createRetentionPolicy(q.Database, rp, q.Force)
+	if err == ErrRetentionPolicyExists && q.IfNotExists {
+		return &Result{}
+	} else if err != nil {
 		return &Result{Err: err}
 	}
 
@@ -2467,6 +4904,9 @@ func (s *Server) executeAlterRetentionPolicyStatement(stmt *influxql.AlterRetent
 				return &n
 			}
 		}(),
+		Duplicates: stmt.Duplicates,
+		Name:       stmt.NewName,
+		Force:      stmt.Force,
 	}
 
 	// Update the retention policy.
@@ -2475,16 +4915,50 @@ func (s *Server) executeAlterRetentionPolicyStatement(stmt *influxql.AlterRetent
 		return &Result{Err: err}
 	}
 
+	name := stmt.Name
+	if stmt.NewName != nil {
+		name = *stmt.NewName
+	}
+
+	// Remove shard groups that a shorter duration has already aged out
+	// instead of waiting for the next enforcement check.
+	if stmt.Duration != nil {
+		if err := s.enforceRetentionPolicy(stmt.Database, name); err != nil {
+			return &Result{Err: err}
+		}
+	}
+
 	// If requested, set as default retention policy.
 	if stmt.Default {
-		err = s.SetDefaultRetentionPolicy(stmt.Database, stmt.Name)
+		err = s.SetDefaultRetentionPolicy(stmt.Database, name)
 	}
 
 	return &Result{Err: err}
 }
 
+func (s *Server) executeAlterDatabaseStatement(q *influxql.AlterDatabaseStatement, user *User) *Result {
+	if q.WriteLimit != nil {
+		return &Result{Err: s.SetWriteLimit(q.Name, *q.WriteLimit, q.WriteBurst)}
+	}
+	if q.NormalizeNames {
+		var rules *NameRules
+		if q.LowercaseNames || q.NameCharacters != "" || q.MaxNameLength > 0 {
+			var err error
+			if rules, err = NewNameRules(q.LowercaseNames, q.NameCharacters, q.MaxNameLength); err != nil {
+				return &Result{Err: err}
+			}
+		}
+		return &Result{Err: s.SetNameRules(q.Name, rules)}
+	}
+	return &Result{Err: s.RenameDatabase(q.Name, q.NewName)}
+}
+
 func (s *Server) executeDropRetentionPolicyStatement(q *influxql.DropRetentionPolicyStatement, user *User) *Result {
-	return &Result{Err: s.DeleteRetentionPolicy(q.Database, q.Name)}
+	err := s.DeleteRetentionPolicy(q.Database, q.Name)
+	if err == ErrRetentionPolicyNotFound && q.IfExists {
+		err = nil
+	}
+	return &Result{Err: err}
 }
 
 func (s *Server) executeShowRetentionPoliciesStatement(q *influxql.ShowRetentionPoliciesStatement, user *User) *Result {
@@ -2492,10 +4966,17 @@ func (s *Server) executeShowRetentionPoliciesStatement(q *influxql.ShowRetention
 	if err != nil {
 		return &Result{Err: err}
 	}
+	sort.Sort(retentionPolicies(a))
+
+	defaultRP, err := s.DefaultRetentionPolicy(q.Database)
+	if err != nil {
+		return &Result{Err: err}
+	}
 
-	row := &influxql.Row{Columns: []string{"name", "duration", "replicaN"}}
+	// Columns are only ever appended so clients can rely on their positions.
+	row := &influxql.Row{Columns: []string{"name", "duration", "replicaN", "default", "shardGroupDuration"}}
 	for _, rp := range a {
-		row.Values = append(row.Values, []interface{}{rp.Name, rp.Duration.String(), rp.ReplicaN})
+		row.Values = append(row.Values, []interface{}{rp.Name, rp.Duration.String(), rp.ReplicaN, rp == defaultRP, rp.ShardGroupDuration().String()})
 	}
 	return &Result{Series: []*influxql.Row{row}}
 }
@@ -2537,6 +5018,66 @@ func (s *Server) MeasurementNames(database string) []string {
 	return db.names
 }
 
+// MeasurementSchema describes the tags, fields and series of a measurement.
+type MeasurementSchema struct {
+	Name        string        `json:"name"`
+	TagKeys     []string      `json:"tagKeys"`
+	Fields      []FieldSchema `json:"fields"`
+	SeriesCount int           `json:"seriesCount"`
+	Unit        string        `json:"unit,omitempty"`
+	Description string        `json:"description,omitempty"`
+	DisplayName string        `json:"displayName,omitempty"`
+}
+
+// FieldSchema describes a field, its type and its metadata.
+type FieldSchema struct {
+	Name        string            `json:"name"`
+	Type        influxql.DataType `json:"type"`
+	Unit        string            `json:"unit,omitempty"`
+	Description string            `json:"description,omitempty"`
+	DisplayName string            `json:"displayName,omitempty"`
+}
+
+// Schema returns the schema of every measurement in a database, sorted by name.
+func (s *Server) Schema(database string) ([]*MeasurementSchema, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	a := make([]*MeasurementSchema, 0, len(db.names))
+	for _, name := range db.names {
+		m := db.measurements[name]
+		ms := &MeasurementSchema{
+			Name:        m.Name,
+			TagKeys:     m.tagKeys(),
+			Fields:      make([]FieldSchema, 0, len(m.Fields)),
+			SeriesCount: len(m.seriesIDs),
+			Unit:        m.Unit,
+			Description: m.Description,
+			DisplayName: m.DisplayName,
+		}
+		for _, f := range m.Fields {
+			if f.Hidden {
+				continue
+			}
+			ms.Fields = append(ms.Fields, FieldSchema{Name: f.Name, Type: f.Type, Unit: f.Unit, Description: f.Description, DisplayName: f.DisplayName})
+		}
+		sort.Sort(fieldSchemas(ms.Fields))
+		a = append(a, ms)
+	}
+	return a, nil
+}
+
+type fieldSchemas []FieldSchema
+
+func (a fieldSchemas) Len() int           { return len(a) }
+func (a fieldSchemas) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a fieldSchemas) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 /*
 func (s *Server) MeasurementSeriesIDs(database, measurement string) []uint32 {
 	s.mu.RLock()
@@ -2564,6 +5105,19 @@ func (s *Server) measurement(database, name string) (*Measurement, error) {
 // Begin returns an unopened transaction associated with the server.
 func (s *Server) Begin() (influxql.Tx, error) { return newTx(s), nil }
 
+// scanCountingDB begins transactions that count the points they scan.
+type scanCountingDB struct {
+	server  *Server
+	scanned *uint64
+}
+
+// Begin returns an unopened transaction that counts scanned points.
+func (db *scanCountingDB) Begin() (influxql.Tx, error) {
+	tx := newTx(db.server)
+	tx.scanned = db.scanned
+	return tx, nil
+}
+
 // NormalizeStatement adds a default database and policy to the measurements in statement.
 func (s *Server) NormalizeStatement(stmt influxql.Statement, defaultDatabase string) (err error) {
 	s.mu.RLock()
@@ -2685,10 +5239,13 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 
 			// Set index & error under lock.
 			s.mu.Lock()
-			s.index = m.Index
+			s.setIndex(m.Index)
 			if err != nil {
 				s.errors[m.Index] = err
 			}
+			if err == nil || err == ErrDuplicatePointRejected {
+				s.recordReplay(client, countRawPoints(m.Data))
+			}
 			s.mu.Unlock()
 			continue
 		}
@@ -2712,16 +5269,32 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 				err = s.applyCreateDataNode(m)
 			case deleteDataNodeMessageType:
 				err = s.applyDeleteDataNode(m)
+			case dataNodeHeartbeatMessageType:
+				err = s.applyDataNodeHeartbeat(m)
+			case setDataNodeLabelsMessageType:
+				err = s.applySetDataNodeLabels(m)
 			case createDatabaseMessageType:
 				err = s.applyCreateDatabase(m)
 			case dropDatabaseMessageType:
 				err = s.applyDropDatabase(m)
+			case renameDatabaseMessageType:
+				err = s.applyRenameDatabase(m)
+			case cloneDatabaseMessageType:
+				err = s.applyCloneDatabase(m)
+			case setWriteLimitMessageType:
+				err = s.applySetWriteLimit(m)
+			case setNameRulesMessageType:
+				err = s.applySetNameRules(m)
 			case createUserMessageType:
 				err = s.applyCreateUser(m)
 			case updateUserMessageType:
 				err = s.applyUpdateUser(m)
 			case deleteUserMessageType:
 				err = s.applyDeleteUser(m)
+			case createTokenMessageType:
+				err = s.applyCreateToken(m)
+			case deleteTokenMessageType:
+				err = s.applyDeleteToken(m)
 			case createRetentionPolicyMessageType:
 				err = s.applyCreateRetentionPolicy(m)
 			case updateRetentionPolicyMessageType:
@@ -2732,25 +5305,49 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 				err = s.applyCreateShardGroupIfNotExists(m)
 			case deleteShardGroupMessageType:
 				err = s.applyDeleteShardGroup(m)
+			case dropShardMessageType:
+				err = s.applyDropShard(m)
+			case truncateShardGroupsMessageType:
+				err = s.applyTruncateShardGroups(m)
 			case setDefaultRetentionPolicyMessageType:
 				err = s.applySetDefaultRetentionPolicy(m)
 			case createMeasurementsIfNotExistsMessageType:
 				err = s.applyCreateMeasurementsIfNotExists(m)
 			case dropMeasurementMessageType:
 				err = s.applyDropMeasurement(m)
+			case updateFieldMessageType:
+				err = s.applyUpdateField(m)
+			case fieldConvertedMessageType:
+				err = s.applyFieldConverted(m)
+			case setMeasurementTTLMessageType:
+				err = s.applySetMeasurementTTL(m)
+			case updateMetadataMessageType:
+				err = s.applyUpdateMetadata(m)
 			case setPrivilegeMessageType:
 				err = s.applySetPrivilege(m)
 			case createContinuousQueryMessageType:
 				err = s.applyCreateContinuousQueryCommand(m)
+			case createStoredQueryMessageType:
+				err = s.applyCreateStoredQuery(m)
+			case dropStoredQueryMessageType:
+				err = s.applyDropStoredQuery(m)
 			case dropSeriesMessageType:
 				err = s.applyDropSeries(m)
+			case updateSeriesTagsMessageType:
+				err = s.applyUpdateSeriesTags(m)
+			}
+
+			// Keep a history of successful metadata changes.
+			if err == nil {
+				s.recordMetadataChange(m)
 			}
 
 			// Sync high water mark and errors.
-			s.index = m.Index
+			s.setIndex(m.Index)
 			if err != nil {
 				s.errors[m.Index] = err
 			}
+			s.recordReplay(client, 0)
 		}()
 	}
 }
@@ -2759,6 +5356,28 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 type Result struct {
 	Series influxql.Rows
 	Err    error
+
+	// Execution statistics. Only set when requested.
+	Stats *StatementStats
+
+	scanned uint64 // points read by a select statement
+}
+
+// StatementStats represents the cost of executing a statement.
+type StatementStats struct {
+	SeriesN       int           `json:"series"`        // series returned
+	RowsN         int           `json:"rows"`          // values returned across all series
+	PointsScanned uint64        `json:"pointsScanned"` // points read from shards
+	ExecutionTime time.Duration `json:"executionTime"` // in nanoseconds
+}
+
+// newStatementStats returns the statistics of a statement's result.
+func newStatementStats(r *Result, d time.Duration) *StatementStats {
+	st := &StatementStats{SeriesN: len(r.Series), PointsScanned: r.scanned, ExecutionTime: d}
+	for _, row := range r.Series {
+		st.RowsN += len(row.Values)
+	}
+	return st
 }
 
 // MarshalJSON encodes the result into JSON.
@@ -2766,11 +5385,13 @@ func (r *Result) MarshalJSON() ([]byte, error) {
 	// Define a struct that outputs "error" as a string.
 	var o struct {
 		Series []*influxql.Row `json:"series,omitempty"`
+		Stats  *StatementStats `json:"stats,omitempty"`
 		Err    string          `json:"error,omitempty"`
 	}
 
 	// Copy fields to output struct.
 	o.Series = r.Series
+	o.Stats = r.Stats
 	if r.Err != nil {
 		o.Err = r.Err.Error()
 	}
@@ -2782,6 +5403,7 @@ func (r *Result) MarshalJSON() ([]byte, error) {
 func (r *Result) UnmarshalJSON(b []byte) error {
 	var o struct {
 		Series []*influxql.Row `json:"series,omitempty"`
+		Stats  *StatementStats `json:"stats,omitempty"`
 		Err    string          `json:"error,omitempty"`
 	}
 
@@ -2790,6 +5412,7 @@ func (r *Result) UnmarshalJSON(b []byte) error {
 		return err
 	}
 	r.Series = o.Series
+	r.Stats = o.Stats
 	if o.Err != "" {
 		r.Err = errors.New(o.Err)
 	}
@@ -2856,8 +5479,9 @@ type MessagingClient interface {
 	// Publishes a message to the broker.
 	Publish(m *messaging.Message) (index uint64, err error)
 
-	// Creates a new replica with a given ID on the broker.
-	CreateReplica(replicaID uint64, connectURL *url.URL) error
+	// Creates a new replica with a given ID on the broker that receives the
+	// broadcast messages after an index. Zero starts at the end of the topic.
+	CreateReplicaFrom(replicaID uint64, connectURL *url.URL, index uint64) error
 
 	// Deletes an existing replica with a given ID from the broker.
 	DeleteReplica(replicaID uint64) error
@@ -2872,10 +5496,41 @@ type MessagingClient interface {
 	C() <-chan *messaging.Message
 }
 
+// Data node statuses reported by SHOW DATA NODES.
+const (
+	DataNodeStatusUp      = "up"
+	DataNodeStatusDown    = "down"
+	DataNodeStatusUnknown = "unknown" // no heartbeat received yet
+)
+
+// DataNodeHeartbeatMisses is the number of heartbeat intervals after a data
+// node's last heartbeat that it is reported down.
+const DataNodeHeartbeatMisses = 3
+
 // DataNode represents a data node in the cluster.
 type DataNode struct {
 	ID  uint64
 	URL *url.URL
+
+	// Labels describing where the node runs, such as its rack or zone, for
+	// placing replicas.
+	Labels map[string]string `json:",omitempty"`
+
+	// Status sent by the node's last heartbeat.
+	LastHeartbeat     time.Time
+	HeartbeatInterval time.Duration `json:",omitempty"`
+	DiskFree          uint64        `json:",omitempty"` // bytes
+}
+
+// Status returns whether the node was up at a given time according to its
+// last heartbeat.
+func (n *DataNode) Status(now time.Time) string {
+	if n.LastHeartbeat.IsZero() {
+		return DataNodeStatusUnknown
+	} else if now.Sub(n.LastHeartbeat) > DataNodeHeartbeatMisses*n.HeartbeatInterval {
+		return DataNodeStatusDown
+	}
+	return DataNodeStatusUp
 }
 
 // newDataNode returns an instance of DataNode.
@@ -2948,6 +5603,10 @@ type User struct {
 	Hash       string                        `json:"hash"`
 	Privileges map[string]influxql.Privilege `json:"privileges"` // db name to privilege
 	Admin      bool                          `json:"admin,omitempty"`
+
+	// Measurements the user may write to, by db name. Unrestricted if a
+	// database has no filter.
+	WriteMeasurements map[string]*MeasurementFilter `json:"writeMeasurements,omitempty"`
 }
 
 // Authenticate returns nil if the password matches the user's password.
@@ -2962,6 +5621,35 @@ func (u *User) Authorize(privilege influxql.Privilege, database string) bool {
 	return (ok && p >= privilege) || (u.Admin)
 }
 
+// AuthorizeMeasurement returns true if the user may write to the measurement
+// in the database. Admin users are never restricted.
+func (u *User) AuthorizeMeasurement(database, measurement string) bool {
+	if u.Admin {
+		return true
+	}
+	return u.WriteMeasurements[database].Allows(measurement)
+}
+
+// MeasurementFilter restricts the measurements a user can write to.
+type MeasurementFilter struct {
+	Names  []string `json:"names"`
+	Except bool     `json:"except,omitempty"` // names are denied instead of allowed
+}
+
+// Allows returns true if the filter allows the measurement. A nil filter
+// allows all measurements.
+func (f *MeasurementFilter) Allows(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, n := range f.Names {
+		if n == name {
+			return !f.Except
+		}
+	}
+	return f.Except
+}
+
 // users represents a list of users, sortable by name.
 type users []*User
 
@@ -3045,6 +5733,144 @@ func NewContinuousQuery(q string) (*ContinuousQuery, error) {
 	return cquery, nil
 }
 
+// renameContinuousQueries rewrites the continuous queries that reference a
+// database or retention policy renamed by fn. The new queries of each database
+// with a changed query are returned. The caller must hold the lock.
+func (s *Server) renameContinuousQueries(fn func(database, policy string) (string, string)) (map[*database][]*ContinuousQuery, error) {
+	m := make(map[*database][]*ContinuousQuery)
+	for _, db := range s.databases {
+		a := make([]*ContinuousQuery, len(db.continuousQueries))
+		var changed bool
+		for i, cq := range db.continuousQueries {
+			q, err := renameContinuousQuery(cq.Query, fn)
+			if err != nil {
+				return nil, fmt.Errorf("continuous query %s: %s", cq.cq.Name, err)
+			} else if q == cq.Query {
+				a[i] = cq
+				continue
+			}
+
+			other, err := NewContinuousQuery(q)
+			if err != nil {
+				return nil, fmt.Errorf("continuous query %s: %s", cq.cq.Name, err)
+			}
+			cq.mu.Lock()
+			other.lastRun = cq.lastRun
+			cq.mu.Unlock()
+			a[i], changed = other, true
+		}
+		if changed {
+			m[db] = a
+		}
+	}
+	return m, nil
+}
+
+// setContinuousQueries replaces the continuous queries of databases with the
+// queries returned from renameContinuousQueries. The caller must hold the lock.
+func (s *Server) setContinuousQueries(m map[*database][]*ContinuousQuery) {
+	for db, a := range m {
+		db.continuousQueries = a
+		for _, cq := range a {
+			// Normalize as when the query was created. The names referenced
+			// by a renamed query exist so this cannot fail.
+			_ = s.normalizeStatement(cq.cq.Source, cq.cq.Database)
+		}
+	}
+}
+
+// renameContinuousQuery returns the query with the database and retention
+// policy names it references replaced by fn. The query is returned unchanged
+// if fn doesn't rename anything.
+func renameContinuousQuery(q string, fn func(database, policy string) (string, string)) (string, error) {
+	stmt, err := influxql.NewParser(strings.NewReader(q)).ParseStatement()
+	if err != nil {
+		return "", err
+	}
+	cq, ok := stmt.(*influxql.CreateContinuousQueryStatement)
+	if !ok {
+		return "", errors.New("query isn't a continuous query")
+	}
+	database := cq.Database
+
+	var changed bool
+	rename := func(name, defaultDatabase string) string {
+		other, err := renameMeasurementIdent(name, defaultDatabase, fn)
+		if err != nil || other == name {
+			return name
+		}
+		changed = true
+		return other
+	}
+	renameDatabase := func(name string) string {
+		if other, _ := fn(name, ""); other != name {
+			changed = true
+			return other
+		}
+		return name
+	}
+
+	// Rename the database the query runs on, the target and the sources.
+	cq.Database = renameDatabase(database)
+	if t := cq.Source.Target; t != nil {
+		if t.Database != "" {
+			t.Measurement = rename(t.Measurement, t.Database)
+			t.Database = renameDatabase(t.Database)
+		} else {
+			t.Measurement = rename(t.Measurement, database)
+		}
+	}
+	switch src := cq.Source.Source.(type) {
+	case *influxql.Measurement:
+		src.Name = rename(src.Name, database)
+	case *influxql.Join:
+		for _, m := range src.Measurements {
+			m.Name = rename(m.Name, database)
+		}
+	case *influxql.Merge:
+		for _, m := range src.Measurements {
+			m.Name = rename(m.Name, database)
+		}
+	}
+
+	if !changed {
+		return q, nil
+	}
+	return cq.String(), nil
+}
+
+// renameMeasurementIdent returns a measurement identifier with its database
+// and retention policy segments replaced by fn. Missing segments are passed to
+// fn as the default database and a blank retention policy and are left out of
+// the result.
+func renameMeasurementIdent(name, defaultDatabase string, fn func(database, policy string) (string, string)) (string, error) {
+	a, err := influxql.SplitIdent(name)
+	if err != nil {
+		return "", err
+	}
+
+	database, policy := defaultDatabase, ""
+	switch len(a) {
+	case 2:
+		policy = a[0]
+	case 3:
+		database, policy = a[0], a[1]
+	}
+
+	var changed bool
+	newDatabase, newPolicy := fn(database, policy)
+	if len(a) == 3 && newDatabase != database {
+		a[0], changed = newDatabase, true
+	}
+	if len(a) >= 2 && newPolicy != policy {
+		a[len(a)-2], changed = newPolicy, true
+	}
+	if !changed {
+		return name, nil
+	}
+	return influxql.QuoteIdent(a), nil
+}
+
 // applyCreateContinuousQueryCommand adds the continuous query to the database object and saves it to the metastore
 func (s *Server) applyCreateContinuousQueryCommand(m *messaging.Message) error {
 	var c createContinuousQueryCommand
@@ -3084,6 +5910,136 @@ func (s *Server) applyCreateContinuousQueryCommand(m *messaging.Message) error {
 	return nil
 }
 
+// StoredQuery represents a named query stored in a database. The query may
+// reference bound parameters whose values are supplied when it's executed.
+type StoredQuery struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// Parameters returns the names of the query's bound parameters.
+func (q *StoredQuery) Parameters() []string {
+	stmt, err := influxql.NewParser(strings.NewReader(q.Query)).ParseStatement()
+	if err != nil {
+		return nil
+	}
+	return influxql.BoundParameters(stmt)
+}
+
+// Bind returns the query with its bound parameters replaced by values.
+func (q *StoredQuery) Bind(params map[string]interface{}) (*influxql.Query, error) {
+	query, err := influxql.NewParser(strings.NewReader(q.Query)).ParseQuery()
+	if err != nil {
+		return nil, err
+	} else if err := influxql.BindParameters(query, params); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// CreateStoredQuery stores a named select statement in a database.
+func (s *Server) CreateStoredQuery(database, name, query string) error {
+	if database == "" {
+		return ErrDatabaseNameRequired
+	} else if stmt, err := influxql.NewParser(strings.NewReader(query)).ParseStatement(); err != nil {
+		return err
+	} else if _, ok := stmt.(*influxql.SelectStatement); !ok {
+		return ErrInvalidQuery
+	}
+
+	c := &createStoredQueryCommand{Database: database, Name: name, Query: query}
+	_, err := s.broadcast(createStoredQueryMessageType, c)
+	return err
+}
+
+func (s *Server) applyCreateStoredQuery(m *messaging.Message) error {
+	var c createStoredQueryCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if db.storedQueryByName(c.Name) != nil {
+		return ErrStoredQueryExists
+	}
+
+	return s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		db.storedQueries = append(db.storedQueries, &StoredQuery{Name: c.Name, Query: c.Query})
+		return tx.saveDatabase(db)
+	})
+}
+
+// DropStoredQuery removes a stored query from a database.
+func (s *Server) DropStoredQuery(database, name string) error {
+	if database == "" {
+		return ErrDatabaseNameRequired
+	}
+	c := &dropStoredQueryCommand{Database: database, Name: name}
+	_, err := s.broadcast(dropStoredQueryMessageType, c)
+	return err
+}
+
+func (s *Server) applyDropStoredQuery(m *messaging.Message) error {
+	var c dropStoredQueryCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	} else if db.storedQueryByName(c.Name) == nil {
+		return ErrStoredQueryNotFound
+	}
+
+	return s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		a := make([]*StoredQuery, 0, len(db.storedQueries))
+		for _, q := range db.storedQueries {
+			if q.Name != c.Name {
+				a = append(a, q)
+			}
+		}
+		db.storedQueries = a
+		return tx.saveDatabase(db)
+	})
+}
+
+// StoredQueries returns the stored queries of a database, sorted by name.
+func (s *Server) StoredQueries(database string) ([]*StoredQuery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	a := make([]*StoredQuery, len(db.storedQueries))
+	copy(a, db.storedQueries)
+	sort.Sort(storedQueries(a))
+	return a, nil
+}
+
+// StoredQuery returns a stored query by name.
+func (s *Server) StoredQuery(database, name string) (*StoredQuery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+	q := db.storedQueryByName(name)
+	if q == nil {
+		return nil, ErrStoredQueryNotFound
+	}
+	return q, nil
+}
+
+type storedQueries []*StoredQuery
+
+func (a storedQueries) Len() int           { return len(a) }
+func (a storedQueries) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a storedQueries) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 // RunContinuousQueries will run any continuous queries that are due to run and write the
 // results back into the database
 func (s *Server) RunContinuousQueries() error {
@@ -3186,7 +6142,7 @@ func (s *Server) runContinuousQuery(cq *ContinuousQuery) {
 
 // runContinuousQueryAndWriteResult will run the query against the cluster and write the results back in
 func (s *Server) runContinuousQueryAndWriteResult(cq *ContinuousQuery) error {
-	e, err := s.planSelectStatement(cq.cq.Source)
+	e, err := s.planSelectStatement(cq.cq.Source, nil, nil)
 
 	if err != nil {
 		return err
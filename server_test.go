@@ -6,16 +6,22 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/influxdb/influxdb"
 	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
+	"github.com/influxdb/influxdb/objectstore"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -97,6 +103,68 @@ func TestServer_DeleteDataNode(t *testing.T) {
 	}
 }
 
+// Ensure a heartbeat updates the status of the server's data node.
+func TestServer_Heartbeat(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	if n := s.DataNode(s.ID()); n.Status(time.Now()) != influxdb.DataNodeStatusUnknown {
+		t.Fatalf("unexpected status: %s", n.Status(time.Now()))
+	} else if err := s.Heartbeat(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	n := s.DataNode(s.ID())
+	if n.LastHeartbeat.IsZero() || n.HeartbeatInterval != time.Second {
+		t.Fatalf("unexpected heartbeat: %s every %s", n.LastHeartbeat, n.HeartbeatInterval)
+	} else if status := n.Status(n.LastHeartbeat.Add(3 * time.Second)); status != influxdb.DataNodeStatusUp {
+		t.Fatalf("unexpected status: %s", status)
+	} else if status := n.Status(n.LastHeartbeat.Add(4 * time.Second)); status != influxdb.DataNodeStatusDown {
+		t.Fatalf("unexpected status: %s", status)
+	}
+}
+
+// Ensure the server can set and remove the labels of a data node.
+func TestServer_SetDataNodeLabels(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	if err := s.SetDataNodeLabels(s.ID(), map[string]string{"rack": "r1", "zone": "z1"}); err != nil {
+		t.Fatal(err)
+	}
+	results := s.ExecuteQuery(MustParseQuery(`ALTER DATA NODE 1 SET zone = ''`), "", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+	s.Restart()
+
+	if n := s.DataNode(s.ID()); !reflect.DeepEqual(n.Labels, map[string]string{"rack": "r1"}) {
+		t.Fatalf("unexpected labels: %v", n.Labels)
+	} else if err := s.SetDataNodeLabels(100, map[string]string{"rack": "r1"}); err != influxdb.ErrDataNodeNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server lists data nodes with their shard counts and labels.
+func TestServer_ShowDataNodes(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	u, _ := url.Parse("http://localhost:8090")
+	s.CreateDataNode(u)
+	s.SetDataNodeLabels(2, map[string]string{"zone": "z1"})
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour, ReplicaN: 2})
+	s.CreateShardGroupIfNotExists("foo", "bar", mustParseTime("2000-01-01T00:00:00Z"))
+
+	results := s.ExecuteQuery(MustParseQuery(`SHOW DATA NODES`), "", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if got := mustMarshalJSON(res); got != `{"series":[{"name":"data_nodes","columns":["id","url","status","last_heartbeat","shard_count","disk_free","zone"],"values":[[1,"//127.0.0.1:8080","unknown",null,1,0,null],[2,"http://localhost:8090","unknown",null,1,0,"z1"]]}]}` {
+		t.Fatalf("unexpected results: %s", got)
+	}
+}
+
 // Test unuathorized requests logging
 func TestServer_UnauthorizedRequests(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -173,6 +241,48 @@ func TestServer_UserPrivilegeAuthorization(t *testing.T) {
 	}
 }
 
+// Ensure a user's writes can be restricted to some measurements of a database.
+func TestServer_UserMeasurementAuthorization(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateUser("user1", "user1", false)
+
+	// Only cpu and mem can be written to in foo.
+	results := s.ExecuteQuery(MustParseQuery(`GRANT WRITE ON foo MEASUREMENTS cpu, mem TO user1`), "", nil)
+	if err := results.Error(); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	if u := s.User("user1"); !u.Authorize(influxql.WritePrivilege, "foo") {
+		t.Fatalf("user1 doesn't have influxql.WritePrivilege on foo")
+	} else if !u.AuthorizeMeasurement("foo", "cpu") || !u.AuthorizeMeasurement("foo", "mem") {
+		t.Fatalf("user1 can't write to allowed measurements")
+	} else if u.AuthorizeMeasurement("foo", "disk") {
+		t.Fatalf("user1 can write to disk")
+	} else if !u.AuthorizeMeasurement("bar", "disk") {
+		t.Fatalf("user1 is restricted in bar")
+	}
+
+	// Everything but billing can be written to.
+	if err := s.SetPrivilegeWithFilter(influxql.WritePrivilege, "user1", "foo", &influxdb.MeasurementFilter{Names: []string{"billing"}, Except: true}); err != nil {
+		t.Fatal(err)
+	} else if u := s.User("user1"); !u.AuthorizeMeasurement("foo", "disk") || u.AuthorizeMeasurement("foo", "billing") {
+		t.Fatalf("unexpected filter: %#v", u.WriteMeasurements["foo"])
+	}
+
+	// Granting without a filter removes the restriction.
+	if err := s.SetPrivilege(influxql.WritePrivilege, "user1", "foo"); err != nil {
+		t.Fatal(err)
+	} else if u := s.User("user1"); !u.AuthorizeMeasurement("foo", "billing") {
+		t.Fatalf("user1 is still restricted")
+	}
+
+	// Read privileges can't be restricted.
+	if err := s.SetPrivilegeWithFilter(influxql.ReadPrivilege, "user1", "foo", &influxdb.MeasurementFilter{Names: []string{"cpu"}}); err != influxdb.ErrInvalidGrantRevoke {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Test single statement query authorization.
 func TestServer_SingleStatementQueryAuthorization(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -302,6 +412,28 @@ func TestServer_CreateDatabase(t *testing.T) {
 	}
 }
 
+// Ensure the server can create a database with a default retention policy.
+func TestServer_CreateDatabase_WithRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create the "foo" database with retention policy options.
+	results := s.ExecuteQuery(MustParseQuery(`CREATE DATABASE foo WITH DURATION 2h NAME raw`), "", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+	s.Restart()
+
+	// Verify that the retention policy exists and is the default.
+	if rp, err := s.DefaultRetentionPolicy("foo"); err != nil {
+		t.Fatal(err)
+	} else if rp == nil {
+		t.Fatal("default retention policy not found")
+	} else if rp.Name != "raw" || rp.Duration != 2*time.Hour || rp.ReplicaN != influxdb.DefaultReplicaN {
+		t.Fatalf("unexpected retention policy: %#v", rp)
+	}
+}
+
 // Ensure the server returns an error when creating a duplicate database.
 func TestServer_CreateDatabase_ErrDatabaseExists(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -316,6 +448,174 @@ func TestServer_CreateDatabase_ErrDatabaseExists(t *testing.T) {
 	}
 }
 
+// Ensure IF EXISTS and IF NOT EXISTS clauses suppress existence errors.
+func TestServer_ExecuteQuery_IfExists(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	for i, tt := range []struct {
+		q   string
+		err error
+	}{
+		{q: `CREATE DATABASE IF NOT EXISTS foo`},
+		{q: `CREATE DATABASE IF NOT EXISTS foo`},
+		{q: `CREATE DATABASE foo`, err: influxdb.ErrDatabaseExists},
+		{q: `CREATE RETENTION POLICY IF NOT EXISTS raw ON foo DURATION 1h REPLICATION 1`},
+		{q: `CREATE RETENTION POLICY IF NOT EXISTS raw ON foo DURATION 2h REPLICATION 1`},
+		{q: `DROP RETENTION POLICY IF EXISTS raw ON foo`},
+		{q: `DROP RETENTION POLICY IF EXISTS raw ON foo`},
+		{q: `DROP RETENTION POLICY raw ON foo`, err: influxdb.ErrRetentionPolicyNotFound},
+		{q: `CREATE USER IF NOT EXISTS susy WITH PASSWORD 'pass'`},
+		{q: `CREATE USER IF NOT EXISTS susy WITH PASSWORD 'pass'`},
+		{q: `DROP USER IF EXISTS susy`},
+		{q: `DROP USER IF EXISTS susy`},
+		{q: `DROP USER susy`, err: influxdb.ErrUserNotFound},
+		{q: `DROP DATABASE IF EXISTS foo`},
+		{q: `DROP DATABASE IF EXISTS foo`},
+		{q: `DROP DATABASE foo`, err: influxdb.ErrDatabaseNotFound},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "", nil)
+		if err := results.Error(); err != tt.err {
+			t.Fatalf("%d. %s: unexpected error: %v", i, tt.q, err)
+		}
+	}
+}
+
+// Ensure the server can find series that have stopped receiving points.
+func TestServer_SilentSeries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 0})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	now := time.Now().UTC()
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: now.Add(-1 * time.Hour), Fields: map[string]interface{}{"value": float64(1)}},
+		{Name: "cpu", Tags: map[string]string{"host": "b"}, Timestamp: now, Fields: map[string]interface{}{"value": float64(1)}},
+	})
+
+	a, err := s.SilentSeries("foo", "cpu", 10*time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || a[0].Tags["host"] != "a" || !a[0].LastSeen.Equal(now.Add(-1*time.Hour)) {
+		t.Fatalf("unexpected silent series: %s", mustMarshalJSON(a))
+	}
+
+	if _, err := s.SilentSeries("foo", "mem", 10*time.Minute, now); err != influxdb.ErrMeasurementNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a deadman check reports a silent series to its webhook once.
+func TestServer_StartDeadmanCheck_Webhook(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 0})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: time.Now().UTC().Add(-1 * time.Hour), Fields: map[string]interface{}{"value": float64(1)}}})
+
+	events := make(chan *influxdb.SilentSeries, 10)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ss influxdb.SilentSeries
+		if err := json.NewDecoder(r.Body).Decode(&ss); err != nil {
+			t.Error(err)
+		}
+		events <- &ss
+	}))
+	defer hs.Close()
+
+	if err := s.StartDeadmanCheck(&influxdb.DeadmanCheck{Database: "foo", Threshold: 10 * time.Minute, WebhookURL: hs.URL}, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ss := <-events:
+		if ss.Database != "foo" || ss.Measurement != "cpu" || ss.Tags["host"] != "a" {
+			t.Fatalf("unexpected event: %s", mustMarshalJSON(ss))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("webhook not called")
+	}
+
+	// The series should not be reported again while it stays silent.
+	select {
+	case ss := <-events:
+		t.Fatalf("unexpected event: %s", mustMarshalJSON(ss))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Ensure the server writes its own statistics when self-monitoring is enabled.
+func TestServer_StartSelfMonitoring(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.StartSelfMonitoring("_internal", "monitor", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for statistics to be written.
+	for i := 0; ; i++ {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT shards FROM server`), "_internal", nil)
+		if results.Error() == nil && len(results.Results[0].Series) > 0 {
+			break
+		} else if i > 100 {
+			t.Fatalf("statistics not written: %s", mustMarshalJSON(results))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Ensure the retention policy was created with the monitoring duration.
+	if rp, err := s.RetentionPolicy("_internal", "monitor"); err != nil {
+		t.Fatal(err)
+	} else if rp.Duration != influxdb.DefaultMonitorRetention {
+		t.Fatalf("unexpected duration: %s", rp.Duration)
+	}
+}
+
+// Ensure a read-only server rejects writes and mutating statements.
+func TestServer_ReadOnly(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
+	s.SetReadOnly(true)
+
+	if !s.ReadOnly() {
+		t.Fatal("expected server to be read-only")
+	}
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}}); err != influxdb.ErrReadOnly {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+
+	for i, tt := range []struct {
+		q   string
+		err error
+	}{
+		{q: `SHOW DATABASES`},
+		{q: `SELECT value FROM cpu`},
+		{q: `CREATE DATABASE bar`, err: influxdb.ErrReadOnly},
+		{q: `DROP DATABASE foo`, err: influxdb.ErrReadOnly},
+		{q: `SELECT value INTO cpu2 FROM cpu`, err: influxdb.ErrReadOnly},
+		{q: `SHOW USAGE`},
+		{q: `RESET USAGE`, err: influxdb.ErrReadOnly},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if err := results.Error(); err != tt.err {
+			t.Fatalf("%d. %s: unexpected error: %v", i, tt.q, err)
+		}
+	}
+
+	// Writes are accepted again once read-only mode is turned off.
+	s.SetReadOnly(false)
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(100)}}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure the server can drop a database.
 func TestServer_DropDatabase(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -404,6 +704,61 @@ func TestServer_CreateUser(t *testing.T) {
 
 }
 
+// Ensure the server can create, authenticate and revoke API tokens.
+func TestServer_CreateToken(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create a token.
+	secret, err := s.CreateToken("collector", false, map[string]influxql.Privilege{"db0": influxql.WritePrivilege})
+	if err != nil {
+		t.Fatal(err)
+	} else if secret == "" {
+		t.Fatal("expected secret")
+	}
+	s.Restart()
+
+	// Only the hash of the secret is stored.
+	if a := s.Tokens(); len(a) != 1 || a[0].Name != "collector" {
+		t.Fatalf("unexpected tokens: %#v", a)
+	} else if a[0].Hash == "" || strings.Contains(a[0].Hash, secret) {
+		t.Fatalf("unexpected hash: %s", a[0].Hash)
+	}
+
+	// Authenticate with the secret.
+	u, err := s.AuthenticateToken(secret)
+	if err != nil {
+		t.Fatal(err)
+	} else if u.Name != "token:collector" || u.Admin {
+		t.Fatalf("unexpected user: %#v", u)
+	} else if !u.Authorize(influxql.WritePrivilege, "db0") || u.Authorize(influxql.WritePrivilege, "db1") {
+		t.Fatalf("unexpected privileges: %#v", u.Privileges)
+	}
+	if _, err := s.AuthenticateToken("bad"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Duplicate and invalid tokens are rejected.
+	if _, err := s.CreateToken("collector", true, nil); err != influxdb.ErrTokenExists {
+		t.Fatalf("unexpected error: %s", err)
+	} else if _, err := s.CreateToken("", true, nil); err != influxdb.ErrTokenNameRequired {
+		t.Fatalf("unexpected error: %s", err)
+	} else if _, err := s.CreateToken("empty", false, nil); err != influxdb.ErrTokenPrivilegesRequired {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Revoke the token.
+	if err := s.DropToken("collector"); err != nil {
+		t.Fatal(err)
+	} else if err := s.DropToken("collector"); err != influxdb.ErrTokenNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.Restart()
+	if _, err := s.AuthenticateToken(secret); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
 // Ensure the server correctly detects when there is an admin user.
 func TestServer_AdminUserExists(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -534,7 +889,7 @@ func TestServer_CreateRetentionPolicy(t *testing.T) {
 	rp := &influxdb.RetentionPolicy{
 		Name:     "bar",
 		Duration: time.Hour,
-		ReplicaN: 2,
+		ReplicaN: 1,
 	}
 	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
 		t.Fatal(err)
@@ -581,6 +936,31 @@ func TestServer_CreateRetentionPolicy_ErrRetentionPolicyExists(t *testing.T) {
 	}
 }
 
+// Ensure the server returns an error when creating a retention policy with
+// more replicas than data nodes, unless forced.
+func TestServer_CreateRetentionPolicy_ErrReplicationFactorTooHigh(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", ReplicaN: 2}); err != influxdb.ErrReplicationFactorTooHigh {
+		t.Fatal(err)
+	} else if err := s.ForceCreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", ReplicaN: 2}); err != nil {
+		t.Fatal(err)
+	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp.ReplicaN != 2 {
+		t.Fatalf("unexpected replication factor: %d", rp.ReplicaN)
+	}
+
+	// Statements may only exceed the data node count with FORCE.
+	results := s.ExecuteQuery(MustParseQuery(`CREATE RETENTION POLICY baz ON foo DURATION 1h REPLICATION 3`), "foo", nil)
+	if results.Error() != influxdb.ErrReplicationFactorTooHigh {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+	results = s.ExecuteQuery(MustParseQuery(`CREATE RETENTION POLICY baz ON foo DURATION 1h REPLICATION 3 FORCE`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+}
+
 // Ensure the database can alter an existing retention policy.
 func TestServer_AlterRetentionPolicy(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -595,7 +975,7 @@ func TestServer_AlterRetentionPolicy(t *testing.T) {
 	rp := &influxdb.RetentionPolicy{
 		Name:     "bar",
 		Duration: time.Hour,
-		ReplicaN: 2,
+		ReplicaN: 1,
 	}
 	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
 		t.Fatal(err)
@@ -607,6 +987,7 @@ func TestServer_AlterRetentionPolicy(t *testing.T) {
 	rp2 := &influxdb.RetentionPolicyUpdate{
 		Duration: &duration,
 		ReplicaN: &replicaN,
+		Force:    true,
 	}
 	if err := s.UpdateRetentionPolicy("foo", "bar", rp2); err != nil {
 		t.Fatal(err)
@@ -645,6 +1026,57 @@ func TestServer_AlterRetentionPolicy(t *testing.T) {
 	}
 }
 
+// Ensure raising a retention policy's replication factor assigns more data
+// nodes to its existing shards.
+func TestServer_AlterRetentionPolicy_Replicate(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	u, _ := url.Parse("http://localhost:8090")
+	s.CreateDataNode(u)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour, ReplicaN: 1})
+	s.CreateShardGroupIfNotExists("foo", "bar", mustParseTime("2000-01-01T00:00:00Z"))
+
+	// Each node stores one of the group's two shards.
+	g, _ := s.ShardGroups("foo")
+	if len(g) != 1 || len(g[0].Shards) != 2 {
+		t.Fatalf("unexpected shard groups: %#v", g)
+	}
+	var remote *influxdb.Shard
+	for _, sh := range g[0].Shards {
+		if !sh.HasDataNodeID(s.ID()) {
+			remote = sh
+		}
+	}
+	if remote == nil {
+		t.Fatal("expected a shard on the other node")
+	}
+
+	// Track subscribed topics.
+	var topics []uint64
+	c.SubscribeFunc = func(replicaID, topicID uint64) error {
+		topics = append(topics, topicID)
+		return nil
+	}
+
+	// Raise the replication factor so both nodes store both shards.
+	results := s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY bar ON foo REPLICATION 2`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	} else if !reflect.DeepEqual(topics, []uint64{remote.ID}) {
+		t.Fatalf("unexpected topics: %v", topics)
+	}
+	s.Restart()
+
+	g, _ = s.ShardGroups("foo")
+	for _, sh := range g[0].Shards {
+		if len(sh.DataNodeIDs) != 2 {
+			t.Fatalf("unexpected data nodes for shard %d: %v", sh.ID, sh.DataNodeIDs)
+		}
+	}
+}
+
 // Ensure the server can delete an existing retention policy.
 func TestServer_DeleteRetentionPolicy(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -788,6 +1220,31 @@ func TestServer_EnforceRetentionPolices(t *testing.T) {
 	}
 }
 
+// Ensure shortening a policy's duration removes the shard groups it ages out.
+func TestServer_AlterRetentionPolicy_ShortenDuration(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 24 * time.Hour, ReplicaN: 1})
+	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(-72*time.Hour))
+	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now())
+
+	// Changing only the duration leaves the replication factor alone.
+	if res := s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY mypolicy ON foo DURATION 2h`), "foo", nil); res.Error() != nil {
+		t.Fatal(res.Error())
+	} else if rp, _ := s.RetentionPolicy("foo", "mypolicy"); rp.Duration != 2*time.Hour || rp.ReplicaN != 1 {
+		t.Fatalf("unexpected policy: %#v", rp)
+	}
+
+	// The older shard group is removed without waiting for enforcement.
+	if g, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(g) != 1 {
+		t.Fatalf("expected 1 shard group but found %d", len(g))
+	}
+}
+
 // Ensure the database can write data to the database.
 func TestServer_WriteSeries(t *testing.T) {
 	c := NewMessagingClient()
@@ -848,72 +1305,312 @@ func TestServer_WriteSeries(t *testing.T) {
 	}
 }
 
-// Ensure the server can drop a measurement.
-func TestServer_DropMeasurement(t *testing.T) {
+// Ensure the server doesn't reapply write messages that the broker redelivers.
+func TestServer_WriteSeries_Redelivered(t *testing.T) {
 	c := NewMessagingClient()
 	s := OpenServer(c)
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
-	s.CreateUser("susy", "pass", false)
-
-	// Write series with one point to the database.
-	tags := map[string]string{"host": "serverA", "region": "uswest"}
-	index, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(23.2)}}})
-	if err != nil {
-		t.Fatal(err)
-	} else if err = s.Sync(index); err != nil {
-		t.Fatalf("sync error: %s", err)
-	}
-
-	// Ensure measurement exists
-	results := s.ExecuteQuery(MustParseQuery(`SHOW MEASUREMENTS`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Series) != 1 {
-		t.Fatalf("unexpected row count: %d", len(res.Series))
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"measurements","columns":["name"],"values":[["cpu"]]}]}` {
-		t.Fatalf("unexpected row(0): %s", s)
-	}
 
-	// Ensure series exists
-	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Series) != 1 {
-		t.Fatalf("unexpected row count: %d", len(res.Series))
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["id","host","region"],"values":[[1,"serverA","uswest"]]}]}` {
-		t.Fatalf("unexpected row(0): %s", s)
+	// Keep the write messages sent to the broker.
+	var writes []*messaging.Message
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		if m.TopicID != messaging.BroadcastTopicID {
+			writes = append(writes, m)
+		}
+		c.c <- m
+		return m.Index, nil
 	}
 
-	// Drop measurement
-	results = s.ExecuteQuery(MustParseQuery(`DROP MEASUREMENT cpu`), "foo", nil)
-	if results.Error() != nil {
-		t.Fatalf("unexpected error: %s", results.Error())
+	// Overwrite a point and then redeliver the first write.
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: timestamp, Fields: map[string]interface{}{"value": float64(1)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: timestamp, Fields: map[string]interface{}{"value": float64(2)}}})
+	if len(writes) != 2 {
+		t.Fatalf("unexpected write count: %d", len(writes))
 	}
+	c.c <- writes[0]
 
-	results = s.ExecuteQuery(MustParseQuery(`SHOW MEASUREMENTS`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Series) != 0 {
-		t.Fatalf("unexpected row count: %d", len(res.Series))
-	} else if s := mustMarshalJSON(res); s != `{}` {
-		t.Fatalf("unexpected row(0): %s", s)
+	// Wait for the redelivered message to be processed.
+	if err := s.CreateDatabase("bar"); err != nil {
+		t.Fatal(err)
 	}
 
-	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES`), "foo", nil)
+	// Verify the point was not overwritten by the old value.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
 	if res := results.Results[0]; res.Err != nil {
 		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Series) != 0 {
-		t.Fatalf("unexpected row count: %d", len(res.Series))
-	} else if s := mustMarshalJSON(res); s != `{}` {
-		t.Fatalf("unexpected row(0): %s", s)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",2]]}]}` {
+		t.Fatalf("unexpected row: %s", s)
 	}
 }
 
-// Ensure the server can handles drop measurement if none exists.
-func TestServer_DropMeasurementNoneExists(t *testing.T) {
+// Ensure the server merges the fields of duplicate points when the retention policy requires it.
+func TestServer_WriteSeries_DuplicatePolicyMerge(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 1 * time.Hour, Duplicates: "MERGE"})
+
+	// Write two points to the same series and timestamp with different fields.
+	tags := map[string]string{"host": "servera.influx.com"}
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+	if index, err := s.WriteSeries("foo", "mypolicy", []influxdb.Point{{Name: "cpu_load", Tags: tags, Timestamp: timestamp, Fields: map[string]interface{}{"value": float64(23.2), "idle": float64(10)}}}); err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	if index, err := s.WriteSeries("foo", "mypolicy", []influxdb.Point{{Name: "cpu_load", Tags: tags, Timestamp: timestamp, Fields: map[string]interface{}{"value": float64(100)}}}); err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Verify the fields were merged.
+	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, timestamp); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(100), "idle": float64(10)}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+}
+
+// Ensure the server keeps the existing point when the retention policy rejects duplicates.
+func TestServer_WriteSeries_DuplicatePolicyReject(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 1 * time.Hour})
+	if results := s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY mypolicy ON foo DUPLICATES reject`), "foo", nil); results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+
+	// Write two points to the same series and timestamp. The second write
+	// reports the rejected point.
+	tags := map[string]string{"host": "servera.influx.com"}
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+	for i, value := range []float64{23.2, 100} {
+		if index, err := s.WriteSeries("foo", "mypolicy", []influxdb.Point{{Name: "cpu_load", Tags: tags, Timestamp: timestamp, Fields: map[string]interface{}{"value": value}}}); err != nil {
+			t.Fatal(err)
+		} else if err = s.Sync(index); i == 0 && err != nil {
+			t.Fatalf("sync error: %s", err)
+		} else if i == 1 && err != influxdb.ErrDuplicatePointRejected {
+			t.Fatalf("unexpected sync error: %v", err)
+		}
+	}
+
+	// Verify the first point was kept.
+	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, timestamp); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(23.2)}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+}
+
+// Ensure the server returns an error when creating a retention policy with an unknown duplicate policy.
+func TestServer_CreateRetentionPolicy_ErrInvalidDuplicatePolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duplicates: "bogus"}); err != influxdb.ErrInvalidDuplicatePolicy {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can drop a measurement.
+func TestServer_DropMeasurement(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+
+	// Write series with one point to the database.
+	tags := map[string]string{"host": "serverA", "region": "uswest"}
+	index, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(23.2)}}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Ensure measurement exists
+	results := s.ExecuteQuery(MustParseQuery(`SHOW MEASUREMENTS`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 1 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"measurements","columns":["name"],"values":[["cpu"]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Ensure series exists
+	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 1 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["id","host","region"],"values":[[1,"serverA","uswest"]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Drop measurement
+	results = s.ExecuteQuery(MustParseQuery(`DROP MEASUREMENT cpu`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SHOW MEASUREMENTS`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 0 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
+	} else if s := mustMarshalJSON(res); s != `{}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 0 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
+	} else if s := mustMarshalJSON(res); s != `{}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+}
+
+// Ensure the server can change the type of a field and convert its existing values.
+func TestServer_AlterField(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write string values to the database, one of which is not numeric.
+	tags := map[string]string{"host": "serverA"}
+	index, err := s.WriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": "23.2", "load": float64(2)}},
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": "n/a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Convert the field to a number and wait for the values to be converted.
+	results := s.ExecuteQuery(MustParseQuery(`ALTER FIELD value ON cpu TO number`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+	a := s.FieldConversions()
+	if len(a) != 1 {
+		t.Fatalf("unexpected job count: %d", len(a))
+	}
+	j := a[0]
+	for !j.Done() {
+		time.Sleep(10 * time.Millisecond)
+		j = s.FieldConversion(j.ID)
+	}
+	if j.Err != "" || j.SeriesDone != 1 {
+		t.Fatalf("unexpected job: %s", mustMarshalJSON(j))
+	}
+
+	// Verify the field type is updated and persisted.
+	s.Restart()
+	results = s.ExecuteQuery(MustParseQuery(`SHOW FIELD KEYS`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["fieldKey","fieldType","unit","description","displayName"],"values":[["load","number","","",""],["value","number","","",""]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Verify the values were converted and unconvertible values were removed.
+	if v, err := s.ReadSeries("foo", "raw", "cpu", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(23.2), "load": float64(2)}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+	if v, err := s.ReadSeries("foo", "raw", "cpu", tags, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("unexpected values: %#v", v)
+	}
+
+	// Verify an unknown field returns an error.
+	if err := s.UpdateField("foo", "cpu", "no_such_field", influxql.String); err != influxdb.ErrFieldNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server can set metadata on measurements and fields.
+func TestServer_UpdateMetadata(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	tags := map[string]string{"host": "serverA"}
+	index, err := s.WriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(23.2), "load": float64(2)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Set metadata on the measurement and on a field.
+	results := s.ExecuteQuery(MustParseQuery(`ALTER MEASUREMENT cpu SET description = 'CPU usage'; ALTER FIELD value ON cpu SET unit = 'percent', display_name = 'Usage'`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+
+	// Change only the description of the field.
+	results = s.ExecuteQuery(MustParseQuery(`ALTER FIELD value ON cpu SET description = 'Percent of CPU in use'`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+
+	// Verify the metadata is returned and persisted.
+	s.Restart()
+	results = s.ExecuteQuery(MustParseQuery(`SHOW FIELD KEYS`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["fieldKey","fieldType","unit","description","displayName"],"values":[["load","number","","",""],["value","number","percent","Percent of CPU in use","Usage"]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+	if a, err := s.Schema("foo"); err != nil {
+		t.Fatal(err)
+	} else if a[0].Description != "CPU usage" {
+		t.Fatalf("unexpected measurement description: %q", a[0].Description)
+	}
+
+	// Verify changing the field's type keeps its metadata.
+	if err := s.UpdateField("foo", "cpu", "value", influxql.String); err != nil {
+		t.Fatal(err)
+	} else if a, _ := s.Schema("foo"); a[0].Fields[1].Unit != "percent" {
+		t.Fatalf("unexpected field schema: %#v", a[0].Fields[1])
+	}
+
+	// Verify unknown measurements and fields return errors.
+	unit := "percent"
+	if err := s.UpdateMetadata("foo", "mem", "", &influxdb.MetadataUpdate{Unit: &unit}); err != influxdb.ErrMeasurementNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.UpdateMetadata("foo", "cpu", "no_such_field", &influxdb.MetadataUpdate{Unit: &unit}); err != influxdb.ErrFieldNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server can handles drop measurement if none exists.
+func TestServer_DropMeasurementNoneExists(t *testing.T) {
 	c := NewMessagingClient()
 	s := OpenServer(c)
 	defer s.Close()
@@ -1268,364 +1965,832 @@ func TestServer_DropSeriesTagsPreserved(t *testing.T) {
 	}
 }
 
-// Ensure the server can execute a query and return the data correctly.
-func TestServer_ExecuteQuery(t *testing.T) {
+// Ensure the server can store and query unsigned integer fields without losing precision.
+func TestServer_ExecuteQuery_Unsigned(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
-	s.CreateUser("susy", "pass", false)
-
-	// Write series with one point to the database.
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(20)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(30)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
 
-	// Select data from the server.
-	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY time(10s), region`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Series) != 2 {
-		t.Fatalf("unexpected row count: %d", len(res.Series))
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",20],["2000-01-01T00:00:10Z",30]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",100]]}]}` {
-		t.Fatalf("unexpected row(0): %s", s)
-	}
+	// Write an unsigned value and then a whole number to the same field.
+	tags := map[string]string{"host": "serverA"}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "net", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"bytes": uint64(18446744073709551000)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "net", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"bytes": float64(5)}}})
 
-	// Simple non-aggregation.
-	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01 00:00:05'`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during simple SELECT: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:10Z",30]]}]}` {
-		t.Fatalf("unexpected row(0) during simple SELECT: %s", s)
+	// Verify a fractional number cannot be written to the field.
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "net", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"bytes": float64(1.5)}}}); err == nil {
+		t.Fatal("expected error")
 	}
 
-	// Sum aggregation.
-	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01 00:00:05' GROUP BY time(10s), region`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during SUM: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:10Z",30]]}]}` {
-		t.Fatalf("unexpected row(0) during SUM: %s", s)
+	// Verify an unsigned value a float can't represent exactly cannot be written to a number field.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "net", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:30Z"), Fields: map[string]interface{}{"load": float64(1)}}})
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "net", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"load": uint64(1<<53 + 1)}}}); err == nil {
+		t.Fatal("expected error")
 	}
 
-	// Aggregation with a null field value
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:03Z"), Fields: map[string]interface{}{"otherVal": float64(20)}}})
-	// Sum aggregation.
-	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY region`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during SUM: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",50]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",100]]}]}` {
-		t.Fatalf("unexpected row(0) during SUM: %s", s)
+	// Verify the value is read back exactly.
+	if v, err := s.ReadSeries("foo", "raw", "net", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"bytes": uint64(18446744073709551000)}) {
+		t.Fatalf("values mismatch: %#v", v)
 	}
 
-	// WHERE with AND
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "uk", "host": "serverZ", "service": "redis"}, Timestamp: mustParseTime("2000-01-01T00:00:03Z"), Fields: map[string]interface{}{"value": float64(20)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "uk", "host": "serverZ", "service": "mysql"}, Timestamp: mustParseTime("2000-01-01T00:00:03Z"), Fields: map[string]interface{}{"value": float64(30)}}})
-	// Sum aggregation.
-	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE region='uk' AND host='serverZ'`), "foo", nil)
+	// Verify comparisons and sums are exact.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT bytes FROM net WHERE bytes > 9007199254740992`), "foo", nil)
 	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during SUM: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",50]]}]}` {
-		t.Fatalf("unexpected row(0) during SUM AND: %s", s)
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"net","columns":["time","bytes"],"values":[["2000-01-01T00:00:00Z",18446744073709551000]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
-
-	// TODO re-enable. The following code is racy
-	//results = s.ExecuteQuery(MustParseQuery(`SELECT * FROM cpu WHERE region='uk' AND host='serverZ'`), "foo", nil)
-	//if res := results.Results[0]; res.Err != nil {
-	//t.Fatalf("unexpected error during SUM: %s", res.Err)
-	//} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value","otherVal"],"values":[["2000-01-01T00:00:03Z",30,0],["2000-01-01T00:00:03Z",20,0]]}]}` {
-	//t.Fatalf("unexpected row(0) during SUM AND: %s", s)
-	//}
-
-	// Select that should return an empty result.
-	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '3000-01-01 00:00:05'`), "foo", nil)
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(bytes) FROM net`), "foo", nil)
 	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during simple SELECT: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{}` {
-		t.Fatalf("unexpected row(0) during simple SELECT: %s", s)
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"net","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",18446744073709551005]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
 }
 
-// Ensure the server respects limit and offset in show series queries
-func TestServer_ShowSeriesLimitOffset(t *testing.T) {
+// Ensure the server can store and query nanosecond duration fields.
+func TestServer_ExecuteQuery_Duration(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	// Write series with one point to the database.
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east", "host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(20)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east", "host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(30)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west", "host": "serverC"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "memory", Tags: map[string]string{"region": "us-west", "host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "memory", Tags: map[string]string{"region": "us-east", "host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
+	tags := map[string]string{"host": "serverA"}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "ping", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"rtt": 1500 * time.Microsecond}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "ping", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"rtt": 20 * time.Millisecond}}})
 
-	// Select data from the server.
-	results := s.ExecuteQuery(MustParseQuery(`SHOW SERIES LIMIT 3 OFFSET 1`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Series) != 2 {
-		t.Fatalf("unexpected row count: %d", len(res.Series))
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["id","host","region"],"values":[[2,"serverB","us-east"],[3,"serverC","us-west"]]},{"name":"memory","columns":["id","host","region"],"values":[[4,"serverB","us-west"]]}]}` {
-		t.Fatalf("unexpected row(0): %s", s)
+	// Verify a number cannot be written to the field.
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "ping", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"rtt": float64(1)}}}); err == nil {
+		t.Fatal("expected error")
 	}
 
-	// Select data from the server.
-	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES LIMIT 2 OFFSET 4`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Series) != 1 {
-		t.Fatalf("unexpected row count: %d", len(res.Series))
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"memory","columns":["id","host","region"],"values":[[5,"serverA","us-east"]]}]}` {
-		t.Fatalf("unexpected row(0): %s", s)
+	// Verify the value is read back exactly.
+	if v, err := s.ReadSeries("foo", "raw", "ping", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"rtt": 1500 * time.Microsecond}) {
+		t.Fatalf("values mismatch: %#v", v)
 	}
 
-	// Select data from the server.
-	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES LIMIT 2 OFFSET 20`), "foo", nil)
+	// Verify durations are returned as nanoseconds and can be compared with duration literals and summed.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT rtt FROM ping WHERE rtt > 10ms`), "foo", nil)
 	if res := results.Results[0]; res.Err != nil {
 		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Series) != 0 {
-		t.Fatalf("unexpected row count: %d", len(res.Series))
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"ping","columns":["time","rtt"],"values":[["2000-01-01T00:00:10Z",20000000]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
-
-	// Select data from the server.
-	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES LIMIT 20`), "foo", nil)
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(rtt) FROM ping`), "foo", nil)
 	if res := results.Results[0]; res.Err != nil {
 		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Series) != 2 {
-		t.Fatalf("unexpected row count: %d", len(res.Series))
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["id","host","region"],"values":[[1,"serverA","us-east"],[2,"serverB","us-east"],[3,"serverC","us-west"]]},{"name":"memory","columns":["id","host","region"],"values":[[4,"serverB","us-west"],[5,"serverA","us-east"]]}]}` {
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"ping","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",21500000]]}]}` {
 		t.Fatalf("unexpected row(0): %s", s)
 	}
 }
 
-// Ensure that when querying for raw data values that they return in time order
-func TestServer_RawDataReturnsInOrder(t *testing.T) {
+// Ensure annotations can be written and read back by the time they overlap.
+func TestServer_Annotations(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
-	s.CreateUser("susy", "pass", false)
 
-	for i := 1; i < 500; i++ {
-		host := fmt.Sprintf("server-%d", i%10)
-		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east", "host": host}, Timestamp: time.Unix(int64(i), 0), Fields: map[string]interface{}{"value": float64(i)}}})
+	// Databases without annotations return none.
+	if a, err := s.Annotations(nil, "foo", "", time.Time{}, time.Time{}, nil); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 0 {
+		t.Fatalf("unexpected annotations: %#v", a)
 	}
 
-	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during COUNT: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","count"],"values":[["1970-01-01T00:00:00Z",499]]}]}` {
-		t.Fatalf("unexpected row(0) during COUNT: %s", s)
+	if _, err := s.WriteAnnotation(nil, "foo", "", &influxdb.Annotation{Start: mustParseTime("2000-01-01T00:00:00Z")}); err != influxdb.ErrAnnotationTextRequired {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := s.WriteAnnotation(nil, "foo", "", &influxdb.Annotation{Start: mustParseTime("2000-01-01T00:00:00Z"), End: mustParseTime("1999-12-31T00:00:00Z"), Text: "x"}); err != influxdb.ErrAnnotationEndBeforeStart {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
-
-	lastTime := int64(0)
-	for _, v := range results.Results[0].Series[0].Values {
-		tt := v[0].(time.Time)
-		if lastTime > tt.UnixNano() {
-			t.Fatal("values out of order")
+	deploy := &influxdb.Annotation{Start: mustParseTime("2000-01-01T00:00:00Z"), Text: "deploy v1.2", Tags: map[string]string{"app": "api"}}
+	incident := &influxdb.Annotation{Start: mustParseTime("2000-01-01T00:10:00Z"), End: mustParseTime("2000-01-01T01:00:00Z"), Text: "api outage", Tags: map[string]string{"app": "api"}}
+	other := &influxdb.Annotation{Start: mustParseTime("2000-01-01T00:20:00Z"), Text: "deploy web", Tags: map[string]string{"app": "web"}}
+	for _, a := range []*influxdb.Annotation{incident, deploy, other} {
+		index, err := s.WriteAnnotation(nil, "foo", "", a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Sync(index); err != nil {
+			t.Fatal(err)
 		}
-		lastTime = tt.UnixNano()
 	}
-	if len(results.Results[0].Series[0].Values) != 499 {
-		t.Fatal("expected 499 values")
+
+	// Windows starting before the time range are included.
+	if a, err := s.Annotations(nil, "foo", "", mustParseTime("2000-01-01T00:30:00Z"), mustParseTime("2000-01-01T00:40:00Z"), nil); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(a); s != `[{"start":"2000-01-01T00:10:00Z","end":"2000-01-01T01:00:00Z","text":"api outage","tags":{"app":"api"}}]` {
+		t.Fatalf("unexpected annotations: %s", s)
 	}
 
-	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu GROUP BY *`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during GROUP BY *: %s", res.Err)
-	} else if len(res.Series) != 10 {
-		t.Fatalf("expected 10 series back but got %d", len(res.Series))
-	} else if len(res.Series[0].Values) != 50 {
-		t.Fatalf("expected 50 values per series but got %d", len(res.Series[0].Values))
+	// Annotations can be filtered by tags.
+	if a, err := s.Annotations(nil, "foo", "raw", time.Time{}, time.Time{}, map[string]string{"app": "api"}); err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(a); s != `[{"start":"2000-01-01T00:00:00Z","end":"2000-01-01T00:00:00Z","text":"deploy v1.2","tags":{"app":"api"}},{"start":"2000-01-01T00:10:00Z","end":"2000-01-01T01:00:00Z","text":"api outage","tags":{"app":"api"}}]` {
+		t.Fatalf("unexpected annotations: %s", s)
 	}
 }
 
-// Ensure that limit and offset work
-func TestServer_LimitAndOffset(t *testing.T) {
+// Ensure boolean fields can be aggregated with true counted as 1.
+func TestServer_BooleanAggregates(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	for i := 1; i < 10; i++ {
-		host := fmt.Sprintf("server-%d", i)
-		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east", "host": host}, Timestamp: time.Unix(int64(i), 0), Fields: map[string]interface{}{"value": float64(i)}}})
-	}
+	tags := map[string]string{"host": "serverA"}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "status", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"up": true}},
+		{Name: "status", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"up": false}},
+		{Name: "status", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"up": true}},
+		{Name: "status", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:30Z"), Fields: map[string]interface{}{"up": true}},
+	})
 
-	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 20`), "foo", nil)
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(up), sum(up), mean(up), min(up), max(up) FROM status`), "foo", nil)
 	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during COUNT: %s", res.Err)
-	} else if len(res.Series) != 9 {
-		t.Fatalf("unexpected 9 series back but got %d", len(res.Series))
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"status","columns":["time","count","sum","mean","min","max"],"values":[["1970-01-01T00:00:00Z",4,3,0.75,0,1]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
+}
 
-	results = s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 2 OFFSET 1`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during COUNT: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"server-2","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]},{"name":"cpu","tags":{"host":"server-3","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]}]}` {
-		t.Fatalf("unexpected row(0) during COUNT: %s", s)
-	}
+// Ensure string fields can be searched with regular expressions.
+func TestServer_StringFieldSearch(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	results = s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 2 OFFSET 3`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during COUNT: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"server-5","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]},{"name":"cpu","tags":{"host":"server-4","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]}]}` {
-		t.Fatalf("unexpected row(0) during COUNT: %s", s)
-	}
+	tags := map[string]string{"host": "serverA"}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "log", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"msg": "Disk ERROR on sda"}},
+		{Name: "log", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"msg": "warning: low memory"}},
+		{Name: "log", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"msg": "no errors found"}},
+	})
 
-	results = s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 3 OFFSET 8`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during COUNT: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"server-9","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]}]}` {
-		t.Fatalf("unexpected row(0) during COUNT: %s", s)
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{q: `SELECT msg FROM log WHERE msg =~ /(?i)error/`, exp: `{"series":[{"name":"log","columns":["time","msg"],"values":[["2000-01-01T00:00:00Z","Disk ERROR on sda"],["2000-01-01T00:00:20Z","no errors found"]]}]}`},
+		{q: `SELECT msg FROM log WHERE msg =~ /(?i)^WARN/`, exp: `{"series":[{"name":"log","columns":["time","msg"],"values":[["2000-01-01T00:00:10Z","warning: low memory"]]}]}`},
+		{q: `SELECT msg FROM log WHERE msg !~ /error/`, exp: `{"series":[{"name":"log","columns":["time","msg"],"values":[["2000-01-01T00:00:00Z","Disk ERROR on sda"],["2000-01-01T00:00:10Z","warning: low memory"]]}]}`},
+		{q: `SELECT count(msg) FROM log WHERE msg =~ /(?i)error/ AND host = 'serverA'`, exp: `{"series":[{"name":"log","columns":["time","count"],"values":[["1970-01-01T00:00:00Z",2]]}]}`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Fatalf("%d. unexpected row(0): %s", i, s)
+		}
 	}
+}
 
-	results = s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 3 OFFSET 20`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during COUNT: %s", res.Err)
+// Ensure queries coarser than a rollup's interval read from the rollup.
+func TestServer_Rollups(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "hourly", Duration: 24 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.Rollups = []*influxdb.Rollup{{Database: "foo", Measurement: "cpu", Interval: time.Hour, TargetRetentionPolicy: "hourly", Functions: []string{"max"}}}
+
+	// The rollup holds different values than the raw points so reads from it can be told apart.
+	tags := map[string]string{"host": "serverA"}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T01:00:00Z"), Fields: map[string]interface{}{"value": float64(20)}},
+	})
+	s.MustWriteSeries("foo", "hourly", []influxdb.Point{
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}},
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T01:00:00Z"), Fields: map[string]interface{}{"value": float64(200)}},
+	})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{
+			q:   `SELECT max(value) FROM cpu WHERE time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T02:00:00Z' GROUP BY time(2h)`,
+			exp: `{"series":[{"name":"cpu","columns":["time","max"],"values":[["2000-01-01T00:00:00Z",200]]}]}`,
+		},
+		{
+			q:   `SELECT max(value) FROM cpu WHERE host = 'serverA' AND time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T02:00:00Z' GROUP BY time(1h), host`,
+			exp: `{"series":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","max"],"values":[["2000-01-01T00:00:00Z",100],["2000-01-01T01:00:00Z",200]]}]}`,
+		},
+
+		// Finer intervals, other functions and field filters read the raw points.
+		{
+			q:   `SELECT max(value) FROM cpu WHERE time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T02:00:00Z' GROUP BY time(90m)`,
+			exp: `{"series":[{"name":"cpu","columns":["time","max"],"values":[["2000-01-01T00:00:00Z",20]]}]}`,
+		},
+		{
+			q:   `SELECT count(value) FROM cpu WHERE time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T02:00:00Z' GROUP BY time(2h)`,
+			exp: `{"series":[{"name":"cpu","columns":["time","count"],"values":[["2000-01-01T00:00:00Z",2]]}]}`,
+		},
+		{
+			q:   `SELECT max(value) FROM cpu WHERE value < 15 AND time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T02:00:00Z' GROUP BY time(2h)`,
+			exp: `{"series":[{"name":"cpu","columns":["time","max"],"values":[["2000-01-01T00:00:00Z",10]]}]}`,
+		},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Errorf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Errorf("%d. unexpected row:\n  exp=%s\n  got=%s", i, tt.exp, s)
+		}
 	}
 }
 
-// Ensure the server can execute a wildcard query and return the data correctly.
-func TestServer_ExecuteWildcardQuery(t *testing.T) {
+// Ensure histograms can be written, read back and merged by queries.
+func TestServer_Histogram(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
-	s.CreateUser("susy", "pass", false)
 
-	// Write series with one point to the database.
-	// We deliberatly write one value per insert as we need to create each field in a predicatable order for testing.
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(10)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"val-x": 20}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"value": 30, "val-x": 40}}})
+	tags := map[string]string{"host": "serverA"}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "req", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"latency": influxql.Buckets{10: 8, 100: 2}}},
+		{Name: "req", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"latency": influxql.Buckets{10: 2, 100: 6, math.Inf(1): 2}}},
+	})
 
-	// Select * (wildcard).
-	results := s.ExecuteQuery(MustParseQuery(`SELECT * FROM cpu`), "foo", nil)
+	// Verify a number cannot be written to the field.
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "req", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"latency": float64(5)}}}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Verify the histogram is read back exactly.
+	if v, err := s.ReadSeries("foo", "raw", "req", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"latency": influxql.Buckets{10: 8, 100: 2}}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+
+	// Verify histograms are merged across series before percentiles are estimated.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT histogram(latency) FROM req`), "foo", nil)
 	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during SELECT *: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value","val-x"],"values":[["2000-01-01T00:00:00Z",10,0],["2000-01-01T00:00:10Z",0,20],["2000-01-01T00:00:20Z",30,40]]}]}` {
-		t.Fatalf("unexpected results during SELECT *: %s", s)
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"req","columns":["time","histogram"],"values":[["1970-01-01T00:00:00Z",{"10":10,"100":8,"+Inf":2}]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+	results = s.ExecuteQuery(MustParseQuery(`SELECT histogram_percentile(latency, 75) FROM req`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"req","columns":["time","histogram_percentile"],"values":[["1970-01-01T00:00:00Z",66.25]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
 }
 
-// Ensure the server can execute a wildcard GROUP BY
-func TestServer_ExecuteWildcardGroupBy(t *testing.T) {
+// Ensure a query can aggregate several fields with different functions.
+func TestServer_ExecuteQuery_MultipleAggregates(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
-	s.CreateUser("susy", "pass", false)
-
-	// Write series with one point to the database.
-	// We deliberatly write one value per insert as we need to create each field in a predicatable order for testing.
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(10)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": 20}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"value": 30}}})
 
-	// GROUP BY * (wildcard).
-	results := s.ExecuteQuery(MustParseQuery(`SELECT mean(value) FROM cpu GROUP BY *`), "foo", nil)
+	// Fields are written at different times so some intervals are missing some fields.
+	serverA, serverB := map[string]string{"host": "serverA"}, map[string]string{"host": "serverB"}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: serverA, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"a": float64(10), "b": float64(1)}},
+		{Name: "cpu", Tags: serverA, Timestamp: mustParseTime("2000-01-01T00:00:30Z"), Fields: map[string]interface{}{"a": float64(20), "c": float64(5)}},
+		{Name: "cpu", Tags: serverA, Timestamp: mustParseTime("2000-01-01T00:01:00Z"), Fields: map[string]interface{}{"b": float64(3)}},
+		{Name: "cpu", Tags: serverA, Timestamp: mustParseTime("2000-01-01T00:02:00Z"), Fields: map[string]interface{}{"c": float64(7)}},
+		{Name: "cpu", Tags: serverB, Timestamp: mustParseTime("2000-01-01T00:01:00Z"), Fields: map[string]interface{}{"a": float64(100), "c": float64(1)}},
+	})
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT mean(a), max(b), count(c) FROM cpu WHERE time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T00:03:00Z' GROUP BY time(1m)`), "foo", nil)
 	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during GROUP BY *: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",15]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",30]]}]}` {
-		t.Fatalf("unexpected results during SELECT *: %s", s)
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","mean","max","count"],"values":[["2000-01-01T00:00:00Z",15,1,1],["2000-01-01T00:01:00Z",100,3,1],["2000-01-01T00:02:00Z",null,null,1]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
 
-	// GROUP BY * (wildcard) with time.
-	results = s.ExecuteQuery(MustParseQuery(`SELECT mean(value) FROM cpu GROUP BY *,time(1m)`), "foo", nil)
+	results = s.ExecuteQuery(MustParseQuery(`SELECT count(c), max(b), mean(a) FROM cpu WHERE time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T00:03:00Z' GROUP BY time(1m), host`), "foo", nil)
 	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during GROUP BY *: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["2000-01-01T00:00:00Z",15]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["2000-01-01T00:00:00Z",30]]}]}` {
-		t.Fatalf("unexpected results during SELECT *: %s", s)
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","count","max","mean"],"values":[["2000-01-01T00:00:00Z",1,1,15],["2000-01-01T00:01:00Z",0,3,null],["2000-01-01T00:02:00Z",1,null,null]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","count","max","mean"],"values":[["2000-01-01T00:01:00Z",1,null,100]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
 }
 
-func TestServer_CreateShardGroupIfNotExist(t *testing.T) {
+// Ensure wildcards can select fields, group by tags and aggregate numeric fields.
+func TestServer_ExecuteQuery_TypedWildcards(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
-		t.Fatal(err)
+	// Add one field per write so the fields are created in order.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"idle": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"idle": float64(20), "state": "ok"}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"idle": float64(30), "state": "ok", "user": float64(70)}}})
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT *::field FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","idle","state","user"],"values":[["2000-01-01T00:00:00Z",10,0,0],["2000-01-01T00:00:10Z",20,"ok",0],["2000-01-01T00:00:20Z",30,"ok",70]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
 
-	if err := s.CreateShardGroupIfNotExists("foo", "bar", time.Time{}); err != nil {
-		t.Fatal(err)
+	// Selected tags are returned with each series.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT *::tag, idle FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","idle"],"values":[["2000-01-01T00:00:00Z",10],["2000-01-01T00:00:10Z",20]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","idle"],"values":[["2000-01-01T00:00:20Z",30]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
 
-	// Restart the server to ensure the shard group is not lost.
-	s.Restart()
+	// Aggregates over all fields skip non-numeric fields.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT mean(*) FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","mean_idle","mean_user"],"values":[["1970-01-01T00:00:00Z",20,70]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
 
-	if a, err := s.ShardGroups("foo"); err != nil {
-		t.Fatal(err)
-	} else if len(a) != 1 {
-		t.Fatalf("expected 1 shard group but found %d", len(a))
+	// Tags can't be selected without a field.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT *::tag FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err == nil || res.Err.Error() != `at least one field is required: SELECT *::tag FROM "foo"."raw"."cpu"` {
+		t.Fatalf("unexpected error: %v", res.Err)
 	}
 }
 
-func TestServer_DeleteShardGroup(t *testing.T) {
+// Ensure a query is stopped once its result is no longer wanted.
+func TestServer_ExecuteQuery_Closing(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(10)}}})
 
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
-		t.Fatal(err)
+	// The query runs while the channel is open.
+	closing := make(chan struct{})
+	results := s.ExecuteQueryWithOptions(MustParseQuery(`SELECT value FROM cpu`), "foo", nil, influxdb.QueryOptions{Closing: closing})
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",10]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
 
-	if err := s.CreateShardGroupIfNotExists("foo", "bar", time.Time{}); err != nil {
-		t.Fatal(err)
+	// Statements aren't run once it is closed.
+	close(closing)
+	results = s.ExecuteQueryWithOptions(MustParseQuery(`SELECT value FROM cpu`), "foo", nil, influxdb.QueryOptions{Closing: closing})
+	if err := results.Results[0].Err; err != influxql.ErrQueryInterrupted {
+		t.Fatalf("unexpected error: %v", err)
 	}
+}
 
-	// Get the new shard's ID.
-	var g []*influxdb.ShardGroup
-	g, err := s.ShardGroups("foo")
-	if err != nil {
-		t.Fatal(err)
-	} else if len(g) != 1 {
-		t.Fatalf("expected 1 shard group but found %d", len(g))
+// Ensure GROUP BY queries over the group limit fail or are truncated.
+func TestServer_ExecuteQuery_MaxGroups(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(20)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverC", "region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(30)}},
+	})
+	s.MaxGroups = 2
+
+	// Grouping by region stays within the limit.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY time(1m), region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 2 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
 	}
-	id := g[0].ID
 
-	// Delete the shard group and verify it's gone.
-	if err := s.DeleteShardGroup("foo", "bar", id); err != nil {
-		t.Fatal(err)
+	// Grouping by host and region has too many groups.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY time(1m), host, region`), "foo", nil)
+	if res := results.Results[0]; res.Err != influxdb.ErrTooManyGroups {
+		t.Fatalf("unexpected error: %v", res.Err)
 	}
-	g, err = s.ShardGroups("foo")
-	if err != nil {
-		t.Fatal(err)
-	} else if len(g) != 0 {
-		t.Fatalf("expected 0 shard group but found %d", len(g))
+
+	// The first groups are returned if truncation is enabled.
+	s.TruncateGroups = true
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY time(1m), host, region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"serverA","region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",10]]},{"name":"cpu","tags":{"host":"serverB","region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",20]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
 }
 
-/* TODO(benbjohnson): Change test to not expose underlying series ids directly.
-func TestServer_Measurements(t *testing.T) {
+// Ensure the server can execute a query and return the data correctly.
+func TestServer_ExecuteQuery(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
-	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 	s.CreateUser("susy", "pass", false)
 
 	// Write series with one point to the database.
-	timestamp := mustParseTime("2000-01-01T00:00:00Z")
-
-	tags := map[string]string{"host": "servera.influx.com", "region": "uswest"}
-	values := map[string]interface{}{"value": 23.2}
-
-	index, err := s.WriteSeries("foo", "mypolicy", []influxdb.Point{influxdb.Point{Name: "cpu_load", Tags: tags, Timestamp: timestamp, Fields: values}})
-	if err != nil {
-		t.Fatal(err)
-	} else if err = s.Sync(index); err != nil {
-		t.Fatalf("sync error: %s", err)
-	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
+
+	// Select data from the server.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY time(10s), region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 2 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",20],["2000-01-01T00:00:10Z",30]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",100]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Simple non-aggregation.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01 00:00:05'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during simple SELECT: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:10Z",30]]}]}` {
+		t.Fatalf("unexpected row(0) during simple SELECT: %s", s)
+	}
+
+	// Sum aggregation.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01 00:00:05' GROUP BY time(10s), region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during SUM: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:10Z",30]]}]}` {
+		t.Fatalf("unexpected row(0) during SUM: %s", s)
+	}
+
+	// Aggregation with a null field value
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:03Z"), Fields: map[string]interface{}{"otherVal": float64(20)}}})
+	// Sum aggregation.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during SUM: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",50]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",100]]}]}` {
+		t.Fatalf("unexpected row(0) during SUM: %s", s)
+	}
+
+	// WHERE with AND
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "uk", "host": "serverZ", "service": "redis"}, Timestamp: mustParseTime("2000-01-01T00:00:03Z"), Fields: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "uk", "host": "serverZ", "service": "mysql"}, Timestamp: mustParseTime("2000-01-01T00:00:03Z"), Fields: map[string]interface{}{"value": float64(30)}}})
+	// Sum aggregation.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE region='uk' AND host='serverZ'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during SUM: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",50]]}]}` {
+		t.Fatalf("unexpected row(0) during SUM AND: %s", s)
+	}
+
+	// TODO re-enable. The following code is racy
+	//results = s.ExecuteQuery(MustParseQuery(`SELECT * FROM cpu WHERE region='uk' AND host='serverZ'`), "foo", nil)
+	//if res := results.Results[0]; res.Err != nil {
+	//t.Fatalf("unexpected error during SUM: %s", res.Err)
+	//} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value","otherVal"],"values":[["2000-01-01T00:00:03Z",30,0],["2000-01-01T00:00:03Z",20,0]]}]}` {
+	//t.Fatalf("unexpected row(0) during SUM AND: %s", s)
+	//}
+
+	// Select that should return an empty result.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '3000-01-01 00:00:05'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during simple SELECT: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{}` {
+		t.Fatalf("unexpected row(0) during simple SELECT: %s", s)
+	}
+}
+
+// Ensure the server respects limit and offset in show series queries
+func TestServer_ShowSeriesLimitOffset(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write series with one point to the database.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east", "host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east", "host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west", "host": "serverC"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "memory", Tags: map[string]string{"region": "us-west", "host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "memory", Tags: map[string]string{"region": "us-east", "host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
+
+	// Select data from the server.
+	results := s.ExecuteQuery(MustParseQuery(`SHOW SERIES LIMIT 3 OFFSET 1`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 2 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["id","host","region"],"values":[[2,"serverB","us-east"],[3,"serverC","us-west"]]},{"name":"memory","columns":["id","host","region"],"values":[[4,"serverB","us-west"]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Select data from the server.
+	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES LIMIT 2 OFFSET 4`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 1 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"memory","columns":["id","host","region"],"values":[[5,"serverA","us-east"]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Select data from the server.
+	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES LIMIT 2 OFFSET 20`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 0 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
+	}
+
+	// Select data from the server.
+	results = s.ExecuteQuery(MustParseQuery(`SHOW SERIES LIMIT 20`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 2 {
+		t.Fatalf("unexpected row count: %d", len(res.Series))
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["id","host","region"],"values":[[1,"serverA","us-east"],[2,"serverB","us-east"],[3,"serverC","us-west"]]},{"name":"memory","columns":["id","host","region"],"values":[[4,"serverB","us-west"],[5,"serverA","us-east"]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+}
+
+// Ensure that when querying for raw data values that they return in time order
+func TestServer_RawDataReturnsInOrder(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+
+	for i := 1; i < 500; i++ {
+		host := fmt.Sprintf("server-%d", i%10)
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east", "host": host}, Timestamp: time.Unix(int64(i), 0), Fields: map[string]interface{}{"value": float64(i)}}})
+	}
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during COUNT: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","count"],"values":[["1970-01-01T00:00:00Z",499]]}]}` {
+		t.Fatalf("unexpected row(0) during COUNT: %s", s)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
+
+	lastTime := int64(0)
+	for _, v := range results.Results[0].Series[0].Values {
+		tt := v[0].(time.Time)
+		if lastTime > tt.UnixNano() {
+			t.Fatal("values out of order")
+		}
+		lastTime = tt.UnixNano()
+	}
+	if len(results.Results[0].Series[0].Values) != 499 {
+		t.Fatal("expected 499 values")
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu GROUP BY *`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during GROUP BY *: %s", res.Err)
+	} else if len(res.Series) != 10 {
+		t.Fatalf("expected 10 series back but got %d", len(res.Series))
+	} else if len(res.Series[0].Values) != 50 {
+		t.Fatalf("expected 50 values per series but got %d", len(res.Series[0].Values))
+	}
+}
+
+// Ensure that limit and offset work
+func TestServer_LimitAndOffset(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	for i := 1; i < 10; i++ {
+		host := fmt.Sprintf("server-%d", i)
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east", "host": host}, Timestamp: time.Unix(int64(i), 0), Fields: map[string]interface{}{"value": float64(i)}}})
+	}
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 20`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during COUNT: %s", res.Err)
+	} else if len(res.Series) != 9 {
+		t.Fatalf("unexpected 9 series back but got %d", len(res.Series))
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 2 OFFSET 1`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during COUNT: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"server-2","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]},{"name":"cpu","tags":{"host":"server-3","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]}]}` {
+		t.Fatalf("unexpected row(0) during COUNT: %s", s)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 2 OFFSET 3`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during COUNT: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"server-5","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]},{"name":"cpu","tags":{"host":"server-4","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]}]}` {
+		t.Fatalf("unexpected row(0) during COUNT: %s", s)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 3 OFFSET 8`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during COUNT: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"server-9","region":"us-east"},"columns":["time","count"],"values":[["1970-01-01T00:00:00Z",1]]}]}` {
+		t.Fatalf("unexpected row(0) during COUNT: %s", s)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu GROUP BY * LIMIT 3 OFFSET 20`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during COUNT: %s", res.Err)
+	}
+}
+
+// Ensure the server can execute a wildcard query and return the data correctly.
+func TestServer_ExecuteWildcardQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+
+	// Write series with one point to the database.
+	// We deliberatly write one value per insert as we need to create each field in a predicatable order for testing.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"val-x": 20}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"value": 30, "val-x": 40}}})
+
+	// Select * (wildcard).
+	results := s.ExecuteQuery(MustParseQuery(`SELECT * FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during SELECT *: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value","val-x"],"values":[["2000-01-01T00:00:00Z",10,0],["2000-01-01T00:00:10Z",0,20],["2000-01-01T00:00:20Z",30,40]]}]}` {
+		t.Fatalf("unexpected results during SELECT *: %s", s)
+	}
+}
+
+// Ensure the server can execute a wildcard GROUP BY
+func TestServer_ExecuteWildcardGroupBy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+
+	// Write series with one point to the database.
+	// We deliberatly write one value per insert as we need to create each field in a predicatable order for testing.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": 20}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"value": 30}}})
+
+	// GROUP BY * (wildcard).
+	results := s.ExecuteQuery(MustParseQuery(`SELECT mean(value) FROM cpu GROUP BY *`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during GROUP BY *: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",15]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",30]]}]}` {
+		t.Fatalf("unexpected results during SELECT *: %s", s)
+	}
+
+	// GROUP BY * (wildcard) with time.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT mean(value) FROM cpu GROUP BY *,time(1m)`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during GROUP BY *: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["2000-01-01T00:00:00Z",15]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["2000-01-01T00:00:00Z",30]]}]}` {
+		t.Fatalf("unexpected results during SELECT *: %s", s)
+	}
+}
+
+func TestServer_CreateShardGroupIfNotExist(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CreateShardGroupIfNotExists("foo", "bar", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart the server to ensure the shard group is not lost.
+	s.Restart()
+
+	if a, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 {
+		t.Fatalf("expected 1 shard group but found %d", len(a))
+	}
+}
+
+func TestServer_DeleteShardGroup(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CreateShardGroupIfNotExists("foo", "bar", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Get the new shard's ID.
+	var g []*influxdb.ShardGroup
+	g, err := s.ShardGroups("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(g) != 1 {
+		t.Fatalf("expected 1 shard group but found %d", len(g))
+	}
+	id := g[0].ID
+
+	// Delete the shard group and verify it's gone.
+	if err := s.DeleteShardGroup("foo", "bar", id); err != nil {
+		t.Fatal(err)
+	}
+	g, err = s.ShardGroups("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(g) != 0 {
+		t.Fatalf("expected 0 shard group but found %d", len(g))
+	}
+}
+
+// Ensure the server stops streaming the topics of shards that are removed.
+func TestServer_DeleteShardGroup_Unsubscribe(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour})
+	s.CreateShardGroupIfNotExists("foo", "bar", mustParseTime("2000-01-01T00:00:00Z"))
+	s.CreateShardGroupIfNotExists("foo", "bar", mustParseTime("2000-01-02T00:00:00Z"))
+
+	// Track unsubscribed topics.
+	var topics []uint64
+	id := s.ID()
+	c.UnsubscribeFunc = func(replicaID, topicID uint64) error {
+		if replicaID != id {
+			t.Fatalf("unexpected replica: %d", replicaID)
+		}
+		topics = append(topics, topicID)
+		return nil
+	}
+
+	// Deleting the shard group unsubscribes from its shard.
+	g, _ := s.ShardGroups("foo")
+	if len(g) != 2 {
+		t.Fatalf("unexpected shard group count: %d", len(g))
+	} else if err := s.DeleteShardGroup("foo", "bar", g[0].ID); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(topics, []uint64{g[0].Shards[0].ID}) {
+		t.Fatalf("unexpected topics: %v", topics)
+	}
+
+	// Dropping the database unsubscribes from its remaining shards.
+	if err := s.DropDatabase("foo"); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(topics, []uint64{g[0].Shards[0].ID, g[1].Shards[0].ID}) {
+		t.Fatalf("unexpected topics: %v", topics)
+	}
+}
+
+/* TODO(benbjohnson): Change test to not expose underlying series ids directly.
+func TestServer_Measurements(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 1 * time.Hour})
+	s.CreateUser("susy", "pass", false)
+
+	// Write series with one point to the database.
+	timestamp := mustParseTime("2000-01-01T00:00:00Z")
+
+	tags := map[string]string{"host": "servera.influx.com", "region": "uswest"}
+	values := map[string]interface{}{"value": 23.2}
+
+	index, err := s.WriteSeries("foo", "mypolicy", []influxdb.Point{influxdb.Point{Name: "cpu_load", Tags: tags, Timestamp: timestamp, Fields: values}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
 
 	expectedMeasurementNames := []string{"cpu_load"}
 	expectedSeriesIDs := influxdb.SeriesIDs([]uint32{uint32(1)})
@@ -1633,528 +2798,1907 @@ func TestServer_Measurements(t *testing.T) {
 	if !reflect.DeepEqual(names, expectedMeasurementNames) {
 		t.Fatalf("Mesurements not the same:\n  exp: %s\n  got: %s", expectedMeasurementNames, names)
 	}
-	ids := s.MeasurementSeriesIDs("foo", "foo")
-	if !reflect.DeepEqual(ids, expectedSeriesIDs) {
-		t.Fatalf("Series IDs not the same:\n  exp: %v\n  got: %v", expectedSeriesIDs, ids)
+	ids := s.MeasurementSeriesIDs("foo", "foo")
+	if !reflect.DeepEqual(ids, expectedSeriesIDs) {
+		t.Fatalf("Series IDs not the same:\n  exp: %v\n  got: %v", expectedSeriesIDs, ids)
+	}
+
+	s.Restart()
+
+	names = s.MeasurementNames("foo")
+	if !reflect.DeepEqual(names, expectedMeasurementNames) {
+		t.Fatalf("Mesurements not the same:\n  exp: %s\n  got: %s", expectedMeasurementNames, names)
+	}
+	ids = s.MeasurementSeriesIDs("foo", "foo")
+	if !reflect.DeepEqual(ids, expectedSeriesIDs) {
+		t.Fatalf("Series IDs not the same:\n  exp: %v\n  got: %v", expectedSeriesIDs, ids)
+	}
+}
+*/
+
+// Ensure the server can convert a measurement into its normalized form.
+func TestServer_NormalizeMeasurement(t *testing.T) {
+	var tests = []struct {
+		in  string // input string
+		db  string // current database
+		out string // normalized string
+		err string // error, if any
+	}{
+		{in: `cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
+		{in: `"".cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
+		{in: `"rp0".cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
+		{in: `""."".cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
+		{in: `""..cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
+
+		{in: `"db1"..cpu`, db: `db0`, out: `"db1"."rp1"."cpu"`},
+		{in: `"db1"."rp1".cpu`, db: `db0`, out: `"db1"."rp1"."cpu"`},
+		{in: `"db1"."rp2".cpu`, db: `db0`, out: `"db1"."rp2"."cpu"`},
+
+		{in: ``, err: `invalid measurement: `},
+		{in: `"foo"."bar"."baz"."bat"`, err: `invalid measurement: "foo"."bar"."baz"."bat"`},
+		{in: `"no_db"..cpu`, db: ``, err: `database not found: no_db`},
+		{in: `"db2"..cpu`, db: ``, err: `default retention policy not set for: db2`},
+		{in: `"db2"."no_policy".cpu`, db: ``, err: `retention policy does not exist: db2.no_policy`},
+	}
+
+	// Create server with a variety of databases, retention policies, and measurements
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Default database with one policy.
+	s.CreateDatabase("db0")
+	s.CreateRetentionPolicy("db0", &influxdb.RetentionPolicy{Name: "rp0"})
+	s.SetDefaultRetentionPolicy("db0", "rp0")
+
+	// Another database with two policies.
+	s.CreateDatabase("db1")
+	s.CreateRetentionPolicy("db1", &influxdb.RetentionPolicy{Name: "rp1"})
+	s.CreateRetentionPolicy("db1", &influxdb.RetentionPolicy{Name: "rp2"})
+	s.SetDefaultRetentionPolicy("db1", "rp1")
+
+	// Another database with no policies.
+	s.CreateDatabase("db2")
+
+	// Execute the tests
+	for i, tt := range tests {
+		out, err := s.NormalizeMeasurement(tt.in, tt.db)
+		if tt.err != errstr(err) {
+			t.Errorf("%d. %s/%s: error: exp: %s, got: %s", i, tt.db, tt.in, tt.err, errstr(err))
+		} else if tt.out != out {
+			t.Errorf("%d. %s/%s: out: exp: %s, got: %s", i, tt.db, tt.in, tt.out, out)
+		}
+	}
+}
+
+// Ensure the server can normalize all statements in query.
+func TestServer_NormalizeQuery(t *testing.T) {
+	var tests = []struct {
+		in  string // input query
+		db  string // default database
+		out string // output query
+		err string // error, if any
+	}{
+		{
+			in: `SELECT cpu.value FROM cpu`, db: `db0`,
+			out: `SELECT "db0"."rp0"."cpu"."value" FROM "db0"."rp0"."cpu"`,
+		},
+
+		{
+			in: `SELECT value FROM cpu`, db: `no_db`, err: `database not found: no_db`,
+		},
+	}
+
+	// Start server with database & retention policy.
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("db0")
+	s.CreateRetentionPolicy("db0", &influxdb.RetentionPolicy{Name: "rp0"})
+	s.SetDefaultRetentionPolicy("db0", "rp0")
+
+	// Execute the tests
+	for i, tt := range tests {
+		out := MustParseQuery(tt.in)
+		err := s.NormalizeStatement(out.Statements[0], tt.db)
+		if tt.err != errstr(err) {
+			t.Errorf("%d. %s/%s: error: exp: %s, got: %s", i, tt.db, tt.in, tt.err, errstr(err))
+		} else if err == nil && tt.out != out.String() {
+			t.Errorf("%d. %s/%s:\n\nexp: %s\n\ngot: %s\n\n", i, tt.db, tt.in, tt.out, out.String())
+		}
+	}
+}
+
+// Ensure queries seek to the start of their time range instead of reading
+// a series from its first point.
+func TestServer_ExecuteQuery_SeekTimeRange(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	var points []influxdb.Point
+	for i := 0; i < 1000; i++ {
+		points = append(points, influxdb.Point{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i) * time.Second), Fields: map[string]interface{}{"value": float64(i)}})
+	}
+	s.MustWriteSeries("foo", "raw", points)
+
+	for _, q := range []string{
+		`SELECT value FROM cpu WHERE time >= '2000-01-01T00:16:00Z' AND time < '2000-01-01T00:16:05Z'`,
+		`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01T00:16:00Z' AND time < '2000-01-01T00:16:05Z'`,
+	} {
+		results := s.ExecuteQueryWithStats(MustParseQuery(q), "foo", nil)
+		if err := results.Error(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		} else if st := results.Results[0].Stats; st.PointsScanned != 5 {
+			t.Fatalf("unexpected points scanned: %s: %d", q, st.PointsScanned)
+		}
+	}
+}
+
+// Ensure the server can report the execution statistics of each statement.
+func TestServer_ExecuteQueryWithStats(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	index, err := s.WriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(20)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(30)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	q := `SELECT value FROM cpu WHERE time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T00:01:00Z' GROUP BY host; SHOW MEASUREMENTS`
+	results := s.ExecuteQueryWithStats(MustParseQuery(q), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Verify the select statement's statistics.
+	if st := results.Results[0].Stats; st == nil {
+		t.Fatal("expected stats")
+	} else if st.SeriesN != 2 || st.RowsN != 3 || st.PointsScanned != 3 {
+		t.Fatalf("unexpected stats: %#v", st)
+	} else if st.ExecutionTime <= 0 {
+		t.Fatalf("unexpected execution time: %s", st.ExecutionTime)
+	}
+
+	// Verify other statements report the rows they return.
+	if st := results.Results[1].Stats; st == nil {
+		t.Fatal("expected stats")
+	} else if st.SeriesN != 1 || st.RowsN != 1 || st.PointsScanned != 0 {
+		t.Fatalf("unexpected stats: %#v", st)
+	}
+
+	// Verify statistics are not set by default.
+	results = s.ExecuteQuery(MustParseQuery(q), "foo", nil)
+	if results.Results[0].Stats != nil {
+		t.Fatalf("unexpected stats: %#v", results.Results[0].Stats)
+	}
+}
+
+// Ensure the server can store, list and drop named queries.
+func TestServer_StoredQueries(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateDatabase("bar")
+
+	// Store queries in the default database and in another database.
+	results := s.ExecuteQuery(MustParseQuery(`CREATE QUERY host_cpu AS SELECT mean(value) FROM cpu WHERE host = $host; CREATE QUERY all_cpu ON bar AS SELECT value FROM cpu`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.CreateStoredQuery("foo", "host_cpu", "SELECT value FROM cpu"); err != influxdb.ErrStoredQueryExists {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.CreateStoredQuery("foo", "drop_cpu", "DROP MEASUREMENT cpu"); err != influxdb.ErrInvalidQuery {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Verify the queries are persisted.
+	s.Restart()
+	results = s.ExecuteQuery(MustParseQuery(`SHOW QUERIES`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"foo","columns":["name","query","parameters"],"values":[["host_cpu","SELECT mean(value) FROM cpu WHERE host = $host",["host"]]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+	if a, err := s.StoredQueries("bar"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || a[0].Name != "all_cpu" {
+		t.Fatalf("unexpected queries: %#v", a)
+	}
+
+	// Verify the query's parameters must be bound to run it directly.
+	q, err := s.StoredQuery("foo", "host_cpu")
+	if err != nil {
+		t.Fatal(err)
+	} else if res := s.ExecuteQuery(MustParseQuery(q.Query), "foo", nil); res.Error() == nil || res.Error().Error() != `missing value for parameter $host` {
+		t.Fatalf("unexpected error: %v", res.Error())
+	}
+	if query, err := q.Bind(map[string]interface{}{"host": "serverA"}); err != nil {
+		t.Fatal(err)
+	} else if query.String() != `SELECT mean(value) FROM cpu WHERE host = 'serverA'` {
+		t.Fatalf("unexpected query: %s", query)
+	}
+
+	// Drop a query.
+	results = s.ExecuteQuery(MustParseQuery(`DROP QUERY host_cpu`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if _, err := s.StoredQuery("foo", "host_cpu"); err != influxdb.ErrStoredQueryNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.DropStoredQuery("foo", "host_cpu"); err != influxdb.ErrStoredQueryNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server can create a continuous query
+func TestServer_CreateContinuousQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create the "foo" database.
+	if err := s.CreateDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetDefaultRetentionPolicy("foo", "bar")
+
+	// create and check
+	q := "CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT count() INTO measure1 FROM myseries GROUP BY time(10m) END"
+	stmt, err := influxql.NewParser(strings.NewReader(q)).ParseStatement()
+	if err != nil {
+		t.Fatalf("error parsing query %s", err.Error())
+	}
+	cq := stmt.(*influxql.CreateContinuousQueryStatement)
+	if err := s.CreateContinuousQuery(cq); err != nil {
+		t.Fatalf("error creating continuous query %s", err.Error())
+	}
+
+	queries := s.ContinuousQueries("foo")
+	cqObj, _ := influxdb.NewContinuousQuery(q)
+	expected := []*influxdb.ContinuousQuery{cqObj}
+	if mustMarshalJSON(expected) != mustMarshalJSON(queries) {
+		t.Fatalf("query not saved:\n\texp: %s\n\tgot: %s", mustMarshalJSON(expected), mustMarshalJSON(queries))
+	}
+	s.Restart()
+
+	// check again
+	queries = s.ContinuousQueries("foo")
+	if !reflect.DeepEqual(queries, expected) {
+		t.Fatalf("query not saved:\n\texp: %s\ngot: %s", mustMarshalJSON(expected), mustMarshalJSON(queries))
+	}
+}
+
+// Ensure the server prevents a duplicate named continuous query from being created
+func TestServer_CreateContinuousQuery_ErrContinuousQueryExists(t *testing.T) {
+	t.Skip("pending")
+}
+
+// Ensure the server returns an error when creating a continuous query on a database that doesn't exist
+func TestServer_CreateContinuousQuery_ErrDatabaseNotFound(t *testing.T) {
+	t.Skip("pending")
+}
+
+// Ensure the server returns an error when creating a continuous query on a retention policy that doesn't exist
+func TestServer_CreateContinuousQuery_ErrRetentionPolicyNotFound(t *testing.T) {
+	t.Skip("pending")
+}
+
+func TestServer_CreateContinuousQuery_ErrInfinteLoop(t *testing.T) {
+	t.Skip("pending")
+}
+
+// Ensure
+func TestServer_RunContinuousQueries(t *testing.T) {
+	t.Skip()
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create the "foo" database.
+	if err := s.CreateDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.RecomputePreviousN = 50
+	s.RecomputeNoOlderThan = time.Second
+	s.ComputeRunsPerInterval = 5
+	s.ComputeNoMoreThan = 2 * time.Millisecond
+
+	// create cq and check
+	q := `CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT mean(value) INTO cpu_region FROM cpu GROUP BY time(5ms), region END`
+	stmt, err := influxql.NewParser(strings.NewReader(q)).ParseStatement()
+	if err != nil {
+		t.Fatalf("error parsing query %s", err.Error())
+	}
+	cq := stmt.(*influxql.CreateContinuousQueryStatement)
+	if err := s.CreateContinuousQuery(cq); err != nil {
+		t.Fatalf("error creating continuous query %s", err.Error())
+	}
+	if err := s.RunContinuousQueries(); err != nil {
+		t.Fatalf("error running cqs when no data exists: %s", err.Error())
+	}
+
+	// set a test time in the middle of a 5 second interval that we can work with
+	testTime := time.Now().UTC().Round(5 * time.Millisecond)
+	if testTime.UnixNano() > time.Now().UnixNano() {
+		testTime = testTime.Add(-5 * time.Millisecond)
+	}
+	testTime.Add(time.Millisecond * 2)
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: testTime, Fields: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: testTime.Add(-time.Millisecond * 5), Fields: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: testTime, Fields: map[string]interface{}{"value": float64(100)}}})
+
+	// Run CQs after a period of time
+	time.Sleep(time.Millisecond * 50)
+	s.RunContinuousQueries()
+	// give the CQs time to run
+	time.Sleep(time.Millisecond * 100)
+
+	verify := func(num int, exp string) {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT mean(mean) FROM cpu_region GROUP BY region`), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("unexpected error verify %d: %s", num, res.Err)
+		} else if len(res.Series) != 2 {
+			t.Fatalf("unexpected row count on verify %d: %d", num, len(res.Series))
+		} else if s := mustMarshalJSON(res); s != exp {
+			t.Fatalf("unexpected row(0) on verify %d: %s", num, s)
+		}
+	}
+
+	// ensure CQ results were saved
+	verify(1, `{"series":[{"name":"cpu_region","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",25]]},{"name":"cpu_region","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",100]]}]}`)
+
+	// ensure that repeated runs don't cause duplicate data
+	s.RunContinuousQueries()
+	verify(2, `{"series":[{"name":"cpu_region","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",25]]},{"name":"cpu_region","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",100]]}]}`)
+
+	// ensure that data written into a previous window is picked up and the result recomputed.
+	time.Sleep(time.Millisecond * 2)
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: testTime.Add(-time.Millisecond), Fields: map[string]interface{}{"value": float64(50)}}})
+	s.RunContinuousQueries()
+	// give CQs time to run
+	time.Sleep(time.Millisecond * 50)
+
+	verify(3, `{"series":[{"name":"cpu_region","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",25]]},{"name":"cpu_region","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",75]]}]}`)
+}
+
+func mustMarshalJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic("marshal: " + err.Error())
+	}
+	return string(b)
+}
+
+func measurementsEqual(l influxdb.Measurements, r influxdb.Measurements) bool {
+	if mustMarshalJSON(l) == mustMarshalJSON(r) {
+		return true
+	}
+	return false
+}
+
+func TestServer_SeriesByTagNames(t *testing.T)  { t.Skip("pending") }
+func TestServer_SeriesByTagValues(t *testing.T) { t.Skip("pending") }
+func TestDatabase_TagNames(t *testing.T)        { t.Skip("pending") }
+func TestServer_TagNamesBySeries(t *testing.T)  { t.Skip("pending") }
+func TestServer_TagValues(t *testing.T)         { t.Skip("pending") }
+func TestServer_TagValuesBySeries(t *testing.T) { t.Skip("pending") }
+
+// Point JSON Unmarshal tests
+
+func TestbatchWrite_UnmarshalEpoch(t *testing.T) {
+	var (
+		now     = time.Now()
+		nanos   = now.UnixNano()
+		micros  = nanos / int64(time.Microsecond)
+		millis  = nanos / int64(time.Millisecond)
+		seconds = nanos / int64(time.Second)
+		minutes = nanos / int64(time.Minute)
+		hours   = nanos / int64(time.Hour)
+	)
+
+	tests := []struct {
+		name  string
+		epoch int64
+	}{
+		{name: "nanos", epoch: nanos},
+		{name: "micros", epoch: micros},
+		{name: "millis", epoch: millis},
+		{name: "seconds", epoch: seconds},
+		{name: "minutes", epoch: minutes},
+		{name: "hours", epoch: hours},
+	}
+
+	for _, test := range tests {
+		json := fmt.Sprintf(`"points": [{timestamp: "%d"}`, test.epoch)
+		log.Println(json)
+		t.Fatal("foo")
+	}
+
+}
+
+// Ensure the server will skip over replayed log entries and not blow up.
+func TestServer_Replay(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+
+	// Record all messages through the client.
+	var messages []*messaging.Message
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		messages = append(messages, m)
+		c.c <- m
+		return m.Index, nil
+	}
+
+	// Create a new node.
+	u, _ := url.Parse("http://localhost:80000")
+	if err := s.CreateDataNode(u); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Replay messages through client.
+	for _, m := range messages {
+		c.c <- m
+	}
+
+	// Sleep so it has a moment to process & ignore.
+	time.Sleep(100 * time.Millisecond)
+
+	// NOTE: There is no way to introspect into the server to see that
+	// messages are being dropped. This test exists to make sure the server
+	// doesn't crash on retry.
+}
+
+// Server is a wrapping test struct for influxdb.Server.
+type Server struct {
+	*influxdb.Server
+}
+
+// NewServer returns a new test server instance.
+func NewServer() *Server {
+	s := influxdb.NewServer()
+	s.SetAuthenticationEnabled(false)
+	return &Server{s}
+}
+
+// OpenServer returns a new, open test server instance.
+func OpenServer(client influxdb.MessagingClient) *Server {
+	s := OpenUninitializedServer(client)
+	if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		panic(err.Error())
+	}
+	return s
+}
+
+// OpenUninitializedServer returns a new, uninitialized, open test server instance.
+func OpenUninitializedServer(client influxdb.MessagingClient) *Server {
+	s := NewServer()
+	if err := s.Open(tempfile()); err != nil {
+		panic(err.Error())
+	}
+	if err := s.SetClient(client); err != nil {
+		panic(err.Error())
+	}
+	return s
+}
+
+// OpenDefaultServer opens a server and creates a default db & retention policy.
+func OpenDefaultServer(client influxdb.MessagingClient) *Server {
+	s := OpenServer(client)
+	if err := s.CreateDatabase("db"); err != nil {
+		panic(err.Error())
+	} else if err = s.CreateRetentionPolicy("db", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour}); err != nil {
+		panic(err.Error())
+	} else if err = s.SetDefaultRetentionPolicy("db", "raw"); err != nil {
+		panic(err.Error())
+	}
+	return s
+}
+
+// Restart stops and restarts the server.
+func (s *Server) Restart() {
+	path, client := s.Path(), s.Client()
+
+	// Stop the server.
+	if err := s.Server.Close(); err != nil {
+		panic("close: " + err.Error())
+	}
+
+	// Open and reset the client.
+	if err := s.Server.Open(path); err != nil {
+		panic("open: " + err.Error())
+	}
+	if err := s.Server.SetClient(client); err != nil {
+		panic("client: " + err.Error())
+	}
+}
+
+// Close shuts down the server and removes all temporary files.
+func (s *Server) Close() {
+	defer os.RemoveAll(s.Path())
+	s.Server.Close()
+}
+
+// MustWriteSeries writes series data and waits for the data to be applied.
+// Returns the messaging index for the write.
+func (s *Server) MustWriteSeries(database, retentionPolicy string, points []influxdb.Point) uint64 {
+	index, err := s.WriteSeries(database, retentionPolicy, points)
+	if err != nil {
+		panic(err.Error())
+	} else if err = s.Sync(index); err != nil {
+		panic("sync error: " + err.Error())
+	}
+	return index
+}
+
+// MessagingClient represents a test client for the messaging broker.
+type MessagingClient struct {
+	index uint64
+	c     chan *messaging.Message
+
+	PublishFunc       func(*messaging.Message) (uint64, error)
+	CreateReplicaFunc func(replicaID uint64, connectURL *url.URL, index uint64) error
+	DeleteReplicaFunc func(replicaID uint64) error
+	SubscribeFunc     func(replicaID, topicID uint64) error
+	UnsubscribeFunc   func(replicaID, topicID uint64) error
+}
+
+// NewMessagingClient returns a new instance of MessagingClient.
+func NewMessagingClient() *MessagingClient {
+	c := &MessagingClient{c: make(chan *messaging.Message, 1)}
+	c.PublishFunc = c.send
+	c.CreateReplicaFunc = func(replicaID uint64, connectURL *url.URL, index uint64) error { return nil }
+	c.DeleteReplicaFunc = func(replicaID uint64) error { return nil }
+	c.SubscribeFunc = func(replicaID, topicID uint64) error { return nil }
+	c.UnsubscribeFunc = func(replicaID, topicID uint64) error { return nil }
+	return c
+}
+
+// Publish attaches an autoincrementing index to the message.
+// This function also execute's the client's PublishFunc mock function.
+func (c *MessagingClient) Publish(m *messaging.Message) (uint64, error) {
+	c.index++
+	m.Index = c.index
+	return c.PublishFunc(m)
+}
+
+// send sends the message through to the channel.
+// This is the default value of PublishFunc.
+func (c *MessagingClient) send(m *messaging.Message) (uint64, error) {
+	c.c <- m
+	return m.Index, nil
+}
+
+// Creates a new replica with a given ID on the broker.
+func (c *MessagingClient) CreateReplicaFrom(replicaID uint64, connectURL *url.URL, index uint64) error {
+	return c.CreateReplicaFunc(replicaID, connectURL, index)
+}
+
+// Deletes an existing replica with a given ID from the broker.
+func (c *MessagingClient) DeleteReplica(replicaID uint64) error {
+	return c.DeleteReplicaFunc(replicaID)
+}
+
+// Subscribe adds a subscription to a replica for a topic on the broker.
+func (c *MessagingClient) Subscribe(replicaID, topicID uint64) error {
+	return c.SubscribeFunc(replicaID, topicID)
+}
+
+// Unsubscribe removes a subscrition from a replica for a topic on the broker.
+func (c *MessagingClient) Unsubscribe(replicaID, topicID uint64) error {
+	return c.UnsubscribeFunc(replicaID, topicID)
+}
+
+// C returns a channel for streaming message.
+func (c *MessagingClient) C() <-chan *messaging.Message { return c.c }
+
+// tempfile returns a temporary path.
+func tempfile() string {
+	f, _ := ioutil.TempFile("", "influxdb-")
+	path := f.Name()
+	f.Close()
+	os.Remove(path)
+	return path
+}
+
+// mustParseTime parses an IS0-8601 string. Panic on error.
+func mustParseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err.Error())
+	}
+	return t
+}
+
+// MustParseQuery parses an InfluxQL query. Panic on error.
+func MustParseQuery(s string) *influxql.Query {
+	q, err := influxql.NewParser(strings.NewReader(s)).ParseQuery()
+	if err != nil {
+		panic(err.Error())
+	}
+	return q
+}
+
+// MustParseSelectStatement parses an InfluxQL select statement. Panic on error.
+func MustParseSelectStatement(s string) *influxql.SelectStatement {
+	stmt, err := influxql.NewParser(strings.NewReader(s)).ParseStatement()
+	if err != nil {
+		panic(err.Error())
+	}
+	return stmt.(*influxql.SelectStatement)
+}
+
+// errstr is an ease-of-use function to convert an error to a string.
+func errstr(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func warn(v ...interface{})              { fmt.Fprintln(os.Stderr, v...) }
+func warnf(msg string, v ...interface{}) { fmt.Fprintf(os.Stderr, msg+"\n", v...) }
+
+// Ensure the server validates points without writing them.
+func TestServer_ValidateSeries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
+
+	v, err := s.ValidateSeries("foo", "", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(1)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(2)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": "bad"}},
+		{Name: "mem", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"used": float64(1)}},
+		{Name: "mem", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"used": true}},
+		{Name: "mem", Timestamp: mustParseTime("3000-01-01T00:00:00Z"), Fields: map[string]interface{}{"used": float64(1)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if s := mustMarshalJSON(v); s != `{"database":"foo","retentionPolicy":"raw","points":6,"newSeries":[{"name":"cpu","tags":{"host":"serverB"}},{"name":"mem"}],"errors":[{"index":2,"error":"field \"value\" is type string, mapped as type number"},{"index":4,"error":"field \"used\" is type boolean, previously written in batch as type number"},{"index":5,"error":"timestamp out of range: 3000-01-01T00:00:00Z"}]}` {
+		t.Fatalf("unexpected validation: %s", s)
+	}
+
+	// Verify nothing was written.
+	if a := s.MeasurementNames("foo"); len(a) != 1 {
+		t.Fatalf("unexpected measurements: %v", a)
+	}
+
+	// Verify an unknown database is an error.
+	if _, err := s.ValidateSeries("bar", "", nil); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server rejects NaN and infinite field values.
+func TestServer_WriteSeries_NonFinite(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		points := []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": v}}}
+		if _, err := s.WriteSeries("foo", "raw", points); err != influxdb.ErrNonFiniteFieldValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		v, err := s.ValidateSeries("foo", "raw", points)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(v.Errors) != 1 || v.Errors[0].Err != `field "value": `+influxdb.ErrNonFiniteFieldValue.Error() {
+			t.Fatalf("unexpected validation: %s", mustMarshalJSON(v))
+		}
+	}
+
+	if a := s.MeasurementNames("foo"); len(a) != 0 {
+		t.Fatalf("unexpected measurements: %v", a)
+	}
+}
+
+// Ensure the server rejects blank and "time" tag and field keys.
+func TestServer_WriteSeries_ReservedKey(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	points := []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"TIME": float64(1)}}}
+	if _, err := s.WriteSeries("foo", "raw", points); err == nil || err.Error() != `point 0: invalid key "TIME": `+influxdb.ErrReservedKey.Error() {
+		t.Fatalf("unexpected error: %v", err)
 	}
+	if v, err := s.ValidateSeries("foo", "raw", points); err != nil {
+		t.Fatal(err)
+	} else if len(v.Errors) != 1 || v.Errors[0].Err != `key "TIME": `+influxdb.ErrReservedKey.Error() {
+		t.Fatalf("unexpected validation: %s", mustMarshalJSON(v))
+	}
+}
 
-	s.Restart()
+// Ensure the server rejects points too far in the future when configured to.
+func TestServer_WriteSeries_MaxFutureTime(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MaxFutureTime = 10 * time.Minute
 
-	names = s.MeasurementNames("foo")
-	if !reflect.DeepEqual(names, expectedMeasurementNames) {
-		t.Fatalf("Mesurements not the same:\n  exp: %s\n  got: %s", expectedMeasurementNames, names)
+	points := []influxdb.Point{
+		{Name: "cpu", Timestamp: time.Now().Add(5 * time.Minute), Fields: map[string]interface{}{"value": float64(1)}},
+		{Name: "cpu", Timestamp: mustParseTime("2100-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}},
 	}
-	ids = s.MeasurementSeriesIDs("foo", "foo")
-	if !reflect.DeepEqual(ids, expectedSeriesIDs) {
-		t.Fatalf("Series IDs not the same:\n  exp: %v\n  got: %v", expectedSeriesIDs, ids)
+	if _, err := s.WriteSeries("foo", "raw", points); err == nil || err.Error() != `point 1: invalid timestamp 2100-01-01T00:00:00Z: `+influxdb.ErrTimestampInFuture.Error() {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, err := s.ValidateSeries("foo", "raw", points); err != nil {
+		t.Fatal(err)
+	} else if len(v.Errors) != 1 || v.Errors[0].Index != 1 || v.Errors[0].Err != `timestamp 2100-01-01T00:00:00Z: `+influxdb.ErrTimestampInFuture.Error() {
+		t.Fatalf("unexpected validation: %s", mustMarshalJSON(v))
 	}
+
+	// Points up to the limit can be written.
+	s.MustWriteSeries("foo", "raw", points[:1])
 }
-*/
 
-// Ensure the server can convert a measurement into its normalized form.
-func TestServer_NormalizeMeasurement(t *testing.T) {
-	var tests = []struct {
-		in  string // input string
-		db  string // current database
-		out string // normalized string
-		err string // error, if any
+// Ensure shard, series and retention policy metadata can be queried from system measurements.
+func TestServer_SystemMeasurements(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "other", Duration: 2 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}},
+		{Name: "cpu", Tags: map[string]string{"host": "b"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}},
+		{Name: "mem", Tags: map[string]string{"region": "west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}},
+	})
+
+	for i, tt := range []struct {
+		q   string
+		res string
 	}{
-		{in: `cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
-		{in: `"".cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
-		{in: `"rp0".cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
-		{in: `""."".cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
-		{in: `""..cpu`, db: `db0`, out: `"db0"."rp0"."cpu"`},
+		{q: `SELECT * FROM _series`, res: `{"series":[{"name":"_series","columns":["id","measurement_name","host","region"],"values":[[1,"cpu","a",null],[2,"cpu","b",null],[3,"mem",null,"west"]]}]}`},
+		{q: `SELECT id, host AS h FROM "foo"."other"._series WHERE measurement_name = 'cpu' LIMIT 1 OFFSET 1`, res: `{"series":[{"name":"_series","columns":["id","h"],"values":[[2,"b"]]}]}`},
+		{q: `SELECT id, retention_policy, shard_count FROM _shard_groups`, res: `{"series":[{"name":"_shard_groups","columns":["id","retention_policy","shard_count"],"values":[[1,"raw",1]]}]}`},
+		{q: `SELECT time, id, series_count FROM _shards WHERE series_count >= 3`, res: `{"series":[{"name":"_shards","columns":["time","id","series_count"],"values":[["2000-01-01T00:00:00Z",1,3]]}]}`},
+		{q: `SELECT name, retention_duration, is_default FROM _retention_policies`, res: `{"series":[{"name":"_retention_policies","columns":["name","retention_duration","is_default"],"values":[["other","2h0m0s",false],["raw","1h0m0s",true]]}]}`},
+		{q: `SELECT count(id) FROM _shards`, res: `{"error":"` + influxdb.ErrInvalidSystemQuery.Error() + `"}`},
+		{q: `SELECT size FROM _shards`, res: `{"error":"column not found: size"}`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := mustMarshalJSON(results.Results[0]); res != tt.res {
+			t.Errorf("%d. %s: unexpected result: %s", i, tt.q, res)
+		}
+	}
 
-		{in: `"db1"..cpu`, db: `db0`, out: `"db1"."rp1"."cpu"`},
-		{in: `"db1"."rp1".cpu`, db: `db0`, out: `"db1"."rp1"."cpu"`},
-		{in: `"db1"."rp2".cpu`, db: `db0`, out: `"db1"."rp2"."cpu"`},
+	// System measurements can't be written to.
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "_shards", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}}}); err == nil || err.Error() != `point 0: invalid measurement _shards: `+influxdb.ErrSystemMeasurement.Error() {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
 
-		{in: ``, err: `invalid measurement: `},
-		{in: `"foo"."bar"."baz"."bat"`, err: `invalid measurement: "foo"."bar"."baz"."bat"`},
-		{in: `"no_db"..cpu`, db: ``, err: `database not found: no_db`},
-		{in: `"db2"..cpu`, db: ``, err: `default retention policy not set for: db2`},
-		{in: `"db2"."no_policy".cpu`, db: ``, err: `retention policy does not exist: db2.no_policy`},
+// Ensure names with spaces, commas and quotes can be written and queried.
+func TestServer_QuotedNames(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu load", Tags: map[string]string{"data center": `"east"`}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"used, %": float64(10)}}})
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum("used, %") AS "total, %" FROM "cpu load" WHERE "data center" = '"east"' GROUP BY "data center"`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu load","tags":{"data center":"\"east\""},"columns":["time","total, %"],"values":[["1970-01-01T00:00:00Z",10]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
 	}
 
-	// Create server with a variety of databases, retention policies, and measurements
+	results = s.ExecuteQuery(MustParseQuery(`SHOW TAG VALUES FROM "cpu load" WITH KEY = "data center"`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"data centerTagValues","columns":["data center"],"values":[["\"east\""]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+}
+
+// Ensure the server enforces database quotas on writes and reports them with SHOW QUOTAS.
+func TestServer_Quotas(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	// Default database with one policy.
-	s.CreateDatabase("db0")
-	s.CreateRetentionPolicy("db0", &influxdb.RetentionPolicy{Name: "rp0"})
-	s.SetDefaultRetentionPolicy("db0", "rp0")
+	now := mustParseTime("2000-01-01T00:00:00Z")
+	s.Quotas.Now = func() time.Time { return now }
+	s.Quotas.SetQuota(influxdb.Quota{Database: "foo", MaxSeries: 2, MaxWriteRate: 3, MaxConcurrentQueries: 1})
 
-	// Another database with two policies.
-	s.CreateDatabase("db1")
-	s.CreateRetentionPolicy("db1", &influxdb.RetentionPolicy{Name: "rp1"})
-	s.CreateRetentionPolicy("db1", &influxdb.RetentionPolicy{Name: "rp2"})
-	s.SetDefaultRetentionPolicy("db1", "rp1")
+	point := func(host string) influxdb.Point {
+		return influxdb.Point{Name: "cpu", Tags: map[string]string{"host": host}, Timestamp: now, Fields: map[string]interface{}{"value": float64(100)}}
+	}
 
-	// Another database with no policies.
-	s.CreateDatabase("db2")
+	// Write up to the series quota.
+	s.MustWriteSeries("foo", "", []influxdb.Point{point("serverA"), point("serverB")})
 
-	// Execute the tests
-	for i, tt := range tests {
-		out, err := s.NormalizeMeasurement(tt.in, tt.db)
-		if tt.err != errstr(err) {
-			t.Errorf("%d. %s/%s: error: exp: %s, got: %s", i, tt.db, tt.in, tt.err, errstr(err))
-		} else if tt.out != out {
-			t.Errorf("%d. %s/%s: out: exp: %s, got: %s", i, tt.db, tt.in, tt.out, out)
+	// Creating a third series is rejected.
+	if _, err := s.WriteSeries("foo", "", []influxdb.Point{point("serverC")}); err != influxdb.ErrSeriesQuotaExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Writing to existing series is allowed until the rate quota is reached.
+	s.MustWriteSeries("foo", "", []influxdb.Point{point("serverA")})
+	if _, err := s.WriteSeries("foo", "", []influxdb.Point{point("serverB")}); err != influxdb.ErrWriteRateQuotaExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The rate resets every second.
+	now = now.Add(time.Second)
+	s.MustWriteSeries("foo", "", []influxdb.Point{point("serverB")})
+
+	// The running SHOW QUOTAS statement counts against the query quota.
+	results := s.ExecuteQuery(MustParseQuery(`SHOW QUOTAS`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if values := res.Series[0].Values[0]; !reflect.DeepEqual(values[:8], []interface{}{"foo", 2, 2, 3, 1, 1, 1, int64(0)}) {
+		t.Fatalf("unexpected values: %#v", values)
+	} else if n := values[8].(int64); n <= 0 {
+		t.Fatalf("unexpected disk usage: %d", n)
+	}
+}
+
+// Ensure ALTER DATABASE sets a write limit that throttles writes and is kept across restarts.
+func TestServer_WriteLimit(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	points := func(n int) []influxdb.Point {
+		a := make([]influxdb.Point, n)
+		for i := range a {
+			a[i] = influxdb.Point{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i) * time.Second), Fields: map[string]interface{}{"value": float64(i)}}
 		}
+		return a
+	}
+
+	if res := s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo WRITE LIMIT 1 BURST 3`), "foo", nil); res.Error() != nil {
+		t.Fatalf("unexpected error: %s", res.Error())
+	} else if limit, burst, err := s.WriteLimit("foo"); err != nil || limit != 1 || burst != 3 {
+		t.Fatalf("unexpected write limit: %d, %d, %v", limit, burst, err)
+	}
+
+	// Writes are allowed up to the burst and rejected after.
+	s.MustWriteSeries("foo", "", points(3))
+	if _, err := s.WriteSeries("foo", "", points(1)); err != influxdb.ErrWriteThrottled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The limit is kept across restarts.
+	s.Restart()
+	if limit, burst, err := s.WriteLimit("foo"); err != nil || limit != 1 || burst != 3 {
+		t.Fatalf("unexpected write limit after restart: %d, %d, %v", limit, burst, err)
+	}
+
+	// A limit of zero removes it.
+	if res := s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo WRITE LIMIT 0`), "foo", nil); res.Error() != nil {
+		t.Fatalf("unexpected error: %s", res.Error())
+	}
+	s.MustWriteSeries("foo", "", points(100))
+
+	if err := s.SetWriteLimit("foo", -1, 0); err != influxdb.ErrInvalidWriteLimit {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.SetWriteLimit("no_such_db", 1, 0); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-// Ensure the server can normalize all statements in query.
-func TestServer_NormalizeQuery(t *testing.T) {
-	var tests = []struct {
-		in  string // input query
-		db  string // default database
-		out string // output query
-		err string // error, if any
-	}{
-		{
-			in: `SELECT cpu.value FROM cpu`, db: `db0`,
-			out: `SELECT "db0"."rp0"."cpu"."value" FROM "db0"."rp0"."cpu"`,
-		},
+// Ensure ALTER DATABASE sets measurement name rules that are kept across restarts.
+func TestServer_NameRules(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
 
-		{
-			in: `SELECT value FROM cpu`, db: `no_db`, err: `database not found: no_db`,
-		},
+	if res := s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo NORMALIZE NAMES LOWERCASE CHARACTERS 'a-z0-9_' LENGTH 16`), "foo", nil); res.Error() != nil {
+		t.Fatalf("unexpected error: %s", res.Error())
 	}
 
-	// Start server with database & retention policy.
+	// The rules are kept across restarts.
+	s.Restart()
+	if rules, err := s.NameRules("foo"); err != nil {
+		t.Fatal(err)
+	} else if rules == nil || rules.Normalize("Disk.IO") != "disk_io" {
+		t.Fatalf("unexpected name rules: %#v", rules)
+	}
+
+	// No options remove the rules.
+	if res := s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo NORMALIZE NAMES`), "foo", nil); res.Error() != nil {
+		t.Fatalf("unexpected error: %s", res.Error())
+	} else if rules, _ := s.NameRules("foo"); rules != nil {
+		t.Fatalf("unexpected name rules: %#v", rules)
+	}
+
+	if err := s.SetNameRules("no_such_db", nil); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server meters usage per database and user and can reset it.
+func TestServer_Usage(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
-	s.CreateDatabase("db0")
-	s.CreateRetentionPolicy("db0", &influxdb.RetentionPolicy{Name: "rp0"})
-	s.SetDefaultRetentionPolicy("db0", "rp0")
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+	susy := s.User("susy")
+
+	// Write two points as susy and one anonymously.
+	tags := map[string]string{"host": "serverA"}
+	if index, err := s.WriteSeriesAs(susy, "foo", "", []influxdb.Point{
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}},
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(90)}},
+	}); err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+	s.MustWriteSeries("foo", "", []influxdb.Point{{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"value": float64(80)}}})
+
+	// Query all three points as susy.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", susy)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SHOW USAGE`), "", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if values := res.Series[0].Values; len(values) != 3 {
+		t.Fatalf("unexpected values: %#v", values)
+	} else if !reflect.DeepEqual(values[0][:1], []interface{}{""}) || values[0][3] != uint64(0) || values[0][4] != uint64(1) {
+		t.Fatalf("unexpected anonymous usage with no database: %#v", values[0])
+	} else if values[1][0] != "foo" || values[1][1] != "" || values[1][3] != uint64(1) || values[1][4] != uint64(0) {
+		t.Fatalf("unexpected anonymous usage: %#v", values[1])
+	} else if values[2][0] != "foo" || values[2][1] != "susy" || values[2][2].(uint64) == 0 || values[2][3] != uint64(2) || values[2][4] != uint64(1) || values[2][5] != uint64(3) {
+		t.Fatalf("unexpected usage for susy: %#v", values[2])
+	}
+
+	// Reset and verify the usage is cleared.
+	s.ExecuteQuery(MustParseQuery(`RESET USAGE`), "", nil)
+	if a := s.Usage.Usage(); len(a) != 0 {
+		t.Fatalf("unexpected usage after reset: %#v", a)
+	}
+}
+
+// Ensure the server counts points written and dropped per database and measurement.
+func TestServer_WriteStats(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.WriteStats.ByMeasurement = true
+
+	tags := map[string]string{"host": "serverA"}
+	s.MustWriteSeries("foo", "", []influxdb.Point{
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}},
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(90)}},
+		{Name: "mem", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(10)}},
+	})
+
+	// Write a point with a non-finite value, which is rejected.
+	if _, err := s.WriteSeries("foo", "", []influxdb.Point{
+		{Name: "mem", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": math.NaN()}},
+	}); err != influxdb.ErrNonFiniteFieldValue {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Writes to a missing database are not counted.
+	if _, err := s.WriteSeries("bar", "", []influxdb.Point{
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}},
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	a := s.WriteStats.Stats()
+	if len(a) != 3 {
+		t.Fatalf("unexpected stats: %#v", a)
+	} else if a[0].Database != "foo" || a[0].Measurement != "" || a[0].PointsWritten != 3 || a[0].PointsDropped[influxdb.DropReasonNonFinite] != 1 {
+		t.Fatalf("unexpected database stats: %#v", a[0])
+	} else if a[1].Measurement != "cpu" || a[1].PointsWritten != 2 || len(a[1].PointsDropped) != 0 {
+		t.Fatalf("unexpected cpu stats: %#v", a[1])
+	} else if a[2].Measurement != "mem" || a[2].PointsWritten != 1 || a[2].PointsDropped[influxdb.DropReasonNonFinite] != 1 {
+		t.Fatalf("unexpected mem stats: %#v", a[2])
+	}
+}
+
+// Ensure the server wakes up waiters when an index is applied and times out otherwise.
+func TestServer_WaitForIndex(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Wait for the next index in the background.
+	index := s.Index() + 1
+	ch := make(chan error)
+	go func() { ch <- s.WaitForIndex(index, time.Second) }()
+
+	s.CreateDatabase("foo")
+	if err := <-ch; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := s.WaitForIndex(s.Index()+1, 10*time.Millisecond); err != influxdb.ErrWaitTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure subscribers receive matching points and are dropped when they fall behind.
+func TestServer_Subscribe(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	if _, err := s.Subscribe(influxdb.PointFilter{Database: "bar"}, 0); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sub, err := s.Subscribe(influxdb.PointFilter{Database: "foo", Measurement: "cpu"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustWrite := func(name string) {
+		if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: name, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustWrite("mem")
+	mustWrite("cpu")
+	if p := <-sub.C(); p.Name != "cpu" {
+		t.Fatalf("unexpected point: %#v", p)
+	}
+
+	// Overflow the buffer.
+	mustWrite("cpu")
+	mustWrite("cpu")
+	<-sub.C()
+	if _, ok := <-sub.C(); ok {
+		t.Fatal("expected subscription to be closed")
+	} else if err := sub.Err(); err != influxdb.ErrSlowSubscriber {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server keeps a history of metadata changes that can be viewed by index.
+func TestServer_MetadataHistory(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	if _, err := s.MetadataAt(s.Index()); err != influxdb.ErrMetadataSnapshotNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	before := s.Index()
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", true)
+
+	// Verify the change log.
+	a, err := s.MetadataHistory()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(a) != 4 {
+		t.Fatalf("unexpected change count: %d", len(a))
+	} else if a[2].Type != "setDefaultRetentionPolicy" || string(a[2].Command) != `{"database":"foo","name":"raw"}` {
+		t.Fatalf("unexpected change: %#v", a[2])
+	} else if a[3].Type != "createUser" || a[3].Command != nil {
+		t.Fatalf("unexpected change: %#v", a[3])
+	}
+
+	// Verify the metadata before and after the default policy changed.
+	if ss, err := s.MetadataAt(before); err != nil {
+		t.Fatal(err)
+	} else if len(ss.Databases) != 1 || ss.Databases[0].DefaultRetentionPolicy != "" || len(ss.Databases[0].RetentionPolicies) != 1 {
+		t.Fatalf("unexpected databases: %s", mustMarshalJSON(ss.Databases))
+	} else if len(ss.Users) != 0 {
+		t.Fatalf("unexpected users: %s", mustMarshalJSON(ss.Users))
+	}
+	if ss, err := s.MetadataAt(s.Index()); err != nil {
+		t.Fatal(err)
+	} else if ss.Databases[0].DefaultRetentionPolicy != "raw" {
+		t.Fatalf("unexpected databases: %s", mustMarshalJSON(ss.Databases))
+	} else if len(ss.Users) != 1 || ss.Users[0].Name != "susy" || !ss.Users[0].Admin {
+		t.Fatalf("unexpected users: %s", mustMarshalJSON(ss.Users))
+	}
+
+	// Verify lookup by time.
+	if ss, err := s.MetadataAtTime(time.Now()); err != nil {
+		t.Fatal(err)
+	} else if ss.Type != "createUser" {
+		t.Fatalf("unexpected snapshot: %s", ss.Type)
+	}
+	if _, err := s.MetadataAtTime(a[0].Time.Add(-time.Second)); err != influxdb.ErrMetadataSnapshotNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server compacts fragmented shards in shard groups that have ended.
+func TestServer_CompactShards(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.BackfillThreshold = 0
+	s.Compactor.SetThroughput(0)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write points in reverse order so pages are split and left partially filled.
+	start := mustParseTime("2000-01-01T00:00:00Z")
+	for i := 2000; i > 0; i-- {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: start.Add(time.Duration(i) * time.Second), Fields: map[string]interface{}{"value": float64(i)}}})
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(s.Path(), "shards", "*"))
+	if len(paths) != 1 {
+		t.Fatalf("unexpected shard count: %d", len(paths))
+	}
+	before, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compact and verify the file shrank.
+	if err := s.CompactShards(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if after.Size() >= before.Size() {
+		t.Fatalf("shard not compacted: %d >= %d bytes", after.Size(), before.Size())
+	}
+
+	// Verify an unmodified shard isn't compacted again.
+	if err := s.CompactShards(); err != nil {
+		t.Fatal(err)
+	} else if fi, err := os.Stat(paths[0]); err != nil {
+		t.Fatal(err)
+	} else if !fi.ModTime().Equal(after.ModTime()) {
+		t.Fatal("shard compacted again")
+	}
 
-	// Execute the tests
-	for i, tt := range tests {
-		out := MustParseQuery(tt.in)
-		err := s.NormalizeStatement(out.Statements[0], tt.db)
-		if tt.err != errstr(err) {
-			t.Errorf("%d. %s/%s: error: exp: %s, got: %s", i, tt.db, tt.in, tt.err, errstr(err))
-		} else if err == nil && tt.out != out.String() {
-			t.Errorf("%d. %s/%s:\n\nexp: %s\n\ngot: %s\n\n", i, tt.db, tt.in, tt.out, out.String())
-		}
+	// Verify the data is still readable and writable.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: start, Fields: map[string]interface{}{"value": float64(0)}}})
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(value), sum(value) FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","count","sum"],"values":[["1970-01-01T00:00:00Z",2001,2001000]]}]}` {
+		t.Fatalf("unexpected row: %s", s)
 	}
 }
 
-// Ensure the server can create a continuous query
-func TestServer_CreateContinuousQuery(t *testing.T) {
+// Ensure points that don't match their checksum are read according to the checksum policy.
+func TestServer_ChecksumPolicy(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}},
+		{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(200)}},
+	})
+	path := s.Path()
+	s.Server.Close()
 
-	// Create the "foo" database.
-	if err := s.CreateDatabase("foo"); err != nil {
+	// Flip a bit in the value of the second point.
+	paths, _ := filepath.Glob(filepath.Join(path, "shards", "*"))
+	if len(paths) != 1 {
+		t.Fatalf("unexpected shard count: %d", len(paths))
+	}
+	db, err := bolt.Open(paths[0], 0600, nil)
+	if err != nil {
 		t.Fatal(err)
 	}
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if len(name) != 4 {
+				return nil
+			}
+			k, v := b.Cursor().Last()
+			v = append([]byte(nil), v...)
+			v[len(v)-1] ^= 1
+			return b.Put(k, v)
+		})
+	}); err != nil {
 		t.Fatal(err)
 	}
-	s.SetDefaultRetentionPolicy("foo", "bar")
+	db.Close()
 
-	// create and check
-	q := "CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT count() INTO measure1 FROM myseries GROUP BY time(10m) END"
-	stmt, err := influxql.NewParser(strings.NewReader(q)).ParseStatement()
-	if err != nil {
-		t.Fatalf("error parsing query %s", err.Error())
-	}
-	cq := stmt.(*influxql.CreateContinuousQueryStatement)
-	if err := s.CreateContinuousQuery(cq); err != nil {
-		t.Fatalf("error creating continuous query %s", err.Error())
+	if r, err := influxdb.Verify(path, false); err != nil {
+		t.Fatal(err)
+	} else if len(r.Problems) != 1 || !strings.HasSuffix(r.Problems[0], "point 946684810000000000: checksum mismatch") {
+		t.Fatalf("unexpected problems: %#v", r.Problems)
 	}
 
-	queries := s.ContinuousQueries("foo")
-	cqObj, _ := influxdb.NewContinuousQuery(q)
-	expected := []*influxdb.ContinuousQuery{cqObj}
-	if mustMarshalJSON(expected) != mustMarshalJSON(queries) {
-		t.Fatalf("query not saved:\n\texp: %s\n\tgot: %s", mustMarshalJSON(expected), mustMarshalJSON(queries))
+	for _, tt := range []struct {
+		policy string
+		exp    string
+	}{
+		{policy: "", exp: `{"error":"shard 1: series 1: point 946684810000000000: checksum mismatch"}`},
+		{policy: influxdb.ChecksumPolicySkip, exp: `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",100]]}]}`},
+		{policy: influxdb.ChecksumPolicyLog, exp: `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",100],["2000-01-01T00:00:10Z",200.00000000000003]]}]}`},
+	} {
+		s.ChecksumPolicy = tt.policy
+		if err := s.Server.Open(path); err != nil {
+			t.Fatalf("%q: %s", tt.policy, err)
+		}
+		results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
+		if res := mustMarshalJSON(results.Results[0]); res != tt.exp {
+			t.Errorf("%q: unexpected result: %s", tt.policy, res)
+		}
+		s.Server.Close()
 	}
-	s.Restart()
 
-	// check again
-	queries = s.ContinuousQueries("foo")
-	if !reflect.DeepEqual(queries, expected) {
-		t.Fatalf("query not saved:\n\texp: %s\ngot: %s", mustMarshalJSON(expected), mustMarshalJSON(queries))
+	s.ChecksumPolicy = "ignore"
+	if err := s.Server.Open(path); err != influxdb.ErrInvalidChecksumPolicy {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-// Ensure the server prevents a duplicate named continuous query from being created
-func TestServer_CreateContinuousQuery_ErrContinuousQueryExists(t *testing.T) {
-	t.Skip("pending")
-}
+// Ensure background IO waits for both its class budget and the total budget.
+func TestIOScheduler_Wait(t *testing.T) {
+	s := influxdb.NewIOScheduler()
+	s.SetThroughput(influxdb.IOClassBackup, 0)
+	s.SetThroughput("", 1000)
 
-// Ensure the server returns an error when creating a continuous query on a database that doesn't exist
-func TestServer_CreateContinuousQuery_ErrDatabaseNotFound(t *testing.T) {
-	t.Skip("pending")
-}
+	start := time.Now()
+	s.Wait(influxdb.IOClassBackup, 50)
+	s.Wait(influxdb.IOClassCompaction, 50)
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("total budget not applied: %s", d)
+	}
 
-// Ensure the server returns an error when creating a continuous query on a retention policy that doesn't exist
-func TestServer_CreateContinuousQuery_ErrRetentionPolicyNotFound(t *testing.T) {
-	t.Skip("pending")
+	s.SetThroughput("", 0)
+	start = time.Now()
+	s.Wait(influxdb.IOClassBackup, 1<<30)
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("unlimited class throttled: %s", d)
+	}
 }
 
-func TestServer_CreateContinuousQuery_ErrInfinteLoop(t *testing.T) {
-	t.Skip("pending")
-}
+// Ensure old shards are offloaded to the object store and fetched when read.
+func TestServer_OffloadShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "influxdb-cold-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 
-// Ensure
-func TestServer_RunContinuousQueries(t *testing.T) {
-	t.Skip()
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+	s.ColdStore = &objectstore.Dir{Path: dir}
+	s.ColdCacheTTL = time.Hour
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100)}}})
 
-	// Create the "foo" database.
-	if err := s.CreateDatabase("foo"); err != nil {
+	paths, _ := filepath.Glob(filepath.Join(s.Path(), "shards", "*"))
+	if len(paths) != 1 {
+		t.Fatalf("unexpected shard count: %d", len(paths))
+	}
+	path := paths[0]
+
+	// Offload and verify the shard only exists in the object store.
+	var id uint64
+	if err := s.OffloadShards(); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("shard not removed: %v", err)
+	} else if objects, _ := filepath.Glob(filepath.Join(dir, "nodes", "*", "shards", "*")); len(objects) != 1 {
+		t.Fatalf("unexpected objects: %v", objects)
+	} else if a, err := s.ShardStats(""); err != nil {
 		t.Fatal(err)
+	} else if !a[0].Offloaded || a[0].DiskBytes != 0 {
+		t.Fatalf("unexpected shard stats: %#v", a[0])
+	} else {
+		id = a[0].ID
 	}
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"}); err != nil {
+
+	// Verify the shard is fetched when read.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",100]]}]}` {
+		t.Fatalf("unexpected row: %s", s)
+	} else if _, err := os.Stat(path); err != nil {
+		t.Fatalf("shard not fetched: %v", err)
+	}
+
+	// Verify a fetched shard is kept while in use and written back if modified.
+	if err := s.OffloadShards(); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(path); err != nil {
+		t.Fatalf("fetched shard removed: %v", err)
+	}
+	index := s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(200)}}})
+	s.Sync(index)
+	s.ColdCacheTTL = time.Nanosecond
+	if err := s.OffloadShards(); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("shard not removed: %v", err)
+	}
+
+	// Verify the rewritten shard is fetched after a restart.
+	s.Restart()
+	results = s.ExecuteQuery(MustParseQuery(`SELECT count(value) FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","count"],"values":[["1970-01-01T00:00:00Z",2]]}]}` {
+		t.Fatalf("unexpected row: %s", s)
+	}
+
+	// Verify dropping the shard deletes the offloaded copy.
+	if err := s.DropShard(id); err != nil {
 		t.Fatal(err)
+	} else if objects, _ := filepath.Glob(filepath.Join(dir, "nodes", "*", "shards", "*")); len(objects) != 0 {
+		t.Fatalf("unexpected objects: %v", objects)
 	}
+}
+
+// Ensure a shard can be exported and imported into another server.
+func TestServer_ExportImportShard(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	s.RecomputePreviousN = 50
-	s.RecomputeNoOlderThan = time.Second
-	s.ComputeRunsPerInterval = 5
-	s.ComputeNoMoreThan = 2 * time.Millisecond
+	// Write a field of each type.
+	tags := map[string]string{"host": "serverA"}
+	points := []influxdb.Point{
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1.5)}},
+		{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"bytes": uint64(18446744073709551000)}},
+		{Name: "status", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"up": true}},
+		{Name: "log", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"msg": "hello"}},
+		{Name: "req", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"latency": influxql.Buckets{10: 8, math.Inf(1): 2}}},
+	}
+	for _, p := range points {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{p})
+	}
 
-	// create cq and check
-	q := `CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT mean(value) INTO cpu_region FROM cpu GROUP BY time(5ms), region END`
-	stmt, err := influxql.NewParser(strings.NewReader(q)).ParseStatement()
+	a, err := s.ShardStats("foo")
 	if err != nil {
-		t.Fatalf("error parsing query %s", err.Error())
+		t.Fatal(err)
+	} else if len(a) != 1 {
+		t.Fatalf("unexpected shards: %d", len(a))
 	}
-	cq := stmt.(*influxql.CreateContinuousQueryStatement)
-	if err := s.CreateContinuousQuery(cq); err != nil {
-		t.Fatalf("error creating continuous query %s", err.Error())
+	var buf bytes.Buffer
+	if err := s.ExportShard(a[0].ID, &buf); err != nil {
+		t.Fatal(err)
+	} else if err := s.ExportShard(1000, &bytes.Buffer{}); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if err := s.RunContinuousQueries(); err != nil {
-		t.Fatalf("error running cqs when no data exists: %s", err.Error())
+
+	// Import into a database with another name on a new server.
+	other := OpenServer(NewMessagingClient())
+	defer other.Close()
+	other.CreateDatabase("bar")
+	other.CreateRetentionPolicy("bar", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	if n, err := other.ImportShard(bytes.NewReader(buf.Bytes()), "bar", ""); err != nil {
+		t.Fatal(err)
+	} else if n != len(points) {
+		t.Fatalf("unexpected point count: %d", n)
 	}
 
-	// set a test time in the middle of a 5 second interval that we can work with
-	testTime := time.Now().UTC().Round(5 * time.Millisecond)
-	if testTime.UnixNano() > time.Now().UnixNano() {
-		testTime = testTime.Add(-5 * time.Millisecond)
+	// Verify every value is read back with its type.
+	for i, p := range points {
+		if v, err := other.ReadSeries("bar", "raw", p.Name, p.Tags, p.Timestamp); err != nil {
+			t.Fatalf("%d. %s", i, err)
+		} else if !reflect.DeepEqual(v, p.Fields) {
+			t.Fatalf("%d. values mismatch: %#v", i, v)
+		}
 	}
-	testTime.Add(time.Millisecond * 2)
 
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: testTime, Fields: map[string]interface{}{"value": float64(30)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: testTime.Add(-time.Millisecond * 5), Fields: map[string]interface{}{"value": float64(20)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: testTime, Fields: map[string]interface{}{"value": float64(100)}}})
+	// Verify invalid archives are rejected.
+	if _, err := other.ImportShard(strings.NewReader("foo"), "bar", ""); err == nil || !strings.HasPrefix(err.Error(), "invalid shard archive") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
 
-	// Run CQs after a period of time
-	time.Sleep(time.Millisecond * 50)
-	s.RunContinuousQueries()
-	// give the CQs time to run
-	time.Sleep(time.Millisecond * 100)
+// Ensure a data directory can be verified and its series index repaired.
+func TestVerify(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(100), "state": "ok"}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(200)}},
+	})
+	path := s.Path()
+	s.Server.Close()
 
-	verify := func(num int, exp string) {
-		results := s.ExecuteQuery(MustParseQuery(`SELECT mean(mean) FROM cpu_region GROUP BY region`), "foo", nil)
-		if res := results.Results[0]; res.Err != nil {
-			t.Fatalf("unexpected error verify %d: %s", num, res.Err)
-		} else if len(res.Series) != 2 {
-			t.Fatalf("unexpected row count on verify %d: %d", num, len(res.Series))
-		} else if s := mustMarshalJSON(res); s != exp {
-			t.Fatalf("unexpected row(0) on verify %d: %s", num, s)
-		}
+	if r, err := influxdb.Verify(path, false); err != nil {
+		t.Fatal(err)
+	} else if !r.OK() || r.Shards != 1 || r.Series != 2 || r.Points != 2 {
+		t.Fatalf("unexpected report: %#v", r)
 	}
 
-	// ensure CQ results were saved
-	verify(1, `{"series":[{"name":"cpu_region","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",25]]},{"name":"cpu_region","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",100]]}]}`)
+	// Add an entry that can't be decoded and rewind the series id sequence.
+	db, err := bolt.Open(filepath.Join(path, "meta"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Databases")).Bucket([]byte("foo")).Bucket([]byte("Series"))
+		if err := b.Bucket([]byte("cpu")).Put([]byte{0, 0, 0, 9}, []byte("{")); err != nil {
+			return err
+		}
+		return b.SetSequence(1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
 
-	// ensure that repeated runs don't cause duplicate data
-	s.RunContinuousQueries()
-	verify(2, `{"series":[{"name":"cpu_region","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",25]]},{"name":"cpu_region","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",100]]}]}`)
+	if r, err := influxdb.Verify(path, false); err != nil {
+		t.Fatal(err)
+	} else if exp := []string{
+		"database foo: measurement cpu: invalid series 00000009",
+		"database foo: series id sequence 1 is behind series id 2",
+	}; !reflect.DeepEqual(r.Problems, exp) || len(r.Repairs) != 0 {
+		t.Fatalf("unexpected report: %#v", r)
+	}
 
-	// ensure that data written into a previous window is picked up and the result recomputed.
-	time.Sleep(time.Millisecond * 2)
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: testTime.Add(-time.Millisecond), Fields: map[string]interface{}{"value": float64(50)}}})
-	s.RunContinuousQueries()
-	// give CQs time to run
-	time.Sleep(time.Millisecond * 50)
+	if r, err := influxdb.Verify(path, true); err != nil {
+		t.Fatal(err)
+	} else if exp := []string{
+		"database foo: measurement cpu: removed series 00000009",
+		"database foo: advanced series id sequence to 2",
+	}; !reflect.DeepEqual(r.Repairs, exp) {
+		t.Fatalf("unexpected repairs: %#v", r.Repairs)
+	}
 
-	verify(3, `{"series":[{"name":"cpu_region","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",25]]},{"name":"cpu_region","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",75]]}]}`)
+	if r, err := influxdb.Verify(path, false); err != nil {
+		t.Fatal(err)
+	} else if !r.OK() {
+		t.Fatalf("unexpected problems: %#v", r.Problems)
+	}
 }
 
-func mustMarshalJSON(v interface{}) string {
-	b, err := json.Marshal(v)
-	if err != nil {
-		panic("marshal: " + err.Error())
+// Ensure the server reports shard statistics with SHOW SHARDS.
+func TestServer_ShowShards(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(2)}},
+	})
+
+	results := s.ExecuteQuery(MustParseQuery(`SHOW SHARDS`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Series) != 1 || len(res.Series[0].Values) != 1 {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(res))
+	} else if v := res.Series[0].Values[0]; v[1] != "foo" || v[2] != "raw" || v[6] != 2 || v[7].(int64) <= 0 {
+		t.Fatalf("unexpected shard: %v", v)
+	} else if v[10] != false {
+		t.Fatalf("unexpected offloaded: %v", v)
+	} else if lastWrite := v[11].(time.Time); time.Since(lastWrite) > time.Minute {
+		t.Fatalf("unexpected last write: %s", lastWrite)
 	}
-	return string(b)
-}
 
-func measurementsEqual(l influxdb.Measurements, r influxdb.Measurements) bool {
-	if mustMarshalJSON(l) == mustMarshalJSON(r) {
-		return true
+	// Statistics can be limited to a single database.
+	if a, err := s.ShardStats("bar"); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v %v", a, err)
 	}
-	return false
 }
 
-func TestServer_SeriesByTagNames(t *testing.T)  { t.Skip("pending") }
-func TestServer_SeriesByTagValues(t *testing.T) { t.Skip("pending") }
-func TestDatabase_TagNames(t *testing.T)        { t.Skip("pending") }
-func TestServer_TagNamesBySeries(t *testing.T)  { t.Skip("pending") }
-func TestServer_TagValues(t *testing.T)         { t.Skip("pending") }
-func TestServer_TagValuesBySeries(t *testing.T) { t.Skip("pending") }
+// Ensure the server can list shard groups and drop individual shards.
+func TestServer_DropShard(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T01:00:10Z"), Fields: map[string]interface{}{"value": float64(2)}}})
 
-// Point JSON Unmarshal tests
+	results := s.ExecuteQuery(MustParseQuery(`SHOW SHARD GROUPS`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"shard groups","columns":["id","database","retention_policy","start_time","end_time","shards"],"values":[[1,"foo","raw","2000-01-01T00:00:00Z","2000-01-01T01:00:00Z",[1]],[2,"foo","raw","2000-01-01T01:00:00Z","2000-01-01T02:00:00Z",[2]]]}]}` {
+		t.Fatalf("unexpected row: %s", s)
+	}
 
-func TestbatchWrite_UnmarshalEpoch(t *testing.T) {
-	var (
-		now     = time.Now()
-		nanos   = now.UnixNano()
-		micros  = nanos / int64(time.Microsecond)
-		millis  = nanos / int64(time.Millisecond)
-		seconds = nanos / int64(time.Second)
-		minutes = nanos / int64(time.Minute)
-		hours   = nanos / int64(time.Hour)
-	)
+	// Drop the first shard and verify its group and data are removed.
+	results = s.ExecuteQuery(MustParseQuery(`DROP SHARD 1`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+	if a, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 || a[0].ID != 2 {
+		t.Fatalf("unexpected shard groups: %s", mustMarshalJSON(a))
+	}
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T01:00:10Z",2]]}]}` {
+		t.Fatalf("unexpected row: %s", s)
+	}
 
-	tests := []struct {
-		name  string
-		epoch int64
-	}{
-		{name: "nanos", epoch: nanos},
-		{name: "micros", epoch: micros},
-		{name: "millis", epoch: millis},
-		{name: "seconds", epoch: seconds},
-		{name: "minutes", epoch: minutes},
-		{name: "hours", epoch: hours},
+	if err := s.DropShard(1); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
+}
 
-	for _, test := range tests {
-		json := fmt.Sprintf(`"points": [{timestamp: "%d"}`, test.epoch)
-		log.Println(json)
-		t.Fatal("foo")
+// Ensure the server rejects dropping a shard whose group has other shards.
+func TestServer_DropShard_ErrShardGroupNotDroppable(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	u, _ := url.Parse("http://localhost:8090")
+	s.CreateDataNode(u)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour, ReplicaN: 1})
+	s.CreateShardGroupIfNotExists("foo", "bar", mustParseTime("2000-01-01T00:00:00Z"))
+
+	g, _ := s.ShardGroups("foo")
+	if len(g) != 1 || len(g[0].Shards) != 2 {
+		t.Fatalf("unexpected shard groups: %#v", g)
+	}
+	if err := s.DropShard(g[0].Shards[0].ID); err != influxdb.ErrShardGroupNotDroppable {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
+	// Verify the group and its shards are kept.
+	if other, _ := s.ShardGroups("foo"); len(other) != 1 || len(other[0].Shards) != 2 {
+		t.Fatalf("unexpected shard groups: %s", mustMarshalJSON(other))
+	}
 }
 
-// Ensure the server will skip over replayed log entries and not blow up.
-func TestServer_Replay(t *testing.T) {
-	c := NewMessagingClient()
-	s := OpenServer(c)
+// Ensure the server can end shard groups early so new groups start at a boundary.
+func TestServer_TruncateShardGroups(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1000 * 24 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	// Record all messages through the client.
-	var messages []*messaging.Message
-	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
-		messages = append(messages, m)
-		c.c <- m
-		return m.Index, nil
+	now := time.Now().UTC()
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: now, Fields: map[string]interface{}{"value": float64(1)}}})
+
+	// Truncate the current shard group shortly after now.
+	boundary := now.Add(1 * time.Minute).Truncate(time.Second)
+	results := s.ExecuteQuery(MustParseQuery(fmt.Sprintf(`TRUNCATE SHARDS '%s'`, boundary.Format(time.RFC3339Nano))), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
 	}
 
-	// Create a new node.
-	u, _ := url.Parse("http://localhost:80000")
-	if err := s.CreateDataNode(u); err != nil {
+	// Points after the boundary are written to a new group starting at the boundary.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: boundary.Add(1 * time.Hour), Fields: map[string]interface{}{"value": float64(2)}}})
+	if a, err := s.ShardGroups("foo"); err != nil {
 		t.Fatal(err)
+	} else if len(a) != 2 {
+		t.Fatalf("unexpected shard group count: %d", len(a))
+	} else if !a[0].EndTime.Equal(boundary) || !a[1].StartTime.Equal(boundary) {
+		t.Fatalf("unexpected shard groups: %s", mustMarshalJSON(a))
 	}
-	s.Restart()
 
-	// Replay messages through client.
-	for _, m := range messages {
-		c.c <- m
+	if err := s.TruncateShardGroups(now.Add(-1 * time.Hour)); err != influxdb.ErrTruncateTimeInPast {
+		t.Fatalf("unexpected error: %v", err)
 	}
-
-	// Sleep so it has a moment to process & ignore.
-	time.Sleep(100 * time.Millisecond)
-
-	// NOTE: There is no way to introspect into the server to see that
-	// messages are being dropped. This test exists to make sure the server
-	// doesn't crash on retry.
 }
 
-// Server is a wrapping test struct for influxdb.Server.
-type Server struct {
-	*influxdb.Server
-}
+// Ensure the server can rename a database along with the queries, privileges
+// and subscriptions that reference it.
+func TestServer_RenameDatabase(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateRetentionPolicy("bar", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("bar", "raw")
+	s.CreateUser("susy", "pass", false)
+	s.SetPrivilege(influxql.ReadPrivilege, "susy", "foo")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}}})
 
-// NewServer returns a new test server instance.
-func NewServer() *Server {
-	s := influxdb.NewServer()
-	s.SetAuthenticationEnabled(false)
-	return &Server{s}
-}
+	results := s.ExecuteQuery(MustParseQuery(`CREATE CONTINUOUS QUERY cq0 ON bar BEGIN SELECT count(value) INTO "foo"."raw".cpu_count FROM "foo"."raw".cpu GROUP BY time(10m) END`), "bar", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+	sub, err := s.Subscribe(influxdb.PointFilter{Database: "foo"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
 
-// OpenServer returns a new, open test server instance.
-func OpenServer(client influxdb.MessagingClient) *Server {
-	s := OpenUninitializedServer(client)
-	if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
-		panic(err.Error())
+	// Rename the database.
+	results = s.ExecuteQuery(MustParseQuery(`ALTER DATABASE foo RENAME TO baz`), "", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
 	}
-	return s
-}
+	s.Restart()
 
-// OpenUninitializedServer returns a new, uninitialized, open test server instance.
-func OpenUninitializedServer(client influxdb.MessagingClient) *Server {
-	s := NewServer()
-	if err := s.Open(tempfile()); err != nil {
-		panic(err.Error())
+	// Verify the data, privileges and continuous query moved to the new name.
+	if s.DatabaseExists("foo") || !s.DatabaseExists("baz") {
+		t.Fatalf("database not renamed")
 	}
-	if err := s.SetClient(client); err != nil {
-		panic(err.Error())
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "baz", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",1]]}]}` {
+		t.Fatalf("unexpected row: %s", s)
+	}
+	if p := s.User("susy").Privileges; p["foo"] != influxql.NoPrivileges || p["baz"] != influxql.ReadPrivilege {
+		t.Fatalf("unexpected privileges: %v", p)
+	}
+	if a := s.ContinuousQueries("bar"); len(a) != 1 {
+		t.Fatalf("unexpected continuous query count: %d", len(a))
+	} else if a[0].Query != `CREATE CONTINUOUS QUERY cq0 ON bar BEGIN SELECT count(value) INTO "baz"."raw"."cpu_count" FROM "baz"."raw"."cpu" GROUP BY time(10m) END` {
+		t.Fatalf("unexpected continuous query: %s", a[0].Query)
+	}
+	if sub.Filter.Database != "baz" {
+		t.Fatalf("unexpected subscription database: %s", sub.Filter.Database)
 	}
-	return s
-}
 
-// OpenDefaultServer opens a server and creates a default db & retention policy.
-func OpenDefaultServer(client influxdb.MessagingClient) *Server {
-	s := OpenServer(client)
-	if err := s.CreateDatabase("db"); err != nil {
-		panic(err.Error())
-	} else if err = s.CreateRetentionPolicy("db", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour}); err != nil {
-		panic(err.Error())
-	} else if err = s.SetDefaultRetentionPolicy("db", "raw"); err != nil {
-		panic(err.Error())
+	if err := s.RenameDatabase("foo", "qux"); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.RenameDatabase("baz", "bar"); err != influxdb.ErrDatabaseExists {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.RenameDatabase("baz", ""); err != influxdb.ErrDatabaseNameRequired {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	return s
 }
 
-// Restart stops and restarts the server.
-func (s *Server) Restart() {
-	path, client := s.Path(), s.Client()
+// Ensure the server can clone a database with and without its data.
+func TestServer_CloneDatabase(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}}})
 
-	// Stop the server.
-	if err := s.Server.Close(); err != nil {
-		panic("close: " + err.Error())
+	results := s.ExecuteQuery(MustParseQuery(`CREATE CONTINUOUS QUERY cq0 ON foo BEGIN SELECT count(value) INTO "foo"."raw".cpu_count FROM cpu GROUP BY time(10m) END`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
 	}
 
-	// Open and reset the client.
-	if err := s.Server.Open(path); err != nil {
-		panic("open: " + err.Error())
-	}
-	if err := s.Server.SetClient(client); err != nil {
-		panic("client: " + err.Error())
+	// Clone with and without data.
+	results = s.ExecuteQuery(MustParseQuery(`CREATE DATABASE bar FROM foo WITH DATA; CREATE DATABASE baz FROM foo`), "", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
 	}
-}
 
-// Close shuts down the server and removes all temporary files.
-func (s *Server) Close() {
-	defer os.RemoveAll(s.Path())
-	s.Server.Close()
-}
+	// Writes to the clone must not affect the original.
+	s.MustWriteSeries("bar", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(2)}}})
+	s.Restart()
 
-// MustWriteSeries writes series data and waits for the data to be applied.
-// Returns the messaging index for the write.
-func (s *Server) MustWriteSeries(database, retentionPolicy string, points []influxdb.Point) uint64 {
-	index, err := s.WriteSeries(database, retentionPolicy, points)
-	if err != nil {
-		panic(err.Error())
-	} else if err = s.Sync(index); err != nil {
-		panic("sync error: " + err.Error())
+	for _, tt := range []struct {
+		db  string
+		res string
+	}{
+		{db: "foo", res: `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",1]]}]}`},
+		{db: "bar", res: `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",1],["2000-01-01T00:00:10Z",2]]}]}`},
+		{db: "baz", res: `{}`},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), tt.db, nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.db, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.res {
+			t.Fatalf("%s: unexpected row: %s", tt.db, s)
+		}
 	}
-	return index
-}
 
-// MessagingClient represents a test client for the messaging broker.
-type MessagingClient struct {
-	index uint64
-	c     chan *messaging.Message
+	// The series index and continuous queries are cloned.
+	results = s.ExecuteQuery(MustParseQuery(`SHOW TAG VALUES FROM cpu WITH KEY = host`), "baz", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"hostTagValues","columns":["host"],"values":[["serverA"]]}]}` {
+		t.Fatalf("unexpected row: %s", s)
+	}
+	if a := s.ContinuousQueries("baz"); len(a) != 1 {
+		t.Fatalf("unexpected continuous query count: %d", len(a))
+	} else if a[0].Query != `CREATE CONTINUOUS QUERY cq0 ON baz BEGIN SELECT count(value) INTO "baz"."raw"."cpu_count" FROM cpu GROUP BY time(10m) END` {
+		t.Fatalf("unexpected continuous query: %s", a[0].Query)
+	}
 
-	PublishFunc       func(*messaging.Message) (uint64, error)
-	CreateReplicaFunc func(replicaID uint64, connectURL *url.URL) error
-	DeleteReplicaFunc func(replicaID uint64) error
-	SubscribeFunc     func(replicaID, topicID uint64) error
-	UnsubscribeFunc   func(replicaID, topicID uint64) error
+	if err := s.CloneDatabase("no_such_db", "qux", false); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.CloneDatabase("foo", "bar", false); err != influxdb.ErrDatabaseExists {
+		t.Fatalf("unexpected error: %v", err)
+	}
 }
 
-// NewMessagingClient returns a new instance of MessagingClient.
-func NewMessagingClient() *MessagingClient {
-	c := &MessagingClient{c: make(chan *messaging.Message, 1)}
-	c.PublishFunc = c.send
-	c.CreateReplicaFunc = func(replicaID uint64, connectURL *url.URL) error { return nil }
-	c.DeleteReplicaFunc = func(replicaID uint64) error { return nil }
-	c.SubscribeFunc = func(replicaID, topicID uint64) error { return nil }
-	c.UnsubscribeFunc = func(replicaID, topicID uint64) error { return nil }
-	return c
-}
+// Ensure the server removes points older than their measurement's TTL.
+func TestServer_EnforceMeasurementTTLs(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 7 * 24 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-// Publish attaches an autoincrementing index to the message.
-// This function also execute's the client's PublishFunc mock function.
-func (c *MessagingClient) Publish(m *messaging.Message) (uint64, error) {
-	c.index++
-	m.Index = c.index
-	return c.PublishFunc(m)
-}
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, name := range []string{"cpu", "debug_events"} {
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: name, Timestamp: now.Add(-2 * time.Hour), Fields: map[string]interface{}{"value": float64(1)}}})
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: name, Timestamp: now, Fields: map[string]interface{}{"value": float64(2)}}})
+	}
 
-// send sends the message through to the channel.
-// This is the default value of PublishFunc.
-func (c *MessagingClient) send(m *messaging.Message) (uint64, error) {
-	c.c <- m
-	return m.Index, nil
-}
+	results := s.ExecuteQuery(MustParseQuery(`ALTER MEASUREMENT debug_events TTL 1h`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+	s.Restart()
+	if err := s.EnforceMeasurementTTLs(); err != nil {
+		t.Fatal(err)
+	}
 
-// Creates a new replica with a given ID on the broker.
-func (c *MessagingClient) CreateReplica(replicaID uint64, connectURL *url.URL) error {
-	return c.CreateReplicaFunc(replicaID, connectURL)
-}
+	// Only the expired point of the measurement with a TTL is removed.
+	for _, tt := range []struct {
+		name string
+		n    int
+	}{
+		{name: "cpu", n: 2},
+		{name: "debug_events", n: 1},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(fmt.Sprintf(`SELECT value FROM %s WHERE time > now() - 1d`, tt.name)), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, res.Err)
+		} else if len(res.Series) != 1 || len(res.Series[0].Values) != tt.n {
+			t.Fatalf("%s: unexpected result: %s", tt.name, mustMarshalJSON(res))
+		}
+	}
 
-// Deletes an existing replica with a given ID from the broker.
-func (c *MessagingClient) DeleteReplica(replicaID uint64) error {
-	return c.DeleteReplicaFunc(replicaID)
+	if err := s.SetMeasurementTTL("foo", "no_such_measurement", time.Hour); err != influxdb.ErrMeasurementNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.SetMeasurementTTL("foo", "cpu", -time.Hour); err != influxdb.ErrInvalidMeasurementTTL {
+		t.Fatalf("unexpected error: %v", err)
+	}
 }
 
-// Subscribe adds a subscription to a replica for a topic on the broker.
-func (c *MessagingClient) Subscribe(replicaID, topicID uint64) error {
-	return c.SubscribeFunc(replicaID, topicID)
-}
+// Ensure the server can rewrite a tag value in place and by merging into an existing series.
+func TestServer_RewriteTagValue(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA", "region": "us"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverA", "region": "eu"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(2)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB", "region": "eu"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(3)}},
+	})
+
+	// Rename serverA to serverB. The "us" series is renamed in place and the
+	// "eu" series is merged into the existing serverB series.
+	j, err := s.RewriteTagValue("foo", "cpu", "host", "serverA", "serverB")
+	if err != nil {
+		t.Fatal(err)
+	} else if j.SeriesN != 2 {
+		t.Fatalf("unexpected series count: %d", j.SeriesN)
+	}
+	for !j.Done() {
+		time.Sleep(10 * time.Millisecond)
+		j = s.TagRewrite(j.ID)
+	}
+	if j.Err != "" || j.SeriesDone != 2 || j.PointsN != 1 {
+		t.Fatalf("unexpected job: %s", mustMarshalJSON(j))
+	}
+	s.Restart()
 
-// Unsubscribe removes a subscrition from a replica for a topic on the broker.
-func (c *MessagingClient) Unsubscribe(replicaID, topicID uint64) error {
-	return c.UnsubscribeFunc(replicaID, topicID)
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu GROUP BY host, region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","tags":{"host":"serverB","region":"eu"},"columns":["time","value"],"values":[["2000-01-01T00:00:00Z",2],["2000-01-01T00:00:10Z",3]]},{"name":"cpu","tags":{"host":"serverB","region":"us"},"columns":["time","value"],"values":[["2000-01-01T00:00:00Z",1]]}]}` {
+		t.Fatalf("unexpected row: %s", s)
+	}
+
+	if _, err := s.RewriteTagValue("foo", "cpu", "host", "serverB", "serverB"); err != influxdb.ErrInvalidTagRewrite {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := s.RewriteTagValue("foo", "mem", "host", "serverA", "serverB"); err != influxdb.ErrMeasurementNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
 }
 
-// C returns a channel for streaming message.
-func (c *MessagingClient) C() <-chan *messaging.Message { return c.c }
+// Ensure the server can rename a retention policy and the queries that reference it.
+func TestServer_RenameRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "other", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-// tempfile returns a temporary path.
-func tempfile() string {
-	f, _ := ioutil.TempFile("", "influxdb-")
-	path := f.Name()
-	f.Close()
-	os.Remove(path)
-	return path
-}
+	results := s.ExecuteQuery(MustParseQuery(`CREATE CONTINUOUS QUERY cq0 ON foo BEGIN SELECT count(value) INTO "other".cpu_count FROM "raw".cpu GROUP BY time(10m) END`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
 
-// mustParseTime parses an IS0-8601 string. Panic on error.
-func mustParseTime(s string) time.Time {
-	t, err := time.Parse(time.RFC3339, s)
-	if err != nil {
-		panic(err.Error())
+	results = s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY raw ON foo RENAME TO hourly`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
 	}
-	return t
-}
+	s.Restart()
 
-// MustParseQuery parses an InfluxQL query. Panic on error.
-func MustParseQuery(s string) *influxql.Query {
-	q, err := influxql.NewParser(strings.NewReader(s)).ParseQuery()
-	if err != nil {
-		panic(err.Error())
+	if rp, _ := s.DefaultRetentionPolicy("foo"); rp == nil || rp.Name != "hourly" {
+		t.Fatalf("unexpected default retention policy: %#v", rp)
+	}
+	if a := s.ContinuousQueries("foo"); len(a) != 1 {
+		t.Fatalf("unexpected continuous query count: %d", len(a))
+	} else if a[0].Query != `CREATE CONTINUOUS QUERY cq0 ON foo BEGIN SELECT count(value) INTO "other".cpu_count FROM "hourly"."cpu" GROUP BY time(10m) END` {
+		t.Fatalf("unexpected continuous query: %s", a[0].Query)
 	}
-	return q
-}
 
-// MustParseSelectStatement parses an InfluxQL select statement. Panic on error.
-func MustParseSelectStatement(s string) *influxql.SelectStatement {
-	stmt, err := influxql.NewParser(strings.NewReader(s)).ParseStatement()
-	if err != nil {
-		panic(err.Error())
+	name := "other"
+	if err := s.UpdateRetentionPolicy("foo", "hourly", &influxdb.RetentionPolicyUpdate{Name: &name}); err != influxdb.ErrRetentionPolicyExists {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	return stmt.(*influxql.SelectStatement)
 }
 
-// errstr is an ease-of-use function to convert an error to a string.
-func errstr(err error) string {
-	if err != nil {
-		return err.Error()
+// Ensure historical writes return the same results through the backfill path.
+func TestServer_WriteSeries_Backfill(t *testing.T) {
+	for _, threshold := range []time.Duration{influxdb.DefaultBackfillThreshold, 0} {
+		s := OpenServer(NewMessagingClient())
+		s.BackfillThreshold = threshold
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+
+		// Write points out of order with a duplicate timestamp in one batch.
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{
+			{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Fields: map[string]interface{}{"value": float64(3)}},
+			{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Fields: map[string]interface{}{"value": float64(1)}},
+			{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(100)}},
+			{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Fields: map[string]interface{}{"value": float64(2)}},
+		})
+
+		results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("unexpected error: %s", res.Err)
+		} else if s := mustMarshalJSON(res); s != `{"series":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",1],["2000-01-01T00:00:10Z",2],["2000-01-01T00:00:20Z",3]]}]}` {
+			t.Fatalf("unexpected row(%s): %s", threshold, s)
+		}
+		s.Close()
 	}
-	return ""
 }
-
-func warn(v ...interface{})              { fmt.Fprintln(os.Stderr, v...) }
-func warnf(msg string, v ...interface{}) { fmt.Fprintf(os.Stderr, msg+"\n", v...) }
@@ -4,6 +4,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -34,9 +38,18 @@ func (g *ShardGroup) ShardBySeriesID(seriesID uint32) *Shard {
 // shard is assigned to the server's data node id.
 type Shard struct {
 	ID          uint64   `json:"id,omitempty"`
+	writeN      uint64   // write transactions committed, accessed atomically
+	lastWrite   int64    // time of the last write in nanoseconds, accessed atomically
+	lastRead    int64    // time the store was last used in nanoseconds, accessed atomically
 	DataNodeIDs []uint64 `json:"nodeIDs,omitempty"` // owners
 
+	mu    sync.RWMutex // held for reading while the store is used and for writing when it is replaced
 	store *bolt.DB
+	cold  *coldShard // set if the store has been offloaded to an object store
+
+	openErr error // set if the store couldn't be opened when the server started
+
+	checksumPolicy string // how points that don't match their checksum are read
 }
 
 // newShardGroup returns a new initialized ShardGroup instance.
@@ -79,9 +92,15 @@ func (s *Shard) open(path string) error {
 		return err
 	}
 	s.store = store
+	if fi, err := os.Stat(path); err == nil {
+		atomic.StoreInt64(&s.lastWrite, fi.ModTime().UnixNano())
+	}
 
-	// Initialize store.
+	// Initialize store. New stores hold checksums.
 	if err := s.store.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("values")) == nil {
+			_, _ = tx.CreateBucketIfNotExists(checksumsBucket)
+		}
 		_, _ = tx.CreateBucketIfNotExists([]byte("values"))
 		return nil
 	}); err != nil {
@@ -92,12 +111,103 @@ func (s *Shard) open(path string) error {
 	return nil
 }
 
-// close shuts down the shard's store.
+// close shuts down the shard's store. The store is closed once all open
+// transactions have finished.
 func (s *Shard) close() error {
+	s.mu.Lock()
+	store := s.store
+	s.store = nil
+	s.mu.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	return store.Close()
+}
+
+// path returns the path to the shard's store or an empty string if the shard
+// is not stored locally.
+func (s *Shard) path() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.store == nil {
+		return ""
+	}
+	return s.store.Path()
+}
+
+// rlock read-locks the shard for using its store. An offloaded store is
+// fetched from its object store first.
+func (s *Shard) rlock() error {
+	atomic.StoreInt64(&s.lastRead, time.Now().UnixNano())
+	for {
+		s.mu.RLock()
+		if err := s.openErr; err != nil {
+			s.mu.RUnlock()
+			return fmt.Errorf("shard %d unavailable: %s", s.ID, err)
+		}
+		c := s.cold
+		if s.store != nil || c == nil {
+			return nil
+		}
+		s.mu.RUnlock()
+
+		if err := s.fetch(c); err != nil {
+			return fmt.Errorf("fetch shard %d: %s", s.ID, err)
+		}
+	}
+}
+
+// begin starts a read-only transaction on the shard's store.
+func (s *Shard) begin() (*bolt.Tx, error) {
+	if err := s.rlock(); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+	if s.store == nil {
+		return nil, bolt.ErrDatabaseNotOpen
+	}
+	return s.store.Begin(false)
+}
+
+// view executes fn within a read-only transaction on the shard's store.
+// It is a no-op if the shard is not stored locally.
+func (s *Shard) view(fn func(*bolt.Tx) error) error {
+	if err := s.rlock(); err != nil {
+		return err
+	}
+	defer s.mu.RUnlock()
+	if s.store == nil {
+		return nil
+	}
+	return s.store.View(fn)
+}
+
+// update executes fn within a read-write transaction on the shard's store.
+// It is a no-op if the shard is not stored locally.
+func (s *Shard) update(fn func(*bolt.Tx) error) error {
+	if err := s.rlock(); err != nil {
+		return err
+	}
+	defer s.mu.RUnlock()
 	if s.store == nil {
 		return nil
 	}
-	return s.store.Close()
+	if err := s.store.Update(fn); err != nil {
+		return err
+	}
+	atomic.AddUint64(&s.writeN, 1)
+	atomic.StoreInt64(&s.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+// copyFile writes a consistent copy of the shard's store to path. Writes to the
+// shard are not blocked while it is copied. It is a no-op if the shard is not
+// stored locally.
+func (s *Shard) copyFile(path string) error {
+	return s.view(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
 }
 
 // HasDataNodeID return true if the data node owns the shard.
@@ -112,7 +222,7 @@ func (s *Shard) HasDataNodeID(id uint64) bool {
 
 // readSeries reads encoded series data from a shard.
 func (s *Shard) readSeries(seriesID uint32, timestamp int64) (values []byte, err error) {
-	err = s.store.View(func(tx *bolt.Tx) error {
+	err = s.view(func(tx *bolt.Tx) error {
 		// Find series bucket.
 		b := tx.Bucket(u32tob(seriesID))
 		if b == nil {
@@ -120,15 +230,82 @@ func (s *Shard) readSeries(seriesID uint32, timestamp int64) (values []byte, err
 		}
 
 		// Retrieve encoded series data.
-		values = b.Get(u64tob(uint64(timestamp)))
+		key := u64tob(uint64(timestamp))
+		if v := b.Get(key); v != nil {
+			values, _, err = s.pointCodec(tx).decode(seriesID, key, v)
+		}
+		return err
+	})
+	return
+}
+
+// lastTimestamp returns the timestamp of the most recent point in a series.
+// Returns false if the shard holds no points for the series.
+func (s *Shard) lastTimestamp(seriesID uint32) (timestamp int64, ok bool, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		// Find series bucket.
+		b := tx.Bucket(u32tob(seriesID))
+		if b == nil {
+			return nil
+		}
+
+		// Keys are big-endian timestamps so the last key is the newest point.
+		if k, _ := b.Cursor().Last(); k != nil {
+			timestamp, ok = int64(btou64(k)), true
+		}
 		return nil
 	})
 	return
 }
 
-// writeSeries writes series batch to a shard.
-func (s *Shard) writeSeries(batch []byte) error {
-	return s.store.Update(func(tx *bolt.Tx) error {
+// pointMergeFunc resolves a write to a series and timestamp that already
+// holds a point. It returns the data to store or nil to keep the existing point.
+// An error fails the whole write message.
+type pointMergeFunc func(seriesID uint32, existing, data []byte) ([]byte, error)
+
+// shardMetaBucket holds the bookkeeping of a shard's store. Its name must not
+// be 4 bytes long as those buckets are series.
+var shardMetaBucket = []byte("shardmeta")
+
+// appliedIndexKey is the key of the index of the last write message applied
+// to a shard's store.
+var appliedIndexKey = []byte("appliedIndex")
+
+// errWriteApplied is returned when a write message has already been applied
+// to a shard, such as when the broker redelivers it after a reconnect.
+var errWriteApplied = errors.New("write already applied")
+
+// markApplied records the index of a write message in the same transaction
+// that applies it, so each message is applied exactly once. Returns
+// errWriteApplied if the message or a later one has already been applied.
+// An index of zero is not recorded.
+func markApplied(tx *bolt.Tx, index uint64) error {
+	if index == 0 {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists(shardMetaBucket)
+	if err != nil {
+		return err
+	}
+	if v := b.Get(appliedIndexKey); v != nil && btou64(v) >= index {
+		return errWriteApplied
+	}
+	return b.Put(appliedIndexKey, u64tob(index))
+}
+
+// writeSeries writes series batch from the write message at index to a shard.
+// If merge is nil then existing points are overwritten. Returns
+// errWriteApplied if the message has already been applied, or
+// ErrDuplicatePointRejected once the batch is written if merge kept an
+// existing point.
+func (s *Shard) writeSeries(index uint64, batch []byte, merge pointMergeFunc) error {
+	var rejected bool
+	if err := s.update(func(tx *bolt.Tx) error {
+		if err := markApplied(tx, index); err != nil {
+			return err
+		}
+
+		codec := s.pointCodec(tx)
 		for {
 			if pointHeaderSize > len(batch) {
 				return ErrInvalidPointBuffer
@@ -147,8 +324,24 @@ func (s *Shard) writeSeries(batch []byte) error {
 				return err
 			}
 
+			// Resolve duplicate points, if necessary.
+			key := u64tob(uint64(timestamp))
+			if merge != nil {
+				if v := b.Get(key); v != nil {
+					if existing, ok, err := codec.decode(seriesID, key, v); err != nil {
+						return err
+					} else if ok {
+						if data, err = merge(seriesID, existing, data); err != nil {
+							return err
+						}
+					}
+				}
+			}
+
 			// Insert the values by timestamp.
-			if err := b.Put(u64tob(uint64(timestamp)), data); err != nil {
+			if data == nil {
+				rejected = true
+			} else if err := b.Put(key, codec.encode(key, data)); err != nil {
 				return err
 			}
 
@@ -160,14 +353,153 @@ func (s *Shard) writeSeries(batch []byte) error {
 		}
 
 		return nil
-	})
+	}); err != nil {
+		return err
+	} else if rejected {
+		return ErrDuplicatePointRejected
+	}
+	return nil
 }
 
-func (s *Shard) dropSeries(seriesID uint32) error {
-	if s.store == nil {
+// writeBackfill writes a batch of historical points to the shard. Points are
+// sorted by series and time so each series bucket is appended to in order and
+// pages are packed fully since few writes are expected to follow. Points with
+// the same series and timestamp are applied in the order they were written.
+// Returns errWriteApplied if the message at index has already been applied,
+// or ErrDuplicatePointRejected once the batch is written if merge kept an
+// existing point.
+func (s *Shard) writeBackfill(index uint64, batch []byte, merge pointMergeFunc) error {
+	points, err := unmarshalPointBatch(batch)
+	if err != nil {
+		return err
+	}
+	sort.Stable(rawPoints(points))
+
+	var rejected bool
+	if err := s.update(func(tx *bolt.Tx) error {
+		if err := markApplied(tx, index); err != nil {
+			return err
+		}
+
+		codec := s.pointCodec(tx)
+		var b *bolt.Bucket
+		for i, p := range points {
+			// Move to the next series bucket.
+			if i == 0 || p.seriesID != points[i-1].seriesID {
+				var err error
+				if b, err = tx.CreateBucketIfNotExists(u32tob(p.seriesID)); err != nil {
+					return err
+				}
+				b.FillPercent = 1.0
+			}
+
+			// Resolve duplicate points, if necessary.
+			key, data := u64tob(uint64(p.timestamp)), p.data
+			if merge != nil {
+				if v := b.Get(key); v != nil {
+					if existing, ok, err := codec.decode(p.seriesID, key, v); err != nil {
+						return err
+					} else if ok {
+						if data, err = merge(p.seriesID, existing, data); err != nil {
+							return err
+						}
+					}
+				}
+			}
+
+			if data == nil {
+				rejected = true
+			} else if err := b.Put(key, codec.encode(key, data)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	} else if rejected {
+		return ErrDuplicatePointRejected
+	}
+	return nil
+}
+
+// rewriteSeries replaces the encoded data of every point in a series with the
+// result of fn. Points are removed if fn returns no data.
+func (s *Shard) rewriteSeries(seriesID uint32, fn func(data []byte) []byte) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(u32tob(seriesID))
+		if b == nil {
+			return nil
+		}
+
+		// Collect the rewritten points first since the bucket cannot be
+		// modified while it is being iterated over. Points left out by the
+		// checksum policy are left as they are.
+		codec := s.pointCodec(tx)
+		var keys, values [][]byte
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			data, ok, err := codec.decode(seriesID, k, v)
+			if err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			keys = append(keys, append([]byte(nil), k...))
+			values = append(values, fn(data))
+		}
+
+		for i, k := range keys {
+			if len(values[i]) == 0 {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			} else if err := b.Put(k, codec.encode(k, values[i])); err != nil {
+				return err
+			}
+		}
 		return nil
+	})
+}
+
+// deleteSeriesBefore removes the points in a series with a timestamp before t.
+// Points are removed in batches and wait is called after each batch of n bytes
+// is removed and may block to throttle the deletes.
+func (s *Shard) deleteSeriesBefore(seriesID uint32, t int64, wait func(n int)) error {
+	for {
+		var n int
+		if err := s.update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(u32tob(seriesID))
+			if b == nil {
+				return nil
+			}
+
+			// Keys are big-endian timestamps so expired points come first. They
+			// are collected first since the bucket cannot be modified while it is
+			// being iterated over.
+			var keys [][]byte
+			c := b.Cursor()
+			for k, v := c.First(); k != nil && int64(btou64(k)) < t && n < ioBatchSize; k, v = c.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+				n += len(k) + len(v)
+			}
+
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		} else if n == 0 {
+			return nil
+		}
+		wait(n)
 	}
-	return s.store.Update(func(tx *bolt.Tx) error {
+}
+
+func (s *Shard) dropSeries(seriesID uint32) error {
+	return s.update(func(tx *bolt.Tx) error {
 		err := tx.DeleteBucket(u32tob(seriesID))
 		if err != bolt.ErrBucketNotFound {
 			return err
@@ -176,6 +508,169 @@ func (s *Shard) dropSeries(seriesID uint32) error {
 	})
 }
 
+// writeCount returns the number of write transactions committed to the shard.
+func (s *Shard) writeCount() uint64 { return atomic.LoadUint64(&s.writeN) }
+
+// errShardModified is returned when a shard is written to or closed while it is
+// being compacted. The compaction is abandoned and retried later.
+var errShardModified = errors.New("shard modified during compaction")
+
+// compactionBatchSize is the number of bytes copied per transaction while compacting.
+const compactionBatchSize = 1 << 20
+
+// lastWriteTime returns the time of the last write to the shard. The
+// modification time of the store is used for writes before the shard was opened.
+func (s *Shard) lastWriteTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastWrite)).UTC()
+}
+
+// diskStats returns the number of series in the shard, the size of its file
+// and the number of those bytes that hold data. Offloaded stores are not
+// fetched and have no stats.
+func (s *Shard) diskStats() (seriesN int, size, inuse int64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.store == nil {
+		return
+	}
+	err = s.store.View(func(tx *bolt.Tx) error {
+		fi, err := os.Stat(tx.DB().Path())
+		if err != nil {
+			return err
+		}
+		size = fi.Size()
+
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			// Series buckets are keyed by their 4-byte series id.
+			if len(name) == 4 {
+				seriesN++
+			}
+			stats := b.Stats()
+			inuse += int64(stats.BranchInuse + stats.LeafInuse + stats.InlineBucketInuse)
+			return nil
+		})
+	})
+	return
+}
+
+// compact copies the shard's store into a new file with fully packed pages and
+// replaces the store with it. Reads continue against the current store while it
+// is copied. wait is called after each batch of n bytes is written and may block
+// to throttle the copy. Returns errShardModified if the shard was written to or
+// closed before the copy completed.
+func (s *Shard) compact(wait func(n int)) error {
+	// Start a read transaction and note the number of writes it includes.
+	s.mu.RLock()
+	store, writeN := s.store, atomic.LoadUint64(&s.writeN)
+	if store == nil {
+		s.mu.RUnlock()
+		return errShardModified
+	}
+	src, err := store.Begin(false)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Rollback() }()
+
+	// Copy into a file alongside the store.
+	path := store.Path()
+	tmppath := path + ".compact"
+	if err := s.copyTo(src, store, tmppath, wait); err != nil {
+		_ = os.Remove(tmppath)
+		return err
+	}
+	_ = src.Rollback()
+
+	// Replace the store unless it changed while copying.
+	s.mu.Lock()
+	if s.store != store || atomic.LoadUint64(&s.writeN) != writeN {
+		s.mu.Unlock()
+		_ = os.Remove(tmppath)
+		return errShardModified
+	}
+	if err := os.Rename(tmppath, path); err != nil {
+		s.mu.Unlock()
+		_ = os.Remove(tmppath)
+		return err
+	}
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("reopen: %s", err)
+	}
+	s.store = dst
+	s.mu.Unlock()
+
+	// Close the old store once in-flight reads have finished.
+	return store.Close()
+}
+
+// copyTo writes every bucket in src to a new store at path. Points copied
+// from a store without checksums are given checksums. The copy is abandoned
+// if the shard's store is replaced or closed.
+func (s *Shard) copyTo(src *bolt.Tx, store *bolt.DB, path string, wait func(n int)) error {
+	_ = os.Remove(path)
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dst.Close() }()
+
+	if err := dst.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(checksumsBucket)
+		return err
+	}); err != nil {
+		return err
+	}
+	addChecksums := src.Bucket(checksumsBucket) == nil
+	codec := &pointCodec{shardID: s.ID, checksums: true}
+
+	return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+		// Series buckets are keyed by their 4-byte series id.
+		series := len(name) == 4
+		c := b.Cursor()
+		k, v := c.First()
+		for {
+			// Stop if the shard was closed.
+			s.mu.RLock()
+			closed := s.store != store
+			s.mu.RUnlock()
+			if closed {
+				return errShardModified
+			}
+
+			// Copy the next batch of keys. Keys are copied in order so pages can be filled completely.
+			var n int
+			if err := dst.Update(func(tx *bolt.Tx) error {
+				bkt, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				bkt.FillPercent = 1.0
+
+				for ; k != nil && n < compactionBatchSize; k, v = c.Next() {
+					if series && addChecksums {
+						v = codec.encode(k, v)
+					}
+					if err := bkt.Put(k, v); err != nil {
+						return err
+					}
+					n += len(k) + len(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			wait(n)
+
+			if k == nil {
+				return nil
+			}
+		}
+	})
+}
+
 // Shards represents a list of shards.
 type Shards []*Shard
 
@@ -199,8 +694,51 @@ func unmarshalPointHeader(b []byte) (seriesID uint32, payloadLength uint32, time
 	return
 }
 
+// rawPoint represents an encoded point within a write batch.
+type rawPoint struct {
+	seriesID  uint32
+	timestamp int64
+	data      []byte
+}
+
+// unmarshalPointBatch splits a write batch into its encoded points.
+func unmarshalPointBatch(batch []byte) (a []rawPoint, err error) {
+	for len(batch) > 0 {
+		if pointHeaderSize > len(batch) {
+			return nil, ErrInvalidPointBuffer
+		}
+		seriesID, payloadLength, timestamp := unmarshalPointHeader(batch[:pointHeaderSize])
+		batch = batch[pointHeaderSize:]
+
+		if payloadLength > uint32(len(batch)) {
+			return nil, ErrInvalidPointBuffer
+		}
+		a = append(a, rawPoint{seriesID: seriesID, timestamp: timestamp, data: batch[:payloadLength]})
+		batch = batch[payloadLength:]
+	}
+	return a, nil
+}
+
+// rawPoints sorts points by series id and then timestamp.
+type rawPoints []rawPoint
+
+func (a rawPoints) Len() int      { return len(a) }
+func (a rawPoints) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a rawPoints) Less(i, j int) bool {
+	if a[i].seriesID != a[j].seriesID {
+		return a[i].seriesID < a[j].seriesID
+	}
+	return a[i].timestamp < a[j].timestamp
+}
+
 type uint8Slice []uint8
 
 func (p uint8Slice) Len() int           { return len(p) }
 func (p uint8Slice) Less(i, j int) bool { return p[i] < p[j] }
 func (p uint8Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type uint64Slice []uint64
+
+func (p uint64Slice) Len() int           { return len(p) }
+func (p uint64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,138 @@
+package influxdb
+
+import (
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// DefaultSubscriptionBufferN is the number of points buffered for a
+// subscription before it is closed as a slow subscriber.
+const DefaultSubscriptionBufferN = 1000
+
+// PointFilter selects points written to a database.
+type PointFilter struct {
+	Database        string
+	RetentionPolicy string            // matches all retention policies if blank
+	Measurement     string            // matches all measurements if blank
+	Tags            map[string]string // tag values a point must have
+	Condition       influxql.Expr     // evaluated against the point's tags and fields, optional
+}
+
+// Match returns true if a point written to database and retentionPolicy passes the filter.
+func (f *PointFilter) Match(database, retentionPolicy string, p *Point) bool {
+	if f.Database != database {
+		return false
+	} else if f.RetentionPolicy != "" && f.RetentionPolicy != retentionPolicy {
+		return false
+	} else if f.Measurement != "" && f.Measurement != p.Name {
+		return false
+	}
+	for k, v := range f.Tags {
+		if p.Tags[k] != v {
+			return false
+		}
+	}
+
+	if f.Condition != nil {
+		m := make(map[string]interface{}, len(p.Tags)+len(p.Fields))
+		for k, v := range p.Tags {
+			m[k] = v
+		}
+		for k, v := range p.Fields {
+			m[k] = v
+		}
+		if ok, _ := influxql.Eval(f.Condition, m).(bool); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscription receives points written through the server that match a filter.
+// Points are delivered on C until the subscription is closed. If the
+// subscriber falls more than the buffer size behind then the subscription is
+// closed and Err returns ErrSlowSubscriber.
+type Subscription struct {
+	Filter PointFilter
+
+	server *Server
+	c      chan Point
+	err    error
+}
+
+// Subscribe returns a subscription to points written to the server that match
+// the filter. Up to bufferN points are buffered for the subscriber.
+func (s *Server) Subscribe(f PointFilter, bufferN int) (*Subscription, error) {
+	if !s.DatabaseExists(f.Database) {
+		return nil, ErrDatabaseNotFound
+	}
+	if bufferN <= 0 {
+		bufferN = DefaultSubscriptionBufferN
+	}
+
+	sub := &Subscription{Filter: f, server: s, c: make(chan Point, bufferN)}
+	s.subMu.Lock()
+	s.subscriptions[sub] = struct{}{}
+	s.subMu.Unlock()
+	return sub, nil
+}
+
+// C returns the channel points are delivered on. It is closed when the subscription closes.
+func (sub *Subscription) C() <-chan Point { return sub.c }
+
+// Err returns the reason the subscription was closed by the server, if any.
+func (sub *Subscription) Err() error {
+	sub.server.subMu.Lock()
+	defer sub.server.subMu.Unlock()
+	return sub.err
+}
+
+// Close stops delivery of points to the subscription.
+func (sub *Subscription) Close() {
+	sub.server.subMu.Lock()
+	defer sub.server.subMu.Unlock()
+	sub.server.closeSubscription(sub, nil)
+}
+
+// closeSubscription removes a subscription and closes its channel.
+// The caller must hold the subscription lock.
+func (s *Server) closeSubscription(sub *Subscription, err error) {
+	if _, ok := s.subscriptions[sub]; !ok {
+		return
+	}
+	delete(s.subscriptions, sub)
+	sub.err = err
+	close(sub.c)
+}
+
+// renameSubscriptions updates the database and retention policy of each
+// subscription's filter to the names returned by fn.
+func (s *Server) renameSubscriptions(fn func(database, policy string) (string, string)) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for sub := range s.subscriptions {
+		sub.Filter.Database, sub.Filter.RetentionPolicy = fn(sub.Filter.Database, sub.Filter.RetentionPolicy)
+	}
+}
+
+// publishPoints delivers written points to matching subscriptions.
+// Subscriptions that cannot keep up are closed.
+func (s *Server) publishPoints(database, retentionPolicy string, points []Point) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	for sub := range s.subscriptions {
+		for i := range points {
+			if !sub.Filter.Match(database, retentionPolicy, &points[i]) {
+				continue
+			}
+			select {
+			case sub.c <- points[i]:
+			default:
+				s.closeSubscription(sub, ErrSlowSubscriber)
+			}
+			if _, ok := s.subscriptions[sub]; !ok {
+				break
+			}
+		}
+	}
+}
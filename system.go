@@ -0,0 +1,229 @@
+package influxdb
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// System measurements are read-only measurements that expose the metadata of
+// a database to SELECT statements, such as "SELECT * FROM _shards". They are
+// generated when queried and can't be written to. The retention policy in a
+// system measurement's name is ignored as they cover the whole database.
+const (
+	// ShardsMeasurement has a row for each shard of the database stored on
+	// this data node.
+	ShardsMeasurement = "_shards"
+
+	// ShardGroupsMeasurement has a row for each shard group of the database.
+	ShardGroupsMeasurement = "_shard_groups"
+
+	// SeriesMeasurement has a row for each series of the database, with a
+	// column for each tag key.
+	SeriesMeasurement = "_series"
+
+	// RetentionPoliciesMeasurement has a row for each retention policy of
+	// the database.
+	RetentionPoliciesMeasurement = "_retention_policies"
+)
+
+// IsSystemMeasurement returns true if name is the name of a system measurement.
+func IsSystemMeasurement(name string) bool {
+	switch name {
+	case ShardsMeasurement, ShardGroupsMeasurement, SeriesMeasurement, RetentionPoliciesMeasurement:
+		return true
+	}
+	return false
+}
+
+// executeSystemSelectStatement executes a select statement against a system
+// measurement of a database. Only columns can be selected. Column names avoid
+// keywords so they don't need to be quoted. Rows are filtered
+// by the condition, which can compare columns with number, string and
+// boolean literals, and limited by LIMIT and OFFSET.
+func (s *Server) executeSystemSelectStatement(stmt *influxql.SelectStatement, database, name string) *Result {
+	if stmt.Target != nil || len(stmt.Dimensions) > 0 {
+		return &Result{Err: ErrInvalidSystemQuery}
+	}
+
+	row, err := s.systemMeasurement(database, name)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	// Map selected columns to their position in the full row.
+	var columns []string
+	var indexes []int
+	for _, f := range stmt.Fields {
+		switch expr := f.Expr.(type) {
+		case *influxql.Wildcard:
+			for i, c := range row.Columns {
+				columns, indexes = append(columns, c), append(indexes, i)
+			}
+		case *influxql.VarRef:
+			i := columnIndex(row.Columns, expr.Val)
+			if i == -1 {
+				return &Result{Err: fmt.Errorf("column not found: %s", expr.Val)}
+			}
+			columns, indexes = append(columns, f.Name()), append(indexes, i)
+		default:
+			return &Result{Err: ErrInvalidSystemQuery}
+		}
+	}
+
+	// Filter the rows and select the columns.
+	other := &influxql.Row{Name: row.Name, Columns: columns, Values: [][]interface{}{}}
+	offset := stmt.Offset
+	for _, values := range row.Values {
+		if stmt.Condition != nil {
+			m := make(map[string]interface{}, len(values))
+			for i, c := range row.Columns {
+				m[c] = systemEvalValue(values[i])
+			}
+			if ok, _ := influxql.Eval(stmt.Condition, m).(bool); !ok {
+				continue
+			}
+		}
+		if offset > 0 {
+			offset--
+			continue
+		} else if stmt.Limit > 0 && len(other.Values) == stmt.Limit {
+			break
+		}
+
+		selected := make([]interface{}, len(indexes))
+		for i, j := range indexes {
+			selected[i] = values[j]
+		}
+		other.Values = append(other.Values, selected)
+	}
+	return &Result{Series: []*influxql.Row{other}}
+}
+
+// systemMeasurement returns every row of a system measurement of a database.
+func (s *Server) systemMeasurement(database, name string) (*influxql.Row, error) {
+	if name == ShardsMeasurement {
+		a, err := s.ShardStats(database)
+		if err != nil {
+			return nil, err
+		}
+		row := &influxql.Row{
+			Name: name,
+			Columns: []string{"time", "id", "retention_policy", "shard_group", "end_time",
+				"series_count", "disk_bytes", "unused_bytes", "compaction_pending", "offloaded", "last_write",
+			},
+		}
+		for _, st := range a {
+			row.Values = append(row.Values, []interface{}{st.StartTime, st.ID, st.RetentionPolicy, st.ShardGroupID, st.EndTime,
+				st.SeriesN, st.DiskBytes, st.UnusedBytes, st.CompactionPending, st.Offloaded, st.LastWrite,
+			})
+		}
+		return row, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	db := s.databases[database]
+	if db == nil {
+		return nil, ErrDatabaseNotFound
+	}
+
+	row := &influxql.Row{Name: name}
+	switch name {
+	case ShardGroupsMeasurement:
+		row.Columns = []string{"time", "id", "retention_policy", "end_time", "shard_count"}
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				row.Values = append(row.Values, []interface{}{g.StartTime, g.ID, rp.Name, g.EndTime, len(g.Shards)})
+			}
+		}
+		sort.Sort(rowValuesByColumn{row.Values, 1})
+
+	case SeriesMeasurement:
+		// Each tag key is a column, after the id and measurement name columns.
+		row.Columns = []string{"id", "measurement_name"}
+		keys := make(map[string]string)
+		for _, m := range db.measurements {
+			for _, k := range m.tagKeys() {
+				keys[k] = ""
+			}
+		}
+		index := make(map[string]int)
+		for _, k := range sortedKeys(keys) {
+			if columnIndex(row.Columns, k) == -1 {
+				index[k] = len(row.Columns)
+				row.Columns = append(row.Columns, k)
+			}
+		}
+		for _, series := range db.series {
+			values := make([]interface{}, len(row.Columns))
+			values[0], values[1] = uint64(series.ID), series.measurement.Name
+			for k, v := range series.Tags {
+				if i := index[k]; i > 0 {
+					values[i] = v
+				}
+			}
+			row.Values = append(row.Values, values)
+		}
+		sort.Sort(rowValuesByID(row.Values))
+
+	case RetentionPoliciesMeasurement:
+		row.Columns = []string{"name", "retention_duration", "replica_n", "is_default", "shard_group_duration", "shard_group_count"}
+		for _, rp := range db.policies {
+			row.Values = append(row.Values, []interface{}{rp.Name, rp.Duration.String(), rp.ReplicaN,
+				db.defaultRetentionPolicy == rp.Name, rp.ShardGroupDuration().String(), len(rp.shardGroups),
+			})
+		}
+		sort.Sort(rowValuesByColumn{row.Values, 0})
+
+	default:
+		return nil, fmt.Errorf("measurement not found: %s", name)
+	}
+	return row, nil
+}
+
+// systemEvalValue returns a column value in a form that can be compared by
+// influxql.Eval. Integers are converted to floats and times to strings.
+func systemEvalValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// columnIndex returns the position of a column, or -1 if it doesn't exist.
+func columnIndex(columns []string, name string) int {
+	for i, c := range columns {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// rowValuesByColumn sorts result values by a column of uint64 or string values.
+type rowValuesByColumn struct {
+	values [][]interface{}
+	column int
+}
+
+func (a rowValuesByColumn) Len() int      { return len(a.values) }
+func (a rowValuesByColumn) Swap(i, j int) { a.values[i], a.values[j] = a.values[j], a.values[i] }
+func (a rowValuesByColumn) Less(i, j int) bool {
+	switch v := a.values[i][a.column].(type) {
+	case uint64:
+		return v < a.values[j][a.column].(uint64)
+	case string:
+		return v < a.values[j][a.column].(string)
+	}
+	return false
+}
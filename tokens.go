@@ -0,0 +1,210 @@
+package influxdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// tokenSecretSize is the number of random bytes in a token's secret.
+const tokenSecretSize = 32
+
+// Token represents an API token. Tokens are machine credentials with their own
+// privileges so they can be created and revoked without touching user passwords.
+//
+// Only a hash of the token's secret is stored. The secret is returned once,
+// when the token is created.
+type Token struct {
+	Name       string                        `json:"name"`
+	Hash       string                        `json:"hash"`
+	Admin      bool                          `json:"admin,omitempty"`
+	Privileges map[string]influxql.Privilege `json:"privileges"` // db name to privilege
+	CreatedAt  time.Time                     `json:"createdAt"`
+}
+
+// User returns the user that requests authenticated with the token act as.
+// The user's name is the token name prefixed with "token:".
+func (t *Token) User() *User {
+	u := &User{
+		Name:       "token:" + t.Name,
+		Admin:      t.Admin,
+		Privileges: make(map[string]influxql.Privilege, len(t.Privileges)),
+	}
+	for db, p := range t.Privileges {
+		u.Privileges[db] = p
+	}
+	return u
+}
+
+// hashTokenSecret returns the hex-encoded SHA-256 hash of a token's secret.
+// Secrets are random so they don't need a slow password hash.
+func hashTokenSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// tokens represents a list of API tokens, sortable by name.
+type tokens []*Token
+
+func (p tokens) Len() int           { return len(p) }
+func (p tokens) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p tokens) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// Tokens returns the API tokens on the server, sorted by name.
+func (s *Server) Tokens() (a []*Token) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tokens {
+		a = append(a, t)
+	}
+	sort.Sort(tokens(a))
+	return a
+}
+
+// CreateToken creates an API token with all privileges on the cluster if admin
+// is set, otherwise with the given privileges by database. Returns the token's
+// secret, which is not stored and can't be retrieved later.
+func (s *Server) CreateToken(name string, admin bool, privileges map[string]influxql.Privilege) (string, error) {
+	if name == "" {
+		return "", ErrTokenNameRequired
+	} else if !admin && len(privileges) == 0 {
+		return "", ErrTokenPrivilegesRequired
+	}
+
+	b := make([]byte, tokenSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %s", err)
+	}
+	secret := hex.EncodeToString(b)
+
+	c := &createTokenCommand{
+		Name:       name,
+		Hash:       hashTokenSecret(secret),
+		Admin:      admin,
+		Privileges: privileges,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if _, err := s.broadcast(createTokenMessageType, c); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func (s *Server) applyCreateToken(m *messaging.Message) error {
+	var c createTokenCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	// Validate token.
+	if c.Name == "" {
+		return ErrTokenNameRequired
+	} else if s.tokens[c.Name] != nil {
+		return ErrTokenExists
+	}
+
+	t := &Token{
+		Name:       c.Name,
+		Hash:       c.Hash,
+		Admin:      c.Admin,
+		Privileges: c.Privileges,
+		CreatedAt:  c.CreatedAt,
+	}
+	if t.Privileges == nil {
+		t.Privileges = make(map[string]influxql.Privilege)
+	}
+
+	// Persist to metastore.
+	err := s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		return tx.saveToken(t)
+	})
+
+	s.tokens[t.Name] = t
+	return err
+}
+
+// DropToken revokes an API token. Requests using the token are rejected once
+// the revocation has been applied.
+func (s *Server) DropToken(name string) error {
+	c := &deleteTokenCommand{Name: name}
+	_, err := s.broadcast(deleteTokenMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteToken(m *messaging.Message) error {
+	var c deleteTokenCommand
+	mustUnmarshalJSON(m.Data, &c)
+
+	// Validate token.
+	if c.Name == "" {
+		return ErrTokenNameRequired
+	} else if s.tokens[c.Name] == nil {
+		return ErrTokenNotFound
+	}
+
+	// Remove from metastore.
+	err := s.meta.mustUpdate(m.Index, func(tx *metatx) error {
+		return tx.deleteToken(c.Name)
+	})
+
+	delete(s.tokens, c.Name)
+	return err
+}
+
+// AuthenticateToken returns the user for an API token's secret.
+// Returns an error if no token has the secret.
+func (s *Server) AuthenticateToken(secret string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash := []byte(hashTokenSecret(secret))
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 {
+			return t.User(), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid token")
+}
+
+func (s *Server) executeCreateTokenStatement(q *influxql.CreateTokenStatement, user *User) *Result {
+	secret, err := s.CreateToken(q.Name, q.Admin, q.Privileges)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	row := &influxql.Row{Columns: []string{"name", "token"}, Values: [][]interface{}{{q.Name, secret}}}
+	return &Result{Series: []*influxql.Row{row}}
+}
+
+func (s *Server) executeDropTokenStatement(q *influxql.DropTokenStatement, user *User) *Result {
+	return &Result{Err: s.DropToken(q.Name)}
+}
+
+func (s *Server) executeShowTokensStatement(q *influxql.ShowTokensStatement, user *User) *Result {
+	row := &influxql.Row{Columns: []string{"name", "admin", "privileges", "createdAt"}}
+	for _, t := range s.Tokens() {
+		row.Values = append(row.Values, []interface{}{t.Name, t.Admin, formatTokenPrivileges(t.Privileges), t.CreatedAt})
+	}
+	return &Result{Series: []*influxql.Row{row}}
+}
+
+// formatTokenPrivileges returns privileges as "<privilege> ON <database>"
+// pairs sorted by database.
+func formatTokenPrivileges(privileges map[string]influxql.Privilege) string {
+	names := make([]string, 0, len(privileges))
+	for name := range privileges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	a := make([]string, len(names))
+	for i, name := range names {
+		a[i] = privileges[name].String() + " ON " + name
+	}
+	return strings.Join(a, ", ")
+}
@@ -0,0 +1,189 @@
+// Package transport manages the HTTP connections used between nodes.
+//
+// Brokers, raft peers and data nodes talk to each other over HTTP. Rather than
+// dialing a new connection per request, requests are sent through a Manager
+// which keeps a pool of keep-alive connections to each peer and limits how
+// many connections can be open to a peer at once.
+package transport
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxConnsPerPeer is the default limit on open connections to a peer.
+	DefaultMaxConnsPerPeer = 64
+
+	// DefaultMaxIdleConnsPerPeer is the default number of keep-alive
+	// connections kept open to a peer between requests.
+	DefaultMaxIdleConnsPerPeer = 16
+
+	// DefaultIdleConnTimeout is the default time an unused connection is kept.
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// DefaultDialTimeout is the default time allowed to connect to a peer.
+	DefaultDialTimeout = 10 * time.Second
+
+	// maxDrainSize is the most bytes read from an unread response body so
+	// that its connection can be reused.
+	maxDrainSize = 64 * 1024
+)
+
+// Default is the manager used for node communication unless another is set.
+var Default = NewManager()
+
+// Manager sends requests to peers over pooled connections. Each peer, keyed
+// by its scheme and host, has its own pool of connections.
+//
+// The limits and TLS settings apply to pools created after they are set so
+// they should be changed before the manager is used.
+type Manager struct {
+	mu    sync.Mutex
+	peers map[string]*http.Transport
+	swept time.Time // last time idle connections were closed
+
+	// Maximum number of connections open to a single peer, including those
+	// in use. Requests wait for a free connection once the limit is reached.
+	// Unlimited if zero.
+	MaxConnsPerPeer int
+
+	// Maximum number of idle keep-alive connections kept for a single peer.
+	MaxIdleConnsPerPeer int
+
+	// Idle connections are closed by the first request sent once this much
+	// time has passed since they were last closed. Kept if zero.
+	IdleConnTimeout time.Duration
+
+	// Time allowed to connect to a peer.
+	DialTimeout time.Duration
+
+	// TLS configuration used for peers with an https URL.
+	TLSConfig *tls.Config
+}
+
+// NewManager returns a new instance of Manager with default limits.
+func NewManager() *Manager {
+	return &Manager{
+		peers:               make(map[string]*http.Transport),
+		MaxConnsPerPeer:     DefaultMaxConnsPerPeer,
+		MaxIdleConnsPerPeer: DefaultMaxIdleConnsPerPeer,
+		IdleConnTimeout:     DefaultIdleConnTimeout,
+		DialTimeout:         DefaultDialTimeout,
+	}
+}
+
+// Client returns an HTTP client that sends requests through the manager.
+// Requests are not given a deadline if timeout is zero.
+func (m *Manager) Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: m, Timeout: timeout}
+}
+
+// RoundTrip sends a request over a connection from the peer's pool.
+// Implements http.RoundTripper.
+func (m *Manager) RoundTrip(req *http.Request) (*http.Response, error) {
+	return m.transport(req.URL.Scheme, req.URL.Host).RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections to every peer.
+func (m *Manager) CloseIdleConnections() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.peers {
+		t.CloseIdleConnections()
+	}
+}
+
+// Peers returns the keys of the peers that have a connection pool, sorted.
+func (m *Manager) Peers() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := make([]string, 0, len(m.peers))
+	for k := range m.peers {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// transport returns the pool for a peer, creating it if needed.
+func (m *Manager) transport(scheme, host string) *http.Transport {
+	key := scheme + "://" + host
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.peers == nil {
+		m.peers = make(map[string]*http.Transport)
+	}
+
+	// Close idle connections once the idle timeout has passed.
+	if now := time.Now(); m.IdleConnTimeout > 0 && now.Sub(m.swept) >= m.IdleConnTimeout {
+		for _, t := range m.peers {
+			t.CloseIdleConnections()
+		}
+		m.swept = now
+	}
+
+	if t := m.peers[key]; t != nil {
+		return t
+	}
+
+	t := &http.Transport{
+		Dial:                m.dial(),
+		MaxIdleConnsPerHost: m.MaxIdleConnsPerPeer,
+		TLSHandshakeTimeout: m.DialTimeout,
+		TLSClientConfig:     m.TLSConfig,
+	}
+	m.peers[key] = t
+	return t
+}
+
+// dial returns a dial function for a new pool. The function waits for one of
+// the pool's connections to close once MaxConnsPerPeer are open.
+func (m *Manager) dial() func(network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: m.DialTimeout, KeepAlive: 30 * time.Second}
+	if m.MaxConnsPerPeer <= 0 {
+		return d.Dial
+	}
+
+	sem := make(chan struct{}, m.MaxConnsPerPeer)
+	return func(network, addr string) (net.Conn, error) {
+		sem <- struct{}{}
+		c, err := d.Dial(network, addr)
+		if err != nil {
+			<-sem
+			return nil, err
+		}
+		return &peerConn{Conn: c, release: func() { <-sem }}, nil
+	}
+}
+
+// peerConn is a pooled connection that frees its slot in the pool when closed.
+type peerConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close closes the connection and frees its slot.
+func (c *peerConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// CloseBody reads the rest of a response body and closes it so that its
+// connection is returned to the pool. Large bodies are not read in full;
+// their connection is closed instead.
+func CloseBody(resp *http.Response) error {
+	_, _ = io.CopyN(ioutil.Discard, resp.Body, maxDrainSize)
+	return resp.Body.Close()
+}
@@ -0,0 +1,158 @@
+package transport_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/transport"
+)
+
+// Ensure that sequential requests to a peer reuse a single connection.
+func TestManager_ReuseConnections(t *testing.T) {
+	var mu sync.Mutex
+	conns := make(map[string]struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr] = struct{}{}
+		mu.Unlock()
+		fmt.Fprint(w, "ok")
+	}))
+	defer s.Close()
+
+	m := transport.NewManager()
+	c := m.Client(0)
+	for i := 0; i < 10; i++ {
+		resp, err := c.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := transport.CloseBody(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(conns) != 1 {
+		t.Fatalf("unexpected connection count: %d", len(conns))
+	} else if peers := m.Peers(); !reflect.DeepEqual(peers, []string{"http://" + s.Listener.Addr().String()}) {
+		t.Fatalf("unexpected peers: %v", peers)
+	}
+}
+
+// Ensure that concurrent requests to a peer wait once the connection limit is reached.
+func TestManager_MaxConnsPerPeer(t *testing.T) {
+	var mu sync.Mutex
+	var active, max int
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if active++; active > max {
+			max = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	var n int
+	s.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			n++
+			mu.Unlock()
+		}
+	}
+	s.Start()
+	defer s.Close()
+
+	m := transport.NewManager()
+	m.MaxConnsPerPeer = 2
+	c := m.Client(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get(s.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = transport.CloseBody(resp)
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if n > 2 {
+		t.Fatalf("unexpected connection count: %d", n)
+	} else if max > 2 {
+		t.Fatalf("unexpected concurrent requests: %d", max)
+	}
+}
+
+// Ensure that idle connections are closed once the idle timeout has passed.
+func TestManager_IdleConnTimeout(t *testing.T) {
+	var mu sync.Mutex
+	conns := make(map[string]struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr] = struct{}{}
+		mu.Unlock()
+	}))
+	defer s.Close()
+
+	m := transport.NewManager()
+	m.IdleConnTimeout = 10 * time.Millisecond
+	c := m.Client(0)
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(s.URL)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := transport.CloseBody(resp); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conns) != 2 {
+		t.Fatalf("unexpected connection count: %d", len(conns))
+	}
+}
+
+// Ensure that requests to peers served over TLS use the TLS configuration.
+func TestManager_TLS(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS != nil)
+	}))
+	defer s.Close()
+
+	// The server's certificate isn't trusted by default.
+	if _, err := transport.NewManager().Client(0).Get(s.URL); err == nil {
+		t.Fatal("expected certificate error")
+	}
+
+	m := transport.NewManager()
+	m.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	resp, err := m.Client(0).Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if b, _ := ioutil.ReadAll(resp.Body); string(b) != "true" {
+		t.Fatalf("unexpected body: %s", b)
+	}
+}
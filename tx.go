@@ -5,6 +5,7 @@ import (
 	"math"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -21,6 +22,8 @@ type tx struct {
 
 	itrs []*shardIterator // shard iterators
 
+	scanned *uint64 // points read from shards, optional
+
 	// used by DecodeFields and FieldIDs. Only used in a raw query, which won't let you select from more than one measurement
 	measurement *Measurement
 	decoder     fieldDecoder
@@ -67,11 +70,14 @@ func (tx *tx) close() error {
 	// Mark transaction as closed.
 	tx.opened = false
 
+	var err error
 	for _, itr := range tx.itrs {
-		_ = itr.close()
+		if e := itr.close(); e != nil && err == nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
 
 // CreateIterators returns an iterator for a simple select statement.
@@ -134,7 +140,16 @@ func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterat
 	if f == nil {
 		return nil, fmt.Errorf("field not found: %s", fieldName)
 	}
-	tagSets := m.tagSets(stmt, dimensions)
+
+	// Fail queries with too many groups unless they are truncated below.
+	maxGroups := tx.server.MaxGroups
+	if tx.server.TruncateGroups {
+		maxGroups = 0
+	}
+	tagSets, err := m.tagSets(stmt, dimensions, maxGroups)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get a field decoder.
 	d := NewFieldCodec(m)
@@ -154,12 +169,8 @@ func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterat
 			return nil, nil
 		}
 
-		limitSets := make(map[string]map[uint32]influxql.Expr)
-		orderedSets := make([]string, 0, len(tagSets))
-		for k, _ := range tagSets {
-			orderedSets = append(orderedSets, k)
-		}
-		sort.Strings(orderedSets)
+		limitSets := make(map[string]*tagSet)
+		orderedSets := sortedTagSetKeys(tagSets)
 
 		if stmt.Offset+stmt.Limit > len(orderedSets) {
 			stmt.Limit = len(orderedSets) - stmt.Offset
@@ -172,17 +183,25 @@ func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterat
 		tagSets = limitSets
 	}
 
-	// Create an iterator for every shard.
+	// Keep the first groups, in tag value order, of queries with too many groups.
+	keys := sortedTagSetKeys(tagSets)
+	if max := tx.server.MaxGroups; tx.server.TruncateGroups && max > 0 && len(keys) > max {
+		tx.server.Logger.Printf("query truncated from %d to %d groups: %s", len(keys), max, stmt)
+		keys = keys[:max]
+	}
+
+	// Create an iterator for every shard, visiting groups in tag value order.
 	var itrs []influxql.Iterator
-	for tag, set := range tagSets {
+	for _, tag := range keys {
+		set := tagSets[tag]
 		for _, group := range shardGroups {
 			// TODO: only create iterators for the shards we actually have to hit in a group
 			for _, sh := range group.Shards {
 
 				// create a series cursor for each unique series id
-				cursors := make([]*seriesCursor, 0, len(set))
-				for id, cond := range set {
-					c := &seriesCursor{id: id, condition: cond, decoder: d, rawQuery: stmt.RawQuery}
+				cursors := make([]*seriesCursor, 0, len(set.ids))
+				for i, id := range set.ids {
+					c := &seriesCursor{id: id, condition: set.filters[i], decoder: d, rawQuery: stmt.RawQuery, scanned: tx.scanned}
 					if stmt.RawQuery {
 						c.fieldIDs = fieldIDs
 						c.fieldNames = fieldNames
@@ -197,7 +216,7 @@ func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterat
 					fieldName:   f.Name,
 					fieldID:     f.ID,
 					tags:        tag,
-					db:          sh.store,
+					shard:       sh,
 					cursors:     cursors,
 					tmin:        tmin.UnixNano(),
 					tmax:        tmax.UnixNano(),
@@ -214,6 +233,16 @@ func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterat
 	return itrs, nil
 }
 
+// sortedTagSetKeys returns the encoded tag values of the tag sets, sorted.
+func sortedTagSetKeys(tagSets map[string]*tagSet) []string {
+	keys := make([]string, 0, len(tagSets))
+	for k := range tagSets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // DecodeValues is for use in a raw data query
 func (tx *tx) DecodeValues(fieldIDs []uint8, timestamp int64, data []byte) []interface{} {
 	vals := make([]interface{}, len(fieldIDs)+1)
@@ -270,14 +299,14 @@ type shardIterator struct {
 	tags        string // encoded dimensional tag values
 	cursors     []*seriesCursor
 	keyValues   []keyValue
-	db          *bolt.DB // data stores by shard id
+	shard       *Shard   // shard holding the data store
 	txn         *bolt.Tx // read transactions by shard id
 	tmin, tmax  int64
 }
 
 func (i *shardIterator) open() error {
 	// Open the data store
-	txn, err := i.db.Begin(false)
+	txn, err := i.shard.begin()
 	if err != nil {
 		return err
 	}
@@ -291,6 +320,7 @@ func (i *shardIterator) open() error {
 		}
 
 		c.cur = b.Cursor()
+		c.codec = i.shard.pointCodec(i.txn)
 	}
 
 	i.keyValues = make([]keyValue, len(i.cursors))
@@ -301,8 +331,15 @@ func (i *shardIterator) open() error {
 	return nil
 }
 
+// close ends the read transaction. Returns the first error encountered
+// reading points.
 func (i *shardIterator) close() error {
 	_ = i.txn.Rollback()
+	for _, c := range i.cursors {
+		if c.err != nil {
+			return c.err
+		}
+	}
 	return nil
 }
 
@@ -354,6 +391,11 @@ type seriesCursor struct {
 	rawQuery   bool
 	fieldIDs   []uint8
 	fieldNames []string
+
+	scanned *uint64 // incremented for each point read, optional
+
+	codec *pointCodec // decodes the points of the shard's store
+	err   error       // set if a point could not be read
 }
 
 func (c *seriesCursor) Next(fieldName string, fieldID uint8, tmin, tmax int64) (key int64, data []byte, value interface{}) {
@@ -367,6 +409,8 @@ func (c *seriesCursor) Next(fieldName string, fieldID uint8, tmin, tmax int64) (
 	for {
 		var k, v []byte
 		if !c.initialized {
+			// Keys are big-endian timestamps so the seek is a binary search
+			// down the bucket's B+tree to the first point in the time range.
 			k, v = c.cur.Seek(u64tob(uint64(tmin)))
 			c.initialized = true
 		} else {
@@ -384,6 +428,19 @@ func (c *seriesCursor) Next(fieldName string, fieldID uint8, tmin, tmax int64) (
 		if key > tmax {
 			return 0, nil, nil
 		}
+		if c.scanned != nil {
+			atomic.AddUint64(c.scanned, 1)
+		}
+
+		// Verify the point's checksum. The cursor ends if the read fails.
+		data, ok, err := c.codec.decode(c.id, k, v)
+		if err != nil {
+			c.err = err
+			return 0, nil, nil
+		} else if !ok {
+			continue
+		}
+		v = data
 
 		// if it's a raw query we handle things differently
 		if c.rawQuery {
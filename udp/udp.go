@@ -61,7 +61,15 @@ func (u *UDPServer) ListenAndServe(iface string) error {
 				continue
 			}
 
-			points, err := influxdb.NormalizeBatchPoints(bp)
+			// Normalize measurement names if the writer has rules for them.
+			var rules *influxdb.NameRules
+			if w, ok := u.writer.(interface {
+				NameRules(database string) (*influxdb.NameRules, error)
+			}); ok {
+				rules, _ = w.NameRules(bp.Database)
+			}
+
+			points, err := influxdb.NormalizeBatchPoints(bp, influxdb.LenientParse, rules)
 			if err != nil {
 				log.Printf("Failed normalize batch points")
 				continue
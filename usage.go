@@ -0,0 +1,102 @@
+package influxdb
+
+import (
+	"sort"
+	"sync"
+)
+
+// Usage is the resource usage metered for a user against a database.
+// Anonymous usage has an empty user name.
+type Usage struct {
+	Database      string
+	User          string
+	BytesWritten  uint64 // encoded size of the points written
+	PointsWritten uint64
+	Queries       uint64
+	PointsScanned uint64 // points read from shards by select statements
+}
+
+// UsageMeter accumulates usage per database and user until it is reset.
+type UsageMeter struct {
+	mu    sync.Mutex
+	usage map[usageKey]*Usage
+}
+
+// usageKey identifies the database and user usage is metered for.
+type usageKey struct {
+	database string
+	user     string
+}
+
+// NewUsageMeter returns a new instance of UsageMeter.
+func NewUsageMeter() *UsageMeter {
+	return &UsageMeter{usage: make(map[usageKey]*Usage)}
+}
+
+// Usage returns the metered usage sorted by database and user.
+func (m *UsageMeter) Usage() []Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := make([]Usage, 0, len(m.usage))
+	for _, u := range m.usage {
+		a = append(a, *u)
+	}
+	sort.Sort(usages(a))
+	return a
+}
+
+// Reset clears all metered usage.
+func (m *UsageMeter) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage = make(map[usageKey]*Usage)
+}
+
+// usageOf returns the usage for a database and user, creating it if necessary.
+// The caller must hold the lock.
+func (m *UsageMeter) usageOf(database string, user *User) *Usage {
+	k := usageKey{database: database}
+	if user != nil {
+		k.user = user.Name
+	}
+	u := m.usage[k]
+	if u == nil {
+		u = &Usage{Database: k.database, User: k.user}
+		m.usage[k] = u
+	}
+	return u
+}
+
+// recordWrite meters points written by a user.
+func (m *UsageMeter) recordWrite(database string, user *User, points, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.usageOf(database, user)
+	u.PointsWritten += uint64(points)
+	u.BytesWritten += uint64(bytes)
+}
+
+// recordQuery meters a query executed by a user.
+func (m *UsageMeter) recordQuery(database string, user *User) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usageOf(database, user).Queries++
+}
+
+// recordScan meters points scanned by a user's select statement.
+func (m *UsageMeter) recordScan(database string, user *User, n uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usageOf(database, user).PointsScanned += n
+}
+
+type usages []Usage
+
+func (a usages) Len() int { return len(a) }
+func (a usages) Less(i, j int) bool {
+	if a[i].Database != a[j].Database {
+		return a[i].Database < a[j].Database
+	}
+	return a[i].User < a[j].User
+}
+func (a usages) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
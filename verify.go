@@ -0,0 +1,321 @@
+package influxdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// VerifyReport describes the state of a data directory checked by Verify.
+type VerifyReport struct {
+	Shards   int      // shard stores checked
+	Series   int      // series found in shard stores
+	Points   int      // points decoded
+	Problems []string // problems found
+	Repairs  []string // repairs made to the index
+}
+
+// OK returns true if no problems were found.
+func (r *VerifyReport) OK() bool { return len(r.Problems) == 0 }
+
+func (r *VerifyReport) problemf(format string, a ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, a...))
+}
+
+func (r *VerifyReport) repairf(format string, a ...interface{}) {
+	r.Repairs = append(r.Repairs, fmt.Sprintf(format, a...))
+}
+
+// verifyIndex holds the series index of a database as read by Verify.
+type verifyIndex struct {
+	name         string
+	measurements map[string]*Measurement // by name
+	series       map[uint32]string       // measurement names by series id
+	maxID        uint32                  // highest series id in the index or shard data
+	sequence     uint64                  // series id sequence of the index
+	corrupt      [][2]string             // measurement and key of entries that can't be decoded
+}
+
+// Verify checks the data directory of a server that is not running. The pages
+// of the metastore and of every local shard store are checked, the series
+// index of each database is decoded and every point in the shard stores is
+// checked against its checksum and decoded against the index.
+//
+// If repair is set, the series index is rebuilt from what can be recovered:
+// entries that can't be decoded, and would stop the server from opening, are
+// removed and the series id sequence is advanced past every series id found
+// in the shard stores so new series never reuse the id of existing data.
+// Shard stores are never modified.
+func Verify(path string, repair bool) (*VerifyReport, error) {
+	r := &VerifyReport{}
+
+	meta, err := bolt.Open(filepath.Join(path, "meta"), 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: !repair})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("meta: data directory is in use")
+	} else if err != nil {
+		return nil, fmt.Errorf("meta: %s", err)
+	}
+	defer func() { _ = meta.Close() }()
+
+	// Check the metastore and read the index and shard locations.
+	var nodeID uint64
+	indexes := make(map[string]*verifyIndex)
+	shards := make(map[uint64]string) // database names by shard id
+	if err := meta.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			r.problemf("meta: %s", err)
+		}
+		if v := tx.Bucket([]byte("Meta")).Get([]byte("id")); v != nil {
+			nodeID = btou64(v)
+		}
+
+		return tx.Bucket([]byte("Databases")).ForEach(func(k, _ []byte) error {
+			b := tx.Bucket([]byte("Databases")).Bucket(k)
+			db := newDatabase()
+			if err := json.Unmarshal(b.Get([]byte("meta")), &db); err != nil {
+				r.problemf("database %s: invalid metadata: %s", k, err)
+				return nil
+			}
+			for _, rp := range db.policies {
+				for _, g := range rp.shardGroups {
+					for _, sh := range g.Shards {
+						if sh.HasDataNodeID(nodeID) {
+							shards[sh.ID] = db.name
+						}
+					}
+				}
+			}
+			indexes[db.name] = readVerifyIndex(r, db.name, b)
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("meta: %s", err)
+	}
+
+	// Check every shard store stored on this server.
+	ids := make([]uint64, 0, len(shards))
+	for id := range shards {
+		ids = append(ids, id)
+	}
+	sort.Sort(uint64Slice(ids))
+	for _, id := range ids {
+		p := filepath.Join(path, "shards", strconv.FormatUint(id, 10))
+		if _, err := os.Stat(p + ".cold"); err == nil {
+			continue
+		} else if _, err := os.Stat(p); os.IsNotExist(err) {
+			r.problemf("shard %d: store missing", id)
+			continue
+		}
+		if err := verifyShard(r, id, p, indexes[shards[id]]); err != nil {
+			r.problemf("shard %d: %s", id, err)
+		}
+	}
+
+	// Report stores that don't belong to any shard.
+	fis, err := ioutil.ReadDir(filepath.Join(path, "shards"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("shards: %s", err)
+	}
+	for _, fi := range fis {
+		if id, err := strconv.ParseUint(fi.Name(), 10, 64); err == nil {
+			if _, ok := shards[id]; !ok {
+				r.problemf("shard %d: store is not assigned to this server", id)
+			}
+		}
+	}
+
+	// Report and optionally repair problems with the index itself.
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		idx := indexes[name]
+		if uint64(idx.maxID) > idx.sequence {
+			r.problemf("database %s: series id sequence %d is behind series id %d", name, idx.sequence, idx.maxID)
+		}
+		if repair {
+			if err := meta.Update(func(tx *bolt.Tx) error { return idx.repair(r, tx) }); err != nil {
+				return nil, fmt.Errorf("repair %s: %s", name, err)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// readVerifyIndex decodes the series index of a database from its metastore
+// bucket, reporting any entries that can't be decoded or reuse a series id.
+func readVerifyIndex(r *VerifyReport, name string, b *bolt.Bucket) *verifyIndex {
+	idx := &verifyIndex{
+		name:         name,
+		measurements: make(map[string]*Measurement),
+		series:       make(map[uint32]string),
+	}
+
+	_ = b.Bucket([]byte("Measurements")).ForEach(func(k, v []byte) error {
+		m := &Measurement{}
+		if err := json.Unmarshal(v, m); err != nil {
+			r.problemf("database %s: measurement %s: invalid metadata: %s", name, k, err)
+			return nil
+		}
+		idx.measurements[string(k)] = m
+		return nil
+	})
+
+	t := b.Bucket([]byte("Series"))
+	idx.sequence = t.Sequence()
+	_ = t.ForEach(func(k, _ []byte) error {
+		mb := t.Bucket(k)
+		if mb == nil {
+			return nil
+		}
+		return mb.ForEach(func(key, v []byte) error {
+			var s *Series
+			if err := json.Unmarshal(v, &s); err != nil || s == nil || len(key) != 4 || btou32(key) != s.ID {
+				r.problemf("database %s: measurement %s: invalid series %x", name, k, key)
+				idx.corrupt = append(idx.corrupt, [2]string{string(k), string(key)})
+				return nil
+			}
+			if other, ok := idx.series[s.ID]; ok {
+				r.problemf("database %s: series %d is in measurements %s and %s", name, s.ID, other, k)
+				idx.corrupt = append(idx.corrupt, [2]string{string(k), string(key)})
+				return nil
+			}
+			idx.series[s.ID] = string(k)
+			if s.ID > idx.maxID {
+				idx.maxID = s.ID
+			}
+			return nil
+		})
+	})
+	return idx
+}
+
+// repair removes the index entries that couldn't be decoded and advances the
+// series id sequence past the highest series id seen.
+func (idx *verifyIndex) repair(r *VerifyReport, tx *bolt.Tx) error {
+	t := tx.Bucket([]byte("Databases")).Bucket([]byte(idx.name)).Bucket([]byte("Series"))
+	for _, e := range idx.corrupt {
+		if err := t.Bucket([]byte(e[0])).Delete([]byte(e[1])); err != nil {
+			return err
+		}
+		r.repairf("database %s: measurement %s: removed series %x", idx.name, e[0], e[1])
+	}
+	if uint64(idx.maxID) > idx.sequence {
+		if err := t.SetSequence(uint64(idx.maxID)); err != nil {
+			return err
+		}
+		r.repairf("database %s: advanced series id sequence to %d", idx.name, idx.maxID)
+	}
+	return nil
+}
+
+// verifyShard checks the pages of a shard store and decodes each of its
+// points, after checking its checksum, using the fields of the measurement
+// its series belongs to.
+func verifyShard(r *VerifyReport, id uint64, path string, idx *verifyIndex) error {
+	store, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err == bolt.ErrTimeout {
+		return fmt.Errorf("store is in use")
+	} else if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+	r.Shards++
+
+	return store.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			r.problemf("shard %d: %s", id, err)
+		}
+		checksums := tx.Bucket(checksumsBucket) != nil
+
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			// Series buckets are keyed by their 4-byte series id.
+			if len(name) != 4 {
+				return nil
+			}
+			seriesID := btou32(name)
+			r.Series++
+			if seriesID > idx.maxID {
+				idx.maxID = seriesID
+			}
+
+			mname, ok := idx.series[seriesID]
+			if !ok {
+				r.problemf("shard %d: series %d is not in the index", id, seriesID)
+				return nil
+			}
+			m := idx.measurements[mname]
+			if m == nil {
+				r.problemf("shard %d: series %d: measurement %s has no fields", id, seriesID, mname)
+				return nil
+			}
+			codec := NewFieldCodec(m)
+
+			return b.ForEach(func(k, v []byte) error {
+				if len(k) != 8 {
+					r.problemf("shard %d: series %d: invalid timestamp %x", id, seriesID, k)
+					return nil
+				}
+				if checksums {
+					var err error
+					if v, err = splitChecksum(k, v); err != nil {
+						r.problemf("shard %d: series %d: point %d: %s", id, seriesID, int64(btou64(k)), err)
+						return nil
+					}
+				}
+				if err := codec.validateFields(v); err != nil {
+					r.problemf("shard %d: series %d: point %d: %s", id, seriesID, int64(btou64(k)), err)
+				} else {
+					r.Points++
+				}
+				return nil
+			})
+		})
+	})
+}
+
+// validateFields returns an error if b is not a valid encoding of fields.
+func (f *FieldCodec) validateFields(b []byte) error {
+	for len(b) > 0 {
+		field := f.fieldsByID[b[0]]
+		if field == nil {
+			return fmt.Errorf("unknown field id %d", b[0])
+		}
+
+		var n int
+		switch field.Type {
+		case influxql.Number, influxql.Unsigned:
+			n = 9
+		case influxql.Boolean:
+			n = 2
+		case influxql.String, influxql.Histogram:
+			if len(b) < 3 {
+				return fmt.Errorf("field %s: truncated", field.Name)
+			}
+			size := int(binary.BigEndian.Uint16(b[1:3]))
+			if field.Type == influxql.Histogram {
+				size *= 16
+			}
+			n = 3 + size
+		default:
+			return fmt.Errorf("field %s: unsupported type: %s", field.Name, field.Type)
+		}
+		if len(b) < n {
+			return fmt.Errorf("field %s: truncated", field.Name)
+		}
+		b = b[n:]
+	}
+	return nil
+}
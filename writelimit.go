@@ -0,0 +1,80 @@
+package influxdb
+
+import (
+	"sync"
+	"time"
+)
+
+// writeLimiter throttles the points written to each database with a token
+// bucket. Buckets fill at the database's write limit in points per second up
+// to its burst, and each point written takes a token.
+type writeLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*writeBucket
+
+	// Returns the current time. Defaults to time.Now().
+	Now func() time.Time
+}
+
+// writeBucket holds the tokens available to a database.
+type writeBucket struct {
+	limit  int       // points per second
+	burst  int       // maximum tokens
+	tokens float64   // tokens available, negative after a batch larger than the burst
+	last   time.Time // time tokens were last added
+}
+
+// newWriteLimiter returns a new instance of writeLimiter.
+func newWriteLimiter() *writeLimiter {
+	return &writeLimiter{
+		buckets: make(map[string]*writeBucket),
+		Now:     time.Now,
+	}
+}
+
+// reserve takes n tokens from the database's bucket. The bucket starts full
+// and is reset when the limit or burst changes. A burst of zero is the same
+// as the limit. A batch larger than the burst is let through when the bucket
+// is full and the tokens it overdraws are paid back before the next write.
+// Returns ErrWriteThrottled if there are not enough tokens.
+func (l *writeLimiter) reserve(database string, limit, burst, n int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit <= 0 {
+		delete(l.buckets, database)
+		return nil
+	}
+	if burst <= 0 {
+		burst = limit
+	}
+
+	now := l.Now()
+	b := l.buckets[database]
+	if b == nil || b.limit != limit || b.burst != burst {
+		b = &writeBucket{limit: limit, burst: burst, tokens: float64(burst), last: now}
+		l.buckets[database] = b
+	}
+
+	// Add the tokens accumulated since the last write.
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * float64(limit)
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		b.last = now
+	}
+
+	if float64(n) > b.tokens && b.tokens < float64(burst) {
+		return ErrWriteThrottled
+	}
+	b.tokens -= float64(n)
+	return nil
+}
+
+// remove forgets the bucket of a database.
+func (l *writeLimiter) remove(database string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, database)
+}
@@ -0,0 +1,164 @@
+package influxdb
+
+import (
+	"sort"
+	"sync"
+)
+
+// Reasons points are dropped by the write path.
+const (
+	DropReasonInvalid           = "invalid"             // malformed or missing fields
+	DropReasonNonFinite         = "non_finite"          // NaN or infinite field values
+	DropReasonReadOnly          = "read_only"           // server not accepting writes
+	DropReasonQuota             = "quota"               // over a series, disk or write rate quota
+	DropReasonRetentionPolicy   = "retention_policy"    // retention policy not found
+	DropReasonFieldTypeConflict = "field_type_conflict" // field written with another type
+	DropReasonOther             = "other"
+)
+
+// WriteStat holds the write counters for a database, or for a measurement in
+// a database. Database totals have a blank measurement.
+type WriteStat struct {
+	Database      string
+	Measurement   string
+	PointsWritten uint64
+	BytesReceived uint64            // request bytes, only counted for databases
+	PointsDropped map[string]uint64 // by drop reason
+}
+
+// WriteStats counts points written, points dropped and bytes received per
+// database, and per measurement if ByMeasurement is set, so ingest load can be
+// attributed to its source. Counters are kept from when the server starts.
+type WriteStats struct {
+	mu    sync.Mutex
+	stats map[writeStatKey]*WriteStat
+
+	// Also count points per measurement.
+	ByMeasurement bool
+}
+
+// writeStatKey identifies the database and measurement counters are kept for.
+type writeStatKey struct {
+	database    string
+	measurement string
+}
+
+// NewWriteStats returns a new instance of WriteStats.
+func NewWriteStats() *WriteStats {
+	return &WriteStats{stats: make(map[writeStatKey]*WriteStat)}
+}
+
+// Stats returns a copy of the counters sorted by database and measurement.
+func (w *WriteStats) Stats() []WriteStat {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a := make([]WriteStat, 0, len(w.stats))
+	for _, st := range w.stats {
+		other := *st
+		other.PointsDropped = make(map[string]uint64, len(st.PointsDropped))
+		for reason, n := range st.PointsDropped {
+			other.PointsDropped[reason] = n
+		}
+		a = append(a, other)
+	}
+	sort.Sort(writeStats(a))
+	return a
+}
+
+// Reset clears all counters.
+func (w *WriteStats) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats = make(map[writeStatKey]*WriteStat)
+}
+
+// RecordBytesReceived counts the bytes of a write request to a database.
+func (w *WriteStats) RecordBytesReceived(database string, n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.statOf(database, "").BytesReceived += uint64(n)
+}
+
+// RecordDropped counts n points dropped for a reason. The points are only
+// counted against the database if measurement is blank.
+func (w *WriteStats) RecordDropped(database, measurement, reason string, n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.statOf(database, "").PointsDropped[reason] += uint64(n)
+	if w.ByMeasurement && measurement != "" {
+		w.statOf(database, measurement).PointsDropped[reason] += uint64(n)
+	}
+}
+
+// recordWritten counts points written to a database.
+func (w *WriteStats) recordWritten(database string, points []Point) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.statOf(database, "").PointsWritten += uint64(len(points))
+	if w.ByMeasurement {
+		for _, p := range points {
+			w.statOf(database, p.Name).PointsWritten++
+		}
+	}
+}
+
+// recordRejected counts the points of a write that failed with err.
+func (w *WriteStats) recordRejected(database string, points []Point, err error) {
+	reason := WriteDropReason(err)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.statOf(database, "").PointsDropped[reason] += uint64(len(points))
+	if w.ByMeasurement {
+		for _, p := range points {
+			w.statOf(database, p.Name).PointsDropped[reason]++
+		}
+	}
+}
+
+// statOf returns the counters for a database and measurement, creating them
+// if necessary. The caller must hold the lock.
+func (w *WriteStats) statOf(database, measurement string) *WriteStat {
+	if w.stats == nil {
+		w.stats = make(map[writeStatKey]*WriteStat)
+	}
+	k := writeStatKey{database: database, measurement: measurement}
+	st := w.stats[k]
+	if st == nil {
+		st = &WriteStat{Database: database, Measurement: measurement, PointsDropped: make(map[string]uint64)}
+		w.stats[k] = st
+	}
+	return st
+}
+
+// WriteDropReason returns the reason points are dropped when a write fails
+// with err.
+func WriteDropReason(err error) string {
+	switch err {
+	case ErrFieldsRequired, ErrMeasurementNameRequired:
+		return DropReasonInvalid
+	case ErrNonFiniteFieldValue:
+		return DropReasonNonFinite
+	case ErrReadOnly:
+		return DropReasonReadOnly
+	case ErrSeriesQuotaExceeded, ErrDiskQuotaExceeded, ErrWriteRateQuotaExceeded, ErrWriteThrottled:
+		return DropReasonQuota
+	case ErrRetentionPolicyNotFound, ErrDefaultRetentionPolicyNotFound:
+		return DropReasonRetentionPolicy
+	case ErrFieldTypeConflict:
+		return DropReasonFieldTypeConflict
+	default:
+		return DropReasonOther
+	}
+}
+
+type writeStats []WriteStat
+
+func (a writeStats) Len() int { return len(a) }
+func (a writeStats) Less(i, j int) bool {
+	if a[i].Database != a[j].Database {
+		return a[i].Database < a[j].Database
+	}
+	return a[i].Measurement < a[j].Measurement
+}
+func (a writeStats) Swap(i, j int) { a[i], a[j] = a[j], a[i] }